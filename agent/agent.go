@@ -2,20 +2,24 @@ package nexagent
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/cloudevents/sdk-go/pkg/cloudevents"
 	"github.com/nats-io/nats.go"
 	"github.com/synadia-io/nex/agent/providers"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	"github.com/synadia-io/nex/internal/schema"
 )
 
 const defaultAgentHandshakeTimeoutMillis = 250
@@ -23,34 +27,62 @@ const runloopSleepInterval = 250 * time.Millisecond
 const runloopTickInterval = 2500 * time.Millisecond
 const workloadExecutionSleepTimeoutMillis = 1000
 
+// Streamed artifacts are read in chunks of this size, and their progress is published each time
+// this many more bytes have been written
+const artifactStreamChunkSize = 256 * 1024
+const artifactProgressIntervalBytes = 16 * 1024 * 1024
+
 // Agent facilitates communication between the nex agent running in the firecracker VM
 // and the nex node by way of a configured internal NATS server. Agent instances provide
 // logging and event emission facilities, and deployment and execution of workloads
 type Agent struct {
 	agentLogs chan *agentapi.LogEntry
 	eventLogs chan *cloudevents.Event
+	// the workload's output, queued apart from the agent's own logs so that a chatty workload
+	// can't hold them up
+	workloadLogs chan *agentapi.LogEntry
 
 	cancelF context.CancelFunc
 	closing uint32
 	ctx     context.Context
 	sigs    chan os.Signal
 
-	provider providers.ExecutionProvider
+	deployRequest *agentapi.DeployRequest
+	provider      providers.ExecutionProvider
+
+	// cancels the workload's health checks, if any
+	healthCancel context.CancelFunc
+
+	// stops reporting the workload's memory use and watching its soft memory limit, if any
+	memoryCancel context.CancelFunc
+	// processes killed for running out of memory since boot, as of the last check
+	oomKills uint64
 
-	cacheBucket nats.ObjectStore
-	md          *agentapi.MachineMetadata
-	nc          *nats.Conn
-	started     time.Time
+	hostServices *hostServicesProxy
+
+	md      *agentapi.MachineMetadata
+	nc      *nats.Conn
+	started time.Time
 }
 
-// HaltVM stops the firecracker VM
+// HaltVM stops the firecracker VM; when the agent is running as a local
+// process (i.e., without a sandbox) the process simply exits instead
 func HaltVM(err error) {
+	if !isSandboxed() {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Terminating agent process due to fatal error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
 	if err != nil {
 		// On the off chance the agent's log is captured from the vm
 		fmt.Fprintf(os.Stderr, "Terminating Firecracker VM due to fatal error: %s\n", err)
 	}
 
-	err = syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART)
+	err = reboot()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to reboot: %s", err)
 	}
@@ -58,43 +90,48 @@ func HaltVM(err error) {
 
 // Initialize a new agent to facilitate communications with the host
 func NewAgent(ctx context.Context, cancelF context.CancelFunc) (*Agent, error) {
-	metadata, err := GetMachineMetadata()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get mmds data: %s", err)
-		return nil, err
+	var metadata *agentapi.MachineMetadata
+	var err error
+
+	if isSandboxed() {
+		metadata, err = GetMachineMetadata()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get mmds data: %s", err)
+			return nil, err
+		}
+	} else {
+		metadata, err = GetMachineMetadataFromEnv()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read metadata from environment: %s", err)
+			return nil, err
+		}
 	}
 
 	if !metadata.Validate() {
 		return nil, fmt.Errorf("invalid metadata retrieved from mmds; %v", metadata.Errors)
 	}
 
-	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", *metadata.NodeNatsHost, *metadata.NodeNatsPort))
+	opts, err := internalNatsOptions(metadata)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to connect to shared NATS: %s", err)
-		return nil, err
-	}
-
-	js, err := nc.JetStream()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get JetStream context from shared NATS: %s", err)
+		fmt.Fprintf(os.Stderr, "failed to load internal NATS credentials: %s", err)
 		return nil, err
 	}
 
-	bucket, err := js.ObjectStore(agentapi.WorkloadCacheBucket)
+	nc, err := nats.Connect(fmt.Sprintf("nats://%s:%d", *metadata.NodeNatsHost, *metadata.NodeNatsPort), opts...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get reference to shared object store: %s", err)
+		fmt.Fprintf(os.Stderr, "failed to connect to shared NATS: %s", err)
 		return nil, err
 	}
 
 	return &Agent{
-		agentLogs:   make(chan *agentapi.LogEntry, 64),
-		eventLogs:   make(chan *cloudevents.Event, 64),
-		cancelF:     cancelF,
-		ctx:         ctx,
-		cacheBucket: bucket,
-		md:          metadata,
-		nc:          nc,
-		started:     time.Now().UTC(),
+		agentLogs:    make(chan *agentapi.LogEntry, 64),
+		eventLogs:    make(chan *cloudevents.Event, 64),
+		workloadLogs: make(chan *agentapi.LogEntry, 256),
+		cancelF:      cancelF,
+		ctx:          ctx,
+		md:           metadata,
+		nc:           nc,
+		started:      time.Now().UTC(),
 	}, nil
 }
 
@@ -137,9 +174,11 @@ func (a *Agent) Start() {
 // Request a handshake with the host indicating the agent is "all the way" up
 // NOTE: the agent process will request a VM shutdown if this fails
 func (a *Agent) requestHandshake() error {
+	sentAt := time.Now().UTC()
 	msg := agentapi.HandshakeRequest{
 		MachineID: a.md.VmID,
 		StartTime: a.started,
+		SentAt:    &sentAt,
 		Message:   a.md.Message,
 	}
 	raw, _ := json.Marshal(msg)
@@ -151,7 +190,7 @@ func (a *Agent) requestHandshake() error {
 	}
 
 	var handshakeResponse *agentapi.HandshakeResponse
-	err = json.Unmarshal(resp.Data, &handshakeResponse)
+	err = schema.Unmarshal(resp.Data, &handshakeResponse)
 	if err != nil {
 		a.LogError(fmt.Sprintf("Failed to parse handshake response: %s", err))
 		return err
@@ -170,12 +209,27 @@ func (a *Agent) Version() string {
 // temporary file and make it executable; this method returns the full
 // path to the cached artifact if successful
 func (a *Agent) cacheExecutableArtifact(req *agentapi.DeployRequest) (*string, error) {
-	tempFile := path.Join(os.TempDir(), "workload") // FIXME-- randomly generate a filename
+	// agents running as local processes share a temp dir, so qualify the filename with the vm id
+	tempFile := path.Join(os.TempDir(), fmt.Sprintf("workload-%s", *a.md.VmID))
+
+	var err error
+	if req.StreamArtifact {
+		// the digest is verified as the artifact is written
+		err = a.streamExecutableArtifact(req, tempFile)
+	} else {
+		err = a.writeWorkloadArtifact(tempFile)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to write workload artifact to temp dir: %s", err)
+			a.LogError(msg)
+			return nil, errors.New(msg)
+		}
 
-	err := a.cacheBucket.GetFile(*req.WorkloadName, tempFile)
+		err = verifyArtifactDigest(tempFile, req.Hash)
+	}
 	if err != nil {
-		msg := fmt.Sprintf("Failed to write workload artifact to temp dir: %s", err)
+		msg := fmt.Sprintf("Failed to verify workload artifact: %s", err)
 		a.LogError(msg)
+		_ = os.Remove(tempFile)
 		return nil, errors.New(msg)
 	}
 
@@ -189,6 +243,118 @@ func (a *Agent) cacheExecutableArtifact(req *agentapi.DeployRequest) (*string, e
 	return &tempFile, nil
 }
 
+// Writes the workload artifact from the cache bucket to the given path chunk by chunk, hashing it
+// as it's written and publishing progress events along the way, then verifies its digest
+func (a *Agent) streamExecutableArtifact(req *agentapi.DeployRequest, path string) error {
+	obj, err := a.openWorkloadArtifact()
+	if err != nil {
+		return fmt.Errorf("failed to open workload artifact: %s", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	buf := make([]byte, artifactStreamChunkSize)
+
+	var received, reported int64
+	for {
+		n, err := obj.Read(buf)
+		if n > 0 {
+			_, werr := w.Write(buf[:n])
+			if werr != nil {
+				return fmt.Errorf("failed to write workload artifact: %s", werr)
+			}
+			received += int64(n)
+		}
+
+		if received-reported >= artifactProgressIntervalBytes || (errors.Is(err, io.EOF) && received > reported) {
+			reported = received
+			a.PublishArtifactProgress(*a.md.VmID, agentapi.ArtifactProgressEvent{
+				WorkloadName:  *req.WorkloadName,
+				ReceivedBytes: received,
+				TotalBytes:    obj.size,
+			})
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read workload artifact: %s", err)
+		}
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, req.Hash) {
+		return fmt.Errorf("digest mismatch; expected %s, got %s", req.Hash, actual)
+	}
+
+	return nil
+}
+
+// Writes the NATS credentials minted for the workload by the node to a file readable only
+// by the workload's user, returning the path to the file
+func (a *Agent) writeWorkloadCredentials(creds *agentapi.Credentials) (string, error) {
+	path := a.workloadCredentialsPath()
+
+	err := os.WriteFile(path, []byte(creds.Creds), 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write workload credentials: %s", err)
+	}
+
+	return path, nil
+}
+
+func (a *Agent) workloadCredentialsPath() string {
+	return path.Join(os.TempDir(), fmt.Sprintf("workload-%s.creds", *a.md.VmID))
+}
+
+// Ensures the workload's volume, attached by the node as the machine's second drive, was mounted
+// by the machine's init system
+func verifyWorkloadVolume() error {
+	mounts, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %s", err)
+	}
+
+	for _, line := range strings.Split(string(mounts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == agentapi.WorkloadVolumeMountPath {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("workload volume is not mounted at %s", agentapi.WorkloadVolumeMountPath)
+}
+
+// Ensures the SHA-256 digest of the file at the given path matches the expected,
+// hex-encoded digest. This guards against a corrupted or tampered cache entry
+func verifyArtifactDigest(path string, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("digest mismatch; expected %s, got %s", expected, actual)
+	}
+
+	return nil
+}
+
 // Run inside a goroutine to pull event entries and publish them to the node host.
 func (a *Agent) dispatchEvents() {
 	for !a.shuttingDown() {
@@ -210,23 +376,32 @@ func (a *Agent) dispatchEvents() {
 	}
 }
 
-// This is run inside a goroutine to pull log entries off the channel and publish to the
-// node host via internal NATS
+// This is run inside a goroutine to pull log entries off the channels and publish to the
+// node host via internal NATS. The connection is only flushed once both channels are drained,
+// so that a burst of workload output isn't published one round trip at a time
 func (a *Agent) dispatchLogs() {
+	subject := fmt.Sprintf("agentint.%s.logs", *a.md.VmID)
+
 	for !a.shuttingDown() {
-		entry := <-a.agentLogs
+		var entry *agentapi.LogEntry
+		select {
+		case entry = <-a.agentLogs:
+		case entry = <-a.workloadLogs:
+		}
+
 		bytes, err := json.Marshal(entry)
 		if err != nil {
 			continue
 		}
 
-		subject := fmt.Sprintf("agentint.%s.logs", *a.md.VmID)
 		err = a.nc.Publish(subject, bytes)
 		if err != nil {
 			continue
 		}
 
-		a.nc.Flush()
+		if len(a.agentLogs) == 0 && len(a.workloadLogs) == 0 {
+			a.nc.Flush()
+		}
 	}
 }
 
@@ -235,7 +410,7 @@ func (a *Agent) dispatchLogs() {
 // request, and then validate and deploy a workload
 func (a *Agent) handleDeploy(m *nats.Msg) {
 	var request agentapi.DeployRequest
-	err := json.Unmarshal(m.Data, &request)
+	err := schema.Unmarshal(m.Data, &request)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to unmarshal deploy request: %s", err)
 		a.LogError(msg)
@@ -248,15 +423,53 @@ func (a *Agent) handleDeploy(m *nats.Msg) {
 		return
 	}
 
+	ack := a.newDeployAck(m, &request)
+
+	hostServices, err := a.startHostServicesProxy(&request)
+	if err != nil {
+		a.LogError(err.Error())
+		ack.complete(false, err.Error())
+		return
+	}
+	a.hostServices = hostServices
+
+	if request.Environment == nil {
+		request.Environment = make(map[string]string)
+	}
+	for k, v := range hostServices.environment() {
+		request.Environment[k] = v
+	}
+
+	if request.Credentials != nil && request.Credentials.Creds != "" {
+		credsFile, err := a.writeWorkloadCredentials(request.Credentials)
+		if err != nil {
+			a.LogError(err.Error())
+			ack.complete(false, err.Error())
+			return
+		}
+		request.Environment["NATS_CREDS"] = credsFile
+		request.Environment["NATS_INBOX_PREFIX"] = request.Credentials.InboxPrefix
+	}
+
+	if request.VolumeSizeMib != nil {
+		err = verifyWorkloadVolume()
+		if err != nil {
+			a.LogError(err.Error())
+			ack.complete(false, err.Error())
+			return
+		}
+		request.Environment[agentapi.NexVolumePathEnv] = agentapi.WorkloadVolumeMountPath
+	}
+
 	tmpFile, err := a.cacheExecutableArtifact(&request)
 	if err != nil {
-		_ = a.workAck(m, false, err.Error())
+		ack.complete(false, err.Error())
 		return
 	}
 
 	params, err := a.newExecutionProviderParams(&request, *tmpFile)
 	if err != nil {
-		_ = a.workAck(m, false, err.Error())
+		ack.complete(false, err.Error())
 		return
 	}
 
@@ -264,7 +477,7 @@ func (a *Agent) handleDeploy(m *nats.Msg) {
 	if err != nil {
 		msg := fmt.Sprintf("Failed to initialize workload execution provider; %s", err)
 		a.LogError(msg)
-		_ = a.workAck(m, false, msg)
+		ack.complete(false, msg)
 		return
 	}
 	a.provider = provider
@@ -273,19 +486,62 @@ func (a *Agent) handleDeploy(m *nats.Msg) {
 	if err != nil {
 		msg := fmt.Sprintf("Failed to validate workload: %s", err)
 		a.LogError(msg)
-		_ = a.workAck(m, false, msg)
+		ack.complete(false, msg)
 		return
 	}
 
+	if request.PreStartHook != nil {
+		err = a.runWorkloadHook(workloadHookPreStart, request.PreStartHook, &request)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to deploy workload: %s", err)
+			a.LogError(msg)
+			ack.complete(false, msg)
+			return
+		}
+	}
+
+	a.deployRequest = &request
+
+	// processes killed before the workload was deployed aren't the workload's
+	kills, _ := readOOMKills()
+	atomic.StoreUint64(&a.oomKills, kills)
+
 	err = a.provider.Deploy()
+	if err == nil && request.ReadinessProbe != nil {
+		err = a.awaitReadiness(&request)
+	}
 	if err != nil {
-		a.LogError(fmt.Sprintf("Failed to deploy workload: %s", err))
+		msg := fmt.Sprintf("Failed to deploy workload: %s", err)
+		a.LogError(msg)
+		ack.complete(false, msg)
 	} else {
-		_ = a.workAck(m, true, "Workload deployed")
+		ack.complete(true, "Workload deployed")
+
+		if request.HealthCheck != nil {
+			var ctx context.Context
+			ctx, a.healthCancel = context.WithCancel(a.ctx)
+			go a.runHealthChecks(ctx, &request)
+		}
+
+		var ctx context.Context
+		ctx, a.memoryCancel = context.WithCancel(a.ctx)
+		go a.runMemoryReports(ctx, &request)
+
+		if request.MemorySoftLimit != nil {
+			go a.watchMemoryPressure(ctx, &request)
+		}
 	}
 }
 
 func (a *Agent) handleUndeploy(m *nats.Msg) {
+	if a.healthCancel != nil {
+		a.healthCancel()
+	}
+
+	if a.memoryCancel != nil {
+		a.memoryCancel()
+	}
+
 	err := a.provider.Undeploy()
 	if err != nil {
 		// don't return an error here so worst-case scenario is an ungraceful shutdown,
@@ -293,6 +549,19 @@ func (a *Agent) handleUndeploy(m *nats.Msg) {
 		a.LogError(fmt.Sprintf("Failed to undeploy workload: %s", err))
 	}
 
+	if a.hostServices != nil {
+		a.hostServices.close()
+	}
+
+	_ = os.Remove(a.workloadCredentialsPath())
+
+	if a.deployRequest != nil && a.deployRequest.PostStopHook != nil {
+		err = a.runWorkloadHook(workloadHookPostStop, a.deployRequest.PostStopHook, a.deployRequest)
+		if err != nil {
+			a.LogError(err.Error())
+		}
+	}
+
 	_ = m.Respond([]byte{})
 }
 
@@ -349,10 +618,14 @@ func (a *Agent) newExecutionProviderParams(req *agentapi.DeployRequest, tmpFile
 		return nil, errors.New("workload name is required to initialize execution provider params")
 	}
 
+	// the output of processes is piped in arbitrary chunks, so it's split into lines
+	output := newWorkloadOutput(*req.WorkloadName, req.LogLimits, a.workloadLogs)
+	lines := strings.EqualFold(*req.WorkloadType, agentapi.NexExecutionProviderELF) || strings.EqualFold(*req.WorkloadType, agentapi.NexExecutionProviderPython)
+
 	params := &agentapi.ExecutionProviderParams{
 		DeployRequest: *req,
-		Stderr:        &logEmitter{stderr: true, name: *req.WorkloadName, logs: a.agentLogs},
-		Stdout:        &logEmitter{stderr: false, name: *req.WorkloadName, logs: a.agentLogs},
+		Stderr:        output.stream(agentapi.LogLevelError, lines),
+		Stdout:        output.stream(agentapi.LogLevelInfo, lines),
 		TmpFilename:   &tmpFile,
 		VmID:          *a.md.VmID,
 
@@ -370,15 +643,21 @@ func (a *Agent) newExecutionProviderParams(req *agentapi.DeployRequest, tmpFile
 		for {
 			select {
 			case <-params.Fail:
+				output.close()
 				msg := fmt.Sprintf("Failed to start workload: %s; vm: %s", *params.WorkloadName, params.VmID)
 				a.PublishWorkloadExited(params.VmID, *params.WorkloadName, msg, true, -1)
 				return
 
 			case <-params.Run:
-				a.PublishWorkloadDeployed(params.VmID, *params.WorkloadName, params.TotalBytes)
+				a.PublishWorkloadDeployed(params.VmID, *params.WorkloadName, params.TotalBytes, params.Provenance, params.ScanResults)
 				sleepMillis = workloadExecutionSleepTimeoutMillis
 
 			case exit := <-params.Exit:
+				output.close()
+				if exit != 0 {
+					a.checkOOMKills(*params.WorkloadName)
+				}
+
 				msg := fmt.Sprintf("Exited workload: %s; vm: %s; status: %d", *params.WorkloadName, params.VmID, exit)
 				a.PublishWorkloadExited(params.VmID, *params.WorkloadName, msg, exit != 0, exit)
 				return
@@ -425,11 +704,13 @@ func (a *Agent) submitLog(msg string, lvl agentapi.LogLevel) {
 // workAck ACKs the provided NATS message by responding with the
 // accepted status of the attempted work request and associated message
 func (a *Agent) workAck(m *nats.Msg, accepted bool, msg string) error {
-	ack := agentapi.DeployResponse{
+	return a.respondDeploy(m, agentapi.DeployResponse{
 		Accepted: accepted,
 		Message:  agentapi.StringOrNil(msg),
-	}
+	})
+}
 
+func (a *Agent) respondDeploy(m *nats.Msg, ack agentapi.DeployResponse) error {
 	bytes, err := json.Marshal(&ack)
 	if err != nil {
 		return err
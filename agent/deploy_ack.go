@@ -0,0 +1,75 @@
+package nexagent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Acknowledges a deploy request once the workload has either deployed or failed to. Should that
+// take more than half of the node's ack timeout (e.g., while a large artifact is cached or a
+// pre-start hook runs), the workload is first acknowledged as initializing, and whether it
+// deployed is then published in a workload ready event
+type deployAck struct {
+	agent        *Agent
+	msg          *nats.Msg
+	workloadName string
+	timer        *time.Timer
+
+	mutex        sync.Mutex
+	responded    bool
+	initializing bool
+}
+
+func (a *Agent) newDeployAck(m *nats.Msg, request *agentapi.DeployRequest) *deployAck {
+	ack := &deployAck{
+		agent:        a,
+		msg:          m,
+		workloadName: *request.WorkloadName,
+	}
+	ack.timer = time.AfterFunc(request.AckTimeout()/2, ack.initialize)
+
+	return ack
+}
+
+func (d *deployAck) initialize() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.responded {
+		return
+	}
+
+	d.responded = true
+	d.initializing = true
+	_ = d.agent.respondDeploy(d.msg, agentapi.DeployResponse{
+		Accepted:     true,
+		Message:      agentapi.StringOrNil("Workload initializing"),
+		Initializing: true,
+	})
+}
+
+// Reports whether the workload was deployed, either in response to the deploy request or, if it
+// was acknowledged as initializing, in a workload ready event
+func (d *deployAck) complete(accepted bool, msg string) {
+	d.timer.Stop()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.responded {
+		d.responded = true
+		_ = d.agent.workAck(d.msg, accepted, msg)
+		return
+	}
+
+	if d.initializing {
+		d.agent.PublishWorkloadReady(*d.agent.md.VmID, agentapi.WorkloadReadyEvent{
+			WorkloadName: d.workloadName,
+			Ready:        accepted,
+			Message:      msg,
+		})
+	}
+}
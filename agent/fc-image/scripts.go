@@ -13,7 +13,7 @@ output_log="/home/nex/nex.log"
 error_log="/home/nex/err.log"
 
 depend() {
-	after net.eth0
+	after net.eth0 localmount
 }`
 
 	setup_alpine = `#!/bin/sh
@@ -25,6 +25,7 @@ chmod +x /etc/init.d/agent
 
 apk add --no-cache openrc
 apk add --no-cache util-linux
+apk add --no-cache python3
 
 ln -s agetty /etc/init.d/agetty.ttyS0
 echo ttyS0 >/etc/securetty
@@ -38,6 +39,25 @@ rc-update add devfs boot
 rc-update add procfs boot
 rc-update add sysfs boot
 
+# Run in place of init when the node shares the rootfs read-only between machines: mounts a
+# writable tmpfs overlay over the rootfs and hands over to init within it
+cat >/sbin/overlay-init <<'EOF'
+#!/bin/sh
+set -e
+mount -t tmpfs -o size=${nex_overlay_size_mib:-64}m,mode=0755 tmpfs /run
+mkdir /run/upper /run/work /run/root
+mount -t overlay overlay -o lowerdir=/,upperdir=/run/upper,workdir=/run/work /run/root
+mkdir -p /run/root/rom
+cd /run/root
+pivot_root . rom
+exec chroot . /sbin/init
+EOF
+chmod +x /sbin/overlay-init
+
+# Persistent workload volumes are attached as the machine's second drive
+echo "/dev/vdb /home/nex/volume ext4 defaults,nofail 0 0" >>/etc/fstab
+rc-update add localmount boot
+
 # This is our script that runs nex-agent
 rc-update add agent boot
 
@@ -45,6 +65,6 @@ for d in bin etc lib root sbin usr; do tar c "/$d" | tar x -C /tmp/rootfs; done
 for dir in dev proc run sys var tmp; do mkdir /tmp/rootfs/${dir}; done
 
 chmod 1777 /tmp/rootfs/tmp
-mkdir -p /tmp/rootfs/home/nex/
-chown 1000:1000 /tmp/rootfs/home/nex/`
+mkdir -p /tmp/rootfs/home/nex/volume
+chown -R 1000:1000 /tmp/rootfs/home/nex/`
 )
@@ -0,0 +1,181 @@
+package nexagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Runs the workload's health check on its declared interval until the given context
+// is cancelled, publishing each result to the node host via internal NATS
+func (a *Agent) runHealthChecks(ctx context.Context, request *agentapi.DeployRequest) {
+	check := request.HealthCheck
+	probe := &healthProbe{check: check, env: request.Environment}
+	defer probe.close()
+
+	ticker := time.NewTicker(check.Interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result := agentapi.HealthCheckResult{
+				Healthy:   true,
+				CheckedAt: time.Now().UTC(),
+			}
+
+			err := probe.run(ctx)
+			if err != nil {
+				result.Healthy = false
+				result.Message = agentapi.StringOrNil(err.Error())
+			}
+
+			a.publishHealthCheckResult(&result)
+		}
+	}
+}
+
+// Runs the workload's readiness probe on its interval until it first passes, returning the error
+// of its last attempt if it hasn't passed within the workload's ready timeout
+func (a *Agent) awaitReadiness(request *agentapi.DeployRequest) error {
+	probe := &healthProbe{check: request.ReadinessProbe, env: request.Environment}
+	defer probe.close()
+
+	ctx, cancel := context.WithTimeout(a.ctx, request.ReadyTimeout())
+	defer cancel()
+
+	ticker := time.NewTicker(request.ReadinessProbeInterval())
+	defer ticker.Stop()
+
+	var failure error
+	for {
+		err := probe.run(ctx)
+		if err == nil {
+			return nil
+		}
+		// an attempt cut short by the ready timeout says less than the one before it
+		if failure == nil || ctx.Err() == nil {
+			failure = err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("readiness probe did not pass within %s: %s", request.ReadyTimeout(), failure)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Agent) publishHealthCheckResult(result *agentapi.HealthCheckResult) {
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal health check result to json: %s", err.Error())
+		return
+	}
+
+	subject := fmt.Sprintf("agentint.%s.health", *a.md.VmID)
+	err = a.nc.Publish(subject, bytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to publish health check result: %s", err.Error())
+	}
+}
+
+type healthProbe struct {
+	check *agentapi.HealthCheck
+	env   map[string]string
+
+	// lazily established for nats probes
+	nc *nats.Conn
+}
+
+// Runs the health check once, returning an error if the workload is unhealthy
+func (p *healthProbe) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.check.Timeout())
+	defer cancel()
+
+	switch strings.ToLower(p.check.Type) {
+	case agentapi.HealthCheckTypeExec:
+		return p.exec(ctx)
+	case agentapi.HealthCheckTypeHTTP:
+		return p.http(ctx)
+	case agentapi.HealthCheckTypeNATS:
+		return p.nats(ctx)
+	default:
+		return fmt.Errorf("unsupported health check type: %s", p.check.Type)
+	}
+}
+
+func (p *healthProbe) exec(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, p.check.Command[0], p.check.Command[1:]...)
+
+	cmd.Env = make([]string, 0)
+	for k, v := range p.env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("health check command failed: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (p *healthProbe) http(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *p.check.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (p *healthProbe) nats(ctx context.Context) error {
+	if p.nc == nil {
+		url := nats.DefaultURL
+		if p.check.NatsUrl != nil {
+			url = *p.check.NatsUrl
+		} else if envUrl, ok := p.env["NATS_URL"]; ok {
+			url = envUrl
+		}
+
+		nc, err := nats.Connect(url, nats.Timeout(p.check.Timeout()))
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS for health check: %s", err)
+		}
+		p.nc = nc
+	}
+
+	_, err := p.nc.RequestWithContext(ctx, *p.check.Subject, []byte{})
+	if err != nil {
+		return fmt.Errorf("health check request failed: %s", err)
+	}
+
+	return nil
+}
+
+func (p *healthProbe) close() {
+	if p.nc != nil {
+		p.nc.Close()
+	}
+}
@@ -0,0 +1,52 @@
+package nexagent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Names of the workload lifecycle hooks, used to identify their output in the logs
+const (
+	workloadHookPreStart = "pre-start"
+	workloadHookPostStop = "post-stop"
+)
+
+// Runs the given workload hook inside the sandbox, waiting for it to exit or time out. The
+// hook's stdout and stderr are emitted to the node as workload logs, and its output is
+// included in the returned error if the hook fails
+func (a *Agent) runWorkloadHook(name string, hook *agentapi.WorkloadHook, request *agentapi.DeployRequest) error {
+	ctx, cancel := context.WithTimeout(a.ctx, hook.Timeout())
+	defer cancel()
+
+	source := fmt.Sprintf("%s/%s", *request.WorkloadName, name)
+	var output bytes.Buffer
+	logs := newWorkloadOutput(source, request.LogLimits, a.workloadLogs)
+	defer logs.close()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdout = io.MultiWriter(&output, logs.stream(agentapi.LogLevelInfo, true))
+	cmd.Stderr = io.MultiWriter(&output, logs.stream(agentapi.LogLevelError, true))
+
+	cmd.Env = make([]string, 0)
+	for k, v := range request.Environment {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
+	}
+
+	a.LogInfo(fmt.Sprintf("Running %s hook for workload %s", name, *request.WorkloadName))
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %s", name, hook.Timeout())
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %s: %s", name, err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package nexagent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const hostServicesRequestTimeout = 5 * time.Second
+
+// Messages buffered by the agent for each of the workload's subscriptions before further
+// messages are dropped
+const hostServicesSubscriptionBufferSize = 256
+
+var tracePropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Proxies host services requests made by the workload over HTTP on a loopback endpoint
+// to the node via internal NATS, so workloads don't need to know anything about the
+// internal NATS connection. Requests are made to /{service}/{method} and must present
+// the bearer token the agent injected into the workload's environment
+type hostServicesProxy struct {
+	listener net.Listener
+	server   *http.Server
+	token    string
+
+	// agentint.{vmID}.rpc.{namespace}.{workload}
+	subjectPrefix string
+	vmID          string
+	nc            *nats.Conn
+}
+
+// Starts a host services proxy for the given workload listening on an ephemeral loopback port
+func (a *Agent) startHostServicesProxy(request *agentapi.DeployRequest) (*hostServicesProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for host services requests: %s", err)
+	}
+
+	token := make([]byte, 32)
+	_, err = rand.Read(token)
+	if err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to generate host services token: %s", err)
+	}
+
+	namespace := "default"
+	if request.Namespace != nil {
+		namespace = *request.Namespace
+	}
+
+	proxy := &hostServicesProxy{
+		listener:      listener,
+		token:         hex.EncodeToString(token),
+		subjectPrefix: fmt.Sprintf("agentint.%s.rpc.%s.%s", *a.md.VmID, namespace, *request.WorkloadName),
+		vmID:          *a.md.VmID,
+		nc:            a.nc,
+	}
+	proxy.server = &http.Server{
+		Handler:           proxy,
+		ReadHeaderTimeout: hostServicesRequestTimeout,
+	}
+
+	go func() {
+		err := proxy.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			a.LogError(fmt.Sprintf("Host services proxy stopped: %s", err))
+		}
+	}()
+
+	return proxy, nil
+}
+
+// Environment variables injected into the workload describing how to reach host services
+func (p *hostServicesProxy) environment() map[string]string {
+	return map[string]string{
+		agentapi.NexHostServicesEnvURL:   fmt.Sprintf("http://%s", p.listener.Addr().String()),
+		agentapi.NexHostServicesEnvToken: p.token,
+	}
+}
+
+func (p *hostServicesProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// /{service}/{method}
+	tokens := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		http.Error(w, "expected a request path of the form /{service}/{method}", http.StatusNotFound)
+		return
+	}
+
+	if tokens[0] == "messaging" && tokens[1] == "subscribe" {
+		p.serveSubscription(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg := p.newRPCMsg(tokens[0], tokens[1], r)
+	msg.Data = body
+
+	ctx, cancel := context.WithTimeout(r.Context(), hostServicesRequestTimeout)
+	defer cancel()
+
+	// forward any trace context the workload propagated with its request
+	ctx = tracePropagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(msg.Header))
+
+	resp, err := p.nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("host services request failed: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	for k, v := range resp.Header {
+		for _, hv := range v {
+			w.Header().Add(k, hv)
+		}
+	}
+
+	_, _ = w.Write(resp.Data)
+}
+
+// Builds an RPC request for the given host service method, passing along the host services
+// arguments (e.g., x-subject) of the workload's request as lowercase x- headers
+func (p *hostServicesProxy) newRPCMsg(service string, method string, r *http.Request) *nats.Msg {
+	msg := nats.NewMsg(fmt.Sprintf("%s.%s.%s", p.subjectPrefix, service, method))
+	for k, v := range r.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-") && len(v) > 0 {
+			msg.Header.Add(strings.ToLower(k), v[0])
+		}
+	}
+	return msg
+}
+
+// Asks the node to subscribe to the subject given in the request's x-subject header (and x-queue
+// header, if any) on the workload's behalf, then streams the messages it forwards to the workload
+// as lines of JSON until the workload closes the request, at which point the node is asked to
+// unsubscribe
+func (p *hostServicesProxy) serveSubscription(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	raw := make([]byte, 8)
+	_, err := rand.Read(raw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate subscription id: %s", err), http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(raw)
+
+	// agentint.{vmID}.subscriptions.{subscriptionID}
+	msgs := make(chan *nats.Msg, hostServicesSubscriptionBufferSize)
+	sub, err := p.nc.ChanSubscribe(fmt.Sprintf("agentint.%s.subscriptions.%s", p.vmID, id), msgs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to subscribe: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	msg := p.newRPCMsg("messaging", "subscribe", r)
+	msg.Header.Set("x-subscription-id", id)
+
+	err = p.requestRPC(r.Context(), msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() {
+		msg := p.newRPCMsg("messaging", "unsubscribe", r)
+		msg.Header.Set("x-subscription-id", id)
+		_ = p.requestRPC(context.Background(), msg)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m := <-msgs:
+			out := agentapi.HostServicesSubscriptionMessage{
+				Subject: m.Header.Get("x-subject"),
+				Reply:   m.Header.Get("x-reply"),
+				Data:    m.Data,
+			}
+			m.Header.Del("x-subject")
+			m.Header.Del("x-reply")
+			if len(m.Header) > 0 {
+				out.Header = m.Header
+			}
+
+			err := enc.Encode(&out)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Makes a messaging host services RPC request, returning an error if the node failed it
+func (p *hostServicesProxy) requestRPC(ctx context.Context, msg *nats.Msg) error {
+	ctx, cancel := context.WithTimeout(ctx, hostServicesRequestTimeout)
+	defer cancel()
+
+	resp, err := p.nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("host services request failed: %s", err)
+	}
+
+	var res agentapi.HostServicesMessagingResponse
+	err = json.Unmarshal(resp.Data, &res)
+	if err != nil || (!res.Success && len(res.Errors) == 0) {
+		return fmt.Errorf("host services request failed: %s", string(resp.Data))
+	}
+	if !res.Success {
+		return errors.New(strings.Join(res.Errors, "; "))
+	}
+	return nil
+}
+
+func (p *hostServicesProxy) close() {
+	_ = p.server.Close()
+}
@@ -7,35 +7,7 @@ import (
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 )
 
-const NexEventSourceNexAgent = "nex-agent"
-
-// logEmitter implements the writer interface that allows us to capture a workload's
-// stdout and stderr so that we can then publish those logs to the host node
-type logEmitter struct {
-	name   string
-	stderr bool
-
-	logs chan *agentapi.LogEntry
-}
-
-// Write arbitrary bytes to the underlying log emitter
-func (l *logEmitter) Write(bytes []byte) (int, error) {
-	var lvl agentapi.LogLevel
-	if l.stderr {
-		lvl = agentapi.LogLevelError
-	} else {
-		lvl = agentapi.LogLevelInfo
-	}
-
-	l.logs <- &agentapi.LogEntry{
-		Level:  lvl,
-		Source: l.name,
-		Text:   string(bytes),
-	}
-
-	// FIXME-- this never returns an error
-	return len(bytes), nil
-}
+const NexEventSourceNexAgent = agentapi.AgentLogSource
 
 func (a *Agent) LogDebug(msg string) {
 	a.submitLog(msg, agentapi.LogLevelDebug)
@@ -53,14 +25,14 @@ func (a *Agent) LogInfo(msg string) {
 }
 
 // FIXME-- revisit error handling
-func (a *Agent) PublishWorkloadDeployed(vmID, workloadName string, totalBytes int64) {
+func (a *Agent) PublishWorkloadDeployed(vmID, workloadName string, totalBytes int64, provenance *agentapi.ArtifactProvenance, scanResults []agentapi.ArtifactScanResult) {
 	a.agentLogs <- &agentapi.LogEntry{
 		Source: NexEventSourceNexAgent,
 		Level:  agentapi.LogLevelInfo,
 		Text:   fmt.Sprintf("Workload %s deployed", workloadName),
 	}
 
-	evt := agentapi.NewAgentEvent(vmID, agentapi.WorkloadStartedEventType, agentapi.WorkloadStatusEvent{WorkloadName: workloadName})
+	evt := agentapi.NewAgentEvent(vmID, agentapi.WorkloadStartedEventType, agentapi.WorkloadStatusEvent{WorkloadName: workloadName, Provenance: provenance, ScanResults: scanResults})
 	a.eventLogs <- &evt
 }
 
@@ -87,3 +59,47 @@ func (a *Agent) PublishWorkloadExited(vmID, workloadName, message string, err bo
 	evt := agentapi.NewAgentEvent(vmID, agentapi.WorkloadStoppedEventType, agentapi.WorkloadStatusEvent{WorkloadName: workloadName, Code: code, Message: message})
 	a.eventLogs <- &evt
 }
+
+// PublishWorkloadReady publishes whether a workload acknowledged as initializing has deployed
+func (a *Agent) PublishWorkloadReady(vmID string, event agentapi.WorkloadReadyEvent) {
+	if !event.Ready {
+		a.agentLogs <- &agentapi.LogEntry{
+			Source: NexEventSourceNexAgent,
+			Level:  agentapi.LogLevelError,
+			Text:   fmt.Sprintf("Workload %s failed to initialize", event.WorkloadName),
+		}
+	}
+
+	evt := agentapi.NewAgentEvent(vmID, agentapi.WorkloadReadyEventType, event)
+	a.eventLogs <- &evt
+}
+
+// PublishArtifactProgress publishes an event as the workload's artifact is streamed into the machine
+func (a *Agent) PublishArtifactProgress(vmID string, event agentapi.ArtifactProgressEvent) {
+	evt := agentapi.NewAgentEvent(vmID, agentapi.ArtifactProgressEventType, event)
+	a.eventLogs <- &evt
+}
+
+// PublishMemoryPressure publishes a memory pressure event when the workload crosses its soft memory limit
+func (a *Agent) PublishMemoryPressure(vmID string, event agentapi.MemoryPressureEvent) {
+	a.agentLogs <- &agentapi.LogEntry{
+		Source: NexEventSourceNexAgent,
+		Level:  agentapi.LogLevelWarn,
+		Text:   fmt.Sprintf("Workload %s crossed its soft memory limit (%.1f%% of memory in use)", event.WorkloadName, event.UsedPercent),
+	}
+
+	evt := agentapi.NewAgentEvent(vmID, agentapi.MemoryPressureEventType, event)
+	a.eventLogs <- &evt
+}
+
+// PublishWorkloadOOM publishes an event when a process in the workload's machine is killed for running out of memory
+func (a *Agent) PublishWorkloadOOM(vmID string, event agentapi.WorkloadOOMEvent) {
+	a.agentLogs <- &agentapi.LogEntry{
+		Source: NexEventSourceNexAgent,
+		Level:  agentapi.LogLevelError,
+		Text:   fmt.Sprintf("Workload %s ran out of memory (%d of %d MiB in use)", event.WorkloadName, event.UsedMib, event.TotalMib),
+	}
+
+	evt := agentapi.NewAgentEvent(vmID, agentapi.WorkloadOOMEventType, event)
+	a.eventLogs <- &evt
+}
@@ -0,0 +1,183 @@
+package nexagent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/synadia-io/nex/agent/providers"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	memInfoPath        = "/proc/meminfo"
+	memoryPressurePath = "/proc/pressure/memory"
+)
+
+// Number of percentage points memory use (and pressure) must fall below the soft memory limit
+// before the workload can be notified again, so that a workload hovering around its limit
+// isn't notified on every sample
+const memorySoftLimitRearmPercent = 5
+
+// A sample of the machine's memory use. Pressure is nil when the kernel doesn't report
+// pressure stall information
+type memoryUsage struct {
+	totalMib    int64
+	usedMib     int64
+	usedPercent float64
+	pressure    *float64
+}
+
+// Watches the machine's memory use on the soft memory limit's interval until the given context
+// is cancelled, notifying the workload each time it crosses its soft memory limit
+func (a *Agent) watchMemoryPressure(ctx context.Context, request *agentapi.DeployRequest) {
+	limit := request.MemorySoftLimit
+
+	var signal os.Signal
+	if limit.Signal != nil {
+		var err error
+		signal, err = memorySoftLimitSignal(*limit.Signal)
+		if err != nil {
+			a.LogError(fmt.Sprintf("Workload won't be signaled when crossing its soft memory limit: %s", err))
+		}
+	}
+
+	ticker := time.NewTicker(limit.Interval())
+	defer ticker.Stop()
+
+	armed := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			usage, err := readMemoryUsage()
+			if err != nil {
+				a.LogError(fmt.Sprintf("Failed to read memory use; no longer watching soft memory limit: %s", err))
+				return
+			}
+
+			if !armed {
+				armed = !usage.exceeds(limit, memorySoftLimitRearmPercent)
+				continue
+			}
+
+			if !usage.exceeds(limit, 0) {
+				continue
+			}
+			armed = false
+
+			event := agentapi.MemoryPressureEvent{
+				WorkloadName:     *request.WorkloadName,
+				UsedPercent:      usage.usedPercent,
+				PressurePercent:  usage.pressure,
+				ThresholdPercent: limit.ThresholdPercent,
+			}
+
+			if signal != nil {
+				err = a.signalWorkload(signal)
+				if err != nil {
+					a.LogError(fmt.Sprintf("Failed to signal workload crossing its soft memory limit: %s", err))
+				} else {
+					event.Signal = strings.ToUpper(*limit.Signal)
+				}
+			}
+
+			a.PublishMemoryPressure(*a.md.VmID, event)
+		}
+	}
+}
+
+func (a *Agent) signalWorkload(sig os.Signal) error {
+	provider, ok := a.provider.(providers.SignalingProvider)
+	if !ok {
+		return errors.New("execution provider does not support signals")
+	}
+
+	return provider.Signal(sig)
+}
+
+// Returns true if memory use (or pressure) is at or above the given limit, less the given margin
+func (u *memoryUsage) exceeds(limit *agentapi.MemorySoftLimit, margin float64) bool {
+	if limit.ThresholdPercent > 0 && u.usedPercent >= float64(limit.ThresholdPercent)-margin {
+		return true
+	}
+
+	return limit.PressurePercent > 0 && u.pressure != nil && *u.pressure >= float64(limit.PressurePercent)-margin
+}
+
+// Samples the machine's memory use from /proc/meminfo and, where available, its memory
+// pressure from /proc/pressure/memory
+func readMemoryUsage() (*memoryUsage, error) {
+	f, err := os.Open(memInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseFloat(fields[1], 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no total memory reported by %s", memInfoPath)
+	}
+
+	usage := &memoryUsage{
+		totalMib:    int64(total / 1024),
+		usedMib:     int64((total - available) / 1024),
+		usedPercent: (total - available) / total * 100,
+		pressure:    readMemoryPressure(),
+	}
+
+	return usage, nil
+}
+
+// Returns the share of the last 10 seconds in which some tasks stalled on memory, or nil if
+// the kernel doesn't report pressure stall information
+func readMemoryPressure() *float64 {
+	raw, err := os.ReadFile(memoryPressurePath)
+	if err != nil {
+		return nil
+	}
+
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+
+		avg10, ok := strings.CutPrefix(fields[1], "avg10=")
+		if !ok {
+			return nil
+		}
+
+		pressure, err := strconv.ParseFloat(avg10, 64)
+		if err != nil {
+			return nil
+		}
+		return &pressure
+	}
+
+	return nil
+}
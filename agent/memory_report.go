@@ -0,0 +1,122 @@
+package nexagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/synadia-io/nex/agent/providers"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const vmStatPath = "/proc/vmstat"
+
+// Samples the machine's memory use on an interval until the given context is cancelled,
+// reporting it (and its high-water mark) to the node host via internal NATS, along with the
+// trigger executions of functions. Processes
+// killed by the kernel for running out of memory are published as workload_oom events
+func (a *Agent) runMemoryReports(ctx context.Context, request *agentapi.DeployRequest) {
+	ticker := time.NewTicker(agentapi.MemoryReportIntervalMillis * time.Millisecond)
+	defer ticker.Stop()
+
+	var peakMib int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			usage, err := readMemoryUsage()
+			if err != nil {
+				a.LogError(fmt.Sprintf("Failed to read memory use; no longer reporting it: %s", err))
+				return
+			}
+
+			if usage.usedMib > peakMib {
+				peakMib = usage.usedMib
+			}
+
+			a.checkOOMKills(*request.WorkloadName)
+
+			report := &agentapi.MemoryReport{
+				UsedMib:   usage.usedMib,
+				PeakMib:   peakMib,
+				TotalMib:  usage.totalMib,
+				OOMKills:  atomic.LoadUint64(&a.oomKills),
+				SampledAt: time.Now().UTC(),
+			}
+			if reporter, ok := a.provider.(providers.TriggerExecutionReporter); ok {
+				triggers := reporter.TriggerExecutions()
+				report.Triggers = &triggers
+			}
+
+			a.publishMemoryReport(report)
+		}
+	}
+}
+
+// Publishes a workload_oom event if the kernel has killed processes for running out of memory
+// since the last check. Also called when the workload exits, as it may have been the process
+// that was killed
+func (a *Agent) checkOOMKills(workloadName string) {
+	kills, err := readOOMKills()
+	if err != nil {
+		return
+	}
+
+	if previous := atomic.SwapUint64(&a.oomKills, kills); kills <= previous {
+		return
+	}
+
+	event := agentapi.WorkloadOOMEvent{
+		WorkloadName: workloadName,
+		OOMKills:     kills,
+	}
+	if usage, err := readMemoryUsage(); err == nil {
+		event.UsedMib = usage.usedMib
+		event.TotalMib = usage.totalMib
+	}
+
+	a.PublishWorkloadOOM(*a.md.VmID, event)
+}
+
+func (a *Agent) publishMemoryReport(report *agentapi.MemoryReport) {
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal memory report to json: %s", err.Error())
+		return
+	}
+
+	subject := fmt.Sprintf("agentint.%s.memory", *a.md.VmID)
+	err = a.nc.Publish(subject, bytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to publish memory report: %s", err.Error())
+	}
+}
+
+// Returns the number of processes the kernel has killed for running out of memory since boot
+func readOOMKills() (uint64, error) {
+	f, err := os.Open(vmStatPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no oom kill count reported by %s", vmStatPath)
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package nexagent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Returns the signal with the given name which is sent to a workload crossing its soft memory limit
+func memorySoftLimitSignal(name string) (os.Signal, error) {
+	switch strings.ToUpper(name) {
+	case agentapi.MemorySoftLimitSignalUSR1:
+		return syscall.SIGUSR1, nil
+	case agentapi.MemorySoftLimitSignalUSR2:
+		return syscall.SIGUSR2, nil
+	case agentapi.MemorySoftLimitSignalHUP:
+		return syscall.SIGHUP, nil
+	default:
+		return nil, fmt.Errorf("unsupported memory soft limit signal: %s", name)
+	}
+}
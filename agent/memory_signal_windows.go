@@ -0,0 +1,11 @@
+package nexagent
+
+import (
+	"errors"
+	"os"
+)
+
+// Workloads can't be signaled on Windows; they're still notified of memory pressure by event
+func memorySoftLimitSignal(name string) (os.Signal, error) {
+	return nil, errors.New("signals are not supported on this platform")
+}
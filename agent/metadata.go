@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 )
 
@@ -60,6 +64,55 @@ func GetMachineMetadata() (*agentapi.MachineMetadata, error) {
 	return nil, fmt.Errorf("failed to obtain metadata after %dms", metadataPollingTimeoutMillis)
 }
 
+// GetMachineMetadataFromEnv reads machine metadata from the environment variables
+// supplied by the node when the agent is spawned as a local process (no sandbox)
+func GetMachineMetadataFromEnv() (*agentapi.MachineMetadata, error) {
+	port, err := strconv.Atoi(os.Getenv(agentapi.NexAgentEnvNodeNatsPort))
+	if err != nil {
+		return nil, fmt.Errorf("invalid node NATS port: %s", err)
+	}
+
+	return &agentapi.MachineMetadata{
+		Message:      agentapi.StringOrNil("Host-supplied metadata"),
+		NodeNatsHost: agentapi.StringOrNil(os.Getenv(agentapi.NexAgentEnvNodeNatsHost)),
+		NodeNatsPort: &port,
+		VmID:         agentapi.StringOrNil(os.Getenv(agentapi.NexAgentEnvVmID)),
+
+		NodeNatsNkeySeed: agentapi.StringOrNil(os.Getenv(agentapi.NexAgentEnvNodeNatsSeed)),
+	}, nil
+}
+
+// internalNatsOptions returns the options for connecting to the node's internal NATS server
+// as the user issued to this machine, if the node supplied its nkey seed. Inboxes are created
+// beneath the machine's own prefix, as those are the only ones the agent may subscribe to
+func internalNatsOptions(metadata *agentapi.MachineMetadata) ([]nats.Option, error) {
+	if metadata.NodeNatsNkeySeed == nil {
+		return nil, nil
+	}
+
+	kp, err := nkeys.FromSeed([]byte(*metadata.NodeNatsNkeySeed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nkey seed: %s", err)
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return []nats.Option{
+		nats.Nkey(pub, kp.Sign),
+		nats.CustomInboxPrefix(agentapi.AgentInboxPrefix(*metadata.VmID)),
+	}, nil
+}
+
+// isSandboxed returns false when the agent has been spawned by the node as a
+// local process, which is indicated by the presence of a vm id in the environment
+func isSandboxed() bool {
+	_, ok := os.LookupEnv(agentapi.NexAgentEnvVmID)
+	return !ok
+}
+
 func performMetadataQuery(url string, req *http.Request, client *http.Client) (*agentapi.MachineMetadata, error) {
 	resp, err := client.Do(req)
 	if err != nil {
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"errors"
+	"os"
 
 	"github.com/synadia-io/nex/agent/providers/lib"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
@@ -19,14 +20,17 @@ const NexExecutionProviderOCI = "oci"
 // NexExecutionProviderWasm Wasm execution provider
 const NexExecutionProviderWasm = "wasm"
 
+// NexExecutionProviderPython Python execution provider
+const NexExecutionProviderPython = "python"
+
 // ExecutionProvider implementations provide support for a specific
 // execution environment pattern -- e.g., statically-linked ELF
 // binaries, serverless JavaScript functions, OCI images, Wasm, etc.
 type ExecutionProvider interface {
-	// Deploy a service (e.g., "elf" and "oci" types) or executable function (e.g., "v8" and "wasm" types)
+	// Deploy a service (e.g., "elf" and "oci" types) or executable function (e.g., "v8", "wasm" and "python" types)
 	Deploy() error
 
-	// Execute a deployed function, if supported by the execution provider implementation (e.g., "v8" and "wasm" types)
+	// Execute a deployed function, if supported by the execution provider implementation (e.g., "v8", "wasm" and "python" types)
 	Execute(subject string, payload []byte) ([]byte, error)
 
 	// Undeploy a workload, giving it a chance to gracefully clean up after itself (if applicable)
@@ -37,6 +41,20 @@ type ExecutionProvider interface {
 	Validate() error
 }
 
+// SignalingProvider is implemented by execution providers whose workloads run as a
+// process the agent can signal (e.g., "elf" types)
+type SignalingProvider interface {
+	// Signal the workload's process
+	Signal(sig os.Signal) error
+}
+
+// TriggerExecutionReporter is implemented by execution providers which execute functions on
+// trigger messages (e.g., "v8", "wasm" and "python" types)
+type TriggerExecutionReporter interface {
+	// Report the function's queued and active trigger executions
+	TriggerExecutions() agentapi.TriggerExecutionReport
+}
+
 // NewExecutionProvider initializes and returns an execution provider for a given work request
 func NewExecutionProvider(params *agentapi.ExecutionProviderParams) (ExecutionProvider, error) {
 	if params.WorkloadType == nil {
@@ -53,6 +71,8 @@ func NewExecutionProvider(params *agentapi.ExecutionProviderParams) (ExecutionPr
 		return nil, errors.New("oci execution provider not yet implemented")
 	case NexExecutionProviderWasm:
 		return lib.InitNexExecutionProviderWasm(params)
+	case NexExecutionProviderPython:
+		return lib.InitNexExecutionProviderPython(params)
 	default:
 		break
 	}
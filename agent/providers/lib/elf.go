@@ -1,6 +1,8 @@
 package lib
 
 import (
+	"bytes"
+	"context"
 	"debug/elf"
 	"errors"
 	"fmt"
@@ -10,17 +12,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 )
 
-// ELF execution provider implementation
+// How long a native workload executed once per trigger may run for
+const elfExecutionTimeout = 5 * time.Second
+
+// ELF execution provider implementation. The binary runs as a service, unless it's executed
+// once per trigger, with the trigger payload on its stdin and its stdout as the reply
 type ELF struct {
-	argv        []string
-	environment map[string]string
-	name        string
-	tmpFilename string
-	totalBytes  int64
-	vmID        string
+	argv           []string
+	environment    map[string]string
+	execPerTrigger bool
+	name           string
+	stdin          []byte
+	tmpFilename    string
+	totalBytes     int64
+	vmID           string
 
 	fail chan bool
 	run  chan bool
@@ -30,18 +39,30 @@ type ELF struct {
 
 	stderr io.Writer
 	stdout io.Writer
+
+	nc *nats.Conn // agent NATS connection
+
+	executions triggerExecutions
 }
 
 // Deploy the ELF binary
 func (e *ELF) Deploy() error {
+	if e.execPerTrigger {
+		err := e.executions.subscribe(e.nc, e.vmID, e.stderr, e.execute)
+		if err != nil {
+			return err
+		}
+
+		e.run <- true
+		return nil
+	}
+
 	cmd := exec.Command(e.tmpFilename, e.argv...)
 	cmd.Stdout = e.stdout
 	cmd.Stderr = e.stderr
-
-	cmd.Env = make([]string, len(e.environment))
-	for k, v := range e.environment {
-		item := fmt.Sprintf("%s=%s", strings.ToUpper(k), v)
-		cmd.Env = append(cmd.Env, item)
+	cmd.Env = e.processEnvironment()
+	if len(e.stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(e.stdin)
 	}
 
 	err := cmd.Start()
@@ -80,11 +101,60 @@ func (e *ELF) Deploy() error {
 }
 
 func (e *ELF) Execute(subject string, payload []byte) ([]byte, error) {
-	return nil, errors.New("ELF execution provider does not support execution via trigger subjects")
+	if !e.execPerTrigger {
+		return nil, errors.New("ELF execution provider does not support execution via trigger subjects unless executed per trigger")
+	}
+	return e.execute(context.Background(), subject, "", payload)
+}
+
+// Reports the workload's queued and active trigger executions, when executed per trigger
+func (e *ELF) TriggerExecutions() agentapi.TriggerExecutionReport {
+	return e.executions.report()
+}
+
+// Executes the binary with the trigger payload on its stdin, and the trigger subject and
+// idempotency key (if any) in its environment, returning its stdout
+func (e *ELF) execute(ctx context.Context, subject string, idempotencyKey string, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, elfExecutionTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.tmpFilename, e.argv...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &out
+	cmd.Stderr = e.stderr
+
+	cmd.Env = append(e.processEnvironment(), fmt.Sprintf("%s=%s", agentapi.NexTriggerSubjectEnv, subject))
+	if idempotencyKey != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", agentapi.NexIdempotencyKeyEnv, idempotencyKey))
+	}
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("execution timed out after %s", elfExecutionTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("execution failed: %s", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+func (e *ELF) processEnvironment() []string {
+	env := make([]string, 0, len(e.environment))
+	for k, v := range e.environment {
+		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
+	}
+	return env
 }
 
 // Undeploy the ELF binary
 func (e *ELF) Undeploy() error {
+	if e.execPerTrigger {
+		// Each execution's process exits with it, so there is nothing to stop
+		return nil
+	}
+
 	err := e.cmd.Process.Signal(os.Kill)
 	if err != nil {
 		e.fail <- true
@@ -94,6 +164,15 @@ func (e *ELF) Undeploy() error {
 	return nil
 }
 
+// Signal the ELF binary's process
+func (e *ELF) Signal(sig os.Signal) error {
+	if e.cmd == nil || e.cmd.Process == nil {
+		return errors.New("ELF binary is not running")
+	}
+
+	return e.cmd.Process.Signal(sig)
+}
+
 // Validate the underlying artifact to be a 64-bit linux native ELF
 // binary that is statically-linked
 func (e *ELF) Validate() error {
@@ -115,12 +194,14 @@ func InitNexExecutionProviderELF(params *agentapi.ExecutionProviderParams) (*ELF
 	}
 
 	return &ELF{
-		argv:        params.Argv,
-		environment: params.Environment,
-		name:        *params.WorkloadName,
-		tmpFilename: *params.TmpFilename,
-		totalBytes:  params.TotalBytes,
-		vmID:        params.VmID,
+		argv:           params.Argv,
+		environment:    params.Environment,
+		execPerTrigger: params.ExecPerTrigger,
+		name:           *params.WorkloadName,
+		stdin:          params.Stdin,
+		tmpFilename:    *params.TmpFilename,
+		totalBytes:     params.TotalBytes,
+		vmID:           params.VmID,
 
 		stderr: params.Stderr,
 		stdout: params.Stdout,
@@ -128,6 +209,8 @@ func InitNexExecutionProviderELF(params *agentapi.ExecutionProviderParams) (*ELF
 		fail: params.Fail,
 		run:  params.Run,
 		exit: params.Exit,
+
+		nc: params.NATSConn,
 	}, nil
 }
 
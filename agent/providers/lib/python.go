@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	// Interpreter with which python functions are executed, looked up on the agent's PATH. The
+	// default rootfs includes it
+	pythonInterpreter = "python3"
+
+	pythonExecutionTimeout = 5 * time.Second
+)
+
+// Python execution provider implementation. Functions are deployed as zipapps (or pex files),
+// and each trigger runs the zipapp with the trigger subject and, when present, the idempotency
+// key of the trigger message as arguments, and the trigger payload on stdin. Anything written
+// to stdout is the function's reply. Host services are available over the agent's loopback
+// endpoint, given in the process' environment
+type Python struct {
+	environment map[string]string
+	name        string
+	tmpFilename string
+	vmID        string
+
+	interpreter string
+
+	fail chan bool
+	run  chan bool
+	exit chan int
+
+	stderr io.Writer
+	stdout io.Writer
+
+	nc *nats.Conn // agent NATS connection
+
+	executions triggerExecutions
+}
+
+func (p *Python) Deploy() error {
+	err := p.executions.subscribe(p.nc, p.vmID, p.stderr, p.execute)
+	if err != nil {
+		return err
+	}
+
+	p.run <- true
+	return nil
+}
+
+// Reports the function's queued and active trigger executions
+func (p *Python) TriggerExecutions() agentapi.TriggerExecutionReport {
+	return p.executions.report()
+}
+
+func (p *Python) Execute(subject string, payload []byte) ([]byte, error) {
+	return p.execute(context.Background(), subject, "", payload)
+}
+
+func (p *Python) execute(ctx context.Context, subject string, idempotencyKey string, payload []byte) ([]byte, error) {
+	if p.interpreter == "" {
+		return nil, fmt.Errorf("invalid state for execution; no interpreter available for vm: %s", p.vmID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pythonExecutionTimeout)
+	defer cancel()
+
+	args := []string{p.tmpFilename, subject}
+	if idempotencyKey != "" {
+		args = append(args, idempotencyKey)
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.interpreter, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &out
+	cmd.Stderr = p.stderr
+
+	cmd.Env = make([]string, 0, len(p.environment))
+	for k, v := range p.environment {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", strings.ToUpper(k), v))
+	}
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("python execution timed out after %s", pythonExecutionTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("python execution failed: %s", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+func (p *Python) Undeploy() error {
+	// Each execution's process exits with it, so there is nothing to clean up
+	return nil
+}
+
+// Validate the underlying artifact to be a zipapp with a __main__.py, and that the agent has an
+// interpreter with which to execute it
+func (p *Python) Validate() error {
+	interpreter, err := exec.LookPath(pythonInterpreter)
+	if err != nil {
+		return fmt.Errorf("python runtime not available: %s", err)
+	}
+
+	// zipapps and pex files are zip archives, optionally preceded by a shebang line
+	archive, err := zip.OpenReader(p.tmpFilename)
+	if err != nil {
+		return fmt.Errorf("failed to open zipapp: %s", err)
+	}
+	defer archive.Close()
+
+	found := false
+	for _, f := range archive.File {
+		if f.Name == "__main__.py" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("zipapp has no __main__.py")
+	}
+
+	p.interpreter = interpreter
+	return nil
+}
+
+// InitNexExecutionProviderPython convenience method to initialize a Python execution provider
+func InitNexExecutionProviderPython(params *agentapi.ExecutionProviderParams) (*Python, error) {
+	if params.WorkloadName == nil {
+		return nil, errors.New("python execution provider requires a workload name parameter")
+	}
+
+	if params.TmpFilename == nil {
+		return nil, errors.New("python execution provider requires a temporary filename parameter")
+	}
+
+	return &Python{
+		environment: params.Environment,
+		name:        *params.WorkloadName,
+		tmpFilename: *params.TmpFilename,
+		vmID:        params.VmID,
+
+		stderr: params.Stderr,
+		stdout: params.Stdout,
+
+		fail: params.Fail,
+		run:  params.Run,
+		exit: params.Exit,
+
+		nc: params.NATSConn,
+	}, nil
+}
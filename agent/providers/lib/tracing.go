@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// The node propagates the trace context of each trigger in the headers of the internal trigger
+// message; the agent forwards it in the headers of any host services requests made by the
+// function, so the node can stitch those spans under the trigger's span
+var tracePropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Extracts the trace context propagated in the headers of the given trigger message
+func extractTraceContext(msg *nats.Msg) context.Context {
+	if msg.Header == nil {
+		return context.Background()
+	}
+	return tracePropagator.Extract(context.Background(), propagation.HeaderCarrier(msg.Header))
+}
+
+// Injects the given trace context into the headers of the given host services request
+func injectTraceContext(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(msg.Header))
+}
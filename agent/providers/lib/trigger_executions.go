@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Executes a function on a trigger, returning its reply
+type triggerExecutor func(ctx context.Context, subject string, idempotencyKey string, payload []byte) ([]byte, error)
+
+// Subscribes to the machine's internal trigger subject, executing each trigger message and
+// replying with the result and the execution's runtime. Failures are written to stderr
+func (t *triggerExecutions) subscribe(nc *nats.Conn, vmID string, stderr io.Writer, execute triggerExecutor) error {
+	subject := fmt.Sprintf("agentint.%s.trigger", vmID)
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		t.begin()
+		defer t.end()
+
+		startTime := time.Now()
+		val, err := execute(extractTraceContext(msg), msg.Header.Get(nexTriggerSubject), msg.Header.Get(nexIdempotencyKey), msg.Data)
+		if err != nil {
+			_, _ = stderr.Write([]byte(fmt.Sprintf("failed to execute function on trigger subject %s: %s\n", subject, err.Error())))
+			return
+		}
+
+		runtimeNanos := time.Since(startTime).Nanoseconds()
+		err = msg.RespondMsg(&nats.Msg{
+			Data: val,
+			Header: nats.Header{
+				nexRuntimeNs: []string{strconv.FormatInt(runtimeNanos, 10)},
+			},
+		})
+		if err != nil {
+			_, _ = stderr.Write([]byte(fmt.Sprintf("failed to write %d-byte response: %s\n", len(val), err.Error())))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to trigger: %s", err)
+	}
+
+	t.sub = sub
+	return nil
+}
+
+// Counts a function's trigger executions. Trigger messages are executed one at a time by the
+// subscription's handler, so those it has yet to deliver are queued in the agent
+type triggerExecutions struct {
+	sub      *nats.Subscription
+	active   int64
+	executed uint64
+}
+
+func (t *triggerExecutions) begin() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+func (t *triggerExecutions) end() {
+	atomic.AddInt64(&t.active, -1)
+	atomic.AddUint64(&t.executed, 1)
+}
+
+func (t *triggerExecutions) report() agentapi.TriggerExecutionReport {
+	report := agentapi.TriggerExecutionReport{
+		Active:   int(atomic.LoadInt64(&t.active)),
+		Executed: atomic.LoadUint64(&t.executed),
+	}
+
+	if t.sub != nil {
+		queued, _, err := t.sub.Pending()
+		if err == nil {
+			report.Queued = queued
+		}
+	}
+
+	return report
+}
@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -37,8 +38,14 @@ const (
 	hostServicesMessagingRequestTimeout     = time.Millisecond * 500
 	hostServicesMessagingRequestManyTimeout = time.Millisecond * 3000
 
+	hostServicesSecretsObjectName      = "secrets"
+	hostServicesSecretsGetFunctionName = "get"
+
+	hostServicesSecretsGetTimeout = time.Second * 10
+
 	nexTriggerSubject = "x-nex-trigger-subject"
 	nexRuntimeNs      = "x-nex-runtime-ns"
+	nexIdempotencyKey = "x-nex-idempotency-key"
 
 	messageSubject = "x-subject"
 
@@ -67,6 +74,11 @@ type V8 struct {
 
 	nc *nats.Conn // agent NATS connection
 
+	// Host services exposed by the workload's sandbox profile; all of them when nil
+	hostServices *agentapi.HostServicesPolicy
+
+	executions triggerExecutions
+
 	ctx   *v8.Context // default context for internal use only
 	iso   *v8.Isolate
 	ubs   *v8.UnboundScript
@@ -80,9 +92,12 @@ func (v *V8) Deploy() error {
 	}
 
 	subject := fmt.Sprintf("agentint.%s.trigger", v.vmID)
-	_, err := v.nc.Subscribe(subject, func(msg *nats.Msg) {
+	sub, err := v.nc.Subscribe(subject, func(msg *nats.Msg) {
+		v.executions.begin()
+		defer v.executions.end()
+
 		startTime := time.Now()
-		val, err := v.Execute(msg.Header.Get(nexTriggerSubject), msg.Data)
+		val, err := v.execute(extractTraceContext(msg), msg.Header.Get(nexTriggerSubject), msg.Header.Get(nexIdempotencyKey), msg.Data)
 		if err != nil {
 			_, _ = v.stderr.Write([]byte(fmt.Sprintf("failed to execute function on trigger subject %s: %s", subject, err.Error())))
 			return
@@ -103,20 +118,34 @@ func (v *V8) Deploy() error {
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to trigger: %s", err)
 	}
+	v.executions.sub = sub
 
 	v.run <- true
 	return nil
 }
 
+// Reports the function's queued and active trigger executions
+func (v *V8) TriggerExecutions() agentapi.TriggerExecutionReport {
+	return v.executions.report()
+}
+
 // Trigger execution of the deployed function; expects a `Validate` to have succeeded and `ubs` to be non-nil.
 // The executed function can optionally return a value, in which case it will be deemed a reply and returned
 // to the caller. In the case of a nil or empty value returned by the function, no reply will be sent.
 func (v *V8) Execute(subject string, payload []byte) ([]byte, error) {
+	return v.execute(context.Background(), subject, "", payload)
+}
+
+// Executes the deployed function, additionally passing the idempotency key of the trigger message
+// (if any) as the third argument. Keys are only present for workloads with at-least-once trigger
+// delivery, where the same message may be delivered to the function more than once. The given
+// trace context is propagated to any host services the function calls
+func (v *V8) execute(traceCtx context.Context, subject string, idempotencyKey string, payload []byte) ([]byte, error) {
 	if v.ubs == nil {
 		return nil, fmt.Errorf("invalid state for execution; no compiled code available for vm: %s", v.name)
 	}
 
-	ctx, err := v.newV8Context()
+	ctx, err := v.newV8Context(traceCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize context in vm: %s", err.Error())
 	}
@@ -149,7 +178,17 @@ func (v *V8) Execute(subject string, payload []byte) ([]byte, error) {
 			return
 		}
 
-		val, err = fn.Call(ctx.Global(), argv1, argv2)
+		argv := []v8.Valuer{argv1, argv2}
+		if idempotencyKey != "" {
+			argv3, err := v8.NewValue(ctx.Isolate(), idempotencyKey)
+			if err != nil {
+				errs <- err
+				return
+			}
+			argv = append(argv, argv3)
+		}
+
+		val, err = fn.Call(ctx.Global(), argv...)
 		if err != nil {
 			errs <- err
 			return
@@ -205,16 +244,34 @@ func (v *V8) Validate() error {
 		return fmt.Errorf("failed to stat open source file: %s", err)
 	}
 
-	if fi.Size() > v8MaxFileSizeBytes {
-		return fmt.Errorf("source file (%d bytes) exceeds maximum of %d bytes", fi.Size(), v8MaxFileSizeBytes)
+	bundle, err := isModuleBundle(f)
+	if err != nil {
+		return fmt.Errorf("failed to read source: %s", err)
 	}
 
-	src, err := os.ReadFile(v.tmpFilename)
-	if err != nil {
-		return fmt.Errorf("failed to open source for validation: %s", err)
+	var src string
+	if bundle {
+		if fi.Size() > v8MaxBundleSizeBytes {
+			return fmt.Errorf("module bundle (%d bytes) exceeds maximum of %d bytes", fi.Size(), v8MaxBundleSizeBytes)
+		}
+
+		src, err = linkModuleBundle(v.tmpFilename)
+		if err != nil {
+			return fmt.Errorf("failed to link module bundle: %s", err)
+		}
+	} else {
+		if fi.Size() > v8MaxFileSizeBytes {
+			return fmt.Errorf("source file (%d bytes) exceeds maximum of %d bytes", fi.Size(), v8MaxFileSizeBytes)
+		}
+
+		raw, err := os.ReadFile(v.tmpFilename)
+		if err != nil {
+			return fmt.Errorf("failed to open source for validation: %s", err)
+		}
+		src = string(raw)
 	}
 
-	v.ubs, err = v.iso.CompileUnboundScript(string(src), v.tmpFilename, v8.CompileOptions{})
+	v.ubs, err = v.iso.CompileUnboundScript(src, v.tmpFilename, v8.CompileOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to compile source for execution: %s", err)
 	}
@@ -234,10 +291,10 @@ func (v *V8) Validate() error {
 	return nil
 }
 
-func (v *V8) newV8Context() (*v8.Context, error) {
+func (v *V8) newV8Context(traceCtx context.Context) (*v8.Context, error) {
 	global := v8.NewObjectTemplate(v.iso)
 
-	hostServices, err := v.newHostServicesTemplate()
+	hostServices, err := v.newHostServicesTemplate(traceCtx)
 	if err != nil {
 		return nil, err
 	}
@@ -250,6 +307,15 @@ func (v *V8) newV8Context() (*v8.Context, error) {
 	return v8.NewContext(v.iso, global), nil
 }
 
+// Makes a host services request via internal NATS, propagating the given trace context
+func (v *V8) hostServicesRequest(traceCtx context.Context, subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	injectTraceContext(traceCtx, msg)
+
+	return v.nc.RequestMsg(msg, timeout)
+}
+
 // agentint.{vmID}.rpc.{namespace}.{workload}.kv.{method}
 func (v *V8) keyValueServiceSubject(method string) string {
 	return fmt.Sprintf("agentint.%s.rpc.%s.%s.kv.%s", v.vmID, v.namespace, v.name, method)
@@ -260,23 +326,87 @@ func (v *V8) messagingServiceSubject(method string) string {
 	return fmt.Sprintf("agentint.%s.rpc.%s.%s.messaging.%s", v.vmID, v.namespace, v.name, method)
 }
 
-func (v *V8) newHostServicesTemplate() (*v8.ObjectTemplate, error) {
+// agentint.{vmID}.rpc.{namespace}.{workload}.secrets.{method}
+func (v *V8) secretsServiceSubject(method string) string {
+	return fmt.Sprintf("agentint.%s.rpc.%s.%s.secrets.%s", v.vmID, v.namespace, v.name, method)
+}
+
+func (v *V8) newHostServicesTemplate(traceCtx context.Context) (*v8.ObjectTemplate, error) {
 	hostServices := v8.NewObjectTemplate(v.iso)
 
-	err := hostServices.Set(hostServicesKVObjectName, v.newKeyValueObjectTemplate())
-	if err != nil {
-		return nil, err
+	if v.hostServices.Exposes(hostServicesKVObjectName) {
+		err := hostServices.Set(hostServicesKVObjectName, v.newKeyValueObjectTemplate(traceCtx))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = hostServices.Set(hostServicesMessagingObjectName, v.newMessagingObjectTemplate())
-	if err != nil {
-		return nil, err
+	if v.hostServices.Exposes(hostServicesMessagingObjectName) {
+		err := hostServices.Set(hostServicesMessagingObjectName, v.newMessagingObjectTemplate(traceCtx))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v.hostServices.Exposes(hostServicesSecretsObjectName) {
+		err := hostServices.Set(hostServicesSecretsObjectName, v.newSecretsObjectTemplate(traceCtx))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return hostServices, nil
 }
 
-func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
+func (v *V8) newSecretsObjectTemplate(traceCtx context.Context) *v8.ObjectTemplate {
+	secrets := v8.NewObjectTemplate(v.iso)
+
+	_ = secrets.Set(hostServicesSecretsGetFunctionName, v8.NewFunctionTemplate(v.iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		args := info.Args()
+		if len(args) != 1 {
+			val, _ := v8.NewValue(v.iso, "name is required")
+			return v.iso.ThrowException(val)
+		}
+
+		name := args[0].String()
+
+		req, _ := json.Marshal(&agentapi.HostServicesSecretRequest{
+			Name: &name,
+		})
+
+		resp, err := v.hostServicesRequest(traceCtx, v.secretsServiceSubject(hostServicesSecretsGetFunctionName), req, hostServicesSecretsGetTimeout)
+		if err != nil {
+			val, _ := v8.NewValue(v.iso, err.Error())
+			return v.iso.ThrowException(val)
+		}
+
+		var secretResp struct {
+			agentapi.HostServicesSecretRequest
+			Error *string `json:"error,omitempty"`
+		}
+		err = json.Unmarshal(resp.Data, &secretResp)
+		if err != nil {
+			val, _ := v8.NewValue(v.iso, err.Error())
+			return v.iso.ThrowException(val)
+		}
+
+		if secretResp.Error != nil || secretResp.Value == nil {
+			msg := "failed to resolve secret"
+			if secretResp.Error != nil {
+				msg = *secretResp.Error
+			}
+			val, _ := v8.NewValue(v.iso, msg)
+			return v.iso.ThrowException(val)
+		}
+
+		val, _ := v8.NewValue(v.iso, *secretResp.Value)
+		return val
+	}))
+
+	return secrets
+}
+
+func (v *V8) newKeyValueObjectTemplate(traceCtx context.Context) *v8.ObjectTemplate {
 	kv := v8.NewObjectTemplate(v.iso)
 
 	_ = kv.Set(hostServicesKVGetFunctionName, v8.NewFunctionTemplate(v.iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
@@ -292,7 +422,7 @@ func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
 			Key: &key,
 		})
 
-		resp, err := v.nc.Request(v.keyValueServiceSubject(hostServicesKVGetFunctionName), req, hostServicesKVGetTimeout)
+		resp, err := v.hostServicesRequest(traceCtx, v.keyValueServiceSubject(hostServicesKVGetFunctionName), req, hostServicesKVGetTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
 			return v.iso.ThrowException(val)
@@ -336,7 +466,7 @@ func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
 			Value: &val,
 		})
 
-		resp, err := v.nc.Request(v.keyValueServiceSubject(hostServicesKVSetFunctionName), req, hostServicesKVSetTimeout)
+		resp, err := v.hostServicesRequest(traceCtx, v.keyValueServiceSubject(hostServicesKVSetFunctionName), req, hostServicesKVSetTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
 			return v.iso.ThrowException(val)
@@ -370,7 +500,7 @@ func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
 			Key: &key,
 		})
 
-		resp, err := v.nc.Request(v.keyValueServiceSubject(hostServicesKVDeleteFunctionName), req, hostServicesKVDeleteTimeout)
+		resp, err := v.hostServicesRequest(traceCtx, v.keyValueServiceSubject(hostServicesKVDeleteFunctionName), req, hostServicesKVDeleteTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
 			return v.iso.ThrowException(val)
@@ -394,7 +524,7 @@ func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
 	_ = kv.Set(hostServicesKVKeysFunctionName, v8.NewFunctionTemplate(v.iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
 		req, _ := json.Marshal(map[string]interface{}{})
 
-		resp, err := v.nc.Request(v.keyValueServiceSubject(hostServicesKVKeysFunctionName), req, hostServicesKVKeysTimeout)
+		resp, err := v.hostServicesRequest(traceCtx, v.keyValueServiceSubject(hostServicesKVKeysFunctionName), req, hostServicesKVKeysTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
 			return v.iso.ThrowException(val)
@@ -412,7 +542,7 @@ func (v *V8) newKeyValueObjectTemplate() *v8.ObjectTemplate {
 	return kv
 }
 
-func (v *V8) newMessagingObjectTemplate() *v8.ObjectTemplate {
+func (v *V8) newMessagingObjectTemplate(traceCtx context.Context) *v8.ObjectTemplate {
 	messaging := v8.NewObjectTemplate(v.iso)
 
 	_ = messaging.Set(hostServicesMessagingPublishFunctionName, v8.NewFunctionTemplate(v.iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
@@ -429,6 +559,7 @@ func (v *V8) newMessagingObjectTemplate() *v8.ObjectTemplate {
 		msg.Header.Add(messageSubject, subject)
 		msg.Data = []byte(payload)
 
+		injectTraceContext(traceCtx, msg)
 		resp, err := v.nc.RequestMsg(msg, hostServicesMessagingPublishTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
@@ -459,6 +590,7 @@ func (v *V8) newMessagingObjectTemplate() *v8.ObjectTemplate {
 		msg.Header.Add(messageSubject, subject)
 		msg.Data = []byte(payload)
 
+		injectTraceContext(traceCtx, msg)
 		resp, err := v.nc.RequestMsg(msg, hostServicesMessagingRequestTimeout)
 		if err != nil {
 			val, _ := v8.NewValue(v.iso, err.Error())
@@ -513,6 +645,7 @@ func (v *V8) newMessagingObjectTemplate() *v8.ObjectTemplate {
 		_ = v.nc.Flush()
 
 		// publish the requestMany request to the target subject
+		injectTraceContext(traceCtx, msg)
 		err = v.nc.PublishMsg(msg)
 		if err != nil {
 			_, _ = v.stderr.Write([]byte(fmt.Sprintf("failed to publish message: %s", err.Error())))
@@ -580,6 +713,8 @@ func InitNexExecutionProviderV8(params *agentapi.ExecutionProviderParams) (*V8,
 		totalBytes:  0, // FIXME
 		vmID:        params.VmID,
 
+		hostServices: params.HostServices,
+
 		stderr: params.Stderr,
 		stdout: params.Stdout,
 
@@ -0,0 +1,512 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The v8 runtime only compiles scripts, so a module graph deployed as a tarball of ES modules
+// (e.g. as produced by `npm pack`, with its dependencies beneath node_modules) is linked into a
+// single script before it's compiled. Each module is wrapped in a function which is given its
+// exports object and a function importing other modules, and its import and export declarations
+// are rewritten to use them. The linked script evaluates to the default export of the bundle's
+// entry module, which must be the function to execute, as for a single script
+
+const (
+	v8MaxBundleSizeBytes      = int64(4 * 1024 * 1024)  // of the (compressed) tarball
+	v8MaxBundleExtractedBytes = int64(32 * 1024 * 1024) // of the modules within it
+
+	// Directory beneath which `npm pack` places a package's files
+	npmPackDir = "package"
+)
+
+var (
+	// import "specifier"
+	sideEffectImportRe = regexp.MustCompile(`(?m)^[ \t]*import\s*["']([^"'\n]+)["'][ \t]*;?`)
+	// import clause from "specifier"
+	importRe = regexp.MustCompile(`(?m)^[ \t]*import\s+([^"';]+?)\s*from\s*["']([^"'\n]+)["'][ \t]*;?`)
+	// export * from "specifier", export * as name from "specifier", export { a, b as c } from "specifier"
+	reexportRe = regexp.MustCompile(`(?m)^[ \t]*export\s*(\*(?:\s*as\s+[\w$]+)?|\{[^}]*\})\s*from\s*["']([^"'\n]+)["'][ \t]*;?`)
+	// export { a, b as c }
+	exportListRe = regexp.MustCompile(`(?m)^[ \t]*export\s*\{([^}]*)\}[ \t]*;?`)
+	// export default function name, export default class name
+	exportDefaultDeclRe = regexp.MustCompile(`(?m)^([ \t]*)export\s+default\s+((?:async\s+)?function(?:\s*\*\s*|\s+)([\w$]+)|class\s+([\w$]+))`)
+	// export default expression
+	exportDefaultRe = regexp.MustCompile(`(?m)^([ \t]*)export\s+default\s+`)
+	// export function name, export class name, export const name
+	exportDeclRe = regexp.MustCompile(`(?m)^([ \t]*)export\s+((?:async\s+)?function(?:\s*\*\s*|\s+)([\w$]+)|class\s+([\w$]+)|(?:const|let|var)\s+([\w$]+))`)
+	// any export declaration left once the supported forms have been rewritten
+	unsupportedExportRe = regexp.MustCompile(`(?m)^[ \t]*export\s`)
+)
+
+const moduleRuntime = `(() => {
+"use strict";
+const __nex_cache = {};
+const __nex_loading = {};
+function __nex_import(id) {
+	if (id in __nex_cache) {
+		if (__nex_loading[id]) {
+			throw new Error("circular import of " + id + " is not supported");
+		}
+		return __nex_cache[id];
+	}
+	const exports = {};
+	__nex_cache[id] = exports;
+	__nex_loading[id] = true;
+	__nex_modules[id](exports, __nex_import, __nex_reexport);
+	delete __nex_loading[id];
+	return exports;
+}
+function __nex_reexport(target, source) {
+	for (const key of Object.keys(source)) {
+		if (key !== "default" && !(key in target)) {
+			Object.defineProperty(target, key, { enumerable: true, get: () => source[key] });
+		}
+	}
+}
+`
+
+// Returns whether the file is a tarball, optionally gzipped, rather than a single script
+func isModuleBundle(f *os.File) (bool, error) {
+	header := make([]byte, 512)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	header = header[:n]
+
+	if bytes.HasPrefix(header, []byte{0x1f, 0x8b}) {
+		return true, nil
+	}
+	return len(header) >= 262 && string(header[257:262]) == "ustar", nil
+}
+
+// Links the module graph in the tarball at the given path into a single script, starting from
+// the entry module named by the bundle's package.json (its exports, module or main field), or
+// index.js
+func linkModuleBundle(filename string) (string, error) {
+	files, err := readModuleBundle(filename)
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := resolvePackage(files, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve entry module: %s", err)
+	}
+
+	linked := map[string]string{}
+	pending := []string{entry}
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if _, ok := linked[id]; ok {
+			continue
+		}
+
+		wrapped, imports, err := wrapModule(files, id)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", id, err)
+		}
+		linked[id] = wrapped
+		pending = append(pending, imports...)
+	}
+
+	ids := make([]string, 0, len(linked))
+	for id := range linked {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var script strings.Builder
+	script.WriteString(moduleRuntime)
+	script.WriteString("const __nex_modules = {\n")
+	for _, id := range ids {
+		fmt.Fprintf(&script, "%q: %s,\n", id, linked[id])
+	}
+	script.WriteString("};\n")
+	fmt.Fprintf(&script, "return __nex_import(%q).default;\n})()", entry)
+
+	return script.String(), nil
+}
+
+// Reads the modules and package manifests in the tarball, keyed by their path within it. The
+// directory into which `npm pack` places a package's files is stripped
+func readModuleBundle(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped, _ := isGzipped(f); gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	files := map[string]string{}
+	remaining := v8MaxBundleExtractedBytes
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		switch path.Ext(name) {
+		case ".js", ".mjs", ".json":
+		default:
+			continue
+		}
+		if path.Ext(name) == ".json" && path.Base(name) != "package.json" {
+			continue
+		}
+
+		if hdr.Size > remaining {
+			return nil, fmt.Errorf("bundle exceeds maximum of %d extracted bytes", v8MaxBundleExtractedBytes)
+		}
+		remaining -= hdr.Size
+
+		content, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from bundle: %s", name, err)
+		}
+		files[name] = string(content)
+	}
+
+	if _, ok := files["package.json"]; !ok {
+		if _, ok := files[path.Join(npmPackDir, "package.json")]; ok {
+			stripped := map[string]string{}
+			for name, content := range files {
+				if rel, ok := strings.CutPrefix(name, npmPackDir+"/"); ok {
+					stripped[rel] = content
+				}
+			}
+			files = stripped
+		}
+	}
+
+	return files, nil
+}
+
+func isGzipped(f *os.File) (bool, error) {
+	magic := make([]byte, 2)
+	_, err := f.ReadAt(magic, 0)
+	if err != nil {
+		return false, err
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
+
+// Wraps the module in a function which evaluates it, returning the IDs of the modules it imports
+func wrapModule(files map[string]string, id string) (string, []string, error) {
+	src := files[id]
+	dir := path.Dir(id)
+
+	var imports []string
+	var prologue strings.Builder
+	bindings := 0
+
+	// binds the imported module to a new variable, returning its name
+	importModule := func(body *strings.Builder, specifier string) (string, error) {
+		resolved, err := resolveImport(files, dir, specifier)
+		if err != nil {
+			return "", err
+		}
+		imports = append(imports, resolved)
+		bindings++
+		binding := fmt.Sprintf("__nex_m%d", bindings)
+		fmt.Fprintf(body, "const %s = __nex_import(%q);", binding, resolved)
+		return binding, nil
+	}
+	export := func(name string, expr string) {
+		fmt.Fprintf(&prologue, "Object.defineProperty(__nex_exports, %q, { enumerable: true, get: () => %s });\n", name, expr)
+	}
+
+	src, err := replaceAllSubmatchFunc(reexportRe, src, func(match []string) (string, error) {
+		var body strings.Builder
+		binding, err := importModule(&body, match[2])
+		if err != nil {
+			return "", err
+		}
+
+		clause := match[1]
+		if strings.HasPrefix(clause, "*") {
+			if _, name, ok := strings.Cut(clause, "as"); ok {
+				export(strings.TrimSpace(name), binding)
+			} else {
+				fmt.Fprintf(&body, " __nex_reexport(__nex_exports, %s);", binding)
+			}
+			return body.String(), nil
+		}
+
+		for _, spec := range splitSpecifiers(clause) {
+			export(spec.local, fmt.Sprintf("%s[%q]", binding, spec.imported))
+		}
+		return body.String(), nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	src, err = replaceAllSubmatchFunc(importRe, src, func(match []string) (string, error) {
+		var body strings.Builder
+		binding, err := importModule(&body, match[2])
+		if err != nil {
+			return "", err
+		}
+
+		clause := strings.TrimSpace(match[1])
+		if def, rest, ok := strings.Cut(clause, ","); ok && !strings.HasPrefix(clause, "{") {
+			fmt.Fprintf(&body, " const %s = %s.default;", strings.TrimSpace(def), binding)
+			clause = strings.TrimSpace(rest)
+		}
+
+		switch {
+		case strings.HasPrefix(clause, "*"):
+			_, name, ok := strings.Cut(clause, "as")
+			if !ok {
+				return "", fmt.Errorf("invalid namespace import: %s", clause)
+			}
+			fmt.Fprintf(&body, " const %s = %s;", strings.TrimSpace(name), binding)
+		case strings.HasPrefix(clause, "{"):
+			for _, spec := range splitSpecifiers(clause) {
+				fmt.Fprintf(&body, " const %s = %s[%q];", spec.local, binding, spec.imported)
+			}
+		default:
+			fmt.Fprintf(&body, " const %s = %s.default;", clause, binding)
+		}
+		return body.String(), nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	src, err = replaceAllSubmatchFunc(sideEffectImportRe, src, func(match []string) (string, error) {
+		var body strings.Builder
+		_, err := importModule(&body, match[1])
+		return body.String(), err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	src, _ = replaceAllSubmatchFunc(exportListRe, src, func(match []string) (string, error) {
+		for _, spec := range splitSpecifiers(match[1]) {
+			export(spec.local, spec.imported)
+		}
+		return "", nil
+	})
+
+	src, _ = replaceAllSubmatchFunc(exportDefaultDeclRe, src, func(match []string) (string, error) {
+		export("default", match[3]+match[4])
+		return match[1] + match[2], nil
+	})
+	src = exportDefaultRe.ReplaceAllString(src, "${1}__nex_exports.default = ")
+
+	src, _ = replaceAllSubmatchFunc(exportDeclRe, src, func(match []string) (string, error) {
+		export(match[3]+match[4]+match[5], match[3]+match[4]+match[5])
+		return match[1] + match[2], nil
+	})
+
+	if loc := unsupportedExportRe.FindStringIndex(src); loc != nil {
+		line := strings.SplitN(src[loc[0]:], "\n", 2)[0]
+		return "", nil, fmt.Errorf("unsupported export declaration: %s", strings.TrimSpace(line))
+	}
+
+	return fmt.Sprintf("function (__nex_exports, __nex_import, __nex_reexport) {\n%s%s\n}", prologue.String(), src), imports, nil
+}
+
+type importSpecifier struct {
+	imported string
+	local    string
+}
+
+// Splits an import or export list, e.g. { a, b as c }, into its specifiers
+func splitSpecifiers(list string) []importSpecifier {
+	list = strings.Trim(strings.TrimSpace(list), "{}")
+
+	var specs []importSpecifier
+	for _, spec := range strings.Split(list, ",") {
+		fields := strings.Fields(spec)
+		switch {
+		case len(fields) == 1:
+			specs = append(specs, importSpecifier{imported: fields[0], local: fields[0]})
+		case len(fields) == 3 && fields[1] == "as":
+			specs = append(specs, importSpecifier{imported: fields[0], local: fields[2]})
+		}
+	}
+	return specs
+}
+
+// Resolves the import of the given specifier by a module in the given directory. Relative
+// specifiers are resolved to the module's path, with a .js or .mjs extension or as a directory
+// index if need be; others name packages beneath the nearest node_modules directory
+func resolveImport(files map[string]string, dir string, specifier string) (string, error) {
+	if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") || strings.HasPrefix(specifier, "/") {
+		target := path.Clean(path.Join(dir, specifier))
+		if strings.HasPrefix(specifier, "/") {
+			target = path.Clean(strings.TrimPrefix(specifier, "/"))
+		}
+		if resolved, ok := resolveFile(files, target); ok {
+			return resolved, nil
+		}
+		return "", fmt.Errorf("cannot resolve import of %s", specifier)
+	}
+
+	name, subpath := specifier, ""
+	segments := strings.SplitN(specifier, "/", 3)
+	if strings.HasPrefix(specifier, "@") && len(segments) > 2 {
+		name, subpath = segments[0]+"/"+segments[1], segments[2]
+	} else if !strings.HasPrefix(specifier, "@") && len(segments) > 1 {
+		name, subpath = segments[0], strings.Join(segments[1:], "/")
+	}
+
+	for current := dir; ; current = path.Dir(current) {
+		pkgDir := path.Join(current, "node_modules", name)
+		if resolved, err := resolvePackageSubpath(files, pkgDir, subpath); err == nil {
+			return resolved, nil
+		}
+		if current == "." || current == "/" {
+			break
+		}
+	}
+	return "", fmt.Errorf("cannot resolve import of %s; is it in the bundle's node_modules?", specifier)
+}
+
+func resolvePackageSubpath(files map[string]string, pkgDir string, subpath string) (string, error) {
+	if subpath == "" {
+		return resolvePackage(files, pkgDir)
+	}
+
+	manifest := readPackageManifest(files, pkgDir)
+	if exports, ok := manifest.Exports.(map[string]interface{}); ok {
+		if target := exportTarget(exports["./"+subpath]); target != "" {
+			if resolved, ok := resolveFile(files, path.Join(pkgDir, target)); ok {
+				return resolved, nil
+			}
+		}
+	}
+	if resolved, ok := resolveFile(files, path.Join(pkgDir, subpath)); ok {
+		return resolved, nil
+	}
+	return "", fmt.Errorf("cannot resolve %s in %s", subpath, pkgDir)
+}
+
+type packageManifest struct {
+	Exports interface{} `json:"exports"`
+	Module  string      `json:"module"`
+	Main    string      `json:"main"`
+}
+
+func readPackageManifest(files map[string]string, pkgDir string) packageManifest {
+	var manifest packageManifest
+	if raw, ok := files[path.Join(pkgDir, "package.json")]; ok {
+		_ = json.Unmarshal([]byte(raw), &manifest)
+	}
+	return manifest
+}
+
+// Resolves the entry module of the package in the given directory
+func resolvePackage(files map[string]string, pkgDir string) (string, error) {
+	manifest := readPackageManifest(files, pkgDir)
+
+	candidates := []string{}
+	switch exports := manifest.Exports.(type) {
+	case string:
+		candidates = append(candidates, exports)
+	case map[string]interface{}:
+		if main, ok := exports["."]; ok {
+			candidates = append(candidates, exportTarget(main))
+		} else {
+			candidates = append(candidates, exportTarget(exports))
+		}
+	}
+	candidates = append(candidates, manifest.Module, manifest.Main, "index")
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if resolved, ok := resolveFile(files, path.Join(pkgDir, candidate)); ok {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("no entry module found in %s", path.Join(pkgDir, "package.json"))
+}
+
+// Returns the target of a package export, preferring the conditions under which ES modules are
+// imported
+func exportTarget(export interface{}) string {
+	switch target := export.(type) {
+	case string:
+		return target
+	case map[string]interface{}:
+		for _, condition := range []string{"import", "module", "default"} {
+			if resolved := exportTarget(target[condition]); resolved != "" {
+				return resolved
+			}
+		}
+	}
+	return ""
+}
+
+func resolveFile(files map[string]string, target string) (string, bool) {
+	target = path.Clean(target)
+	for _, candidate := range []string{target, target + ".js", target + ".mjs", path.Join(target, "index.js"), path.Join(target, "index.mjs")} {
+		if path.Ext(candidate) == ".json" {
+			continue
+		}
+		if _, ok := files[candidate]; ok {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Replaces each match of the expression with the result of the given function, which is passed
+// the match and its submatches
+func replaceAllSubmatchFunc(re *regexp.Regexp, src string, repl func([]string) (string, error)) (string, error) {
+	var result strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(src, -1) {
+		match := make([]string, len(loc)/2)
+		for i := range match {
+			if loc[2*i] >= 0 {
+				match[i] = src[loc[2*i]:loc[2*i+1]]
+			}
+		}
+
+		replacement, err := repl(match)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(src[last:loc[0]])
+		result.WriteString(replacement)
+		last = loc[1]
+	}
+	result.WriteString(src[last:])
+	return result.String(), nil
+}
@@ -28,12 +28,17 @@ type Wasm struct {
 	exit chan int
 
 	nc *nats.Conn // agent NATS connection
+
+	executions triggerExecutions
 }
 
 func (e *Wasm) Deploy() error {
 	subject := fmt.Sprintf("agentint.%s.trigger", e.vmID)
-	_, err := e.nc.Subscribe(subject, func(msg *nats.Msg) {
-		val, err := e.Execute(msg.Header.Get("x-nex-trigger-subject"), msg.Data)
+	sub, err := e.nc.Subscribe(subject, func(msg *nats.Msg) {
+		e.executions.begin()
+		defer e.executions.end()
+
+		val, err := e.execute(extractTraceContext(msg), msg.Header.Get("x-nex-trigger-subject"), msg.Header.Get("x-nex-idempotency-key"), msg.Data)
 		if err != nil {
 			// TODO-- propagate this error to agent logs
 			return
@@ -46,14 +51,24 @@ func (e *Wasm) Deploy() error {
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to trigger: %s", err)
 	}
+	e.executions.sub = sub
 
 	e.run <- true
 	return nil
 }
 
+// Reports the function's queued and active trigger executions
+func (e *Wasm) TriggerExecutions() agentapi.TriggerExecutionReport {
+	return e.executions.report()
+}
+
 func (e *Wasm) Execute(subject string, payload []byte) ([]byte, error) {
-	ctx := context.Background()
+	return e.execute(context.Background(), subject, "", payload)
+}
 
+// Executes the module, passing the trigger subject and, when present, the idempotency key of
+// the trigger message as arguments
+func (e *Wasm) execute(ctx context.Context, subject string, idempotencyKey string, payload []byte) ([]byte, error) {
 	out := newStdOutBuf()
 	in := newStdInBuf()
 	in.Reset(payload)
@@ -62,7 +77,7 @@ func (e *Wasm) Execute(subject string, payload []byte) ([]byte, error) {
 	cfg := e.runtimeConfig.
 		WithStdin(in).
 		WithStdout(out).
-		WithArgs("nexfunction", subject)
+		WithArgs(wasmArgs(subject, idempotencyKey)...)
 
 	_, err := e.runtime.InstantiateModule(ctx, e.module, cfg)
 	if err != nil {
@@ -80,6 +95,14 @@ func (e *Wasm) Execute(subject string, payload []byte) ([]byte, error) {
 	return nil, errors.New("unknown")
 }
 
+func wasmArgs(subject string, idempotencyKey string) []string {
+	args := []string{"nexfunction", subject}
+	if idempotencyKey != "" {
+		args = append(args, idempotencyKey)
+	}
+	return args
+}
+
 func (e *Wasm) Undeploy() error {
 	// We shouldn't have to do anything here since the wasm "owns" no resources
 	return nil
@@ -0,0 +1,7 @@
+package nexagent
+
+import "syscall"
+
+func reboot() error {
+	return syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART)
+}
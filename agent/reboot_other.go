@@ -0,0 +1,11 @@
+//go:build !linux
+
+package nexagent
+
+import "errors"
+
+// There is no firecracker VM to reboot outside of Linux; agents on other
+// platforms only ever run as local processes
+func reboot() error {
+	return errors.New("reboot is not supported on this platform")
+}
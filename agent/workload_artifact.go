@@ -0,0 +1,101 @@
+package nexagent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// How long the agent waits for the node to serve each chunk of the workload artifact
+const artifactChunkTimeout = 10 * time.Second
+
+// Reads the artifact of the workload deployed to the agent's machine from the node, a chunk at
+// a time
+type artifactReader struct {
+	nc      *nats.Conn
+	subject string
+
+	offset int64
+	size   int64
+	chunk  []byte
+	eof    bool
+}
+
+// Opens the workload artifact, requesting its first chunk, which carries its size
+func (a *Agent) openWorkloadArtifact() (*artifactReader, error) {
+	r := &artifactReader{
+		nc:      a.nc,
+		subject: agentapi.ArtifactSubject(*a.md.VmID),
+	}
+
+	err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *artifactReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// Requests the chunk following those read so far
+func (r *artifactReader) next() error {
+	req, _ := json.Marshal(&agentapi.ArtifactChunkRequest{Offset: r.offset})
+	resp, err := r.nc.Request(r.subject, req, artifactChunkTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to request workload artifact: %s", err)
+	}
+
+	if reason := resp.Header.Get(agentapi.ArtifactErrorHeader); reason != "" {
+		return errors.New(reason)
+	}
+
+	size, err := strconv.ParseInt(resp.Header.Get(agentapi.ArtifactSizeHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid workload artifact size: %s", err)
+	}
+
+	r.size = size
+	r.chunk = resp.Data
+	r.offset += int64(len(resp.Data))
+	r.eof = len(resp.Data) < agentapi.ArtifactChunkSize || r.offset >= size
+	return nil
+}
+
+// Writes the workload artifact to the given path
+func (a *Agent) writeWorkloadArtifact(path string) error {
+	obj, err := a.openWorkloadArtifact()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, obj)
+	return err
+}
@@ -0,0 +1,334 @@
+package nexagent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	// Lines longer than this are forwarded in pieces
+	maxOutputLineBytes = 16 * 1024
+	// A partial line is forwarded once the workload has written nothing more for this long
+	outputIdleFlushInterval = time.Second
+	// How long the workload's writes may block while the node falls behind before lines are dropped
+	outputBackpressureTimeout = time.Second
+
+	// The workload's output is also kept in the machine, in a file rotated once it reaches this
+	// size, keeping this many rotated files
+	workloadOutputDir      = "/home/nex/logs"
+	workloadOutputMaxBytes = 1024 * 1024
+	workloadOutputMaxFiles = 3
+)
+
+// Captures the output of a workload, forwarding each line to the node as a log entry as it's
+// written, subject to the workload's log limits. Lines beyond the limits, or which the node
+// can't keep up with, are dropped and counted, but all of the output is kept in a rotated file
+// in the machine
+type workloadOutput struct {
+	name     string
+	minLevel agentapi.LogLevel
+	logs     chan *agentapi.LogEntry
+
+	mutex   sync.Mutex
+	limiter *tokenBucket
+	dropped int
+	file    *rotatingFile
+
+	streams []*outputStream
+}
+
+func newWorkloadOutput(name string, limits *agentapi.LogLimits, logs chan *agentapi.LogEntry) *workloadOutput {
+	rate, burst := limits.Rate()
+	o := &workloadOutput{
+		name:     name,
+		minLevel: limits.MinLevel(),
+		logs:     logs,
+		limiter:  newTokenBucket(rate, burst),
+	}
+
+	file, err := openRotatingFile(filepath.Join(workloadOutputDir, name+".log"), workloadOutputMaxBytes, workloadOutputMaxFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open workload output file: %s\n", err)
+	} else {
+		o.file = file
+	}
+
+	return o
+}
+
+// Returns a writer for one of the workload's output streams, whose lines are logged at the given
+// level. Process output arrives in arbitrary chunks, so it's split into lines; otherwise each
+// write is forwarded as it is
+func (o *workloadOutput) stream(level agentapi.LogLevel, lines bool) io.Writer {
+	s := &outputStream{output: o, level: level, lines: lines}
+	o.streams = append(o.streams, s)
+	return s
+}
+
+// Forwards any partial lines the workload left unterminated and reports lines dropped since
+// the last report. Called once the workload has exited
+func (o *workloadOutput) close() {
+	for _, s := range o.streams {
+		s.flush()
+	}
+
+	o.mutex.Lock()
+	notice := o.dropNotice()
+	if o.file != nil {
+		o.file.close()
+		o.file = nil
+	}
+	o.mutex.Unlock()
+
+	if notice != nil {
+		o.send(notice)
+	}
+}
+
+func (o *workloadOutput) emit(level agentapi.LogLevel, text string) {
+	o.mutex.Lock()
+	if o.file != nil {
+		err := o.file.write([]byte(text + "\n"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write workload output file: %s\n", err)
+			o.file.close()
+			o.file = nil
+		}
+	}
+
+	// levels are numbered from the most severe
+	if level > o.minLevel {
+		o.mutex.Unlock()
+		return
+	}
+	if !o.limiter.allow(time.Now()) {
+		o.dropped++
+		o.mutex.Unlock()
+		return
+	}
+	notice := o.dropNotice()
+	o.mutex.Unlock()
+
+	if notice != nil && !o.send(notice) {
+		o.recordDropped(1)
+	}
+	if !o.send(&agentapi.LogEntry{Source: o.name, Level: level, Text: text}) {
+		o.recordDropped(1)
+	}
+}
+
+// Returns a log entry reporting the lines dropped since the last report, if any, resetting the
+// count. Must be called with the mutex held
+func (o *workloadOutput) dropNotice() *agentapi.LogEntry {
+	if o.dropped == 0 {
+		return nil
+	}
+
+	text := fmt.Sprintf("Dropped %d lines of output from workload %s exceeding its log rate limit or while the node fell behind", o.dropped, o.name)
+	if o.file != nil {
+		text = fmt.Sprintf("%s; its full output is kept in the machine at %s", text, o.file.path)
+	}
+	o.dropped = 0
+
+	return &agentapi.LogEntry{
+		Source: NexEventSourceNexAgent,
+		Level:  agentapi.LogLevelWarn,
+		Text:   text,
+	}
+}
+
+func (o *workloadOutput) recordDropped(n int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.dropped += n
+}
+
+// Queues the entry for the node, blocking the workload's write for a while if the queue is full,
+// so that a workload writing faster than the node can take its logs is slowed down rather than
+// losing lines, without stalling it indefinitely
+func (o *workloadOutput) send(entry *agentapi.LogEntry) bool {
+	select {
+	case o.logs <- entry:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(outputBackpressureTimeout)
+	defer timer.Stop()
+
+	select {
+	case o.logs <- entry:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// One of the workload's output streams, stdout or stderr
+type outputStream struct {
+	output *workloadOutput
+	level  agentapi.LogLevel
+	lines  bool
+
+	mutex   sync.Mutex
+	partial []byte
+	idle    *time.Timer
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	if !s.lines {
+		s.output.emit(s.level, string(p))
+		return len(p), nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.output.emit(s.level, strings.TrimSuffix(string(s.partial[:i]), "\r"))
+		s.partial = s.partial[i+1:]
+	}
+	for len(s.partial) >= maxOutputLineBytes {
+		s.output.emit(s.level, string(s.partial[:maxOutputLineBytes]))
+		s.partial = s.partial[maxOutputLineBytes:]
+	}
+
+	if len(s.partial) > 0 {
+		if s.idle == nil {
+			s.idle = time.AfterFunc(outputIdleFlushInterval, s.flush)
+		} else {
+			s.idle.Reset(outputIdleFlushInterval)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *outputStream) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	if len(s.partial) > 0 {
+		s.output.emit(s.level, string(s.partial))
+		s.partial = nil
+	}
+}
+
+// Allows events at a rate, on average, and in bursts of up to a size
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// A file which is rotated once it reaches its maximum size: the file is renamed with the suffix
+// .1, the one before it with .2 and so on, and the oldest removed
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxBytes int64, maxFiles int) (*rotatingFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &rotatingFile{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		file:     file,
+		size:     info.Size(),
+	}, nil
+}
+
+func (r *rotatingFile) write(p []byte) error {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return err
+}
+
+func (r *rotatingFile) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	err = os.Rename(r.path, r.path+".1")
+	if err != nil {
+		return err
+	}
+
+	r.file, err = os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	r.size = 0
+	return nil
+}
+
+func (r *rotatingFile) close() {
+	_ = r.file.Close()
+}
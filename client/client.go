@@ -0,0 +1,211 @@
+// Package nexclient is a Go client of the nex control API, for tools embedding it (e.g. operators
+// or a Terraform provider) rather than shelling out to the nex CLI or hand-rolling its NATS
+// subjects and envelopes.
+//
+// A client is created from a NATS connection, and signs the workloads it runs with an issuer:
+//
+//	nc, _ := nats.Connect(nats.DefaultURL)
+//	issuer, _ := nkeys.CreateAccount()
+//	client, _ := nexclient.New(nc, nexclient.WithNamespace("default"), nexclient.WithIssuer(issuer))
+//
+//	run, err := client.RunWorkload(nodeId,
+//		nexclient.WorkloadName("echoservice"),
+//		nexclient.WorkloadType("native"),
+//		nexclient.Location("nats://MYOBJSTORE/echoservice"),
+//		nexclient.Checksum(digest),
+//	)
+//
+// Failures reported by nodes are returned as *RequestError, whose Code is one of the ErrorCode
+// constants. Request and response types are aliases of the control API's own, so their JSON is
+// exactly what nodes send and receive.
+package nexclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	DefaultNamespace = "default"
+	DefaultTimeout   = 5 * time.Second
+)
+
+// A client of the nex nodes reachable over a NATS connection. Clients are safe for concurrent use
+type Client struct {
+	api       *controlapi.Client
+	namespace string
+	signer    controlapi.ClaimsSigner
+	xkey      nkeys.KeyPair
+}
+
+// Configures a client created by New
+type Option func(o *options)
+
+type options struct {
+	namespace string
+	timeout   time.Duration
+	log       *slog.Logger
+	signer    controlapi.ClaimsSigner
+	xkey      nkeys.KeyPair
+}
+
+// The namespace in which workloads are run and queried. Defaults to DefaultNamespace
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// How long to wait for a node's response to each request. Defaults to DefaultTimeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// The logger of the client. By default, nothing is logged
+func WithLogger(log *slog.Logger) Option {
+	return func(o *options) {
+		o.log = log
+	}
+}
+
+// The account nkey which signs the JWTs of the workloads the client runs and stops. Without an
+// issuer, workloads may only be run with a DeployToken
+func WithIssuer(issuer nkeys.KeyPair) Option {
+	return func(o *options) {
+		o.signer = controlapi.NkeyClaimsSigner{KeyPair: issuer}
+	}
+}
+
+// Signs the JWTs of the workloads the client runs and stops with a signer other than an nkey,
+// e.g. a Vault transit key
+func WithIssuerSigner(signer ClaimsSigner) Option {
+	return func(o *options) {
+		o.signer = signer
+	}
+}
+
+// The curve key with which the environments of workloads are encrypted for their nodes. By
+// default, a key is generated for the client
+func WithXKey(xkey nkeys.KeyPair) Option {
+	return func(o *options) {
+		o.xkey = xkey
+	}
+}
+
+// Creates a client of the nex nodes reachable over the given connection
+func New(nc *nats.Conn, opts ...Option) (*Client, error) {
+	if nc == nil {
+		return nil, errors.New("a NATS connection is required")
+	}
+
+	o := &options{
+		namespace: DefaultNamespace,
+		timeout:   DefaultTimeout,
+		log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.xkey == nil {
+		xkey, err := nkeys.CreateCurveKeys()
+		if err != nil {
+			return nil, err
+		}
+		o.xkey = xkey
+	}
+
+	return &Client{
+		api:       controlapi.NewApiClientWithNamespace(nc, o.timeout, o.namespace, o.log),
+		namespace: o.namespace,
+		signer:    o.signer,
+		xkey:      o.xkey,
+	}, nil
+}
+
+// The namespace in which the client runs and queries workloads
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Lists the nodes which respond to a ping within the client's timeout
+func (c *Client) ListNodes() ([]PingResponse, error) {
+	return c.api.ListNodes()
+}
+
+// Returns the details of a node, including its capabilities and the workloads it runs in the
+// client's namespace
+func (c *Client) NodeInfo(nodeId string) (*InfoResponse, error) {
+	return c.api.NodeInfo(nodeId)
+}
+
+// Runs a workload on the given node. The request is signed by the client's issuer and its
+// environment encrypted for the node, so the options need only describe the workload; options
+// given here take precedence over the client's, e.g. an IssuerSigner or DeployToken
+func (c *Client) RunWorkload(nodeId string, opts ...RequestOption) (*RunResponse, error) {
+	info, err := c.api.NodeInfo(nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := []RequestOption{
+		controlapi.TargetNode(nodeId),
+		controlapi.SenderXKey(c.xkey),
+		controlapi.TargetPublicXKey(info.PublicXKey),
+	}
+	if c.signer != nil {
+		defaults = append(defaults, controlapi.IssuerSigner(c.signer))
+	}
+
+	request, err := controlapi.NewDeployRequest(append(defaults, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return c.api.StartWorkload(request)
+}
+
+// Awaits the readiness of a workload after it was run, for up to the given timeout. An error
+// is returned along with the outcome if the workload fails or isn't ready in time
+func (c *Client) AwaitWorkload(nodeId string, run *RunResponse, timeout time.Duration) (*WorkloadReadiness, error) {
+	return c.api.AwaitWorkload(nodeId, run, timeout)
+}
+
+// Describes a workload running on the given node
+func (c *Client) DescribeWorkload(nodeId string, workloadId string) (*DescribeResponse, error) {
+	return c.api.DescribeWorkload(nodeId, workloadId)
+}
+
+// Stops a workload running on the given node. The stop request is signed by the client's
+// issuer, which must be the one that ran the workload
+func (c *Client) StopWorkload(nodeId string, workloadId string, workloadName string) (*StopResponse, error) {
+	if c.signer == nil {
+		return nil, errors.New("an issuer is required to stop workloads")
+	}
+
+	request, err := controlapi.NewSignedStopRequest(workloadId, workloadName, nodeId, c.signer)
+	if err != nil {
+		return nil, err
+	}
+	return c.api.StopWorkload(request)
+}
+
+// Streams the events matching the filter as they're emitted, until the context is done, at
+// which point the channel is closed
+func (c *Client) StreamEvents(ctx context.Context, filter EventFilter) (<-chan EmittedEvent, error) {
+	return c.api.StreamEvents(ctx, filter)
+}
+
+// Streams the logs matching the filter as they're emitted, until the context is done, at which
+// point the channel is closed
+func (c *Client) StreamLogs(ctx context.Context, filter LogFilter) (<-chan EmittedLog, error) {
+	return c.api.StreamLogs(ctx, filter)
+}
@@ -0,0 +1,154 @@
+package nexclient
+
+import (
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Requests and responses of the control API
+type (
+	DeployRequest     = controlapi.DeployRequest
+	RunResponse       = controlapi.RunResponse
+	StopResponse      = controlapi.StopResponse
+	PingResponse      = controlapi.PingResponse
+	InfoResponse      = controlapi.InfoResponse
+	DescribeResponse  = controlapi.DescribeResponse
+	WorkloadReadiness = controlapi.WorkloadReadiness
+	NodeCapabilities  = controlapi.NodeCapabilities
+	WorkloadSummary   = controlapi.WorkloadSummary
+	MachineSummary    = controlapi.MachineSummary
+)
+
+// Events and logs, as streamed by StreamEvents and StreamLogs
+type (
+	EventFilter  = controlapi.EventFilter
+	LogFilter    = controlapi.LogFilter
+	EmittedEvent = controlapi.EmittedEvent
+	EmittedLog   = controlapi.EmittedLog
+	RawLog       = controlapi.RawLog
+)
+
+// Failures reported by nodes
+type (
+	RequestError = controlapi.RequestError
+	FieldError   = schema.FieldError
+)
+
+// Codes of the failures reported by nodes, as found in RequestError.Code
+const (
+	ErrorCodeArtifactRejected        = controlapi.ErrorCodeArtifactRejected
+	ErrorCodeArtifactUnavailable     = controlapi.ErrorCodeArtifactUnavailable
+	ErrorCodeConflict                = controlapi.ErrorCodeConflict
+	ErrorCodeDeployFailed            = controlapi.ErrorCodeDeployFailed
+	ErrorCodeHandshakeTimeout        = controlapi.ErrorCodeHandshakeTimeout
+	ErrorCodeInternal                = controlapi.ErrorCodeInternal
+	ErrorCodeInvalidRequest          = controlapi.ErrorCodeInvalidRequest
+	ErrorCodeNodeReserved            = controlapi.ErrorCodeNodeReserved
+	ErrorCodeNodeUnavailable         = controlapi.ErrorCodeNodeUnavailable
+	ErrorCodeNotFound                = controlapi.ErrorCodeNotFound
+	ErrorCodePlacementRejected       = controlapi.ErrorCodePlacementRejected
+	ErrorCodePoolExhausted           = controlapi.ErrorCodePoolExhausted
+	ErrorCodeQuotaExceeded           = controlapi.ErrorCodeQuotaExceeded
+	ErrorCodeTriggerSubjectRejected  = controlapi.ErrorCodeTriggerSubjectRejected
+	ErrorCodeUnauthorized            = controlapi.ErrorCodeUnauthorized
+	ErrorCodeUnsupportedAPIVersion   = controlapi.ErrorCodeUnsupportedAPIVersion
+	ErrorCodeUnsupportedFeature      = controlapi.ErrorCodeUnsupportedFeature
+	ErrorCodeUnsupportedWorkloadType = controlapi.ErrorCodeUnsupportedWorkloadType
+)
+
+// The sources of log records
+const (
+	LogSourceAgent    = controlapi.LogSourceAgent
+	LogSourceWorkload = controlapi.LogSourceWorkload
+	LogSourceHost     = controlapi.LogSourceHost
+)
+
+// Describes a workload to RunWorkload. The options are those of the control API, documented on
+// the functions they alias
+type RequestOption = controlapi.RequestOption
+
+// Signs workload JWTs, see WithIssuerSigner
+type ClaimsSigner = controlapi.ClaimsSigner
+
+// The parts of a workload's description taken by RequestOptions
+type (
+	ArtifactSignature        = controlapi.ArtifactSignature
+	CredentialsRequest       = controlapi.CredentialsRequest
+	CronTrigger              = controlapi.CronTrigger
+	EgressPolicy             = controlapi.EgressPolicy
+	EgressRule               = controlapi.EgressRule
+	HealthCheck              = controlapi.HealthCheck
+	MemorySoftLimit          = controlapi.MemorySoftLimit
+	LogLimits                = controlapi.LogLimits
+	MicroServiceRegistration = controlapi.MicroServiceRegistration
+	PlacementConstraints     = controlapi.PlacementConstraints
+	WorkloadHook             = controlapi.WorkloadHook
+)
+
+// Options describing the workload
+var (
+	WorkloadName        = controlapi.WorkloadName
+	WorkloadType        = controlapi.WorkloadType
+	WorkloadDescription = controlapi.WorkloadDescription
+	WorkloadLabels      = controlapi.WorkloadLabels
+	Location            = controlapi.Location
+	Checksum            = controlapi.Checksum
+	WorkloadDigest      = controlapi.WorkloadDigest
+	WorkloadSignature   = controlapi.WorkloadSignature
+	StreamArtifact      = controlapi.StreamArtifact
+	JsDomain            = controlapi.JsDomain
+	Argv                = controlapi.Argv
+	Stdin               = controlapi.Stdin
+	Essential           = controlapi.Essential
+	IdempotencyKey      = controlapi.IdempotencyKey
+	DeployTimeouts      = controlapi.DeployTimeouts
+)
+
+// Options of the workload's environment and secrets, which are encrypted for the node
+var (
+	Environment         = controlapi.Environment
+	EnvironmentValue    = controlapi.EnvironmentValue
+	SealedEnvironment   = controlapi.SealedEnvironment
+	SecretReferences    = controlapi.SecretReferences
+	WorkloadCredentials = controlapi.WorkloadCredentials
+)
+
+// Options of how functions are triggered
+var (
+	TriggerSubjects         = controlapi.TriggerSubjects
+	TriggerDelivery         = controlapi.TriggerDelivery
+	TriggerQueueGroups      = controlapi.TriggerQueueGroups
+	TriggerConcurrencyLimit = controlapi.TriggerConcurrencyLimit
+	CompletionSubject       = controlapi.CompletionSubject
+	CronTriggers            = controlapi.CronTriggers
+	ExecPerTrigger          = controlapi.ExecPerTrigger
+	IdleTimeout             = controlapi.IdleTimeout
+	WorkloadWebhook         = controlapi.WorkloadWebhook
+	WorkloadMicroService    = controlapi.WorkloadMicroService
+)
+
+// Options of the workload's machine
+var (
+	MachineTemplate         = controlapi.MachineTemplate
+	MachineSize             = controlapi.MachineSize
+	WorkloadSandboxProfile  = controlapi.WorkloadSandboxProfile
+	WorkloadHealthCheck     = controlapi.WorkloadHealthCheck
+	WorkloadReadinessProbe  = controlapi.WorkloadReadinessProbe
+	WorkloadPreStartHook    = controlapi.WorkloadPreStartHook
+	WorkloadPostStopHook    = controlapi.WorkloadPostStopHook
+	WorkloadMemorySoftLimit = controlapi.WorkloadMemorySoftLimit
+	WorkloadLogLimits       = controlapi.WorkloadLogLimits
+	WorkloadEgressPolicy    = controlapi.WorkloadEgressPolicy
+	WorkloadStableIP        = controlapi.WorkloadStableIP
+	WorkloadDNSName         = controlapi.WorkloadDNSName
+	WorkloadVolume          = controlapi.WorkloadVolume
+	WorkloadPlacement       = controlapi.WorkloadPlacement
+)
+
+// Options of who the workload is run by, which RunWorkload sets from the client's own
+var (
+	Issuer       = controlapi.Issuer
+	IssuerSigner = controlapi.IssuerSigner
+	IssuerChain  = controlapi.IssuerChain
+	DeployToken  = controlapi.DeployToken
+)
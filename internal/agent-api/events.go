@@ -10,8 +10,13 @@ import (
 const (
 	AgentStartedEventType          = "agent_started"
 	AgentStoppedEventType          = "agent_stopped"
+	ArtifactProgressEventType      = "artifact_progress"
 	FunctionExecutionFailedType    = "function_exec_failed"
 	FunctionExecutionSucceededType = "function_exec_succeeded"
+	MemoryPressureEventType        = "memory_pressure"
+	WorkloadOOMEventType           = "workload_oom"
+	WorkloadFailedEventType        = "workload_failed"
+	WorkloadReadyEventType         = "workload_ready"
 	WorkloadStartedEventType       = "workload_started" // FIXME-- should this be WorkloadDeployed?
 	WorkloadStoppedEventType       = "workload_stopped" // FIXME-- should this be in addition to WorkloadUndeployed (likely yes, in case of something bad happening...)
 	// FIXME-- where is WorkloadDeployedEventType? (likely just need to rename WorkloadStartedEventType -> WorkloadDeployedEventType)
@@ -26,6 +31,46 @@ type WorkloadStatusEvent struct {
 	WorkloadName string `json:"workload_name"`
 	Code         int    `json:"code"`
 	Message      string `json:"message,omitempty"`
+
+	// Included in workload started events when the node verified the workload's artifact
+	Provenance *ArtifactProvenance `json:"provenance,omitempty"`
+	// Included in workload started events when the node scanned the workload's artifact
+	ScanResults []ArtifactScanResult `json:"scan_results,omitempty"`
+}
+
+// Emitted by the agent once a workload it acknowledged as still initializing has either finished
+// initializing or failed to
+type WorkloadReadyEvent struct {
+	WorkloadName string `json:"workload_name"`
+	Ready        bool   `json:"ready"`
+	Message      string `json:"message,omitempty"`
+}
+
+// Emitted by the agent when the workload's machine crosses the workload's soft memory limit
+type MemoryPressureEvent struct {
+	WorkloadName     string   `json:"workload_name"`
+	UsedPercent      float64  `json:"used_percent"`
+	PressurePercent  *float64 `json:"pressure_percent,omitempty"`
+	ThresholdPercent int      `json:"threshold_percent,omitempty"`
+	// Signal sent to the workload's process, if any
+	Signal string `json:"signal,omitempty"`
+}
+
+// Emitted by the agent when the kernel of the workload's machine kills a process for
+// running out of memory
+type WorkloadOOMEvent struct {
+	WorkloadName string `json:"workload_name"`
+	// Number of processes killed since the machine booted
+	OOMKills uint64 `json:"oom_kills"`
+	UsedMib  int64  `json:"used_mib"`
+	TotalMib int64  `json:"total_mib"`
+}
+
+// Emitted by the agent as it streams a workload's artifact into its machine
+type ArtifactProgressEvent struct {
+	WorkloadName  string `json:"workload_name"`
+	ReceivedBytes int64  `json:"received_bytes"`
+	TotalBytes    int64  `json:"total_bytes"`
 }
 
 type AgentStoppedEvent struct {
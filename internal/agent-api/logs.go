@@ -1,5 +1,15 @@
 package agentapi
 
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// The source of the log entries the agent emits itself, rather than its workload's output
+const AgentLogSource = "nex-agent"
+
 const (
 	LogLevelPanic = 0
 	LogLevelFatal = 1
@@ -9,3 +19,91 @@ const (
 	LogLevelDebug = 5
 	LogLevelTrace = 6
 )
+
+// The slog level of the log level, with which nodes publish entries
+func (l LogLevel) SlogLevel() slog.Level {
+	switch {
+	case l <= LogLevelError:
+		return slog.LevelError
+	case l == LogLevelWarn:
+		return slog.LevelWarn
+	case l == LogLevelInfo:
+		return slog.LevelInfo
+	case l == LogLevelDebug:
+		return slog.LevelDebug
+	}
+	return slog.LevelDebug - 4
+}
+
+// Workload output is forwarded to the node at most this many lines a second, on average, and in
+// a burst, unless its log limits say otherwise
+const (
+	DefaultLogLinesPerSecond = 500
+	DefaultLogBurst          = 1000
+)
+
+// Limits on the output of a workload the agent forwards to the node as logs, so that a chatty
+// workload can't flood internal NATS. Lines written to stdout are logged at info level and those
+// written to stderr at error level
+type LogLimits struct {
+	// Least severe level forwarded: debug, info (the default), warn or error
+	Level *string `json:"level,omitempty"`
+	// Lines forwarded a second, on average and in a burst; lines beyond them are dropped
+	LinesPerSecond int `json:"lines_per_second,omitempty"`
+	Burst          int `json:"burst,omitempty"`
+}
+
+func (l *LogLimits) Validate() error {
+	if l.Level != nil {
+		_, err := ParseLogLevel(*l.Level)
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.LinesPerSecond < 0 || l.Burst < 0 {
+		return errors.New("log rate limits must be positive")
+	}
+
+	return nil
+}
+
+// The least severe level of output forwarded, falling back to info when unspecified
+func (l *LogLimits) MinLevel() LogLevel {
+	if l == nil || l.Level == nil {
+		return LogLevelInfo
+	}
+	level, _ := ParseLogLevel(*l.Level)
+	return level
+}
+
+// The lines forwarded a second and in a burst, falling back to the defaults when unspecified
+func (l *LogLimits) Rate() (int, int) {
+	if l == nil {
+		return DefaultLogLinesPerSecond, DefaultLogBurst
+	}
+
+	rate, burst := l.LinesPerSecond, l.Burst
+	if rate == 0 {
+		rate = DefaultLogLinesPerSecond
+	}
+	if burst == 0 {
+		burst = max(rate, DefaultLogBurst)
+	}
+	return rate, burst
+}
+
+// Parses the name of a log level: debug, info, warn or error
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return 0, fmt.Errorf("unsupported log level: %s", level)
+}
@@ -0,0 +1,26 @@
+package agentapi
+
+import "github.com/synadia-io/nex/internal/schema"
+
+// Returns the JSON Schemas of every message of the agent API, keyed by the message's name
+func Schemas() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"agent_started_event":              schema.For(AgentStartedEvent{}),
+		"agent_stopped_event":              schema.For(AgentStoppedEvent{}),
+		"artifact_progress_event":          schema.For(ArtifactProgressEvent{}),
+		"deploy_request":                   schema.For(DeployRequest{}),
+		"deploy_response":                  schema.For(DeployResponse{}),
+		"handshake_request":                schema.For(HandshakeRequest{}),
+		"handshake_response":               schema.For(HandshakeResponse{}),
+		"host_services_key_value_request":  schema.For(HostServicesKeyValueRequest{}),
+		"host_services_messaging_request":  schema.For(HostServicesMessagingRequest{}),
+		"host_services_messaging_response": schema.For(HostServicesMessagingResponse{}),
+		"host_services_secret_request":     schema.For(HostServicesSecretRequest{}),
+		"log_entry":                        schema.For(LogEntry{}),
+		"machine_metadata":                 schema.For(MachineMetadata{}),
+		"memory_pressure_event":            schema.For(MemoryPressureEvent{}),
+		"workload_o_o_m_event":             schema.For(WorkloadOOMEvent{}),
+		"workload_ready_event":             schema.For(WorkloadReadyEvent{}),
+		"workload_status_event":            schema.For(WorkloadStatusEvent{}),
+	}
+}
@@ -3,18 +3,34 @@ package agentapi
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nats.go"
+	"github.com/synadia-io/nex/internal/compat"
 )
 
 // Agent handshake subject
 const NexAgentSubjectHandshake = "agentint.handshake"
 
+// Returns the inbox prefix an agent must use on the internal NATS connection; agents may only
+// subscribe to inboxes beneath their own prefix
+func AgentInboxPrefix(vmID string) string {
+	return fmt.Sprintf("_INBOX_%s", vmID)
+}
+
+// Returns the inbox prefix a workload must use with the NATS credentials minted for it; workloads
+// may only subscribe to inboxes beneath the prefix of their own user
+func WorkloadInboxPrefix(user string) string {
+	return fmt.Sprintf("_INBOX_%s", user)
+}
+
 // Executable Linkable Format execution provider
 const NexExecutionProviderELF = "elf"
 
@@ -27,12 +43,182 @@ const NexExecutionProviderOCI = "oci"
 // Wasm execution provider
 const NexExecutionProviderWasm = "wasm"
 
+// Python execution provider
+const NexExecutionProviderPython = "python"
+
+// Returns true if workloads of the given type are functions, executed on triggers rather than
+// run as services
+func IsFunctionWorkloadType(workloadType string) bool {
+	return strings.EqualFold(workloadType, NexExecutionProviderV8) ||
+		strings.EqualFold(workloadType, NexExecutionProviderWasm) ||
+		strings.EqualFold(workloadType, NexExecutionProviderPython)
+}
+
+// Returns true if workloads of the given type are executed on triggers: functions, and native
+// workloads executed once per trigger
+func SupportsTriggers(workloadType string, execPerTrigger bool) bool {
+	return IsFunctionWorkloadType(workloadType) ||
+		(execPerTrigger && strings.EqualFold(workloadType, NexExecutionProviderELF))
+}
+
+// Limits on the arguments and stdin given to native workloads
+const (
+	MaxArgvBytes  = 4096
+	MaxStdinBytes = 64 * 1024
+)
+
+// Environment variables in which native workloads executed once per trigger are given the
+// trigger subject and, for at-least-once deliveries, the idempotency key of the trigger message.
+// The trigger payload is delivered on stdin
+const (
+	NexTriggerSubjectEnv = "NEX_TRIGGER_SUBJECT"
+	NexIdempotencyKeyEnv = "NEX_IDEMPOTENCY_KEY"
+)
+
+// Validates the arguments, stdin and execution mode of a workload of the given type. Only native
+// workloads may be executed once per trigger, and only native services are given stdin
+func ValidateNativeExecution(workloadType string, argv []string, stdin []byte, execPerTrigger bool) error {
+	var err error
+
+	argvBytes := 0
+	for _, arg := range argv {
+		argvBytes += len(arg)
+	}
+	if argvBytes > MaxArgvBytes {
+		err = errors.Join(err, fmt.Errorf("arguments (%d bytes) exceed maximum of %d bytes", argvBytes, MaxArgvBytes))
+	}
+
+	if len(stdin) > MaxStdinBytes {
+		err = errors.Join(err, fmt.Errorf("stdin (%d bytes) exceeds maximum of %d bytes", len(stdin), MaxStdinBytes))
+	}
+
+	native := strings.EqualFold(workloadType, NexExecutionProviderELF)
+	if execPerTrigger && !native {
+		err = errors.Join(err, errors.New("exec per trigger is only supported for native workloads"))
+	}
+	if len(stdin) > 0 && (!native || execPerTrigger) {
+		err = errors.Join(err, errors.New("stdin is only supported for native service workloads; triggered executions receive the trigger payload"))
+	}
+
+	return err
+}
+
+// Path at which a service workload's persistent volume, attached to its machine as the second
+// drive, is mounted by the machine's init system, and the environment variable in which the
+// workload is given it
+const (
+	WorkloadVolumeMountPath = "/home/nex/volume"
+	NexVolumePathEnv        = "NEX_VOLUME_PATH"
+)
+
 // Name of the internal, non-public bucket for sharing files between host and agent
 const WorkloadCacheBucket = "NEXCACHE"
 
+// Agents can't read the workload cache, which holds the artifacts of every workload. Instead they
+// read their own workload's artifact from the node a chunk at a time, requesting each chunk with
+// the offset they've read up to. Each response carries the artifact's size in a header, and the
+// chunk as its data, which is empty once the artifact has been read
+const (
+	ArtifactChunkSize   = 128 * 1024
+	ArtifactSizeHeader  = "Nex-Artifact-Size"
+	ArtifactErrorHeader = "Nex-Artifact-Error"
+)
+
+// The subject on which the agent of the given machine requests its workload's artifact
+func ArtifactSubject(vmID string) string {
+	return fmt.Sprintf("agentint.%s.artifact", vmID)
+}
+
+type ArtifactChunkRequest struct {
+	Offset int64 `json:"offset"`
+}
+
 // DefaultRunloopSleepTimeoutMillis default number of milliseconds to sleep during execution runloops
 const DefaultRunloopSleepTimeoutMillis = 25
 
+// Workload health check probe types
+const (
+	HealthCheckTypeExec = "exec"
+	HealthCheckTypeHTTP = "http"
+	HealthCheckTypeNATS = "nats"
+)
+
+// Trigger delivery modes. At-most-once delivers each trigger message to the function a
+// single time (or not at all); at-least-once persists in-flight trigger messages on the
+// node and redelivers them until the function executes successfully
+const (
+	TriggerDeliveryAtMostOnce  = "at_most_once"
+	TriggerDeliveryAtLeastOnce = "at_least_once"
+)
+
+// Policies for handling trigger messages which arrive while a function's trigger queue is
+// full. Reject responds to the new message with an error, drop-oldest discards the message
+// which has been queued the longest, and block stops consuming trigger messages until
+// there's room in the queue
+const (
+	TriggerOverflowReject     = "reject"
+	TriggerOverflowDropOldest = "drop_oldest"
+	TriggerOverflowBlock      = "block"
+)
+
+const DefaultTriggerQueueSize = 100
+
+// Default interval and timeout for workload health checks
+const (
+	DefaultHealthCheckIntervalMillis = 10000
+	DefaultHealthCheckTimeoutMillis  = 2000
+)
+
+// Signals the agent may send to a workload's process when the workload crosses its soft
+// memory limit. A workload which asks for a signal must handle it, as the default action
+// of each of these signals is to terminate the process
+const (
+	MemorySoftLimitSignalUSR1 = "SIGUSR1"
+	MemorySoftLimitSignalUSR2 = "SIGUSR2"
+	MemorySoftLimitSignalHUP  = "SIGHUP"
+)
+
+// Protocols of the egress rules of a workload's egress policy
+const (
+	EgressProtocolTCP = "tcp"
+	EgressProtocolUDP = "udp"
+)
+
+// Default interval on which the agent samples memory use against a workload's soft memory limit
+const DefaultMemorySoftLimitIntervalMillis = 1000
+
+// Interval on which the agent reports the memory use of a workload's machine to the node
+const MemoryReportIntervalMillis = 10000
+
+// Environment variables injected into workloads by the agent describing the in-sandbox
+// host services endpoint and the bearer token required to use it
+const (
+	NexHostServicesEnvURL   = "NEX_HOSTSERVICES_URL"
+	NexHostServicesEnvToken = "NEX_HOSTSERVICES_TOKEN"
+)
+
+// Default timeout for workload pre-start and post-stop hooks
+const DefaultWorkloadHookTimeoutMillis = 30000
+
+// Default time the node waits for the agent to acknowledge a deploy request, and, once the agent
+// has acknowledged a workload it's still initializing, for that workload to be ready
+const (
+	DefaultDeployAckTimeoutMillis   = 1000
+	DefaultDeployReadyTimeoutMillis = 300000
+)
+
+// Default interval between attempts of a workload's readiness probe
+const DefaultReadinessProbeIntervalMillis = 1000
+
+// Environment variables used to supply machine metadata to an agent running as a
+// local process (i.e., without a firecracker sandbox) in lieu of MMDS
+const (
+	NexAgentEnvNodeNatsHost = "NEX_NODE_NATS_HOST"
+	NexAgentEnvNodeNatsPort = "NEX_NODE_NATS_PORT"
+	NexAgentEnvVmID         = "NEX_VMID"
+	NexAgentEnvNodeNatsSeed = "NEX_NODE_NATS_NKEY_SEED"
+)
+
 // ExecutionProviderParams parameters for initializing a specific execution provider
 type ExecutionProviderParams struct {
 	DeployRequest
@@ -59,47 +245,171 @@ type ExecutionProviderParams struct {
 
 // DeployRequest processed by the agent
 type DeployRequest struct {
-	Argv            []string          `json:"argv,omitempty"`
-	DecodedClaims   jwt.GenericClaims `json:"-"`
-	Description     *string           `json:"description"`
-	Environment     map[string]string `json:"environment"`
-	Essential       *bool             `json:"essential,omitempty"`
-	Hash            string            `json:"hash,omitempty"`
-	Namespace       *string           `json:"namespace,omitempty"`
-	RetriedAt       *time.Time        `json:"retried_at,omitempty"`
-	RetryCount      *uint             `json:"retry_count,omitempty"`
-	TotalBytes      int64             `json:"total_bytes,omitempty"`
-	TriggerSubjects []string          `json:"trigger_subjects"`
-	WorkloadName    *string           `json:"workload_name,omitempty"`
-	WorkloadType    *string           `json:"workload_type,omitempty"`
+	AckTimeoutMillis   *int                 `json:"ack_timeout_ms,omitempty"`
+	Argv               []string             `json:"argv,omitempty"`
+	DecodedClaims      jwt.GenericClaims    `json:"-"`
+	Credentials        *Credentials         `json:"credentials,omitempty"`
+	CronTriggers       []CronTrigger        `json:"cron_triggers,omitempty"`
+	Description        *string              `json:"description"`
+	DNSName            *string              `json:"dns_name,omitempty"`
+	EgressPolicy       *EgressPolicy        `json:"egress_policy,omitempty"`
+	Environment        map[string]string    `json:"environment"`
+	Essential          *bool                `json:"essential,omitempty"`
+	ExecPerTrigger     bool                 `json:"exec_per_trigger,omitempty"`
+	Hash               string               `json:"hash,omitempty"`
+	HealthCheck        *HealthCheck         `json:"health_check,omitempty"`
+	HostServices       *HostServicesPolicy  `json:"host_services,omitempty"`
+	IdleTimeoutMillis  *int                 `json:"idle_timeout_ms,omitempty"`
+	Labels             map[string]string    `json:"labels,omitempty"`
+	LogLimits          *LogLimits           `json:"log_limits,omitempty"`
+	MachineTemplate    *string              `json:"machine_template,omitempty"`
+	MemorySoftLimit    *MemorySoftLimit     `json:"memory_soft_limit,omitempty"`
+	MemSizeMib         *int                 `json:"memsize_mib,omitempty"`
+	Namespace          *string              `json:"namespace,omitempty"`
+	Provenance         *ArtifactProvenance  `json:"provenance,omitempty"`
+	PostStopHook       *WorkloadHook        `json:"post_stop_hook,omitempty"`
+	PreStartHook       *WorkloadHook        `json:"pre_start_hook,omitempty"`
+	ReadinessProbe     *HealthCheck         `json:"readiness_probe,omitempty"`
+	ReadyTimeoutMillis *int                 `json:"ready_timeout_ms,omitempty"`
+	Signature          *ArtifactSignature   `json:"signature,omitempty"`
+	RetriedAt          *time.Time           `json:"retried_at,omitempty"`
+	RetryCount         *uint                `json:"retry_count,omitempty"`
+	SandboxProfile     *string              `json:"sandbox_profile,omitempty"`
+	ScanResults        []ArtifactScanResult `json:"scan_results,omitempty"`
+	Stdin              []byte               `json:"stdin,omitempty"`
+	StreamArtifact     bool                 `json:"stream_artifact,omitempty"`
+	TotalBytes         int64                `json:"total_bytes,omitempty"`
+	TriggerConcurrency *TriggerConcurrency  `json:"trigger_concurrency,omitempty"`
+	TriggerDelivery    *string              `json:"trigger_delivery,omitempty"`
+	TriggerQueueGroups map[string]string    `json:"trigger_queue_groups,omitempty"`
+	TriggerSubjects    []string             `json:"trigger_subjects"`
+	VcpuCount          *int                 `json:"vcpu_count,omitempty"`
+	VolumeSizeMib      *int                 `json:"volume_size_mib,omitempty"`
+	WorkloadName       *string              `json:"workload_name,omitempty"`
+	WorkloadType       *string              `json:"workload_type,omitempty"`
 
 	Stderr      io.Writer `json:"-"`
 	Stdout      io.Writer `json:"-"`
 	TmpFilename *string   `json:"-"`
 
-	EncryptedEnvironment *string  `json:"-"`
-	JsDomain             *string  `json:"-"`
-	Location             *url.URL `json:"-"`
-	SenderPublicKey      *string  `json:"-"`
-	TargetNode           *string  `json:"-"`
-	WorkloadJwt          *string  `json:"-"`
+	CompletionSubject    *string           `json:"-"`
+	EncryptedEnvironment *string           `json:"-"`
+	SealedEnvironment    map[string]string `json:"-"`
+	Secrets              map[string]string `json:"-"`
+	JsDomain             *string           `json:"-"`
+	Location             *url.URL          `json:"-"`
+	SenderPublicKey      *string           `json:"-"`
+	StableIP             bool              `json:"-"`
+	Standby              bool              `json:"-"`
+	Webhook              *WebhookTrigger   `json:"-"`
+	MicroService         *MicroService     `json:"-"`
+	TargetNode           *string           `json:"-"`
+	WorkloadJwt          *string           `json:"-"`
+	IssuerChain          []string          `json:"-"`
 
 	Errors []error `json:"errors,omitempty"`
+
+	// Fields unknown to this version of the API, preserved for forward compatibility
+	Unknown compat.UnknownFields `json:"-"`
+}
+
+func (request DeployRequest) MarshalJSON() ([]byte, error) {
+	type deployRequest DeployRequest
+	return compat.MarshalPreservingUnknown(deployRequest(request), request.Unknown)
+}
+
+func (request *DeployRequest) UnmarshalJSON(data []byte) error {
+	type deployRequest DeployRequest
+
+	var r deployRequest
+	unknown, err := compat.UnmarshalPreservingUnknown(data, &r)
+	if err != nil {
+		return err
+	}
+
+	*request = DeployRequest(r)
+	request.Unknown = unknown
+	return nil
 }
 
 // Returns true if the run request supports essential flag
 func (request *DeployRequest) SupportsEssential() bool {
-	return strings.EqualFold(*request.WorkloadType, "elf") ||
+	return (strings.EqualFold(*request.WorkloadType, "elf") && !request.ExecPerTrigger) ||
 		strings.EqualFold(*request.WorkloadType, "oci")
 }
 
+// Returns true if the workload runs as a process the agent can signal
+func (request *DeployRequest) SupportsSignals() bool {
+	return strings.EqualFold(*request.WorkloadType, NexExecutionProviderELF) && !request.ExecPerTrigger
+}
+
 // Returns true if the run request supports trigger subjects
 func (request *DeployRequest) SupportsTriggerSubjects() bool {
-	return (strings.EqualFold(*request.WorkloadType, "v8") ||
-		strings.EqualFold(*request.WorkloadType, "wasm")) &&
+	return SupportsTriggers(*request.WorkloadType, request.ExecPerTrigger) &&
 		len(request.TriggerSubjects) > 0
 }
 
+// Returns true if the run request supports cron triggers
+func (request *DeployRequest) SupportsCronTriggers() bool {
+	return SupportsTriggers(*request.WorkloadType, request.ExecPerTrigger) &&
+		len(request.CronTriggers) > 0
+}
+
+// Returns true if trigger messages for the workload should be persisted and redelivered
+// until the function executes successfully
+func (request *DeployRequest) AtLeastOnceDelivery() bool {
+	return request.TriggerDelivery != nil && strings.EqualFold(*request.TriggerDelivery, TriggerDeliveryAtLeastOnce)
+}
+
+// Returns the name of the machine template the workload runs in, or an empty string if it runs
+// in the node's default template
+func (request *DeployRequest) Template() string {
+	if request.MachineTemplate == nil {
+		return ""
+	}
+	return *request.MachineTemplate
+}
+
+// Returns how long the function may go without a trigger before it's scaled to zero, or
+// zero if it should remain deployed indefinitely
+func (request *DeployRequest) IdleTimeout() time.Duration {
+	if request.IdleTimeoutMillis == nil || *request.IdleTimeoutMillis <= 0 {
+		return 0
+	}
+	return time.Duration(*request.IdleTimeoutMillis) * time.Millisecond
+}
+
+// Returns how long the node waits for the agent to acknowledge the deploy request
+func (request *DeployRequest) AckTimeout() time.Duration {
+	if request.AckTimeoutMillis == nil || *request.AckTimeoutMillis <= 0 {
+		return DefaultDeployAckTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(*request.AckTimeoutMillis) * time.Millisecond
+}
+
+// Returns how long the node waits for a workload the agent acknowledged as still initializing
+// to be ready
+func (request *DeployRequest) ReadyTimeout() time.Duration {
+	if request.ReadyTimeoutMillis == nil || *request.ReadyTimeoutMillis <= 0 {
+		return DefaultDeployReadyTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(*request.ReadyTimeoutMillis) * time.Millisecond
+}
+
+// Returns the interval between attempts of the workload's readiness probe
+func (request *DeployRequest) ReadinessProbeInterval() time.Duration {
+	if request.ReadinessProbe == nil || request.ReadinessProbe.IntervalMillis == 0 {
+		return DefaultReadinessProbeIntervalMillis * time.Millisecond
+	}
+	return time.Duration(request.ReadinessProbe.IntervalMillis) * time.Millisecond
+}
+
+// Returns the queue group to subscribe to the given trigger subject with, or an empty
+// string if the subject isn't load-balanced
+func (request *DeployRequest) TriggerQueueGroup(tsub string) string {
+	return request.TriggerQueueGroups[tsub]
+}
+
 func (r *DeployRequest) Validate() bool {
 	var err error
 
@@ -121,24 +431,446 @@ func (r *DeployRequest) Validate() bool {
 
 	if r.WorkloadType == nil {
 		err = errors.Join(err, errors.New("workload type is required"))
-	} else if (strings.EqualFold(*r.WorkloadType, NexExecutionProviderV8) ||
-		strings.EqualFold(*r.WorkloadType, NexExecutionProviderWasm)) &&
-		len(r.TriggerSubjects) == 0 {
-		err = errors.Join(err, errors.New("at least one trigger subject is required for this workload type"))
+	} else {
+		if SupportsTriggers(*r.WorkloadType, r.ExecPerTrigger) &&
+			len(r.TriggerSubjects) == 0 && len(r.CronTriggers) == 0 {
+			err = errors.Join(err, errors.New("at least one trigger subject or cron trigger is required for this workload type"))
+		}
+
+		err = errors.Join(err, ValidateNativeExecution(*r.WorkloadType, r.Argv, r.Stdin, r.ExecPerTrigger))
+	}
+
+	if r.TriggerDelivery != nil &&
+		!strings.EqualFold(*r.TriggerDelivery, TriggerDeliveryAtMostOnce) &&
+		!strings.EqualFold(*r.TriggerDelivery, TriggerDeliveryAtLeastOnce) {
+		err = errors.Join(err, fmt.Errorf("unsupported trigger delivery mode: %s", *r.TriggerDelivery))
+	}
+
+	if r.TriggerConcurrency != nil {
+		err = errors.Join(err, r.TriggerConcurrency.Validate())
+	}
+
+	if r.HealthCheck != nil {
+		err = errors.Join(err, r.HealthCheck.Validate())
+	}
+
+	if r.ReadinessProbe != nil {
+		err = errors.Join(err, r.ReadinessProbe.Validate())
+	}
+
+	if r.MemorySoftLimit != nil {
+		err = errors.Join(err, r.MemorySoftLimit.Validate())
+
+		if r.MemorySoftLimit.Signal != nil && r.WorkloadType != nil && !r.SupportsSignals() {
+			err = errors.Join(err, errors.New("memory soft limit signals are not supported for workload type"))
+		}
+	}
+
+	if r.EgressPolicy != nil {
+		err = errors.Join(err, r.EgressPolicy.Validate())
+	}
+
+	if r.LogLimits != nil {
+		err = errors.Join(err, r.LogLimits.Validate())
+	}
+
+	if r.VolumeSizeMib != nil && *r.VolumeSizeMib < 1 {
+		err = errors.Join(err, errors.New("volume size must be >= 1 MiB"))
+	}
+
+	if r.PreStartHook != nil {
+		err = errors.Join(err, r.PreStartHook.Validate())
+	}
+
+	if r.PostStopHook != nil {
+		err = errors.Join(err, r.PostStopHook.Validate())
+	}
+
+	if err != nil {
+		r.Errors = []error{err}
 	}
 
 	return err == nil
 }
 
+// Bounds the number of trigger messages a function executes concurrently. Trigger messages
+// beyond the limit wait in a bounded queue, and are handled per the overflow policy once
+// the queue is full
+type TriggerConcurrency struct {
+	MaxInFlight int    `json:"max_in_flight"`
+	QueueSize   int    `json:"queue_size,omitempty"`
+	Overflow    string `json:"overflow,omitempty"`
+}
+
+func (c *TriggerConcurrency) Validate() error {
+	if c.MaxInFlight <= 0 {
+		return errors.New("trigger concurrency requires a positive max in flight")
+	}
+
+	if c.QueueSize < 0 {
+		return errors.New("trigger queue size must be positive")
+	}
+
+	switch c.OverflowPolicy() {
+	case TriggerOverflowReject, TriggerOverflowDropOldest, TriggerOverflowBlock:
+		return nil
+	default:
+		return fmt.Errorf("unsupported trigger overflow policy: %s", c.Overflow)
+	}
+}
+
+// Number of trigger messages which may wait for execution, falling back to the default
+// when unspecified
+func (c *TriggerConcurrency) QueueCapacity() int {
+	if c.QueueSize == 0 {
+		return DefaultTriggerQueueSize
+	}
+	return c.QueueSize
+}
+
+// Overflow policy, falling back to rejecting new trigger messages when unspecified
+func (c *TriggerConcurrency) OverflowPolicy() string {
+	if c.Overflow == "" {
+		return TriggerOverflowReject
+	}
+	return strings.ToLower(c.Overflow)
+}
+
+// A schedule on which a function is triggered by the node
+type CronTrigger struct {
+	Schedule string  `json:"schedule"`
+	Timezone *string `json:"timezone,omitempty"`
+	Payload  []byte  `json:"payload,omitempty"`
+}
+
+// An inbound webhook through which the node triggers a function, delivering requests presenting
+// a token with the given digest on the given subject
+type WebhookTrigger struct {
+	TokenSha256 string
+	Subject     string
+}
+
+// The NATS service (micro) as which the node registers a function, with its trigger subjects as
+// the service's endpoints
+type MicroService struct {
+	Name    string
+	Version string
+}
+
+// A probe declared by a workload, which the agent runs on an interval to determine
+// whether or not the workload is healthy
+type HealthCheck struct {
+	Type string `json:"type"`
+
+	// Command (and arguments) to run for exec probes; a zero exit code is healthy
+	Command []string `json:"command,omitempty"`
+	// URL to GET for http probes; any 2xx status is healthy
+	URL *string `json:"url,omitempty"`
+	// Subject to request for nats probes; any reply is healthy
+	Subject *string `json:"subject,omitempty"`
+	// NATS server used for nats probes. Defaults to the workload's NATS_URL environment variable
+	NatsUrl *string `json:"nats_url,omitempty"`
+
+	IntervalMillis int `json:"interval_ms,omitempty"`
+	TimeoutMillis  int `json:"timeout_ms,omitempty"`
+}
+
+func (h *HealthCheck) Validate() error {
+	switch strings.ToLower(h.Type) {
+	case HealthCheckTypeExec:
+		if len(h.Command) == 0 {
+			return errors.New("exec health check requires a command")
+		}
+	case HealthCheckTypeHTTP:
+		if h.URL == nil {
+			return errors.New("http health check requires a url")
+		}
+	case HealthCheckTypeNATS:
+		if h.Subject == nil {
+			return errors.New("nats health check requires a subject")
+		}
+	default:
+		return fmt.Errorf("unsupported health check type: %s", h.Type)
+	}
+
+	if h.IntervalMillis < 0 || h.TimeoutMillis < 0 {
+		return errors.New("health check interval and timeout must be positive")
+	}
+
+	return nil
+}
+
+// Interval between health checks, falling back to the default when unspecified
+func (h *HealthCheck) Interval() time.Duration {
+	if h.IntervalMillis == 0 {
+		return DefaultHealthCheckIntervalMillis * time.Millisecond
+	}
+	return time.Duration(h.IntervalMillis) * time.Millisecond
+}
+
+// Timeout for a single health check, falling back to the default when unspecified
+func (h *HealthCheck) Timeout() time.Duration {
+	if h.TimeoutMillis == 0 {
+		return DefaultHealthCheckTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(h.TimeoutMillis) * time.Millisecond
+}
+
+// Restricts the destinations the workload's machine may connect to. The node drops all other
+// traffic from the machine, other than to the node's internal NATS server and, if allowed, DNS
+type EgressPolicy struct {
+	Allow []EgressRule `json:"allow"`
+	// Allows DNS queries (port 53) to any destination
+	AllowDNS bool `json:"allow_dns,omitempty"`
+}
+
+// A destination the workload may connect to, given as either an IPv4 network (or address) or a
+// DNS name. DNS names are resolved by the node when the workload is deployed
+type EgressRule struct {
+	CIDR *string `json:"cidr,omitempty"`
+	Host *string `json:"host,omitempty"`
+	// Destination ports; all ports when empty
+	Ports []int `json:"ports,omitempty"`
+	// Either tcp (the default) or udp
+	Protocol string `json:"protocol,omitempty"`
+}
+
+func (p *EgressPolicy) Validate() error {
+	var err error
+	for i, rule := range p.Allow {
+		if ruleErr := rule.Validate(); ruleErr != nil {
+			err = errors.Join(err, fmt.Errorf("egress rule %d: %s", i, ruleErr))
+		}
+	}
+	return err
+}
+
+func (r *EgressRule) Validate() error {
+	if (r.CIDR == nil) == (r.Host == nil) {
+		return errors.New("egress rule requires exactly one of a cidr or host")
+	}
+
+	if r.CIDR != nil {
+		if _, err := r.Network(); err != nil {
+			return err
+		}
+	}
+
+	if r.Host != nil && strings.TrimSpace(*r.Host) == "" {
+		return errors.New("egress rule host must not be empty")
+	}
+
+	for _, port := range r.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid egress port: %d", port)
+		}
+	}
+
+	switch strings.ToLower(r.Protocol) {
+	case "", EgressProtocolTCP, EgressProtocolUDP:
+	default:
+		return fmt.Errorf("unsupported egress protocol: %s", r.Protocol)
+	}
+
+	return nil
+}
+
+// Returns the IPv4 network of a rule given as a CIDR, treating a bare address as a /32
+func (r *EgressRule) Network() (*net.IPNet, error) {
+	cidr := *r.CIDR
+	if !strings.Contains(cidr, "/") {
+		cidr += "/32"
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || network.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid egress cidr: %s", *r.CIDR)
+	}
+	return network, nil
+}
+
+// Returns the rule's protocol, falling back to tcp when unspecified
+func (r *EgressRule) EffectiveProtocol() string {
+	if r.Protocol == "" {
+		return EgressProtocolTCP
+	}
+	return strings.ToLower(r.Protocol)
+}
+
+// A soft limit on the memory of the workload's machine. When the machine's memory use (or,
+// where the kernel reports it, memory pressure) crosses the limit, the agent notifies the
+// workload ahead of the hard out-of-memory limit so it can shed caches gracefully
+type MemorySoftLimit struct {
+	// Percentage of the machine's memory in use, per /proc/meminfo, at which the workload is notified
+	ThresholdPercent int `json:"threshold_percent,omitempty"`
+	// Percentage of the last 10 seconds in which tasks stalled on memory, per /proc/pressure/memory,
+	// at which the workload is notified
+	PressurePercent int `json:"pressure_percent,omitempty"`
+	// Signal sent to the workload's process in addition to the memory pressure event
+	Signal *string `json:"signal,omitempty"`
+
+	IntervalMillis int `json:"interval_ms,omitempty"`
+}
+
+func (l *MemorySoftLimit) Validate() error {
+	if l.ThresholdPercent == 0 && l.PressurePercent == 0 {
+		return errors.New("memory soft limit requires a threshold or pressure percentage")
+	}
+
+	if l.ThresholdPercent < 0 || l.ThresholdPercent > 99 {
+		return errors.New("memory soft limit threshold must be between 1 and 99 percent")
+	}
+
+	if l.PressurePercent < 0 || l.PressurePercent > 100 {
+		return errors.New("memory soft limit pressure must be between 1 and 100 percent")
+	}
+
+	if l.Signal != nil {
+		switch strings.ToUpper(*l.Signal) {
+		case MemorySoftLimitSignalUSR1, MemorySoftLimitSignalUSR2, MemorySoftLimitSignalHUP:
+		default:
+			return fmt.Errorf("unsupported memory soft limit signal: %s", *l.Signal)
+		}
+	}
+
+	if l.IntervalMillis < 0 {
+		return errors.New("memory soft limit interval must be positive")
+	}
+
+	return nil
+}
+
+// Interval on which memory use is sampled, falling back to the default when unspecified
+func (l *MemorySoftLimit) Interval() time.Duration {
+	if l.IntervalMillis == 0 {
+		return DefaultMemorySoftLimitIntervalMillis * time.Millisecond
+	}
+	return time.Duration(l.IntervalMillis) * time.Millisecond
+}
+
+// A cosign signature (and optional attestation and bundle) of a workload artifact, retained
+// so the artifact can be verified again when the workload is redeployed
+type ArtifactSignature struct {
+	Signature   string  `json:"signature"`
+	Certificate *string `json:"certificate,omitempty"`
+	Attestation *string `json:"attestation,omitempty"`
+	Bundle      *string `json:"bundle,omitempty"`
+}
+
+// The outcome of the node's verification of a workload artifact's signature
+type ArtifactProvenance struct {
+	Verified      bool   `json:"verified"`
+	Signer        string `json:"signer,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+	Attested      bool   `json:"attested,omitempty"`
+	PredicateType string `json:"predicate_type,omitempty"`
+}
+
+// The host services exposed to a v8 or wasm workload, as resolved by the node from the
+// workload's sandbox profile
+type HostServicesPolicy struct {
+	Profile  string   `json:"profile"`
+	Services []string `json:"services"`
+}
+
+// Returns true if the policy exposes the given host service; a nil policy exposes all of them
+func (p *HostServicesPolicy) Exposes(service string) bool {
+	return p == nil || slices.Contains(p.Services, service)
+}
+
+// The outcome of a scanner the node ran against a workload artifact before deploying it
+type ArtifactScanResult struct {
+	Scanner string `json:"scanner"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// Short-lived NATS user credentials minted by the node for a workload. The agent writes
+// the creds to a file in the sandbox and points the workload at it via NATS_CREDS
+type Credentials struct {
+	PublishAllow   []string `json:"publish_allow,omitempty"`
+	SubscribeAllow []string `json:"subscribe_allow,omitempty"`
+	TTLSeconds     int      `json:"ttl_seconds,omitempty"`
+
+	// Public key of the minted user and the formatted creds (JWT and seed)
+	User  string `json:"user,omitempty"`
+	Creds string `json:"creds,omitempty"`
+
+	// Prefix beneath which the workload must make its inboxes, the only ones it may subscribe to
+	InboxPrefix string `json:"inbox_prefix,omitempty"`
+}
+
+// A command run by the agent inside the sandbox at a point in the workload's lifecycle,
+// e.g., to run database migrations before the workload starts
+type WorkloadHook struct {
+	// Command (and arguments) to run; a non-zero exit code is considered a failure
+	Command       []string `json:"command"`
+	TimeoutMillis int      `json:"timeout_ms,omitempty"`
+}
+
+func (h *WorkloadHook) Validate() error {
+	if len(h.Command) == 0 {
+		return errors.New("workload hook requires a command")
+	}
+
+	if h.TimeoutMillis < 0 {
+		return errors.New("workload hook timeout must be positive")
+	}
+
+	return nil
+}
+
+// Timeout for the hook, falling back to the default when unspecified
+func (h *WorkloadHook) Timeout() time.Duration {
+	if h.TimeoutMillis == 0 {
+		return DefaultWorkloadHookTimeoutMillis * time.Millisecond
+	}
+	return time.Duration(h.TimeoutMillis) * time.Millisecond
+}
+
+// Published by the agent on agentint.{vmid}.health each time the workload's health check runs
+type HealthCheckResult struct {
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at"`
+	Message   *string   `json:"message,omitempty"`
+}
+
+// Published by the agent on agentint.{vmid}.memory on an interval, describing the memory use
+// of the workload's machine. Peak is the most memory in use at any sample since the workload
+// was deployed
+type MemoryReport struct {
+	UsedMib   int64     `json:"used_mib"`
+	PeakMib   int64     `json:"peak_mib"`
+	TotalMib  int64     `json:"total_mib"`
+	OOMKills  uint64    `json:"oom_kills"`
+	SampledAt time.Time `json:"sampled_at"`
+
+	// Only present for functions
+	Triggers *TriggerExecutionReport `json:"triggers,omitempty"`
+}
+
+// The trigger messages a function's agent has received but not yet begun executing, those it's
+// executing, and the total it has executed since the function was deployed
+type TriggerExecutionReport struct {
+	Queued   int    `json:"queued"`
+	Active   int    `json:"active"`
+	Executed uint64 `json:"executed"`
+}
+
 type DeployResponse struct {
 	Accepted bool    `json:"accepted"`
 	Message  *string `json:"message"`
+	// The workload was accepted but is still initializing. Whether it initialized successfully
+	// is reported afterwards in a workload ready event
+	Initializing bool `json:"initializing,omitempty"`
 }
 
 type HandshakeRequest struct {
 	MachineID *string   `json:"machine_id"`
 	StartTime time.Time `json:"start_time"`
-	Message   *string   `json:"message,omitempty"`
+	// When the agent requested the handshake, having connected to the node
+	SentAt  *time.Time `json:"sent_at,omitempty"`
+	Message *string    `json:"message,omitempty"`
 }
 
 type HandshakeResponse struct {
@@ -157,17 +889,34 @@ type HostServicesMessagingRequest struct {
 	Payload *json.RawMessage `json:"payload,omitempty"`
 }
 
+type HostServicesSecretRequest struct {
+	Name  *string `json:"name"`
+	Value *string `json:"value,omitempty"`
+}
+
 type HostServicesMessagingResponse struct {
 	Errors  []string `json:"errors,omitempty"`
 	Success bool     `json:"success,omitempty"`
 }
 
+// A message received by one of a workload's messaging subscriptions, streamed to the workload
+// by the agent as a line of JSON
+type HostServicesSubscriptionMessage struct {
+	Subject string              `json:"subject"`
+	Reply   string              `json:"reply,omitempty"`
+	Header  map[string][]string `json:"header,omitempty"`
+	Data    []byte              `json:"data"`
+}
+
 type MachineMetadata struct {
 	VmID         *string `json:"vmid"`
 	NodeNatsHost *string `json:"node_nats_host"`
 	NodeNatsPort *int    `json:"node_nats_port"`
 	Message      *string `json:"message"`
 
+	// Seed of the nkey issued to the agent for connecting to the internal NATS server
+	NodeNatsNkeySeed *string `json:"node_nats_nkey_seed,omitempty"`
+
 	Errors []error `json:"errors,omitempty"`
 }
 
@@ -0,0 +1,140 @@
+// Package compat contains helpers for evolving the control and agent API
+// message formats without breaking older (or newer) clients and agents.
+//
+// Messages that may be relayed by a component running a different version of nex
+// carry an UnknownFields field. Any JSON field that isn't known to the receiving version is
+// captured during deserialization and written back out, verbatim, when the message
+// is serialized again, so fields added by newer versions survive a round trip
+// through older ones.
+package compat
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Fields encountered while unmarshaling a message that aren't known to this version of
+// the API, keyed by their JSON name
+type UnknownFields map[string]json.RawMessage
+
+// Unmarshal the given JSON into v (which must be a pointer to a struct) and return any
+// top-level fields that don't correspond to a field of v. Field names are matched
+// case-insensitively, just like encoding/json does.
+func UnmarshalPreservingUnknown(data []byte, v interface{}) (UnknownFields, error) {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		// not an object, so there's nothing to preserve
+		return nil, nil
+	}
+
+	known := knownFields(reflect.TypeOf(v))
+
+	var unknown UnknownFields
+	for name, value := range raw {
+		if _, ok := known[strings.ToLower(name)]; ok {
+			continue
+		}
+
+		if unknown == nil {
+			unknown = make(UnknownFields)
+		}
+		unknown[name] = value
+	}
+
+	return unknown, nil
+}
+
+// Marshal v and append the given unknown fields to the resulting JSON object. Unknown
+// fields never overwrite a field known to this version of the API.
+func MarshalPreservingUnknown(v interface{}, unknown UnknownFields) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unknown) == 0 {
+		return data, nil
+	}
+
+	known := knownFields(reflect.TypeOf(v))
+
+	names := make([]string, 0, len(unknown))
+	for name := range unknown {
+		if _, ok := known[strings.ToLower(name)]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return data, nil
+	}
+
+	buf := bytes.NewBuffer(bytes.TrimSuffix(bytes.TrimSpace(data), []byte("}")))
+	empty := bytes.Equal(bytes.TrimSpace(buf.Bytes()), []byte("{"))
+
+	for _, name := range names {
+		if !empty {
+			buf.WriteByte(',')
+		}
+		empty = false
+
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(unknown[name])
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// Returns the (lowercased) JSON names of all fields, including those promoted from
+// embedded structs, that encoding/json would consider when (de)serializing the given type
+func knownFields(t reflect.Type) map[string]struct{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	fields := make(map[string]struct{})
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			for embedded := range knownFields(f.Type) {
+				fields[embedded] = struct{}{}
+			}
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = struct{}{}
+	}
+
+	return fields
+}
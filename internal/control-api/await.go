@@ -0,0 +1,142 @@
+package controlapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/nats-io/nats.go"
+)
+
+// Interval between the descriptions of a workload requested while awaiting its readiness
+const awaitPollInterval = 250 * time.Millisecond
+
+// The outcome of awaiting the readiness of a workload after it was run. A workload is ready once
+// its machine is running the workload and, if it declared a health check, its first health check
+// has passed. Reason explains why a workload which is not ready failed
+type WorkloadReadiness struct {
+	Ready     bool   `json:"ready"`
+	Reason    string `json:"reason,omitempty"`
+	NodeId    string `json:"node_id"`
+	MachineId string `json:"machine_id"`
+	Name      string `json:"name"`
+	State     string `json:"state,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	DNSName   string `json:"dns_name,omitempty"`
+
+	MachineStarted  *time.Time `json:"machine_started,omitempty"`
+	WorkloadStarted *time.Time `json:"workload_started,omitempty"`
+	// Only present once the workload's machine has completed its handshake
+	Boot *MachineBootTimings `json:"boot,omitempty"`
+	// How long the workload took to become ready (or fail) after being awaited
+	Elapsed time.Duration `json:"elapsed"`
+
+	// The most recent entries in the timeline of the workload's machine
+	Events []TimelineEntry `json:"events,omitempty"`
+}
+
+// Awaits the readiness of a workload the given node reported as started in the given run
+// response, for up to the given timeout. The node is taken from the run response when the
+// workload was run on a cluster. The workload's lifecycle events are watched for its failure,
+// and it is described until it's ready. An error is returned along with the outcome when the
+// workload fails or isn't ready before the timeout
+func (api *Client) AwaitWorkload(nodeId string, run *RunResponse, timeout time.Duration) (*WorkloadReadiness, error) {
+	if run == nil || !run.Started {
+		return nil, errors.New("workload was not started")
+	}
+	if run.NodeId != "" {
+		nodeId = run.NodeId
+	}
+
+	started := time.Now()
+	readiness := &WorkloadReadiness{
+		NodeId:    nodeId,
+		MachineId: run.MachineId,
+		Name:      run.Name,
+	}
+
+	failures := make(chan WorkloadLifecycleEvent, 1)
+	sub, err := api.nc.Subscribe(fmt.Sprintf("%s.events.%s.%s", APIPrefix, api.namespace, WorkloadLifecycleEventType), func(m *nats.Msg) {
+		event := cloudevents.NewEvent()
+		if json.Unmarshal(m.Data, &event) != nil {
+			return
+		}
+
+		var lifecycle WorkloadLifecycleEvent
+		if event.DataAs(&lifecycle) != nil || lifecycle.MachineId != run.MachineId || lifecycle.NodeId != nodeId {
+			return
+		}
+		if lifecycle.State == WorkloadStateFailed || lifecycle.State == WorkloadStateStopped {
+			select {
+			case failures <- lifecycle:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(awaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		description, err := api.DescribeWorkload(nodeId, run.MachineId)
+		switch {
+		case err == nil:
+			readiness.describe(description)
+			if readiness.Ready {
+				readiness.Elapsed = time.Since(started)
+				return readiness, nil
+			}
+		case errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders):
+			// the node may be too busy starting the workload to respond in time
+		default:
+			readiness.Reason = fmt.Sprintf("workload is no longer known to the node: %s", err)
+			readiness.Elapsed = time.Since(started)
+			return readiness, fmt.Errorf("workload %s failed: %s", run.MachineId, readiness.Reason)
+		}
+
+		select {
+		case lifecycle := <-failures:
+			readiness.State = lifecycle.State
+			readiness.Reason = lifecycle.Reason
+			if readiness.Reason == "" {
+				readiness.Reason = fmt.Sprintf("workload %s", lifecycle.State)
+			}
+			readiness.Elapsed = time.Since(started)
+			return readiness, fmt.Errorf("workload %s failed: %s", run.MachineId, readiness.Reason)
+		case <-deadline:
+			readiness.Reason = fmt.Sprintf("workload was not ready after %s", timeout)
+			readiness.Elapsed = time.Since(started)
+			return readiness, errors.New(readiness.Reason)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *WorkloadReadiness) describe(description *DescribeResponse) {
+	r.State = description.State
+	r.IP = description.IP
+	r.DNSName = description.DNSName
+	r.MachineStarted = description.MachineStarted
+	r.WorkloadStarted = description.WorkloadStarted
+	r.Boot = description.Boot
+	r.Events = description.Events
+	if description.Workload.Name != "" {
+		r.Name = description.Workload.Name
+	}
+
+	// functions which have scaled to zero are started again by their next trigger
+	if description.State == MachineStateScaledToZero {
+		r.Ready = true
+		return
+	}
+
+	checked := description.Request.HealthCheck == nil || description.LastHealthCheck != nil
+	r.Ready = description.State == MachineStateRunning && description.Healthy && checked
+}
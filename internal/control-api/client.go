@@ -6,18 +6,37 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 )
 
 // API subjects:
 // $NEX.PING
 // $NEX.PING.{node}
+// $NEX.PREFLIGHT
+// $NEX.PREFLIGHT.{node}
+// $NEX.UPDATE.{node}
+// $NEX.STANDBY.{node}
+// $NEX.PAUSE.{node}
 // $NEX.INFO.{namespace}.{node}
 // $NEX.RUN.{namespace}.{node}
 // $NEX.STOP.{namespace}.{node}
+// $NEX.STOPALL.{namespace}.{node}
+// $NEX.SUBJECTS.{namespace}.{node}
+// $NEX.DESCRIBE.{namespace}.{node}
+// $NEX.MEMORY.{namespace}.{node}
+// $NEX.RESERVE.{namespace}.{node}
+// $NEX.AUDIT.{namespace}.{node}
+// $NEX.UPDATEWORKLOAD.{namespace}.{node}
+// $NEX.PROMOTE.{namespace}.{node}
+// $NEX.ROLES.{namespace}.{node}
+// $NEX.CLUSTER.{cluster}
+// $NEX.CLUSTERDEPLOY.{namespace}.{cluster}
+// $NEX.DEPLOYSET.{namespace}.{cluster}.{operation}
 
 type Client struct {
 	nc        *nats.Conn
@@ -57,6 +76,186 @@ func (api *Client) StopWorkload(stopRequest *StopRequest) (*StopResponse, error)
 
 }
 
+// Attempts to stop all of the workloads in the client's namespace on the target node which match the
+// request's label selector. The response contains the result of each individual stop, so callers should
+// check for partial failures
+func (api *Client) StopWorkloads(request *BulkStopRequest) (*BulkStopResponse, error) {
+	subject := fmt.Sprintf("%s.STOPALL.%s.%s", APIPrefix, api.namespace, request.TargetNode)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response BulkStopResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Retrieves the timeline of a single machine (running or recently stopped) on the given node
+func (api *Client) MachineTimeline(nodeId string, workloadId string) (*TimelineResponse, error) {
+	subject := fmt.Sprintf("%s.TIMELINE.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, &TimelineRequest{WorkloadId: workloadId})
+	if err != nil {
+		return nil, err
+	}
+
+	var response TimelineResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Retrieves everything the given node knows about a single workload: its deploy request (redacted)
+// and the defaults applied to it, its machine, resources, health and recent events
+func (api *Client) DescribeWorkload(nodeId string, workloadId string) (*DescribeResponse, error) {
+	subject := fmt.Sprintf("%s.DESCRIBE.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, &DescribeRequest{WorkloadId: workloadId})
+	if err != nil {
+		return nil, err
+	}
+
+	var response DescribeResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Retrieves the memory use the given node has observed of the namespace's workloads, along with
+// a recommended memory size for each. An empty workload name includes every workload
+func (api *Client) WorkloadMemory(nodeId string, workloadName string) (*MemoryResponse, error) {
+	subject := fmt.Sprintf("%s.MEMORY.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, &MemoryRequest{WorkloadName: workloadName})
+	if err != nil {
+		return nil, err
+	}
+
+	var response MemoryResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Retrieves the namespace's recent entries in the audit log of the given node, most recent last
+func (api *Client) AuditLog(nodeId string, request *AuditRequest) (*AuditResponse, error) {
+	subject := fmt.Sprintf("%s.AUDIT.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response AuditResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Instructs the given node to update itself to a signed nex binary in an object store. The node
+// responds once it has installed the binary, after which it restarts
+func (api *Client) UpdateNode(nodeId string, request *NodeUpdateRequest) (*NodeUpdateResponse, error) {
+	subject := fmt.Sprintf("%s.UPDATE.%s", APIPrefix, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response NodeUpdateResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Activates the given node from standby, warming its machine pools. Activating an active node has
+// no effect
+func (api *Client) ActivateNode(nodeId string) (*StandbyResponse, error) {
+	return api.standby(nodeId, &StandbyRequest{Standby: false})
+}
+
+// Returns the given node to standby, stopping its warm machines. Workloads running on the node are
+// left running
+func (api *Client) StandbyNode(nodeId string) (*StandbyResponse, error) {
+	return api.standby(nodeId, &StandbyRequest{Standby: true})
+}
+
+func (api *Client) standby(nodeId string, request *StandbyRequest) (*StandbyResponse, error) {
+	subject := fmt.Sprintf("%s.STANDBY.%s", APIPrefix, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response StandbyResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Pauses the creation of machines on the given node for the given duration, after which the node
+// resumes refilling its machine pools. Workloads may still be deployed to its warm machines
+func (api *Client) PauseNode(nodeId string, duration time.Duration) (*PauseResponse, error) {
+	return api.pause(nodeId, &PauseRequest{DurationSeconds: int(duration.Seconds())})
+}
+
+// Resumes the creation of machines on the given node before its pause expires
+func (api *Client) ResumeNode(nodeId string) (*PauseResponse, error) {
+	return api.pause(nodeId, &PauseRequest{Resume: true})
+}
+
+func (api *Client) pause(nodeId string, request *PauseRequest) (*PauseResponse, error) {
+	subject := fmt.Sprintf("%s.PAUSE.%s", APIPrefix, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response PauseResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Reserves the given node exclusively for the client's namespace for the given duration, during
+// which the node rejects deploy requests from other namespaces
+func (api *Client) ReserveNode(nodeId string, duration time.Duration) (*ReserveResponse, error) {
+	return api.reserve(nodeId, &ReserveRequest{DurationSeconds: int(duration.Seconds())})
+}
+
+// Releases the client namespace's reservation of the given node
+func (api *Client) ReleaseNode(nodeId string) (*ReserveResponse, error) {
+	return api.reserve(nodeId, &ReserveRequest{Release: true})
+}
+
+func (api *Client) reserve(nodeId string, request *ReserveRequest) (*ReserveResponse, error) {
+	subject := fmt.Sprintf("%s.RESERVE.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ReserveResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
 // Attempts to start a workload. The workload URI, at the moment, must always point to a NATS object store
 // bucket in the form of `nats://{bucket}/{key}`
 func (api *Client) StartWorkload(request *DeployRequest) (*RunResponse, error) {
@@ -74,10 +273,82 @@ func (api *Client) StartWorkload(request *DeployRequest) (*RunResponse, error) {
 	return &response, nil
 }
 
+// Retrieves the live members of the given cluster from its leader, including the leader's public
+// xkey, for which the environment of workloads deployed to the cluster must be encrypted
+func (api *Client) ClusterInfo(cluster string) (*ClusterResponse, error) {
+	subject := fmt.Sprintf("%s.CLUSTER.%s", APIPrefix, cluster)
+	bytes, err := api.performRequest(subject, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ClusterResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Asks the leader of the given cluster to deploy a workload to the node best able to run it. The
+// response names the chosen node
+func (api *Client) StartClusterWorkload(cluster string, request *ClusterDeployRequest) (*RunResponse, error) {
+	subject := fmt.Sprintf("%s.CLUSTERDEPLOY.%s.%s", APIPrefix, api.namespace, cluster)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response RunResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Fetches the given node's public xkey and seals each of the given environment values to it,
+// returning an option which adds them to a deploy request targeting that node. The same
+// sender xkey must be supplied to the deploy request with SenderXKey
+func (api *Client) SealEnvironment(nodeId string, senderXKey nkeys.KeyPair, env map[string]string) (RequestOption, error) {
+	if len(env) == 0 {
+		return func(o requestOptions) requestOptions { return o }, nil
+	}
+
+	info, err := api.NodeInfo(nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve public xkey of node %s: %s", nodeId, err)
+	}
+
+	sealed := make(map[string]string, len(env))
+	for key, value := range env {
+		sealed[key], err = SealEnvironmentValue(senderXKey, info.PublicXKey, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal environment value %s: %s", key, err)
+		}
+	}
+
+	return func(o requestOptions) requestOptions {
+		o = TargetPublicXKey(info.PublicXKey)(o)
+		return SealedEnvironment(sealed)(o)
+	}, nil
+}
+
 // Requests information for a given node within the client's namespace
 func (api *Client) NodeInfo(nodeId string) (*InfoResponse, error) {
+	return api.NodeInfoMatching(nodeId, nil)
+}
+
+// Requests information for a given node within the client's namespace, only including the
+// machines running workloads whose labels match the given selector
+func (api *Client) NodeInfoMatching(nodeId string, selector map[string]string) (*InfoResponse, error) {
+	var request interface{}
+	if len(selector) > 0 {
+		request = &InfoRequest{Selector: selector}
+	}
+
 	subject := fmt.Sprintf("%s.INFO.%s.%s", APIPrefix, api.namespace, nodeId)
-	bytes, err := api.performRequest(subject, nil)
+	bytes, err := api.performRequest(subject, request)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +388,7 @@ func (api *Client) ListNodes() ([]PingResponse, error) {
 	if err != nil {
 		return nil, nil
 	}
-	msg := nats.NewMsg(fmt.Sprintf("%s.PING", APIPrefix))
+	msg := newVersionedMsg(fmt.Sprintf("%s.PING", APIPrefix), nil)
 	msg.Reply = sub.Subject
 	err = api.nc.PublishMsg(msg)
 	if err != nil {
@@ -128,6 +399,86 @@ func (api *Client) ListNodes() ([]PingResponse, error) {
 	return responses, nil
 }
 
+// Retrieves the subjects the given node uses for the client's namespace: its control requests,
+// events, logs, and the triggers and host services of its deployed workloads
+func (api *Client) NamespaceSubjects(nodeId string) (*SubjectsResponse, error) {
+	subject := fmt.Sprintf("%s.SUBJECTS.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response SubjectsResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Runs the preflight checks of the given node remotely, returning its report
+func (api *Client) NodePreflight(nodeId string) (*PreflightResponse, error) {
+	subject := fmt.Sprintf("%s.PREFLIGHT.%s", APIPrefix, nodeId)
+	bytes, err := api.performRequest(subject, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response PreflightResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Runs the preflight checks of every visible node, returning the reports received before the
+// client's timeout elapses
+func (api *Client) FleetPreflight() ([]PreflightResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	responses := make([]PreflightResponse, 0)
+
+	sub, err := api.nc.Subscribe(api.nc.NewRespInbox(), func(m *nats.Msg) {
+		env, err := extractEnvelope(m.Data)
+		if err != nil {
+			return
+		}
+		var resp PreflightResponse
+		bytes, err := json.Marshal(env.Data)
+		if err != nil {
+			return
+		}
+		err = json.Unmarshal(bytes, &resp)
+		if err != nil {
+			return
+		}
+
+		mutex.Lock()
+		responses = append(responses, resp)
+		mutex.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	msg := newVersionedMsg(fmt.Sprintf("%s.PREFLIGHT", APIPrefix), nil)
+	msg.Reply = sub.Subject
+	err = api.nc.PublishMsg(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	<-ctx.Done()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return responses, nil
+}
+
 // A convenience function that subscribes to all available logs and uses
 // an unbuffered, blocking channel
 func (api *Client) MonitorAllLogs() (chan EmittedLog, error) {
@@ -144,11 +495,7 @@ func (api *Client) MonitorLogs(
 	vmFilter string,
 	bufferLength int) (chan EmittedLog, error) {
 
-	subject := fmt.Sprintf("%s.logs.%s.%s.%s.%s", APIPrefix,
-		namespaceFilter,
-		nodeFilter,
-		workloadFilter,
-		vmFilter)
+	subject := LogSubject(namespaceFilter, workloadFilter, nodeFilter, vmFilter)
 
 	logChannel := make(chan EmittedLog, bufferLength)
 	_, err := api.nc.Subscribe(subject, handleLogEntry(api, logChannel))
@@ -173,7 +520,7 @@ func (api *Client) MonitorEvents(
 	eventTypeFilter string,
 	bufferLength int) (chan EmittedEvent, error) {
 
-	subscribeSubject := fmt.Sprintf("%s.events.%s.*", APIPrefix, namespaceFilter)
+	subscribeSubject := EventSubject(namespaceFilter, "*")
 
 	eventChannel := make(chan EmittedEvent, bufferLength)
 
@@ -184,8 +531,8 @@ func (api *Client) MonitorEvents(
 
 	// Add a monitor for the system namespace if the supplied filter doesn't
 	// already include it
-	if namespaceFilter != "*" && namespaceFilter != "system" {
-		systemSub := fmt.Sprintf("%s.events.system.*", APIPrefix)
+	if namespaceFilter != "*" && namespaceFilter != SystemNamespace {
+		systemSub := EventSubject(SystemNamespace, "*")
 		_, err = api.nc.Subscribe(systemSub, handleEventEntry(api, eventChannel))
 		if err != nil {
 			return nil, err
@@ -221,15 +568,6 @@ func handleEventEntry(api *Client, ch chan EmittedEvent) func(m *nats.Msg) {
 
 func handleLogEntry(api *Client, ch chan EmittedLog) func(m *nats.Msg) {
 	return func(m *nats.Msg) {
-		/*
-			$NEX.logs.{namespace}.{node}.{workload}.{vm}
-		*/
-		tokens := strings.Split(m.Subject, ".")
-		if len(tokens) != 6 {
-			api.log.Debug("token length not 6", "length", len(tokens), "subject", m.Subject)
-			return
-		}
-
 		var logEntry RawLog
 		err := json.Unmarshal(m.Data, &logEntry)
 		if err != nil {
@@ -237,11 +575,17 @@ func handleLogEntry(api *Client, ch chan EmittedLog) func(m *nats.Msg) {
 			return
 		}
 
+		tokens, ok := ParseLogSubject(m.Subject, logEntry.MachineId)
+		if !ok {
+			api.log.Debug("Skipping log entry on an unexpected subject", "subject", m.Subject)
+			return
+		}
+
 		ch <- EmittedLog{
-			Namespace: tokens[2],
-			NodeId:    tokens[3],
-			Workload:  tokens[4],
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Namespace: tokens.Namespace,
+			NodeId:    tokens.NodeId,
+			Workload:  tokens.Workload,
+			Timestamp: logEntry.LoggedAt(time.Now()).UTC().Format(time.RFC3339Nano),
 			RawLog:    logEntry,
 		}
 	}
@@ -262,7 +606,7 @@ func (api *Client) performRequest(subject string, raw interface{}) ([]byte, erro
 		}
 	}
 
-	resp, err := api.nc.Request(subject, bytes, api.timeout)
+	resp, err := api.nc.RequestMsg(newVersionedMsg(subject, bytes), api.timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -270,8 +614,41 @@ func (api *Client) performRequest(subject string, raw interface{}) ([]byte, erro
 	if err != nil {
 		return nil, err
 	}
+	err = checkEnvelopeVersion(env)
+	if err != nil {
+		return nil, err
+	}
+	if env.PayloadType == QuotaExceededResponseType {
+		var quotaErr QuotaExceededResponse
+		raw, _ := json.Marshal(env.Data)
+		if json.Unmarshal(raw, &quotaErr) == nil {
+			return nil, &quotaErr
+		}
+	}
+	if env.PayloadType == NodeReservedResponseType {
+		var reservedErr NodeReservedResponse
+		raw, _ := json.Marshal(env.Data)
+		if json.Unmarshal(raw, &reservedErr) == nil {
+			return nil, &reservedErr
+		}
+	}
+	if env.PayloadType == TriggerSubjectRejectedResponseType {
+		var rejectedErr TriggerSubjectRejectedResponse
+		raw, _ := json.Marshal(env.Data)
+		if json.Unmarshal(raw, &rejectedErr) == nil {
+			return nil, &rejectedErr
+		}
+	}
 	if env.Error != nil {
-		return nil, fmt.Errorf("%v", env.Error)
+		reqErr := &RequestError{Code: env.Code, Message: fmt.Sprintf("%v", env.Error)}
+		if env.Code == ErrorCodeInvalidRequest && env.Data != nil {
+			var failure ValidationFailure
+			raw, _ := json.Marshal(env.Data)
+			if json.Unmarshal(raw, &failure) == nil {
+				reqErr.Fields = failure.Fields
+			}
+		}
+		return nil, reqErr
 	}
 	return json.Marshal(env.Data)
 }
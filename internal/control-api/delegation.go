@@ -0,0 +1,112 @@
+package controlapi
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// Value of the "type" claim of a delegation JWT
+	DelegationClaimType = "nex_delegation"
+
+	// Maximum number of delegation JWTs in an issuer chain
+	MaxIssuerChainLength = 5
+)
+
+// Creates a JWT, signed by the issuer, which authorizes the delegate signing key to issue
+// workload JWTs on the issuer's behalf. The issuer is typically a root key trusted by nodes via
+// their valid issuers, but may itself be a delegate, in which case its own delegation JWT must
+// precede this one in the issuer chain. When namespaces are given, the delegate may only deploy
+// workloads to those namespaces. An expiry of zero creates a delegation that doesn't expire
+func CreateDelegationJwt(delegate string, namespaces []string, expiry time.Duration, issuer nkeys.KeyPair) (string, error) {
+	if !nkeys.IsValidPublicKey(delegate) {
+		return "", fmt.Errorf("invalid delegate public key: %s", delegate)
+	}
+
+	claims := jwt.NewGenericClaims(delegate)
+	claims.Data["type"] = DelegationClaimType
+	if len(namespaces) > 0 {
+		claims.Data["namespaces"] = namespaces
+	}
+	if expiry > 0 {
+		claims.Expires = time.Now().Add(expiry).Unix()
+	}
+
+	return claims.Encode(issuer)
+}
+
+// Validates the request's issuer chain, if any, against the issuer of its (already validated)
+// workload JWT and returns the root issuer to which the workload's signing key chains. Each
+// delegation JWT in the chain must be valid and unexpired, be issued by the subject of the
+// delegation before it, and permit deployment to the given namespace. The subject of the final
+// delegation must be the workload JWT's issuer. Without an issuer chain, the root issuer is the
+// workload JWT's issuer
+func (request *DeployRequest) ValidateIssuerChain(namespace string) (string, error) {
+	signer := request.DecodedClaims.Issuer
+	if len(request.IssuerChain) == 0 {
+		return signer, nil
+	}
+	if len(request.IssuerChain) > MaxIssuerChainLength {
+		return "", fmt.Errorf("issuer chain exceeds maximum length of %d", MaxIssuerChainLength)
+	}
+
+	// walk the chain from the workload's signing key back to the root
+	for i := len(request.IssuerChain) - 1; i >= 0; i-- {
+		claims, err := jwt.DecodeGeneric(request.IssuerChain[i])
+		if err != nil {
+			return "", fmt.Errorf("could not decode delegation JWT %d: %s", i, err)
+		}
+
+		var vr jwt.ValidationResults
+		claims.Validate(&vr)
+		if len(vr.Issues) > 0 || len(vr.Errors()) > 0 {
+			return "", fmt.Errorf("delegation JWT %d is not valid or has expired", i)
+		}
+
+		if claimType, _ := claims.Data["type"].(string); claimType != DelegationClaimType {
+			return "", fmt.Errorf("JWT %d in issuer chain is not a delegation", i)
+		}
+		if claims.Subject != signer {
+			return "", fmt.Errorf("delegation JWT %d does not delegate to signing key %s", i, signer)
+		}
+		if claims.Issuer == claims.Subject {
+			return "", fmt.Errorf("delegation JWT %d is self-issued", i)
+		}
+
+		if namespaces, ok := claims.Data["namespaces"]; ok {
+			allowed, err := delegationNamespaces(namespaces)
+			if err != nil {
+				return "", fmt.Errorf("delegation JWT %d: %s", i, err)
+			}
+			if !slices.Contains(allowed, namespace) {
+				return "", fmt.Errorf("delegation JWT %d does not permit deployment to namespace %s", i, namespace)
+			}
+		}
+
+		signer = claims.Issuer
+	}
+
+	return signer, nil
+}
+
+func delegationNamespaces(claim interface{}) ([]string, error) {
+	values, ok := claim.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid namespaces claim")
+	}
+
+	namespaces := make([]string, 0, len(values))
+	for _, v := range values {
+		ns, ok := v.(string)
+		if !ok {
+			return nil, errors.New("invalid namespaces claim")
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
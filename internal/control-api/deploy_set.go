@@ -0,0 +1,95 @@
+package controlapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Operations on deploy sets, the last token of $NEX.DEPLOYSET.{namespace}.{cluster}.{op}
+const (
+	DeploySetOpCreate = "CREATE"
+	DeploySetOpScale  = "SCALE"
+	DeploySetOpStatus = "STATUS"
+	DeploySetOpDelete = "DELETE"
+)
+
+// Asks the leader of a cluster to create, scale, describe or delete a deploy set: a number of
+// replicas of a workload the leader maintains across the cluster's members, replacing replicas
+// lost to failed workloads or departed members
+type DeploySetRequest struct {
+	Name string `json:"name"`
+	// The number of replicas to maintain, when creating or scaling the set
+	Replicas int `json:"replicas,omitempty"`
+	// Tags the members running replicas must have
+	NodeTags map[string]string `json:"node_tags,omitempty"`
+	// The workload to replicate when creating the set. Its environment must be encrypted for the
+	// leader, as with cluster deploy requests
+	Request *DeployRequest `json:"request,omitempty"`
+	// A JWT for the set's workload signed by its issuer, as in a stop request, authorizing the
+	// set to be scaled or deleted
+	IssuerJwt string `json:"issuer_jwt,omitempty"`
+}
+
+// A replica of a deploy set's workload
+type DeploySetReplica struct {
+	NodeId    string `json:"node_id"`
+	MachineId string `json:"machine_id"`
+}
+
+type DeploySetStatus struct {
+	Name      string             `json:"name"`
+	Namespace string             `json:"namespace"`
+	Workload  string             `json:"workload"`
+	Desired   int                `json:"desired"`
+	Replicas  []DeploySetReplica `json:"replicas"`
+	// Why the most recent attempt to deploy or stop a replica failed, if it did
+	LastError string `json:"last_error,omitempty"`
+}
+
+type DeploySetResponse struct {
+	Cluster string            `json:"cluster"`
+	Sets    []DeploySetStatus `json:"sets"`
+}
+
+// Creates a JWT authorizing a deploy set of the named workload to be scaled or deleted, signed
+// by the given signer, which must be the one that signed the set's deploy request
+func DeploySetIssuerJwt(workloadName string, signer ClaimsSigner) (string, error) {
+	return signer.Sign(jwt.NewGenericClaims(workloadName))
+}
+
+// Asks the leader of the given cluster to maintain the request's replicas of its workload
+func (api *Client) CreateDeploySet(cluster string, request *DeploySetRequest) (*DeploySetResponse, error) {
+	return api.deploySetRequest(cluster, DeploySetOpCreate, request)
+}
+
+// Changes the number of replicas of the named deploy set, stopping any in excess
+func (api *Client) ScaleDeploySet(cluster string, name string, replicas int, issuerJwt string) (*DeploySetResponse, error) {
+	return api.deploySetRequest(cluster, DeploySetOpScale, &DeploySetRequest{Name: name, Replicas: replicas, IssuerJwt: issuerJwt})
+}
+
+// Describes the named deploy set or, without a name, every deploy set in the client's namespace
+func (api *Client) DeploySetStatus(cluster string, name string) (*DeploySetResponse, error) {
+	return api.deploySetRequest(cluster, DeploySetOpStatus, &DeploySetRequest{Name: name})
+}
+
+// Stops every replica of the named deploy set and forgets it
+func (api *Client) DeleteDeploySet(cluster string, name string, issuerJwt string) (*DeploySetResponse, error) {
+	return api.deploySetRequest(cluster, DeploySetOpDelete, &DeploySetRequest{Name: name, IssuerJwt: issuerJwt})
+}
+
+func (api *Client) deploySetRequest(cluster string, op string, request *DeploySetRequest) (*DeploySetResponse, error) {
+	subject := fmt.Sprintf("%s.DEPLOYSET.%s.%s.%s", APIPrefix, api.namespace, cluster, op)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response DeploySetResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
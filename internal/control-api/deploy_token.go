@@ -0,0 +1,81 @@
+package controlapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// Value of the "type" claim of a deploy token
+const DeployTokenClaimType = "nex_deploy_token"
+
+// The workload, artifact and namespace a deploy token authorizes
+type DeployTokenClaims struct {
+	ID           string
+	Issuer       string
+	WorkloadName string
+	Digest       string
+	Namespace    string
+	Expires      time.Time
+}
+
+// Creates a pre-authorized, single-use deploy token, signed by the issuer, which allows its
+// bearer to run the named workload from the artifact with the given hex-encoded SHA-256 digest
+// in the given namespace until the token expires. The token is supplied in place of a workload
+// JWT (see DeployToken), so the bearer needs no signing key of its own
+func CreateDeployToken(workloadName string, digest string, namespace string, expiry time.Duration, issuer nkeys.KeyPair) (string, error) {
+	if !validWorkloadName.MatchString(workloadName) {
+		return "", fmt.Errorf("workload name ('%s') does not match requirements of all lowercase letters", workloadName)
+	}
+	if digest == "" {
+		return "", errors.New("deploy token requires an artifact digest")
+	}
+	if namespace == "" {
+		return "", errors.New("deploy token requires a namespace")
+	}
+	if expiry <= 0 {
+		return "", errors.New("deploy token requires a positive expiry")
+	}
+
+	claims := jwt.NewGenericClaims(workloadName)
+	claims.Data["type"] = DeployTokenClaimType
+	claims.Data["digest"] = strings.ToLower(digest)
+	claims.Data["namespace"] = namespace
+	// the standard JWT ID only covers the standard claims, so it isn't unique to the token
+	claims.Data["token_id"] = uuid.NewString()
+	claims.Expires = time.Now().Add(expiry).Unix()
+
+	return claims.Encode(issuer)
+}
+
+// Returns the deploy token claims of the request's (already validated) workload JWT, or nil
+// if the workload JWT isn't a deploy token
+func (request *DeployRequest) DeployTokenClaims() (*DeployTokenClaims, error) {
+	if claimType, _ := request.DecodedClaims.Data["type"].(string); claimType != DeployTokenClaimType {
+		return nil, nil
+	}
+
+	digest, _ := request.DecodedClaims.Data["digest"].(string)
+	namespace, _ := request.DecodedClaims.Data["namespace"].(string)
+	id, _ := request.DecodedClaims.Data["token_id"].(string)
+	if digest == "" || namespace == "" || id == "" {
+		return nil, errors.New("deploy token is missing its ID, digest or namespace")
+	}
+	if request.DecodedClaims.Expires == 0 {
+		return nil, errors.New("deploy token does not expire")
+	}
+
+	return &DeployTokenClaims{
+		ID:           id,
+		Issuer:       request.DecodedClaims.Issuer,
+		WorkloadName: request.DecodedClaims.Subject,
+		Digest:       digest,
+		Namespace:    namespace,
+		Expires:      time.Unix(request.DecodedClaims.Expires, 0),
+	}, nil
+}
@@ -1,12 +1,29 @@
 package controlapi
 
+import "time"
+
 const (
-	AgentStartedEventType    = "agent_started"
-	AgentStoppedEventType    = "agent_stopped"
-	NodeStartedEventType     = "node_started"
-	NodeStoppedEventType     = "node_stopped"
-	WorkloadStartedEventType = "workload_started" // FIXME-- should this be WorkloadDeployed?
-	WorkloadStoppedEventType = "workload_stopped" // FIXME-- should this be in addition to WorkloadUndeployed (likely yes, in case of something bad happening...)
+	AgentStartedEventType        = "agent_started"
+	AgentStoppedEventType        = "agent_stopped"
+	ArtifactReplicationEventType = "artifact_replication"
+	CronTriggerExecutedEventType = "cron_trigger_executed"
+	MachineStateChangedEventType = "machine_state_changed"
+	NodeCapacityEventType        = "node_capacity"
+	NodeReservationEventType     = "node_reservation"
+	NodeShutdownReportEventType  = "node_shutdown_report"
+	NodeStandbyEventType         = "node_standby"
+	NodePauseEventType           = "node_pause"
+	NodeStartedEventType         = "node_started"
+	NodeStoppedEventType         = "node_stopped"
+	StaleAssetsEventType         = "stale_assets"
+	UtilizationReportEventType   = "utilization_report"
+	WorkloadFailedEventType      = "workload_failed"
+	WorkloadLifecycleEventType   = "workload_lifecycle"
+	WorkloadReadyEventType       = "workload_ready"
+	WorkloadRecoveryEventType    = "workload_recovery"
+	WorkloadStartedEventType     = "workload_started" // FIXME-- should this be WorkloadDeployed?
+	WorkloadStoppedEventType     = "workload_stopped" // FIXME-- should this be in addition to WorkloadUndeployed (likely yes, in case of something bad happening...)
+	ZombieResourcesEventType     = "zombie_resources"
 	// FIXME-- where is WorkloadDeployedEventType? (likely just need to rename WorkloadStartedEventType -> WorkloadDeployedEventType)
 	// FIXME-- where is WorkloadStoppedEventType?
 )
@@ -16,8 +33,26 @@ type AgentStartedEvent struct {
 }
 
 type WorkloadStartedEvent struct {
-	Name       string `json:"workload_name"`
-	TotalBytes int    `json:"total_bytes"`
+	Name        string               `json:"workload_name"`
+	TotalBytes  int                  `json:"total_bytes"`
+	Provenance  *ArtifactProvenance  `json:"provenance,omitempty"`
+	ScanResults []ArtifactScanResult `json:"scan_results,omitempty"`
+}
+
+// The outcome of a node's verification of a workload artifact's signature
+type ArtifactProvenance struct {
+	Verified      bool   `json:"verified"`
+	Signer        string `json:"signer,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+	Attested      bool   `json:"attested,omitempty"`
+	PredicateType string `json:"predicate_type,omitempty"`
+}
+
+// The outcome of a scanner a node ran against a workload artifact before deploying it
+type ArtifactScanResult struct {
+	Scanner string `json:"scanner"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
 }
 
 type WorkloadStoppedEvent struct {
@@ -26,6 +61,98 @@ type WorkloadStoppedEvent struct {
 	Message string `json:"message"`
 }
 
+type WorkloadFailedEvent struct {
+	Name    string `json:"workload_name"`
+	VmId    string `json:"vmid"`
+	Reason  string `json:"reason"`
+	Evicted bool   `json:"evicted"`
+}
+
+// Machine lifecycle states, as reported in INFO and machine state changed events
+const (
+	MachineStateWarming   = "warming"
+	MachineStateReady     = "ready"
+	MachineStateDeploying = "deploying"
+	MachineStateRunning   = "running"
+	MachineStateDraining  = "draining"
+	MachineStateStopped   = "stopped"
+
+	// Reported for functions which have been undeployed after going idle, and will be
+	// cold-started by their next trigger
+	MachineStateScaledToZero = "scaled_to_zero"
+)
+
+type CronTriggerExecutedEvent struct {
+	Name        string    `json:"workload_name"`
+	VmId        string    `json:"vmid"`
+	Schedule    string    `json:"schedule"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Identifies the version of the schema of workload lifecycle events. Fields are only ever added
+// within a version; any other change to the schema is made under a new version
+const WorkloadLifecycleSchema = "io.nats.nex.v1.workload_lifecycle"
+
+// Workload lifecycle states, as reported in workload lifecycle events. A workload is cached once
+// the node has retrieved and verified its artifact, scheduled once a machine has been chosen to
+// run it, and moves through the remaining states as it's deployed and stopped. A failed workload
+// is subsequently reported as stopping and stopped
+const (
+	WorkloadStateCached    = "cached"
+	WorkloadStateScheduled = "scheduled"
+	WorkloadStateDeploying = "deploying"
+	WorkloadStateRunning   = "running"
+	WorkloadStateUnhealthy = "unhealthy"
+	WorkloadStateStopping  = "stopping"
+	WorkloadStateStopped   = "stopped"
+	WorkloadStateFailed    = "failed"
+)
+
+// Emitted on every transition of a workload's lifecycle state. The machine ID is absent for
+// workloads which haven't yet been scheduled onto a machine
+type WorkloadLifecycleEvent struct {
+	Schema       string    `json:"schema"`
+	State        string    `json:"state"`
+	NodeId       string    `json:"node_id"`
+	Namespace    string    `json:"namespace"`
+	WorkloadName string    `json:"workload_name"`
+	MachineId    string    `json:"machine_id,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	// Only present for workloads which declared a readiness probe, saying whether it has passed
+	Ready *bool `json:"ready,omitempty"`
+}
+
+// Artifact replication statuses, as reported in artifact replication events
+const (
+	ArtifactReplicationStarted   = "replicating"
+	ArtifactReplicationCompleted = "replicated"
+	ArtifactReplicationSkipped   = "already_replicated"
+	ArtifactReplicationFailed    = "failed"
+)
+
+// Reports the progress of replicating a workload artifact to the JetStream domain local to
+// the node a workload is being deployed to
+type ArtifactReplicationEvent struct {
+	Name         string `json:"workload_name"`
+	TargetNode   string `json:"target_node"`
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	SourceDomain string `json:"source_domain,omitempty"`
+	TargetDomain string `json:"target_domain"`
+	Status       string `json:"status"`
+	Bytes        uint64 `json:"bytes,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type MachineStateChangedEvent struct {
+	VmId string `json:"vmid"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
 type AgentStoppedEvent struct {
 	Message string `json:"message"`
 	Code    int    `json:"code"`
@@ -36,7 +163,179 @@ type NodeStartedEvent struct {
 	Id      string `json:"id"`
 }
 
+type NodeCapacityEvent struct {
+	Id       string       `json:"id"`
+	Capacity NodeCapacity `json:"capacity"`
+}
+
 type NodeStoppedEvent struct {
 	Id       string `json:"id"`
 	Graceful bool   `json:"graceful"`
 }
+
+// Published as a node stops, after it has stopped its machines, describing why it stopped and
+// what it left behind. The shutdown is clean unless the node stopped due to a fatal error,
+// failed to stop a machine or failed to clean up after one
+type NodeShutdownReport struct {
+	Id           string             `json:"id"`
+	Reason       string             `json:"reason"`
+	Fatal        bool               `json:"fatal"`
+	Clean        bool               `json:"clean"`
+	Uptime       string             `json:"uptime"`
+	Stopped      []ShutdownWorkload `json:"stopped"`
+	FailedToStop []ShutdownWorkload `json:"failed_to_stop"`
+	// Resources the node failed to clean up, e.g., firecracker processes, rootfs copies and
+	// cgroups, whether as it stopped or earlier
+	Orphaned []string `json:"orphaned"`
+}
+
+// A machine stopped (or not) as its node shut down. Warm machines have no workload name
+type ShutdownWorkload struct {
+	MachineId    string `json:"machine_id"`
+	Namespace    string `json:"namespace,omitempty"`
+	WorkloadName string `json:"workload_name,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// How long stopping the machine took, or how long it had been stopping when the node gave up
+	DurationMillis int64 `json:"duration_ms,omitempty"`
+}
+
+// Published as a node enters or leaves standby
+type NodeStandbyEvent struct {
+	Id      string `json:"id"`
+	Standby bool   `json:"standby"`
+}
+
+// Published as a node pauses the creation of machines and as it resumes, whether it's resumed by a
+// request or its pause expires
+type NodePauseEvent struct {
+	Id        string     `json:"id"`
+	Paused    bool       `json:"paused"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Emitted when a namespace reserves a node exclusively, extends its reservation or releases it.
+// Reservations which simply expire are not announced
+type NodeReservationEvent struct {
+	Id        string     `json:"id"`
+	Namespace string     `json:"namespace"`
+	Reserved  bool       `json:"reserved"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Kinds of JetStream assets created by nodes on behalf of namespaces
+const (
+	StaleAssetKindKeyValue        = "host_services_kv"
+	StaleAssetKindTriggerConsumer = "trigger_consumer"
+)
+
+// Emitted by a node as it restarts, for each workload it was running when it last stopped. The
+// workload is redeployed to a new machine, unless recovery failed
+type WorkloadRecoveryEvent struct {
+	NodeId            string    `json:"node_id"`
+	Namespace         string    `json:"namespace"`
+	WorkloadName      string    `json:"workload_name"`
+	PreviousMachineId string    `json:"previous_machine_id"`
+	DeployedAt        time.Time `json:"deployed_at"`
+	MachineId         string    `json:"machine_id,omitempty"`
+	Recovered         bool      `json:"recovered"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// Kinds of resources left behind by machines a node isn't tracking
+const (
+	ZombieResourceKindProcess = "firecracker_process"
+	ZombieResourceKindNetwork = "cni_attachment"
+	ZombieResourceKindFile    = "run_directory_file"
+)
+
+// Emitted by a node's zombie reaper when it finds resources left behind by machines the node
+// isn't tracking, e.g., after a crash. In dry run mode they're only reported
+type ZombieResourcesEvent struct {
+	Id        string           `json:"id"`
+	DryRun    bool             `json:"dry_run"`
+	Resources []ZombieResource `json:"resources"`
+}
+
+type ZombieResource struct {
+	Kind string `json:"kind"`
+	// e.g., the firecracker process' PID, or the file's path
+	Name      string `json:"name"`
+	MachineId string `json:"machine_id,omitempty"`
+	Reaped    bool   `json:"reaped"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Emitted by a node's asset reaper when it finds assets belonging to namespaces without workloads
+// on the node that have been inactive beyond the retention period
+type StaleAssetsEvent struct {
+	Id     string       `json:"id"`
+	Assets []StaleAsset `json:"assets"`
+}
+
+type StaleAsset struct {
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	LastActivity time.Time `json:"last_activity"`
+	Deleted      bool      `json:"deleted"`
+}
+
+// Emitted by a node at the end of each reporting period, summarizing the utilization of the node
+// and of each namespace with workloads on it over the period. Resource use is measured as the
+// vCPUs and memory allocated to machines, integrated over time, and, when the node places machines
+// in cgroups, as the host CPU time used by the machines of workloads
+type UtilizationReport struct {
+	NodeId      string    `json:"node_id"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+
+	VCPUs     int   `json:"vcpus"`
+	MemoryMib int64 `json:"memory_mib"`
+
+	AllocatedVCPUSeconds      float64 `json:"allocated_vcpu_seconds"`
+	AllocatedMemoryMibSeconds float64 `json:"allocated_memory_mib_seconds"`
+	// Percentage of the node's vCPUs and memory allocated to machines, on average over the period
+	VCPUUtilization   float64 `json:"vcpu_utilization"`
+	MemoryUtilization float64 `json:"memory_utilization"`
+
+	// Host CPU time used by the machines of workloads, and the percentage of the node's CPUs it
+	// amounts to over the period. Only present when the node places machines in cgroups
+	CPUSeconds     float64 `json:"cpu_seconds,omitempty"`
+	CPUUtilization float64 `json:"cpu_utilization,omitempty"`
+
+	AverageWorkloads float64 `json:"average_workloads"`
+	PeakWorkloads    int     `json:"peak_workloads"`
+
+	Namespaces []NamespaceUtilization `json:"namespaces"`
+}
+
+type NamespaceUtilization struct {
+	Namespace     string `json:"namespace"`
+	Deploys       int    `json:"deploys"`
+	PeakWorkloads int    `json:"peak_workloads"`
+
+	VCPUSeconds      float64 `json:"vcpu_seconds"`
+	MemoryMibSeconds float64 `json:"memory_mib_seconds"`
+
+	Triggers              int64 `json:"triggers"`
+	FailedTriggers        int64 `json:"failed_triggers"`
+	FunctionRunTimeMillis int64 `json:"function_run_time_ms"`
+
+	// Host CPU time used by the namespace's workloads and the time they were throttled for
+	// exhausting their CPU quota, with a breakdown by workload. Only present when the node places
+	// machines in cgroups
+	CPUSeconds          float64               `json:"cpu_seconds,omitempty"`
+	ThrottledCPUSeconds float64               `json:"throttled_cpu_seconds,omitempty"`
+	Workloads           []WorkloadUtilization `json:"workloads,omitempty"`
+}
+
+// The host CPU use of the machines of a workload, identified by name, over a reporting period
+type WorkloadUtilization struct {
+	Name                string  `json:"name"`
+	VCPUSeconds         float64 `json:"vcpu_seconds"`
+	CPUSeconds          float64 `json:"cpu_seconds"`
+	ThrottledCPUSeconds float64 `json:"throttled_cpu_seconds"`
+	// CPU bandwidth periods in which the workload's machines had runnable processes, and those in
+	// which they were throttled
+	CPUPeriods          int64 `json:"cpu_periods"`
+	ThrottledCPUPeriods int64 `json:"throttled_cpu_periods"`
+}
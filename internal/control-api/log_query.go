@@ -0,0 +1,225 @@
+package controlapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Queries the logs nodes persisted to a log stream for the entries of a workload in the client's
+// namespace, emitted between Since and Until. Nodes in other JetStream domains, e.g. leaf nodes,
+// persist their logs to a stream of the same name in their own domain; each of the given domains
+// is queried and their entries are merged
+type LogQuery struct {
+	Stream       string   `json:"stream"`
+	Domains      []string `json:"domains,omitempty"`
+	WorkloadName string   `json:"workload_name"`
+	// Defaults to the beginning of the stream
+	Since time.Time `json:"since,omitempty"`
+	// Defaults to the time of the query
+	Until time.Time `json:"until,omitempty"`
+	// At most this many entries are returned, the earliest in the range. Unlimited if 0
+	Limit int `json:"limit,omitempty"`
+}
+
+type timedLog struct {
+	at    time.Time
+	entry EmittedLog
+}
+
+// Returns the log entries of the queried workload, emitted on any node, ordered by the time they
+// were persisted. The node and machine of each entry tell which replica emitted it
+func (api *Client) QueryLogs(query *LogQuery) ([]EmittedLog, error) {
+	if query.WorkloadName == "" {
+		return nil, errors.New("a workload name is required to query logs")
+	}
+
+	until := query.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if !query.Since.IsZero() && query.Since.After(until) {
+		return nil, errors.New("log query range ends before it starts")
+	}
+
+	domains := query.Domains
+	if len(domains) == 0 {
+		domains = []string{""}
+	}
+
+	logs := make([]timedLog, 0)
+	for _, domain := range domains {
+		domainLogs, err := api.queryLogStream(query, domain, until)
+		if err != nil {
+			if domain != "" {
+				return nil, fmt.Errorf("failed to query logs in domain %s: %s", domain, err)
+			}
+			return nil, err
+		}
+		logs = append(logs, domainLogs...)
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].at.Before(logs[j].at)
+	})
+	if query.Limit > 0 && len(logs) > query.Limit {
+		logs = logs[:query.Limit]
+	}
+
+	entries := make([]EmittedLog, len(logs))
+	for i, log := range logs {
+		entries[i] = log.entry
+	}
+	return entries, nil
+}
+
+func (api *Client) queryLogStream(query *LogQuery, domain string, until time.Time) ([]timedLog, error) {
+	opts := make([]nats.JSOpt, 0)
+	if domain != "" {
+		opts = append(opts, nats.Domain(domain))
+	}
+	js, err := api.nc.JetStream(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := js.StreamInfo(query.Stream)
+	if err != nil {
+		return nil, err
+	}
+	logs := make([]timedLog, 0)
+	if info.State.Msgs == 0 || info.State.LastTime.Before(query.Since) {
+		return logs, nil
+	}
+
+	start := nats.DeliverAll()
+	if !query.Since.IsZero() {
+		start = nats.StartTime(query.Since)
+	}
+
+	// Streams may still hold entries published by older nodes, on whose subjects the workload
+	// name came after the node, so entries are matched on their subject's tokens rather than
+	// filtered
+	subject := fmt.Sprintf("%s.logs.%s.>", APIPrefix, api.namespace)
+	sub, err := js.SubscribeSync(subject, nats.BindStream(query.Stream), nats.OrderedConsumer(), start)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	for {
+		m, err := sub.NextMsg(api.timeout)
+		if errors.Is(err, nats.ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := m.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		if meta.Timestamp.After(until) {
+			break
+		}
+
+		if entry, ok := api.matchLogEntry(m, query.WorkloadName); ok {
+			entry.Timestamp = entry.LoggedAt(meta.Timestamp).UTC().Format(time.RFC3339Nano)
+			logs = append(logs, timedLog{at: meta.Timestamp, entry: *entry})
+
+			// Entries of a single stream are in order, so no more than the limit are needed
+			if query.Limit > 0 && len(logs) >= query.Limit {
+				break
+			}
+		}
+		if meta.NumPending == 0 {
+			break
+		}
+	}
+
+	return logs, nil
+}
+
+// Follows the logs nodes persist to a log stream, of the queried workload or, if no workload name
+// is given, of every workload in the client's namespace, starting with those emitted since Since.
+// Entries are sent on the returned channel as they're persisted, in order within each domain.
+// Until and Limit are ignored. Bufferlength is the size of the channel buffer, where 0 is
+// unbuffered (aka blocking)
+func (api *Client) FollowLogs(query *LogQuery, bufferLength int) (chan EmittedLog, error) {
+	domains := query.Domains
+	if len(domains) == 0 {
+		domains = []string{""}
+	}
+
+	ch := make(chan EmittedLog, bufferLength)
+	for _, domain := range domains {
+		err := api.followLogStream(query, domain, ch)
+		if err != nil {
+			if domain != "" {
+				return nil, fmt.Errorf("failed to follow logs in domain %s: %s", domain, err)
+			}
+			return nil, err
+		}
+	}
+
+	return ch, nil
+}
+
+func (api *Client) followLogStream(query *LogQuery, domain string, ch chan EmittedLog) error {
+	opts := make([]nats.JSOpt, 0)
+	if domain != "" {
+		opts = append(opts, nats.Domain(domain))
+	}
+	js, err := api.nc.JetStream(opts...)
+	if err != nil {
+		return err
+	}
+
+	start := nats.DeliverNew()
+	if !query.Since.IsZero() {
+		start = nats.StartTime(query.Since)
+	}
+
+	subject := fmt.Sprintf("%s.logs.%s.>", APIPrefix, api.namespace)
+	_, err = js.Subscribe(subject, func(m *nats.Msg) {
+		meta, err := m.Metadata()
+		if err != nil {
+			return
+		}
+		if entry, ok := api.matchLogEntry(m, query.WorkloadName); ok {
+			entry.Timestamp = entry.LoggedAt(meta.Timestamp).UTC().Format(time.RFC3339Nano)
+			ch <- *entry
+		}
+	}, nats.BindStream(query.Stream), nats.OrderedConsumer(), start)
+	return err
+}
+
+// Returns the log entry of the given persisted message, if it's a log of the given workload, or of
+// any workload if no name is given
+func (api *Client) matchLogEntry(m *nats.Msg, workloadName string) (*EmittedLog, bool) {
+	var raw RawLog
+	err := json.Unmarshal(m.Data, &raw)
+	if err != nil {
+		api.log.Debug("Skipping undecodable log entry", "subject", m.Subject, "err", err)
+		return nil, false
+	}
+
+	tokens, ok := ParseLogSubject(m.Subject, raw.MachineId)
+	if !ok || (workloadName != "" && tokens.Workload != workloadName) {
+		return nil, false
+	}
+
+	return &EmittedLog{
+		Namespace: tokens.Namespace,
+		NodeId:    tokens.NodeId,
+		Workload:  tokens.Workload,
+		RawLog:    raw,
+	}, true
+}
@@ -0,0 +1,172 @@
+package controlapi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// Label applied to every workload deployed as a stage of a pipeline
+	PipelineLabel = "nex.pipeline"
+
+	pipelineSubjectPrefix = "nexpipeline"
+)
+
+// A chain of function workloads deployed and torn down as a unit. Each stage is triggered by
+// the completion subject of the stage before it, so messages published to the pipeline's input
+// subject flow through every stage in order, with the result of the final stage published to
+// the pipeline's output subject
+type Pipeline struct {
+	Name   string
+	Issuer nkeys.KeyPair
+	Stages []PipelineStage
+}
+
+// A single function workload within a pipeline. The options describe the workload as they
+// would for NewDeployRequest; the workload name, issuer, trigger subjects and completion
+// subject are supplied by the pipeline. Stages may target different nodes
+type PipelineStage struct {
+	Name    string
+	Options []RequestOption
+}
+
+// The result of deploying a pipeline, used to tear it down again
+type PipelineDeployment struct {
+	Pipeline *Pipeline
+	Stages   []PipelineStageDeployment
+}
+
+type PipelineStageDeployment struct {
+	Name       string
+	TargetNode string
+	Response   *RunResponse
+}
+
+// Creates a new pipeline from the given stages, in order
+func NewPipeline(name string, issuer nkeys.KeyPair, stages ...PipelineStage) (*Pipeline, error) {
+	if !validWorkloadName.MatchString(name) {
+		return nil, fmt.Errorf("pipeline name ('%s') does not match requirements of all lowercase letters", name)
+	}
+	if len(stages) == 0 {
+		return nil, errors.New("pipeline requires at least one stage")
+	}
+
+	seen := make(map[string]bool)
+	for _, stage := range stages {
+		if !validWorkloadName.MatchString(stage.Name) {
+			return nil, fmt.Errorf("pipeline stage name ('%s') does not match requirements of all lowercase letters", stage.Name)
+		}
+		if seen[stage.Name] {
+			return nil, fmt.Errorf("duplicate pipeline stage name: %s", stage.Name)
+		}
+		seen[stage.Name] = true
+	}
+
+	return &Pipeline{
+		Name:   name,
+		Issuer: issuer,
+		Stages: stages,
+	}, nil
+}
+
+// The subject on which messages enter the pipeline, i.e., the trigger subject of its first stage
+func (p *Pipeline) InputSubject() string {
+	return fmt.Sprintf("%s.%s.in", pipelineSubjectPrefix, p.Name)
+}
+
+// The subject to which the result of the pipeline's final stage is published
+func (p *Pipeline) OutputSubject() string {
+	return p.completionSubject(len(p.Stages) - 1)
+}
+
+func (p *Pipeline) completionSubject(stage int) string {
+	return fmt.Sprintf("%s.%s.%s.done", pipelineSubjectPrefix, p.Name, p.Stages[stage].Name)
+}
+
+func (p *Pipeline) triggerSubject(stage int) string {
+	if stage == 0 {
+		return p.InputSubject()
+	}
+	return p.completionSubject(stage - 1)
+}
+
+// Creates the deploy request for the given stage, wiring its trigger subject to the completion
+// subject of the previous stage
+func (p *Pipeline) deployRequest(stage int) (*DeployRequest, error) {
+	opts := append([]RequestOption{}, p.Stages[stage].Options...)
+	opts = append(opts,
+		WorkloadName(p.Stages[stage].Name),
+		Issuer(p.Issuer),
+		TriggerSubjects([]string{p.triggerSubject(stage)}),
+		CompletionSubject(p.completionSubject(stage)),
+	)
+
+	request, err := NewDeployRequest(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string)
+	for k, v := range request.Labels {
+		labels[k] = v
+	}
+	labels[PipelineLabel] = p.Name
+	request.Labels = labels
+
+	return request, nil
+}
+
+// Deploys every stage of the pipeline, last stage first so that no stage produces results
+// before the stage consuming them is listening. If any stage fails to deploy, the stages
+// already deployed are stopped again
+func (api *Client) DeployPipeline(pipeline *Pipeline) (*PipelineDeployment, error) {
+	deployment := &PipelineDeployment{
+		Pipeline: pipeline,
+		Stages:   make([]PipelineStageDeployment, len(pipeline.Stages)),
+	}
+
+	for i := len(pipeline.Stages) - 1; i >= 0; i-- {
+		request, err := pipeline.deployRequest(i)
+		if err == nil {
+			var response *RunResponse
+			response, err = api.StartWorkload(request)
+			if err == nil {
+				deployment.Stages[i] = PipelineStageDeployment{
+					Name:       pipeline.Stages[i].Name,
+					TargetNode: *request.TargetNode,
+					Response:   response,
+				}
+				continue
+			}
+		}
+
+		err = fmt.Errorf("failed to deploy pipeline stage %s: %w", pipeline.Stages[i].Name, err)
+		return nil, errors.Join(err, api.TeardownPipeline(deployment))
+	}
+
+	return deployment, nil
+}
+
+// Stops every deployed stage of the pipeline, first stage first so that no stage is left
+// without a consumer while its producer is still running
+func (api *Client) TeardownPipeline(deployment *PipelineDeployment) error {
+	var err error
+
+	for _, stage := range deployment.Stages {
+		if stage.Response == nil {
+			continue
+		}
+
+		request, serr := NewStopRequest(stage.Response.MachineId, stage.Name, stage.TargetNode, deployment.Pipeline.Issuer)
+		if serr == nil {
+			_, serr = api.StopWorkload(request)
+		}
+		if serr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to stop pipeline stage %s: %w", stage.Name, serr))
+		}
+	}
+
+	return err
+}
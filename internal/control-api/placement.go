@@ -0,0 +1,159 @@
+package controlapi
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Operators of node selector requirements
+const (
+	SelectorOpIn           = "in"
+	SelectorOpNotIn        = "notin"
+	SelectorOpExists       = "exists"
+	SelectorOpDoesNotExist = "doesnotexist"
+)
+
+// Constraints on the nodes a workload may be placed on. They're evaluated by the node the
+// workload is deployed to and, for workloads deployed to a cluster, by its leader when choosing
+// the node
+type PlacementConstraints struct {
+	// Requirements the node's tags must all satisfy
+	NodeSelector []NodeSelectorRequirement `json:"node_selector,omitempty"`
+	// Names of workloads, in the same namespace, the workload may not share a node with. A
+	// workload may name itself to spread its replicas across nodes
+	AntiAffinity []string `json:"anti_affinity,omitempty"`
+}
+
+// A requirement on one of a node's tags: that its value is (in) or isn't (notin) one of the given
+// values, or that the node has (exists) or doesn't have (doesnotexist) the tag
+type NodeSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// Parses a node selector expression: key=value, key!=value, "key in (a,b)", "key notin (a,b)",
+// key (the tag exists) or !key (it doesn't)
+func ParseNodeSelector(expr string) (NodeSelectorRequirement, error) {
+	expr = strings.TrimSpace(expr)
+
+	for _, op := range []string{SelectorOpNotIn, SelectorOpIn} {
+		key, values, ok := strings.Cut(expr, " "+op+" ")
+		if !ok {
+			continue
+		}
+		values = strings.TrimSpace(values)
+		if !strings.HasPrefix(values, "(") || !strings.HasSuffix(values, ")") {
+			return NodeSelectorRequirement{}, fmt.Errorf("values of %q must be enclosed in parentheses", expr)
+		}
+		r := NodeSelectorRequirement{Key: strings.TrimSpace(key), Operator: op}
+		for _, v := range strings.Split(values[1:len(values)-1], ",") {
+			r.Values = append(r.Values, strings.TrimSpace(v))
+		}
+		return r, r.Validate()
+	}
+
+	var r NodeSelectorRequirement
+	if key, value, ok := strings.Cut(expr, "!="); ok {
+		r = NodeSelectorRequirement{Key: strings.TrimSpace(key), Operator: SelectorOpNotIn, Values: []string{strings.TrimSpace(value)}}
+	} else if key, value, ok := strings.Cut(expr, "="); ok {
+		r = NodeSelectorRequirement{Key: strings.TrimSpace(key), Operator: SelectorOpIn, Values: []string{strings.TrimSpace(value)}}
+	} else if key, ok := strings.CutPrefix(expr, "!"); ok {
+		r = NodeSelectorRequirement{Key: strings.TrimSpace(key), Operator: SelectorOpDoesNotExist}
+	} else {
+		r = NodeSelectorRequirement{Key: expr, Operator: SelectorOpExists}
+	}
+	return r, r.Validate()
+}
+
+func (r NodeSelectorRequirement) Validate() error {
+	if r.Key == "" || strings.ContainsAny(r.Key, " \t=!(),") {
+		return fmt.Errorf("invalid node selector key: %q", r.Key)
+	}
+
+	switch r.Operator {
+	case SelectorOpIn, SelectorOpNotIn:
+		if len(r.Values) == 0 || slices.Contains(r.Values, "") {
+			return fmt.Errorf("node selector %s %s requires non-empty values", r.Key, r.Operator)
+		}
+	case SelectorOpExists, SelectorOpDoesNotExist:
+		if len(r.Values) > 0 {
+			return fmt.Errorf("node selector %s %s takes no values", r.Key, r.Operator)
+		}
+	default:
+		return fmt.Errorf("unsupported node selector operator: %q", r.Operator)
+	}
+
+	return nil
+}
+
+// Returns true if the given node tags satisfy the requirement
+func (r NodeSelectorRequirement) Matches(tags map[string]string) bool {
+	value, ok := tags[r.Key]
+
+	switch r.Operator {
+	case SelectorOpIn:
+		return ok && slices.Contains(r.Values, value)
+	case SelectorOpNotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case SelectorOpExists:
+		return ok
+	case SelectorOpDoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+func (r NodeSelectorRequirement) String() string {
+	switch r.Operator {
+	case SelectorOpExists:
+		return r.Key
+	case SelectorOpDoesNotExist:
+		return "!" + r.Key
+	}
+	return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+}
+
+func (p *PlacementConstraints) Validate() error {
+	var err error
+	for _, r := range p.NodeSelector {
+		err = errors.Join(err, r.Validate())
+	}
+	for _, name := range p.AntiAffinity {
+		if !validWorkloadName.MatchString(name) {
+			err = errors.Join(err, fmt.Errorf("invalid anti-affinity workload name: %q", name))
+		}
+	}
+	return err
+}
+
+// Returns the first node selector requirement the given node tags don't satisfy, if any. Nil
+// constraints are satisfied by every node
+func (p *PlacementConstraints) UnmatchedRequirement(tags map[string]string) *NodeSelectorRequirement {
+	if p == nil {
+		return nil
+	}
+	for _, r := range p.NodeSelector {
+		if !r.Matches(tags) {
+			return &r
+		}
+	}
+	return nil
+}
+
+// Returns the first of the given workload names, running in the workload's namespace on a node,
+// the workload is anti-affine to, if any
+func (p *PlacementConstraints) AntiAffinityConflict(workloads []string) string {
+	if p == nil {
+		return ""
+	}
+	for _, name := range p.AntiAffinity {
+		if slices.Contains(workloads, name) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package controlapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+)
+
+// Replicates the request's workload artifact to the JetStream domain local to the target node, as
+// advertised by the node's nex.jsdomain tag, and points the request at the replica, so the node
+// doesn't pull the artifact from another domain (e.g., region) at deploy time. Nothing is
+// replicated when the target domain is empty or is already the artifact's domain, and an existing
+// replica is reused when its digest matches. Progress is published as artifact replication events
+func (api *Client) ReplicateArtifact(request *DeployRequest, targetDomain string) error {
+	var sourceDomain string
+	if request.JsDomain != nil {
+		sourceDomain = *request.JsDomain
+	}
+	if targetDomain == "" || targetDomain == sourceDomain || request.Location == nil {
+		return nil
+	}
+
+	evt := ArtifactReplicationEvent{
+		Bucket:       request.Location.Host,
+		Key:          strings.Trim(request.Location.Path, "/"),
+		SourceDomain: sourceDomain,
+		TargetDomain: targetDomain,
+	}
+	if request.TargetNode != nil {
+		evt.TargetNode = *request.TargetNode
+	}
+	if request.WorkloadJwt != nil {
+		if claims, err := jwt.DecodeGeneric(*request.WorkloadJwt); err == nil {
+			evt.Name = claims.Subject
+		}
+	}
+
+	bytes, skipped, err := api.replicateObject(evt.Bucket, evt.Key, sourceDomain, targetDomain, func() {
+		evt.Status = ArtifactReplicationStarted
+		api.publishReplicationEvent(evt)
+	})
+	if err != nil {
+		evt.Status = ArtifactReplicationFailed
+		evt.Error = err.Error()
+		api.publishReplicationEvent(evt)
+		return fmt.Errorf("failed to replicate workload artifact to domain %s: %w", targetDomain, err)
+	}
+
+	evt.Bytes = bytes
+	evt.Status = ArtifactReplicationCompleted
+	if skipped {
+		evt.Status = ArtifactReplicationSkipped
+	}
+	api.publishReplicationEvent(evt)
+
+	request.JsDomain = &targetDomain
+	return nil
+}
+
+// Copies the object from the bucket in the source domain to the bucket of the same name in the
+// target domain, creating the bucket if necessary. The started func is called before any bytes
+// are copied; it isn't called if the target already holds an identical object
+func (api *Client) replicateObject(bucket, key, sourceDomain, targetDomain string, started func()) (uint64, bool, error) {
+	source, err := api.objectStore(sourceDomain, bucket, false)
+	if err != nil {
+		return 0, false, err
+	}
+
+	info, err := source.GetInfo(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	target, err := api.objectStore(targetDomain, bucket, true)
+	if err != nil {
+		return 0, false, err
+	}
+
+	existing, err := target.GetInfo(key)
+	if err == nil && existing.Digest == info.Digest {
+		return existing.Size, true, nil
+	}
+
+	started()
+
+	result, err := source.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	defer result.Close()
+
+	replica, err := target.Put(&nats.ObjectMeta{
+		Name:        key,
+		Description: info.Description,
+		Headers:     info.Headers,
+		Metadata:    info.Metadata,
+	}, result)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if replica.Digest != info.Digest {
+		return 0, false, errors.New("digest of replicated artifact does not match the source")
+	}
+
+	return replica.Size, false, nil
+}
+
+// Binds to the bucket in the given JetStream domain, optionally creating it
+func (api *Client) objectStore(domain string, bucket string, create bool) (nats.ObjectStore, error) {
+	opts := []nats.JSOpt{}
+	if domain != "" {
+		opts = append(opts, nats.APIPrefix(domain))
+	}
+
+	js, err := api.nc.JetStream(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) && create {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      bucket,
+			Description: "Replicated nex workload artifacts",
+		})
+	}
+	return store, err
+}
+
+func (api *Client) publishReplicationEvent(evt ArtifactReplicationEvent) {
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(api.nc.ConnectedUrlRedacted())
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(ArtifactReplicationEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(evt)
+
+	raw, _ := cloudevent.MarshalJSON()
+
+	// $NEX.events.{namespace}.{event_type}
+	subject := fmt.Sprintf("%s.events.%s.%s", APIPrefix, api.namespace, ArtifactReplicationEventType)
+	err := api.nc.Publish(subject, raw)
+	if err != nil {
+		api.log.Warn("Failed to publish artifact replication event", "err", err)
+	}
+}
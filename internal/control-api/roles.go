@@ -0,0 +1,71 @@
+package controlapi
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Roles permitting a requester to perform control operations on a namespace, each of which
+// permits everything the roles before it do
+const (
+	// May inspect the namespace's workloads and the node, e.g. INFO, DESCRIBE and TIMELINE
+	RoleViewer = "viewer"
+	// May also deploy, update and stop workloads
+	RoleDeployer = "deployer"
+	// May also reserve the node, read its audit log and manage role assignments
+	RoleOperator = "operator"
+)
+
+// Operations on role assignments
+const (
+	RoleOpList   = "list"
+	RoleOpAssign = "assign"
+	RoleOpRevoke = "revoke"
+)
+
+// Lists, assigns or revokes the roles of requesters in the client's namespace. Requesters are
+// identified by their user's public key or name, or by their account's public key or name
+type RoleRequest struct {
+	Operation string `json:"operation"`
+	Identity  string `json:"identity,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
+
+type RoleResponse struct {
+	Namespace string `json:"namespace"`
+	// Roles assigned in the namespace, keyed by identity. Roles assigned in the node
+	// configuration can't be changed with role requests
+	Assignments map[string]string `json:"assignments"`
+	Configured  map[string]string `json:"configured,omitempty"`
+}
+
+// Returns the roles assigned in the client's namespace, as known to the given node
+func (api *Client) ListRoles(nodeId string) (*RoleResponse, error) {
+	return api.roleRequest(nodeId, &RoleRequest{Operation: RoleOpList})
+}
+
+// Assigns the given role in the client's namespace to the given identity, replacing its role
+// if it already has one
+func (api *Client) AssignRole(nodeId string, identity string, role string) (*RoleResponse, error) {
+	return api.roleRequest(nodeId, &RoleRequest{Operation: RoleOpAssign, Identity: identity, Role: role})
+}
+
+// Revokes the role assigned to the given identity in the client's namespace
+func (api *Client) RevokeRole(nodeId string, identity string) (*RoleResponse, error) {
+	return api.roleRequest(nodeId, &RoleRequest{Operation: RoleOpRevoke, Identity: identity})
+}
+
+func (api *Client) roleRequest(nodeId string, request *RoleRequest) (*RoleResponse, error) {
+	subject := fmt.Sprintf("%s.ROLES.%s.%s", APIPrefix, api.namespace, nodeId)
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response RoleResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
@@ -1,7 +1,9 @@
 package controlapi
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
+	"github.com/synadia-io/nex/internal/compat"
 )
 
 type DeployRequest struct {
@@ -20,11 +23,103 @@ type DeployRequest struct {
 	Location     *url.URL `json:"location"`
 	Essential    *bool    `json:"essential,omitempty"`
 
+	// Optional data written to a native service workload's stdin when it starts
+	Stdin []byte `json:"stdin,omitempty"`
+	// Optionally executes a native workload once per trigger, with the trigger payload on its
+	// stdin and its stdout as the reply, rather than running it as a service
+	ExecPerTrigger bool `json:"exec_per_trigger,omitempty"`
+
+	// Optional name of the node machine template the workload runs in; the node's default
+	// template is used when omitted
+	MachineTemplate *string `json:"machine_template,omitempty"`
+	// Optional size of the workload's machine, within the limits configured by the node; the
+	// machine template's size is used for whichever is omitted
+	VcpuCount  *int `json:"vcpu_count,omitempty"`
+	MemSizeMib *int `json:"memsize_mib,omitempty"`
+
+	// Optionally requests short-lived NATS user credentials, scoped to the given subjects,
+	// be minted by the node and injected into the workload's sandbox
+	Credentials *CredentialsRequest `json:"credentials,omitempty"`
+
+	// Expected hex-encoded SHA-256 digest of the workload artifact. When present, the
+	// workload is rejected if the artifact retrieved from the object store doesn't match
+	Digest *string `json:"digest,omitempty"`
+	// Optionally streams the workload artifact through the node and into the workload's machine in
+	// chunks, verifying its digest as it's written rather than once it's been transferred
+	StreamArtifact bool `json:"stream_artifact,omitempty"`
+
+	// Optional cosign signature (and attestation) of the workload artifact
+	Signature *ArtifactSignature `json:"signature,omitempty"`
+
+	// Arbitrary key/value pairs used to group and select workloads
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Optional probe run by the agent to determine the health of the workload
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	// Optional probe run by the agent once the workload has started, until it first passes. The
+	// workload isn't considered deployed, nor subscribed to its triggers, until then
+	ReadinessProbe *HealthCheck `json:"readiness_probe,omitempty"`
+
+	// Optional commands run by the agent before the workload starts and after it stops
+	PreStartHook *WorkloadHook `json:"pre_start_hook,omitempty"`
+	PostStopHook *WorkloadHook `json:"post_stop_hook,omitempty"`
+
+	// Optional soft memory limit at which the agent notifies the workload, ahead of the hard
+	// out-of-memory limit, so it can shed caches gracefully
+	MemorySoftLimit *MemorySoftLimit `json:"memory_soft_limit,omitempty"`
+
+	// Optional limits on the workload's output forwarded as logs, so that a chatty workload
+	// can't flood the node
+	LogLimits *LogLimits `json:"log_limits,omitempty"`
+
+	// Optional restriction of the destinations the workload's machine may connect to
+	EgressPolicy *EgressPolicy `json:"egress_policy,omitempty"`
+
+	// Optionally gives a service workload's machine a stable IP address, leased to the workload
+	// (by namespace and name) so that it's kept when the workload is redeployed, and registers a
+	// DNS name, {dns_name}.{namespace}.{domain}, resolving to the machine's address
+	StableIP bool    `json:"stable_ip,omitempty"`
+	DNSName  *string `json:"dns_name,omitempty"`
+
+	// Optionally attaches a persistent volume of the given size to a service workload's machine.
+	// The volume is kept by the node for the workload (by namespace and name), so it's attached
+	// again, with its contents, whenever the workload is redeployed to the node
+	VolumeSizeMib *int `json:"volume_size_mib,omitempty"`
+
+	// Optional name of the sandbox profile determining which host services are exposed to a
+	// function workload; the namespace's (or node's) default profile is used when omitted
+	SandboxProfile *string `json:"sandbox_profile,omitempty"`
+
+	// Optional constraints on the nodes the workload may be placed on
+	Placement *PlacementConstraints `json:"placement,omitempty"`
+
+	// Optional webhook through which HTTP POSTs to the node trigger the function
+	Webhook *WebhookTrigger `json:"webhook,omitempty"`
+
+	// Optionally registers the function as a NATS service (micro) whose endpoints are its
+	// trigger subjects
+	MicroService *MicroServiceRegistration `json:"micro_service,omitempty"`
+
+	// Optional key identifying the deploy request, so that a node receiving it more than once,
+	// e.g. when it's retried, answers the duplicates with the response to the original rather
+	// than deploying the workload again. Keys are scoped to the namespace
+	IdempotencyKey *string `json:"idempotency_key,omitempty"`
+
 	// Contains claims for the workload: name, hash
 	WorkloadJwt *string `json:"workload_jwt"`
+	// Optional delegation JWTs, ordered from the root issuer's delegation to the delegation of
+	// the key which signed the workload JWT, through which that key chains to a trusted issuer
+	IssuerChain []string `json:"issuer_chain,omitempty"`
 
 	// A base64-encoded byte array that contains an encrypted json-serialized map[string]string.
 	Environment *string `json:"environment"`
+	// Environment values individually sealed to the target node's xkey (each a base64-encoded
+	// byte array), which are merged into the decrypted environment
+	SealedEnvironment map[string]string `json:"sealed_environment,omitempty"`
+	// References to secrets held by the node's secret providers, e.g. vault://secret/db, keyed by
+	// the name of the environment variable each is given to the workload as. References are
+	// resolved by the node, so secret values never travel through the control API
+	Secrets map[string]string `json:"secrets,omitempty"`
 
 	// If the payload indicates an object store bucket & key, JS domain can be supplied
 	JsDomain *string `json:"jsdomain,omitempty"`
@@ -32,12 +127,55 @@ type DeployRequest struct {
 	SenderPublicKey *string  `json:"sender_public_key"`
 	TargetNode      *string  `json:"target_node"`
 	TriggerSubjects []string `json:"trigger_subjects,omitempty"`
+	// Either "at_most_once" (the default) or "at_least_once"
+	TriggerDelivery *string `json:"trigger_delivery,omitempty"`
+	// Optional limit on the number of trigger messages the function executes concurrently
+	TriggerConcurrency *TriggerConcurrency `json:"trigger_concurrency,omitempty"`
+	// Optional queue group (keyed by trigger subject) to subscribe to each trigger subject
+	// with, so that identical workloads across nodes load-balance its trigger messages
+	TriggerQueueGroups map[string]string `json:"trigger_queue_groups,omitempty"`
+	// Optional schedules on which the function is triggered, in addition to its trigger subjects
+	CronTriggers []CronTrigger `json:"cron_triggers,omitempty"`
+	// If set, the result of each successful trigger execution is also published to this
+	// subject, e.g., to trigger the next stage of a pipeline
+	CompletionSubject *string `json:"completion_subject,omitempty"`
+	// If set, the function is undeployed after going this long without a trigger and
+	// cold-started again by its next trigger
+	IdleTimeoutMillis *int `json:"idle_timeout_ms,omitempty"`
+
+	// Optional time the node waits for the agent to acknowledge the workload (1 second by
+	// default). Workloads which take longer to initialize are acknowledged as initializing, and
+	// the node then waits up to the ready timeout (5 minutes by default) for them to be ready
+	AckTimeoutMillis   *int `json:"ack_timeout_ms,omitempty"`
+	ReadyTimeoutMillis *int `json:"ready_timeout_ms,omitempty"`
 
 	RetryCount *uint      `json:"retry_count,omitempty"`
 	RetriedAt  *time.Time `json:"retried_at,omitempty"`
 
 	WorkloadEnvironment map[string]string `json:"-"`
 	DecodedClaims       jwt.GenericClaims `json:"-"`
+
+	// Fields unknown to this version of the API, preserved for forward compatibility
+	Unknown compat.UnknownFields `json:"-"`
+}
+
+func (request DeployRequest) MarshalJSON() ([]byte, error) {
+	type deployRequest DeployRequest
+	return compat.MarshalPreservingUnknown(deployRequest(request), request.Unknown)
+}
+
+func (request *DeployRequest) UnmarshalJSON(data []byte) error {
+	type deployRequest DeployRequest
+
+	var r deployRequest
+	unknown, err := compat.UnmarshalPreservingUnknown(data, &r)
+	if err != nil {
+		return err
+	}
+
+	*request = DeployRequest(r)
+	request.Unknown = unknown
+	return nil
 }
 
 var (
@@ -54,9 +192,18 @@ func NewDeployRequest(opts ...RequestOption) (*DeployRequest, error) {
 
 	// TODO: ensure that all the required fields are here
 
-	workloadJwt, err := CreateWorkloadJwt(reqOpts.hash, reqOpts.workloadName, reqOpts.claimsIssuer)
-	if err != nil {
-		return nil, err
+	workloadJwt := reqOpts.deployToken
+	if workloadJwt == "" {
+		signer := reqOpts.claimsSigner
+		if signer == nil {
+			signer = NkeyClaimsSigner{KeyPair: reqOpts.claimsIssuer}
+		}
+
+		var err error
+		workloadJwt, err = CreateSignedWorkloadJwt(reqOpts.hash, reqOpts.workloadName, signer)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	encryptedEnv, err := EncryptRequestEnvironment(reqOpts.senderXkey, reqOpts.targetPublicXKey, reqOpts.env)
@@ -67,26 +214,63 @@ func NewDeployRequest(opts ...RequestOption) (*DeployRequest, error) {
 	senderPublic, _ := reqOpts.senderXkey.PublicKey()
 
 	req := &DeployRequest{
-		Argv:            reqOpts.argv,
-		Description:     &reqOpts.workloadDescription,
-		WorkloadType:    &reqOpts.workloadType,
-		Location:        &reqOpts.location,
-		WorkloadJwt:     &workloadJwt,
-		Environment:     &encryptedEnv,
-		Essential:       &reqOpts.essential,
-		SenderPublicKey: &senderPublic,
-		TargetNode:      &reqOpts.targetNode,
-		TriggerSubjects: reqOpts.triggerSubjects,
-		JsDomain:        &reqOpts.jsDomain,
+		Argv:               reqOpts.argv,
+		Description:        &reqOpts.workloadDescription,
+		WorkloadType:       &reqOpts.workloadType,
+		Location:           &reqOpts.location,
+		WorkloadJwt:        &workloadJwt,
+		IssuerChain:        reqOpts.issuerChain,
+		Environment:        &encryptedEnv,
+		SealedEnvironment:  reqOpts.sealedEnv,
+		Secrets:            reqOpts.secrets,
+		Essential:          &reqOpts.essential,
+		Stdin:              reqOpts.stdin,
+		ExecPerTrigger:     reqOpts.execPerTrigger,
+		MachineTemplate:    reqOpts.machineTemplate,
+		SandboxProfile:     reqOpts.sandboxProfile,
+		StableIP:           reqOpts.stableIP,
+		DNSName:            reqOpts.dnsName,
+		VolumeSizeMib:      reqOpts.volumeSizeMib,
+		VcpuCount:          reqOpts.vcpuCount,
+		MemSizeMib:         reqOpts.memSizeMib,
+		SenderPublicKey:    &senderPublic,
+		TargetNode:         &reqOpts.targetNode,
+		TriggerSubjects:    reqOpts.triggerSubjects,
+		TriggerDelivery:    reqOpts.triggerDelivery,
+		TriggerQueueGroups: reqOpts.triggerQueueGroups,
+		TriggerConcurrency: reqOpts.triggerConcurrency,
+		IdleTimeoutMillis:  reqOpts.idleTimeoutMillis,
+		AckTimeoutMillis:   reqOpts.ackTimeoutMillis,
+		ReadyTimeoutMillis: reqOpts.readyTimeoutMillis,
+		CompletionSubject:  reqOpts.completionSubject,
+		CronTriggers:       reqOpts.cronTriggers,
+		JsDomain:           &reqOpts.jsDomain,
+		HealthCheck:        reqOpts.healthCheck,
+		ReadinessProbe:     reqOpts.readinessProbe,
+		Labels:             reqOpts.labels,
+		Digest:             reqOpts.digest,
+		StreamArtifact:     reqOpts.streamArtifact,
+		IdempotencyKey:     reqOpts.idempotencyKey,
+		Credentials:        reqOpts.credentials,
+		Signature:          reqOpts.signature,
+		PreStartHook:       reqOpts.preStartHook,
+		PostStopHook:       reqOpts.postStopHook,
+		MemorySoftLimit:    reqOpts.memorySoftLimit,
+		LogLimits:          reqOpts.logLimits,
+		EgressPolicy:       reqOpts.egressPolicy,
+		Placement:          reqOpts.placement,
+		Webhook:            reqOpts.webhook,
+		MicroService:       reqOpts.microService,
 	}
 
 	return req, nil
 }
 
-// This will validate a request's workload JWT. It will not perform a
-// comparison of the hash found in the claims with a recipient's expected hash
-func (request *DeployRequest) Validate() (*jwt.GenericClaims, error) {
-	claims, err := jwt.DecodeGeneric(*request.WorkloadJwt)
+// This will validate a request's workload JWT, signed by an nkey or a key supported by one of the
+// given verifiers. It will not perform a comparison of the hash found in the claims with a
+// recipient's expected hash
+func (request *DeployRequest) Validate(verifiers ...ClaimsVerifier) (*jwt.GenericClaims, error) {
+	claims, err := VerifyClaims(*request.WorkloadJwt, verifiers...)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode workload JWT: %s", err)
 	}
@@ -106,10 +290,18 @@ func (request *DeployRequest) Validate() (*jwt.GenericClaims, error) {
 }
 
 func CreateWorkloadJwt(hash string, name string, issuer nkeys.KeyPair) (string, error) {
+	return CreateSignedWorkloadJwt(hash, name, NkeyClaimsSigner{KeyPair: issuer})
+}
+
+// Creates a workload JWT signed by the given signer, e.g. a VaultTransit key
+func CreateSignedWorkloadJwt(hash string, name string, signer ClaimsSigner) (string, error) {
 	genericClaims := jwt.NewGenericClaims(name)
+	if genericClaims == nil {
+		return "", errors.New("workload name is required")
+	}
 	genericClaims.Data["hash"] = hash
 
-	return genericClaims.Encode(issuer)
+	return signer.Sign(genericClaims)
 }
 
 func EncryptRequestEnvironment(senderXKey nkeys.KeyPair, recipientPublicKey string, env map[string]string) (string, error) {
@@ -120,6 +312,29 @@ func EncryptRequestEnvironment(senderXKey nkeys.KeyPair, recipientPublicKey stri
 	return hexenv, nil
 }
 
+// Seals a single environment value to the recipient's public xkey
+func SealEnvironmentValue(senderXKey nkeys.KeyPair, recipientPublicKey string, value string) (string, error) {
+	sealed, err := senderXKey.Seal([]byte(value), recipientPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Opens a single environment value sealed with SealEnvironmentValue
+func OpenEnvironmentValue(recipientXKey nkeys.KeyPair, senderPublicKey string, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := recipientXKey.Open(data, senderPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
 func (request *DeployRequest) DecryptRequestEnvironment(recipientXKey nkeys.KeyPair) error {
 	data, err := base64.StdEncoding.DecodeString(*request.Environment)
 	if err != nil {
@@ -137,6 +352,14 @@ func (request *DeployRequest) DecryptRequestEnvironment(recipientXKey nkeys.KeyP
 		return err
 	}
 
+	for key, sealed := range request.SealedEnvironment {
+		value, err := OpenEnvironmentValue(recipientXKey, *request.SenderPublicKey, sealed)
+		if err != nil {
+			return fmt.Errorf("failed to open sealed environment value %s: %s", key, err)
+		}
+		cleanEnv[key] = value
+	}
+
 	// "I can't believe I can do this" - Every Rust developer ever.
 	request.WorkloadEnvironment = cleanEnv
 	return nil
@@ -144,19 +367,57 @@ func (request *DeployRequest) DecryptRequestEnvironment(recipientXKey nkeys.KeyP
 
 type requestOptions struct {
 	argv                []string
+	stdin               []byte
+	execPerTrigger      bool
 	workloadName        string
 	workloadType        string
 	workloadDescription string
 	location            url.URL
 	env                 map[string]string
+	sealedEnv           map[string]string
+	secrets             map[string]string
 	essential           bool
+	machineTemplate     *string
+	vcpuCount           *int
+	memSizeMib          *int
+	healthCheck         *HealthCheck
+	readinessProbe      *HealthCheck
+	labels              map[string]string
+	digest              *string
+	streamArtifact      bool
+	idempotencyKey      *string
+	credentials         *CredentialsRequest
+	signature           *ArtifactSignature
+	idleTimeoutMillis   *int
+	ackTimeoutMillis    *int
+	readyTimeoutMillis  *int
+	completionSubject   *string
+	triggerConcurrency  *TriggerConcurrency
+	cronTriggers        []CronTrigger
+	triggerQueueGroups  map[string]string
+	preStartHook        *WorkloadHook
+	postStopHook        *WorkloadHook
+	memorySoftLimit     *MemorySoftLimit
+	logLimits           *LogLimits
+	egressPolicy        *EgressPolicy
+	sandboxProfile      *string
+	stableIP            bool
+	dnsName             *string
+	volumeSizeMib       *int
+	placement           *PlacementConstraints
+	webhook             *WebhookTrigger
+	microService        *MicroServiceRegistration
 	senderXkey          nkeys.KeyPair
 	claimsIssuer        nkeys.KeyPair
+	claimsSigner        ClaimsSigner
+	issuerChain         []string
+	deployToken         string
 	targetPublicXKey    string
 	jsDomain            string
 	hash                string
 	targetNode          string
 	triggerSubjects     []string
+	triggerDelivery     *string
 }
 
 type RequestOption func(o requestOptions) requestOptions
@@ -169,6 +430,22 @@ func Argv(argv []string) RequestOption {
 	}
 }
 
+// Data to be written to the workload's stdin when it starts, if applicable
+func Stdin(stdin []byte) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.stdin = stdin
+		return o
+	}
+}
+
+// Executes a native workload once per trigger rather than running it as a service
+func ExecPerTrigger(execPerTrigger bool) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.execPerTrigger = execPerTrigger
+		return o
+	}
+}
+
 // Name of the workload. Conforms to the same name rules as the services API
 func WorkloadName(name string) RequestOption {
 	return func(o requestOptions) requestOptions {
@@ -177,7 +454,7 @@ func WorkloadName(name string) RequestOption {
 	}
 }
 
-// Type of the workload, e.g., one of "elf", "v8", "oci", "wasm" for this request
+// Type of the workload, e.g., one of "elf", "v8", "oci", "wasm", "python" for this request
 func WorkloadType(workloadType string) RequestOption {
 	return func(o requestOptions) requestOptions {
 		o.workloadType = workloadType
@@ -201,6 +478,87 @@ func TriggerSubjects(triggerSubjects []string) RequestOption {
 	}
 }
 
+// Sets the delivery mode for trigger messages, either "at_most_once" or "at_least_once"
+func TriggerDelivery(mode string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if mode != "" {
+			o.triggerDelivery = &mode
+		}
+		return o
+	}
+}
+
+// Sets the queue groups (keyed by trigger subject) with which to subscribe to trigger subjects
+func TriggerQueueGroups(queueGroups map[string]string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if len(queueGroups) > 0 {
+			o.triggerQueueGroups = queueGroups
+		}
+		return o
+	}
+}
+
+// Limits the number of trigger messages the function executes concurrently, queuing up to
+// queueSize more and handling any beyond that per the given overflow policy
+func TriggerConcurrencyLimit(maxInFlight int, queueSize int, overflow string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if maxInFlight > 0 {
+			o.triggerConcurrency = &TriggerConcurrency{
+				MaxInFlight: maxInFlight,
+				QueueSize:   queueSize,
+				Overflow:    overflow,
+			}
+		}
+		return o
+	}
+}
+
+// Sets the subject to which the result of each successful trigger execution is published
+func CompletionSubject(subject string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if subject != "" {
+			o.completionSubject = &subject
+		}
+		return o
+	}
+}
+
+// Sets the schedules on which the function is triggered
+func CronTriggers(triggers []CronTrigger) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.cronTriggers = triggers
+		return o
+	}
+}
+
+// Sets how long a function may go without a trigger before it's scaled to zero
+func IdleTimeout(timeout time.Duration) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if timeout > 0 {
+			millis := int(timeout.Milliseconds())
+			o.idleTimeoutMillis = &millis
+		}
+		return o
+	}
+}
+
+// Sets how long the node waits for the agent to acknowledge the workload and, should the agent
+// acknowledge it as still initializing, for it to be ready. The node's defaults are used for
+// either timeout when it's zero
+func DeployTimeouts(ack time.Duration, ready time.Duration) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if ack > 0 {
+			millis := int(ack.Milliseconds())
+			o.ackTimeoutMillis = &millis
+		}
+		if ready > 0 {
+			millis := int(ready.Milliseconds())
+			o.readyTimeoutMillis = &millis
+		}
+		return o
+	}
+}
+
 // Location of the workload. For files in NATS object stores, use nats://BUCKET/key
 func Location(fileUrl string) RequestOption {
 	return func(o requestOptions) requestOptions {
@@ -238,6 +596,168 @@ func Essential(essential bool) RequestOption {
 	}
 }
 
+// Sets the name of the node machine template the workload runs in
+func MachineTemplate(name string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if name != "" {
+			o.machineTemplate = &name
+		}
+		return o
+	}
+}
+
+// Sets the number of vCPUs and MiB of memory of the workload's machine. Either may be zero, in
+// which case the machine template's value is used
+func MachineSize(vcpuCount int, memSizeMib int) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if vcpuCount > 0 {
+			o.vcpuCount = &vcpuCount
+		}
+		if memSizeMib > 0 {
+			o.memSizeMib = &memSizeMib
+		}
+		return o
+	}
+}
+
+// Sets the labels used to group and select the workload
+func WorkloadLabels(labels map[string]string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.labels = labels
+		return o
+	}
+}
+
+// Sets the health check probe the agent should run against the workload
+func WorkloadHealthCheck(healthCheck *HealthCheck) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.healthCheck = healthCheck
+		return o
+	}
+}
+
+// Sets the probe the agent runs until the workload is ready to receive triggers
+func WorkloadReadinessProbe(probe *HealthCheck) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.readinessProbe = probe
+		return o
+	}
+}
+
+// Sets a command the agent runs inside the sandbox before starting the workload. The
+// workload is not started if the command fails
+func WorkloadPreStartHook(hook *WorkloadHook) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.preStartHook = hook
+		return o
+	}
+}
+
+// Sets a command the agent runs inside the sandbox after the workload is stopped
+func WorkloadPostStopHook(hook *WorkloadHook) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.postStopHook = hook
+		return o
+	}
+}
+
+// Sets the soft memory limit at which the agent notifies the workload that it should shed memory
+func WorkloadMemorySoftLimit(limit *MemorySoftLimit) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.memorySoftLimit = limit
+		return o
+	}
+}
+
+// Limits the workload's output the agent forwards as logs by level and rate
+func WorkloadLogLimits(limits *LogLimits) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.logLimits = limits
+		return o
+	}
+}
+
+// Restricts the destinations the workload's machine may connect to
+func WorkloadEgressPolicy(policy *EgressPolicy) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.egressPolicy = policy
+		return o
+	}
+}
+
+// Sets the name of the sandbox profile determining which host services are exposed to the workload
+func WorkloadSandboxProfile(name string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if name != "" {
+			o.sandboxProfile = &name
+		}
+		return o
+	}
+}
+
+// Requests a stable IP address for the workload's machine
+func WorkloadStableIP(stable bool) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.stableIP = stable
+		return o
+	}
+}
+
+// Registers a DNS name ({name}.{namespace}.{domain}) resolving to the workload's machine
+func WorkloadDNSName(name string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if name != "" {
+			o.dnsName = &name
+		}
+		return o
+	}
+}
+
+// Attaches a persistent volume of the given size to the workload's machine
+func WorkloadVolume(sizeMib int) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if sizeMib > 0 {
+			o.volumeSizeMib = &sizeMib
+		}
+		return o
+	}
+}
+
+// Constrains the nodes the workload may be placed on by their tags and by the workloads
+// already running on them
+func WorkloadPlacement(placement *PlacementConstraints) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if placement != nil && (len(placement.NodeSelector) > 0 || len(placement.AntiAffinity) > 0) {
+			o.placement = placement
+		}
+		return o
+	}
+}
+
+// Gives the function a webhook through which HTTP POSTs presenting the given token trigger it,
+// delivered on the given subject or, when empty, on its first trigger subject
+func WorkloadWebhook(token string, subject string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if token != "" {
+			digest := sha256.Sum256([]byte(token))
+			o.webhook = &WebhookTrigger{TokenSha256: hex.EncodeToString(digest[:])}
+			if subject != "" {
+				o.webhook.Subject = &subject
+			}
+		}
+		return o
+	}
+}
+
+// Registers the function as a NATS service (micro), so that it's discoverable with the micro
+// protocol's tooling, e.g. nats micro ls
+func WorkloadMicroService(registration *MicroServiceRegistration) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.microService = registration
+		return o
+	}
+}
+
 // This is the sender's xkey. The public key will be placed on the request while the private key will be used
 // to encrypt the environment variables
 func SenderXKey(xkey nkeys.KeyPair) RequestOption {
@@ -264,6 +784,15 @@ func Issuer(issuerAccountKey nkeys.KeyPair) RequestOption {
 	}
 }
 
+// Signs the JWT that accompanies the request with a key held elsewhere, e.g. a VaultTransit key,
+// in place of an issuer account key
+func IssuerSigner(signer ClaimsSigner) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.claimsSigner = signer
+		return o
+	}
+}
+
 // Optionally set a JetStream domain that will be used to locate an object store when necessary
 func JsDomain(domain string) RequestOption {
 	return func(o requestOptions) requestOptions {
@@ -283,6 +812,98 @@ func EnvironmentValue(key string, value string) RequestOption {
 	}
 }
 
+// Sets environment values which have already been sealed to the target node's xkey, e.g.,
+// by Client.SealEnvironment
+func SealedEnvironment(sealed map[string]string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if o.sealedEnv == nil {
+			o.sealedEnv = make(map[string]string)
+		}
+		for k, v := range sealed {
+			o.sealedEnv[k] = v
+		}
+		return o
+	}
+}
+
+// Sets references to secrets, resolved by the target node's secret providers, given to the
+// workload as the environment variables they're keyed by
+func SecretReferences(secrets map[string]string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if o.secrets == nil {
+			o.secrets = make(map[string]string)
+		}
+		for k, v := range secrets {
+			o.secrets[k] = v
+		}
+		return o
+	}
+}
+
+// Uses a deploy token (see CreateDeployToken) in place of a workload JWT, in which case neither
+// an issuer nor a workload name need be set
+func DeployToken(token string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.deployToken = token
+		return o
+	}
+}
+
+// Sets the chain of delegation JWTs through which the key supplied to Issuer is authorized to
+// sign workloads on behalf of a trusted root issuer, ordered from the root's delegation onward
+func IssuerChain(chain ...string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.issuerChain = chain
+		return o
+	}
+}
+
+// Sets the expected hex-encoded SHA-256 digest of the workload artifact, which the node and
+// agent verify before executing the workload
+func WorkloadDigest(digest string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if digest != "" {
+			o.digest = &digest
+		}
+		return o
+	}
+}
+
+// Streams the workload artifact into the workload's machine in chunks
+func StreamArtifact(stream bool) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.streamArtifact = stream
+		return o
+	}
+}
+
+// Sets the key by which a node recognizes retries of the deploy request
+func IdempotencyKey(key string) RequestOption {
+	return func(o requestOptions) requestOptions {
+		if key != "" {
+			o.idempotencyKey = &key
+		}
+		return o
+	}
+}
+
+// Requests that the node mint short-lived NATS credentials for the workload, allowed to
+// publish and subscribe only on the given subjects
+func WorkloadCredentials(credentials *CredentialsRequest) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.credentials = credentials
+		return o
+	}
+}
+
+// Sets the signature of the workload artifact, verified by nodes configured to do so
+func WorkloadSignature(signature *ArtifactSignature) RequestOption {
+	return func(o requestOptions) requestOptions {
+		o.signature = signature
+		return o
+	}
+}
+
 // Sets the hash of the workload payload for verification purposes
 func Checksum(hash string) RequestOption {
 	return func(o requestOptions) requestOptions {
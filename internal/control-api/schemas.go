@@ -0,0 +1,74 @@
+package controlapi
+
+import "github.com/synadia-io/nex/internal/schema"
+
+// Returns the JSON Schemas of every message of the control API, keyed by the message's name
+func Schemas() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"agent_started_event":               schema.For(AgentStartedEvent{}),
+		"agent_stopped_event":               schema.For(AgentStoppedEvent{}),
+		"artifact_replication_event":        schema.For(ArtifactReplicationEvent{}),
+		"audit_entry":                       schema.For(AuditEntry{}),
+		"audit_request":                     schema.For(AuditRequest{}),
+		"audit_response":                    schema.For(AuditResponse{}),
+		"bulk_stop_request":                 schema.For(BulkStopRequest{}),
+		"bulk_stop_response":                schema.For(BulkStopResponse{}),
+		"cluster_deploy_request":            schema.For(ClusterDeployRequest{}),
+		"cluster_response":                  schema.For(ClusterResponse{}),
+		"credentials_request":               schema.For(CredentialsRequest{}),
+		"cron_trigger_executed_event":       schema.For(CronTriggerExecutedEvent{}),
+		"deploy_request":                    schema.For(DeployRequest{}),
+		"deploy_set_request":                schema.For(DeploySetRequest{}),
+		"deploy_set_response":               schema.For(DeploySetResponse{}),
+		"describe_request":                  schema.For(DescribeRequest{}),
+		"describe_response":                 schema.For(DescribeResponse{}),
+		"emitted_event":                     schema.For(EmittedEvent{}),
+		"emitted_log":                       schema.For(EmittedLog{}),
+		"envelope":                          schema.For(Envelope{}),
+		"info_request":                      schema.For(InfoRequest{}),
+		"info_response":                     schema.For(InfoResponse{}),
+		"machine_state_changed_event":       schema.For(MachineStateChangedEvent{}),
+		"memory_request":                    schema.For(MemoryRequest{}),
+		"memory_response":                   schema.For(MemoryResponse{}),
+		"node_capacity_event":               schema.For(NodeCapacityEvent{}),
+		"node_pause_event":                  schema.For(NodePauseEvent{}),
+		"node_reservation_event":            schema.For(NodeReservationEvent{}),
+		"node_reserved_response":            schema.For(NodeReservedResponse{}),
+		"node_standby_event":                schema.For(NodeStandbyEvent{}),
+		"node_started_event":                schema.For(NodeStartedEvent{}),
+		"node_stopped_event":                schema.For(NodeStoppedEvent{}),
+		"node_update_request":               schema.For(NodeUpdateRequest{}),
+		"node_update_response":              schema.For(NodeUpdateResponse{}),
+		"pause_request":                     schema.For(PauseRequest{}),
+		"pause_response":                    schema.For(PauseResponse{}),
+		"ping_response":                     schema.For(PingResponse{}),
+		"preflight_response":                schema.For(PreflightResponse{}),
+		"quota_exceeded_response":           schema.For(QuotaExceededResponse{}),
+		"raw_log":                           schema.For(RawLog{}),
+		"reserve_request":                   schema.For(ReserveRequest{}),
+		"reserve_response":                  schema.For(ReserveResponse{}),
+		"role_request":                      schema.For(RoleRequest{}),
+		"role_response":                     schema.For(RoleResponse{}),
+		"run_response":                      schema.For(RunResponse{}),
+		"stale_assets_event":                schema.For(StaleAssetsEvent{}),
+		"standby_request":                   schema.For(StandbyRequest{}),
+		"standby_response":                  schema.For(StandbyResponse{}),
+		"stop_request":                      schema.For(StopRequest{}),
+		"stop_response":                     schema.For(StopResponse{}),
+		"stream_frame":                      schema.For(StreamFrame{}),
+		"subjects_response":                 schema.For(SubjectsResponse{}),
+		"timeline_entry":                    schema.For(TimelineEntry{}),
+		"timeline_request":                  schema.For(TimelineRequest{}),
+		"timeline_response":                 schema.For(TimelineResponse{}),
+		"trigger_subject_rejected_response": schema.For(TriggerSubjectRejectedResponse{}),
+		"workload_failed_event":             schema.For(WorkloadFailedEvent{}),
+		"workload_lifecycle_event":          schema.For(WorkloadLifecycleEvent{}),
+		"workload_promote_request":          schema.For(WorkloadPromoteRequest{}),
+		"workload_recovery_event":           schema.For(WorkloadRecoveryEvent{}),
+		"workload_started_event":            schema.For(WorkloadStartedEvent{}),
+		"workload_stopped_event":            schema.For(WorkloadStoppedEvent{}),
+		"workload_update_request":           schema.For(WorkloadUpdateRequest{}),
+		"workload_update_response":          schema.For(WorkloadUpdateResponse{}),
+		"zombie_resources_event":            schema.For(ZombieResourcesEvent{}),
+	}
+}
@@ -5,12 +5,35 @@ import (
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
+	"github.com/synadia-io/nex/internal/compat"
 )
 
 type StopRequest struct {
 	WorkloadId  string `json:"workload_id"`
 	WorkloadJwt string `json:"workload_jwt"`
 	TargetNode  string `json:"target_node"`
+
+	// Fields unknown to this version of the API, preserved for forward compatibility
+	Unknown compat.UnknownFields `json:"-"`
+}
+
+func (request StopRequest) MarshalJSON() ([]byte, error) {
+	type stopRequest StopRequest
+	return compat.MarshalPreservingUnknown(stopRequest(request), request.Unknown)
+}
+
+func (request *StopRequest) UnmarshalJSON(data []byte) error {
+	type stopRequest StopRequest
+
+	var r stopRequest
+	unknown, err := compat.UnmarshalPreservingUnknown(data, &r)
+	if err != nil {
+		return err
+	}
+
+	*request = StopRequest(r)
+	request.Unknown = unknown
+	return nil
 }
 
 type StopResponse struct {
@@ -21,8 +44,14 @@ type StopResponse struct {
 }
 
 func NewStopRequest(workloadId string, name string, targetNode string, issuer nkeys.KeyPair) (*StopRequest, error) {
+	return NewSignedStopRequest(workloadId, name, targetNode, NkeyClaimsSigner{KeyPair: issuer})
+}
+
+// Creates a stop request whose JWT is signed by the given signer, which must be the one that
+// signed the workload's deploy request
+func NewSignedStopRequest(workloadId string, name string, targetNode string, signer ClaimsSigner) (*StopRequest, error) {
 	claims := jwt.NewGenericClaims(name)
-	jwtText, err := claims.Encode(issuer)
+	jwtText, err := signer.Sign(claims)
 	if err != nil {
 		return nil, err
 	}
@@ -34,8 +63,8 @@ func NewStopRequest(workloadId string, name string, targetNode string, issuer nk
 	}, nil
 }
 
-func (request *StopRequest) Validate(originalClaims *jwt.GenericClaims) error {
-	claims, err := jwt.DecodeGeneric(request.WorkloadJwt)
+func (request *StopRequest) Validate(originalClaims *jwt.GenericClaims, verifiers ...ClaimsVerifier) error {
+	claims, err := VerifyClaims(request.WorkloadJwt, verifiers...)
 	if err != nil {
 		return fmt.Errorf("could not decode workload JWT: %s", err)
 	}
@@ -52,3 +81,67 @@ func (request *StopRequest) Validate(originalClaims *jwt.GenericClaims) error {
 
 	return nil
 }
+
+// Requests that a node stop every workload in the request's namespace whose labels match
+// the given selector. An empty selector matches all workloads in the namespace. Only
+// workloads started by the issuer of the request's JWT are stopped
+type BulkStopRequest struct {
+	Selector   map[string]string `json:"selector,omitempty"`
+	IssuerJwt  string            `json:"issuer_jwt"`
+	TargetNode string            `json:"target_node"`
+}
+
+type BulkStopResponse struct {
+	Results []BulkStopResult `json:"results"`
+}
+
+// The outcome of stopping a single workload matched by a bulk stop request
+type BulkStopResult struct {
+	Stopped   bool   `json:"stopped"`
+	MachineId string `json:"machine_id"`
+	Name      string `json:"name"`
+	Error     string `json:"error,omitempty"`
+}
+
+func NewBulkStopRequest(selector map[string]string, targetNode string, issuer nkeys.KeyPair) (*BulkStopRequest, error) {
+	if _, err := issuer.PublicKey(); err != nil {
+		return nil, err
+	}
+
+	return NewSignedBulkStopRequest(selector, targetNode, NkeyClaimsSigner{KeyPair: issuer})
+}
+
+// Creates a bulk stop request whose JWT is signed by the given signer, stopping the workloads
+// it started
+func NewSignedBulkStopRequest(selector map[string]string, targetNode string, signer ClaimsSigner) (*BulkStopRequest, error) {
+	claims := jwt.NewGenericClaims(signer.Issuer())
+	jwtText, err := signer.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BulkStopRequest{
+		Selector:   selector,
+		IssuerJwt:  jwtText,
+		TargetNode: targetNode,
+	}, nil
+}
+
+// Decodes the request's JWT, returning the claims of the issuer requesting the bulk stop
+func (request *BulkStopRequest) Validate(verifiers ...ClaimsVerifier) (*jwt.GenericClaims, error) {
+	claims, err := VerifyClaims(request.IssuerJwt, verifiers...)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode issuer JWT: %s", err)
+	}
+
+	if claims.Subject != claims.Issuer {
+		return nil, fmt.Errorf("bulk stop claims must be issued by their subject")
+	}
+
+	return claims, nil
+}
+
+// Returns true if the given labels contain every key/value pair in the selector
+func (request *BulkStopRequest) Matches(labels map[string]string) bool {
+	return MatchesSelector(request.Selector, labels)
+}
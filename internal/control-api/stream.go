@@ -0,0 +1,134 @@
+package controlapi
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/nats-io/nats.go"
+)
+
+// Size of the channels on which streamed events and logs are delivered
+const streamBufferLength = 64
+
+// Limits the events delivered by StreamEvents. An empty namespace is the client's own, and * is
+// every namespace. Empty event types and workloads match any
+type EventFilter struct {
+	Namespace string
+	EventType string
+	// Matched against the workload_name of the event's data, so events which aren't about a
+	// workload are filtered out
+	Workload string
+}
+
+// Limits the logs delivered by StreamLogs. An empty namespace is the client's own, and * is every
+// namespace. Empty nodes and workloads match any
+type LogFilter struct {
+	Namespace string
+	NodeId    string
+	Workload  string
+}
+
+// Streams the events matching the filter as they're emitted, until the context is done, at which
+// point the channel is closed. Unlike MonitorEvents, the subscription doesn't outlive the stream
+func (api *Client) StreamEvents(ctx context.Context, filter EventFilter) (<-chan EmittedEvent, error) {
+	subject := EventSubject(api.filterNamespace(filter.Namespace), wildcardIfEmpty(filter.EventType))
+
+	return stream(ctx, api.nc, subject, func(m *nats.Msg) (EmittedEvent, bool) {
+		tokens := strings.Split(m.Subject, ".")
+		if len(tokens) != 4 {
+			return EmittedEvent{}, false
+		}
+
+		event := cloudevents.NewEvent()
+		err := json.Unmarshal(m.Data, &event)
+		if err != nil {
+			api.log.Debug("Skipping undecodable event", "subject", m.Subject, "err", err)
+			return EmittedEvent{}, false
+		}
+
+		if filter.Workload != "" {
+			var data struct {
+				WorkloadName string `json:"workload_name"`
+			}
+			if event.DataAs(&data) != nil || data.WorkloadName != filter.Workload {
+				return EmittedEvent{}, false
+			}
+		}
+
+		return EmittedEvent{
+			Event:     event,
+			Namespace: tokens[2],
+			EventType: tokens[3],
+		}, true
+	})
+}
+
+// Streams the logs matching the filter as they're emitted, until the context is done, at which
+// point the channel is closed
+func (api *Client) StreamLogs(ctx context.Context, filter LogFilter) (<-chan EmittedLog, error) {
+	subject := LogSubject(api.filterNamespace(filter.Namespace), wildcardIfEmpty(filter.Workload), wildcardIfEmpty(filter.NodeId), "*")
+
+	return stream(ctx, api.nc, subject, func(m *nats.Msg) (EmittedLog, bool) {
+		entry, ok := api.matchLogEntry(m, filter.Workload)
+		if !ok {
+			return EmittedLog{}, false
+		}
+		entry.Timestamp = entry.LoggedAt(time.Now()).UTC().Format(time.RFC3339Nano)
+		return *entry, true
+	})
+}
+
+func (api *Client) filterNamespace(namespace string) string {
+	if namespace == "" {
+		return api.namespace
+	}
+	return namespace
+}
+
+func wildcardIfEmpty(token string) string {
+	if token == "" {
+		return "*"
+	}
+	return token
+}
+
+// Subscribes to the subject, delivering the messages the given function accepts on the returned
+// channel until the context is done. The channel is only written and closed by a single
+// goroutine, so closing it can't race a delivery
+func stream[T any](ctx context.Context, nc *nats.Conn, subject string, accept func(*nats.Msg) (T, bool)) (<-chan T, error) {
+	msgs := make(chan *nats.Msg, streamBufferLength)
+	sub, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan T, streamBufferLength)
+	go func() {
+		defer close(out)
+		defer func() {
+			_ = sub.Unsubscribe()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-msgs:
+				v, ok := accept(m)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package controlapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+)
+
+// The namespace of the events and logs of nodes themselves, and of machines to which no workload
+// has been deployed yet. Nodes don't deploy workloads to it, so that it only ever carries theirs
+const SystemNamespace = "system"
+
+// Every event and log of a namespace is published beneath $NEX.events.{namespace} and
+// $NEX.logs.{namespace}, so that a single permission, or account import, of each covers them
+const (
+	EventSubjectPrefix = APIPrefix + ".events"
+	LogSubjectPrefix   = APIPrefix + ".logs"
+)
+
+// The subject of the events of the given type in the given namespace
+func EventSubject(namespace string, eventType string) string {
+	// $NEX.events.{namespace}.{event_type}
+	return fmt.Sprintf("%s.%s.%s", EventSubjectPrefix, namespace, eventType)
+}
+
+// The subject of the logs of the given workload, running in the given machine on the given node
+func LogSubject(namespace string, workload string, node string, vm string) string {
+	// $NEX.logs.{namespace}.{workload}.{node}.{vm}
+	return fmt.Sprintf("%s.%s.%s.%s.%s", LogSubjectPrefix, namespace, workload, node, vm)
+}
+
+// The tokens of a log subject
+type LogSubjectTokens struct {
+	Namespace string
+	Workload  string
+	NodeId    string
+	MachineId string
+}
+
+// Parses the subject of a log entry emitted by the given machine. Nodes predating the current
+// layout published logs on $NEX.logs.{namespace}.{node}.{workload}.{vm}, and their agents' on
+// $NEX.logs.{namespace}.{node}.{vm}.{workload}, both of which may still be persisted in log
+// streams; they're told apart by the node's public key in the fourth token
+func ParseLogSubject(subject string, machineId string) (*LogSubjectTokens, bool) {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) != 6 || tokens[0]+"."+tokens[1] != LogSubjectPrefix {
+		return nil, false
+	}
+
+	if !nkeys.IsValidPublicServerKey(tokens[3]) {
+		return &LogSubjectTokens{
+			Namespace: tokens[2],
+			Workload:  tokens[3],
+			NodeId:    tokens[4],
+			MachineId: tokens[5],
+		}, true
+	}
+
+	parsed := &LogSubjectTokens{
+		Namespace: tokens[2],
+		NodeId:    tokens[3],
+		Workload:  tokens[4],
+		MachineId: tokens[5],
+	}
+	if parsed.Workload == machineId {
+		parsed.Workload, parsed.MachineId = tokens[5], tokens[4]
+	}
+	return parsed, true
+}
@@ -1,9 +1,13 @@
 package controlapi
 
 import (
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/synadia-io/nex/internal/schema"
 )
 
 const (
@@ -11,13 +15,37 @@ const (
 )
 
 const (
-	InfoResponseType = "io.nats.nex.v1.info_response"
-	PingResponseType = "io.nats.nex.v1.ping_response"
-	RunResponseType  = "io.nats.nex.v1.run_response"
-	StopResponseType = "io.nats.nex.v1.stop_response"
-	TagOS            = "nex.os"
-	TagArch          = "nex.arch"
-	TagCPUs          = "nex.cpucount"
+	BulkStopResponseType      = "io.nats.nex.v1.bulk_stop_response"
+	InfoResponseType          = "io.nats.nex.v1.info_response"
+	PingResponseType          = "io.nats.nex.v1.ping_response"
+	PreflightResponseType     = "io.nats.nex.v1.preflight_response"
+	QuotaExceededResponseType = "io.nats.nex.v1.quota_exceeded_response"
+	TimelineResponseType      = "io.nats.nex.v1.timeline_response"
+	RunResponseType           = "io.nats.nex.v1.run_response"
+	StopResponseType          = "io.nats.nex.v1.stop_response"
+	SubjectsResponseType      = "io.nats.nex.v1.subjects_response"
+	DescribeResponseType      = "io.nats.nex.v1.describe_response"
+	MemoryResponseType        = "io.nats.nex.v1.memory_response"
+	ReserveResponseType       = "io.nats.nex.v1.reserve_response"
+	NodeReservedResponseType  = "io.nats.nex.v1.node_reserved_response"
+	AuditResponseType         = "io.nats.nex.v1.audit_response"
+	NodeUpdateResponseType    = "io.nats.nex.v1.node_update_response"
+	ClusterResponseType       = "io.nats.nex.v1.cluster_response"
+	DeploySetResponseType     = "io.nats.nex.v1.deploy_set_response"
+	RoleResponseType          = "io.nats.nex.v1.role_response"
+	StandbyResponseType       = "io.nats.nex.v1.standby_response"
+	PauseResponseType         = "io.nats.nex.v1.pause_response"
+	TagOS                     = "nex.os"
+	TagArch                   = "nex.arch"
+	TagCPUs                   = "nex.cpucount"
+	// The JetStream domain local to the node, to which workload artifacts are replicated
+	TagJsDomain = "nex.jsdomain"
+	// Comma-separated names of the machine templates, beyond the default, deploy requests may select
+	TagMachineTemplates = "nex.machine_templates"
+	// Returned in lieu of a run response when one of the request's trigger subjects is rejected
+	TriggerSubjectRejectedResponseType = "io.nats.nex.v1.trigger_subject_rejected_response"
+	// Returned by workload updates and promotions
+	WorkloadUpdateResponseType = "io.nats.nex.v1.workload_update_response"
 )
 
 type RunResponse struct {
@@ -25,6 +53,47 @@ type RunResponse struct {
 	MachineId string `json:"machine_id"`
 	Issuer    string `json:"issuer"`
 	Name      string `json:"name"`
+
+	// Only present when the workload was deployed to a cluster, naming the node its leader chose
+	NodeId string `json:"node_id,omitempty"`
+	// Only present when the workload has a webhook and the node knows its receiver's public URL
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// A node in a cluster, as it last advertised itself to the cluster's other members
+type ClusterMember struct {
+	NodeId        string            `json:"node_id"`
+	PublicXKey    string            `json:"public_xkey"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	WorkloadTypes []string          `json:"workload_types,omitempty"`
+	Capacity      NodeCapacity      `json:"capacity"`
+	// Names of the workloads deployed to the member, keyed by namespace, against which the
+	// anti-affinity of workloads deployed to the cluster is evaluated
+	Workloads map[string][]string `json:"workloads,omitempty"`
+	// IDs of the machines (and idle functions) of the workloads deployed to the member, by which
+	// the leader tells whether the replicas of deploy sets are still running
+	Machines []string `json:"machines,omitempty"`
+	// When the member advertised itself, by its own clock, so that replayed gossip is ignored
+	AdvertisedAt time.Time `json:"advertised_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// The live members of a cluster of nodes, ordered by node ID, and its leader, which places
+// workloads deployed to the cluster
+type ClusterResponse struct {
+	Cluster          string          `json:"cluster"`
+	Leader           string          `json:"leader"`
+	LeaderPublicXKey string          `json:"leader_public_xkey"`
+	Members          []ClusterMember `json:"members"`
+}
+
+// Requests that the leader of a cluster deploy a workload to the member best able to run it.
+// The request's environment must be encrypted for the leader, which re-encrypts it for the
+// chosen node
+type ClusterDeployRequest struct {
+	// Tags the chosen node must have
+	NodeTags map[string]string `json:"node_tags,omitempty"`
+	Request  *DeployRequest    `json:"request"`
 }
 
 type PingResponse struct {
@@ -33,6 +102,76 @@ type PingResponse struct {
 	Uptime          string            `json:"uptime"`
 	Tags            map[string]string `json:"tags,omitempty"`
 	RunningMachines int               `json:"running_machines"`
+
+	// Snapshot of the node's free capacity, refreshed periodically
+	Capacity *NodeCapacity `json:"capacity,omitempty"`
+
+	// Absent from the responses of nodes predating version negotiation
+	Capabilities *NodeCapabilities `json:"capabilities,omitempty"`
+}
+
+// The free capacity of a node, as advertised to schedulers
+type NodeCapacity struct {
+	WarmMachines         int       `json:"warm_machines"`
+	MachinePoolSize      int       `json:"machine_pool_size"`
+	RunningWorkloads     int       `json:"running_workloads"`
+	PendingDeploys       int       `json:"pending_deploys"`
+	AllocatableMemoryMib int64     `json:"allocatable_memory_mib"`
+	AllocatableVCPU      int64     `json:"allocatable_vcpu"`
+	RefreshedAt          time.Time `json:"refreshed_at"`
+
+	// Only present while the node is in standby, with no warm machines until it's activated.
+	// A node which activates on deploy may still be deployed to, e.g. for burst capacity
+	Standby          bool `json:"standby,omitempty"`
+	ActivateOnDeploy bool `json:"activate_on_deploy,omitempty"`
+}
+
+// The result of running a node's preflight checks, i.e., whether its host satisfies the
+// requirements for running firecracker machines
+type PreflightResponse struct {
+	NodeId string `json:"node_id"`
+	// True when every check passed
+	Passed bool `json:"passed"`
+	// Nodes running without a sandbox don't need to pass their checks to run workloads
+	NoSandbox bool             `json:"no_sandbox,omitempty"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+// A single preflight check. Path is where the requirement was found, if it was, otherwise
+// Searched lists the locations in which it was looked for
+type PreflightCheck struct {
+	Requirement string   `json:"requirement"`
+	Description string   `json:"description"`
+	Satisfied   bool     `json:"satisfied"`
+	Path        string   `json:"path,omitempty"`
+	Searched    []string `json:"searched,omitempty"`
+}
+
+// Permissions of a subject grant, from the perspective of the tenant's clients
+const (
+	SubjectPermissionPublish   = "publish"
+	SubjectPermissionSubscribe = "subscribe"
+)
+
+// The subjects a node uses for a namespace's control requests, events, logs, triggers and host
+// services, from which precise NATS permissions can be constructed for the namespace's tenant
+type SubjectsResponse struct {
+	NodeId       string         `json:"node_id"`
+	Namespace    string         `json:"namespace"`
+	Control      []SubjectGrant `json:"control"`
+	Events       []SubjectGrant `json:"events"`
+	Logs         []SubjectGrant `json:"logs"`
+	Triggers     []SubjectGrant `json:"triggers,omitempty"`
+	HostServices []SubjectGrant `json:"host_services,omitempty"`
+}
+
+// A subject (possibly containing wildcards) and the permission the tenant needs on it. Grants
+// specific to a workload name the workload
+type SubjectGrant struct {
+	Subject     string `json:"subject"`
+	Permission  string `json:"permission"`
+	Description string `json:"description"`
+	Workload    string `json:"workload,omitempty"`
 }
 
 type MemoryStat struct {
@@ -41,6 +180,155 @@ type MemoryStat struct {
 	MemAvailable int `json:"available"`
 }
 
+// Optional body of an info request. When a selector is given, only the machines
+// running workloads whose labels match it are included in the response
+type InfoRequest struct {
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// Requests the timeline of a single machine
+type TimelineRequest struct {
+	WorkloadId string `json:"workload_id"`
+}
+
+type TimelineResponse struct {
+	MachineId string          `json:"machine_id"`
+	Entries   []TimelineEntry `json:"entries"`
+}
+
+// Events recorded in machine timelines
+const (
+	TimelineEventCreated       = "created"
+	TimelineEventHandshake     = "handshake"
+	TimelineEventStateChanged  = "state_changed"
+	TimelineEventHealthy       = "healthy"
+	TimelineEventUnhealthy     = "unhealthy"
+	TimelineEventStopRequested = "stop_requested"
+	TimelineEventOOMKilled     = "oom_killed"
+)
+
+// A single event in the life of a machine. State is only present for state changes, and Reason
+// for events with an explanation (e.g., why a machine was stopped)
+type TimelineEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
+	State     string    `json:"state,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Requests a description of a single workload
+type DescribeRequest struct {
+	WorkloadId string `json:"workload_id"`
+}
+
+// Everything a node knows about a single workload. Request is the deploy request as submitted,
+// with its environment and workload JWT redacted; Effective is the same request as the node
+// applies it, with defaults filled in for unset options, the names of which are listed in
+// Defaulted. Events are the most recent entries in the timeline of the workload's machine
+type DescribeResponse struct {
+	NodeId          string            `json:"node_id"`
+	NodeVersion     string            `json:"node_version"`
+	Namespace       string            `json:"namespace"`
+	MachineId       string            `json:"machine_id"`
+	MachineTemplate string            `json:"machine_template"`
+	IP              string            `json:"ip,omitempty"`
+	DNSName         string            `json:"dns_name,omitempty"`
+	State           string            `json:"state"`
+	Healthy         bool              `json:"healthy"`
+	Ready           bool              `json:"ready,omitempty"`
+	Workload        WorkloadSummary   `json:"workload"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Resources       WorkloadResources `json:"resources"`
+	TriggerSubjects []string          `json:"trigger_subjects,omitempty"`
+	// The number of times the workload has been redeployed after exiting
+	RetryCount      uint       `json:"retry_count"`
+	MachineStarted  *time.Time `json:"machine_started,omitempty"`
+	WorkloadStarted *time.Time `json:"workload_started,omitempty"`
+
+	Request         DeployRequest `json:"request"`
+	EnvironmentKeys []string      `json:"environment_keys,omitempty"`
+	Effective       DeployRequest `json:"effective"`
+	Defaulted       []string      `json:"defaulted,omitempty"`
+
+	// Only present when the workload declared a health check
+	LastHealthCheck *time.Time `json:"last_health_check,omitempty"`
+	HealthMessage   string     `json:"health_message,omitempty"`
+
+	// Only present when the workload declared cron triggers
+	CronTriggers []CronTriggerStatus `json:"cron_triggers,omitempty"`
+
+	// Only present when the node scanned the workload's artifact
+	ScanResults []ArtifactScanResult `json:"scan_results,omitempty"`
+
+	// Only present once the workload's machine has completed its handshake
+	Boot *MachineBootTimings `json:"boot,omitempty"`
+
+	// Only present while the workload, or the workload it replaces, is part of a canary update
+	Canary *CanarySplit `json:"canary,omitempty"`
+
+	Events []TimelineEntry `json:"events,omitempty"`
+}
+
+// The resources allocated to a single workload
+type WorkloadResources struct {
+	VCPU          int64 `json:"vcpu"`
+	MemoryMib     int64 `json:"memory_mib"`
+	DeployedBytes int64 `json:"deployed_bytes"`
+
+	// Memory in use by the machine as of its agent's last report, and the most it has used
+	UsedMemoryMib int64 `json:"used_memory_mib,omitempty"`
+	PeakMemoryMib int64 `json:"peak_memory_mib,omitempty"`
+
+	// Host memory and CPU time used by the machine's firecracker process, when the node places
+	// machines in cgroups
+	HostMemoryMib  int64 `json:"host_memory_mib,omitempty"`
+	HostCPUSeconds int64 `json:"host_cpu_seconds,omitempty"`
+	// Time the machine's firecracker process was throttled for exhausting its CPU quota, and the
+	// percentage of CPU bandwidth periods in which it was throttled
+	HostCPUThrottledSeconds int64   `json:"host_cpu_throttled_seconds,omitempty"`
+	HostCPUThrottledPercent float64 `json:"host_cpu_throttled_percent,omitempty"`
+}
+
+// Requests the observed memory use of the namespace's workloads, along with a recommended
+// memory size for each, optionally limited to a single workload by name
+type MemoryRequest struct {
+	WorkloadName string `json:"workload_name,omitempty"`
+}
+
+type MemoryResponse struct {
+	NodeId    string           `json:"node_id"`
+	Namespace string           `json:"namespace"`
+	Workloads []WorkloadMemory `json:"workloads"`
+}
+
+// Actions recommended for the memory size of a workload's machine
+const (
+	MemoryRecommendationIncrease         = "increase"
+	MemoryRecommendationDecrease         = "decrease"
+	MemoryRecommendationKeep             = "keep"
+	MemoryRecommendationInsufficientData = "insufficient_data"
+)
+
+// The memory use a node has observed of a workload across every machine it has run in since
+// the node started. MemSizeMib is the memory size of the machine it most recently ran in
+type WorkloadMemory struct {
+	WorkloadName   string               `json:"workload_name"`
+	MemSizeMib     int64                `json:"memsize_mib"`
+	PeakMib        int64                `json:"peak_mib"`
+	Samples        int                  `json:"samples"`
+	OOMKills       uint64               `json:"oom_kills"`
+	FirstSeen      time.Time            `json:"first_seen"`
+	LastSeen       time.Time            `json:"last_seen"`
+	Recommendation MemoryRecommendation `json:"recommendation"`
+}
+
+// A recommended memory size for a workload's machine, and why
+type MemoryRecommendation struct {
+	Action     string `json:"action"`
+	MemSizeMib int64  `json:"memsize_mib"`
+	Reason     string `json:"reason"`
+}
+
 type InfoResponse struct {
 	Version                string            `json:"version"`
 	Uptime                 string            `json:"uptime"`
@@ -49,13 +337,377 @@ type InfoResponse struct {
 	Memory                 *MemoryStat       `json:"memory,omitempty"`
 	Machines               []MachineSummary  `json:"machines"`
 	SupportedWorkloadTypes []string          `json:"supported_workload_types,omitempty"`
+
+	// Only present when the namespace is subject to a resource quota on the node
+	Quota *QuotaStatus `json:"quota,omitempty"`
+
+	// Only present while the node is reserved exclusively for a namespace
+	Reservation *NodeReservation `json:"reservation,omitempty"`
+
+	// Only present while the node is in standby
+	Standby bool `json:"standby,omitempty"`
+
+	// Only present while the node's machine creation is paused
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+
+	// Only present once the node has started machines
+	MachineBoot *MachineBootSummary `json:"machine_boot,omitempty"`
+
+	// Absent from the responses of nodes predating version negotiation
+	Capabilities *NodeCapabilities `json:"capabilities,omitempty"`
+}
+
+// How long each phase of starting a machine took, in milliseconds: copying its root filesystem,
+// setting up its CNI network, starting and configuring the firecracker process, booting the
+// kernel until the agent started, starting the agent until it requested its handshake, and the
+// handshake reaching the node. Phases which don't apply, e.g. when agents aren't sandboxed, are
+// zero. The kernel boot, agent start and handshake phases are measured partly by the agent's
+// clock, so they're only as accurate as the machine's clock is in sync with the node's
+type MachineBootTimings struct {
+	RootfsCopyMillis       int64 `json:"rootfs_copy_ms"`
+	CNISetupMillis         int64 `json:"cni_setup_ms"`
+	FirecrackerStartMillis int64 `json:"firecracker_start_ms"`
+	KernelBootMillis       int64 `json:"kernel_boot_ms"`
+	AgentStartMillis       int64 `json:"agent_start_ms"`
+	HandshakeMillis        int64 `json:"handshake_ms"`
+	TotalMillis            int64 `json:"total_ms"`
+}
+
+// The boot timings of the node's most recently started machines: the average of each phase, and
+// the timings of the machine which took longest to start
+type MachineBootSummary struct {
+	Machines int                `json:"machines"`
+	Average  MachineBootTimings `json:"average"`
+	Slowest  MachineBootTimings `json:"slowest"`
+}
+
+// Reserves the node exclusively for the requesting namespace for the given duration, or releases
+// the namespace's reservation. Reserving a node the namespace already holds extends the reservation
+type ReserveRequest struct {
+	DurationSeconds int  `json:"duration_seconds,omitempty"`
+	Release         bool `json:"release,omitempty"`
+}
+
+type ReserveResponse struct {
+	NodeId    string     `json:"node_id"`
+	Namespace string     `json:"namespace"`
+	Reserved  bool       `json:"reserved"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Activates a node in standby, warming its machine pools to their configured size, or returns an
+// active node to standby, stopping its warm machines. Running workloads are left running
+type StandbyRequest struct {
+	Standby bool `json:"standby"`
+}
+
+type StandbyResponse struct {
+	NodeId          string `json:"node_id"`
+	Standby         bool   `json:"standby"`
+	WarmMachines    int    `json:"warm_machines"`
+	MachinePoolSize int    `json:"machine_pool_size"`
+	// Warm machines stopped as the node returned to standby
+	StoppedMachines int `json:"stopped_machines,omitempty"`
+}
+
+// Pauses the creation of machines on the node for the given duration, e.g. to quiesce disk and
+// network activity during a host backup, or resumes it early. While paused, the node doesn't refill
+// its machine pools and deploys which would need a new machine fail; workloads are still deployed
+// to warm machines. Pausing a paused node replaces its pause
+type PauseRequest struct {
+	DurationSeconds int  `json:"duration_seconds,omitempty"`
+	Resume          bool `json:"resume,omitempty"`
+}
+
+type PauseResponse struct {
+	NodeId       string     `json:"node_id"`
+	Paused       bool       `json:"paused"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	WarmMachines int        `json:"warm_machines"`
+}
+
+// Queries the namespace's recent entries in a node's audit log, optionally limited to those of a
+// single operation (e.g., "DEPLOY") recorded since the given time. Limit defaults to 100
+type AuditRequest struct {
+	Since     *time.Time `json:"since,omitempty"`
+	Operation string     `json:"operation,omitempty"`
+	Limit     int        `json:"limit,omitempty"`
+}
+
+type AuditResponse struct {
+	NodeId    string       `json:"node_id"`
+	Namespace string       `json:"namespace"`
+	Entries   []AuditEntry `json:"entries"`
+}
+
+// A control API request recorded in a node's audit log. The issuer and target workload are filled
+// in from the request and its response as far as they're known; Requester is the sending NATS
+// account (and user) when the NATS server shares requester info with the node
+type AuditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	NodeId       string    `json:"node_id"`
+	Operation    string    `json:"operation"`
+	Namespace    string    `json:"namespace"`
+	Issuer       string    `json:"issuer,omitempty"`
+	WorkloadId   string    `json:"workload_id,omitempty"`
+	WorkloadName string    `json:"workload_name,omitempty"`
+	Requester    string    `json:"requester,omitempty"`
+	Success      bool      `json:"success"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Instructs a node to update itself to the nex binary stored in the given object store bucket,
+// which must have the given hex-encoded SHA-256 digest and be signed by a key the node trusts.
+// If Version is given, the version the binary reports must contain it
+type NodeUpdateRequest struct {
+	Bucket    string            `json:"bucket"`
+	Name      string            `json:"name"`
+	JsDomain  *string           `json:"jsdomain,omitempty"`
+	Digest    string            `json:"digest"`
+	Signature ArtifactSignature `json:"signature"`
+	Version   string            `json:"version,omitempty"`
+}
+
+// Sent once the node has installed the new binary, just before it restarts into it
+type NodeUpdateResponse struct {
+	NodeId  string `json:"node_id"`
+	Version string `json:"version"`
+	Digest  string `json:"digest"`
+	// Either "exec" or "supervisor"
+	Restart string `json:"restart"`
+}
+
+// A time-boxed exclusive reservation of a node by a namespace. While it's in effect, the node
+// rejects deploy requests from all other namespaces
+type NodeReservation struct {
+	Namespace string    `json:"namespace"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Returned in lieu of a run response when the node is reserved exclusively for another namespace
+type NodeReservedResponse struct {
+	NodeId string `json:"node_id"`
+	NodeReservation
+}
+
+func (r *NodeReservedResponse) Error() string {
+	return fmt.Sprintf("node %s is reserved for namespace %s until %s", r.NodeId, r.Namespace, r.ExpiresAt.Format(time.RFC3339))
+}
+
+// Resources limited by namespace quotas
+const (
+	QuotaResourceWorkloads     = "workloads"
+	QuotaResourceVCPU          = "vcpu"
+	QuotaResourceMemory        = "memory_mib"
+	QuotaResourceDeployedBytes = "deployed_bytes"
+)
+
+// Limits on the resources a namespace's workloads may consume on a node. A zero limit
+// is unlimited
+type NamespaceQuota struct {
+	MaxWorkloads     int   `json:"max_workloads,omitempty"`
+	MaxVCPU          int64 `json:"max_vcpu,omitempty"`
+	MaxMemoryMib     int64 `json:"max_memory_mib,omitempty"`
+	MaxDeployedBytes int64 `json:"max_deployed_bytes,omitempty"`
+}
+
+// The resources a namespace's workloads are consuming on a node
+type QuotaUsage struct {
+	Workloads     int   `json:"workloads"`
+	VCPU          int64 `json:"vcpu"`
+	MemoryMib     int64 `json:"memory_mib"`
+	DeployedBytes int64 `json:"deployed_bytes"`
+}
+
+type QuotaStatus struct {
+	Limits NamespaceQuota `json:"limits"`
+	Usage  QuotaUsage     `json:"usage"`
+}
+
+// Returned in lieu of a run response when deploying a workload would exceed its namespace's quota
+type QuotaExceededResponse struct {
+	Namespace string `json:"namespace"`
+	Resource  string `json:"resource"`
+	Limit     int64  `json:"limit"`
+	Requested int64  `json:"requested"`
+}
+
+func (r *QuotaExceededResponse) Error() string {
+	return fmt.Sprintf("namespace %s quota exceeded: %s limit is %d, deployment requires %d", r.Namespace, r.Resource, r.Limit, r.Requested)
+}
+
+// Reasons for which trigger subjects are rejected
+const (
+	TriggerSubjectInvalid  = "invalid"
+	TriggerSubjectReserved = "reserved_prefix"
+	TriggerSubjectTooBroad = "too_broad"
+	TriggerSubjectClaimed  = "claimed"
+)
+
+// Returned in lieu of a run response when a trigger subject is invalid, overlaps a reserved
+// prefix, is broader than the node's policy permits or is already claimed by another workload
+type TriggerSubjectRejectedResponse struct {
+	Subject string `json:"subject"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail"`
+}
+
+func (r *TriggerSubjectRejectedResponse) Error() string {
+	return fmt.Sprintf("trigger subject %s rejected (%s): %s", r.Subject, r.Reason, r.Detail)
 }
 
 type MachineSummary struct {
-	Id       string          `json:"id"`
-	Healthy  bool            `json:"healthy"`
+	Id      string `json:"id"`
+	Healthy bool   `json:"healthy"`
+	// Whether the workload is deployed and, if it declared a readiness probe, that probe passed
+	Ready    bool            `json:"ready,omitempty"`
+	State    string          `json:"state,omitempty"`
 	Uptime   string          `json:"uptime"`
 	Workload WorkloadSummary `json:"workload,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Only present when the workload declared a health check
+	LastHealthCheck *time.Time `json:"last_health_check,omitempty"`
+	HealthMessage   string     `json:"health_message,omitempty"`
+
+	// Only present when the workload declared cron triggers
+	CronTriggers []CronTriggerStatus `json:"cron_triggers,omitempty"`
+
+	// Only present for functions, once their agent has reported their trigger executions
+	Triggers *TriggerExecutionStatus `json:"triggers,omitempty"`
+}
+
+// The trigger executions of a function's machine: messages waiting in the node's queue (when
+// the function's trigger concurrency is limited), messages received by its agent but not yet
+// executed, and those being executed as of the agent's last report. A machine is saturated when
+// messages are waiting in its agent, i.e. when the machine rather than the node is the bottleneck
+type TriggerExecutionStatus struct {
+	NodeQueued  int       `json:"node_queued"`
+	AgentQueued int       `json:"agent_queued"`
+	Active      int       `json:"active"`
+	Executed    uint64    `json:"executed"`
+	Saturated   bool      `json:"saturated"`
+	ReportedAt  time.Time `json:"reported_at"`
+}
+
+// Bounds the number of trigger messages a function executes concurrently. Messages beyond
+// MaxInFlight wait in a queue of QueueSize (100 by default); once it's full, Overflow decides
+// what happens to new messages: "reject" (the default), "drop_oldest" or "block"
+type TriggerConcurrency struct {
+	MaxInFlight int    `json:"max_in_flight"`
+	QueueSize   int    `json:"queue_size,omitempty"`
+	Overflow    string `json:"overflow,omitempty"`
+}
+
+// A schedule on which a function is triggered. Schedule is a standard five-field cron
+// expression (minute hour day-of-month month day-of-week) or one of the @yearly, @monthly,
+// @weekly, @daily and @hourly macros, evaluated in the given IANA time zone (UTC by default)
+type CronTrigger struct {
+	Schedule string  `json:"schedule"`
+	Timezone *string `json:"timezone,omitempty"`
+	// Optional payload delivered to the function on each scheduled execution
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// An inbound webhook through which HTTP POSTs to the node's webhook receiver trigger a function.
+// Callers present a token, either as a bearer token or as the token query parameter, whose
+// hex-encoded SHA-256 digest is given here so the token itself never travels through the
+// control API
+type WebhookTrigger struct {
+	TokenSha256 string `json:"token_sha256"`
+	// The subject the webhook's requests are delivered on, which must be a literal subject
+	// matching one of the function's trigger subjects. Defaults to its first trigger subject
+	Subject *string `json:"subject,omitempty"`
+}
+
+// Registers a function as a NATS service (micro), so that it's listed by `nats micro ls` and
+// answers the protocol's PING, INFO and STATS requests, with its trigger subjects as endpoints
+type MicroServiceRegistration struct {
+	// Name of the service, of letters, digits, dashes and underscores. Defaults to the workload's
+	// name
+	Name *string `json:"name,omitempty"`
+	// Semantic version of the service. Defaults to 0.0.0
+	Version *string `json:"version,omitempty"`
+}
+
+type CronTriggerStatus struct {
+	Schedule  string     `json:"schedule"`
+	Timezone  string     `json:"timezone"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// A probe run by the agent on an interval to determine whether a workload is healthy. Type
+// is one of "exec", "http" or "nats", which require Command, URL and Subject respectively
+type HealthCheck struct {
+	Type           string   `json:"type"`
+	Command        []string `json:"command,omitempty"`
+	URL            *string  `json:"url,omitempty"`
+	Subject        *string  `json:"subject,omitempty"`
+	NatsUrl        *string  `json:"nats_url,omitempty"`
+	IntervalMillis int      `json:"interval_ms,omitempty"`
+	TimeoutMillis  int      `json:"timeout_ms,omitempty"`
+}
+
+// A signature of a workload artifact as produced by `cosign sign-blob`. Certificate is only
+// present for keyless signatures, Attestation is an optional DSSE envelope as produced
+// by `cosign attest-blob`, and Bundle is the optional `--bundle` output recording the signature
+// in a transparency log
+type ArtifactSignature struct {
+	Signature   string  `json:"signature"`
+	Certificate *string `json:"certificate,omitempty"`
+	Attestation *string `json:"attestation,omitempty"`
+	Bundle      *string `json:"bundle,omitempty"`
+}
+
+// Requests NATS user credentials for a workload. The node's configured maximum TTL
+// applies when TTLSeconds is unspecified or exceeds it
+type CredentialsRequest struct {
+	PublishAllow   []string `json:"publish_allow,omitempty"`
+	SubscribeAllow []string `json:"subscribe_allow,omitempty"`
+	TTLSeconds     int      `json:"ttl_seconds,omitempty"`
+}
+
+// A command run by the agent inside the sandbox before a workload starts or after it stops
+type WorkloadHook struct {
+	Command       []string `json:"command"`
+	TimeoutMillis int      `json:"timeout_ms,omitempty"`
+}
+
+// A soft memory limit on a workload's machine, at which the agent notifies the workload with a
+// memory_pressure event and, for elf workloads, optionally a signal (SIGUSR1, SIGUSR2 or SIGHUP)
+type MemorySoftLimit struct {
+	ThresholdPercent int     `json:"threshold_percent,omitempty"`
+	PressurePercent  int     `json:"pressure_percent,omitempty"`
+	Signal           *string `json:"signal,omitempty"`
+	IntervalMillis   int     `json:"interval_ms,omitempty"`
+}
+
+// Limits on the output of a workload forwarded to the node as logs: the least severe level
+// forwarded (debug, info, warn or error; stdout is info and stderr error) and the lines forwarded
+// a second, on average and in a burst
+type LogLimits struct {
+	Level          *string `json:"level,omitempty"`
+	LinesPerSecond int     `json:"lines_per_second,omitempty"`
+	Burst          int     `json:"burst,omitempty"`
+}
+
+// Restricts the destinations a workload's machine may connect to, in addition to the node's
+// internal NATS server and, if allowed, DNS
+type EgressPolicy struct {
+	Allow    []EgressRule `json:"allow"`
+	AllowDNS bool         `json:"allow_dns,omitempty"`
+}
+
+// A destination a workload may connect to: an IPv4 network (or address) or a DNS name resolved
+// by the node at deploy time, optionally limited to the given ports
+type EgressRule struct {
+	CIDR     *string `json:"cidr,omitempty"`
+	Host     *string `json:"host,omitempty"`
+	Ports    []int   `json:"ports,omitempty"`
+	Protocol string  `json:"protocol,omitempty"`
 }
 
 type WorkloadSummary struct {
@@ -70,6 +722,51 @@ type Envelope struct {
 	PayloadType string      `json:"type"`
 	Data        interface{} `json:"data,omitempty"`
 	Error       interface{} `json:"error,omitempty"`
+	// Identifies the kind of failure, for errors clients may want to handle programmatically
+	Code string `json:"code,omitempty"`
+	// The version of the control API the response is in. Absent from the responses of nodes
+	// predating negotiation, which are of version 1
+	APIVersion int `json:"api_version,omitempty"`
+}
+
+// Codes of the errors in failed responses' envelopes. Every failed response carries one, and
+// codes are never renamed, so clients can branch on them rather than on error messages
+const (
+	ErrorCodeArtifactRejected        = "artifact_rejected"
+	ErrorCodeArtifactUnavailable     = "artifact_unavailable"
+	ErrorCodeConflict                = "conflict"
+	ErrorCodeDeployFailed            = "deploy_failed"
+	ErrorCodeHandshakeTimeout        = "handshake_timeout"
+	ErrorCodeInternal                = "internal"
+	ErrorCodeInvalidRequest          = "invalid_request"
+	ErrorCodeNodeReserved            = "node_reserved"
+	ErrorCodeNodeUnavailable         = "node_unavailable"
+	ErrorCodeNotFound                = "not_found"
+	ErrorCodePlacementRejected       = "placement_rejected"
+	ErrorCodePoolExhausted           = "pool_exhausted"
+	ErrorCodeQuotaExceeded           = "quota_exceeded"
+	ErrorCodeTriggerSubjectRejected  = "trigger_subject_rejected"
+	ErrorCodeUnauthorized            = "unauthorized"
+	ErrorCodeUnsupportedAPIVersion   = "unsupported_api_version"
+	ErrorCodeUnsupportedFeature      = "unsupported_feature"
+	ErrorCodeUnsupportedWorkloadType = "unsupported_workload_type"
+)
+
+// A failed response from a node. The code, if any, is one of the ErrorCode constants
+type RequestError struct {
+	Code    string
+	Message string
+	// Only present when the request's payload didn't match its message's schema
+	Fields []schema.FieldError
+}
+
+// The data of a failed response to a request whose payload didn't match its message's schema
+type ValidationFailure struct {
+	Fields []schema.FieldError `json:"fields"`
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
 }
 
 // Wrapper for what goes across the wire
@@ -81,10 +778,52 @@ type EmittedLog struct {
 	RawLog
 }
 
+// Sources of log records: the output of a workload, the logs of the agent running it, or those
+// of the node (host) about it
+const (
+	LogSourceAgent    = "agent"
+	LogSourceWorkload = "workload"
+	LogSourceHost     = "host"
+)
+
+// A structured log record, as published by nodes on $NEX.logs. Records published by older nodes
+// carry only their text, level and machine ID
 type RawLog struct {
-	Text      string     `json:"text"`
-	Level     slog.Level `json:"level"`
-	MachineId string     `json:"machine_id"`
+	Time         *time.Time `json:"time,omitempty"`
+	Level        slog.Level `json:"level"`
+	Source       string     `json:"source,omitempty"`
+	Namespace    string     `json:"namespace,omitempty"`
+	WorkloadName string     `json:"workload_name,omitempty"`
+	MachineId    string     `json:"machine_id"`
+	Text         string     `json:"text"`
+}
+
+// The time at which the record was logged, or the given time at which it was received if it
+// doesn't carry one
+func (l RawLog) LoggedAt(received time.Time) time.Time {
+	if l.Time == nil {
+		return received
+	}
+	return *l.Time
+}
+
+// Kinds of frames sent by a node's event stream endpoint
+const (
+	StreamFrameKindEvent = "event"
+	StreamFrameKindLog   = "log"
+)
+
+// A frame sent over a node's event stream WebSocket, carrying either an event (as a cloud event)
+// or a workload's log entry (as a RawLog), along with where it came from
+type StreamFrame struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	EventType string `json:"event_type,omitempty"`
+	NodeId    string `json:"node_id,omitempty"`
+	Workload  string `json:"workload_name,omitempty"`
+	// The number of frames dropped since the previous one because the client couldn't keep up
+	Dropped int             `json:"dropped,omitempty"`
+	Data    json.RawMessage `json:"data"`
 }
 
 // Note this a wrapper to add context to a cloud event
@@ -103,5 +842,18 @@ func NewEnvelope(dataType string, data interface{}, err *string) Envelope {
 		PayloadType: dataType,
 		Data:        data,
 		Error:       e,
+		APIVersion:  APIVersion,
 	}
 }
+
+// Returns true if the given labels contain every key/value pair in the selector. An
+// empty selector matches everything
+func MatchesSelector(selector map[string]string, labels map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,206 @@
+package controlapi
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+const (
+	// Algorithm of JWTs signed by a Vault transit key
+	AlgorithmVaultTransit = "vault-transit"
+
+	// Prefix of the issuer of JWTs signed by a Vault transit key: vault:{mount}/{key}
+	VaultTransitIssuerPrefix = "vault:"
+
+	defaultVaultTransitMount = "transit"
+	vaultTransitTimeout      = 10 * time.Second
+)
+
+// A key held by Vault's transit secrets engine (which may itself be backed by an HSM), used to
+// sign the JWTs of control requests in place of an nkey and to verify them. Signing and
+// verification are both performed by Vault, so the key never leaves it. The JWTs it signs are
+// issued by vault:{mount}/{key}, which nodes must trust as they would an nkey issuer
+type VaultTransit struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200
+	Address string
+	Token   string
+	// Vault Enterprise namespace, if any
+	Namespace string
+	// Mount path of the transit secrets engine; defaults to "transit"
+	Mount string
+	Key   string
+
+	HTTPClient *http.Client
+}
+
+// Refers to a transit key given as {mount}/{key}, or just {key} in the default mount
+func NewVaultTransit(address string, token string, key string) *VaultTransit {
+	v := &VaultTransit{Address: address, Token: token, Key: key}
+	if i := strings.LastIndex(key, "/"); i > 0 {
+		v.Mount = key[:i]
+		v.Key = key[i+1:]
+	}
+	return v
+}
+
+func (v *VaultTransit) mount() string {
+	if v.Mount != "" {
+		return strings.Trim(v.Mount, "/")
+	}
+	return defaultVaultTransitMount
+}
+
+// The issuer of the JWTs signed by the key
+func (v *VaultTransit) Issuer() string {
+	return fmt.Sprintf("%s%s/%s", VaultTransitIssuerPrefix, v.mount(), v.Key)
+}
+
+// Signs the claims with the transit key
+func (v *VaultTransit) Sign(claims *jwt.GenericClaims) (string, error) {
+	claims.Issuer = v.Issuer()
+	claims.IssuedAt = time.Now().UTC().Unix()
+	claims.ID = ""
+
+	// the JWT ID is a hash of the claims, as with nkey-signed JWTs
+	raw, err := json.Marshal(claims.ClaimsData)
+	if err != nil {
+		return "", err
+	}
+	hash := sha512.Sum512_256(raw)
+	claims.ID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(hash[:])
+
+	header, err := json.Marshal(&jwt.Header{Type: jwt.TokenTypeJwt, Algorithm: AlgorithmVaultTransit})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := fmt.Sprintf("%s.%s", base64.RawURLEncoding.EncodeToString(header), base64.RawURLEncoding.EncodeToString(payload))
+
+	var res struct {
+		Signature string `json:"signature"`
+	}
+	err = v.request("sign", map[string]interface{}{
+		"input": base64.StdEncoding.EncodeToString([]byte(signed)),
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	if res.Signature == "" {
+		return "", errors.New("vault returned no signature")
+	}
+
+	return fmt.Sprintf("%s.%s", signed, base64.RawURLEncoding.EncodeToString([]byte(res.Signature))), nil
+}
+
+// Verifies JWTs signed by the transit key
+func (v *VaultTransit) Verify(token string) (*jwt.GenericClaims, error) {
+	header, err := jwtHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Algorithm != AlgorithmVaultTransit {
+		return nil, ErrUnsupportedClaims
+	}
+
+	chunks := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(chunks[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwt.GenericClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != v.Issuer() {
+		return nil, ErrUnsupportedClaims
+	}
+	if claims.Data == nil {
+		claims.Data = make(map[string]interface{})
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(chunks[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Valid bool `json:"valid"`
+	}
+	err = v.request("verify", map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString([]byte(chunks[0] + "." + chunks[1])),
+		"signature": string(signature),
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Valid {
+		return nil, errors.New("claim failed vault transit signature verification")
+	}
+
+	return &claims, nil
+}
+
+// Calls the given operation (sign or verify) of the transit key, decoding the data of Vault's
+// response into res
+func (v *VaultTransit) request(operation string, body map[string]interface{}, res interface{}) error {
+	if v.Address == "" || v.Key == "" {
+		return errors.New("vault address and transit key are required")
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", strings.TrimRight(v.Address, "/"), v.mount(), operation, v.Key)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: vaultTransitTimeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault transit %s failed: %s", operation, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&envelope)
+	if err != nil {
+		return fmt.Errorf("vault transit %s failed: %s (%s)", operation, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || len(envelope.Errors) > 0 {
+		return fmt.Errorf("vault transit %s failed: %s %s", operation, resp.Status, strings.Join(envelope.Errors, "; "))
+	}
+
+	return json.Unmarshal(envelope.Data, res)
+}
@@ -0,0 +1,147 @@
+package controlapi
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Versions of the control API. Clients send the version they speak in the APIVersionHeader of
+// their requests, and nodes stamp theirs in the api_version of their responses' envelopes. A
+// request without the header, or an envelope without a version, is of version 1, as spoken
+// before versions were negotiated. Versions only ever add to the API, so either side can read
+// the messages of newer versions, ignoring what it doesn't know; each rejects the messages of
+// versions older than its MinAPIVersion, which is only raised once they can no longer be served
+const (
+	APIVersion       = 1
+	MinAPIVersion    = 1
+	APIVersionHeader = "Nex-Api-Version"
+)
+
+// The version of the contract between workloads of a type and the agent running them, e.g. the
+// functions a wasm module must export or how a function receives its trigger payloads
+const WorkloadTypeVersion = 1
+
+// The control API operations a node may support, named after the verbs of their request subjects
+const (
+	OperationAudit          = "AUDIT"
+	OperationCluster        = "CLUSTER"
+	OperationClusterDeploy  = "CLUSTERDEPLOY"
+	OperationDeploy         = "DEPLOY"
+	OperationDeploySet      = "DEPLOYSET"
+	OperationDescribe       = "DESCRIBE"
+	OperationInfo           = "INFO"
+	OperationMemory         = "MEMORY"
+	OperationPause          = "PAUSE"
+	OperationPing           = "PING"
+	OperationPreflight      = "PREFLIGHT"
+	OperationPromote        = "PROMOTE"
+	OperationReserve        = "RESERVE"
+	OperationRoles          = "ROLES"
+	OperationStandby        = "STANDBY"
+	OperationStop           = "STOP"
+	OperationStopAll        = "STOPALL"
+	OperationSubjects       = "SUBJECTS"
+	OperationTimeline       = "TIMELINE"
+	OperationUpdate         = "UPDATE"
+	OperationUpdateWorkload = "UPDATEWORKLOAD"
+)
+
+// What a node supports, as advertised in its PING and INFO responses, so that clients can tell
+// whether a node can serve a request before sending it
+type NodeCapabilities struct {
+	// Every version of the control API the node speaks, in ascending order
+	APIVersions   []int                    `json:"api_versions"`
+	Operations    []string                 `json:"operations"`
+	WorkloadTypes []WorkloadTypeCapability `json:"workload_types"`
+}
+
+// A workload type a node runs, along with the versions of its contract the node's agents support
+type WorkloadTypeCapability struct {
+	Type     string `json:"type"`
+	Versions []int  `json:"versions"`
+}
+
+// Returns the highest version of the control API spoken both by this client and the node, or
+// false if there is none, i.e., the node or the client is too old for the other
+func (c *NodeCapabilities) NegotiateAPIVersion() (int, bool) {
+	for i := len(c.APIVersions) - 1; i >= 0; i-- {
+		if c.APIVersions[i] >= MinAPIVersion && c.APIVersions[i] <= APIVersion {
+			return c.APIVersions[i], true
+		}
+	}
+	return 0, false
+}
+
+// Returns true if the node supports the given operation, one of the Operation constants
+func (c *NodeCapabilities) SupportsOperation(operation string) bool {
+	return slices.Contains(c.Operations, operation)
+}
+
+// Returns true if the node runs workloads of the given type, at the given version of its contract
+func (c *NodeCapabilities) SupportsWorkloadType(workloadType string, version int) bool {
+	for _, t := range c.WorkloadTypes {
+		if t.Type == workloadType {
+			return slices.Contains(t.Versions, version)
+		}
+	}
+	return false
+}
+
+// Returns the version of the control API a request was sent with, defaulting to 1 for requests
+// from clients predating negotiation
+func RequestAPIVersion(m *nats.Msg) (int, error) {
+	if m.Header == nil {
+		return 1, nil
+	}
+	raw := m.Header.Get(APIVersionHeader)
+	if raw == "" {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid control API version: %q", raw)
+	}
+	return version, nil
+}
+
+// Returns an error if a message of the given version of the control API is older than the
+// oldest this package still speaks
+func CheckAPIVersion(version int) error {
+	if version < MinAPIVersion {
+		return fmt.Errorf("control API version %d is no longer supported; the oldest supported version is %d", version, MinAPIVersion)
+	}
+	return nil
+}
+
+// Returns every version of the control API this package speaks, in ascending order
+func SupportedAPIVersions() []int {
+	versions := make([]int, 0, APIVersion-MinAPIVersion+1)
+	for v := MinAPIVersion; v <= APIVersion; v++ {
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func newVersionedMsg(subject string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(APIVersionHeader, strconv.Itoa(APIVersion))
+	return msg
+}
+
+// Returns an error if the envelope is of a version of the control API older than this client
+// still speaks
+func checkEnvelopeVersion(env *Envelope) error {
+	version := env.APIVersion
+	if version == 0 {
+		version = 1
+	}
+	err := CheckAPIVersion(version)
+	if err != nil {
+		return &RequestError{Code: ErrorCodeUnsupportedAPIVersion, Message: fmt.Sprintf("node answered in an unsupported version: %s", err)}
+	}
+	return nil
+}
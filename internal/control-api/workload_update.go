@@ -0,0 +1,144 @@
+package controlapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Strategies with which a workload is replaced by an update
+const (
+	// Stops the workload, then deploys its replacement
+	UpdateStrategyRecreate = "recreate"
+	// Deploys the replacement alongside the workload and, once it's healthy, moves the
+	// workload's trigger subscriptions to it and stops the workload
+	UpdateStrategyRolling = "rolling"
+	// Deploys the replacement alongside the workload, which keeps its trigger subscriptions
+	// until the replacement is promoted
+	UpdateStrategyBlueGreen = "blue_green"
+	// Deploys the replacement alongside the workload and routes a share of the workload's
+	// trigger messages to it, until it's promoted
+	UpdateStrategyCanary = "canary"
+)
+
+// Share of trigger messages routed to the replacement of a canary update, in percent, unless
+// the update request gives one
+const DefaultCanaryWeight = 5
+
+// Replaces a running workload with a new deployment of it, signed by the same issuer
+type WorkloadUpdateRequest struct {
+	// ID of the machine running the workload to replace
+	WorkloadId string `json:"workload_id"`
+	TargetNode string `json:"target_node"`
+	// One of recreate (the default), rolling, blue_green or canary. All but recreate are only
+	// supported for functions with at-most-once trigger subjects that don't scale to zero
+	Strategy string `json:"strategy,omitempty"`
+	// How long a rolling update waits for the replacement to pass its health check, if it has
+	// one; defaults to 60 seconds
+	HealthTimeoutMillis int `json:"health_timeout_ms,omitempty"`
+	// Share of the workload's trigger messages routed to the replacement of a canary update, in
+	// percent (1-99); defaults to DefaultCanaryWeight
+	CanaryWeight int `json:"canary_weight,omitempty"`
+	// The replacement, whose environment is encrypted for the target node
+	Request *DeployRequest `json:"request"`
+}
+
+// Promotes the replacement of a blue-green or canary update, moving the trigger subscriptions of
+// the workload it replaces to it and stopping that workload, or aborts the update. The share of
+// trigger messages routed to the replacement of a canary update may be changed instead
+type WorkloadPromoteRequest struct {
+	// ID of the machine running the replacement
+	WorkloadId string `json:"workload_id"`
+	TargetNode string `json:"target_node"`
+	// Stops the replacement instead, keeping the workload it would have replaced
+	Abort bool `json:"abort,omitempty"`
+	// Routes this share of trigger messages (1-99 percent) to the replacement of a canary update
+	// instead, neither promoting it nor aborting the update
+	CanaryWeight *int `json:"canary_weight,omitempty"`
+	// A JWT for the workload signed by its issuer, as in a stop request
+	WorkloadJwt string `json:"workload_jwt"`
+}
+
+type WorkloadUpdateResponse struct {
+	Strategy string `json:"strategy"`
+	Name     string `json:"name"`
+	// ID of the machine running the replacement
+	MachineId string `json:"machine_id"`
+	// ID of the machine the replacement replaces, which is stopped unless the update is
+	// pending or aborted
+	PreviousMachineId string `json:"previous_machine_id"`
+	// Whether the replacement awaits promotion
+	Pending bool `json:"pending,omitempty"`
+	Aborted bool `json:"aborted,omitempty"`
+
+	// Only present for pending canary updates
+	Canary *CanarySplit `json:"canary,omitempty"`
+}
+
+// How a workload's trigger messages are split between it and the replacement of a canary
+// update, along with the trigger executions of each since the update
+type CanarySplit struct {
+	// Share of trigger messages routed to the replacement, in percent
+	Weight int                  `json:"weight"`
+	Stable CanaryVersionMetrics `json:"stable"`
+	Canary CanaryVersionMetrics `json:"canary"`
+}
+
+type CanaryVersionMetrics struct {
+	MachineId      string `json:"machine_id"`
+	Hash           string `json:"hash,omitempty"`
+	Triggers       int64  `json:"triggers"`
+	FailedTriggers int64  `json:"failed_triggers"`
+	// Average time taken to execute a trigger message, including failed executions
+	AverageLatencyMillis float64 `json:"average_latency_ms"`
+}
+
+func (s *WorkloadUpdateRequest) HealthTimeout() time.Duration {
+	if s.HealthTimeoutMillis <= 0 {
+		return time.Minute
+	}
+	return time.Duration(s.HealthTimeoutMillis) * time.Millisecond
+}
+
+// Creates a promote request whose JWT is signed by the given signer, which must be the one that
+// signed the replacement's deploy request
+func NewSignedPromoteRequest(workloadId string, name string, targetNode string, abort bool, signer ClaimsSigner) (*WorkloadPromoteRequest, error) {
+	jwtText, err := signer.Sign(jwt.NewGenericClaims(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &WorkloadPromoteRequest{
+		WorkloadId:  workloadId,
+		TargetNode:  targetNode,
+		Abort:       abort,
+		WorkloadJwt: jwtText,
+	}, nil
+}
+
+// Replaces a running workload on the request's target node with the given strategy
+func (api *Client) UpdateWorkload(request *WorkloadUpdateRequest) (*WorkloadUpdateResponse, error) {
+	return api.workloadUpdateRequest(fmt.Sprintf("%s.UPDATEWORKLOAD.%s.%s", APIPrefix, api.namespace, request.TargetNode), request)
+}
+
+// Promotes (or aborts) the pending replacement of a blue-green or canary update, or changes the
+// share of trigger messages routed to the replacement of a canary update
+func (api *Client) PromoteWorkload(request *WorkloadPromoteRequest) (*WorkloadUpdateResponse, error) {
+	return api.workloadUpdateRequest(fmt.Sprintf("%s.PROMOTE.%s.%s", APIPrefix, api.namespace, request.TargetNode), request)
+}
+
+func (api *Client) workloadUpdateRequest(subject string, request interface{}) (*WorkloadUpdateResponse, error) {
+	bytes, err := api.performRequest(subject, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response WorkloadUpdateResponse
+	err = json.Unmarshal(bytes, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
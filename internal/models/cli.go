@@ -15,12 +15,45 @@ type UiOptions struct {
 	Port int
 }
 
+type DeployTokenOptions struct {
+	ClaimsIssuerFile string
+	Name             string
+	Digest           string
+	TTL              time.Duration
+}
+
+type SchemaOptions struct {
+	Name   string
+	Output string
+}
+
 type DevRunOptions struct {
 	Filename string
+	// Manifest of a generated project, from which the workload is deployed
+	ManifestFile string
 	// Stop a workload with the same name on a target
 	AutoStop bool
 }
 
+type DevOptions struct {
+	// Directory of the project, holding its manifest
+	Dir string
+	// Commands building the artifact, instead of those in the manifest
+	BuildCommands []string
+	TargetNode    string
+	PollInterval  time.Duration
+	// Sent to the workload's first trigger subject after each deploy, if given
+	Payload string
+	// Leave the last deployed workload running on exit
+	Keep bool
+}
+
+type NewProjectOptions struct {
+	WorkloadType string
+	Name         string
+	Dir          string
+}
+
 // Options configure the CLI
 type Options struct {
 	Servers string
@@ -63,18 +96,109 @@ type Options struct {
 }
 
 type RunOptions struct {
-	Argv              string
-	TargetNode        string
-	WorkloadUrl       *url.URL
-	Name              string
-	WorkloadType      string
-	Description       string
-	PublisherXkeyFile string
-	ClaimsIssuerFile  string
-	Env               map[string]string
-	Essential         bool
-	DevMode           bool
-	TriggerSubjects   []string
+	Argv string
+	// File whose contents are written to a native service workload's stdin when it starts
+	StdinFile string
+	// Whether a native workload is executed once per trigger rather than run as a service
+	ExecPerTrigger bool
+	TargetNode     string
+	// Whether TargetNode names a cluster, whose leader chooses the node
+	Cluster            bool
+	NodeTags           map[string]string
+	Replicas           int
+	WorkloadUrl        *url.URL
+	Name               string
+	WorkloadType       string
+	Description        string
+	PublisherXkeyFile  string
+	ClaimsIssuerFile   string
+	VaultTransitKey    string
+	DelegationFiles    []string
+	DeployTokenFile    string
+	Env                map[string]string
+	Secrets            map[string]string
+	SecretRefs         map[string]string
+	Essential          bool
+	MachineTemplate    string
+	VcpuCount          int
+	MemSizeMib         int
+	DevMode            bool
+	TriggerSubjects    []string
+	TriggerDelivery    string
+	TriggerQueueGroups map[string]string
+	IdleTimeout        time.Duration
+	AckTimeout         time.Duration
+	ReadyTimeout       time.Duration
+	MaxInFlight        int
+	TriggerQueueSize   int
+	TriggerOverflow    string
+	CronSchedules      []string
+	CronTimezone       string
+	Labels             map[string]string
+	Digest             string
+	// Whether the artifact is streamed into the workload's machine in chunks
+	StreamArtifact bool
+	// Key by which nodes recognize retries of the deploy request
+	IdempotencyKey string
+
+	HealthCheckExec     string
+	HealthCheckHTTP     string
+	HealthCheckNATS     string
+	HealthCheckInterval time.Duration
+
+	ReadinessExec     string
+	ReadinessHTTP     string
+	ReadinessNATS     string
+	ReadinessInterval time.Duration
+
+	PreStartHook string
+	PostStopHook string
+	HookTimeout  time.Duration
+
+	MemorySoftLimit       int
+	MemorySoftLimitSignal string
+
+	OutputLevel string
+	OutputRate  int
+	OutputBurst int
+
+	Egress    []string
+	EgressDNS bool
+
+	SandboxProfile string
+
+	StableIP bool
+	DNSName  string
+	// Size of the persistent volume attached to a service workload's machine; none when 0
+	VolumeSizeMib int
+
+	Webhook        bool
+	WebhookToken   string
+	WebhookSubject string
+
+	// Registers the function as a NATS service (micro); a name or version implies it
+	MicroService        bool
+	MicroServiceName    string
+	MicroServiceVersion string
+
+	NodeSelectors []string
+	AntiAffinity  []string
+
+	CredentialsPublish   []string
+	CredentialsSubscribe []string
+	CredentialsTTL       time.Duration
+
+	SignatureFile   string
+	CertificateFile string
+	AttestationFile string
+	BundleFile      string
+
+	UpdateWorkloadId    string
+	UpdateStrategy      string
+	UpdateHealthTimeout time.Duration
+	CanaryWeight        int
+
+	AwaitReady time.Duration
 }
 
 type StopOptions struct {
@@ -82,6 +206,10 @@ type StopOptions struct {
 	WorkloadName     string
 	WorkloadId       string
 	ClaimsIssuerFile string
+	VaultTransitKey  string
+	Selector         map[string]string
+	Abort            bool
+	CanaryWeight     int
 }
 
 type WatchOptions struct {
@@ -89,12 +217,56 @@ type WatchOptions struct {
 	WorkloadId   string
 	WorkloadName string
 	LogLevel     string
+	// Either "workload", "agent" or "host"; "*" for every source
+	Source string
+	// Either "pretty" or "json", one entry per line
+	Output string
+	// Keep showing log entries as they're persisted to the log stream
+	Follow bool
+
+	// Log stream to query, instead of watching logs as they're emitted
+	LogStream  string
+	LogDomains []string
+	Since      time.Duration
+	Until      time.Duration
+	Limit      int
+}
+
+type TopOptions struct {
+	Interval         time.Duration
+	ClaimsIssuerFile string
+	VaultTransitKey  string
+}
+
+type ApplyOptions struct {
+	ManifestFile string
+	TargetNode   string
+	// Whether TargetNode names a cluster, to which workloads are applied as deploy sets
+	Cluster             bool
+	PublisherXkeyFile   string
+	ClaimsIssuerFile    string
+	VaultTransitKey     string
+	UpdateHealthTimeout time.Duration
+}
+
+type OperatorOptions struct {
+	// URL of the Kubernetes API. The operator's pod's service account is used when empty
+	KubeAPIURL     string
+	KubeToken      string
+	KubeCAFile     string
+	WatchNamespace string
+	ResyncInterval time.Duration
+	// Signs the workloads the operator runs
+	ClaimsIssuerFile  string
+	VaultTransitKey   string
+	PublisherXkeyFile string
 }
 
 // Node configuration is used to configure the node process as well
 // as the virtual machines it produces
 type NodeOptions struct {
 	ConfigFilepath  string `json:"-"`
+	DevMode         bool   `json:"-"`
 	ForceDepInstall bool   `json:"-"`
 
 	OtelMetrics         bool   `json:"-"`
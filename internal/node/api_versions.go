@@ -0,0 +1,72 @@
+package nexnode
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Wraps the handler of a control API operation, rejecting requests in versions of the control
+// API older than the node still speaks
+func (api *ApiListener) negotiated(responseType string, handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		version, err := controlapi.RequestAPIVersion(m)
+		if err == nil {
+			err = controlapi.CheckAPIVersion(version)
+		}
+		if err != nil {
+			api.log.Warn("Rejected control request in unsupported API version", slog.String("subject", m.Subject), slog.Any("err", err))
+			respondFail(responseType, m, controlapi.ErrorCodeUnsupportedAPIVersion, err.Error())
+			return
+		}
+		handler(m)
+	}
+}
+
+// Returns what the node supports, as advertised in its PING and INFO responses. Operations which
+// the node's configuration leaves unavailable, e.g. self updates or cluster deploys on a node
+// outside a cluster, aren't included
+func (api *ApiListener) capabilities() *controlapi.NodeCapabilities {
+	operations := []string{
+		controlapi.OperationAudit,
+		controlapi.OperationDeploy,
+		controlapi.OperationDescribe,
+		controlapi.OperationInfo,
+		controlapi.OperationMemory,
+		controlapi.OperationPause,
+		controlapi.OperationPing,
+		controlapi.OperationPreflight,
+		controlapi.OperationPromote,
+		controlapi.OperationReserve,
+		controlapi.OperationRoles,
+		controlapi.OperationStandby,
+		controlapi.OperationStop,
+		controlapi.OperationStopAll,
+		controlapi.OperationSubjects,
+		controlapi.OperationTimeline,
+		controlapi.OperationUpdateWorkload,
+	}
+	if api.config.Cluster != nil {
+		operations = append(operations, controlapi.OperationCluster, controlapi.OperationClusterDeploy, controlapi.OperationDeploySet)
+	}
+	if api.config.SelfUpdate != nil {
+		operations = append(operations, controlapi.OperationUpdate)
+	}
+	slices.Sort(operations)
+
+	workloadTypes := make([]controlapi.WorkloadTypeCapability, 0, len(api.config.WorkloadTypes))
+	for _, workloadType := range api.config.WorkloadTypes {
+		workloadTypes = append(workloadTypes, controlapi.WorkloadTypeCapability{
+			Type:     workloadType,
+			Versions: []int{controlapi.WorkloadTypeVersion},
+		})
+	}
+
+	return &controlapi.NodeCapabilities{
+		APIVersions:   controlapi.SupportedAPIVersions(),
+		Operations:    operations,
+		WorkloadTypes: workloadTypes,
+	}
+}
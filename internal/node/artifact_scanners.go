@@ -0,0 +1,280 @@
+package nexnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/tetratelabs/wazero"
+)
+
+const (
+	ArtifactScannerTypeCommand     = "command"
+	ArtifactScannerTypeWasmImports = "wasm_imports"
+
+	defaultArtifactScanTimeoutSeconds = 60
+
+	// Longest scanner output included in a scan result
+	maxScanMessageLength = 512
+)
+
+// Inspects a workload artifact before it is deployed. Scan returns an error when the artifact
+// couldn't be scanned, and a result which didn't pass when the scanner rejects the artifact
+type artifactScanner interface {
+	name() string
+	appliesTo(workloadType string) bool
+	scan(ctx context.Context, artifact []byte) (*agentapi.ArtifactScanResult, error)
+}
+
+func (c *ArtifactScannerConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("artifact scanner name is required")
+	}
+	if c.TimeoutSeconds < 0 {
+		return fmt.Errorf("artifact scanner %s timeout must be >= 0", c.Name)
+	}
+
+	switch c.Type {
+	case ArtifactScannerTypeCommand:
+		if len(c.Command) == 0 {
+			return fmt.Errorf("artifact scanner %s requires a command", c.Name)
+		}
+	case ArtifactScannerTypeWasmImports:
+		if len(c.AllowedImports) == 0 && len(c.DeniedImports) == 0 {
+			return fmt.Errorf("artifact scanner %s requires allowed or denied imports", c.Name)
+		}
+		for _, pattern := range append(slices.Clone(c.AllowedImports), c.DeniedImports...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("artifact scanner %s has an invalid import pattern %s: %s", c.Name, pattern, err)
+			}
+		}
+	default:
+		return fmt.Errorf("artifact scanner %s has an unsupported type: %s", c.Name, c.Type)
+	}
+
+	return nil
+}
+
+func (c *ArtifactScannerConfig) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return defaultArtifactScanTimeoutSeconds * time.Second
+}
+
+// Builds the scanners declared in the node's configuration, in the order they're declared
+func newArtifactScanners(configs []ArtifactScannerConfig) []artifactScanner {
+	scanners := make([]artifactScanner, 0, len(configs))
+	for i := range configs {
+		config := &configs[i]
+		switch config.Type {
+		case ArtifactScannerTypeCommand:
+			scanners = append(scanners, &commandScanner{config: config})
+		case ArtifactScannerTypeWasmImports:
+			scanners = append(scanners, &wasmImportsScanner{config: config})
+		}
+	}
+	return scanners
+}
+
+// Runs each of the node's scanners which applies to the workload against its cached artifact,
+// returning the results of those which ran. An error is returned as soon as a scanner rejects the
+// artifact or fails to scan it
+func (m *MachineManager) scanWorkload(request *controlapi.DeployRequest) ([]agentapi.ArtifactScanResult, error) {
+	scanners := newArtifactScanners(m.config.ArtifactScanners)
+	if len(scanners) == 0 {
+		return nil, nil
+	}
+
+	jsInternal, err := m.ncInternal.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := jsInternal.ObjectStore(agentapi.WorkloadCacheBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, err := cache.GetBytes(request.DecodedClaims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached workload: %s", err)
+	}
+
+	results := make([]agentapi.ArtifactScanResult, 0, len(scanners))
+	for _, scanner := range scanners {
+		if !scanner.appliesTo(*request.WorkloadType) {
+			continue
+		}
+
+		result, err := scanner.scan(m.ctx, artifact)
+		if err != nil {
+			m.log.Error("Failed to scan workload artifact", slog.String("scanner", scanner.name()), slog.Any("err", err))
+			return results, fmt.Errorf("artifact scanner %s failed: %s", scanner.name(), err)
+		}
+
+		results = append(results, *result)
+		if !result.Passed {
+			m.log.Warn("Workload artifact rejected by scanner",
+				slog.String("scanner", scanner.name()),
+				slog.String("workload", request.DecodedClaims.Subject),
+				slog.String("message", result.Message),
+			)
+			return results, fmt.Errorf("workload artifact rejected by scanner %s: %s", scanner.name(), result.Message)
+		}
+	}
+
+	return results, nil
+}
+
+func appliesToWorkloadType(types []string, workloadType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(types, func(t string) bool {
+		return strings.EqualFold(t, workloadType)
+	})
+}
+
+// Runs an external command, e.g. clamscan, against a copy of the artifact
+type commandScanner struct {
+	config *ArtifactScannerConfig
+}
+
+func (s *commandScanner) name() string {
+	return s.config.Name
+}
+
+func (s *commandScanner) appliesTo(workloadType string) bool {
+	return appliesToWorkloadType(s.config.WorkloadTypes, workloadType)
+}
+
+func (s *commandScanner) scan(ctx context.Context, artifact []byte) (*agentapi.ArtifactScanResult, error) {
+	f, err := os.CreateTemp("", "nex-scan-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(artifact)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.timeout())
+	defer cancel()
+
+	args := append(slices.Clone(s.config.Command[1:]), f.Name())
+	output, err := exec.CommandContext(ctx, s.config.Command[0], args...).CombinedOutput()
+
+	result := &agentapi.ArtifactScanResult{
+		Scanner: s.config.Name,
+		Message: scanMessage(strings.ReplaceAll(string(output), f.Name(), "artifact")),
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && (ctx.Err() != nil || !errors.As(err, &exitErr)) {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", s.config.timeout())
+		}
+		return nil, err
+	}
+
+	result.Passed = err == nil
+	return result, nil
+}
+
+// Rejects WebAssembly modules whose imported functions aren't permitted by the scanner's
+// allowed and denied imports
+type wasmImportsScanner struct {
+	config *ArtifactScannerConfig
+}
+
+func (s *wasmImportsScanner) name() string {
+	return s.config.Name
+}
+
+func (s *wasmImportsScanner) appliesTo(workloadType string) bool {
+	types := s.config.WorkloadTypes
+	if len(types) == 0 {
+		types = []string{"wasm"}
+	}
+	return appliesToWorkloadType(types, workloadType)
+}
+
+func (s *wasmImportsScanner) scan(ctx context.Context, artifact []byte) (*agentapi.ArtifactScanResult, error) {
+	imports, err := wasmImports(ctx, artifact)
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make([]string, 0)
+	for _, imp := range imports {
+		if !s.permits(imp) {
+			rejected = append(rejected, imp)
+		}
+	}
+
+	result := &agentapi.ArtifactScanResult{
+		Scanner: s.config.Name,
+		Passed:  len(rejected) == 0,
+	}
+	if !result.Passed {
+		result.Message = scanMessage(fmt.Sprintf("disallowed imports: %s", strings.Join(rejected, ", ")))
+	}
+	return result, nil
+}
+
+func (s *wasmImportsScanner) permits(imp string) bool {
+	if matchesImport(s.config.DeniedImports, imp) {
+		return false
+	}
+	return len(s.config.AllowedImports) == 0 || matchesImport(s.config.AllowedImports, imp)
+}
+
+func matchesImport(patterns []string, imp string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, imp); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the functions imported by a WebAssembly module as {module}.{name}. The module is
+// compiled by wazero's interpreter, which decodes and validates it without generating code
+func wasmImports(ctx context.Context, artifact []byte) ([]string, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	module, err := runtime.CompileModule(ctx, artifact)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wasm module: %s", err)
+	}
+	defer module.Close(ctx)
+
+	imports := make([]string, 0)
+	for _, fn := range module.ImportedFunctions() {
+		moduleName, name, _ := fn.Import()
+		imports = append(imports, fmt.Sprintf("%s.%s", moduleName, name))
+	}
+	return imports, nil
+}
+
+func scanMessage(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxScanMessageLength {
+		output = output[:maxScanMessageLength] + "..."
+	}
+	return output
+}
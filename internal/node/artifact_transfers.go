@@ -0,0 +1,153 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// The workload artifacts being read by agents, by machine ID. An agent reads only the artifact of
+// the workload deployed to its machine, a chunk at a time, so the cached object is kept open
+// between its requests. A transfer ends when the artifact has been read, or its machine stops
+type artifactTransfers struct {
+	mutex     sync.Mutex
+	transfers map[string]*artifactTransfer
+}
+
+type artifactTransfer struct {
+	obj    nats.ObjectResult
+	size   uint64
+	offset int64
+}
+
+func newArtifactTransfers() *artifactTransfers {
+	return &artifactTransfers{
+		transfers: make(map[string]*artifactTransfer),
+	}
+}
+
+// Reads the chunk of the named artifact at the given offset for the given machine, returning it
+// along with the artifact's size. Reading from the start opens the artifact, restarting any
+// transfer already under way; otherwise the offset must be the one the transfer has reached
+func (t *artifactTransfers) read(vmID string, name string, offset int64, open func(name string) (nats.ObjectResult, error)) ([]byte, uint64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	transfer := t.transfers[vmID]
+	if offset == 0 {
+		if transfer != nil {
+			_ = transfer.obj.Close()
+			delete(t.transfers, vmID)
+		}
+
+		obj, err := open(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := obj.Info()
+		if err != nil {
+			_ = obj.Close()
+			return nil, 0, err
+		}
+
+		transfer = &artifactTransfer{obj: obj, size: info.Size}
+		t.transfers[vmID] = transfer
+	}
+	if transfer == nil || offset != transfer.offset {
+		return nil, 0, fmt.Errorf("unexpected artifact offset %d", offset)
+	}
+
+	chunk := make([]byte, agentapi.ArtifactChunkSize)
+	n, err := io.ReadFull(transfer.obj, chunk)
+	transfer.offset += int64(n)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.finish(vmID)
+		err = nil
+	} else if err != nil {
+		t.finish(vmID)
+	}
+
+	return chunk[:n], transfer.size, err
+}
+
+// Ends the transfer to the given machine, if any
+func (t *artifactTransfers) cancel(vmID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.finish(vmID)
+}
+
+// Must be called with the mutex held
+func (t *artifactTransfers) finish(vmID string) {
+	transfer, ok := t.transfers[vmID]
+	if !ok {
+		return
+	}
+
+	_ = transfer.obj.Close()
+	delete(t.transfers, vmID)
+}
+
+// Serves a chunk of the artifact of the workload deployed to the requesting machine
+func (m *MachineManager) handleArtifactRequest(msg *nats.Msg) {
+	// agentint.{vmID}.artifact
+	vmID := strings.Split(msg.Subject, ".")[1]
+
+	reply := nats.NewMsg(msg.Reply)
+	respond := func() {
+		err := msg.RespondMsg(reply)
+		if err != nil {
+			m.log.Warn("Failed to respond to artifact request", slog.String("vmid", vmID), slog.Any("err", err))
+		}
+	}
+
+	var request agentapi.ArtifactChunkRequest
+	err := json.Unmarshal(msg.Data, &request)
+	if err != nil {
+		reply.Header.Set(agentapi.ArtifactErrorHeader, fmt.Sprintf("invalid artifact request: %s", err))
+		respond()
+		return
+	}
+
+	vm, ok := m.lookupMachine(vmID)
+	if !ok || vm.deployRequest == nil || vm.deployRequest.WorkloadName == nil {
+		reply.Header.Set(agentapi.ArtifactErrorHeader, "no workload is deployed to the vm")
+		respond()
+		return
+	}
+
+	chunk, size, err := m.artifacts.read(vmID, *vm.deployRequest.WorkloadName, request.Offset, m.openCachedArtifact)
+	if err != nil {
+		m.log.Warn("Failed to read workload artifact for agent", slog.String("vmid", vmID), slog.Any("err", err))
+		reply.Header.Set(agentapi.ArtifactErrorHeader, err.Error())
+		respond()
+		return
+	}
+
+	reply.Header.Set(agentapi.ArtifactSizeHeader, strconv.FormatUint(size, 10))
+	reply.Data = chunk
+	respond()
+}
+
+func (m *MachineManager) openCachedArtifact(name string) (nats.ObjectResult, error) {
+	js, err := m.ncInternal.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := js.ObjectStore(agentapi.WorkloadCacheBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Get(name)
+}
@@ -0,0 +1,226 @@
+package nexnode
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	defaultAssetReaperIntervalSeconds  = 3600
+	defaultAssetReaperRetentionSeconds = 7 * 24 * 3600
+
+	// Prefix of the streams backing host services key/value buckets (hs_{namespace}_{workload}_kv)
+	keyValueStreamPrefix = "KV_hs_"
+)
+
+func (r *AssetReaper) interval() time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return defaultAssetReaperIntervalSeconds * time.Second
+}
+
+func (r *AssetReaper) retention() time.Duration {
+	if r.RetentionSeconds > 0 {
+		return time.Duration(r.RetentionSeconds) * time.Second
+	}
+	return defaultAssetReaperRetentionSeconds * time.Second
+}
+
+// Periodically finds (and, if enabled, deletes) stale namespace assets until the machine
+// manager is stopped
+func (m *MachineManager) reapStaleAssets() {
+	ticker := time.NewTicker(m.config.AssetReaper.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			stale := m.findStaleAssets()
+			if len(stale) == 0 {
+				continue
+			}
+
+			m.publishStaleAssets(stale)
+		}
+	}
+}
+
+// Returns the assets belonging to namespaces without workloads on the node which have been
+// inactive for longer than the retention period, deleting them if the reaper is configured to
+func (m *MachineManager) findStaleAssets() []controlapi.StaleAsset {
+	active := m.activeNamespaces()
+	cutoff := time.Now().UTC().Add(-m.config.AssetReaper.retention())
+	stale := make([]controlapi.StaleAsset, 0)
+
+	js, err := m.nc.JetStream()
+	if err != nil {
+		m.log.Warn("Failed to find stale key/value buckets", slog.Any("err", err))
+	} else {
+		stale = append(stale, m.staleKeyValueBuckets(js, active, cutoff)...)
+	}
+
+	jsInternal, err := m.ncInternal.JetStream()
+	if err != nil {
+		m.log.Warn("Failed to find stale trigger consumers", slog.Any("err", err))
+	} else {
+		stale = append(stale, m.staleTriggerConsumers(jsInternal, active, cutoff)...)
+	}
+
+	return stale
+}
+
+// Host services key/value buckets are stale when no client is watching them and they haven't
+// been written to within the retention period (or since they were created, if empty)
+func (m *MachineManager) staleKeyValueBuckets(js nats.JetStreamContext, active []string, cutoff time.Time) []controlapi.StaleAsset {
+	stale := make([]controlapi.StaleAsset, 0)
+
+	for stream := range js.StreamNames() {
+		if !strings.HasPrefix(stream, keyValueStreamPrefix) || ownedByNamespace(strings.TrimPrefix(stream, keyValueStreamPrefix), active) {
+			continue
+		}
+
+		info, err := js.StreamInfo(stream)
+		if err != nil {
+			m.log.Warn("Failed to inspect key/value bucket", slog.String("stream", stream), slog.Any("err", err))
+			continue
+		}
+
+		lastActivity := info.Created
+		if info.State.LastTime.After(lastActivity) {
+			lastActivity = info.State.LastTime
+		}
+		if info.State.Consumers > 0 || lastActivity.After(cutoff) {
+			continue
+		}
+
+		asset := controlapi.StaleAsset{
+			Kind:         controlapi.StaleAssetKindKeyValue,
+			Name:         strings.TrimPrefix(stream, "KV_"),
+			LastActivity: lastActivity,
+		}
+
+		if m.config.AssetReaper.Delete {
+			err = js.DeleteKeyValue(asset.Name)
+			if err != nil {
+				m.log.Warn("Failed to delete stale key/value bucket", slog.String("bucket", asset.Name), slog.Any("err", err))
+			} else {
+				asset.Deleted = true
+			}
+		}
+
+		stale = append(stale, asset)
+	}
+
+	return stale
+}
+
+// Durable trigger consumers ({namespace}_{workload}) are stale when they haven't delivered a
+// trigger message within the retention period (or since they were created)
+func (m *MachineManager) staleTriggerConsumers(js nats.JetStreamContext, active []string, cutoff time.Time) []controlapi.StaleAsset {
+	stale := make([]controlapi.StaleAsset, 0)
+
+	if _, err := js.StreamInfo(triggerStreamName); err != nil {
+		// the trigger stream is only created once a workload asks for at-least-once delivery
+		return stale
+	}
+
+	for info := range js.ConsumersInfo(triggerStreamName) {
+		if ownedByNamespace(info.Name, active) {
+			continue
+		}
+
+		lastActivity := info.Created
+		if info.Delivered.Last != nil && info.Delivered.Last.After(lastActivity) {
+			lastActivity = *info.Delivered.Last
+		}
+		if lastActivity.After(cutoff) {
+			continue
+		}
+
+		asset := controlapi.StaleAsset{
+			Kind:         controlapi.StaleAssetKindTriggerConsumer,
+			Name:         info.Name,
+			LastActivity: lastActivity,
+		}
+
+		if m.config.AssetReaper.Delete {
+			err := js.DeleteConsumer(triggerStreamName, info.Name)
+			if err != nil {
+				m.log.Warn("Failed to delete stale trigger consumer", slog.String("consumer", info.Name), slog.Any("err", err))
+			} else {
+				asset.Deleted = true
+			}
+		}
+
+		stale = append(stale, asset)
+	}
+
+	return stale
+}
+
+// Returns the namespaces with workloads deployed to the node, including functions which have
+// scaled to zero
+func (m *MachineManager) activeNamespaces() []string {
+	seen := make(map[string]struct{})
+	for _, vm := range m.runningMachines() {
+		if vm.namespace != "" {
+			seen[vm.namespace] = struct{}{}
+		}
+	}
+	for _, fn := range m.idleFunctionsSnapshot() {
+		seen[fn.namespace] = struct{}{}
+	}
+
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// Reports whether an asset named {namespace}_... may belong to one of the given namespaces. As
+// namespaces may themselves contain underscores, this errs on the side of keeping assets
+func ownedByNamespace(name string, namespaces []string) bool {
+	for _, namespace := range namespaces {
+		if strings.HasPrefix(name, namespace+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MachineManager) publishStaleAssets(stale []controlapi.StaleAsset) {
+	for _, asset := range stale {
+		m.log.Info("Found stale namespace asset",
+			slog.String("kind", asset.Kind),
+			slog.String("name", asset.Name),
+			slog.Time("last_activity", asset.LastActivity),
+			slog.Bool("deleted", asset.Deleted),
+		)
+	}
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.StaleAssetsEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(controlapi.StaleAssetsEvent{
+		Id:     m.publicKey,
+		Assets: stale,
+	})
+
+	err := PublishCloudEvent(m.nc, controlapi.SystemNamespace, cloudevent, m.log)
+	if err != nil {
+		m.log.Warn("Failed to publish stale assets event", slog.Any("err", err))
+	}
+}
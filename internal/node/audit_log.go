@@ -0,0 +1,394 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+const (
+	defaultAuditMaxAgeSeconds = 90 * 24 * 3600
+
+	// Number of the most recent entries kept in memory to answer audit queries
+	auditRecentEntries     = 1000
+	defaultAuditQueryLimit = 100
+
+	// Longest to wait for the response of an audited handler to be relayed to the requester
+	auditResponseTimeout = 5 * time.Second
+)
+
+func (c *AuditLog) validate() []error {
+	errs := make([]error, 0)
+
+	if c.Stream != "" && strings.ContainsAny(c.Stream, ".*> ") {
+		errs = append(errs, fmt.Errorf("invalid audit log stream name: %s", c.Stream))
+	}
+
+	if c.MaxAgeSeconds < 0 || c.MaxBytes < 0 {
+		errs = append(errs, errors.New("audit log max age and max bytes must be >= 0"))
+	}
+
+	return errs
+}
+
+func (c *AuditLog) maxAge() time.Duration {
+	if c.MaxAgeSeconds > 0 {
+		return time.Duration(c.MaxAgeSeconds) * time.Second
+	}
+	return defaultAuditMaxAgeSeconds * time.Second
+}
+
+// The node's audit log. The most recent entries are always kept in memory, so that they can be
+// queried; entries are also persisted to the configured stream and file, if any
+type auditLog struct {
+	config *AuditLog
+	nc     *nats.Conn
+	log    *slog.Logger
+
+	mutex    sync.Mutex
+	recent   []controlapi.AuditEntry
+	file     *os.File
+	fileSize int64
+}
+
+func newAuditLog(config *AuditLog, nc *nats.Conn, log *slog.Logger) (*auditLog, error) {
+	a := &auditLog{
+		config: config,
+		nc:     nc,
+		log:    log,
+		recent: make([]controlapi.AuditEntry, 0),
+	}
+	if config == nil {
+		return a, nil
+	}
+
+	if config.Stream != "" {
+		err := a.ensureStream()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit log stream: %s", err)
+		}
+	}
+
+	if config.File != "" {
+		err := a.openFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log file: %s", err)
+		}
+	}
+
+	return a, nil
+}
+
+// Creates the audit log stream if it doesn't exist. An existing stream is left as it is, as it's
+// likely shared with other nodes
+func (a *auditLog) ensureStream() error {
+	js, err := a.nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.StreamInfo(a.config.Stream)
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+
+	maxBytes := a.config.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = -1
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:        a.config.Stream,
+		Description: "Audit log of nex control API requests",
+		Subjects:    []string{fmt.Sprintf("%s.audit.>", controlapi.APIPrefix)},
+		MaxAge:      a.config.maxAge(),
+		MaxBytes:    maxBytes,
+		Storage:     nats.FileStorage,
+	})
+	return err
+}
+
+// Must be called with the mutex held, unless the audit log is being created
+func (a *auditLog) openFile() error {
+	f, err := os.OpenFile(a.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	a.file = f
+	a.fileSize = info.Size()
+	return nil
+}
+
+// Must be called with the mutex held
+func (a *auditLog) rotateFile() error {
+	_ = a.file.Close()
+	a.file = nil
+
+	err := os.Rename(a.config.File, a.config.File+".1")
+	if err != nil {
+		return err
+	}
+	return a.openFile()
+}
+
+func (a *auditLog) record(entry controlapi.AuditEntry) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		a.log.Error("Failed to marshal audit log entry", slog.Any("err", err))
+		return
+	}
+
+	a.mutex.Lock()
+	a.recent = append(a.recent, entry)
+	if len(a.recent) > auditRecentEntries {
+		a.recent = a.recent[len(a.recent)-auditRecentEntries:]
+	}
+	if a.file != nil {
+		err = a.appendFile(append(raw, '\n'))
+		if err != nil {
+			a.log.Error("Failed to write audit log entry to file", slog.String("file", a.config.File), slog.Any("err", err))
+		}
+	}
+	a.mutex.Unlock()
+
+	if a.config != nil && a.config.Stream != "" {
+		js, err := a.nc.JetStream()
+		if err == nil {
+			_, err = js.Publish(fmt.Sprintf("%s.audit.%s.%s", controlapi.APIPrefix, entry.Namespace, entry.NodeId), raw)
+		}
+		if err != nil {
+			a.log.Error("Failed to publish audit log entry", slog.String("stream", a.config.Stream), slog.Any("err", err))
+		}
+	}
+}
+
+// Must be called with the mutex held
+func (a *auditLog) appendFile(line []byte) error {
+	if a.config.MaxBytes > 0 && a.fileSize > 0 && a.fileSize+int64(len(line)) > a.config.MaxBytes {
+		err := a.rotateFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.fileSize += int64(n)
+	return err
+}
+
+// Returns the namespace's most recent entries matching the request, oldest first
+func (a *auditLog) query(namespace string, request *controlapi.AuditRequest) []controlapi.AuditEntry {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultAuditQueryLimit
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entries := make([]controlapi.AuditEntry, 0)
+	for i := len(a.recent) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := a.recent[i]
+		if entry.Namespace != namespace {
+			continue
+		}
+		if request.Since != nil && entry.Timestamp.Before(*request.Since) {
+			break
+		}
+		if request.Operation != "" && !strings.EqualFold(entry.Operation, request.Operation) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+func (a *auditLog) close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.file != nil {
+		_ = a.file.Close()
+		a.file = nil
+	}
+}
+
+// Wraps the handler of a control API operation, recording each request in the audit log along
+// with the response the handler gives. The response is captured on an inbox of the node's own
+// and relayed to the requester
+func (api *ApiListener) audited(handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		inbox := nats.NewInbox()
+		sub, err := api.mgr.nc.SubscribeSync(inbox)
+		if err != nil {
+			api.log.Warn("Failed to capture control response for audit log", slog.Any("err", err))
+			handler(m)
+			api.audit.record(api.auditEntry(m, nil))
+			return
+		}
+		defer func() {
+			_ = sub.Unsubscribe()
+		}()
+
+		captured := *m
+		captured.Reply = inbox
+		handler(&captured)
+
+		res, err := sub.NextMsg(auditResponseTimeout)
+		if err != nil {
+			res = nil
+		} else if m.Reply != "" {
+			_ = m.Respond(res.Data)
+		}
+
+		api.audit.record(api.auditEntry(m, res))
+	}
+}
+
+// Describes a control request and its response, if any, as an audit log entry
+func (api *ApiListener) auditEntry(m *nats.Msg, res *nats.Msg) controlapi.AuditEntry {
+	entry := controlapi.AuditEntry{
+		Timestamp: time.Now().UTC(),
+		NodeId:    api.nodeId,
+	}
+
+	// $NEX.{op}.{namespace}.{node}, or $NEX.{op}.{node} for operations on the node itself, which
+	// are recorded in the system namespace
+	tokens := strings.Split(m.Subject, ".")
+	if len(tokens) >= 2 {
+		entry.Operation = tokens[1]
+	}
+	entry.Namespace = controlapi.SystemNamespace
+	if len(tokens) >= 4 {
+		entry.Namespace = tokens[2]
+	}
+
+	if m.Header != nil {
+		var info server.ClientInfo
+		if raw := m.Header.Get(server.ClientInfoHdr); raw != "" && json.Unmarshal([]byte(raw), &info) == nil {
+			entry.Requester = info.Account
+			if info.User != "" {
+				entry.Requester = fmt.Sprintf("%s/%s", info.Account, info.User)
+			}
+		}
+	}
+
+	// the fields identifying the issuer and target workload across the various requests
+	var request struct {
+		WorkloadId   string `json:"workload_id"`
+		WorkloadName string `json:"workload_name"`
+		WorkloadJwt  string `json:"workload_jwt"`
+		IssuerJwt    string `json:"issuer_jwt"`
+	}
+	_ = json.Unmarshal(m.Data, &request)
+
+	entry.WorkloadId = request.WorkloadId
+	entry.WorkloadName = request.WorkloadName
+	for _, token := range []string{request.WorkloadJwt, request.IssuerJwt} {
+		if token == "" {
+			continue
+		}
+		if claims, err := jwt.DecodeGeneric(token); err == nil {
+			entry.Issuer = claims.Issuer
+			if token == request.WorkloadJwt && entry.WorkloadName == "" {
+				entry.WorkloadName = claims.Subject
+			}
+		}
+	}
+
+	if res == nil {
+		entry.Error = "no response"
+		return entry
+	}
+
+	var envelope struct {
+		Error interface{} `json:"error"`
+		Data  struct {
+			MachineId string `json:"machine_id"`
+			Name      string `json:"name"`
+			Issuer    string `json:"issuer"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(res.Data, &envelope)
+	if err != nil {
+		// responses whose data isn't an object can still be told apart by their error
+		var errOnly struct {
+			Error interface{} `json:"error"`
+		}
+		_ = json.Unmarshal(res.Data, &errOnly)
+		envelope.Error = errOnly.Error
+	}
+
+	if envelope.Error != nil {
+		entry.Error = fmt.Sprint(envelope.Error)
+		return entry
+	}
+
+	entry.Success = true
+	if envelope.Data.MachineId != "" {
+		entry.WorkloadId = envelope.Data.MachineId
+	}
+	if envelope.Data.Name != "" {
+		entry.WorkloadName = envelope.Data.Name
+	}
+	if envelope.Data.Issuer != "" {
+		entry.Issuer = envelope.Data.Issuer
+	}
+
+	return entry
+}
+
+// Responds with the namespace's recent entries in the node's audit log
+func (api *ApiListener) handleAudit(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for audit request", slog.Any("err", err))
+		respondFail(controlapi.AuditResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for audit request")
+		return
+	}
+
+	var request controlapi.AuditRequest
+	if len(m.Data) > 0 {
+		err = schema.Unmarshal(m.Data, &request)
+		if err != nil {
+			api.log.Error("Failed to deserialize audit request", slog.Any("err", err))
+			respondInvalid(controlapi.AuditResponseType, m, fmt.Sprintf("Unable to deserialize audit request: %s", err), err)
+			return
+		}
+	}
+
+	res := controlapi.NewEnvelope(controlapi.AuditResponseType, controlapi.AuditResponse{
+		NodeId:    api.nodeId,
+		Namespace: namespace,
+		Entries:   api.audit.query(namespace, &request),
+	}, nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal audit response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
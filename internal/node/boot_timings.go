@@ -0,0 +1,128 @@
+package nexnode
+
+import (
+	"log/slog"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Number of the most recently started machines whose boot timings are summarized in node info
+const summarizedBootCount = 20
+
+// Tracks the phases of starting a machine, which are completed by its handshake
+type machineBoot struct {
+	started time.Time
+	// when the firecracker instance (or agent process) was started
+	instanceStarted time.Time
+	timings         controlapi.MachineBootTimings
+	complete        bool
+}
+
+func millisBetween(from time.Time, to time.Time) int64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from).Milliseconds()
+}
+
+// Completes the boot timings of a machine upon receiving its agent's handshake, recording them
+// as metrics and keeping them to be summarized
+func (m *MachineManager) completeMachineBoot(vm *runningFirecracker, req *agentapi.HandshakeRequest, received time.Time) {
+	boot := &vm.boot
+	if boot.complete || boot.started.IsZero() {
+		return
+	}
+
+	// an agent which doesn't say when it requested the handshake is taken to have done so as it
+	// started
+	sentAt := req.StartTime
+	if req.SentAt != nil {
+		sentAt = *req.SentAt
+	}
+
+	if m.config.NoSandbox {
+		// unsandboxed agents have no kernel to boot
+		boot.timings.AgentStartMillis = millisBetween(boot.instanceStarted, sentAt)
+	} else {
+		boot.timings.KernelBootMillis = millisBetween(boot.instanceStarted, req.StartTime)
+		boot.timings.AgentStartMillis = millisBetween(req.StartTime, sentAt)
+	}
+	boot.timings.HandshakeMillis = millisBetween(sentAt, received)
+	boot.timings.TotalMillis = millisBetween(boot.started, received)
+	boot.complete = true
+
+	phases := map[string]int64{
+		"rootfs_copy":       boot.timings.RootfsCopyMillis,
+		"cni_setup":         boot.timings.CNISetupMillis,
+		"firecracker_start": boot.timings.FirecrackerStartMillis,
+		"kernel_boot":       boot.timings.KernelBootMillis,
+		"agent_start":       boot.timings.AgentStartMillis,
+		"handshake":         boot.timings.HandshakeMillis,
+		"total":             boot.timings.TotalMillis,
+	}
+	for phase, millis := range phases {
+		m.t.machineBootLatency.Record(m.ctx, millis,
+			metric.WithAttributes(attribute.String("phase", phase)),
+			metric.WithAttributes(attribute.String("machine_template", machineTemplateName(vm.template))),
+		)
+	}
+
+	m.log.Debug("Machine booted",
+		slog.String("vmid", vm.vmmID),
+		slog.Int64("rootfs_copy_ms", boot.timings.RootfsCopyMillis),
+		slog.Int64("cni_setup_ms", boot.timings.CNISetupMillis),
+		slog.Int64("firecracker_start_ms", boot.timings.FirecrackerStartMillis),
+		slog.Int64("kernel_boot_ms", boot.timings.KernelBootMillis),
+		slog.Int64("agent_start_ms", boot.timings.AgentStartMillis),
+		slog.Int64("handshake_ms", boot.timings.HandshakeMillis),
+		slog.Int64("total_ms", boot.timings.TotalMillis),
+	)
+
+	m.bootTimingsMutex.Lock()
+	defer m.bootTimingsMutex.Unlock()
+
+	m.bootTimings = append(m.bootTimings, boot.timings)
+	if len(m.bootTimings) > summarizedBootCount {
+		m.bootTimings = m.bootTimings[len(m.bootTimings)-summarizedBootCount:]
+	}
+}
+
+// Summarizes the boot timings of the node's most recently started machines, if it has started any
+func (m *MachineManager) summarizeMachineBoots() *controlapi.MachineBootSummary {
+	m.bootTimingsMutex.Lock()
+	defer m.bootTimingsMutex.Unlock()
+
+	count := int64(len(m.bootTimings))
+	if count == 0 {
+		return nil
+	}
+
+	summary := &controlapi.MachineBootSummary{Machines: len(m.bootTimings)}
+	for _, t := range m.bootTimings {
+		summary.Average.RootfsCopyMillis += t.RootfsCopyMillis
+		summary.Average.CNISetupMillis += t.CNISetupMillis
+		summary.Average.FirecrackerStartMillis += t.FirecrackerStartMillis
+		summary.Average.KernelBootMillis += t.KernelBootMillis
+		summary.Average.AgentStartMillis += t.AgentStartMillis
+		summary.Average.HandshakeMillis += t.HandshakeMillis
+		summary.Average.TotalMillis += t.TotalMillis
+
+		if t.TotalMillis >= summary.Slowest.TotalMillis {
+			summary.Slowest = t
+		}
+	}
+
+	summary.Average.RootfsCopyMillis /= count
+	summary.Average.CNISetupMillis /= count
+	summary.Average.FirecrackerStartMillis /= count
+	summary.Average.KernelBootMillis /= count
+	summary.Average.AgentStartMillis /= count
+	summary.Average.HandshakeMillis /= count
+	summary.Average.TotalMillis /= count
+
+	return summary
+}
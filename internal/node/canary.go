@@ -0,0 +1,160 @@
+package nexnode
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	canaryVersionStable = "stable"
+	canaryVersionCanary = "canary"
+)
+
+// Splits the trigger messages of a workload between it and the replacement of a canary update.
+// The workload keeps its trigger subscriptions, and its handlers route a weighted share of the
+// messages they receive to the replacement's handlers
+type canarySplit struct {
+	stable *runningFirecracker
+	canary *runningFirecracker
+
+	mutex  sync.Mutex
+	weight int
+	// handlers of the replacement, keyed by its trigger subjects
+	handlers map[string]nats.MsgHandler
+	metrics  map[string]*canaryVersionMetrics
+}
+
+type canaryVersionMetrics struct {
+	triggers       int64
+	failedTriggers int64
+	latency        time.Duration
+}
+
+func (m *MachineManager) newCanarySplit(stable *runningFirecracker, canary *runningFirecracker, weight int) *canarySplit {
+	request := canary.deployRequest
+	if request.TriggerConcurrency != nil {
+		canary.triggerLimiter = m.newTriggerLimiter(canary.namespace, request)
+	}
+
+	split := &canarySplit{
+		stable:   stable,
+		canary:   canary,
+		weight:   weight,
+		handlers: make(map[string]nats.MsgHandler),
+		metrics: map[string]*canaryVersionMetrics{
+			stable.vmmID: {},
+			canary.vmmID: {},
+		},
+	}
+	for _, tsub := range request.TriggerSubjects {
+		handler := nats.MsgHandler(m.generateTriggerHandler(canary, tsub, request))
+		if canary.triggerLimiter != nil {
+			handler = canary.triggerLimiter.wrap(handler)
+		}
+		split.handlers[tsub] = handler
+	}
+
+	return split
+}
+
+// Returns the replacement's handler for the given trigger message, if the message is one of the
+// share routed to the replacement and the replacement subscribes to its subject
+func (s *canarySplit) route(msg *nats.Msg) nats.MsgHandler {
+	s.mutex.Lock()
+	weight := s.weight
+	s.mutex.Unlock()
+
+	if rand.Intn(100) >= weight {
+		return nil
+	}
+	for tsub, handler := range s.handlers {
+		if server.SubjectsCollide(msg.Subject, tsub) {
+			return handler
+		}
+	}
+	return nil
+}
+
+func (s *canarySplit) setWeight(weight int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.weight = weight
+}
+
+func (s *canarySplit) status() *controlapi.CanarySplit {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return &controlapi.CanarySplit{
+		Weight: s.weight,
+		Stable: s.versionStatus(s.stable),
+		Canary: s.versionStatus(s.canary),
+	}
+}
+
+func (s *canarySplit) versionStatus(vm *runningFirecracker) controlapi.CanaryVersionMetrics {
+	metrics := s.metrics[vm.vmmID]
+	status := controlapi.CanaryVersionMetrics{
+		MachineId:      vm.vmmID,
+		Hash:           vm.deployRequest.Hash,
+		Triggers:       metrics.triggers,
+		FailedTriggers: metrics.failedTriggers,
+	}
+	if metrics.triggers > 0 {
+		status.AverageLatencyMillis = float64(metrics.latency.Milliseconds()) / float64(metrics.triggers)
+	}
+	return status
+}
+
+// Wraps the trigger handler of the given machine such that, while the machine's workload is the
+// stable version of a canary update, a share of its trigger messages is routed to the canary
+func (m *MachineManager) routeCanaryTriggers(vm *runningFirecracker, handler nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if split := m.updates.canaryOf(vm.vmmID); split != nil {
+			if canaryHandler := split.route(msg); canaryHandler != nil {
+				canaryHandler(msg)
+				return
+			}
+		}
+		handler(msg)
+	}
+}
+
+// Records a trigger execution of the given machine, if it runs either version of a canary update
+func (m *MachineManager) recordCanaryTrigger(vm *runningFirecracker, elapsed time.Duration, err error) {
+	split := m.updates.splitOf(vm.vmmID)
+	if split == nil {
+		return
+	}
+
+	version := canaryVersionStable
+	if split.canary == vm {
+		version = canaryVersionCanary
+	}
+	outcome := "succeeded"
+
+	split.mutex.Lock()
+	metrics := split.metrics[vm.vmmID]
+	metrics.triggers++
+	metrics.latency += elapsed
+	if err != nil {
+		metrics.failedTriggers++
+		outcome = "failed"
+	}
+	split.mutex.Unlock()
+
+	attrs := []attribute.KeyValue{
+		attribute.String("namespace", vm.namespace),
+		attribute.String("workload_name", *vm.deployRequest.WorkloadName),
+		attribute.String("version", version),
+	}
+	m.t.functionCanaryTriggers.Add(m.ctx, 1, metric.WithAttributes(append(attrs, attribute.String("outcome", outcome))...))
+	m.t.functionCanaryLatency.Record(m.ctx, elapsed.Milliseconds(), metric.WithAttributes(attrs...))
+}
@@ -0,0 +1,87 @@
+package nexnode
+
+import (
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Periodically refreshes the node's capacity snapshot, which is included in PING responses,
+// and advertises it to schedulers via a node capacity event
+func (api *ApiListener) advertiseCapacity() {
+	interval := time.Duration(api.config.CapacityRefreshIntervalMillis) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-api.mgr.ctx.Done():
+			return
+		case <-ticker.C:
+			capacity := api.refreshCapacity()
+
+			cloudevent := cloudevents.NewEvent()
+			cloudevent.SetSource(api.nodeId)
+			cloudevent.SetID(uuid.NewString())
+			cloudevent.SetTime(capacity.RefreshedAt)
+			cloudevent.SetType(controlapi.NodeCapacityEventType)
+			cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+			_ = cloudevent.SetData(controlapi.NodeCapacityEvent{
+				Id:       api.nodeId,
+				Capacity: *capacity,
+			})
+
+			err := PublishCloudEvent(api.mgr.nc, controlapi.SystemNamespace, cloudevent, api.log)
+			if err != nil {
+				api.log.Warn("Failed to publish node capacity event", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// Returns the most recent capacity snapshot, taking one if none has been taken yet
+func (api *ApiListener) currentCapacity() *controlapi.NodeCapacity {
+	if capacity := api.capacity.Load(); capacity != nil {
+		return capacity
+	}
+	return api.refreshCapacity()
+}
+
+// Takes a snapshot of the node's free capacity
+func (api *ApiListener) refreshCapacity() *controlapi.NodeCapacity {
+	var allocatedVCPU int64
+	running := 0
+	for _, vm := range api.mgr.runningMachines() {
+		allocatedVCPU += vm.vcpuCount
+		if vm.deployRequest != nil {
+			running++
+		}
+	}
+
+	capacity := &controlapi.NodeCapacity{
+		WarmMachines:     api.mgr.warmMachineCount(),
+		MachinePoolSize:  api.mgr.poolCapacity(),
+		RunningWorkloads: running,
+		PendingDeploys:   int(atomic.LoadInt32(&api.pendingDeploys)),
+		AllocatableVCPU:  max(int64(runtime.NumCPU())-allocatedVCPU, 0),
+		RefreshedAt:      time.Now().UTC(),
+	}
+	if api.mgr.standby.Load() {
+		capacity.Standby = true
+		capacity.ActivateOnDeploy = api.config.activatesOnDeploy()
+	}
+
+	stats, err := ReadMemoryStats()
+	if err == nil {
+		// meminfo reports kB
+		capacity.AllocatableMemoryMib = int64(stats.MemAvailable / 1024)
+	}
+
+	api.capacity.Store(capacity)
+	return capacity
+}
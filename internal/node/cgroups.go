@@ -0,0 +1,153 @@
+package nexnode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCgroupParent            = "/sys/fs/cgroup/nex"
+	defaultCgroupMemoryOverheadMib = 64
+	defaultCgroupCPUPercent        = 100
+
+	// CPU bandwidth period of machine cgroups, in microseconds
+	cgroupCPUPeriod = 100000
+)
+
+// Host-side resource use of a machine's firecracker process, as accounted by its cgroup
+type cgroupUsage struct {
+	memoryBytes int64
+	cpuUsec     int64
+
+	// CPU bandwidth periods elapsed while the cgroup had runnable processes, those in which it
+	// was throttled for exhausting its cpu.max quota, and the time its processes were throttled
+	cpuPeriods          int64
+	cpuThrottledPeriods int64
+	cpuThrottledUsec    int64
+}
+
+func (l *CgroupLimits) parent() string {
+	if l.Parent != "" {
+		return l.Parent
+	}
+	return defaultCgroupParent
+}
+
+func (l *CgroupLimits) memoryOverheadMib() int {
+	if l.MemoryOverheadMib > 0 {
+		return l.MemoryOverheadMib
+	}
+	return defaultCgroupMemoryOverheadMib
+}
+
+func (l *CgroupLimits) cpuPercent() int {
+	if l.CPUPercent > 0 {
+		return l.CPUPercent
+	}
+	return defaultCgroupCPUPercent
+}
+
+// Creates the cgroup of the machine with the given ID, limiting it to the given number of vCPUs
+// and memory (plus the configured overhead), and returns its path. The parent cgroup is created
+// and its cpu and memory controllers enabled for its children, if necessary
+func createMachineCgroup(limits *CgroupLimits, vmmID string, vcpuCount int, memSizeMib int) (string, error) {
+	parent := limits.parent()
+
+	err := os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create parent cgroup: %s", err)
+	}
+
+	err = writeCgroupFile(parent, "cgroup.subtree_control", "+cpu +memory")
+	if err != nil {
+		return "", fmt.Errorf("failed to enable cgroup controllers: %s", err)
+	}
+
+	path := filepath.Join(parent, vmmID)
+	err = os.Mkdir(path, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create machine cgroup: %s", err)
+	}
+
+	quota := vcpuCount * cgroupCPUPeriod * limits.cpuPercent() / 100
+	err = writeCgroupFile(path, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod))
+	if err == nil {
+		memoryBytes := int64(memSizeMib+limits.memoryOverheadMib()) * 1024 * 1024
+		err = writeCgroupFile(path, "memory.max", strconv.FormatInt(memoryBytes, 10))
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("failed to set machine cgroup limits: %s", err)
+	}
+
+	return path, nil
+}
+
+// Removes the given machine cgroup. A cgroup can't be removed until the processes in it have
+// exited, so removal is retried briefly while the firecracker process stops
+func removeMachineCgroup(path string) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		err = os.Remove(path)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
+
+// Reads the memory and CPU time used by the processes in the given cgroup, along with how often
+// they were throttled
+func readCgroupUsage(path string) (*cgroupUsage, error) {
+	raw, err := os.ReadFile(filepath.Join(path, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &cgroupUsage{}
+	usage.memoryBytes, err = strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := map[string]*int64{
+		"usage_usec":     &usage.cpuUsec,
+		"nr_periods":     &usage.cpuPeriods,
+		"nr_throttled":   &usage.cpuThrottledPeriods,
+		"throttled_usec": &usage.cpuThrottledUsec,
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if stat, ok := stats[fields[0]]; ok {
+			*stat, err = strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return usage, scanner.Err()
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
@@ -0,0 +1,72 @@
+package nexnode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const ClaimsVerifierTypeVaultTransit = "vault_transit"
+
+func (c *ClaimsVerifierConfig) validate() error {
+	switch c.Type {
+	case ClaimsVerifierTypeVaultTransit:
+		if c.Key == "" {
+			return errors.New("vault transit claims verifier requires a key")
+		}
+		if c.Address == "" && os.Getenv("VAULT_ADDR") == "" {
+			return fmt.Errorf("vault transit claims verifier %s requires an address", c.Key)
+		}
+	default:
+		return fmt.Errorf("unsupported claims verifier type: %s", c.Type)
+	}
+
+	return nil
+}
+
+// Builds the verifiers declared in the node's configuration, which are tried ahead of nkeys when
+// validating a control request's JWT
+func newClaimsVerifiers(configs []ClaimsVerifierConfig) []controlapi.ClaimsVerifier {
+	verifiers := make([]controlapi.ClaimsVerifier, 0, len(configs))
+	for i := range configs {
+		config := &configs[i]
+		switch config.Type {
+		case ClaimsVerifierTypeVaultTransit:
+			verifiers = append(verifiers, &vaultTransitVerifier{config: config})
+		}
+	}
+	return verifiers
+}
+
+// Verifies JWTs with a Vault transit key, reading the node's Vault token as each is verified
+type vaultTransitVerifier struct {
+	config *ClaimsVerifierConfig
+}
+
+func (v *vaultTransitVerifier) Verify(token string) (*jwt.GenericClaims, error) {
+	address := v.config.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+
+	vaultToken := os.Getenv("VAULT_TOKEN")
+	if v.config.TokenFile != "" {
+		raw, err := os.ReadFile(v.config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault token: %s", err)
+		}
+		vaultToken = strings.TrimSpace(string(raw))
+	}
+
+	transit := controlapi.NewVaultTransit(address, vaultToken, v.config.Key)
+	if v.config.Mount != "" {
+		transit.Mount = v.config.Mount
+	}
+	transit.Namespace = v.config.Namespace
+
+	return transit.Verify(token)
+}
@@ -0,0 +1,584 @@
+package nexnode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+const (
+	defaultClusterHeartbeatIntervalMillis = 2000
+	defaultClusterDeployTimeoutSeconds    = 30
+
+	// Members are presumed gone once this many heartbeats have been missed, unless configured
+	clusterMemberTimeoutHeartbeats = 3
+
+	// Headers carrying the signatures of a member's gossip by the cluster's signing key, which
+	// admits the member to the cluster, and by the member's node key, which proves its node ID
+	clusterSignatureHeader = "Nex-Cluster-Signature"
+	nodeSignatureHeader    = "Nex-Node-Signature"
+)
+
+var validClusterName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (c *Cluster) validate() []error {
+	errs := make([]error, 0)
+
+	if !validClusterName.MatchString(c.Name) {
+		errs = append(errs, fmt.Errorf("invalid cluster name: %q", c.Name))
+	}
+	if c.HeartbeatIntervalMillis < 0 || c.MemberTimeoutMillis < 0 || c.DeployTimeoutSeconds < 0 {
+		errs = append(errs, errors.New("cluster heartbeat interval, member timeout and deploy timeout must be >= 0"))
+	}
+	if c.MemberTimeoutMillis > 0 && c.memberTimeout() <= c.heartbeatInterval() {
+		errs = append(errs, errors.New("cluster member timeout must be longer than the heartbeat interval"))
+	}
+	if c.SigningKeyFile == "" {
+		errs = append(errs, errors.New("cluster requires a signing key file shared by its members"))
+	} else if _, err := os.Stat(c.SigningKeyFile); err != nil {
+		errs = append(errs, fmt.Errorf("invalid cluster signing key file: %s", err))
+	}
+
+	return errs
+}
+
+func (c *Cluster) heartbeatInterval() time.Duration {
+	if c.HeartbeatIntervalMillis > 0 {
+		return time.Duration(c.HeartbeatIntervalMillis) * time.Millisecond
+	}
+	return defaultClusterHeartbeatIntervalMillis * time.Millisecond
+}
+
+func (c *Cluster) memberTimeout() time.Duration {
+	if c.MemberTimeoutMillis > 0 {
+		return time.Duration(c.MemberTimeoutMillis) * time.Millisecond
+	}
+	return clusterMemberTimeoutHeartbeats * c.heartbeatInterval()
+}
+
+func (c *Cluster) deployTimeout() time.Duration {
+	if c.DeployTimeoutSeconds > 0 {
+		return time.Duration(c.DeployTimeoutSeconds) * time.Second
+	}
+	return defaultClusterDeployTimeoutSeconds * time.Second
+}
+
+// The subject on which the members of a cluster advertise themselves to each other
+func clusterGossipSubject(cluster string) string {
+	return fmt.Sprintf("%s.cluster.%s.gossip", controlapi.APIPrefix, cluster)
+}
+
+// The node's view of the cluster it's a member of. Every member advertises itself on each
+// heartbeat, signed with the cluster's signing key and its node key, and each member takes the live
+// member with the lowest node ID to be the leader, so members agree on the leader once they've heard
+// from each other. Only nodes holding the cluster's signing key are admitted to the cluster, whatever
+// their node ID, so nodes may rejoin with a new ID after restarting. Only the leader subscribes to
+// the cluster's control subjects
+type clusterMembership struct {
+	api    *ApiListener
+	config *Cluster
+	joined time.Time
+	key    nkeys.KeyPair
+
+	mutex   sync.Mutex
+	members map[string]*controlapi.ClusterMember
+	// when each member last advertised itself, kept after members leave so that their gossip
+	// can't be replayed
+	advertised map[string]time.Time
+	leader     string
+	// the cluster's control subjects, subscribed to while the node leads the cluster
+	leaderSubs []*nats.Subscription
+
+	// deploy sets managed while the node leads the cluster, keyed by namespace and name, and
+	// those last shared by the leader, which the node takes over should it become the leader.
+	// See deploy_sets.go
+	sets        map[string]*deploySet
+	standbySets []deploySetState
+	reconciling atomic.Bool
+}
+
+// Joins the cluster named in the node's configuration, advertising the node to its members until
+// the node shuts down
+func (api *ApiListener) joinCluster() error {
+	seed, err := os.ReadFile(api.config.Cluster.SigningKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read cluster signing key: %s", err)
+	}
+
+	key, err := nkeys.FromSeed(bytes.TrimSpace(seed))
+	if err != nil {
+		return fmt.Errorf("failed to parse cluster signing key: %s", err)
+	}
+
+	c := &clusterMembership{
+		api:        api,
+		config:     api.config.Cluster,
+		joined:     time.Now().UTC(),
+		key:        key,
+		members:    make(map[string]*controlapi.ClusterMember),
+		advertised: make(map[string]time.Time),
+		sets:       make(map[string]*deploySet),
+	}
+
+	_, err = api.mgr.nc.Subscribe(clusterGossipSubject(c.config.Name), c.handleGossip)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to cluster gossip: %s", err)
+	}
+
+	_, err = api.mgr.nc.Subscribe(clusterDeploySetsSubject(c.config.Name, api.nodeId), c.handleSharedDeploySets)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to cluster deploy sets: %s", err)
+	}
+
+	api.log.Info("Joined cluster", slog.String("cluster", c.config.Name))
+	go c.run()
+	return nil
+}
+
+func (c *clusterMembership) run() {
+	ticker := time.NewTicker(c.config.heartbeatInterval())
+	defer ticker.Stop()
+
+	c.advertise()
+	for {
+		select {
+		case <-c.api.mgr.ctx.Done():
+			c.mutex.Lock()
+			c.stepDown()
+			c.mutex.Unlock()
+			return
+		case <-ticker.C:
+			c.advertise()
+			c.elect()
+			if c.leading() {
+				go c.reconcileDeploySets()
+				c.shareDeploySets()
+			}
+		}
+	}
+}
+
+// Publishes the node's membership, including its current capacity, to the cluster
+func (c *clusterMembership) advertise() {
+	publicXKey, _ := c.api.xk.PublicKey()
+	member := controlapi.ClusterMember{
+		NodeId:        c.api.nodeId,
+		PublicXKey:    publicXKey,
+		Tags:          c.api.config.Tags,
+		WorkloadTypes: c.api.config.WorkloadTypes,
+		Capacity:      *c.api.refreshCapacity(),
+		Workloads:     c.api.mgr.deployedWorkloadNames(),
+		Machines:      c.api.mgr.deployedMachineIDs(),
+		AdvertisedAt:  time.Now().UTC(),
+	}
+
+	raw, err := json.Marshal(member)
+	if err != nil {
+		c.api.log.Error("Failed to marshal cluster membership", slog.Any("err", err))
+		return
+	}
+
+	clusterSig, err := c.key.Sign(raw)
+	if err != nil {
+		c.api.log.Error("Failed to sign cluster membership", slog.Any("err", err))
+		return
+	}
+
+	nodeSig, err := c.api.mgr.kp.Sign(raw)
+	if err != nil {
+		c.api.log.Error("Failed to sign cluster membership", slog.Any("err", err))
+		return
+	}
+
+	msg := nats.NewMsg(clusterGossipSubject(c.config.Name))
+	msg.Data = raw
+	msg.Header.Set(clusterSignatureHeader, base64.StdEncoding.EncodeToString(clusterSig))
+	msg.Header.Set(nodeSignatureHeader, base64.StdEncoding.EncodeToString(nodeSig))
+
+	err = c.api.mgr.nc.PublishMsg(msg)
+	if err != nil {
+		c.api.log.Warn("Failed to advertise cluster membership", slog.Any("err", err))
+	}
+}
+
+// Admits the advertised member to the cluster, or refreshes it, if the gossip was signed by the
+// cluster's signing key and the member's node key, and is newer than any seen from it before
+func (c *clusterMembership) handleGossip(m *nats.Msg) {
+	var member controlapi.ClusterMember
+	err := json.Unmarshal(m.Data, &member)
+	if err != nil || member.NodeId == "" {
+		c.api.log.Warn("Received invalid cluster membership", slog.Any("err", err))
+		return
+	}
+
+	err = c.verifyGossip(&member, m)
+	if err != nil {
+		c.api.log.Warn("Rejected cluster membership", slog.String("cluster", c.config.Name), slog.String("node_id", member.NodeId), slog.Any("err", err))
+		return
+	}
+
+	// members are timed out by the node's own clock
+	member.LastSeen = time.Now().UTC()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !member.AdvertisedAt.After(c.advertised[member.NodeId]) {
+		c.api.log.Warn("Rejected replayed cluster membership", slog.String("cluster", c.config.Name), slog.String("node_id", member.NodeId))
+		return
+	}
+	c.advertised[member.NodeId] = member.AdvertisedAt
+
+	if _, ok := c.members[member.NodeId]; !ok {
+		c.api.log.Info("Cluster member joined", slog.String("cluster", c.config.Name), slog.String("node_id", member.NodeId))
+	}
+	c.members[member.NodeId] = &member
+}
+
+// Checks that gossip advertising the given member was signed by the cluster's signing key, and by
+// the node key of the member it advertises
+func (c *clusterMembership) verifyGossip(member *controlapi.ClusterMember, m *nats.Msg) error {
+	clusterSig, err := base64.StdEncoding.DecodeString(m.Header.Get(clusterSignatureHeader))
+	if err != nil || len(clusterSig) == 0 {
+		return errors.New("gossip is not signed by the cluster")
+	}
+	if c.key.Verify(m.Data, clusterSig) != nil {
+		return errors.New("gossip was not signed by the cluster's signing key")
+	}
+
+	nodeSig, err := base64.StdEncoding.DecodeString(m.Header.Get(nodeSignatureHeader))
+	if err != nil || len(nodeSig) == 0 {
+		return errors.New("gossip is not signed by its node")
+	}
+
+	kp, err := nkeys.FromPublicKey(member.NodeId)
+	if err != nil {
+		return err
+	}
+	if kp.Verify(m.Data, nodeSig) != nil {
+		return errors.New("gossip was not signed by the node it advertises")
+	}
+	return nil
+}
+
+// Forgets members which haven't been heard from within the member timeout, returning the rest
+// ordered by node ID. Must be called with the mutex held
+func (c *clusterMembership) liveMembers() []*controlapi.ClusterMember {
+	cutoff := time.Now().UTC().Add(-c.config.memberTimeout())
+
+	members := make([]*controlapi.ClusterMember, 0, len(c.members))
+	for id, member := range c.members {
+		if member.LastSeen.Before(cutoff) {
+			c.api.log.Info("Cluster member left", slog.String("cluster", c.config.Name), slog.String("node_id", id))
+			delete(c.members, id)
+			continue
+		}
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].NodeId < members[j].NodeId
+	})
+	return members
+}
+
+// Takes the live member with the lowest node ID to be the leader, leading the cluster or stepping
+// down when that changes. A node only elects a leader once it's been a member long enough to have
+// heard from the others, so it doesn't briefly lead a cluster which already has a leader
+func (c *clusterMembership) elect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members := c.liveMembers()
+	if time.Since(c.joined) < c.config.memberTimeout() || len(members) == 0 {
+		return
+	}
+
+	leader := members[0].NodeId
+	if leader == c.leader {
+		return
+	}
+
+	c.api.log.Info("Elected cluster leader",
+		slog.String("cluster", c.config.Name),
+		slog.String("leader", leader),
+		slog.Int("members", len(members)),
+	)
+
+	wasLeader := c.leader == c.api.nodeId
+	c.leader = leader
+	if leader == c.api.nodeId {
+		c.lead()
+	} else if wasLeader {
+		c.stepDown()
+	}
+}
+
+// Subscribes to the cluster's control subjects. Must be called with the mutex held
+func (c *clusterMembership) lead() {
+	nc := c.api.mgr.nc
+
+	sub, err := nc.Subscribe(fmt.Sprintf("%s.CLUSTER.%s", controlapi.APIPrefix, c.config.Name), c.api.negotiated(controlapi.ClusterResponseType, c.handleClusterInfo))
+	if err != nil {
+		c.api.log.Error("Failed to subscribe to cluster info subject", slog.Any("err", err))
+	} else {
+		c.leaderSubs = append(c.leaderSubs, sub)
+	}
+
+	sub, err = nc.Subscribe(fmt.Sprintf("%s.CLUSTERDEPLOY.*.%s", controlapi.APIPrefix, c.config.Name),
+		c.api.audited(c.api.negotiated(controlapi.RunResponseType, c.api.authorize(controlapi.RunResponseType, c.handleClusterDeploy))))
+	if err != nil {
+		c.api.log.Error("Failed to subscribe to cluster deploy subject", slog.Any("err", err))
+	} else {
+		c.leaderSubs = append(c.leaderSubs, sub)
+	}
+
+	sub, err = nc.Subscribe(fmt.Sprintf("%s.DEPLOYSET.*.%s.*", controlapi.APIPrefix, c.config.Name),
+		c.api.audited(c.api.negotiated(controlapi.DeploySetResponseType, c.api.authorize(controlapi.DeploySetResponseType, c.handleDeploySet))))
+	if err != nil {
+		c.api.log.Error("Failed to subscribe to deploy set subject", slog.Any("err", err))
+	} else {
+		c.leaderSubs = append(c.leaderSubs, sub)
+	}
+
+	c.adoptDeploySets()
+}
+
+// Unsubscribes from the cluster's control subjects and leaves the cluster's deploy sets to the
+// new leader. Must be called with the mutex held
+func (c *clusterMembership) stepDown() {
+	for _, sub := range c.leaderSubs {
+		_ = sub.Unsubscribe()
+	}
+	c.leaderSubs = nil
+	c.sets = make(map[string]*deploySet)
+}
+
+// Whether the node currently leads the cluster
+func (c *clusterMembership) leading() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.leader == c.api.nodeId
+}
+
+func (c *clusterMembership) handleClusterInfo(m *nats.Msg) {
+	publicXKey, _ := c.api.xk.PublicKey()
+	res := controlapi.ClusterResponse{
+		Cluster:          c.config.Name,
+		LeaderPublicXKey: publicXKey,
+		Members:          make([]controlapi.ClusterMember, 0),
+	}
+
+	c.mutex.Lock()
+	res.Leader = c.leader
+	for _, member := range c.liveMembers() {
+		res.Members = append(res.Members, *member)
+	}
+	c.mutex.Unlock()
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.ClusterResponseType, res, nil))
+	if err != nil {
+		c.api.log.Error("Failed to marshal cluster response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Deploys the requested workload to the live member best able to run it, re-encrypting its
+// environment for that member, and responds with that member's run response
+func (c *clusterMembership) handleClusterDeploy(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		c.api.log.Error("Invalid subject for cluster deploy", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for cluster deploy")
+		return
+	}
+
+	var request controlapi.ClusterDeployRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		c.api.log.Error("Failed to deserialize cluster deploy request", slog.Any("err", err))
+		respondInvalid(controlapi.RunResponseType, m, fmt.Sprintf("Unable to deserialize cluster deploy request: %s", err), err)
+		return
+	}
+	if request.Request == nil || request.Request.Environment == nil || request.Request.SenderPublicKey == nil {
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid cluster deploy request: a deploy request with an encrypted environment and its sender's public key is required")
+		return
+	}
+	deploy := request.Request
+
+	err = deploy.DecryptRequestEnvironment(c.api.xk)
+	if err != nil {
+		c.api.log.Error("Failed to decrypt environment for cluster deploy request", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Failed to decrypt environment for cluster deploy request: %s", err))
+		return
+	}
+
+	candidates := c.candidates(namespace, deploy, request.NodeTags)
+	if len(candidates) == 0 {
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodePlacementRejected, "No cluster member can run the workload")
+		return
+	}
+	runResponse, failure, err := c.deployTo(namespace, candidates[0], deploy)
+	if err != nil {
+		c.api.log.Error("Failed to deploy workload to cluster member", slog.String("node_id", candidates[0].NodeId), slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeDeployFailed, fmt.Sprintf("Failed to deploy workload: %s", err))
+		return
+	}
+	if failure != nil {
+		// failures, e.g. quota errors, are passed on as the member gave them
+		_ = m.Respond(failure)
+		return
+	}
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.RunResponseType, *runResponse, nil))
+	if err != nil {
+		c.api.log.Error("Failed to marshal run response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Deploys the workload to the given member, re-encrypting its environment for the member, and
+// returns the member's run response naming the member. Should the member fail to deploy the
+// workload, its response is returned as it gave it instead
+func (c *clusterMembership) deployTo(namespace string, member controlapi.ClusterMember, request *controlapi.DeployRequest) (*controlapi.RunResponse, []byte, error) {
+	deploy := *request
+
+	env, err := controlapi.EncryptRequestEnvironment(c.api.xk, member.PublicXKey, deploy.WorkloadEnvironment)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt environment for node %s: %s", member.NodeId, err)
+	}
+	senderPublicKey, _ := c.api.xk.PublicKey()
+	deploy.Environment = &env
+	deploy.SealedEnvironment = nil
+	deploy.SenderPublicKey = &senderPublicKey
+	deploy.TargetNode = &member.NodeId
+
+	raw, err := json.Marshal(deploy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal deploy request: %s", err)
+	}
+
+	c.api.log.Info("Placing workload on cluster member",
+		slog.String("cluster", c.config.Name),
+		slog.String("namespace", namespace),
+		slog.String("node_id", member.NodeId),
+	)
+
+	subject := fmt.Sprintf("%s.DEPLOY.%s.%s", controlapi.APIPrefix, namespace, member.NodeId)
+	res, err := c.api.mgr.nc.Request(subject, raw, c.config.deployTimeout())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to deploy workload to node %s: %s", member.NodeId, err)
+	}
+
+	var envelope controlapi.Envelope
+	err = json.Unmarshal(res.Data, &envelope)
+	if err != nil || envelope.Error != nil || envelope.PayloadType != controlapi.RunResponseType {
+		return nil, res.Data, nil
+	}
+
+	var runResponse controlapi.RunResponse
+	data, _ := json.Marshal(envelope.Data)
+	err = json.Unmarshal(data, &runResponse)
+	if err != nil {
+		return nil, res.Data, nil
+	}
+	runResponse.NodeId = member.NodeId
+
+	c.reserve(member.NodeId, namespace, &runResponse, request)
+	return &runResponse, nil, nil
+}
+
+// Returns the live members able to run the workload, i.e. those with the given tags, satisfying
+// its placement constraints, supporting its workload type, with enough allocatable vCPUs and
+// memory for it and not in standby (unless they activate on deploy), from best to worst: active
+// members before those in standby, then those with the most warm machines, then the most
+// allocatable memory, then the fewest pending deploys
+func (c *clusterMembership) candidates(namespace string, request *controlapi.DeployRequest, tags map[string]string) []controlapi.ClusterMember {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	candidates := make([]controlapi.ClusterMember, 0)
+	for _, member := range c.liveMembers() {
+		if !controlapi.MatchesSelector(tags, member.Tags) {
+			continue
+		}
+		if request.Placement.UnmatchedRequirement(member.Tags) != nil || request.Placement.AntiAffinityConflict(member.Workloads[namespace]) != "" {
+			continue
+		}
+		if request.WorkloadType != nil && len(member.WorkloadTypes) > 0 && !slices.Contains(member.WorkloadTypes, *request.WorkloadType) {
+			continue
+		}
+		if request.VcpuCount != nil && int64(*request.VcpuCount) > member.Capacity.AllocatableVCPU {
+			continue
+		}
+		if request.MemSizeMib != nil && int64(*request.MemSizeMib) > member.Capacity.AllocatableMemoryMib {
+			continue
+		}
+		if member.Capacity.Standby && !member.Capacity.ActivateOnDeploy {
+			continue
+		}
+		candidates = append(candidates, *member)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].Capacity, candidates[j].Capacity
+		if a.Standby != b.Standby {
+			return !a.Standby
+		}
+		if a.WarmMachines != b.WarmMachines {
+			return a.WarmMachines > b.WarmMachines
+		}
+		if a.AllocatableMemoryMib != b.AllocatableMemoryMib {
+			return a.AllocatableMemoryMib > b.AllocatableMemoryMib
+		}
+		return a.PendingDeploys < b.PendingDeploys
+	})
+
+	return candidates
+}
+
+// Deducts a workload deployed to a member from the member's capacity, and adds it to the member's
+// workloads, until the member next advertises itself, so that workloads deployed in quick
+// succession are spread out
+func (c *clusterMembership) reserve(nodeId string, namespace string, deployed *controlapi.RunResponse, request *controlapi.DeployRequest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	member, ok := c.members[nodeId]
+	if !ok {
+		return
+	}
+
+	member.Capacity.WarmMachines = max(member.Capacity.WarmMachines-1, 0)
+	member.Capacity.RunningWorkloads++
+	if deployed.Name != "" && !slices.Contains(member.Workloads[namespace], deployed.Name) {
+		if member.Workloads == nil {
+			member.Workloads = make(map[string][]string)
+		}
+		member.Workloads[namespace] = append(member.Workloads[namespace], deployed.Name)
+	}
+	member.Machines = append(member.Machines, deployed.MachineId)
+	if request.VcpuCount != nil {
+		member.Capacity.AllocatableVCPU = max(member.Capacity.AllocatableVCPU-int64(*request.VcpuCount), 0)
+	}
+	if request.MemSizeMib != nil {
+		member.Capacity.AllocatableMemoryMib = max(member.Capacity.AllocatableMemoryMib-int64(*request.MemSizeMib), 0)
+	}
+}
@@ -0,0 +1,257 @@
+package nexnode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Directories used by the firecracker SDK and the host-local IPAM plugin; machines' CNI
+	// results are cached in /var/lib/cni/{vmid}/results
+	cniConfDir  = "/etc/cni/conf.d"
+	cniStateDir = "/var/lib/cni"
+	netNSDir    = "/var/run/netns"
+)
+
+// The parts of a CNI result cached by libcni which identify the interfaces of an attachment
+type cniCachedAttachment struct {
+	ContainerID string `json:"containerId"`
+	IfName      string `json:"ifName"`
+	NetworkName string `json:"networkName"`
+	Result      struct {
+		Interfaces []struct {
+			Name    string `json:"name"`
+			Sandbox string `json:"sandbox"`
+		} `json:"interfaces"`
+	} `json:"result"`
+}
+
+// Tears down the network attachments of machines left behind by previous node processes. Each
+// cached attachment to the node's CNI network is deleted through its CNI plugins, falling back to
+// removing its host interfaces directly, along with its network namespace. Host veth interfaces
+// still holding addresses in the network's subnets are then removed, as are the network's IP
+// allocations. Interfaces which don't belong to the network are left alone
+func (m *MachineManager) resetCNI() error {
+	m.log.Info("Resetting network")
+
+	network := *m.config.CNI.NetworkName
+	list, err := libcni.LoadConfList(cniConfDir, network)
+	if err != nil {
+		return fmt.Errorf("failed to load CNI network %s: %s", network, err)
+	}
+
+	var errs error
+
+	cached, err := filepath.Glob(filepath.Join(cniStateDir, "*", "results", network+"-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range cached {
+		err = m.resetCNIAttachment(list, path)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	errs = errors.Join(errs, m.removeOrphanedVeths(list))
+
+	err = os.RemoveAll(filepath.Join(cniStateDir, "networks", network))
+	if err != nil {
+		errs = errors.Join(errs, fmt.Errorf("failed to remove IP allocations of network %s: %s", network, err))
+	}
+
+	return errs
+}
+
+// Deletes the attachment whose result is cached at the given path, and the cache itself
+func (m *MachineManager) resetCNIAttachment(list *libcni.NetworkConfigList, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var attachment cniCachedAttachment
+	err = json.Unmarshal(raw, &attachment)
+	if err != nil {
+		return fmt.Errorf("failed to parse cached CNI result %s: %s", path, err)
+	}
+
+	var errs error
+	var netns string
+	for _, iface := range attachment.Result.Interfaces {
+		if iface.Sandbox != "" {
+			netns = iface.Sandbox
+		}
+	}
+
+	cacheDir := filepath.Dir(filepath.Dir(path))
+	cni := libcni.NewCNIConfigWithCacheDir(m.config.CNI.BinPath, cacheDir, nil)
+	err = cni.DelNetworkList(context.Background(), list, &libcni.RuntimeConf{
+		ContainerID: attachment.ContainerID,
+		NetNS:       netns,
+		IfName:      attachment.IfName,
+	})
+	if err != nil {
+		m.log.Warn("Failed to delete CNI attachment through its plugins; removing its interfaces directly",
+			slog.String("container_id", attachment.ContainerID),
+			slog.Any("err", err),
+		)
+
+		for _, iface := range attachment.Result.Interfaces {
+			if iface.Sandbox != "" {
+				continue
+			}
+
+			err = deleteLink(iface.Name)
+			if err != nil {
+				errs = errors.Join(errs, err)
+				m.log.Error("Failed to remove interface", slog.String("interface", iface.Name), slog.Any("err", err))
+			} else {
+				m.log.Info("Removed interface", slog.String("interface", iface.Name))
+			}
+		}
+	}
+
+	if netns != "" && strings.HasPrefix(netns, netNSDir+"/") {
+		err = removeNetNS(netns)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			m.log.Error("Failed to remove network namespace", slog.String("netns", netns), slog.Any("err", err))
+		}
+	}
+
+	err = os.RemoveAll(cacheDir)
+	if err != nil {
+		errs = errors.Join(errs, fmt.Errorf("failed to remove CNI cache %s: %s", cacheDir, err))
+	}
+
+	return errs
+}
+
+// Removes host veth interfaces holding an address in one of the network's subnets, i.e., the
+// host ends of attachments whose cached results were lost
+func (m *MachineManager) removeOrphanedVeths(list *libcni.NetworkConfigList) error {
+	subnets := cniSubnets(list)
+	if len(subnets) == 0 {
+		return nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %s", err)
+	}
+
+	var errs error
+	for _, link := range links {
+		if link.Type() != "veth" {
+			continue
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to list addresses of interface %s: %s", link.Attrs().Name, err))
+			continue
+		}
+
+		for _, addr := range addrs {
+			if !subnetsContain(subnets, addr.IP) {
+				continue
+			}
+
+			err = netlink.LinkDel(link)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("failed to remove interface %s: %s", link.Attrs().Name, err))
+				m.log.Error("Failed to remove orphaned interface", slog.String("interface", link.Attrs().Name), slog.Any("err", err))
+			} else {
+				m.log.Info("Removed orphaned interface", slog.String("interface", link.Attrs().Name))
+			}
+			break
+		}
+	}
+
+	return errs
+}
+
+// Returns the subnets from which the IPAM configuration of the network's plugins allocates
+// addresses, either as a single subnet or as ranges
+func cniSubnets(list *libcni.NetworkConfigList) []*net.IPNet {
+	subnets := make([]*net.IPNet, 0)
+	for _, plugin := range list.Plugins {
+		var conf struct {
+			IPAM struct {
+				Subnet string `json:"subnet"`
+				Ranges [][]struct {
+					Subnet string `json:"subnet"`
+				} `json:"ranges"`
+			} `json:"ipam"`
+		}
+		if json.Unmarshal(plugin.Bytes, &conf) != nil {
+			continue
+		}
+
+		candidates := []string{conf.IPAM.Subnet}
+		for _, set := range conf.IPAM.Ranges {
+			for _, r := range set {
+				candidates = append(candidates, r.Subnet)
+			}
+		}
+
+		for _, candidate := range candidates {
+			if _, subnet, err := net.ParseCIDR(candidate); err == nil {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+	return subnets
+}
+
+func subnetsContain(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find interface %s: %s", name, err)
+	}
+
+	err = netlink.LinkDel(link)
+	if err != nil {
+		return fmt.Errorf("failed to remove interface %s: %s", name, err)
+	}
+	return nil
+}
+
+// Unmounts and removes the named network namespace at the given path
+func removeNetNS(path string) error {
+	err := syscall.Unmount(path, syscall.MNT_DETACH)
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("failed to unmount network namespace %s: %s", path, err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove network namespace %s: %s", path, err)
+	}
+	return nil
+}
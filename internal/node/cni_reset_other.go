@@ -0,0 +1,8 @@
+//go:build !linux
+
+package nexnode
+
+// CNI networks are only used by firecracker machines, which are only available on Linux
+func (m *MachineManager) resetCNI() error {
+	return nil
+}
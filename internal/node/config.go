@@ -3,10 +3,16 @@ package nexnode
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
 )
 
 const defaultCNINetworkName = "fcnet"
@@ -15,6 +21,23 @@ const defaultInternalNodeHost = "192.168.127.1"
 const defaultInternalNodePort = 9222
 const defaultNodeMemSizeMib = 256
 const defaultNodeVcpuCount = 1
+const defaultTriggerFailureThreshold = 10
+const defaultCapacityRefreshIntervalMillis = 5000
+const defaultShutdownConcurrency = 8
+const defaultShutdownTimeout = 60 * time.Second
+
+// Name by which deploy requests may explicitly select the node's default machine template
+const DefaultMachineTemplate = "default"
+
+// Cleanup policies for the node's run directory. Each machine's files are removed when it stops
+// regardless of policy; these govern sweeping the directory for anything left behind. On stop
+// (the default), the node sweeps up after itself when it stops; always additionally sweeps up
+// after previous node processes (e.g., ones that crashed) on start; never disables sweeping
+const (
+	RunDirectoryCleanupOnStop = "on_stop"
+	RunDirectoryCleanupAlways = "always"
+	RunDirectoryCleanupNever  = "never"
+)
 
 var (
 	// docker/OCI needs to be explicitly enabled in node configuration
@@ -22,30 +45,72 @@ var (
 	defaultBinPath       = append([]string{"/usr/local/bin"}, filepath.SplitList(os.Getenv("PATH"))...)
 	// check the default cni bin path first, otherwise look in the rest of the PATH
 	defaultCNIBinPath = append([]string{"/opt/cni/bin"}, filepath.SplitList(os.Getenv("PATH"))...)
+
+	validMachineTemplateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	validSha256Digest        = regexp.MustCompile(`^[a-f0-9]{64}$`)
 )
 
 // Node configuration is used to configure the node process as well
 // as the virtual machines it produces
 type NodeConfiguration struct {
-	BinPath             []string          `json:"bin_path"`
-	CNI                 CNIDefinition     `json:"cni"`
-	DefaultResourceDir  string            `json:"default_resource_dir"`
-	ForceDepInstall     bool              `json:"-"`
-	InternalNodeHost    *string           `json:"internal_node_host,omitempty"`
-	InternalNodePort    *int              `json:"internal_node_port"`
-	KernelFilepath      string            `json:"kernel_filepath"`
-	MachinePoolSize     int               `json:"machine_pool_size"`
-	MachineTemplate     MachineTemplate   `json:"machine_template"`
-	OtelMetrics         bool              `json:"otel_metrics"`
-	OtelMetricsPort     int               `json:"otel_metrics_port"`
-	OtelMetricsExporter string            `json:"otel_metrics_exporter"`
-	PreserveNetwork     bool              `json:"preserve_network,omitempty"`
-	RateLimiters        *Limiters         `json:"rate_limiters,omitempty"`
-	RootFsFilepath      string            `json:"rootfs_filepath"`
-	Tags                map[string]string `json:"tags,omitempty"`
-	ValidIssuers        []string          `json:"valid_issuers,omitempty"`
-	WorkloadTypes       []string          `json:"workload_types,omitempty"`
-	OtlpExporterUrl     *string           `json:"otlp_exporter_url,omitempty"`
+	ArtifactCacheDir              string                               `json:"artifact_cache_dir,omitempty"`
+	ArtifactScanners              []ArtifactScannerConfig              `json:"artifact_scanners,omitempty"`
+	ArtifactVerification          *ArtifactVerification                `json:"artifact_verification,omitempty"`
+	AssetReaper                   *AssetReaper                         `json:"asset_reaper,omitempty"`
+	BinPath                       []string                             `json:"bin_path"`
+	CNI                           CNIDefinition                        `json:"cni"`
+	CapacityRefreshIntervalMillis int                                  `json:"capacity_refresh_interval_ms"`
+	ClaimsVerifiers               []ClaimsVerifierConfig               `json:"claims_verifiers,omitempty"`
+	Cluster                       *Cluster                             `json:"cluster,omitempty"`
+	Cgroups                       *CgroupLimits                        `json:"cgroups,omitempty"`
+	DefaultResourceDir            string                               `json:"default_resource_dir"`
+	EventStream                   *EventStreamEndpoint                 `json:"event_stream,omitempty"`
+	ForceDepInstall               bool                                 `json:"-"`
+	InternalNodeHost              *string                              `json:"internal_node_host,omitempty"`
+	InternalNodePort              *int                                 `json:"internal_node_port"`
+	JsDomain                      *string                              `json:"jsdomain,omitempty"`
+	KernelFilepath                string                               `json:"kernel_filepath"`
+	MachinePoolSize               int                                  `json:"machine_pool_size"`
+	MachineTemplate               MachineTemplate                      `json:"machine_template"`
+	MachineTemplates              map[string]MachineTemplate           `json:"machine_templates,omitempty"`
+	MachineSizeClasses            map[string]MachineSizeClass          `json:"machine_size_classes,omitempty"`
+	MachineSizeLimits             *MachineSizeLimits                   `json:"machine_size_limits,omitempty"`
+	MaxReservationSeconds         int                                  `json:"max_reservation_seconds,omitempty"`
+	MaxPauseSeconds               int                                  `json:"max_pause_seconds,omitempty"`
+	NamespaceQuotas               map[string]controlapi.NamespaceQuota `json:"namespace_quotas,omitempty"`
+	NoSandbox                     bool                                 `json:"no_sandbox,omitempty"`
+	OtelMetrics                   bool                                 `json:"otel_metrics"`
+	OtelMetricsPort               int                                  `json:"otel_metrics_port"`
+	OtelMetricsExporter           string                               `json:"otel_metrics_exporter"`
+	PreserveNetwork               bool                                 `json:"preserve_network,omitempty"`
+	QuotaBucket                   *string                              `json:"quota_bucket,omitempty"`
+	RateLimiters                  *Limiters                            `json:"rate_limiters,omitempty"`
+	RestartEvictedWorkloads       bool                                 `json:"restart_evicted_workloads,omitempty"`
+	RootFsFilepath                string                               `json:"rootfs_filepath"`
+	RootFsOverlay                 *RootFsOverlay                       `json:"rootfs_overlay,omitempty"`
+	RunDirectory                  string                               `json:"run_directory,omitempty"`
+	RunDirectoryCleanup           string                               `json:"run_directory_cleanup,omitempty"`
+	SandboxProfiles               *SandboxProfiles                     `json:"sandbox_profiles,omitempty"`
+	Secrets                       *Secrets                             `json:"secrets,omitempty"`
+	ServiceNetworking             *ServiceNetworking                   `json:"service_networking,omitempty"`
+	Shutdown                      *Shutdown                            `json:"shutdown,omitempty"`
+	ControlAuth                   *ControlAuth                         `json:"control_auth,omitempty"`
+	UtilizationReports            *UtilizationReports                  `json:"utilization_reports,omitempty"`
+	AuditLog                      *AuditLog                            `json:"audit_log,omitempty"`
+	LogStream                     *LogStream                           `json:"log_stream,omitempty"`
+	SelfUpdate                    *SelfUpdate                          `json:"self_update,omitempty"`
+	Standby                       *Standby                             `json:"standby,omitempty"`
+	Tags                          map[string]string                    `json:"tags,omitempty"`
+	TriggerFailureThreshold       int                                  `json:"trigger_failure_threshold"`
+	TriggerSubjectPolicy          *TriggerSubjectPolicy                `json:"trigger_subject_policy,omitempty"`
+	ValidIssuers                  []string                             `json:"valid_issuers,omitempty"`
+	Volumes                       *Volumes                             `json:"volumes,omitempty"`
+	Webhooks                      *WebhookReceiver                     `json:"webhooks,omitempty"`
+	WorkloadCredentials           *WorkloadCredentials                 `json:"workload_credentials,omitempty"`
+	WorkloadRecovery              *WorkloadRecovery                    `json:"workload_recovery,omitempty"`
+	ZombieReaper                  *ZombieReaper                        `json:"zombie_reaper,omitempty"`
+	WorkloadTypes                 []string                             `json:"workload_types,omitempty"`
+	OtlpExporterUrl               *string                              `json:"otlp_exporter_url,omitempty"`
 
 	Errors []error `json:"errors,omitempty"`
 }
@@ -57,12 +122,207 @@ func (c *NodeConfiguration) Validate() bool {
 		c.Errors = append(c.Errors, errors.New("machine pool size must be >= 1"))
 	}
 
-	if _, err := os.Stat(c.KernelFilepath); errors.Is(err, os.ErrNotExist) {
-		c.Errors = append(c.Errors, err)
+	if c.CapacityRefreshIntervalMillis < 0 {
+		c.Errors = append(c.Errors, errors.New("capacity refresh interval must be >= 0"))
+	}
+
+	if c.MaxReservationSeconds < 0 {
+		c.Errors = append(c.Errors, errors.New("max reservation duration must be >= 0"))
+	}
+
+	if c.MaxPauseSeconds < 0 {
+		c.Errors = append(c.Errors, errors.New("max pause duration must be >= 0"))
+	}
+
+	if c.TriggerFailureThreshold < 0 {
+		c.Errors = append(c.Errors, errors.New("trigger failure threshold must be >= 0"))
+	}
+
+	for namespace, quota := range c.NamespaceQuotas {
+		if quota.MaxWorkloads < 0 || quota.MaxVCPU < 0 || quota.MaxMemoryMib < 0 || quota.MaxDeployedBytes < 0 {
+			c.Errors = append(c.Errors, fmt.Errorf("quota limits for namespace %s must be >= 0", namespace))
+		}
+	}
+
+	switch c.RunDirectoryCleanup {
+	case "", RunDirectoryCleanupOnStop, RunDirectoryCleanupAlways, RunDirectoryCleanupNever:
+	default:
+		c.Errors = append(c.Errors, fmt.Errorf("unsupported run directory cleanup policy: %s", c.RunDirectoryCleanup))
+	}
+
+	if c.RunDirectory != "" {
+		err := validateRunDirectory(c.RunDirectory)
+		if err != nil {
+			c.Errors = append(c.Errors, err)
+		}
+	}
+
+	for name, template := range c.MachineTemplates {
+		if !validMachineTemplateName.MatchString(name) || name == DefaultMachineTemplate {
+			c.Errors = append(c.Errors, fmt.Errorf("invalid machine template name: %s", name))
+		}
+
+		if (template.VcpuCount != nil && *template.VcpuCount < 1) || (template.MemSizeMib != nil && *template.MemSizeMib < 1) {
+			c.Errors = append(c.Errors, fmt.Errorf("machine template %s must have vcpu count and memory size >= 1", name))
+		}
+	}
+
+	for name, class := range c.MachineSizeClasses {
+		if class.VcpuCount < 1 || class.MemSizeMib < 1 || class.PoolSize < 1 {
+			c.Errors = append(c.Errors, fmt.Errorf("machine size class %s must have vcpu count, memory size and pool size >= 1", name))
+		}
+	}
+
+	if l := c.MachineSizeLimits; l != nil {
+		if l.MinVcpuCount < 1 || l.MinMemSizeMib < 1 || l.MaxVcpuCount < l.MinVcpuCount || l.MaxMemSizeMib < l.MinMemSizeMib {
+			c.Errors = append(c.Errors, errors.New("machine size limits must have minimums >= 1 and maximums >= minimums"))
+		}
+	}
+
+	for _, template := range c.allMachineTemplates() {
+		for _, artifact := range []*MachineArtifact{template.Kernel, template.RootFs} {
+			if artifact == nil {
+				continue
+			}
+
+			err := artifact.validate()
+			if err != nil {
+				c.Errors = append(c.Errors, err)
+			}
+		}
+	}
+
+	names := make(map[string]struct{}, len(c.ArtifactScanners))
+	for _, scanner := range c.ArtifactScanners {
+		err := scanner.validate()
+		if err != nil {
+			c.Errors = append(c.Errors, err)
+		}
+		if _, ok := names[scanner.Name]; ok {
+			c.Errors = append(c.Errors, fmt.Errorf("duplicate artifact scanner name: %s", scanner.Name))
+		}
+		names[scanner.Name] = struct{}{}
+	}
+
+	for _, verifier := range c.ClaimsVerifiers {
+		err := verifier.validate()
+		if err != nil {
+			c.Errors = append(c.Errors, err)
+		}
+	}
+
+	if c.Cluster != nil {
+		c.Errors = append(c.Errors, c.Cluster.validate()...)
+	}
+
+	if c.SandboxProfiles != nil {
+		c.Errors = append(c.Errors, c.SandboxProfiles.validate()...)
+	}
+
+	if c.Secrets != nil {
+		c.Errors = append(c.Errors, c.Secrets.validate()...)
+	}
+
+	if c.ServiceNetworking != nil {
+		c.Errors = append(c.Errors, c.ServiceNetworking.validate()...)
+	}
+
+	if c.Volumes != nil {
+		c.Errors = append(c.Errors, c.Volumes.validate()...)
+	}
+
+	if c.RootFsOverlay != nil && c.RootFsOverlay.SizeMib < 0 {
+		c.Errors = append(c.Errors, errors.New("rootfs overlay size must be >= 0"))
+	}
+
+	if c.ControlAuth != nil {
+		c.Errors = append(c.Errors, c.ControlAuth.validate()...)
 	}
 
-	if _, err := os.Stat(c.RootFsFilepath); errors.Is(err, os.ErrNotExist) {
-		c.Errors = append(c.Errors, err)
+	if c.AuditLog != nil {
+		c.Errors = append(c.Errors, c.AuditLog.validate()...)
+	}
+
+	if c.LogStream != nil {
+		c.Errors = append(c.Errors, c.LogStream.validate()...)
+	}
+
+	if c.ArtifactVerification != nil {
+		c.Errors = append(c.Errors, c.ArtifactVerification.validate()...)
+	}
+
+	if c.SelfUpdate != nil {
+		c.Errors = append(c.Errors, c.SelfUpdate.validate()...)
+	}
+
+	if c.TriggerSubjectPolicy != nil {
+		c.Errors = append(c.Errors, c.TriggerSubjectPolicy.validate()...)
+	}
+
+	if c.Webhooks != nil {
+		c.Errors = append(c.Errors, c.Webhooks.validate()...)
+	}
+
+	if c.EventStream != nil {
+		c.Errors = append(c.Errors, c.EventStream.validate()...)
+	}
+
+	if c.WorkloadRecovery != nil {
+		c.Errors = append(c.Errors, c.WorkloadRecovery.validate()...)
+	}
+
+	if r := c.UtilizationReports; r != nil && r.IntervalSeconds < 0 {
+		c.Errors = append(c.Errors, errors.New("utilization report interval must be >= 0"))
+	}
+
+	if s := c.Shutdown; s != nil && (s.Concurrency < 0 || s.TimeoutSeconds < 0) {
+		c.Errors = append(c.Errors, errors.New("shutdown concurrency and timeout must be >= 0"))
+	}
+
+	if r := c.AssetReaper; r != nil && (r.IntervalSeconds < 0 || r.RetentionSeconds < 0) {
+		c.Errors = append(c.Errors, errors.New("asset reaper interval and retention must be >= 0"))
+	}
+
+	if r := c.ZombieReaper; r != nil && r.IntervalSeconds < 0 {
+		c.Errors = append(c.Errors, errors.New("zombie reaper interval must be >= 0"))
+	}
+
+	if l := c.Cgroups; l != nil {
+		if l.Parent != "" && !filepath.IsAbs(l.Parent) {
+			c.Errors = append(c.Errors, fmt.Errorf("cgroup parent must be an absolute path: %s", l.Parent))
+		}
+		if l.MemoryOverheadMib < 0 {
+			c.Errors = append(c.Errors, errors.New("cgroup memory overhead must be >= 0"))
+		}
+		if l.CPUPercent < 0 || l.CPUPercent > 100 {
+			c.Errors = append(c.Errors, errors.New("cgroup cpu percent must be between 0 and 100"))
+		}
+	}
+
+	if c.WorkloadCredentials != nil {
+		if _, err := os.Stat(c.WorkloadCredentials.SigningKeyFile); errors.Is(err, os.ErrNotExist) {
+			c.Errors = append(c.Errors, err)
+		}
+
+		c.Errors = append(c.Errors, c.WorkloadCredentials.validate()...)
+	}
+
+	// kernel and rootfs are only required when agents are sandboxed in firecracker VMs
+	if c.NoSandbox {
+		return len(c.Errors) == 0
+	}
+
+	// the kernel and rootfs of the default template are fetched at startup when given as artifacts
+	if c.MachineTemplate.Kernel == nil {
+		if _, err := os.Stat(c.KernelFilepath); errors.Is(err, os.ErrNotExist) {
+			c.Errors = append(c.Errors, err)
+		}
+	}
+
+	if c.MachineTemplate.RootFs == nil {
+		if _, err := os.Stat(c.RootFsFilepath); errors.Is(err, os.ErrNotExist) {
+			c.Errors = append(c.Errors, err)
+		}
 	}
 
 	return len(c.Errors) == 0
@@ -82,10 +342,402 @@ type CNIDefinition struct {
 	NetworkName   *string  `json:"network_name"`
 }
 
-// Defines the CPU and memory usage of a machine to be configured when it is added to the pool
+// Configures verification of workload artifact signatures. Keys and certificates are PEM-encoded,
+// as used by cosign
+type ArtifactVerification struct {
+	// Paths to public keys trusted to sign workload artifacts
+	TrustedKeys []string `json:"trusted_keys,omitempty"`
+	// Paths to Fulcio root (and intermediate) certificates trusted for keyless signatures
+	FulcioRoots []string `json:"fulcio_roots,omitempty"`
+	// Identities (email or URI) keyless signing certificates must be issued to; required with
+	// fulcio roots
+	CertificateIdentities []string `json:"certificate_identities,omitempty"`
+	// OIDC issuer through which keyless signing certificates must have been issued, e.g.,
+	// https://token.actions.githubusercontent.com; required with fulcio roots
+	CertificateOIDCIssuer string `json:"certificate_oidc_issuer,omitempty"`
+	// Paths to public keys of the transparency logs (Rekor) trusted to timestamp keyless signatures
+	TransparencyLogKeys []string `json:"transparency_log_keys,omitempty"`
+	// Reject workloads whose artifacts aren't signed
+	Required bool `json:"required,omitempty"`
+	// Reject workloads whose artifacts don't have a signed attestation
+	RequireAttestation bool `json:"require_attestation,omitempty"`
+}
+
+// Enables minting short-lived NATS user credentials for workloads that request them. The
+// signing key must be a signing key of (or the key of) the account the workloads' users belong to
+type WorkloadCredentials struct {
+	// Path to the seed of the account signing key used to sign workload user JWTs
+	SigningKeyFile string `json:"signing_key_file"`
+	// Public key of the account, required when signing with a signing key rather than the account key
+	IssuerAccount *string `json:"issuer_account,omitempty"`
+	// Upper bound (and default) for the lifetime of workload credentials; defaults to an hour
+	MaxTTLSeconds int `json:"max_ttl_seconds,omitempty"`
+	// Subjects workload credentials may be granted permission to publish and subscribe on. A requested
+	// subject is only granted when one of these subjects (which may contain wildcards) covers it, so
+	// with none configured workloads are only granted their inboxes
+	PublishAllow   []string `json:"publish_allow,omitempty"`
+	SubscribeAllow []string `json:"subscribe_allow,omitempty"`
+	// When set, a revocation notice for the workload's user is published on this subject when the
+	// workload is undeployed, so that an operator-side integration can revoke the user
+	RevocationSubject *string `json:"revocation_subject,omitempty"`
+}
+
+// Configures a scanner run against each workload artifact after it has been cached and before it is
+// deployed. Artifacts a scanner rejects (or which can't be scanned) aren't deployed. A "command"
+// scanner runs the given command with the path of a copy of the artifact appended to its arguments,
+// e.g. clamscan, and rejects the artifact when the command exits with a non-zero status. A
+// "wasm_imports" scanner rejects WebAssembly modules importing functions matching one of its denied
+// imports or, if any allowed imports are given, not matching one of those. Imports are matched as
+// {module}.{name}, with * wildcards
+type ArtifactScannerConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Workload types the scanner applies to; defaults to all of them ("wasm" for wasm_imports)
+	WorkloadTypes  []string `json:"workload_types,omitempty"`
+	Command        []string `json:"command,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	AllowedImports []string `json:"allowed_imports,omitempty"`
+	DeniedImports  []string `json:"denied_imports,omitempty"`
+}
+
+// Joins the node to the cluster of nodes with the given name, whose members advertise themselves
+// to each other over NATS. The live member with the lowest node ID leads the cluster, deploying
+// workloads requested of the cluster to the member best able to run them
+type Cluster struct {
+	Name string `json:"name"`
+	// Path to the seed of an nkey shared by the cluster's members, which signs their gossip. Gossip
+	// not signed by it, or by the node key of the node it advertises, is ignored
+	SigningKeyFile string `json:"signing_key_file"`
+	// Defaults to 2 seconds
+	HeartbeatIntervalMillis int `json:"heartbeat_interval_ms,omitempty"`
+	// Members not heard from for this long are presumed gone; defaults to 3 heartbeat intervals
+	MemberTimeoutMillis int `json:"member_timeout_ms,omitempty"`
+	// How long the leader waits for the chosen node to deploy a workload; defaults to 30 seconds
+	DeployTimeoutSeconds int `json:"deploy_timeout_seconds,omitempty"`
+}
+
+// Verifies control requests whose JWTs are signed by keys other than nkeys. A "vault_transit" verifier has Vault verify JWTs signed by a transit key, which are
+// issued by vault:{mount}/{key}. The Vault address and token default to the VAULT_ADDR and
+// VAULT_TOKEN environment variables; a token file is re-read on each verification so that it may
+// be renewed by e.g. Vault Agent
+type ClaimsVerifierConfig struct {
+	Type      string `json:"type"`
+	Address   string `json:"address,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Defaults to "transit"
+	Mount     string `json:"mount,omitempty"`
+	Key       string `json:"key"`
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// Periodically looks for JetStream assets the node created for namespaces which no longer have
+// workloads on the node, i.e., host services key/value buckets and durable trigger consumers, and
+// which have seen no activity within the retention period. Stale assets are reported, and deleted
+// only if enabled
+type AssetReaper struct {
+	// Defaults to an hour
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+	// Defaults to a week
+	RetentionSeconds int  `json:"retention_seconds,omitempty"`
+	Delete           bool `json:"delete,omitempty"`
+}
+
+// Periodically looks for firecracker processes, CNI network attachments (and with them the
+// machines' tap devices) and run directory files left behind by machines the node isn't tracking,
+// e.g., after a crash. They're reported, and reaped unless in dry run mode
+type ZombieReaper struct {
+	// Defaults to 5 minutes
+	IntervalSeconds int  `json:"interval_seconds,omitempty"`
+	DryRun          bool `json:"dry_run,omitempty"`
+}
+
+// Periodically summarizes the utilization of the node and of each namespace with workloads on it,
+// e.g., daily, publishing each summary in a utilization_report event and, if a bucket is given,
+// storing it in that object store bucket as {node id}/{period end}.json
+type UtilizationReports struct {
+	// Defaults to a day
+	IntervalSeconds int    `json:"interval_seconds,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+}
+
+// Records every namespaced control API request, with its outcome, to a JetStream stream (created
+// if it doesn't exist, capturing $NEX.audit.{namespace}.{node}) and/or a local append-only file of
+// JSON lines. The stream keeps entries for at most the given age and size; the file is rotated to
+// {file}.1 when it reaches the given size
+type AuditLog struct {
+	Stream string `json:"stream,omitempty"`
+	File   string `json:"file,omitempty"`
+	// Defaults to 90 days
+	MaxAgeSeconds int   `json:"max_age_seconds,omitempty"`
+	MaxBytes      int64 `json:"max_bytes,omitempty"`
+}
+
+// Persists the logs of workloads to a JetStream stream (created if it doesn't exist, capturing
+// $NEX.logs.>), so that they can be queried across nodes after the fact. The stream keeps entries
+// for at most the given age and size
+type LogStream struct {
+	Stream string `json:"stream"`
+	// Defaults to 7 days
+	MaxAgeSeconds int   `json:"max_age_seconds,omitempty"`
+	MaxBytes      int64 `json:"max_bytes,omitempty"`
+}
+
+// Records the workloads the node is running in a local directory, so that once the node restarts
+// it redeploys them, whether it was stopped or crashed. The node's xkey is kept in the directory
+// too, so that the encrypted environments of recorded workloads can still be decrypted
+type WorkloadRecovery struct {
+	Directory string `json:"directory"`
+}
+
+// Bounds how the node stops its machines as it shuts down. Machines are stopped concurrently, and
+// any still stopping when the timeout expires are reported as having failed to stop
+type Shutdown struct {
+	// Number of machines stopped at once; defaults to 8
+	Concurrency int `json:"concurrency,omitempty"`
+	// Defaults to 60 seconds
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// Starts the node in standby: registered and answering control requests, but keeping no warm
+// machines until it's activated, e.g. to keep burst capacity hosts cheap until they're needed.
+// Activating the node warms its machine pools to their configured size
+type Standby struct {
+	// Activate the node when it's sent a deploy request, rather than rejecting the request
+	ActivateOnDeploy bool `json:"activate_on_deploy,omitempty"`
+}
+
+// Limits the trigger subjects workloads may subscribe to, beyond the $NEX, $JS and $SYS prefixes
+// they may never overlap
+type TriggerSubjectPolicy struct {
+	// Further subject prefixes, e.g. "_INBOX" or "orders.internal", trigger subjects may not overlap
+	ReservedPrefixes []string `json:"reserved_prefixes,omitempty"`
+	// Literal tokens a trigger subject must begin with before any wildcard; defaults to 1
+	MinLiteralTokens int `json:"min_literal_tokens,omitempty"`
+	// Wildcard tokens (* or >) a trigger subject may contain; unlimited when omitted
+	MaxWildcards *int `json:"max_wildcards,omitempty"`
+	// Rejects trigger subjects ending in the > wildcard
+	DenyFullWildcard bool `json:"deny_full_wildcard,omitempty"`
+	// Permits workloads to subscribe to trigger subjects already claimed by other workloads
+	AllowShared bool `json:"allow_shared,omitempty"`
+}
+
+// Receives inbound webhooks: HTTP POSTs to {public_url}/webhooks/{namespace}/{workload} which
+// trigger functions deployed with a webhook, so that external services can invoke them directly
+type WebhookReceiver struct {
+	// TCP address (host:port) the receiver listens on
+	Listen string `json:"listen"`
+	// URL at which callers reach the receiver, e.g. through a load balancer, from which the
+	// webhook URLs given in run responses are formed; omitted from run responses when empty
+	PublicURL string `json:"public_url,omitempty"`
+	// Largest request body accepted; defaults to 1 MiB
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// Certificate and key with which the receiver serves HTTPS rather than HTTP
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+}
+
+// Serves a WebSocket endpoint streaming the control API's events and workload logs as JSON frames,
+// for web dashboards which don't embed a NATS client. Since they're read from NATS, the events and
+// logs of every node are streamed, not only those of the node serving the endpoint
+type EventStreamEndpoint struct {
+	// TCP address (host:port) the endpoint listens on
+	Listen string `json:"listen"`
+	// Maps the hex-encoded SHA-256 digests of the bearer tokens permitted to connect to the
+	// namespace whose events and logs they may stream, or * for every namespace
+	Tokens map[string]string `json:"tokens"`
+	// Origins from which browsers may connect, or * for any; when empty, only pages served from
+	// the endpoint's own origin may
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	// Certificate and key with which the endpoint serves WSS rather than WS
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+}
+
+// Permits the node to be updated over the control API to a nex binary retrieved from an object
+// store, provided its signature is verified by the given keys (or Fulcio roots). Once the binary
+// has been installed the node shuts down and either execs into it, keeping its identity, or exits
+// to be restarted by its supervisor
+type SelfUpdate struct {
+	// Either "exec" (the default) or "supervisor"
+	Restart      string               `json:"restart,omitempty"`
+	Verification ArtifactVerification `json:"verification"`
+}
+
+// Authorizes namespaced control API requests by the NATS identity of their sender, as described
+// by the requester info the NATS server shares with requests crossing into the node's account
+// through a service import. Senders may operate on the namespaces mapped to their account and
+// those named by their user JWT's namespace tags, e.g., as issued by an auth callout service
+type ControlAuth struct {
+	// Reject namespaced requests which carry no requester info
+	Required bool `json:"required,omitempty"`
+	// Namespaces the users of each account, by public key or name, may operate on; "*" permits all
+	Accounts map[string][]string `json:"accounts,omitempty"`
+	// Prefix of the user JWT tags naming a namespace; defaults to "nex_namespace:"
+	NamespaceTag string `json:"namespace_tag,omitempty"`
+	// Roles (viewer, deployer or operator) of requesters in each namespace, keyed by the public
+	// key or name of their user or account; roles in "*" apply to every namespace. Once roles
+	// are assigned here or in the role bucket, requesters need a role permitting each operation
+	Roles map[string]map[string]string `json:"roles,omitempty"`
+	// Key-value bucket holding the roles assigned with ROLES requests
+	RoleBucket string `json:"role_bucket,omitempty"`
+}
+
+// Named bundles of the host services ("http", "kv", "messaging", "objectstore" and "secrets")
+// exposed to v8 and wasm workloads, in addition to the built-in "pure-compute" (none), "kv-only"
+// and "full" profiles. Deploy requests may pick a profile; namespaces may be limited to some
+// profiles, the first of which is their default
+type SandboxProfiles struct {
+	Profiles map[string][]string `json:"profiles,omitempty"`
+	// Profile of workloads which don't pick one; defaults to "full"
+	Default    string              `json:"default,omitempty"`
+	Namespaces map[string][]string `json:"namespaces,omitempty"`
+}
+
+// Resolves references to secrets held by external providers, e.g. vault://secret/db or
+// aws-sm://prod/api-key, so that workloads can be given secrets whose values never travel through
+// the control API. Each provider is keyed by the scheme of the references it resolves, and is a
+// plugin command run with the reference appended to its arguments, which writes the secret's
+// value to stdout and exits with a non-zero status when it can't be resolved
+type Secrets struct {
+	Providers map[string]SecretProvider `json:"providers,omitempty"`
+}
+
+type SecretProvider struct {
+	Command []string `json:"command"`
+	// Defaults to 10 seconds
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Namespaces whose workloads may resolve references with the provider; defaults to all of them
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Enables stable IP addresses and DNS names for long-running service workloads (elf and oci). Stable
+// IPs are leased to workloads, by namespace and name, from the lease network, which must lie within
+// the range the CNI network's (host-local) IPAM plugin allocates from, but away from the addresses
+// it hands out to pooled machines, e.g. at the end of the range. DNS names, {dns_name}.{namespace}.{domain},
+// are answered by a built-in responder and/or registered by an external provider hook
+type ServiceNetworking struct {
+	LeaseCIDR string `json:"lease_cidr,omitempty"`
+	// Defaults to nex.internal
+	Domain string `json:"domain,omitempty"`
+	// UDP address (host:port) the built-in DNS responder listens on; disabled when empty
+	DNSListen string `json:"dns_listen,omitempty"`
+	// Command run when a DNS name is registered or deregistered, with the action ("add" or
+	// "remove"), the name and the address appended to its arguments
+	DNSHook []string `json:"dns_hook,omitempty"`
+}
+
+// Boots machines from their template's rootfs, shared read-only between them, rather than from a
+// copy of it made for each machine. The rootfs' overlay init mounts a writable tmpfs overlay over
+// it, whose size is drawn from the machine's memory, so nothing written by a workload outlives its
+// machine. Requires a rootfs with /sbin/overlay-init and a kernel with overlayfs support
+type RootFsOverlay struct {
+	// Defaults to 64 MiB
+	SizeMib int `json:"size_mib,omitempty"`
+}
+
+// Enables persistent volumes for service workloads (elf and oci), attached to their machines as a
+// second drive and mounted at /home/nex/volume. Each workload's volume is provisioned when first
+// requested, either as a sparse file beneath the directory or as a logical volume in the volume
+// group, and is attached again whenever the workload is redeployed. Volumes must be deleted by the
+// operator; the node never deletes them
+type Volumes struct {
+	// Either "file" (the default) or "lvm"
+	Type        string `json:"type,omitempty"`
+	Directory   string `json:"directory,omitempty"`
+	VolumeGroup string `json:"volume_group,omitempty"`
+	// Defaults to 1024 MiB
+	MaxSizeMib int `json:"max_size_mib,omitempty"`
+}
+
+// Places each firecracker process in its own cgroup (v2) beneath the given parent, limiting its CPU
+// and memory to those of its machine. The node must be able to enable the cpu and memory
+// controllers for the parent's children
+type CgroupLimits struct {
+	// Path of the parent cgroup; defaults to /sys/fs/cgroup/nex
+	Parent string `json:"parent,omitempty"`
+	// Memory the firecracker process may use beyond its machine's memory, for the VMM itself;
+	// defaults to 64 MiB
+	MemoryOverheadMib int `json:"memory_overhead_mib,omitempty"`
+	// Share of each of its vCPUs the machine may use; defaults to 100
+	CPUPercent int `json:"cpu_percent,omitempty"`
+}
+
+// Defines the CPU and memory usage of a machine to be configured when it is added to the pool. A
+// template may also declare the kernel and root filesystem its machines boot from; otherwise the
+// node's kernel_filepath and rootfs_filepath are used. Named templates inherit any unset CPU and
+// memory settings from the node's default template
 type MachineTemplate struct {
-	VcpuCount  *int `json:"vcpu_count"`
-	MemSizeMib *int `json:"memsize_mib"`
+	VcpuCount  *int             `json:"vcpu_count"`
+	MemSizeMib *int             `json:"memsize_mib"`
+	Kernel     *MachineArtifact `json:"kernel,omitempty"`
+	RootFs     *MachineArtifact `json:"rootfs,omitempty"`
+}
+
+// A machine size of which the node keeps a pool of warm machines (booted from its default machine
+// template), in addition to the pool of default machines. Deploy requests which specify a machine
+// size are given a machine from the pool of the smallest size class that fits
+type MachineSizeClass struct {
+	VcpuCount  int `json:"vcpu_count"`
+	MemSizeMib int `json:"memsize_mib"`
+	PoolSize   int `json:"pool_size"`
+}
+
+// Bounds the machine sizes deploy requests may specify. Deploy requests may only specify a
+// machine size when the node configures these limits
+type MachineSizeLimits struct {
+	MinVcpuCount  int `json:"min_vcpu_count"`
+	MaxVcpuCount  int `json:"max_vcpu_count"`
+	MinMemSizeMib int `json:"min_memsize_mib"`
+	MaxMemSizeMib int `json:"max_memsize_mib"`
+}
+
+// A kernel or root filesystem image, fetched and cached by the node at startup. The URL is either an
+// object in a JetStream object store (nats://{bucket}/{key}), an HTTPS URL, or a local file path
+type MachineArtifact struct {
+	URL string `json:"url"`
+	// Hex-encoded SHA-256 digest the artifact must match. Required for HTTPS URLs
+	Digest *string `json:"digest,omitempty"`
+	// JetStream domain of the object store, if not the node's own
+	JsDomain *string `json:"jsdomain,omitempty"`
+}
+
+func (a *MachineArtifact) validate() error {
+	u, err := url.Parse(a.URL)
+	if err != nil || a.URL == "" {
+		return fmt.Errorf("invalid machine artifact url: %s", a.URL)
+	}
+
+	switch u.Scheme {
+	case "nats":
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return fmt.Errorf("machine artifact url must identify an object store bucket and key: %s", a.URL)
+		}
+	case "https":
+		if a.Digest == nil {
+			return fmt.Errorf("machine artifact fetched over https requires a digest: %s", a.URL)
+		}
+	case "", "file":
+	default:
+		return fmt.Errorf("unsupported machine artifact url scheme: %s", u.Scheme)
+	}
+
+	if a.Digest != nil && !validSha256Digest.MatchString(strings.ToLower(*a.Digest)) {
+		return fmt.Errorf("machine artifact digest must be a hex-encoded sha256 digest: %s", *a.Digest)
+	}
+
+	return nil
+}
+
+// Returns the node's default machine template along with its named templates, keyed by name.
+// The default template is keyed by DefaultMachineTemplate
+func (c *NodeConfiguration) allMachineTemplates() map[string]MachineTemplate {
+	templates := map[string]MachineTemplate{
+		DefaultMachineTemplate: c.MachineTemplate,
+	}
+	for name, template := range c.MachineTemplates {
+		templates[name] = template
+	}
+	return templates
 }
 
 type TokenBucket struct {
@@ -118,17 +770,67 @@ func DefaultNodeConfiguration() NodeConfiguration {
 		},
 		// CAUTION: This needs to be the IP of the node server's internal NATS --as visible to the inside of the firecracker VM--. This is not necessarily the address
 		// on which the internal NATS server is actually listening on inside the node.
-		InternalNodeHost: agentapi.StringOrNil(defaultInternalNodeHost),
-		InternalNodePort: &defaultNodePort,
-		MachinePoolSize:  1,
+		CapacityRefreshIntervalMillis: defaultCapacityRefreshIntervalMillis,
+		InternalNodeHost:              agentapi.StringOrNil(defaultInternalNodeHost),
+		InternalNodePort:              &defaultNodePort,
+		MachinePoolSize:               1,
 		MachineTemplate: MachineTemplate{
 			VcpuCount:  &defaultVcpuCount,
 			MemSizeMib: &defaultMemSizeMib,
 		},
-		Tags:          make(map[string]string),
-		RateLimiters:  nil,
-		WorkloadTypes: defaultWorkloadTypes,
+		Tags:                    make(map[string]string),
+		RateLimiters:            nil,
+		RunDirectoryCleanup:     RunDirectoryCleanupOnStop,
+		TriggerFailureThreshold: defaultTriggerFailureThreshold,
+		WorkloadTypes:           defaultWorkloadTypes,
+	}
+}
+
+// Returns the directory in which the node keeps machine sockets, firecracker logs and other
+// scratch files. Defaults to the system temp dir
+func (c *NodeConfiguration) runDirectory() string {
+	if c.RunDirectory != "" {
+		return c.RunDirectory
 	}
+	return os.TempDir()
+}
+
+// Returns the number of machines the node stops at once as it shuts down
+func (c *NodeConfiguration) shutdownConcurrency() int {
+	if c.Shutdown != nil && c.Shutdown.Concurrency > 0 {
+		return c.Shutdown.Concurrency
+	}
+	return defaultShutdownConcurrency
+}
+
+// Returns how long the node waits for its machines to stop as it shuts down
+func (c *NodeConfiguration) shutdownTimeout() time.Duration {
+	if c.Shutdown != nil && c.Shutdown.TimeoutSeconds > 0 {
+		return time.Duration(c.Shutdown.TimeoutSeconds) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// Ensures the run directory exists (creating it if necessary) and is writable by the node. Note
+// that the run directory may be mounted noexec, as nothing is ever executed from it
+func validateRunDirectory(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("run directory must be an absolute path: %s", dir)
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create run directory: %s", err)
+	}
+
+	f, err := os.CreateTemp(dir, ".nex-probe-*")
+	if err != nil {
+		return fmt.Errorf("run directory is not writable: %s", err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return nil
 }
 
 // Reads the node configuration from the specified configuration file path
@@ -148,23 +850,27 @@ func LoadNodeConfiguration(configFilepath string) (*NodeConfiguration, error) {
 		config.WorkloadTypes = defaultWorkloadTypes
 	}
 
+	if config.Tags == nil {
+		config.Tags = make(map[string]string)
+	}
+
+	if config.NoSandbox {
+		return &config, nil
+	}
+
 	// TODO-- audit for *string
 	if config.KernelFilepath == "" && config.DefaultResourceDir != "" {
 		config.KernelFilepath = filepath.Join(config.DefaultResourceDir, "vmlinux")
-	} else if config.KernelFilepath == "" && config.DefaultResourceDir == "" {
+	} else if config.KernelFilepath == "" && config.DefaultResourceDir == "" && config.MachineTemplate.Kernel == nil {
 		return nil, errors.New("invalid kernel file setting")
 	}
 
 	// TODO-- audit for *string
 	if config.RootFsFilepath == "" && config.DefaultResourceDir != "" {
 		config.RootFsFilepath = filepath.Join(config.DefaultResourceDir, "rootfs.ext4")
-	} else if config.RootFsFilepath == "" && config.DefaultResourceDir == "" {
+	} else if config.RootFsFilepath == "" && config.DefaultResourceDir == "" && config.MachineTemplate.RootFs == nil {
 		return nil, errors.New("invalid rootfs file setting")
 	}
 
-	if config.Tags == nil {
-		config.Tags = make(map[string]string)
-	}
-
 	return &config, nil
 }
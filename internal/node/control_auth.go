@@ -0,0 +1,170 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	defaultNamespaceTag = "nex_namespace:"
+
+	// Permits the users of an account to operate on any namespace
+	anyNamespace = "*"
+)
+
+func (c *ControlAuth) validate() []error {
+	errs := make([]error, 0)
+
+	for account, namespaces := range c.Accounts {
+		if len(namespaces) == 0 {
+			errs = append(errs, fmt.Errorf("account %s must be mapped to at least one namespace", account))
+		}
+		for _, namespace := range namespaces {
+			if namespace == "" || strings.ContainsAny(namespace, ".> ") {
+				errs = append(errs, fmt.Errorf("account %s is mapped to an invalid namespace: %q", account, namespace))
+			}
+		}
+	}
+
+	if c.NamespaceTag != "" && c.NamespaceTag != strings.ToLower(c.NamespaceTag) {
+		errs = append(errs, errors.New("namespace tag must be lowercase, as user JWT tags are"))
+	}
+
+	errs = append(errs, validateRoles(c.Roles)...)
+
+	return errs
+}
+
+func (c *ControlAuth) namespaceTag() string {
+	if c.NamespaceTag != "" {
+		return c.NamespaceTag
+	}
+	return defaultNamespaceTag
+}
+
+// Returns the namespaces the requester may operate on, as mapped to its account and named by
+// its user's tags
+func (c *ControlAuth) namespaces(info *server.ClientInfo) []string {
+	namespaces := slices.Clone(c.Accounts[info.Account])
+	if info.NameTag != "" {
+		namespaces = append(namespaces, c.Accounts[info.NameTag]...)
+	}
+
+	for _, tag := range info.Tags {
+		if namespace, ok := strings.CutPrefix(tag, c.namespaceTag()); ok && namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	return namespaces
+}
+
+// Wraps the handler of a namespaced control API operation, rejecting requests whose sender isn't
+// permitted to operate on the namespace in the request subject. Requests are passed through as is
+// when the node has no control auth configured
+func (api *ApiListener) authorize(responseType string, handler nats.MsgHandler) nats.MsgHandler {
+	if api.config.ControlAuth == nil {
+		return handler
+	}
+
+	return func(m *nats.Msg) {
+		namespace, err := extractNamespace(m.Subject)
+		if err != nil {
+			respondFail(responseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for control request")
+			return
+		}
+
+		if api.permitted(responseType, m, namespace) {
+			handler(m)
+		}
+	}
+}
+
+// Wraps the handler of a control API operation affecting the whole node, rejecting requests whose
+// sender isn't permitted to operate on every namespace
+func (api *ApiListener) authorizeNode(responseType string, handler nats.MsgHandler) nats.MsgHandler {
+	if api.config.ControlAuth == nil {
+		return handler
+	}
+
+	return func(m *nats.Msg) {
+		if api.permitted(responseType, m, anyNamespace) {
+			handler(m)
+		}
+	}
+}
+
+// Returns whether the sender of the request may operate on the namespace, responding with a
+// failure if not
+func (api *ApiListener) permitted(responseType string, m *nats.Msg, namespace string) bool {
+	auth := api.config.ControlAuth
+
+	var raw string
+	if m.Header != nil {
+		raw = m.Header.Get(server.ClientInfoHdr)
+	}
+
+	if raw == "" {
+		if auth.Required {
+			api.log.Warn("Rejected control request without requester info",
+				slog.String("subject", m.Subject),
+			)
+			respondFail(responseType, m, controlapi.ErrorCodeUnauthorized, "Unauthorized: requester info is required")
+			return false
+		}
+
+		return true
+	}
+
+	var info server.ClientInfo
+	err := json.Unmarshal([]byte(raw), &info)
+	if err != nil {
+		respondFail(responseType, m, controlapi.ErrorCodeUnauthorized, "Unauthorized: invalid requester info")
+		return false
+	}
+
+	namespaces := auth.namespaces(&info)
+	if !slices.Contains(namespaces, namespace) && !slices.Contains(namespaces, anyNamespace) {
+		api.log.Warn("Rejected control request for namespace not permitted to requester",
+			slog.String("subject", m.Subject),
+			slog.String("namespace", namespace),
+			slog.String("account", info.Account),
+			slog.String("user", info.User),
+		)
+
+		reason := fmt.Sprintf("Unauthorized: not permitted to operate on namespace %s", namespace)
+		if namespace == anyNamespace {
+			reason = "Unauthorized: not permitted to operate on the node"
+		}
+		respondFail(responseType, m, controlapi.ErrorCodeUnauthorized, reason)
+		return false
+	}
+
+	if auth.rolesEnabled() {
+		required := requiredRole(m.Subject)
+		role := api.requesterRole(&info, namespace)
+		if roleRanks[role] < roleRanks[required] {
+			api.log.Warn("Rejected control request from requester without the required role",
+				slog.String("subject", m.Subject),
+				slog.String("namespace", namespace),
+				slog.String("account", info.Account),
+				slog.String("user", info.User),
+				slog.String("role", role),
+				slog.String("required_role", required),
+			)
+
+			respondFail(responseType, m, controlapi.ErrorCodeUnauthorized, fmt.Sprintf("Unauthorized: the %s role is required for this operation on namespace %s", required, namespace))
+			return false
+		}
+	}
+
+	return true
+}
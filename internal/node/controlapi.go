@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"runtime"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -15,6 +19,7 @@ import (
 	"github.com/pkg/errors"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
 )
 
 // The API listener is the command and control interface for the node server
@@ -25,6 +30,27 @@ type ApiListener struct {
 	start  time.Time
 	xk     nkeys.KeyPair
 	config *NodeConfiguration
+
+	// number of deploy requests currently being handled
+	pendingDeploys int32
+	// most recent snapshot of the node's free capacity
+	capacity atomic.Pointer[controlapi.NodeCapacity]
+
+	// the namespace holding an exclusive reservation of the node, if any; see reservation.go
+	reservation      *controlapi.NodeReservation
+	reservationMutex sync.Mutex
+
+	// resumes machine creation once the current pause expires, if any; see pause.go
+	pauseTimer *time.Timer
+	pauseMutex sync.Mutex
+
+	// records namespaced requests; see audit_log.go
+	audit *auditLog
+
+	// set while the node is being updated; installed updates are handed to the node, which
+	// restarts into them once it has shut down. See self_update.go
+	updating atomic.Bool
+	updates  chan *nodeUpdate
 }
 
 func NewApiListener(log *slog.Logger, mgr *MachineManager, config *NodeConfiguration) *ApiListener {
@@ -32,8 +58,26 @@ func NewApiListener(log *slog.Logger, mgr *MachineManager, config *NodeConfigura
 	efftags[controlapi.TagOS] = runtime.GOOS
 	efftags[controlapi.TagArch] = runtime.GOARCH
 	efftags[controlapi.TagCPUs] = strconv.FormatInt(int64(runtime.NumCPU()), 10)
+	if config.JsDomain != nil {
+		efftags[controlapi.TagJsDomain] = *config.JsDomain
+	}
+	if len(config.MachineTemplates) > 0 {
+		templates := make([]string, 0, len(config.MachineTemplates))
+		for name := range config.MachineTemplates {
+			templates = append(templates, name)
+		}
+		sort.Strings(templates)
+		efftags[controlapi.TagMachineTemplates] = strings.Join(templates, ",")
+	}
 
-	kp, err := nkeys.CreateCurveKeys()
+	var kp nkeys.KeyPair
+	var err error
+	if mgr.workloadState != nil {
+		// recorded workloads' environments are encrypted for the xkey the node had when they were deployed
+		kp, err = mgr.workloadState.xkey()
+	} else {
+		kp, err = nkeys.CreateCurveKeys()
+	}
 	if err != nil {
 		log.Error("Failed to create x509 curve key", slog.Any("err", err))
 		return nil
@@ -47,12 +91,13 @@ func NewApiListener(log *slog.Logger, mgr *MachineManager, config *NodeConfigura
 	log.Info("Use this key as the recipient for encrypted run requests", slog.String("public_xkey", xkPub))
 
 	return &ApiListener{
-		mgr:    mgr,
-		log:    log,
-		nodeId: mgr.publicKey,
-		xk:     kp,
-		start:  time.Now().UTC(),
-		config: config,
+		mgr:     mgr,
+		log:     log,
+		nodeId:  mgr.publicKey,
+		xk:      kp,
+		start:   time.Now().UTC(),
+		config:  config,
+		updates: make(chan *nodeUpdate, 1),
 	}
 }
 
@@ -61,32 +106,128 @@ func (api *ApiListener) PublicKey() string {
 }
 
 func (api *ApiListener) Start() error {
-	_, err := api.mgr.nc.Subscribe(controlapi.APIPrefix+".PING", api.handlePing)
+	var err error
+	api.audit, err = newAuditLog(api.config.AuditLog, api.mgr.nc, api.log)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PING", api.negotiated(controlapi.PingResponseType, api.handlePing))
 	if err != nil {
 		api.log.Error("Failed to subscribe to ping subject", slog.Any("err", err), slog.String("id", api.nodeId))
 	}
 
-	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PING."+api.nodeId, api.handlePing)
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PING."+api.nodeId, api.negotiated(controlapi.PingResponseType, api.handlePing))
 	if err != nil {
 		api.log.Error("Failed to subscribe to node-specific ping subject", slog.Any("err", err), slog.String("id", api.nodeId))
 	}
 
 	// Namespaced subscriptions, the * below is for the namespace
-	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".INFO.*."+api.nodeId, api.handleInfo)
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".INFO.*."+api.nodeId, api.audited(api.negotiated(controlapi.InfoResponseType, api.authorize(controlapi.InfoResponseType, api.handleInfo))))
 	if err != nil {
 		api.log.Error("Failed to subscribe to info subject", slog.Any("err", err), slog.String("id", api.nodeId))
 	}
 
-	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".DEPLOY.*."+api.nodeId, api.handleDeploy)
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".DEPLOY.*."+api.nodeId, api.audited(api.negotiated(controlapi.RunResponseType, api.authorize(controlapi.RunResponseType, api.handleDeploy))))
 	if err != nil {
 		api.log.Error("Failed to subscribe to run subject", slog.Any("err", err), slog.String("id", api.nodeId))
 	}
 
-	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".STOP.*."+api.nodeId, api.handleStop)
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".STOP.*."+api.nodeId, api.audited(api.negotiated(controlapi.StopResponseType, api.authorize(controlapi.StopResponseType, api.handleStop))))
 	if err != nil {
 		api.log.Error("Failed to subscribe to stop subject", slog.Any("err", err), slog.String("id", api.nodeId))
 	}
 
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".STOPALL.*."+api.nodeId, api.audited(api.negotiated(controlapi.BulkStopResponseType, api.authorize(controlapi.BulkStopResponseType, api.handleBulkStop))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to bulk stop subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".UPDATEWORKLOAD.*."+api.nodeId, api.audited(api.negotiated(controlapi.WorkloadUpdateResponseType, api.authorize(controlapi.WorkloadUpdateResponseType, api.handleWorkloadUpdate))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to workload update subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PROMOTE.*."+api.nodeId, api.audited(api.negotiated(controlapi.WorkloadUpdateResponseType, api.authorize(controlapi.WorkloadUpdateResponseType, api.handlePromote))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to workload promote subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".TIMELINE.*."+api.nodeId, api.audited(api.negotiated(controlapi.TimelineResponseType, api.authorize(controlapi.TimelineResponseType, api.handleTimeline))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to timeline subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".DESCRIBE.*."+api.nodeId, api.audited(api.negotiated(controlapi.DescribeResponseType, api.authorize(controlapi.DescribeResponseType, api.handleDescribe))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to describe subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".SUBJECTS.*."+api.nodeId, api.audited(api.negotiated(controlapi.SubjectsResponseType, api.authorize(controlapi.SubjectsResponseType, api.handleSubjects))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to subjects subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".MEMORY.*."+api.nodeId, api.audited(api.negotiated(controlapi.MemoryResponseType, api.authorize(controlapi.MemoryResponseType, api.handleMemory))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to memory subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".RESERVE.*."+api.nodeId, api.audited(api.negotiated(controlapi.ReserveResponseType, api.authorize(controlapi.ReserveResponseType, api.handleReserve))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to reserve subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".AUDIT.*."+api.nodeId, api.audited(api.negotiated(controlapi.AuditResponseType, api.authorize(controlapi.AuditResponseType, api.handleAudit))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to audit subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".ROLES.*."+api.nodeId, api.audited(api.negotiated(controlapi.RoleResponseType, api.authorize(controlapi.RoleResponseType, api.handleRoles))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to roles subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".UPDATE."+api.nodeId, api.audited(api.negotiated(controlapi.NodeUpdateResponseType, api.authorizeNode(controlapi.NodeUpdateResponseType, api.handleUpdate))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to update subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".STANDBY."+api.nodeId, api.audited(api.negotiated(controlapi.StandbyResponseType, api.authorizeNode(controlapi.StandbyResponseType, api.handleStandby))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to standby subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PAUSE."+api.nodeId, api.audited(api.negotiated(controlapi.PauseResponseType, api.authorizeNode(controlapi.PauseResponseType, api.handlePause))))
+	if err != nil {
+		api.log.Error("Failed to subscribe to pause subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PREFLIGHT", api.negotiated(controlapi.PreflightResponseType, api.handlePreflight))
+	if err != nil {
+		api.log.Error("Failed to subscribe to preflight subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	_, err = api.mgr.nc.Subscribe(controlapi.APIPrefix+".PREFLIGHT."+api.nodeId, api.negotiated(controlapi.PreflightResponseType, api.handlePreflight))
+	if err != nil {
+		api.log.Error("Failed to subscribe to node-specific preflight subject", slog.Any("err", err), slog.String("id", api.nodeId))
+	}
+
+	if api.config.CapacityRefreshIntervalMillis > 0 {
+		go api.advertiseCapacity()
+	}
+
+	if api.config.Cluster != nil {
+		err = api.joinCluster()
+		if err != nil {
+			return err
+		}
+	}
+
+	if api.mgr.workloadState != nil {
+		go api.recoverWorkloads()
+	}
+
 	api.log.Info("NATS execution engine awaiting commands", slog.String("id", api.nodeId), slog.String("version", VERSION))
 	return nil
 }
@@ -95,46 +236,55 @@ func (api *ApiListener) handleStop(m *nats.Msg) {
 	namespace, err := extractNamespace(m.Subject)
 	if err != nil {
 		api.log.Error("Invalid subject for workload stop", slog.Any("err", err))
-		respondFail(controlapi.StopResponseType, m, "Invalid subject for workload stop")
+		respondFail(controlapi.StopResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for workload stop")
 		return
 	}
 
 	var request controlapi.StopRequest
-	err = json.Unmarshal(m.Data, &request)
+	err = schema.Unmarshal(m.Data, &request)
 	if err != nil {
 		api.log.Error("Failed to deserialize stop request", slog.Any("err", err))
-		respondFail(controlapi.StopResponseType, m, fmt.Sprintf("Unable to deserialize stop request: %s", err))
+		respondInvalid(controlapi.StopResponseType, m, fmt.Sprintf("Unable to deserialize stop request: %s", err), err)
 		return
 	}
 
-	vm := api.mgr.LookupMachine(request.WorkloadId)
-	if vm == nil {
-		api.log.Error("Stop request: no such workload", slog.String("vmid", request.WorkloadId))
-		respondFail(controlapi.StopResponseType, m, "No such workload")
-		return
+	vm, err := api.mgr.lookupNamespacedMachine(request.WorkloadId, namespace)
+	if errors.Is(err, ErrMachineNotFound) {
+		if fn := api.mgr.lookupIdleFunction(request.WorkloadId); fn != nil && fn.namespace == namespace {
+			api.stopIdleFunction(m, fn, &request)
+			return
+		}
 	}
-
-	if vm.namespace != namespace {
-		api.log.Error("Namespace mismatch on workload stop request",
-			slog.String("namespace", vm.namespace),
+	if err != nil {
+		api.log.Error("Stop request: no such workload",
+			slog.String("vmid", request.WorkloadId),
 			slog.String("targetnamespace", namespace),
+			slog.Any("err", err),
 		)
 
-		respondFail(controlapi.StopResponseType, m, "No such workload") // do not expose ID existence to avoid existence probes
+		respondError(controlapi.StopResponseType, m, controlapi.ErrorCodeNotFound, "No such workload", err) // do not expose ID existence to avoid existence probes
 		return
 	}
 
-	err = request.Validate(&vm.deployRequest.DecodedClaims)
+	err = request.Validate(&vm.deployRequest.DecodedClaims, newClaimsVerifiers(api.mgr.config.ClaimsVerifiers)...)
 	if err != nil {
 		api.log.Error("Failed to validate stop request", slog.Any("err", err))
-		respondFail(controlapi.StopResponseType, m, fmt.Sprintf("Invalid stop request: %s", err))
+		respondFail(controlapi.StopResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid stop request: %s", err))
 		return
 	}
 
+	api.mgr.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, "Stop requested")
 	err = api.mgr.StopMachine(request.WorkloadId, true)
 	if err != nil {
 		api.log.Error("Failed to stop workload", slog.Any("err", err))
-		respondFail(controlapi.StopResponseType, m, fmt.Sprintf("Failed to stop workload: %s", err))
+		respondError(controlapi.StopResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to stop workload: %s", err), err)
+		return
+	}
+
+	if vm.deployRequest.StableIP {
+		// the lease is kept while the workload is redeployed, or if it failed to stop, but not once
+		// it's been stopped
+		api.mgr.serviceNetwork.release(namespace, vm.deployRequest.DecodedClaims.Subject)
 	}
 
 	res := controlapi.NewEnvelope(controlapi.StopResponseType, controlapi.StopResponse{
@@ -151,73 +301,569 @@ func (api *ApiListener) handleStop(m *nats.Msg) {
 	}
 }
 
+// Stops a function which has been scaled to zero (or has been cold-started into a machine other
+// than the one it was originally deployed to)
+func (api *ApiListener) stopIdleFunction(m *nats.Msg, fn *idleFunction, request *controlapi.StopRequest) {
+	err := request.Validate(&fn.request.DecodedClaims, newClaimsVerifiers(api.mgr.config.ClaimsVerifiers)...)
+	if err != nil {
+		api.log.Error("Failed to validate stop request", slog.Any("err", err))
+		respondFail(controlapi.StopResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid stop request: %s", err))
+		return
+	}
+
+	api.mgr.stopIdleFunction(fn)
+
+	res := controlapi.NewEnvelope(controlapi.StopResponseType, controlapi.StopResponse{
+		Stopped:   true,
+		Name:      fn.request.DecodedClaims.Subject,
+		Issuer:    fn.request.DecodedClaims.Issuer,
+		MachineId: fn.id,
+	}, nil)
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal stop response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func (api *ApiListener) handleBulkStop(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Invalid subject for bulk workload stop", slog.Any("err", err))
+		respondFail(controlapi.BulkStopResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for bulk workload stop")
+		return
+	}
+
+	var request controlapi.BulkStopRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize bulk stop request", slog.Any("err", err))
+		respondInvalid(controlapi.BulkStopResponseType, m, fmt.Sprintf("Unable to deserialize bulk stop request: %s", err), err)
+		return
+	}
+
+	claims, err := request.Validate(newClaimsVerifiers(api.mgr.config.ClaimsVerifiers)...)
+	if err != nil {
+		api.log.Error("Failed to validate bulk stop request", slog.Any("err", err))
+		respondFail(controlapi.BulkStopResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid bulk stop request: %s", err))
+		return
+	}
+
+	results := make([]controlapi.BulkStopResult, 0)
+	for _, vm := range api.mgr.matchingMachines(namespace, request.Matches) {
+		result := controlapi.BulkStopResult{
+			MachineId: vm.vmmID,
+			Name:      vm.deployRequest.DecodedClaims.Subject,
+		}
+
+		switch {
+		case vm.deployRequest.DecodedClaims.Issuer != claims.Issuer:
+			result.Error = "the only entity allowed to terminate a workload is the issuer that originally started it"
+		case claims.ID == vm.deployRequest.DecodedClaims.ID:
+			result.Error = "stop claims appear to be cloned or captured from the original start claims"
+		default:
+			api.mgr.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, "Bulk stop requested")
+			err = api.mgr.StopMachine(vm.vmmID, true)
+			if err != nil {
+				api.log.Error("Failed to stop workload", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+				result.Error = fmt.Sprintf("Failed to stop workload: %s", err)
+			} else {
+				result.Stopped = true
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	api.log.Info("Bulk stop request processed",
+		slog.String("namespace", namespace),
+		slog.Int("matched", len(results)),
+	)
+
+	res := controlapi.NewEnvelope(controlapi.BulkStopResponseType, controlapi.BulkStopResponse{
+		Results: results,
+	}, nil)
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal bulk stop response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
 func (api *ApiListener) handleDeploy(m *nats.Msg) {
+	atomic.AddInt32(&api.pendingDeploys, 1)
+	defer atomic.AddInt32(&api.pendingDeploys, -1)
+
 	namespace, err := extractNamespace(m.Subject)
 	if err != nil {
 		api.log.Error("Invalid subject for workload deployment", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, "Invalid subject for workload deployment")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for workload deployment")
+		return
+	}
+
+	// workloads would otherwise share their events and logs with every subscriber to the node's
+	if namespace == controlapi.SystemNamespace {
+		api.log.Warn("Rejected deploy request in the system namespace")
+		respondError(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Could not deploy workload: %s", ErrSystemNamespace), ErrSystemNamespace)
+		return
+	}
+
+	if reserved := api.reservedForOther(namespace); reserved != nil {
+		api.log.Warn("Rejected deploy request while node is reserved",
+			slog.String("namespace", namespace),
+			slog.String("reserved_for", reserved.Namespace),
+		)
+		reason := reserved.Error()
+		env := controlapi.NewEnvelope(controlapi.NodeReservedResponseType, reserved, &reason)
+		env.Code = controlapi.ErrorCodeNodeReserved
+		raw, _ := json.Marshal(env)
+		_ = m.Respond(raw)
 		return
 	}
 
 	var request controlapi.DeployRequest
-	err = json.Unmarshal(m.Data, &request)
+	err = schema.Unmarshal(m.Data, &request)
 	if err != nil {
 		api.log.Error("Failed to deserialize deploy request", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Unable to deserialize deploy request: %s", err))
+		respondInvalid(controlapi.RunResponseType, m, fmt.Sprintf("Unable to deserialize deploy request: %s", err), err)
 		return
 	}
 
 	if !slices.Contains(api.config.WorkloadTypes, *request.WorkloadType) {
 		api.log.Error("This node does not support the given workload type", slog.String("workload_type", *request.WorkloadType))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Unsupported workload type on this node: %s", *request.WorkloadType))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedWorkloadType, fmt.Sprintf("Unsupported workload type on this node: %s", *request.WorkloadType))
 		return
 	}
 
-	if len(request.TriggerSubjects) > 0 && (!strings.EqualFold(*request.WorkloadType, "v8") &&
-		!strings.EqualFold(*request.WorkloadType, "wasm")) { // FIXME -- workload type comparison
+	if request.Placement != nil {
+		err = request.Placement.Validate()
+		if err != nil {
+			api.log.Error("Invalid placement constraints", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid placement constraints: %s", err))
+			return
+		}
+
+		if unmatched := request.Placement.UnmatchedRequirement(api.config.Tags); unmatched != nil {
+			api.log.Warn("Node does not satisfy workload's node selector", slog.String("requirement", unmatched.String()))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodePlacementRejected, fmt.Sprintf("This node does not satisfy the workload's node selector: %s", unmatched))
+			return
+		}
+	}
+
+	err = agentapi.ValidateNativeExecution(*request.WorkloadType, request.Argv, request.Stdin, request.ExecPerTrigger)
+	if err != nil {
+		api.log.Error("Invalid workload execution", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid workload execution: %s", err))
+		return
+	}
+
+	if len(request.TriggerSubjects) > 0 && !agentapi.SupportsTriggers(*request.WorkloadType, request.ExecPerTrigger) {
 		api.log.Error("Workload type does not support trigger subject registration", slog.String("trigger_subjects", *request.WorkloadType))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Unsupported workload type for trigger subject registration: %s", *request.WorkloadType))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedWorkloadType, fmt.Sprintf("Unsupported workload type for trigger subject registration: %s", *request.WorkloadType))
+		return
+	}
+
+	if len(request.CronTriggers) > 0 && !agentapi.SupportsTriggers(*request.WorkloadType, request.ExecPerTrigger) {
+		api.log.Error("Workload type does not support cron triggers", slog.String("workload_type", *request.WorkloadType))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedWorkloadType, fmt.Sprintf("Unsupported workload type for cron triggers: %s", *request.WorkloadType))
+		return
+	}
+
+	if rejected := api.checkTriggerSubjects(request.TriggerSubjects); rejected != nil {
+		api.respondTriggerSubjectRejected(m, rejected)
+		return
+	}
+
+	err = validateCronTriggers(request.CronTriggers)
+	if err != nil {
+		api.log.Error("Invalid cron trigger", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid cron trigger: %s", err))
+		return
+	}
+
+	for tsub := range request.TriggerQueueGroups {
+		if !slices.Contains(request.TriggerSubjects, tsub) {
+			api.log.Error("Queue group given for unknown trigger subject", slog.String("trigger_subject", tsub))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Queue group given for unknown trigger subject: %s", tsub))
+			return
+		}
+	}
+
+	if request.CompletionSubject != nil && len(request.TriggerSubjects) == 0 && len(request.CronTriggers) == 0 {
+		api.log.Error("Completion subject given for workload without triggers")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, "Completion subject requires trigger subjects or cron triggers")
+		return
+	}
+
+	if request.TriggerConcurrency != nil {
+		err = agentTriggerConcurrency(request.TriggerConcurrency).Validate()
+		if err != nil {
+			api.log.Error("Invalid trigger concurrency limits", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid trigger concurrency limits: %s", err))
+			return
+		}
+	}
+
+	if request.MemorySoftLimit != nil {
+		err = agentMemorySoftLimit(request.MemorySoftLimit).Validate()
+		if err != nil {
+			api.log.Error("Invalid memory soft limit", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid memory soft limit: %s", err))
+			return
+		}
+
+		if request.MemorySoftLimit.Signal != nil && !strings.EqualFold(*request.WorkloadType, agentapi.NexExecutionProviderELF) {
+			api.log.Error("Memory soft limit signal given for workload which isn't a process")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Memory soft limit signals are only supported for elf workloads")
+			return
+		}
+	}
+
+	if request.LogLimits != nil {
+		err = agentLogLimits(request.LogLimits).Validate()
+		if err != nil {
+			api.log.Error("Invalid log limits", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid log limits: %s", err))
+			return
+		}
+	}
+
+	if request.EgressPolicy != nil {
+		if api.config.NoSandbox {
+			api.log.Error("Egress policy given to node running without sandboxes")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Egress policies require workloads to run in firecracker machines")
+			return
+		}
+
+		err = agentEgressPolicy(request.EgressPolicy).Validate()
+		if err != nil {
+			api.log.Error("Invalid egress policy", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid egress policy: %s", err))
+			return
+		}
+	}
+
+	if request.StableIP || request.DNSName != nil {
+		if api.mgr.serviceNetwork == nil {
+			api.log.Error("Stable IP or DNS name requested from node without service networking")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "This node does not support stable IPs or DNS names for workloads")
+			return
+		}
+
+		if !strings.EqualFold(*request.WorkloadType, agentapi.NexExecutionProviderELF) &&
+			!strings.EqualFold(*request.WorkloadType, agentapi.NexExecutionProviderOCI) {
+			api.log.Error("Stable IP or DNS name requested for workload which isn't a service")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Stable IPs and DNS names are only supported for elf and oci workloads")
+			return
+		}
+	}
+
+	if request.StableIP && (api.config.NoSandbox || api.config.ServiceNetworking.LeaseCIDR == "") {
+		api.log.Error("Stable IP requested from node which can't lease them")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "This node has no network to lease stable IPs from")
+		return
+	}
+
+	if request.VolumeSizeMib != nil {
+		if api.mgr.volumes == nil || api.config.NoSandbox {
+			api.log.Error("Volume requested from node without persistent volumes")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "This node does not provide persistent volumes for workloads")
+			return
+		}
+
+		if !strings.EqualFold(*request.WorkloadType, agentapi.NexExecutionProviderELF) &&
+			!strings.EqualFold(*request.WorkloadType, agentapi.NexExecutionProviderOCI) {
+			api.log.Error("Volume requested for workload which isn't a service")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Persistent volumes are only supported for elf and oci workloads")
+			return
+		}
+	}
+
+	if request.DNSName != nil && (!validDNSLabel.MatchString(*request.DNSName) || !validDNSLabel.MatchString(strings.ToLower(namespace))) {
+		api.log.Error("Invalid DNS name", slog.String("dns_name", *request.DNSName), slog.String("namespace", namespace))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid DNS name: %s.%s", *request.DNSName, namespace))
+		return
+	}
+
+	var webhookSubject string
+	if request.Webhook != nil {
+		if api.config.Webhooks == nil {
+			api.log.Error("Webhook requested from node without a webhook receiver")
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "This node does not receive webhooks")
+			return
+		}
+
+		webhookSubject, err = validateWebhookTrigger(request.Webhook, request.TriggerSubjects)
+		if err != nil {
+			api.log.Error("Invalid webhook", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid webhook: %s", err))
+			return
+		}
+	}
+
+	if request.MicroService != nil {
+		err = validateMicroService(request.MicroService, &request)
+		if err != nil {
+			api.log.Error("Invalid micro service registration", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, fmt.Sprintf("Invalid micro service registration: %s", err))
+			return
+		}
+	}
+
+	var hostServices *agentapi.HostServicesPolicy
+	if agentapi.IsFunctionWorkloadType(*request.WorkloadType) {
+		hostServices, err = api.config.SandboxProfiles.resolve(request.SandboxProfile, namespace)
+		if err != nil {
+			api.log.Error("Invalid sandbox profile", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid sandbox profile: %s", err))
+			return
+		}
+	} else if request.SandboxProfile != nil {
+		api.log.Error("Sandbox profile given for workload which isn't a function")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Sandbox profiles are only supported for function workloads")
+		return
+	}
+
+	if request.TriggerConcurrency != nil && request.TriggerDelivery != nil &&
+		strings.EqualFold(*request.TriggerDelivery, agentapi.TriggerDeliveryAtLeastOnce) {
+		api.log.Error("Trigger concurrency limits are not supported with at-least-once delivery")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Trigger concurrency limits are only supported for at-most-once trigger delivery")
+		return
+	}
+
+	if (request.AckTimeoutMillis != nil && *request.AckTimeoutMillis < 0) ||
+		(request.ReadyTimeoutMillis != nil && *request.ReadyTimeoutMillis < 0) {
+		api.log.Error("Negative deploy timeout")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, "Deploy timeouts must not be negative")
+		return
+	}
+
+	if request.IdleTimeoutMillis != nil && len(request.CronTriggers) > 0 {
+		api.log.Error("Idle timeout is not supported with cron triggers")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Idle timeout is not supported for functions with cron triggers")
+		return
+	}
+
+	if request.IdleTimeoutMillis != nil && (len(request.TriggerSubjects) == 0 ||
+		(request.TriggerDelivery != nil && strings.EqualFold(*request.TriggerDelivery, agentapi.TriggerDeliveryAtLeastOnce))) {
+		api.log.Error("Idle timeout requires at-most-once trigger subjects")
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Idle timeout is only supported for functions with at-most-once trigger delivery")
+		return
+	}
+
+	template := DefaultMachineTemplate
+	if request.MachineTemplate != nil {
+		template = machineTemplateName(*request.MachineTemplate)
+	}
+	if _, ok := api.mgr.templates[template]; !ok {
+		api.log.Error("Unknown machine template", slog.String("machine_template", template))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnsupportedFeature, fmt.Sprintf("Unknown machine template on this node: %s", template))
+		return
+	}
+
+	size := api.mgr.requestedMachineSize(template, request.VcpuCount, request.MemSizeMib)
+	err = api.mgr.validateMachineSize(size)
+	if err != nil {
+		api.log.Error("Invalid machine size", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid machine size: %s", err))
 		return
 	}
 
 	err = request.DecryptRequestEnvironment(api.xk)
 	if err != nil {
 		api.log.Error("Failed to decrypt environment for deploy request", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Failed to decrypt environment for deploy request: %s", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Failed to decrypt environment for deploy request: %s", err))
 		return
 	}
 
-	decodedClaims, err := request.Validate()
+	decodedClaims, err := request.Validate(newClaimsVerifiers(api.mgr.config.ClaimsVerifiers)...)
 	if err != nil {
 		api.log.Error("Invalid deploy request", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Invalid deploy request: %s", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid deploy request: %s", err))
 		return
 	}
 
 	request.DecodedClaims = *decodedClaims
-	if !validateIssuer(request.DecodedClaims.Issuer, api.mgr.config.ValidIssuers) {
-		err := fmt.Errorf("invalid workload issuer: %s", request.DecodedClaims.Issuer)
+	rootIssuer, err := request.ValidateIssuerChain(namespace)
+	if err != nil {
+		api.log.Error("Invalid workload issuer chain", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnauthorized, fmt.Sprintf("Invalid workload issuer chain: %s", err))
+		return
+	}
+
+	if !validateIssuer(rootIssuer, api.mgr.config.ValidIssuers) {
+		err := fmt.Errorf("invalid workload issuer: %s", rootIssuer)
 		api.log.Error("Workload validation failed", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("%s", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnauthorized, fmt.Sprintf("%s", err))
+		return
+	}
+
+	// a retried deploy request is answered with the response to the original rather than
+	// deploying the workload again
+	var idempotentResponse []byte
+	if request.IdempotencyKey != nil {
+		err = validateIdempotencyKey(*request.IdempotencyKey)
+		if err != nil {
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid deploy request: %s", err))
+			return
+		}
+
+		deploy, response, err := api.mgr.idempotency.claim(namespace, *request.IdempotencyKey, request.DecodedClaims.Subject)
+		if err != nil {
+			api.log.Warn("Rejected duplicate deploy request", slog.String("idempotency_key", *request.IdempotencyKey), slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeConflict, err.Error())
+			return
+		}
+		if response != nil {
+			api.log.Info("Answered duplicate deploy request with original response", slog.String("idempotency_key", *request.IdempotencyKey))
+			_ = m.Respond(response)
+			return
+		}
+		defer func() {
+			api.mgr.idempotency.complete(namespace, *request.IdempotencyKey, deploy, idempotentResponse)
+		}()
 	}
 
-	numBytes, workloadHash, err := api.mgr.CacheWorkload(&request)
+	deployToken, err := api.mgr.checkDeployToken(&request, namespace)
+	if err != nil {
+		api.log.Error("Invalid deploy token", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnauthorized, fmt.Sprintf("Invalid deploy token: %s", err))
+		return
+	}
+
+	// only a valid, authorized request activates a node in standby
+	if api.mgr.standby.Load() {
+		if !api.config.activatesOnDeploy() {
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeNodeUnavailable, "Node is in standby; activate it before deploying to it")
+			return
+		}
+		api.setStandby(false, "deploy request")
+	}
+
+	if len(request.Secrets) > 0 {
+		secrets, err := api.mgr.resolveSecrets(namespace, request.DecodedClaims.Subject, request.Secrets)
+		if err != nil {
+			api.log.Error("Failed to resolve workload secrets", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to resolve workload secrets: %s", err))
+			return
+		}
+
+		if request.WorkloadEnvironment == nil {
+			request.WorkloadEnvironment = make(map[string]string)
+		}
+		for name, value := range secrets {
+			request.WorkloadEnvironment[name] = value
+		}
+	}
+
+	numBytes, workloadHash, provenance, err := api.mgr.CacheWorkload(&request)
 	if err != nil {
 		api.log.Error("Failed to cache workload bytes", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Failed to cache workload bytes: %s", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeArtifactUnavailable, fmt.Sprintf("Failed to cache workload bytes: %s", err))
 		return
 	}
 
-	runningVM := <-api.mgr.warmVMs
-	if _, ok := api.mgr.handshakes[runningVM.vmmID]; !ok {
-		api.log.Error("Attempted to deploy workload into bad VM (no handshake)",
-			slog.String("vmmid", runningVM.vmmID),
-		)
-		respondFail(controlapi.RunResponseType, m, "Could not deploy workload, VM from pool did not initialize properly")
+	api.mgr.publishLifecycleEvent(controlapi.WorkloadLifecycleEvent{
+		State:        controlapi.WorkloadStateCached,
+		Namespace:    namespace,
+		WorkloadName: request.DecodedClaims.Subject,
+	})
+
+	scanResults, err := api.mgr.scanWorkload(&request)
+	if err != nil {
+		api.log.Error("Workload artifact failed scanning", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeArtifactRejected, fmt.Sprintf("Workload artifact failed scanning: %s", err))
+		return
+	}
+
+	api.mgr.quotaMutex.Lock()
+	defer api.mgr.quotaMutex.Unlock()
+
+	if exceeded := api.mgr.checkNamespaceQuota(namespace, template, size, int64(numBytes)); exceeded != nil {
+		api.log.Warn("Namespace quota exceeded", slog.String("namespace", namespace), slog.String("resource", exceeded.Resource))
+		reason := exceeded.Error()
+		env := controlapi.NewEnvelope(controlapi.QuotaExceededResponseType, exceeded, &reason)
+		env.Code = controlapi.ErrorCodeQuotaExceeded
+		raw, _ := json.Marshal(env)
+		_ = m.Respond(raw)
+		return
+	}
+
+	if conflict := request.Placement.AntiAffinityConflict(api.mgr.deployedWorkloadNames()[namespace]); conflict != "" {
+		api.log.Warn("Workload is anti-affine to a workload on this node", slog.String("workload", conflict))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodePlacementRejected, fmt.Sprintf("Workload may not be placed alongside %s, which is deployed to this node", conflict))
+		return
+	}
+
+	if rejected := api.mgr.checkTriggerSubjectClaims(namespace, request.DecodedClaims.Subject, request.TriggerSubjects); rejected != nil {
+		api.respondTriggerSubjectRejected(m, rejected)
+		return
+	}
+
+	// the deploy token is only used up once the workload has been admitted
+	err = api.mgr.redeemDeployToken(deployToken)
+	if err != nil {
+		api.log.Error("Invalid deploy token", slog.Any("err", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeUnauthorized, fmt.Sprintf("Invalid deploy token: %s", err))
+		return
+	}
+
+	var credentials *agentapi.Credentials
+	if request.Credentials != nil {
+		credentials, err = api.mgr.mintWorkloadCredentials(request.Credentials, request.DecodedClaims.Subject, namespace)
+		if err != nil {
+			api.log.Error("Failed to mint workload credentials", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to mint workload credentials: %s", err))
+			return
+		}
+	}
+
+	var ip net.IP
+	if request.StableIP {
+		ip, err = api.mgr.serviceNetwork.lease(namespace, request.DecodedClaims.Subject)
+		if err != nil {
+			api.log.Error("Failed to lease stable IP", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to lease stable IP: %s", err))
+			return
+		}
+	}
+
+	var volume *workloadVolume
+	if request.VolumeSizeMib != nil {
+		volume, err = api.mgr.volumes.acquire(namespace, request.DecodedClaims.Subject, *request.VolumeSizeMib)
+		if err != nil {
+			if ip != nil {
+				api.mgr.releaseFailedStableIP(namespace, request.DecodedClaims.Subject, ip)
+			}
+			api.log.Error("Failed to provision volume", slog.Any("err", err))
+			respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to provision volume: %s", err))
+			return
+		}
+	}
+
+	runningVM, err := api.mgr.acquireMachine(template, size, ip, volume)
+	if err != nil {
+		if volume != nil {
+			api.mgr.volumes.release(volume)
+		}
+		if ip != nil {
+			api.mgr.releaseFailedStableIP(namespace, request.DecodedClaims.Subject, ip)
+		}
+		api.log.Error("Failed to acquire machine for workload", slog.String("machine_template", template), slog.Any("err", err))
+		respondError(controlapi.RunResponseType, m, controlapi.ErrorCodeDeployFailed, fmt.Sprintf("Could not deploy workload: %s", err), err)
 		return
 	}
 	workloadName := request.DecodedClaims.Subject
 
+	api.mgr.publishLifecycleEvent(controlapi.WorkloadLifecycleEvent{
+		State:        controlapi.WorkloadStateScheduled,
+		Namespace:    namespace,
+		WorkloadName: workloadName,
+		MachineId:    runningVM.vmmID,
+	})
+
 	api.log.
 		Info("Submitting workload to VM",
 			slog.String("vmid", runningVM.vmmID),
@@ -229,46 +875,107 @@ func (api *ApiListener) handleDeploy(m *nats.Msg) {
 		)
 
 	err = api.mgr.DeployWorkload(runningVM, &agentapi.DeployRequest{
+		AckTimeoutMillis:     request.AckTimeoutMillis,
 		Argv:                 request.Argv,
+		Credentials:          credentials,
 		DecodedClaims:        request.DecodedClaims,
 		Description:          request.Description,
+		DNSName:              request.DNSName,
+		EgressPolicy:         agentEgressPolicy(request.EgressPolicy),
 		EncryptedEnvironment: request.Environment,
+		SealedEnvironment:    request.SealedEnvironment,
+		Secrets:              request.Secrets,
+		IssuerChain:          request.IssuerChain,
 		Environment:          request.WorkloadEnvironment,
+		CompletionSubject:    request.CompletionSubject,
+		CronTriggers:         agentCronTriggers(request.CronTriggers),
 		Essential:            request.Essential,
+		ExecPerTrigger:       request.ExecPerTrigger,
 		Hash:                 *workloadHash,
+		HealthCheck:          agentHealthCheck(request.HealthCheck),
+		ReadinessProbe:       agentHealthCheck(request.ReadinessProbe),
+		HostServices:         hostServices,
+		IdleTimeoutMillis:    request.IdleTimeoutMillis,
 		JsDomain:             request.JsDomain,
+		Labels:               request.Labels,
+		MachineTemplate:      request.MachineTemplate,
+		MemorySoftLimit:      agentMemorySoftLimit(request.MemorySoftLimit),
+		MemSizeMib:           request.MemSizeMib,
 		Location:             request.Location,
+		LogLimits:            agentLogLimits(request.LogLimits),
 		Namespace:            &namespace,
+		Provenance:           provenance,
+		PostStopHook:         agentWorkloadHook(request.PostStopHook),
+		PreStartHook:         agentWorkloadHook(request.PreStartHook),
+		ReadyTimeoutMillis:   request.ReadyTimeoutMillis,
+		Signature:            agentArtifactSignature(request.Signature),
 		RetryCount:           request.RetryCount,
 		RetriedAt:            request.RetriedAt,
+		SandboxProfile:       request.SandboxProfile,
+		ScanResults:          scanResults,
 		SenderPublicKey:      request.SenderPublicKey,
+		StableIP:             request.StableIP,
+		Stdin:                request.Stdin,
+		StreamArtifact:       request.StreamArtifact,
+		Standby:              api.mgr.updates.isStandby(request.WorkloadJwt),
 		TargetNode:           request.TargetNode,
 		TotalBytes:           int64(numBytes),
+		TriggerConcurrency:   agentTriggerConcurrency(request.TriggerConcurrency),
+		TriggerDelivery:      request.TriggerDelivery,
+		TriggerQueueGroups:   request.TriggerQueueGroups,
 		TriggerSubjects:      request.TriggerSubjects,
+		VcpuCount:            request.VcpuCount,
+		VolumeSizeMib:        request.VolumeSizeMib,
+		Webhook:              agentWebhookTrigger(request.Webhook, webhookSubject),
+		MicroService:         agentMicroService(request.MicroService, workloadName),
 		WorkloadName:         &workloadName,
 		WorkloadType:         request.WorkloadType, // FIXME-- audit all types for string -> *string, and validate...
 		WorkloadJwt:          request.WorkloadJwt,
 	})
 
 	if err != nil {
+		// the machine has been stopped, releasing its volume, but the workload's stable ip lease
+		// outlives its machines
+		if ip != nil {
+			api.mgr.releaseFailedStableIP(namespace, workloadName, ip)
+		}
+		api.mgr.publishLifecycleEvent(controlapi.WorkloadLifecycleEvent{
+			State:        controlapi.WorkloadStateFailed,
+			Namespace:    namespace,
+			WorkloadName: workloadName,
+			MachineId:    runningVM.vmmID,
+			Reason:       err.Error(),
+		})
 		api.log.Error("Failed to deploy workload in VM", slog.Any("err", err))
-		respondFail(controlapi.RunResponseType, m, fmt.Sprintf("Unable to deploy workload: %s", err))
+		respondFail(controlapi.RunResponseType, m, controlapi.ErrorCodeDeployFailed, fmt.Sprintf("Unable to deploy workload: %s", err))
 		return
 	}
 
+	if request.DNSName != nil {
+		runningVM.dnsName = api.mgr.serviceNetwork.fqdn(*request.DNSName, namespace)
+		api.mgr.serviceNetwork.register(runningVM.dnsName, runningVM.ip)
+	}
+
 	api.log.Info("Workload deployed", slog.String("workload", workloadName), slog.String("vmid", runningVM.vmmID))
 
+	var webhookURL string
+	if request.Webhook != nil {
+		webhookURL = api.config.Webhooks.webhookURL(namespace, workloadName)
+	}
+
 	res := controlapi.NewEnvelope(controlapi.RunResponseType, controlapi.RunResponse{
-		Started:   true,
-		Name:      workloadName,
-		Issuer:    runningVM.deployRequest.DecodedClaims.Issuer,
-		MachineId: runningVM.vmmID,
+		Started:    true,
+		Name:       workloadName,
+		Issuer:     runningVM.deployRequest.DecodedClaims.Issuer,
+		MachineId:  runningVM.vmmID,
+		WebhookURL: webhookURL,
 	}, nil)
 
 	raw, err := json.Marshal(res)
 	if err != nil {
 		api.log.Error("Failed to marshal deploy response", slog.Any("err", err))
 	} else {
+		idempotentResponse = raw
 		_ = m.Respond(raw)
 	}
 }
@@ -279,8 +986,10 @@ func (api *ApiListener) handlePing(m *nats.Msg) {
 		NodeId:          api.nodeId,
 		Version:         Version(),
 		Uptime:          myUptime(now.Sub(api.start)),
-		RunningMachines: len(api.mgr.allVMs) - len(api.mgr.warmVMs),
+		RunningMachines: api.mgr.runningMachineCount() - api.mgr.warmMachineCount(),
 		Tags:            api.config.Tags,
+		Capacity:        api.currentCapacity(),
+		Capabilities:    api.capabilities(),
 	}, nil)
 
 	raw, err := json.Marshal(res)
@@ -295,10 +1004,20 @@ func (api *ApiListener) handleInfo(m *nats.Msg) {
 	namespace, err := extractNamespace(m.Subject)
 	if err != nil {
 		api.log.Error("Failed to extract namespace for info request", slog.Any("err", err))
-		respondFail(controlapi.InfoResponseType, m, "Failed to extract namespace for info request")
+		respondFail(controlapi.InfoResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for info request")
 		return
 	}
 
+	var request controlapi.InfoRequest
+	if len(m.Data) > 0 {
+		err = schema.Unmarshal(m.Data, &request)
+		if err != nil {
+			api.log.Error("Failed to deserialize info request", slog.Any("err", err))
+			respondInvalid(controlapi.InfoResponseType, m, fmt.Sprintf("Unable to deserialize info request: %s", err), err)
+			return
+		}
+	}
+
 	pubX, _ := api.xk.PublicKey()
 	now := time.Now().UTC()
 	stats, _ := ReadMemoryStats()
@@ -308,8 +1027,14 @@ func (api *ApiListener) handleInfo(m *nats.Msg) {
 		Uptime:                 myUptime(now.Sub(api.start)),
 		Tags:                   api.config.Tags,
 		SupportedWorkloadTypes: api.config.WorkloadTypes,
-		Machines:               summarizeMachines(&api.mgr.allVMs, namespace),
+		Machines:               append(summarizeMachines(api.mgr.runningMachines(), namespace, request.Selector), api.mgr.summarizeIdleFunctions(namespace, request.Selector)...),
 		Memory:                 stats,
+		Quota:                  api.mgr.namespaceQuotaStatus(namespace),
+		Reservation:            api.activeReservation(),
+		Standby:                api.mgr.standby.Load(),
+		PausedUntil:            api.mgr.creationPausedUntil(),
+		MachineBoot:            api.mgr.summarizeMachineBoots(),
+		Capabilities:           api.capabilities(),
 	}, nil)
 
 	raw, err := json.Marshal(res)
@@ -320,11 +1045,145 @@ func (api *ApiListener) handleInfo(m *nats.Msg) {
 	}
 }
 
-func summarizeMachines(vms *map[string]*runningFirecracker, namespace string) []controlapi.MachineSummary {
+func (api *ApiListener) handleTimeline(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for timeline request", slog.Any("err", err))
+		respondFail(controlapi.TimelineResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for timeline request")
+		return
+	}
+
+	var request controlapi.TimelineRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize timeline request", slog.Any("err", err))
+		respondInvalid(controlapi.TimelineResponseType, m, fmt.Sprintf("Unable to deserialize timeline request: %s", err), err)
+		return
+	}
+
+	entries := api.mgr.machineTimeline(request.WorkloadId, namespace)
+	if entries == nil {
+		respondError(controlapi.TimelineResponseType, m, controlapi.ErrorCodeNotFound, "No such workload", ErrMachineNotFound)
+		return
+	}
+
+	res := controlapi.NewEnvelope(controlapi.TimelineResponseType, controlapi.TimelineResponse{
+		MachineId: request.WorkloadId,
+		Entries:   entries,
+	}, nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal timeline response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func (api *ApiListener) handleDescribe(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for describe request", slog.Any("err", err))
+		respondFail(controlapi.DescribeResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for describe request")
+		return
+	}
+
+	var request controlapi.DescribeRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize describe request", slog.Any("err", err))
+		respondInvalid(controlapi.DescribeResponseType, m, fmt.Sprintf("Unable to deserialize describe request: %s", err), err)
+		return
+	}
+
+	description := api.mgr.describeWorkload(request.WorkloadId, namespace)
+	if description == nil {
+		respondError(controlapi.DescribeResponseType, m, controlapi.ErrorCodeNotFound, "No such workload", ErrMachineNotFound)
+		return
+	}
+
+	res := controlapi.NewEnvelope(controlapi.DescribeResponseType, description, nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal describe response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Responds with the subjects the node uses for the namespace, from which NATS permissions for
+// the namespace's tenant can be constructed
+func (api *ApiListener) handleSubjects(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for subjects request", slog.Any("err", err))
+		respondFail(controlapi.SubjectsResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for subjects request")
+		return
+	}
+
+	res := controlapi.NewEnvelope(controlapi.SubjectsResponseType, api.namespaceSubjects(namespace), nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal subjects response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Responds with the memory use the node has observed of the namespace's workloads and the
+// memory size recommended for each
+func (api *ApiListener) handleMemory(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for memory request", slog.Any("err", err))
+		respondFail(controlapi.MemoryResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for memory request")
+		return
+	}
+
+	var request controlapi.MemoryRequest
+	if len(m.Data) > 0 {
+		err = schema.Unmarshal(m.Data, &request)
+		if err != nil {
+			api.log.Error("Failed to deserialize memory request", slog.Any("err", err))
+			respondInvalid(controlapi.MemoryResponseType, m, fmt.Sprintf("Unable to deserialize memory request: %s", err), err)
+			return
+		}
+	}
+
+	res := controlapi.NewEnvelope(controlapi.MemoryResponseType, api.mgr.workloadMemoryRecommendations(namespace, request.WorkloadName), nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal memory response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Runs the node's preflight checks and responds with the report. Nothing is installed, even if
+// the node is configured to force the installation of missing dependencies
+func (api *ApiListener) handlePreflight(m *nats.Msg) {
+	res := controlapi.NewEnvelope(controlapi.PreflightResponseType, preflightReport(api.nodeId, api.config), nil)
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal preflight response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func summarizeMachines(vms []*runningFirecracker, namespace string, selector map[string]string) []controlapi.MachineSummary {
 	machines := make([]controlapi.MachineSummary, 0)
 	now := time.Now().UTC()
-	for _, v := range *vms {
-		if v.namespace == namespace {
+	for _, v := range vms {
+		if v.deployRequest == nil || v.namespace != namespace {
+			continue
+		}
+
+		if controlapi.MatchesSelector(selector, v.deployRequest.Labels) {
 			var desc string
 			if v.deployRequest.Description != nil {
 				desc = *v.deployRequest.Description // FIXME-- audit controlapi.WorkloadSummary
@@ -336,9 +1195,14 @@ func summarizeMachines(vms *map[string]*runningFirecracker, namespace string) []
 			}
 
 			machine := controlapi.MachineSummary{
-				Id:      v.vmmID,
-				Healthy: true, // TODO cache last health status
-				Uptime:  myUptime(now.Sub(v.machineStarted)),
+				Id:           v.vmmID,
+				Healthy:      v.healthy(),
+				Ready:        v.isReady(),
+				State:        v.state().String(),
+				Uptime:       myUptime(now.Sub(v.machineStarted)),
+				Labels:       v.deployRequest.Labels,
+				CronTriggers: v.cronTriggerStatus(),
+				Triggers:     v.triggerExecutionStatus(),
 				Workload: controlapi.WorkloadSummary{
 					Name:         v.deployRequest.DecodedClaims.Subject,
 					Description:  desc,
@@ -348,6 +1212,14 @@ func summarizeMachines(vms *map[string]*runningFirecracker, namespace string) []
 				},
 			}
 
+			if result := v.lastHealthCheck.Load(); result != nil {
+				checkedAt := result.CheckedAt
+				machine.LastHealthCheck = &checkedAt
+				if result.Message != nil {
+					machine.HealthMessage = *result.Message
+				}
+			}
+
 			machines = append(machines, machine)
 		}
 	}
@@ -385,8 +1257,31 @@ func myUptime(d time.Duration) string {
 	return fmt.Sprintf("%ds", tsecs)
 }
 
-func respondFail(responseType string, m *nats.Msg, reason string) {
+func respondFail(responseType string, m *nats.Msg, code string, reason string) {
+	env := controlapi.NewEnvelope(responseType, []byte{}, &reason)
+	env.Code = code
+	jenv, _ := json.Marshal(env)
+	_ = m.Respond(jenv)
+}
+
+// Responds to a request whose payload couldn't be deserialized. If it didn't match its message's
+// schema, the response lists the offending fields
+func respondInvalid(responseType string, m *nats.Msg, reason string, err error) {
+	env := controlapi.NewEnvelope(responseType, []byte{}, &reason)
+	env.Code = controlapi.ErrorCodeInvalidRequest
+	var invalid *schema.ValidationError
+	if errors.As(err, &invalid) {
+		env.Data = controlapi.ValidationFailure{Fields: invalid.Fields}
+	}
+	jenv, _ := json.Marshal(env)
+	_ = m.Respond(jenv)
+}
+
+// Responds with the given reason, along with the control API error code of the error that
+// caused the failure, or the given code if the error has none
+func respondError(responseType string, m *nats.Msg, code string, reason string, err error) {
 	env := controlapi.NewEnvelope(responseType, []byte{}, &reason)
+	env.Code = errorCode(err, code)
 	jenv, _ := json.Marshal(env)
 	_ = m.Respond(jenv)
 }
@@ -0,0 +1,90 @@
+package nexnode
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// The CPU use of machines' cgroups is attributed to their workloads this often, as well as when
+// a machine stops and at the end of each utilization reporting period
+const cpuAccountingInterval = 15 * time.Second
+
+// Periodically attributes the CPU use of the node's machines to their workloads until the
+// machine manager is stopped
+func (m *MachineManager) accountCPUUsage() {
+	ticker := time.NewTicker(cpuAccountingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.sampleCPUUsage()
+		}
+	}
+}
+
+func (m *MachineManager) sampleCPUUsage() {
+	for _, vm := range m.runningMachines() {
+		if vm.cgroup != "" {
+			m.accountMachineCPU(vm)
+		}
+	}
+}
+
+// Attributes the CPU time used, and throttled, by the machine's cgroup since it was last accounted
+// to the machine's workload, in metrics and in the current utilization reporting period. CPU use
+// accounted while a machine is still warm is attributed to no one
+func (m *MachineManager) accountMachineCPU(vm *runningFirecracker) {
+	m.cpuAccountingMutex.Lock()
+	defer m.cpuAccountingMutex.Unlock()
+
+	usage, err := readCgroupUsage(vm.cgroup)
+	if err != nil {
+		m.log.Debug("Failed to read machine cgroup usage", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+		return
+	}
+
+	previous := vm.cpuAccounted
+	vm.cpuAccounted = *usage
+	if vm.deployRequest == nil || vm.deployRequest.WorkloadName == nil {
+		return
+	}
+
+	cpuUsec := max(usage.cpuUsec-previous.cpuUsec, 0)
+	throttledUsec := max(usage.cpuThrottledUsec-previous.cpuThrottledUsec, 0)
+	periods := max(usage.cpuPeriods-previous.cpuPeriods, 0)
+	throttledPeriods := max(usage.cpuThrottledPeriods-previous.cpuThrottledPeriods, 0)
+
+	name := *vm.deployRequest.WorkloadName
+	attrs := metric.WithAttributes(attribute.String("namespace", vm.namespace), attribute.String("workload_name", name))
+	m.t.workloadCPUUsec.Add(m.ctx, cpuUsec, attrs)
+	m.t.workloadCPUThrottledUsec.Add(m.ctx, throttledUsec, attrs)
+	m.t.workloadCPUThrottles.Add(m.ctx, throttledPeriods, attrs)
+
+	if m.utilization == nil {
+		return
+	}
+
+	m.utilization.mutex.Lock()
+	defer m.utilization.mutex.Unlock()
+
+	cpuSeconds := float64(cpuUsec) / 1e6
+	throttledSeconds := float64(throttledUsec) / 1e6
+
+	m.utilization.cpuSeconds += cpuSeconds
+
+	usageNs := m.utilization.namespace(vm.namespace)
+	usageNs.CPUSeconds += cpuSeconds
+	usageNs.ThrottledCPUSeconds += throttledSeconds
+
+	workload := m.utilization.workload(vm.namespace, name)
+	workload.CPUSeconds += cpuSeconds
+	workload.ThrottledCPUSeconds += throttledSeconds
+	workload.CPUPeriods += periods
+	workload.ThrottledCPUPeriods += throttledPeriods
+}
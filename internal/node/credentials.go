@@ -0,0 +1,197 @@
+package nexnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Lifetime of workload credentials when the node doesn't configure a maximum
+const defaultWorkloadCredentialsMaxTTLSeconds = 3600
+
+// Published on the configured revocation subject when a workload holding minted
+// credentials is undeployed
+type credentialsRevocation struct {
+	Account   string    `json:"account"`
+	User      string    `json:"user"`
+	Workload  string    `json:"workload"`
+	VmId      string    `json:"vmid"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func (c *WorkloadCredentials) validate() []error {
+	errs := make([]error, 0)
+
+	if c.MaxTTLSeconds < 0 {
+		errs = append(errs, errors.New("workload credentials max ttl must be >= 0"))
+	}
+	for _, subject := range append(append([]string{}, c.PublishAllow...), c.SubscribeAllow...) {
+		if _, err := subjectTokens(subject); err != nil {
+			errs = append(errs, fmt.Errorf("invalid workload credentials subject %q: %s", subject, err))
+		}
+	}
+
+	return errs
+}
+
+func (c *WorkloadCredentials) maxTTLSeconds() int {
+	if c.MaxTTLSeconds == 0 {
+		return defaultWorkloadCredentialsMaxTTLSeconds
+	}
+	return c.MaxTTLSeconds
+}
+
+// Whether every subject matching the given subscription tokens also matches the pattern tokens
+func subjectCovers(pattern []string, tokens []string) bool {
+	for i, p := range pattern {
+		if i == len(tokens) {
+			return false
+		}
+		if p == ">" {
+			return true
+		}
+		if tokens[i] == ">" || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(tokens)
+}
+
+// Returns the requested subjects covered by one of the subjects the node's policy allows
+func allowedCredentialSubjects(requested []string, allowed []string) []string {
+	granted := make([]string, 0)
+	for _, subject := range requested {
+		tokens, err := subjectTokens(subject)
+		if err != nil {
+			continue
+		}
+		for _, allow := range allowed {
+			pattern, err := subjectTokens(allow)
+			if err == nil && subjectCovers(pattern, tokens) {
+				granted = append(granted, subject)
+				break
+			}
+		}
+	}
+	return granted
+}
+
+// Mints short-lived NATS user credentials for a workload, allowed to publish and subscribe only on
+// those of the requested subjects the node's policy allows (plus inboxes beneath a prefix of its own,
+// so that the workload can make requests). Requested subjects the policy doesn't cover are dropped
+func (m *MachineManager) mintWorkloadCredentials(request *controlapi.CredentialsRequest, workloadName string, namespace string) (*agentapi.Credentials, error) {
+	config := m.config.WorkloadCredentials
+	if config == nil {
+		return nil, errors.New("this node is not configured to mint workload credentials")
+	}
+
+	seed, err := os.ReadFile(config.SigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workload credentials signing key: %s", err)
+	}
+
+	signingKey, err := nkeys.FromSeed(bytes.TrimSpace(seed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workload credentials signing key: %s", err)
+	}
+
+	userKey, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+
+	userPub, _ := userKey.PublicKey()
+	userSeed, _ := userKey.Seed()
+
+	ttl := request.TTLSeconds
+	if ttl <= 0 || ttl > config.maxTTLSeconds() {
+		ttl = config.maxTTLSeconds()
+	}
+
+	publishAllow := allowedCredentialSubjects(request.PublishAllow, config.PublishAllow)
+	subscribeAllow := allowedCredentialSubjects(request.SubscribeAllow, config.SubscribeAllow)
+
+	claims := jwt.NewUserClaims(userPub)
+	claims.Name = fmt.Sprintf("%s-%s", namespace, workloadName)
+	if config.IssuerAccount != nil {
+		claims.IssuerAccount = *config.IssuerAccount
+	}
+	claims.Expires = time.Now().UTC().Add(time.Duration(ttl) * time.Second).Unix()
+
+	claims.Pub.Allow.Add(publishAllow...)
+	claims.Sub.Allow.Add(subscribeAllow...)
+	inboxPrefix := agentapi.WorkloadInboxPrefix(userPub)
+	claims.Sub.Allow.Add(fmt.Sprintf("%s.>", inboxPrefix))
+	claims.Resp = &jwt.ResponsePermission{MaxMsgs: 1, Expires: time.Minute}
+
+	userJwt, err := claims.Encode(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign workload user JWT: %s", err)
+	}
+
+	creds, err := jwt.FormatUserConfig(userJwt, userSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentapi.Credentials{
+		PublishAllow:   publishAllow,
+		SubscribeAllow: subscribeAllow,
+		TTLSeconds:     ttl,
+		User:           userPub,
+		Creds:          string(creds),
+		InboxPrefix:    inboxPrefix,
+	}, nil
+}
+
+// Publishes a revocation notice for the credentials minted for the given VM's workload,
+// if any, so that an operator-side integration can revoke the user before it expires
+func (m *MachineManager) revokeWorkloadCredentials(vm *runningFirecracker) {
+	config := m.config.WorkloadCredentials
+	if config == nil || config.RevocationSubject == nil {
+		return
+	}
+
+	if vm.deployRequest == nil || vm.deployRequest.Credentials == nil || vm.deployRequest.Credentials.User == "" {
+		return
+	}
+
+	var account string
+	if config.IssuerAccount != nil {
+		account = *config.IssuerAccount
+	}
+
+	raw, _ := json.Marshal(&credentialsRevocation{
+		Account:   account,
+		User:      vm.deployRequest.Credentials.User,
+		Workload:  *vm.deployRequest.WorkloadName,
+		VmId:      vm.vmmID,
+		RevokedAt: time.Now().UTC(),
+	})
+
+	err := m.nc.Publish(*config.RevocationSubject, raw)
+	if err != nil {
+		m.log.Warn("Failed to publish workload credentials revocation", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+	}
+}
+
+func controlCredentialsRequest(creds *agentapi.Credentials) *controlapi.CredentialsRequest {
+	if creds == nil {
+		return nil
+	}
+
+	return &controlapi.CredentialsRequest{
+		PublishAllow:   creds.PublishAllow,
+		SubscribeAllow: creds.SubscribeAllow,
+		TTLSeconds:     creds.TTLSeconds,
+	}
+}
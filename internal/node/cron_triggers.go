@@ -0,0 +1,239 @@
+package nexnode
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Functions triggered by a schedule see a synthetic trigger subject of the form
+// $NEX.CRON.{namespace}.{workload}
+const cronTriggerSubjectPrefix = "$NEX.CRON"
+
+// A single cron trigger of a deployed function, along with the state reported by INFO
+type cronTrigger struct {
+	index    int
+	trigger  agentapi.CronTrigger
+	schedule *cronSchedule
+
+	mutex     sync.Mutex
+	nextRun   time.Time
+	lastRun   time.Time
+	lastError string
+}
+
+// Validates the schedules of the given cron triggers
+func validateCronTriggers(triggers []controlapi.CronTrigger) error {
+	for _, trigger := range triggers {
+		var timezone string
+		if trigger.Timezone != nil {
+			timezone = *trigger.Timezone
+		}
+
+		schedule, err := parseCronSchedule(trigger.Schedule, timezone)
+		if err != nil {
+			return err
+		}
+
+		if schedule.next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression %q never matches", trigger.Schedule)
+		}
+	}
+
+	return nil
+}
+
+// Starts the scheduler for each of the deployed function's cron triggers. The schedulers
+// run until the machine is stopped
+func (m *MachineManager) scheduleCronTriggers(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	triggers := make([]*cronTrigger, 0, len(request.CronTriggers))
+	for i, trigger := range request.CronTriggers {
+		var timezone string
+		if trigger.Timezone != nil {
+			timezone = *trigger.Timezone
+		}
+
+		schedule, err := parseCronSchedule(trigger.Schedule, timezone)
+		if err != nil {
+			return err
+		}
+
+		triggers = append(triggers, &cronTrigger{
+			index:    i,
+			trigger:  trigger,
+			schedule: schedule,
+		})
+	}
+
+	vm.cronTriggers = triggers
+	vm.cronStop = make(chan struct{})
+
+	for _, ct := range triggers {
+		go m.runCronTrigger(vm, ct, vm.cronStop)
+
+		m.log.Info("Scheduled cron trigger for deployed workload",
+			slog.String("vmid", vm.vmmID),
+			slog.String("schedule", ct.trigger.Schedule),
+			slog.String("timezone", ct.schedule.location.String()),
+		)
+	}
+
+	return nil
+}
+
+// Stops the schedulers of the machine's cron triggers, if any
+func (m *MachineManager) stopCronTriggers(vm *runningFirecracker) {
+	if vm.cronStop != nil {
+		close(vm.cronStop)
+		vm.cronStop = nil
+	}
+}
+
+func (m *MachineManager) runCronTrigger(vm *runningFirecracker, ct *cronTrigger, stop chan struct{}) {
+	for {
+		next := ct.schedule.next(time.Now())
+		if next.IsZero() {
+			m.log.Warn("Cron trigger schedule has no future runs",
+				slog.String("vmid", vm.vmmID),
+				slog.String("schedule", ct.trigger.Schedule),
+			)
+			return
+		}
+
+		ct.mutex.Lock()
+		ct.nextRun = next
+		ct.mutex.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			m.executeCronTrigger(vm, ct, next)
+		}
+	}
+}
+
+// Invokes the function by way of the trigger path, as if the cron trigger's payload had been
+// published to one of its trigger subjects
+func (m *MachineManager) executeCronTrigger(vm *runningFirecracker, ct *cronTrigger, scheduledAt time.Time) {
+	tsub := fmt.Sprintf("%s.%s.%s", cronTriggerSubjectPrefix, vm.namespace, *vm.deployRequest.WorkloadName)
+
+	msg := nats.NewMsg(tsub)
+	msg.Data = ct.trigger.Payload
+	// lets functions recognize a scheduled execution they've already performed
+	msg.Header.Set(nexIdempotencyKey, fmt.Sprintf("cron-%s-%d-%d", vm.vmmID, ct.index, scheduledAt.Unix()))
+
+	_, err := m.executeTrigger(vm, tsub, vm.deployRequest, msg)
+
+	ct.mutex.Lock()
+	ct.lastRun = scheduledAt
+	ct.lastError = ""
+	if err != nil {
+		ct.lastError = err.Error()
+	}
+	ct.mutex.Unlock()
+
+	if err != nil {
+		m.log.Warn("Scheduled execution of cron trigger failed",
+			slog.String("vmid", vm.vmmID),
+			slog.String("schedule", ct.trigger.Schedule),
+			slog.Any("err", err),
+		)
+	}
+
+	_ = m.publishCronTriggerExecuted(vm, ct, scheduledAt, err)
+}
+
+func (m *MachineManager) publishCronTriggerExecuted(vm *runningFirecracker, ct *cronTrigger, scheduledAt time.Time, err error) error {
+	evt := controlapi.CronTriggerExecutedEvent{
+		Name:        *vm.deployRequest.WorkloadName,
+		VmId:        vm.vmmID,
+		Schedule:    ct.trigger.Schedule,
+		ScheduledAt: scheduledAt.UTC(),
+		Success:     err == nil,
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	}
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.CronTriggerExecutedEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(evt)
+
+	return PublishCloudEvent(m.nc, vm.namespace, cloudevent, m.log)
+}
+
+// Returns the status of each of the machine's cron triggers, as reported by INFO
+func (vm *runningFirecracker) cronTriggerStatus() []controlapi.CronTriggerStatus {
+	if len(vm.cronTriggers) == 0 {
+		return nil
+	}
+
+	status := make([]controlapi.CronTriggerStatus, 0, len(vm.cronTriggers))
+	for _, ct := range vm.cronTriggers {
+		ct.mutex.Lock()
+		s := controlapi.CronTriggerStatus{
+			Schedule:  ct.trigger.Schedule,
+			Timezone:  ct.schedule.location.String(),
+			LastError: ct.lastError,
+		}
+		if !ct.nextRun.IsZero() {
+			nextRun := ct.nextRun.UTC()
+			s.NextRun = &nextRun
+		}
+		if !ct.lastRun.IsZero() {
+			lastRun := ct.lastRun.UTC()
+			s.LastRun = &lastRun
+		}
+		ct.mutex.Unlock()
+
+		status = append(status, s)
+	}
+
+	return status
+}
+
+func agentCronTriggers(triggers []controlapi.CronTrigger) []agentapi.CronTrigger {
+	if len(triggers) == 0 {
+		return nil
+	}
+
+	result := make([]agentapi.CronTrigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		result = append(result, agentapi.CronTrigger{
+			Schedule: trigger.Schedule,
+			Timezone: trigger.Timezone,
+			Payload:  trigger.Payload,
+		})
+	}
+	return result
+}
+
+func controlCronTriggers(triggers []agentapi.CronTrigger) []controlapi.CronTrigger {
+	if len(triggers) == 0 {
+		return nil
+	}
+
+	result := make([]controlapi.CronTrigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		result = append(result, controlapi.CronTrigger{
+			Schedule: trigger.Schedule,
+			Timezone: trigger.Timezone,
+			Payload:  trigger.Payload,
+		})
+	}
+	return result
+}
@@ -0,0 +1,516 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// A number of replicas of a workload the cluster's leader maintains across its members. Each
+// heartbeat the leader forgets replicas whose member has left or no longer runs them, and deploys
+// replacements to the members best able to run them, preferring members running the fewest of
+// the set's replicas
+type deploySet struct {
+	mutex sync.Mutex
+
+	namespace string
+	name      string
+	desired   int
+	nodeTags  map[string]string
+	// the workload's deploy request, with its environment decrypted
+	request *controlapi.DeployRequest
+	claims  jwt.GenericClaims
+
+	replicas  []*deploySetReplica
+	lastError string
+
+	// set while replicas in excess of the desired number, or of a deleted set, are to be stopped,
+	// which requires the authorization of the workload's issuer
+	issuerJwt string
+	deleting  bool
+}
+
+type deploySetReplica struct {
+	controlapi.DeploySetReplica
+	// replicas aren't expected to be advertised by their member until it next advertises itself
+	placedAt time.Time
+}
+
+// A deploy set as the leader shares it with the cluster's other members, with the environment
+// of its request encrypted for the recipient
+type deploySetState struct {
+	Namespace string                        `json:"namespace"`
+	Name      string                        `json:"name"`
+	Desired   int                           `json:"desired"`
+	NodeTags  map[string]string             `json:"node_tags,omitempty"`
+	Request   *controlapi.DeployRequest     `json:"request"`
+	Replicas  []controlapi.DeploySetReplica `json:"replicas"`
+}
+
+func deploySetKey(namespace string, name string) string {
+	return namespace + "." + name
+}
+
+// The subject on which the leader shares the cluster's deploy sets with the given member
+func clusterDeploySetsSubject(cluster string, nodeId string) string {
+	return fmt.Sprintf("%s.cluster.%s.deploysets.%s", controlapi.APIPrefix, cluster, nodeId)
+}
+
+// IDs of the node's machines running deployed workloads, and of its idle functions
+func (m *MachineManager) deployedMachineIDs() []string {
+	ids := make([]string, 0)
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest != nil {
+			ids = append(ids, vm.vmmID)
+		}
+	}
+	for _, fn := range m.idleFunctionsSnapshot() {
+		ids = append(ids, fn.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *deploySet) status() controlapi.DeploySetStatus {
+	status := controlapi.DeploySetStatus{
+		Name:      s.name,
+		Namespace: s.namespace,
+		Workload:  s.claims.Subject,
+		Desired:   s.desired,
+		Replicas:  make([]controlapi.DeploySetReplica, 0, len(s.replicas)),
+		LastError: s.lastError,
+	}
+	if s.deleting {
+		status.Desired = 0
+	}
+	for _, replica := range s.replicas {
+		status.Replicas = append(status.Replicas, replica.DeploySetReplica)
+	}
+	return status
+}
+
+func (c *clusterMembership) handleDeploySet(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		c.api.log.Error("Invalid subject for deploy set request", slog.Any("err", err))
+		respondFail(controlapi.DeploySetResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for deploy set request")
+		return
+	}
+	tokens := strings.Split(m.Subject, ".")
+	op := tokens[len(tokens)-1]
+
+	var request controlapi.DeploySetRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		c.api.log.Error("Failed to deserialize deploy set request", slog.Any("err", err))
+		respondInvalid(controlapi.DeploySetResponseType, m, fmt.Sprintf("Unable to deserialize deploy set request: %s", err), err)
+		return
+	}
+	if request.Name == "" && op != controlapi.DeploySetOpStatus {
+		respondFail(controlapi.DeploySetResponseType, m, controlapi.ErrorCodeInvalidRequest, "Deploy set name is required")
+		return
+	}
+
+	switch op {
+	case controlapi.DeploySetOpCreate:
+		err = c.createDeploySet(namespace, &request)
+	case controlapi.DeploySetOpScale, controlapi.DeploySetOpDelete:
+		err = c.updateDeploySet(namespace, &request, op == controlapi.DeploySetOpDelete)
+	case controlapi.DeploySetOpStatus:
+	default:
+		err = fmt.Errorf("unsupported deploy set operation: %s", op)
+	}
+	if err != nil {
+		c.api.log.Warn("Deploy set request failed", slog.String("operation", op), slog.String("name", request.Name), slog.Any("err", err))
+		respondError(controlapi.DeploySetResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Deploy set request failed: %s", err), err)
+		return
+	}
+
+	if op != controlapi.DeploySetOpStatus {
+		c.api.log.Info("Deploy set updated",
+			slog.String("cluster", c.config.Name),
+			slog.String("namespace", namespace),
+			slog.String("name", request.Name),
+			slog.String("operation", op),
+		)
+		go c.reconcileDeploySets()
+	}
+
+	res := controlapi.DeploySetResponse{
+		Cluster: c.config.Name,
+		Sets:    c.deploySetStatuses(namespace, request.Name),
+	}
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.DeploySetResponseType, res, nil))
+	if err != nil {
+		c.api.log.Error("Failed to marshal deploy set response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+// Creates a deploy set, whose replicas are deployed as the leader next reconciles its sets
+func (c *clusterMembership) createDeploySet(namespace string, request *controlapi.DeploySetRequest) error {
+	if namespace == controlapi.SystemNamespace {
+		return ErrSystemNamespace
+	}
+	if !validClusterName.MatchString(request.Name) {
+		return fmt.Errorf("invalid deploy set name: %q", request.Name)
+	}
+	if request.Replicas < 1 {
+		return errors.New("deploy sets must have at least 1 replica")
+	}
+	deploy := request.Request
+	if deploy == nil || deploy.Environment == nil || deploy.SenderPublicKey == nil || deploy.WorkloadJwt == nil {
+		return errors.New("a deploy request with an environment encrypted for the leader is required")
+	}
+
+	err := deploy.DecryptRequestEnvironment(c.api.xk)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt environment: %s", err)
+	}
+	claims, err := deploy.Validate(newClaimsVerifiers(c.api.config.ClaimsVerifiers)...)
+	if err != nil {
+		return fmt.Errorf("invalid deploy request: %s", err)
+	}
+
+	// replicas are deployed repeatedly with the same request, which a deploy token can't authorize
+	if claimType, _ := claims.Data["type"].(string); claimType == controlapi.DeployTokenClaimType {
+		return errors.New("deploy sets cannot be authorized by single-use deploy tokens")
+	}
+	// each replica is deployed separately, so an idempotency key would answer replacements with stale responses
+	deploy.IdempotencyKey = nil
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := deploySetKey(namespace, request.Name)
+	if _, ok := c.sets[key]; ok {
+		return fmt.Errorf("deploy set %s already exists", request.Name)
+	}
+	c.sets[key] = &deploySet{
+		namespace: namespace,
+		name:      request.Name,
+		desired:   request.Replicas,
+		nodeTags:  request.NodeTags,
+		request:   deploy,
+		claims:    *claims,
+		replicas:  make([]*deploySetReplica, 0),
+	}
+
+	return nil
+}
+
+// Scales or deletes a deploy set, provided the request is authorized by the issuer of its
+// workload. Excess replicas are stopped as the leader next reconciles its sets
+func (c *clusterMembership) updateDeploySet(namespace string, request *controlapi.DeploySetRequest, delete bool) error {
+	c.mutex.Lock()
+	set, ok := c.sets[deploySetKey(namespace, request.Name)]
+	c.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no such deploy set: %s", request.Name)
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	stop := controlapi.StopRequest{WorkloadJwt: request.IssuerJwt}
+	err := stop.Validate(&set.claims, newClaimsVerifiers(c.api.config.ClaimsVerifiers)...)
+	if err != nil {
+		return err
+	}
+
+	if delete {
+		set.deleting = true
+	} else {
+		if request.Replicas < 1 {
+			return errors.New("deploy sets must have at least 1 replica; delete the set instead")
+		}
+		set.desired = request.Replicas
+	}
+	set.issuerJwt = request.IssuerJwt
+
+	return nil
+}
+
+// Describes the named deploy set in the namespace or, without a name, all of its deploy sets
+func (c *clusterMembership) deploySetStatuses(namespace string, name string) []controlapi.DeploySetStatus {
+	c.mutex.Lock()
+	sets := make([]*deploySet, 0)
+	for _, set := range c.sets {
+		if set.namespace == namespace && (name == "" || set.name == name) {
+			sets = append(sets, set)
+		}
+	}
+	c.mutex.Unlock()
+
+	statuses := make([]controlapi.DeploySetStatus, 0, len(sets))
+	for _, set := range sets {
+		set.mutex.Lock()
+		statuses = append(statuses, set.status())
+		set.mutex.Unlock()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Brings each of the cluster's deploy sets to its desired number of replicas. Only one
+// reconciliation runs at a time, as deploying replicas may outlast a heartbeat
+func (c *clusterMembership) reconcileDeploySets() {
+	if !c.reconciling.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.reconciling.Store(false)
+
+	c.mutex.Lock()
+	members := make(map[string]controlapi.ClusterMember)
+	for _, member := range c.liveMembers() {
+		members[member.NodeId] = *member
+	}
+	sets := make([]*deploySet, 0, len(c.sets))
+	for _, set := range c.sets {
+		sets = append(sets, set)
+	}
+	c.mutex.Unlock()
+
+	for _, set := range sets {
+		if c.api.mgr.ctx.Err() != nil {
+			return
+		}
+		c.reconcileDeploySet(set, members)
+	}
+}
+
+func (c *clusterMembership) reconcileDeploySet(set *deploySet, members map[string]controlapi.ClusterMember) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	// replicas are lost when their member leaves the cluster or stops running them
+	running := make([]*deploySetReplica, 0, len(set.replicas))
+	for _, replica := range set.replicas {
+		member, ok := members[replica.NodeId]
+		if ok && (time.Since(replica.placedAt) < c.config.memberTimeout() || slices.Contains(member.Machines, replica.MachineId)) {
+			running = append(running, replica)
+			continue
+		}
+
+		c.api.log.Warn("Deploy set replica lost",
+			slog.String("namespace", set.namespace),
+			slog.String("name", set.name),
+			slog.String("node_id", replica.NodeId),
+			slog.String("machine_id", replica.MachineId),
+		)
+	}
+	set.replicas = running
+
+	desired := set.desired
+	if set.deleting {
+		desired = 0
+	}
+	for len(set.replicas) > desired && set.issuerJwt != "" {
+		c.stopReplica(set, set.replicas[len(set.replicas)-1])
+		set.replicas = set.replicas[:len(set.replicas)-1]
+	}
+	if len(set.replicas) <= desired {
+		set.issuerJwt = ""
+	}
+
+	if set.deleting {
+		if len(set.replicas) == 0 {
+			c.mutex.Lock()
+			delete(c.sets, deploySetKey(set.namespace, set.name))
+			c.mutex.Unlock()
+		}
+		return
+	}
+
+	for len(set.replicas) < desired {
+		candidates := c.candidates(set.namespace, set.request, set.nodeTags)
+		if len(candidates) == 0 {
+			set.lastError = "no cluster member can run the workload"
+			return
+		}
+
+		placed := make(map[string]int)
+		for _, replica := range set.replicas {
+			placed[replica.NodeId]++
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return placed[candidates[i].NodeId] < placed[candidates[j].NodeId]
+		})
+
+		deployed, failure, err := c.deployTo(set.namespace, candidates[0], set.request)
+		if err == nil && failure != nil {
+			var envelope controlapi.Envelope
+			_ = json.Unmarshal(failure, &envelope)
+			err = fmt.Errorf("node %s failed to deploy the workload: %v", candidates[0].NodeId, envelope.Error)
+		}
+		if err != nil {
+			c.api.log.Warn("Failed to deploy deploy set replica", slog.String("name", set.name), slog.Any("err", err))
+			set.lastError = err.Error()
+			return
+		}
+
+		set.replicas = append(set.replicas, &deploySetReplica{
+			DeploySetReplica: controlapi.DeploySetReplica{NodeId: deployed.NodeId, MachineId: deployed.MachineId},
+			placedAt:         time.Now(),
+		})
+		set.lastError = ""
+	}
+}
+
+// Stops the replica with the stop authorization given by the workload's issuer. Must be called
+// with the set's mutex held
+func (c *clusterMembership) stopReplica(set *deploySet, replica *deploySetReplica) {
+	raw, _ := json.Marshal(controlapi.StopRequest{
+		WorkloadId:  replica.MachineId,
+		WorkloadJwt: set.issuerJwt,
+		TargetNode:  replica.NodeId,
+	})
+
+	subject := fmt.Sprintf("%s.STOP.%s.%s", controlapi.APIPrefix, set.namespace, replica.NodeId)
+	res, err := c.api.mgr.nc.Request(subject, raw, c.config.deployTimeout())
+	if err == nil {
+		var envelope controlapi.Envelope
+		if json.Unmarshal(res.Data, &envelope) == nil && envelope.Error != nil {
+			err = fmt.Errorf("%v", envelope.Error)
+		}
+	}
+	if err != nil {
+		c.api.log.Warn("Failed to stop deploy set replica",
+			slog.String("name", set.name),
+			slog.String("node_id", replica.NodeId),
+			slog.String("machine_id", replica.MachineId),
+			slog.Any("err", err),
+		)
+		set.lastError = fmt.Sprintf("failed to stop replica %s on node %s: %s", replica.MachineId, replica.NodeId, err)
+	}
+}
+
+// Shares the cluster's deploy sets with its other members, so that whichever member next leads
+// the cluster takes them over. Each member receives the sets' requests with their environments
+// encrypted for it
+func (c *clusterMembership) shareDeploySets() {
+	c.mutex.Lock()
+	members := make([]controlapi.ClusterMember, 0)
+	for _, member := range c.liveMembers() {
+		if member.NodeId != c.api.nodeId {
+			members = append(members, *member)
+		}
+	}
+	sets := make([]*deploySet, 0, len(c.sets))
+	for _, set := range c.sets {
+		sets = append(sets, set)
+	}
+	c.mutex.Unlock()
+
+	states := make([]deploySetState, 0, len(sets))
+	environments := make([]map[string]string, 0, len(sets))
+	for _, set := range sets {
+		set.mutex.Lock()
+		if !set.deleting {
+			status := set.status()
+			request := *set.request
+			states = append(states, deploySetState{
+				Namespace: set.namespace,
+				Name:      set.name,
+				Desired:   set.desired,
+				NodeTags:  set.nodeTags,
+				Request:   &request,
+				Replicas:  status.Replicas,
+			})
+			environments = append(environments, set.request.WorkloadEnvironment)
+		}
+		set.mutex.Unlock()
+	}
+
+	senderPublicKey, _ := c.api.xk.PublicKey()
+	for _, member := range members {
+		for i := range states {
+			env, err := controlapi.EncryptRequestEnvironment(c.api.xk, member.PublicXKey, environments[i])
+			if err != nil {
+				c.api.log.Warn("Failed to encrypt deploy set environment", slog.String("node_id", member.NodeId), slog.Any("err", err))
+				continue
+			}
+			states[i].Request.Environment = &env
+			states[i].Request.SealedEnvironment = nil
+			states[i].Request.SenderPublicKey = &senderPublicKey
+		}
+
+		raw, err := json.Marshal(states)
+		if err != nil {
+			c.api.log.Error("Failed to marshal deploy sets", slog.Any("err", err))
+			return
+		}
+		_ = c.api.mgr.nc.Publish(clusterDeploySetsSubject(c.config.Name, member.NodeId), raw)
+	}
+}
+
+// Keeps the deploy sets most recently shared by the leader
+func (c *clusterMembership) handleSharedDeploySets(m *nats.Msg) {
+	var states []deploySetState
+	err := json.Unmarshal(m.Data, &states)
+	if err != nil {
+		c.api.log.Warn("Received invalid deploy sets", slog.Any("err", err))
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.leader != c.api.nodeId {
+		c.standbySets = states
+	}
+}
+
+// Takes over the deploy sets last shared by the previous leader. Their replicas are presumed
+// running until the members next advertise themselves. Must be called with the mutex held
+func (c *clusterMembership) adoptDeploySets() {
+	for _, state := range c.standbySets {
+		request := state.Request
+		if request == nil || request.Environment == nil || request.SenderPublicKey == nil || request.WorkloadJwt == nil {
+			continue
+		}
+
+		err := request.DecryptRequestEnvironment(c.api.xk)
+		if err == nil {
+			_, err = request.Validate(newClaimsVerifiers(c.api.config.ClaimsVerifiers)...)
+		}
+		if err != nil {
+			c.api.log.Warn("Failed to adopt deploy set", slog.String("name", state.Name), slog.Any("err", err))
+			continue
+		}
+
+		set := &deploySet{
+			namespace: state.Namespace,
+			name:      state.Name,
+			desired:   state.Desired,
+			nodeTags:  state.NodeTags,
+			request:   request,
+			claims:    request.DecodedClaims,
+			replicas:  make([]*deploySetReplica, 0, len(state.Replicas)),
+		}
+		for _, replica := range state.Replicas {
+			set.replicas = append(set.replicas, &deploySetReplica{DeploySetReplica: replica, placedAt: time.Now()})
+		}
+		c.sets[deploySetKey(state.Namespace, state.Name)] = set
+	}
+
+	if len(c.standbySets) > 0 {
+		c.api.log.Info("Adopted cluster deploy sets", slog.String("cluster", c.config.Name), slog.Int("sets", len(c.sets)))
+	}
+	c.standbySets = nil
+}
@@ -0,0 +1,128 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Key-value bucket in which nodes record the deploy tokens they've redeemed
+const DeployTokenBucketName = "NEXDEPLOYTOKENS"
+
+// Records redeemed deploy tokens in a key-value bucket shared by every node connected to the
+// same JetStream, creating each token's entry only if it doesn't exist yet, so that a token is
+// only used once no matter which node it's presented to, or whether that node has restarted
+// since. Entries are never removed; they're small, and a token's ID is never reused
+type deployTokenLedger struct {
+	nc    *nats.Conn
+	mutex sync.Mutex
+	// tokens of essential workloads which may be presented once more by this node's own
+	// redeploy request
+	redeploys map[string]bool
+}
+
+// A redeemed deploy token's entry in the ledger
+type deployTokenRedemption struct {
+	NodeId     string    `json:"node_id"`
+	Namespace  string    `json:"namespace"`
+	Workload   string    `json:"workload"`
+	Expires    time.Time `json:"expires"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+func newDeployTokenLedger(nc *nats.Conn) *deployTokenLedger {
+	return &deployTokenLedger{
+		nc:        nc,
+		redeploys: make(map[string]bool),
+	}
+}
+
+func (l *deployTokenLedger) bucket() (nats.KeyValue, error) {
+	js, err := l.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(DeployTokenBucketName)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: DeployTokenBucketName, History: 1})
+	}
+	return kv, err
+}
+
+// Marks the given token as used by the given node, failing if it has already been used
+func (l *deployTokenLedger) consume(token *controlapi.DeployTokenClaims, nodeID string) error {
+	kv, err := l.bucket()
+	if err != nil {
+		return fmt.Errorf("failed to open deploy token ledger: %s", err)
+	}
+
+	raw, _ := json.Marshal(&deployTokenRedemption{
+		NodeId:     nodeID,
+		Namespace:  token.Namespace,
+		Workload:   token.WorkloadName,
+		Expires:    token.Expires,
+		RedeemedAt: time.Now().UTC(),
+	})
+
+	_, err = kv.Create(token.ID, raw)
+	if errors.Is(err, nats.ErrKeyExists) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if !l.redeploys[token.ID] {
+			return errors.New("deploy token has already been used")
+		}
+		delete(l.redeploys, token.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record deploy token: %s", err)
+	}
+
+	return nil
+}
+
+// Permits the given token to be used once more, by the redeploy of the workload it deployed
+func (l *deployTokenLedger) allowRedeploy(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.redeploys[id] = true
+}
+
+// Validates the deploy token of the given request, if any, against the namespace it targets,
+// returning its claims. The request's digest is set to the digest the token is bound to, so the
+// workload is rejected if the artifact doesn't match. The token isn't marked as used until it's
+// redeemed, once the workload has passed admission
+func (m *MachineManager) checkDeployToken(request *controlapi.DeployRequest, namespace string) (*controlapi.DeployTokenClaims, error) {
+	token, err := request.DeployTokenClaims()
+	if err != nil || token == nil {
+		return nil, err
+	}
+
+	if token.Namespace != namespace {
+		return nil, fmt.Errorf("deploy token does not permit deployment to namespace %s", namespace)
+	}
+	if request.Digest != nil && !strings.EqualFold(*request.Digest, token.Digest) {
+		return nil, errors.New("deploy request digest does not match the digest of its deploy token")
+	}
+
+	request.Digest = &token.Digest
+	return token, nil
+}
+
+// Marks the given (checked) deploy token as used, failing if it has been used before, by this or
+// any other node
+func (m *MachineManager) redeemDeployToken(token *controlapi.DeployTokenClaims) error {
+	if token == nil {
+		return nil
+	}
+	return m.deployTokens.consume(token, m.publicKey)
+}
@@ -0,0 +1,142 @@
+package nexnode
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Prefix of the name of the iptables chain holding a machine's egress rules, followed by the
+// machine's ID
+const egressChainPrefix = "NEX-"
+
+func agentEgressPolicy(policy *controlapi.EgressPolicy) *agentapi.EgressPolicy {
+	if policy == nil {
+		return nil
+	}
+
+	rules := make([]agentapi.EgressRule, len(policy.Allow))
+	for i, rule := range policy.Allow {
+		rules[i] = agentapi.EgressRule{
+			CIDR:     rule.CIDR,
+			Host:     rule.Host,
+			Ports:    rule.Ports,
+			Protocol: rule.Protocol,
+		}
+	}
+
+	return &agentapi.EgressPolicy{
+		Allow:    rules,
+		AllowDNS: policy.AllowDNS,
+	}
+}
+
+func controlEgressPolicy(policy *agentapi.EgressPolicy) *controlapi.EgressPolicy {
+	if policy == nil {
+		return nil
+	}
+
+	rules := make([]controlapi.EgressRule, len(policy.Allow))
+	for i, rule := range policy.Allow {
+		rules[i] = controlapi.EgressRule{
+			CIDR:     rule.CIDR,
+			Host:     rule.Host,
+			Ports:    rule.Ports,
+			Protocol: rule.Protocol,
+		}
+	}
+
+	return &controlapi.EgressPolicy{
+		Allow:    rules,
+		AllowDNS: policy.AllowDNS,
+	}
+}
+
+// Restricts the egress of the given machine to the destinations allowed by the given policy,
+// resolving any DNS names in the policy to their current IPv4 addresses
+func (m *MachineManager) applyEgressPolicy(vm *runningFirecracker, policy *agentapi.EgressPolicy) error {
+	rules, err := m.egressRules(policy)
+	if err != nil {
+		return err
+	}
+
+	chain := egressChainPrefix + vm.vmmID
+	err = installEgressChain(chain, vm.ip.String(), rules)
+	if err != nil {
+		return err
+	}
+	vm.egressChain = chain
+
+	m.log.Info("Applied egress policy to machine",
+		slog.String("vmid", vm.vmmID),
+		slog.String("chain", chain),
+		slog.Int("rules", len(rules)),
+	)
+
+	return nil
+}
+
+// Generates the iptables rule specifications of the chain enforcing the given egress policy: return
+// traffic, the node's internal NATS server, DNS (if allowed) and the policy's destinations are
+// accepted, and everything else is dropped
+func (m *MachineManager) egressRules(policy *agentapi.EgressPolicy) ([][]string, error) {
+	rules := [][]string{
+		{"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"},
+		{"-d", *m.config.InternalNodeHost, "-p", "tcp", "--dport", strconv.Itoa(*m.config.InternalNodePort), "-j", "ACCEPT"},
+	}
+
+	if policy.AllowDNS {
+		rules = append(rules,
+			[]string{"-p", "udp", "--dport", "53", "-j", "ACCEPT"},
+			[]string{"-p", "tcp", "--dport", "53", "-j", "ACCEPT"},
+		)
+	}
+
+	for _, rule := range policy.Allow {
+		destinations, err := m.egressDestinations(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		protocol := rule.EffectiveProtocol()
+		for _, destination := range destinations {
+			if len(rule.Ports) == 0 {
+				rules = append(rules, []string{"-d", destination, "-p", protocol, "-j", "ACCEPT"})
+				continue
+			}
+
+			for _, port := range rule.Ports {
+				rules = append(rules, []string{"-d", destination, "-p", protocol, "--dport", strconv.Itoa(port), "-j", "ACCEPT"})
+			}
+		}
+	}
+
+	return append(rules, []string{"-j", "DROP"}), nil
+}
+
+// Returns the networks the given egress rule allows, in CIDR notation
+func (m *MachineManager) egressDestinations(rule agentapi.EgressRule) ([]string, error) {
+	if rule.CIDR != nil {
+		network, err := rule.Network()
+		if err != nil {
+			return nil, err
+		}
+		return []string{network.String()}, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(m.ctx, "ip4", strings.TrimSpace(*rule.Host))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve egress host %s: %s", *rule.Host, err)
+	}
+
+	destinations := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		destinations = append(destinations, ip.String()+"/32")
+	}
+	return destinations, nil
+}
@@ -0,0 +1,56 @@
+package nexnode
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Built-in chains from which traffic sent by a machine is jumped to its egress chain: traffic
+// routed beyond the host, and traffic to the host itself
+var egressHookChains = []string{"FORWARD", "INPUT"}
+
+// Creates the given iptables chain holding the given rules, and jumps to it all traffic sent
+// from the given (machine) address
+func installEgressChain(chain string, source string, rules [][]string) error {
+	err := iptables("-N", chain)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		err = iptables(append([]string{"-A", chain}, rule...)...)
+		if err != nil {
+			removeEgressChain(chain, source)
+			return err
+		}
+	}
+
+	for _, hook := range egressHookChains {
+		err = iptables("-I", hook, "-s", source, "-j", chain)
+		if err != nil {
+			removeEgressChain(chain, source)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes the given iptables chain and the jumps to it. Failures are ignored, as the chain may
+// have been partially installed
+func removeEgressChain(chain string, source string) {
+	for _, hook := range egressHookChains {
+		_ = iptables("-D", hook, "-s", source, "-j", chain)
+	}
+	_ = iptables("-F", chain)
+	_ = iptables("-X", chain)
+}
+
+func iptables(args ...string) error {
+	out, err := exec.Command("iptables", append([]string{"-w"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables %s failed: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
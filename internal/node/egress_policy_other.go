@@ -0,0 +1,12 @@
+//go:build !linux
+
+package nexnode
+
+import "errors"
+
+// Egress policies are enforced with iptables, which is only available on Linux
+func installEgressChain(chain string, source string, rules [][]string) error {
+	return errors.New("egress policies are not supported on this platform")
+}
+
+func removeEgressChain(chain string, source string) {}
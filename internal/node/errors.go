@@ -0,0 +1,50 @@
+package nexnode
+
+import (
+	"errors"
+	"fmt"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Errors returned by the machine manager and the control API listener, which embedders of the
+// node can test for with errors.Is. Control API responses to requests failing with one of them
+// carry the matching error code
+var (
+	ErrMachineNotFound   = errors.New("no such machine")
+	ErrPoolExhausted     = errors.New("no warm machine available")
+	ErrHandshakeTimeout  = errors.New("handshake timed out")
+	ErrNamespaceMismatch = errors.New("machine belongs to another namespace")
+	ErrSystemNamespace   = errors.New("the system namespace is reserved for nodes")
+)
+
+// An error concerning a particular machine
+type MachineError struct {
+	MachineId string
+	Err       error
+}
+
+func (e *MachineError) Error() string {
+	return fmt.Sprintf("machine %s: %s", e.MachineId, e.Err)
+}
+
+func (e *MachineError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the control API error code of the error, or the given fallback if it has none. A
+// namespace mismatch is reported as a missing machine, so that requests can't probe for the
+// existence of other namespaces' machines
+func errorCode(err error, fallback string) string {
+	switch {
+	case errors.Is(err, ErrMachineNotFound), errors.Is(err, ErrNamespaceMismatch):
+		return controlapi.ErrorCodeNotFound
+	case errors.Is(err, ErrPoolExhausted):
+		return controlapi.ErrorCodePoolExhausted
+	case errors.Is(err, ErrHandshakeTimeout):
+		return controlapi.ErrorCodeHandshakeTimeout
+	case errors.Is(err, ErrSystemNamespace):
+		return controlapi.ErrorCodeInvalidRequest
+	}
+	return fallback
+}
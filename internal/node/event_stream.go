@@ -0,0 +1,313 @@
+package nexnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	eventStreamPath = "/stream"
+
+	// Frames queued for a client beyond this many are dropped, and the client told how many
+	eventStreamBufferSize = 256
+	eventStreamWriteWait  = 10 * time.Second
+	eventStreamPongWait   = 60 * time.Second
+	eventStreamPingPeriod = eventStreamPongWait * 9 / 10
+)
+
+func (c *EventStreamEndpoint) validate() []error {
+	errs := make([]error, 0)
+	if c.Listen == "" {
+		errs = append(errs, errors.New("event stream listen address is required"))
+	}
+	if len(c.Tokens) == 0 {
+		errs = append(errs, errors.New("event stream requires at least one token"))
+	}
+	for digest, namespace := range c.Tokens {
+		raw, err := hex.DecodeString(digest)
+		if err != nil || len(raw) != sha256.Size {
+			errs = append(errs, fmt.Errorf("event stream token digest must be a hex-encoded SHA-256 digest: %s", digest))
+		}
+		if namespace == "" {
+			errs = append(errs, fmt.Errorf("event stream token %s requires a namespace, or *", digest))
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("event stream requires both a tls certificate and key, or neither"))
+	}
+	return errs
+}
+
+// Returns the namespace the given token may stream, if it's one of the endpoint's tokens
+func (c *EventStreamEndpoint) tokenNamespace(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	digest := sha256.Sum256([]byte(token))
+	encoded := []byte(hex.EncodeToString(digest[:]))
+	for d, namespace := range c.Tokens {
+		if subtle.ConstantTimeCompare(encoded, []byte(strings.ToLower(d))) == 1 {
+			return namespace, true
+		}
+	}
+	return "", false
+}
+
+func (c *EventStreamEndpoint) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Serves the node's event stream endpoint until the context is done. Each client is streamed the
+// events and logs of its namespace read from NATS, so that dashboards can follow workloads
+// without a NATS client of their own
+func (m *MachineManager) serveEventStream(ctx context.Context) error {
+	config := m.config.EventStream
+
+	upgrader := &websocket.Upgrader{CheckOrigin: config.checkOrigin}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(eventStreamPath, func(w http.ResponseWriter, r *http.Request) {
+		m.handleEventStream(ctx, upgrader, w, r)
+	})
+	srv := &http.Server{
+		Addr:              config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	m.log.Info("Event stream listening", slog.String("addr", config.Listen), slog.Bool("tls", config.TLSCertFile != ""))
+
+	var err error
+	if config.TLSCertFile != "" {
+		err = srv.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (m *MachineManager) handleEventStream(ctx context.Context, upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	token := query.Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	allowed, ok := m.config.EventStream.tokenNamespace(token)
+	if !ok {
+		m.log.Warn("Rejected event stream connection with an invalid token", slog.String("remote_addr", r.RemoteAddr))
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		namespace = allowed
+	}
+	if allowed != anyNamespace && namespace != allowed {
+		http.Error(w, fmt.Sprintf("token may not stream namespace %s", namespace), http.StatusForbidden)
+		return
+	}
+	if strings.ContainsAny(namespace, ".>") || (namespace != anyNamespace && strings.Contains(namespace, "*")) {
+		http.Error(w, fmt.Sprintf("invalid namespace: %s", namespace), http.StatusBadRequest)
+		return
+	}
+
+	kinds := []string{controlapi.StreamFrameKindEvent, controlapi.StreamFrameKindLog}
+	if raw := query.Get("kinds"); raw != "" {
+		kinds = strings.Split(raw, ",")
+		for _, kind := range kinds {
+			if kind != controlapi.StreamFrameKindEvent && kind != controlapi.StreamFrameKindLog {
+				http.Error(w, fmt.Sprintf("invalid kind: %s", kind), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already responded
+		return
+	}
+	defer conn.Close()
+
+	stream := &eventStream{
+		workload: query.Get("workload"),
+		frames:   make(chan controlapi.StreamFrame, eventStreamBufferSize),
+	}
+
+	subs := make([]*nats.Subscription, 0, len(kinds))
+	defer func() {
+		for _, sub := range subs {
+			_ = sub.Unsubscribe()
+		}
+	}()
+	for _, kind := range kinds {
+		var sub *nats.Subscription
+		switch kind {
+		case controlapi.StreamFrameKindEvent:
+			// $NEX.events.{namespace}.{event_type}
+			sub, err = m.nc.Subscribe(controlapi.EventSubject(namespace, "*"), stream.handleEvent)
+		case controlapi.StreamFrameKindLog:
+			workload := "*"
+			if stream.workload != "" {
+				workload = stream.workload
+			}
+			sub, err = m.nc.Subscribe(controlapi.LogSubject(namespace, workload, "*", "*"), stream.handleLog)
+		}
+		if err != nil {
+			m.log.Error("Failed to subscribe for event stream", slog.String("namespace", namespace), slog.Any("err", err))
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "subscription failed"), time.Now().Add(eventStreamWriteWait))
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	m.log.Debug("Event stream client connected",
+		slog.String("namespace", namespace),
+		slog.String("workload_name", stream.workload),
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// clients aren't expected to send anything, but reading is how closes and pongs are noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(eventStreamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(eventStreamPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(eventStreamPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "node stopping"), time.Now().Add(eventStreamWriteWait))
+			return
+		case <-closed:
+			return
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventStreamWriteWait))
+			if err != nil {
+				return
+			}
+		case frame := <-stream.frames:
+			frame.Dropped = int(stream.dropped.Swap(0))
+			_ = conn.SetWriteDeadline(time.Now().Add(eventStreamWriteWait))
+			err := conn.WriteJSON(frame)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// The frames queued for a single event stream client, filtered by its workload
+type eventStream struct {
+	workload string
+	frames   chan controlapi.StreamFrame
+	dropped  atomic.Int64
+}
+
+func (s *eventStream) handleEvent(m *nats.Msg) {
+	tokens := strings.Split(m.Subject, ".")
+	if len(tokens) != 4 {
+		return
+	}
+
+	// events about a workload name it in their data
+	var event struct {
+		Data struct {
+			WorkloadName string `json:"workload_name"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(m.Data, &event)
+	if s.workload != "" && event.Data.WorkloadName != s.workload {
+		return
+	}
+
+	s.enqueue(controlapi.StreamFrame{
+		Kind:      controlapi.StreamFrameKindEvent,
+		Namespace: tokens[2],
+		EventType: tokens[3],
+		Workload:  event.Data.WorkloadName,
+		Data:      json.RawMessage(m.Data),
+	})
+}
+
+func (s *eventStream) handleLog(m *nats.Msg) {
+	var raw controlapi.RawLog
+	if json.Unmarshal(m.Data, &raw) != nil {
+		return
+	}
+
+	tokens, ok := controlapi.ParseLogSubject(m.Subject, raw.MachineId)
+	if !ok {
+		return
+	}
+
+	s.enqueue(controlapi.StreamFrame{
+		Kind:      controlapi.StreamFrameKindLog,
+		Namespace: tokens.Namespace,
+		NodeId:    tokens.NodeId,
+		Workload:  tokens.Workload,
+		Data:      json.RawMessage(m.Data),
+	})
+}
+
+// Queues the frame without blocking the NATS subscription, dropping it if the client can't keep up
+func (s *eventStream) enqueue(frame controlapi.StreamFrame) {
+	select {
+	case s.frames <- frame:
+	default:
+		s.dropped.Add(1)
+	}
+}
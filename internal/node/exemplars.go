@@ -0,0 +1,182 @@
+package nexnode
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// How long the slowest invocation of a function remains the exemplar of its runtime before a
+// faster invocation may replace it
+const exemplarWindow = time.Minute
+
+// Names of the prometheus series which are given exemplars, as the exporter names them
+const (
+	functionRunTimeSeries        = "nex_function_runtime_nanosec_total"
+	functionFailedTriggersSeries = "nex_function_failed_trigger_total"
+)
+
+// The trace of a function invocation, attached as an exemplar to the function's metrics
+type functionExemplar struct {
+	traceId   string
+	spanId    string
+	value     float64
+	timestamp time.Time
+}
+
+// The exemplars of each function's runtime and failure metrics, keyed by workload name: the
+// trace of its slowest recent invocation, and of its most recent failed invocation
+type functionExemplars struct {
+	mutex    sync.Mutex
+	runtimes map[string]*functionExemplar
+	failures map[string]*functionExemplar
+}
+
+func newFunctionExemplars() *functionExemplars {
+	return &functionExemplars{
+		runtimes: make(map[string]*functionExemplar),
+		failures: make(map[string]*functionExemplar),
+	}
+}
+
+// Records the invocation traced by the given span as the exemplar of the workload's runtime if it
+// is the slowest within the exemplar window. Untraced invocations are ignored
+func (e *functionExemplars) recordRuntime(span trace.SpanContext, workloadName string, runtimeNs int64) {
+	if !span.IsValid() {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	current, ok := e.runtimes[workloadName]
+	if ok && now.Sub(current.timestamp) < exemplarWindow && current.value >= float64(runtimeNs) {
+		return
+	}
+	e.runtimes[workloadName] = newFunctionExemplar(span, float64(runtimeNs), now)
+}
+
+// Records the invocation traced by the given span as the exemplar of the workload's failures.
+// Untraced invocations are ignored
+func (e *functionExemplars) recordFailure(span trace.SpanContext, workloadName string) {
+	if !span.IsValid() {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failures[workloadName] = newFunctionExemplar(span, 1, time.Now())
+}
+
+func (e *functionExemplars) lookup(series string, workloadName string) *functionExemplar {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	switch series {
+	case functionRunTimeSeries:
+		return e.runtimes[workloadName]
+	case functionFailedTriggersSeries:
+		return e.failures[workloadName]
+	}
+	return nil
+}
+
+func newFunctionExemplar(span trace.SpanContext, value float64, timestamp time.Time) *functionExemplar {
+	return &functionExemplar{
+		traceId:   span.TraceID().String(),
+		spanId:    span.SpanID().String(),
+		value:     value,
+		timestamp: timestamp,
+	}
+}
+
+// Registers the collector of the OTel prometheus exporter wrapped so that it attaches exemplars
+type exemplarRegisterer struct {
+	prom.Registerer
+	exemplars *functionExemplars
+}
+
+func (r *exemplarRegisterer) Register(c prom.Collector) error {
+	return r.Registerer.Register(&exemplarCollector{Collector: c, exemplars: r.exemplars})
+}
+
+// Attaches the recorded function exemplars to the per-workload series of the function runtime and
+// failure metrics collected by the wrapped collector. The OTel SDK in use can't record exemplars
+// itself, and they're only exposed to scrapers negotiating the OpenMetrics format
+type exemplarCollector struct {
+	prom.Collector
+	exemplars *functionExemplars
+}
+
+func (c *exemplarCollector) Collect(ch chan<- prom.Metric) {
+	collected := make(chan prom.Metric)
+	go func() {
+		c.Collector.Collect(collected)
+		close(collected)
+	}()
+
+	for metric := range collected {
+		ch <- c.withExemplar(metric)
+	}
+}
+
+func (c *exemplarCollector) withExemplar(metric prom.Metric) prom.Metric {
+	series := seriesName(metric.Desc())
+	if series != functionRunTimeSeries && series != functionFailedTriggersSeries {
+		return metric
+	}
+
+	var m dto.Metric
+	if metric.Write(&m) != nil {
+		return metric
+	}
+	workloadName := ""
+	for _, label := range m.GetLabel() {
+		if label.GetName() == "workload_name" {
+			workloadName = label.GetValue()
+		}
+	}
+	if workloadName == "" {
+		return metric
+	}
+
+	exemplar := c.exemplars.lookup(series, workloadName)
+	if exemplar == nil {
+		return metric
+	}
+
+	withExemplar, err := prom.NewMetricWithExemplars(metric, prom.Exemplar{
+		Value:     exemplar.value,
+		Timestamp: exemplar.timestamp,
+		Labels: prom.Labels{
+			"trace_id": exemplar.traceId,
+			"span_id":  exemplar.spanId,
+		},
+	})
+	if err != nil {
+		return metric
+	}
+	return withExemplar
+}
+
+// Returns the fully qualified name of the described metric, which descriptions don't expose
+// other than in their string form
+func seriesName(desc *prom.Desc) string {
+	_, after, ok := strings.Cut(desc.String(), "fqName: ")
+	if !ok {
+		return ""
+	}
+	var name string
+	_, err := fmt.Sscanf(after, "%q", &name)
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package nexnode
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Handshakes outliving their machine, e.g., of a machine stopped while its handshake was
+	// being handled, are forgotten once they're this old
+	handshakeRetention = 10 * time.Minute
+
+	maxRetainedHandshakes = 4096
+)
+
+// Handshakes received from agents, by machine ID. A machine's handshake is forgotten when the
+// machine stops. Those of machines which are gone anyway expire after the retention period, and
+// the oldest of them are evicted once the store is full. Handshakes of running machines are never
+// expired or evicted, as warm machines may wait in their pool indefinitely
+type handshakeStore struct {
+	mutex    sync.Mutex
+	received map[string]time.Time
+	// whether any agent has completed its handshake since the node started
+	any bool
+}
+
+func newHandshakeStore() *handshakeStore {
+	return &handshakeStore{
+		received: make(map[string]time.Time),
+	}
+}
+
+// Records the handshake of the given machine. Running reports whether a machine is still running,
+// and must not call back into the store
+func (s *handshakeStore) record(vmID string, at time.Time, running func(vmID string) bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(at, running)
+	s.received[vmID] = at
+	s.any = true
+}
+
+// Whether the agent in the given machine has completed its handshake
+func (s *handshakeStore) completed(vmID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.received[vmID]
+	return ok
+}
+
+func (s *handshakeStore) anyCompleted() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.any
+}
+
+func (s *handshakeStore) forget(vmID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.received, vmID)
+}
+
+// Must be called with the mutex held
+func (s *handshakeStore) prune(now time.Time, running func(vmID string) bool) {
+	for vmID, at := range s.received {
+		if now.Sub(at) > handshakeRetention && !running(vmID) {
+			delete(s.received, vmID)
+		}
+	}
+
+	for len(s.received) >= maxRetainedHandshakes {
+		var oldest string
+		for vmID, at := range s.received {
+			if (oldest == "" || at.Before(s.received[oldest])) && !running(vmID) {
+				oldest = vmID
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(s.received, oldest)
+	}
+}
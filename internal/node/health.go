@@ -0,0 +1,95 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Called when the node server receives the result of a workload health check from an agent
+// via internal NATS. The most recent result is cached on the VM and reported by INFO
+func (m *MachineManager) handleAgentHealth(msg *nats.Msg) {
+	// agentint.{vmid}.health
+	tokens := strings.Split(msg.Subject, ".")
+	vmID := tokens[1]
+
+	vm, ok := m.lookupMachine(vmID)
+	if !ok {
+		m.log.Warn("Received a health check result from an unknown VM.")
+		return
+	}
+
+	var result agentapi.HealthCheckResult
+	err := json.Unmarshal(msg.Data, &result)
+	if err != nil {
+		m.log.Error("Failed to unmarshal health check result from agent", slog.Any("err", err))
+		return
+	}
+
+	if vm.healthy() != result.Healthy {
+		var reason string
+		if result.Message != nil {
+			reason = *result.Message
+		}
+
+		m.log.Info("Workload health changed",
+			slog.String("vmid", vmID),
+			slog.Bool("healthy", result.Healthy),
+			slog.String("reason", reason),
+		)
+
+		event := controlapi.TimelineEventHealthy
+		state := controlapi.WorkloadStateRunning
+		if !result.Healthy {
+			event = controlapi.TimelineEventUnhealthy
+			state = controlapi.WorkloadStateUnhealthy
+		}
+		m.recordMachineEvent(vm, event, reason)
+		m.publishWorkloadLifecycle(vm, state, reason)
+	}
+
+	vm.lastHealthCheck.Store(&result)
+}
+
+// A VM is considered healthy unless its workload declared a health check and
+// the most recent result of that check says otherwise
+func (vm *runningFirecracker) healthy() bool {
+	result := vm.lastHealthCheck.Load()
+	return result == nil || result.Healthy
+}
+
+func agentHealthCheck(hc *controlapi.HealthCheck) *agentapi.HealthCheck {
+	if hc == nil {
+		return nil
+	}
+
+	return &agentapi.HealthCheck{
+		Type:           hc.Type,
+		Command:        hc.Command,
+		URL:            hc.URL,
+		Subject:        hc.Subject,
+		NatsUrl:        hc.NatsUrl,
+		IntervalMillis: hc.IntervalMillis,
+		TimeoutMillis:  hc.TimeoutMillis,
+	}
+}
+
+func controlHealthCheck(hc *agentapi.HealthCheck) *controlapi.HealthCheck {
+	if hc == nil {
+		return nil
+	}
+
+	return &controlapi.HealthCheck{
+		Type:           hc.Type,
+		Command:        hc.Command,
+		URL:            hc.URL,
+		Subject:        hc.Subject,
+		NatsUrl:        hc.NatsUrl,
+		IntervalMillis: hc.IntervalMillis,
+		TimeoutMillis:  hc.TimeoutMillis,
+	}
+}
@@ -0,0 +1,28 @@
+package nexnode
+
+import (
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+func agentWorkloadHook(hook *controlapi.WorkloadHook) *agentapi.WorkloadHook {
+	if hook == nil {
+		return nil
+	}
+
+	return &agentapi.WorkloadHook{
+		Command:       hook.Command,
+		TimeoutMillis: hook.TimeoutMillis,
+	}
+}
+
+func controlWorkloadHook(hook *agentapi.WorkloadHook) *controlapi.WorkloadHook {
+	if hook == nil {
+		return nil
+	}
+
+	return &controlapi.WorkloadHook{
+		Command:       hook.Command,
+		TimeoutMillis: hook.TimeoutMillis,
+	}
+}
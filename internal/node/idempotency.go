@@ -0,0 +1,106 @@
+package nexnode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// How long the response to a deploy request is remembered under its idempotency key
+	idempotencyKeyRetention = 10 * time.Minute
+	// How long a duplicate deploy request waits for the deploy request it duplicates to complete
+	idempotentDeployWaitTimeout = 30 * time.Second
+
+	maxIdempotencyKeyLength = 128
+)
+
+// A deploy request given an idempotency key, which is either in flight or has completed
+type idempotentDeploy struct {
+	workload string
+	done     chan struct{}
+	// the response to the completed deploy request; nil if it failed
+	response []byte
+	expires  time.Time
+}
+
+// The deploy requests this node has recently completed successfully, and those in flight, keyed
+// by {namespace}/{idempotency key}. Failed deploy requests are forgotten, so they may be retried
+type deployIdempotency struct {
+	mutex   sync.Mutex
+	deploys map[string]*idempotentDeploy
+}
+
+func newDeployIdempotency() *deployIdempotency {
+	return &deployIdempotency{
+		deploys: make(map[string]*idempotentDeploy),
+	}
+}
+
+func validateIdempotencyKey(key string) error {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("idempotency key must be between 1 and %d characters", maxIdempotencyKeyLength)
+	}
+	return nil
+}
+
+// Claims the idempotency key for a deploy request of the given workload. If the key is unclaimed,
+// the caller must complete the returned deploy once it's done. Otherwise this waits for the deploy
+// request holding the key to complete, returning its response, or nil if it failed, in which case
+// the key is claimed again
+func (d *deployIdempotency) claim(namespace string, key string, workload string) (*idempotentDeploy, []byte, error) {
+	id := fmt.Sprintf("%s/%s", namespace, key)
+	timeout := time.After(idempotentDeployWaitTimeout)
+
+	for {
+		d.mutex.Lock()
+		d.prune()
+		existing, ok := d.deploys[id]
+		if !ok {
+			deploy := &idempotentDeploy{workload: workload, done: make(chan struct{})}
+			d.deploys[id] = deploy
+			d.mutex.Unlock()
+			return deploy, nil, nil
+		}
+		d.mutex.Unlock()
+
+		if existing.workload != workload {
+			return nil, nil, fmt.Errorf("idempotency key %s was used to deploy another workload", key)
+		}
+
+		select {
+		case <-existing.done:
+			if existing.response != nil {
+				return nil, existing.response, nil
+			}
+		case <-timeout:
+			return nil, nil, fmt.Errorf("deploy request with idempotency key %s is still in progress", key)
+		}
+	}
+}
+
+// Completes a deploy request which claimed its idempotency key, remembering its response if it
+// succeeded, or forgetting the key if it failed (response is nil)
+func (d *deployIdempotency) complete(namespace string, key string, deploy *idempotentDeploy, response []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := fmt.Sprintf("%s/%s", namespace, key)
+	if response == nil {
+		delete(d.deploys, id)
+	} else {
+		deploy.response = response
+		deploy.expires = time.Now().Add(idempotencyKeyRetention)
+	}
+	close(deploy.done)
+}
+
+// Must be called with the mutex held
+func (d *deployIdempotency) prune() {
+	now := time.Now()
+	for id, deploy := range d.deploys {
+		if !deploy.expires.IsZero() && now.After(deploy.expires) {
+			delete(d.deploys, id)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package nexnode
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// A function workload which is undeployed (releasing its machine) after receiving no triggers
+// for its idle timeout. Its artifact remains cached and its trigger subscriptions remain in
+// place, so the next trigger cold-starts the function in a machine from the warm pool
+type idleFunction struct {
+	// the ID of the machine the function was originally deployed to, by which it is
+	// known to clients for as long as it remains deployed
+	id        string
+	namespace string
+	request   *agentapi.DeployRequest
+
+	mutex       sync.Mutex
+	vm          *runningFirecracker // nil while scaled to zero
+	inflight    int
+	lastTrigger time.Time
+	subs        []*nats.Subscription
+	limiter     *triggerLimiter
+	stopped     bool
+	done        chan struct{}
+}
+
+// Subscribes to the trigger subjects of a newly deployed function which scales to zero when idle
+func (m *MachineManager) subscribeIdleFunctionTriggers(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	fn := &idleFunction{
+		id:          vm.vmmID,
+		namespace:   vm.namespace,
+		request:     request,
+		vm:          vm,
+		lastTrigger: time.Now().UTC(),
+		done:        make(chan struct{}),
+	}
+
+	if request.TriggerConcurrency != nil {
+		fn.limiter = m.newTriggerLimiter(fn.namespace, request)
+	}
+
+	for _, tsub := range request.TriggerSubjects {
+		handler := nats.MsgHandler(m.generateIdleFunctionTriggerHandler(fn, tsub))
+		if fn.limiter != nil {
+			handler = fn.limiter.wrap(handler)
+		}
+
+		sub, err := m.subscribeTrigger(request, tsub, handler)
+		if err != nil {
+			for _, sub := range fn.subs {
+				_ = sub.Unsubscribe()
+			}
+			if fn.limiter != nil {
+				fn.limiter.stop()
+			}
+			return err
+		}
+
+		m.log.Info("Created trigger subject subscription for deployed idle function",
+			slog.String("vmid", vm.vmmID),
+			slog.String("trigger_subject", tsub),
+			slog.Duration("idle_timeout", request.IdleTimeout()),
+		)
+
+		fn.subs = append(fn.subs, sub)
+	}
+
+	vm.function = fn
+	m.trackIdleFunction(fn)
+
+	go m.reapIdleFunction(fn)
+	return nil
+}
+
+func (m *MachineManager) generateIdleFunctionTriggerHandler(fn *idleFunction, tsub string) func(msg *nats.Msg) {
+	return func(msg *nats.Msg) {
+		vm, err := m.acquireIdleFunction(fn)
+		if err != nil {
+			m.log.Error("Failed to start idle function for trigger",
+				slog.String("id", fn.id),
+				slog.String("trigger_subject", tsub),
+				slog.Any("err", err),
+			)
+			return
+		}
+		defer fn.release()
+
+		m.handleTrigger(vm, tsub, vm.deployRequest, msg)
+	}
+}
+
+// Returns the machine running the function, cold-starting the function in a machine from the
+// warm pool if it's scaled to zero. The function won't be scaled to zero until released
+func (m *MachineManager) acquireIdleFunction(fn *idleFunction) (*runningFirecracker, error) {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	if fn.stopped {
+		return nil, errors.New("function has been stopped")
+	}
+
+	if fn.vm == nil {
+		vm, err := m.coldStartIdleFunction(fn)
+		if err != nil {
+			return nil, err
+		}
+		fn.vm = vm
+	}
+
+	fn.inflight++
+	return fn.vm, nil
+}
+
+func (fn *idleFunction) release() {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	fn.inflight--
+	fn.lastTrigger = time.Now().UTC()
+}
+
+// Deploys a function which was scaled to zero into a machine from the warm pool
+func (m *MachineManager) coldStartIdleFunction(fn *idleFunction) (*runningFirecracker, error) {
+	started := time.Now()
+
+	vm, err := m.acquireMachine(fn.request.Template(), m.deployRequestMachineSize(fn.request), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request := *fn.request
+	if request.Credentials != nil {
+		// the previous machine's credentials were revoked when it was stopped
+		credentials, err := m.mintWorkloadCredentials(controlCredentialsRequest(request.Credentials), *request.WorkloadName, fn.namespace)
+		if err != nil {
+			_ = m.StopMachine(vm.vmmID, false)
+			return nil, err
+		}
+		request.Credentials = credentials
+	}
+
+	err = m.submitDeployment(vm, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.function = fn
+	err = m.workloadDeployed(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	latency := time.Since(started).Milliseconds()
+	m.t.functionColdStartLatency.Record(m.ctx, latency)
+	m.t.functionColdStartLatency.Record(m.ctx, latency, metric.WithAttributes(attribute.String("namespace", fn.namespace)))
+	m.t.functionColdStartLatency.Record(m.ctx, latency, metric.WithAttributes(attribute.String("workload_name", *request.WorkloadName)))
+
+	m.log.Info("Cold-started idle function",
+		slog.String("id", fn.id),
+		slog.String("vmid", vm.vmmID),
+		slog.Int64("latency_ms", latency),
+	)
+
+	return vm, nil
+}
+
+// Periodically scales the function to zero once it has gone its idle timeout without a trigger
+func (m *MachineManager) reapIdleFunction(fn *idleFunction) {
+	timeout := fn.request.IdleTimeout()
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-fn.done:
+			return
+		case <-ticker.C:
+			fn.mutex.Lock()
+			vm := fn.vm
+			idle := vm != nil && fn.inflight == 0 && time.Since(fn.lastTrigger) >= timeout
+			if idle {
+				fn.vm = nil
+			}
+			fn.mutex.Unlock()
+
+			if idle {
+				m.log.Info("Scaling idle function to zero", slog.String("id", fn.id), slog.String("vmid", vm.vmmID))
+				m.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, fmt.Sprintf("Idle for %s; scaling to zero", timeout))
+
+				err := m.StopMachine(vm.vmmID, true)
+				if err != nil {
+					m.log.Warn("Failed to stop idle function machine", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+				}
+			}
+		}
+	}
+}
+
+// Called when the machine running a function is stopped. Unless the function is being scaled to
+// zero, the function itself is being stopped, so its trigger subscriptions are drained
+func (m *MachineManager) detachIdleFunction(vm *runningFirecracker) {
+	fn := vm.function
+
+	fn.mutex.Lock()
+	current := fn.vm == vm
+	if current {
+		fn.vm = nil
+	}
+	fn.mutex.Unlock()
+
+	if current {
+		m.stopIdleFunction(fn)
+	}
+}
+
+// Stops a function for good, draining its trigger subscriptions and stopping the machine
+// running it, if any
+func (m *MachineManager) stopIdleFunction(fn *idleFunction) {
+	fn.mutex.Lock()
+	if fn.stopped {
+		fn.mutex.Unlock()
+		return
+	}
+
+	fn.stopped = true
+	vm := fn.vm
+	fn.vm = nil
+	close(fn.done)
+	fn.mutex.Unlock()
+
+	for _, sub := range fn.subs {
+		err := sub.Drain()
+		if err != nil {
+			m.log.Warn("Failed to drain idle function trigger subscription", slog.String("subject", sub.Subject), slog.Any("err", err))
+		}
+	}
+
+	if fn.limiter != nil {
+		fn.limiter.stop()
+	}
+
+	m.forgetIdleFunction(fn.id)
+	m.forgetRecoverableWorkload(fn.id)
+
+	if vm != nil {
+		_ = m.StopMachine(vm.vmmID, true)
+	}
+}
+
+// Returns the function deployed with the given ID, or the function currently running in the
+// machine with the given ID, if any
+func (m *MachineManager) lookupIdleFunction(id string) *idleFunction {
+	if fn, ok := m.idleFunctionByID(id); ok {
+		return fn
+	}
+
+	if vm, ok := m.lookupMachine(id); ok {
+		return vm.function
+	}
+
+	return nil
+}
+
+// Summarizes the functions in the given namespace which are currently scaled to zero, and so
+// aren't running in any machine
+func (m *MachineManager) summarizeIdleFunctions(namespace string, selector map[string]string) []controlapi.MachineSummary {
+	summaries := make([]controlapi.MachineSummary, 0)
+	for _, fn := range m.idleFunctionsSnapshot() {
+		fn.mutex.Lock()
+		scaledToZero := fn.vm == nil && !fn.stopped
+		fn.mutex.Unlock()
+
+		if !scaledToZero || fn.namespace != namespace || !controlapi.MatchesSelector(selector, fn.request.Labels) {
+			continue
+		}
+
+		var desc string
+		if fn.request.Description != nil {
+			desc = *fn.request.Description
+		}
+
+		summaries = append(summaries, controlapi.MachineSummary{
+			Id:      fn.id,
+			Healthy: true,
+			State:   controlapi.MachineStateScaledToZero,
+			Labels:  fn.request.Labels,
+			Workload: controlapi.WorkloadSummary{
+				Name:         fn.request.DecodedClaims.Subject,
+				Description:  desc,
+				WorkloadType: *fn.request.WorkloadType,
+			},
+		})
+	}
+
+	return summaries
+}
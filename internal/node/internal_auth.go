@@ -0,0 +1,150 @@
+package nexnode
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Username reported by the internal NATS server for the node's own connection
+const internalNodeUser = "node"
+
+// Authenticates connections to the internal NATS server by nkey. The node connects with a key of
+// its own and is granted every permission; each agent connects with a key issued for its machine,
+// which only permits the subjects scoped to the machine's vmid, so a compromised agent can't
+// observe or impersonate other machines
+type internalAuth struct {
+	nodeKey nkeys.KeyPair
+	nodePub string
+
+	mutex sync.RWMutex
+	// vmid of each agent, keyed by the public key issued to it
+	agents map[string]string
+}
+
+func newInternalAuth() (*internalAuth, error) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create internal NATS user: %s", err)
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &internalAuth{
+		nodeKey: kp,
+		nodePub: pub,
+		agents:  make(map[string]string),
+	}, nil
+}
+
+// Issues a new nkey for the agent of the given machine, returning its seed
+func (a *internalAuth) issue(vmID string) (string, error) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return "", fmt.Errorf("failed to create internal NATS user for machine %s: %s", vmID, err)
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	seed, err := kp.Seed()
+	if err != nil {
+		return "", err
+	}
+
+	a.mutex.Lock()
+	a.agents[pub] = vmID
+	a.mutex.Unlock()
+
+	return string(seed), nil
+}
+
+// Revokes the nkey issued to the agent of the given machine, preventing it from reconnecting
+func (a *internalAuth) revoke(vmID string) {
+	// managers created without internal authentication (e.g., by specs) have issued no nkeys
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for pub, id := range a.agents {
+		if id == vmID {
+			delete(a.agents, pub)
+		}
+	}
+}
+
+// Check implements server.Authentication
+func (a *internalAuth) Check(c server.ClientAuthentication) bool {
+	opts := c.GetOpts()
+	if opts == nil || opts.Nkey == "" {
+		return false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(opts.Sig)
+	if err != nil {
+		sig, err = base64.StdEncoding.DecodeString(opts.Sig)
+		if err != nil {
+			return false
+		}
+	}
+
+	pub, err := nkeys.FromPublicKey(opts.Nkey)
+	if err != nil || pub.Verify(c.GetNonce(), sig) != nil {
+		return false
+	}
+
+	if opts.Nkey == a.nodePub {
+		c.RegisterUser(&server.User{Username: internalNodeUser})
+		return true
+	}
+
+	a.mutex.RLock()
+	vmID, ok := a.agents[opts.Nkey]
+	a.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+
+	c.RegisterUser(&server.User{
+		Username:    vmID,
+		Permissions: agentPermissions(vmID),
+	})
+	return true
+}
+
+// Permissions of the agent of the given machine: its own agentint.{vmid}.> subjects, the handshake
+// subject, replies to requests made of it and its own inbox prefix. Agents have no access to
+// JetStream; they read their workload's artifact from the node (see agentapi.ArtifactSubject),
+// which serves each only its own
+func agentPermissions(vmID string) *server.Permissions {
+	return &server.Permissions{
+		Publish: &server.SubjectPermission{
+			Allow: []string{
+				agentapi.NexAgentSubjectHandshake,
+				fmt.Sprintf("agentint.%s.>", vmID),
+			},
+		},
+		Subscribe: &server.SubjectPermission{
+			Allow: []string{
+				fmt.Sprintf("agentint.%s.>", vmID),
+				fmt.Sprintf("%s.>", agentapi.AgentInboxPrefix(vmID)),
+			},
+		},
+		Response: &server.ResponsePermission{
+			MaxMsgs: server.DEFAULT_ALLOW_RESPONSE_MAX_MSGS,
+			Expires: server.DEFAULT_ALLOW_RESPONSE_EXPIRATION,
+		},
+	}
+}
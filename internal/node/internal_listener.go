@@ -0,0 +1,71 @@
+package nexnode
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Name of the iptables chain dropping traffic to the internal NATS listener from anywhere but
+	// the node's CNI network and the host itself
+	internalListenerChain = "NEX-NATSINT"
+
+	// Name of the dummy interface holding the CNI gateway address until machines' host veths do,
+	// so that the internal NATS server can bind to it before any machine is started
+	internalListenerLink = "nex-natsint"
+
+	internalListenerProbeTimeout = 2 * time.Second
+)
+
+// The address the internal NATS server listens on: the CNI gateway through which machines reach
+// the node or, without sandboxes, the loopback address
+func (c *NodeConfiguration) internalListenHost() string {
+	if c.NoSandbox {
+		return noSandboxInternalNodeHost
+	}
+	if c.InternalNodeHost == nil {
+		return ""
+	}
+	return *c.InternalNodeHost
+}
+
+// Fails if the internal NATS listener would be bound to every interface of the host, exposing
+// agentint traffic to anything that can reach the host
+func checkInternalListenHost(host string) error {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("internal node host %q is not an IP address", host)
+	}
+	if ip.IsUnspecified() {
+		return fmt.Errorf("internal node host %s exposes the internal NATS listener on every interface", host)
+	}
+	return nil
+}
+
+// Verifies the running internal NATS server is bound to a specific address and refuses clients
+// without credentials
+func verifyInternalListener(s *server.Server) error {
+	addr, ok := s.Addr().(*net.TCPAddr)
+	if !ok {
+		return errors.New("internal NATS server is not listening")
+	}
+	if addr.IP.IsUnspecified() {
+		return fmt.Errorf("internal NATS server is listening on every interface (%s)", addr)
+	}
+
+	nc, err := nats.Connect(s.ClientURL(), nats.NoReconnect(), nats.Timeout(internalListenerProbeTimeout))
+	if err == nil {
+		nc.Close()
+		return errors.New("internal NATS server accepts clients without credentials")
+	}
+	if !errors.Is(err, nats.ErrAuthorization) {
+		return fmt.Errorf("failed to verify internal NATS server requires credentials: %s", err)
+	}
+
+	return nil
+}
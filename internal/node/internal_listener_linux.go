@@ -0,0 +1,95 @@
+package nexnode
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/vishvananda/netlink"
+)
+
+// Ensures the given address is assigned to the host, adding it to a dummy interface when it
+// isn't. The CNI gateway address is otherwise only held by machines' host veths, which don't
+// exist until machines are started
+func ensureInternalListenAddress(host string) error {
+	ip := net.ParseIP(host)
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list host addresses: %s", err)
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.IP.Equal(ip) {
+			return nil
+		}
+	}
+
+	link, err := netlink.LinkByName(internalListenerLink)
+	if err != nil {
+		link = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: internalListenerLink}}
+		err = netlink.LinkAdd(link)
+		if err != nil {
+			return fmt.Errorf("failed to add interface %s: %s", internalListenerLink, err)
+		}
+	}
+
+	err = netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}})
+	if err != nil {
+		return fmt.Errorf("failed to assign %s to interface %s: %s", host, internalListenerLink, err)
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+// Drops traffic to the internal NATS listener other than from the subnets of the node's CNI
+// network and from the host itself, replacing the rules installed by a previous node process
+func installInternalListenerFirewall(host string, port int, network string) error {
+	list, err := libcni.LoadConfList(cniConfDir, network)
+	if err != nil {
+		return fmt.Errorf("failed to load CNI network %s: %s", network, err)
+	}
+	subnets := cniSubnets(list)
+	if len(subnets) == 0 {
+		return fmt.Errorf("CNI network %s has no subnets", network)
+	}
+
+	removeInternalListenerFirewall(host)
+
+	rules := [][]string{
+		{"-p", "tcp", "!", "--dport", strconv.Itoa(port), "-j", "RETURN"},
+		{"-i", "lo", "-j", "RETURN"},
+	}
+	for _, subnet := range subnets {
+		rules = append(rules, []string{"-s", subnet.String(), "-j", "RETURN"})
+	}
+	rules = append(rules, []string{"-j", "DROP"})
+
+	err = iptables("-N", internalListenerChain)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		err = iptables(append([]string{"-A", internalListenerChain}, rule...)...)
+		if err != nil {
+			removeInternalListenerFirewall(host)
+			return err
+		}
+	}
+
+	err = iptables("-I", "INPUT", "-d", host, "-p", "tcp", "-j", internalListenerChain)
+	if err != nil {
+		removeInternalListenerFirewall(host)
+		return err
+	}
+
+	return nil
+}
+
+// Removes the internal NATS listener's iptables chain and the jump to it. Failures are ignored,
+// as the chain may not exist
+func removeInternalListenerFirewall(host string) {
+	_ = iptables("-D", "INPUT", "-d", host, "-p", "tcp", "-j", internalListenerChain)
+	_ = iptables("-F", internalListenerChain)
+	_ = iptables("-X", internalListenerChain)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package nexnode
+
+import "errors"
+
+// Firecracker machines, and so the CNI gateway they reach the node through, are only available
+// on Linux
+func ensureInternalListenAddress(host string) error {
+	return errors.New("the internal NATS listener can only be bound to a CNI gateway on Linux")
+}
+
+func installInternalListenerFirewall(host string, port int, network string) error {
+	return errors.New("the internal NATS listener firewall is only supported on Linux")
+}
+
+func removeInternalListenerFirewall(host string) {}
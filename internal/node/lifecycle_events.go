@@ -0,0 +1,56 @@
+package nexnode
+
+import (
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// The workload lifecycle state reported when a machine enters the given state, if any. Deploying
+// is reported separately, since the machine doesn't yet hold the deploy request when it enters
+// that state
+var machineLifecycleStates = map[machineState]string{
+	machineStateRunning:  controlapi.WorkloadStateRunning,
+	machineStateDraining: controlapi.WorkloadStateStopping,
+}
+
+// Publishes a workload lifecycle event for the workload deployed into the given machine
+func (m *MachineManager) publishWorkloadLifecycle(vm *runningFirecracker, state string, reason string) {
+	if vm.namespace == "" || vm.deployRequest == nil || vm.deployRequest.WorkloadName == nil {
+		return
+	}
+
+	evt := controlapi.WorkloadLifecycleEvent{
+		State:        state,
+		Namespace:    vm.namespace,
+		WorkloadName: *vm.deployRequest.WorkloadName,
+		MachineId:    vm.vmmID,
+		Reason:       reason,
+	}
+	if vm.deployRequest.ReadinessProbe != nil {
+		ready := vm.isReady()
+		evt.Ready = &ready
+	}
+
+	m.publishLifecycleEvent(evt)
+}
+
+// Publishes the given workload lifecycle event, filling in the schema, node and timestamp
+func (m *MachineManager) publishLifecycleEvent(evt controlapi.WorkloadLifecycleEvent) {
+	evt.Schema = controlapi.WorkloadLifecycleSchema
+	evt.NodeId = m.publicKey
+	evt.Timestamp = time.Now().UTC()
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(evt.Timestamp)
+	cloudevent.SetType(controlapi.WorkloadLifecycleEventType)
+	cloudevent.SetDataSchema(controlapi.WorkloadLifecycleSchema)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(evt)
+
+	_ = PublishCloudEvent(m.nc, evt.Namespace, cloudevent, m.log)
+}
@@ -0,0 +1,30 @@
+package nexnode
+
+import (
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+func agentLogLimits(limits *controlapi.LogLimits) *agentapi.LogLimits {
+	if limits == nil {
+		return nil
+	}
+
+	return &agentapi.LogLimits{
+		Level:          limits.Level,
+		LinesPerSecond: limits.LinesPerSecond,
+		Burst:          limits.Burst,
+	}
+}
+
+func controlLogLimits(limits *agentapi.LogLimits) *controlapi.LogLimits {
+	if limits == nil {
+		return nil
+	}
+
+	return &controlapi.LogLimits{
+		Level:          limits.Level,
+		LinesPerSecond: limits.LinesPerSecond,
+		Burst:          limits.Burst,
+	}
+}
@@ -0,0 +1,63 @@
+package nexnode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const defaultLogStreamMaxAgeSeconds = 7 * 24 * 3600
+
+func (c *LogStream) validate() []error {
+	errs := make([]error, 0)
+
+	if c.Stream == "" || strings.ContainsAny(c.Stream, ".*> ") {
+		errs = append(errs, fmt.Errorf("invalid log stream name: %q", c.Stream))
+	}
+
+	if c.MaxAgeSeconds < 0 || c.MaxBytes < 0 {
+		errs = append(errs, errors.New("log stream max age and max bytes must be >= 0"))
+	}
+
+	return errs
+}
+
+func (c *LogStream) maxAge() time.Duration {
+	if c.MaxAgeSeconds > 0 {
+		return time.Duration(c.MaxAgeSeconds) * time.Second
+	}
+	return defaultLogStreamMaxAgeSeconds * time.Second
+}
+
+// Creates the log stream if it doesn't exist. As with the audit log stream, an existing stream is
+// left as it is, as it's likely shared with the other nodes of the account
+func (m *MachineManager) ensureLogStream() error {
+	js, err := m.nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.StreamInfo(m.config.LogStream.Stream)
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+
+	maxBytes := m.config.LogStream.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = -1
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:        m.config.LogStream.Stream,
+		Description: "Logs of nex workloads",
+		Subjects:    []string{fmt.Sprintf("%s.logs.>", controlapi.APIPrefix)},
+		MaxAge:      m.config.LogStream.maxAge(),
+		MaxBytes:    maxBytes,
+		Storage:     nats.FileStorage,
+	})
+	return err
+}
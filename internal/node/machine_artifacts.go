@@ -0,0 +1,295 @@
+package nexnode
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const objectStoreDigestPrefix = "SHA-256="
+
+// Returns the directory in which fetched kernel and rootfs artifacts are cached. Defaults to a
+// directory beneath the default resource directory, or else beneath the run directory
+func (c *NodeConfiguration) artifactCacheDir() string {
+	if c.ArtifactCacheDir != "" {
+		return c.ArtifactCacheDir
+	}
+	if c.DefaultResourceDir != "" {
+		return filepath.Join(c.DefaultResourceDir, "artifacts")
+	}
+	return filepath.Join(c.runDirectory(), "nex-artifacts")
+}
+
+// Resolves each machine template into the node configuration its machines are created from,
+// fetching, verifying and caching the kernel and rootfs artifacts the templates declare, and then
+// garbage-collects cached artifacts which no template refers to any longer. Named templates which
+// don't declare a kernel or rootfs boot from those of the default template
+func (m *MachineManager) resolveMachineTemplates() error {
+	templates := m.config.allMachineTemplates()
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		if name != DefaultMachineTemplate {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// the default template is resolved first, as the others inherit from it
+	names = append([]string{DefaultMachineTemplate}, names...)
+
+	cacheDir := m.config.artifactCacheDir()
+	if !m.config.NoSandbox {
+		err := os.MkdirAll(cacheDir, 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create artifact cache directory: %s", err)
+		}
+	}
+
+	cached := make(map[string]struct{})
+	resolved := make(map[string]*NodeConfiguration)
+	for _, name := range names {
+		template := templates[name]
+
+		config := *m.config
+		config.MachineTemplate = template
+		if name != DefaultMachineTemplate {
+			defaults := resolved[DefaultMachineTemplate]
+			if template.VcpuCount == nil {
+				config.MachineTemplate.VcpuCount = defaults.MachineTemplate.VcpuCount
+			}
+			if template.MemSizeMib == nil {
+				config.MachineTemplate.MemSizeMib = defaults.MachineTemplate.MemSizeMib
+			}
+			config.KernelFilepath = defaults.KernelFilepath
+			config.RootFsFilepath = defaults.RootFsFilepath
+		}
+
+		// kernel and rootfs are only required when agents are sandboxed in firecracker VMs
+		if !m.config.NoSandbox {
+			if template.Kernel != nil {
+				path, err := m.fetchMachineArtifact(cacheDir, template.Kernel, cached)
+				if err != nil {
+					return fmt.Errorf("failed to fetch kernel of machine template %s: %s", name, err)
+				}
+				config.KernelFilepath = path
+			}
+
+			if template.RootFs != nil {
+				path, err := m.fetchMachineArtifact(cacheDir, template.RootFs, cached)
+				if err != nil {
+					return fmt.Errorf("failed to fetch rootfs of machine template %s: %s", name, err)
+				}
+				config.RootFsFilepath = path
+			}
+		}
+
+		resolved[name] = &config
+	}
+
+	m.templates = resolved
+
+	if !m.config.NoSandbox {
+		m.collectMachineArtifacts(cacheDir, cached)
+	}
+
+	return nil
+}
+
+// Returns the local path of the given artifact, fetching it into the cache unless an intact copy
+// is already cached. The name of the cached file is recorded in cached. Artifacts given as local
+// file paths are verified in place
+func (m *MachineManager) fetchMachineArtifact(cacheDir string, artifact *MachineArtifact, cached map[string]struct{}) (string, error) {
+	u, err := url.Parse(artifact.URL)
+	if err != nil {
+		return "", err
+	}
+
+	var digest string
+	if artifact.Digest != nil {
+		digest = strings.ToLower(*artifact.Digest)
+	}
+
+	if u.Scheme == "" || u.Scheme == "file" {
+		if digest != "" {
+			actual, err := fileDigest(u.Path)
+			if err != nil {
+				return "", err
+			}
+			if actual != digest {
+				return "", fmt.Errorf("artifact digest mismatch; expected %s, got %s", digest, actual)
+			}
+		}
+		return u.Path, nil
+	}
+
+	var store nats.ObjectStore
+	key := strings.Trim(u.Path, "/")
+	if u.Scheme == "nats" {
+		store, err = m.machineArtifactStore(u.Host, artifact.JsDomain)
+		if err != nil {
+			return "", err
+		}
+
+		info, err := store.GetInfo(key)
+		if err != nil {
+			return "", err
+		}
+
+		// the object store keeps its own digest of the object, so the cache can be checked before
+		// fetching it even when the template doesn't pin a digest
+		if digest == "" && strings.HasPrefix(info.Digest, objectStoreDigestPrefix) {
+			raw, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(info.Digest, objectStoreDigestPrefix))
+			if err == nil {
+				digest = hex.EncodeToString(raw)
+			}
+		}
+	}
+
+	if digest != "" {
+		path := filepath.Join(cacheDir, digest)
+		if actual, err := fileDigest(path); err == nil && actual == digest {
+			m.log.Debug("Using cached machine artifact", slog.String("url", artifact.URL), slog.String("path", path))
+			cached[digest] = struct{}{}
+			return path, nil
+		}
+	}
+
+	m.log.Info("Fetching machine artifact", slog.String("url", artifact.URL))
+
+	var source io.ReadCloser
+	if store != nil {
+		source, err = store.Get(key)
+	} else {
+		source, err = m.downloadMachineArtifact(artifact.URL)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	f, err := os.CreateTemp(cacheDir, ".fetch-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), source)
+	if err != nil {
+		return "", err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if digest != "" && actual != digest {
+		return "", fmt.Errorf("artifact digest mismatch; expected %s, got %s", digest, actual)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(cacheDir, actual)
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	m.log.Info("Cached machine artifact",
+		slog.String("url", artifact.URL),
+		slog.String("path", path),
+		slog.Int64("bytes", size),
+	)
+
+	cached[actual] = struct{}{}
+	return path, nil
+}
+
+// Binds to the object store holding machine artifacts
+func (m *MachineManager) machineArtifactStore(bucket string, jsDomain *string) (nats.ObjectStore, error) {
+	opts := []nats.JSOpt{}
+	if jsDomain != nil {
+		opts = append(opts, nats.APIPrefix(*jsDomain))
+	}
+
+	js, err := m.nc.JetStream(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return js.ObjectStore(bucket)
+}
+
+func (m *MachineManager) downloadMachineArtifact(rawURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// Removes cached artifacts which aren't in use, along with any left behind by interrupted fetches
+func (m *MachineManager) collectMachineArtifacts(cacheDir string, cached map[string]struct{}) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		m.log.Error("Failed to read artifact cache directory", slog.String("dir", cacheDir), slog.Any("err", err))
+		return
+	}
+
+	for _, e := range entries {
+		if _, ok := cached[e.Name()]; ok || e.IsDir() {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(cacheDir, e.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.log.Warn("Failed to remove unused machine artifact", slog.String("file", e.Name()), slog.Any("err", err))
+			continue
+		}
+
+		m.log.Info("Removed unused machine artifact", slog.String("file", e.Name()))
+	}
+}
+
+// Returns the hex-encoded SHA-256 digest of the file at the given path
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -6,18 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
-	"os/exec"
-	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	cloudevents "github.com/cloudevents/sdk-go"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -28,14 +31,29 @@ import (
 )
 
 const (
-	EventSubjectPrefix      = "$NEX.events"
-	LogSubjectPrefix        = "$NEX.logs"
+	EventSubjectPrefix      = controlapi.EventSubjectPrefix
+	LogSubjectPrefix        = controlapi.LogSubjectPrefix
 	WorkloadCacheBucketName = "NEXCACHE"
 
+	// the workload name under which agents log before a workload is deployed to their machine
+	agentLogWorkload = agentapi.AgentLogSource
+
 	defaultHandshakeTimeoutMillis = 5000
 
+	// deploy requests the agent can't yet receive are retried, backing off from this, within the
+	// request's ack timeout
+	deployAckRetryBackoff = 50 * time.Millisecond
+	maxDeployAckAttempts  = 5
+
 	nexTriggerSubject = "x-nex-trigger-subject"
 	nexRuntimeNs      = "x-nex-runtime-ns"
+	nexIdempotencyKey = "x-nex-idempotency-key"
+
+	// error headers understood by NATS micro clients
+	natsServiceError     = "Nats-Service-Error"
+	natsServiceErrorCode = "Nats-Service-Error-Code"
+
+	triggerTimeoutMillis = 10000
 )
 
 // The machine manager is responsible for the pool of warm firecracker VMs. This includes starting new
@@ -54,14 +72,86 @@ type MachineManager struct {
 	allVMs  map[string]*runningFirecracker
 	warmVMs chan *runningFirecracker
 
-	handshakes       map[string]string
+	// guards allVMs, stopMutex, vmsubz and idleFunctions; see machine_registry.go
+	vmsMutex sync.RWMutex
+
+	// pools of warm machines by size class, the first of which is warmVMs
+	pools []*machinePool
+
+	// the configurations machines are created from, keyed by machine template; the warm pool
+	// holds machines of the default template only
+	templates map[string]*NodeConfiguration
+
+	handshakes       *handshakeStore
+	artifacts        *artifactTransfers
 	handshakeTimeout time.Duration // TODO: make configurable...
 
+	// issues the nkeys agents use to connect to the internal NATS server
+	internalAuth *internalAuth
+
 	hostServices *HostServices
 
+	// stable IP leases and DNS names of service workloads; nil unless configured
+	serviceNetwork *serviceNetwork
+
+	// persistent volumes of service workloads; nil unless configured
+	volumes *volumeStore
+
+	// single-use deploy tokens which have been redeemed
+	deployTokens *deployTokenLedger
+
+	// responses to recent deploy requests, by idempotency key
+	idempotency *deployIdempotency
+
+	// records of running workloads, to be redeployed should the node restart; nil unless configured
+	workloadState *workloadStateStore
+
+	// replacements of workloads being deployed, or awaiting promotion, by workload updates
+	updates *workloadUpdates
+
+	// set while the node is in standby, keeping no warm machines; see standby.go
+	standby atomic.Bool
+
+	// when the pause of machine creation expires, in Unix nanoseconds, or zero when machine
+	// creation isn't paused; see pause.go
+	pausedUntil atomic.Int64
+
+	// held while checking a namespace's quota and deploying into it
+	quotaMutex sync.Mutex
+
+	// function workloads which scale to zero when idle, keyed by the ID of the machine
+	// each was originally deployed to; see machine_registry.go
+	idleFunctions map[string]*idleFunction
+
+	// timelines of running and recently stopped machines
+	timelines        map[string]*machineTimeline
+	timelinesMutex   sync.Mutex
+	stoppedTimelines []string
+
+	// utilization accumulated over the current reporting period; nil unless reports are enabled
+	utilization *utilizationUsage
+	// serializes attributing the CPU use of machines' cgroups to their workloads
+	cpuAccountingMutex sync.Mutex
+
+	// boot timings of the most recently started machines
+	bootTimings      []controlapi.MachineBootTimings
+	bootTimingsMutex sync.Mutex
+
+	// memory use observed of each workload since the node started
+	workloadMemory      map[workloadMemoryKey]*workloadMemoryUsage
+	workloadMemoryMutex sync.Mutex
+
 	stopMutex map[string]*sync.Mutex
 	vmsubz    map[string][]*nats.Subscription
 
+	// what was (and wasn't) stopped as the manager stopped, resources machines failed to clean
+	// up, and the reason the manager canceled the node, if it did; see shutdown_report.go
+	stopped       []controlapi.ShutdownWorkload
+	failedToStop  []controlapi.ShutdownWorkload
+	orphaned      []string
+	orphanedMutex sync.Mutex
+	failure       string
+
 	natsStoreDir string
 	publicKey    string
 }
@@ -74,6 +164,7 @@ func NewMachineManager(
 	nodeKeypair nkeys.KeyPair,
 	publicKey string,
 	nc, ncint *nats.Conn,
+	internalAuth *internalAuth,
 	config *NodeConfiguration,
 	log *slog.Logger,
 	telemetry *Telemetry,
@@ -83,12 +174,16 @@ func NewMachineManager(
 		return nil, fmt.Errorf("failed to create new machine manager; invalid node config; %v", config.Errors)
 	}
 
+	pools := newMachinePools(config)
+
 	m := &MachineManager{
 		config:           config,
 		cancel:           cancel,
 		ctx:              ctx,
-		handshakes:       make(map[string]string),
+		handshakes:       newHandshakeStore(),
+		artifacts:        newArtifactTransfers(),
 		handshakeTimeout: time.Duration(defaultHandshakeTimeoutMillis * time.Millisecond),
+		internalAuth:     internalAuth,
 		kp:               nodeKeypair,
 		log:              log,
 		natsStoreDir:     defaultNatsStoreDir,
@@ -98,13 +193,35 @@ func NewMachineManager(
 		t:                telemetry,
 
 		allVMs:  make(map[string]*runningFirecracker),
-		warmVMs: make(chan *runningFirecracker, config.MachinePoolSize),
+		warmVMs: pools[0].machines,
+		pools:   pools,
+
+		idleFunctions: make(map[string]*idleFunction),
+		deployTokens:  newDeployTokenLedger(nc),
+		idempotency:   newDeployIdempotency(),
+		updates:       newWorkloadUpdates(),
+		timelines:     make(map[string]*machineTimeline),
+
+		workloadMemory: make(map[workloadMemoryKey]*workloadMemoryUsage),
 
 		stopMutex: make(map[string]*sync.Mutex),
 		vmsubz:    make(map[string][]*nats.Subscription),
 	}
 
-	_, err := m.ncInternal.Subscribe("agentint.handshake", m.handleHandshake)
+	if config.UtilizationReports != nil {
+		m.utilization = newUtilizationUsage()
+	}
+
+	if config.Standby != nil {
+		m.standby.Store(true)
+	}
+
+	err := m.resolveMachineTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.ncInternal.Subscribe("agentint.handshake", m.handleHandshake)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +236,36 @@ func NewMachineManager(
 		return nil, err
 	}
 
+	_, err = m.ncInternal.Subscribe("agentint.*.health", m.handleAgentHealth)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.ncInternal.Subscribe("agentint.*.memory", m.handleAgentMemory)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.ncInternal.Subscribe(agentapi.ArtifactSubject("*"), m.handleArtifactRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServiceNetworking != nil {
+		m.serviceNetwork = newServiceNetwork(config.ServiceNetworking, config.runDirectory(), m.log)
+	}
+
+	if config.Volumes != nil {
+		m.volumes = newVolumeStore(config.Volumes, m.log)
+	}
+
+	if config.WorkloadRecovery != nil {
+		m.workloadState, err = newWorkloadStateStore(config.WorkloadRecovery, m.log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	m.hostServices = NewHostServices(m, m.nc, m.ncInternal, m.log)
 	err = m.hostServices.init()
 	if err != nil {
@@ -139,7 +286,61 @@ func (m *MachineManager) Start() {
 		}
 	}()
 
-	if !m.config.PreserveNetwork {
+	if m.config.RunDirectoryCleanup == RunDirectoryCleanupAlways {
+		m.cleanRunDirectory(true)
+	}
+
+	if m.config.AssetReaper != nil {
+		go m.reapStaleAssets()
+	}
+
+	if m.config.ZombieReaper != nil && !m.config.NoSandbox {
+		go m.reapZombies()
+	}
+
+	if m.utilization != nil {
+		go m.reportUtilization()
+	}
+
+	if m.config.Cgroups != nil {
+		go m.accountCPUUsage()
+	}
+
+	if m.config.LogStream != nil {
+		err := m.ensureLogStream()
+		if err != nil {
+			m.log.Warn("Failed to create log stream", slog.Any("err", err))
+		}
+	}
+
+	if m.serviceNetwork != nil && m.config.ServiceNetworking.DNSListen != "" {
+		go func() {
+			err := m.serviceNetwork.serveDNS(m.ctx)
+			if err != nil {
+				m.log.Error("Service dns responder failed", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if m.config.Webhooks != nil {
+		go func() {
+			err := m.serveWebhooks(m.ctx)
+			if err != nil {
+				m.log.Error("Webhook receiver failed", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if m.config.EventStream != nil {
+		go func() {
+			err := m.serveEventStream(m.ctx)
+			if err != nil {
+				m.log.Error("Event stream failed", slog.Any("err", err))
+			}
+		}()
+	}
+
+	if !m.config.PreserveNetwork && !m.config.NoSandbox {
 		err := m.resetCNI()
 		if err != nil {
 			m.log.Warn("Failed to reset network.", slog.Any("err", err))
@@ -151,36 +352,246 @@ func (m *MachineManager) Start() {
 		case <-m.ctx.Done():
 			return
 		default:
-			if len(m.warmVMs) == m.config.MachinePoolSize {
+			pool := m.poolWithDeficit()
+			if pool == nil {
 				time.Sleep(runloopSleepInterval)
 				continue
 			}
 
-			vm, err := createAndStartVM(context.TODO(), m.config, m.log)
-			if err != nil {
-				m.log.Warn("Failed to create VMM for warming pool.", slog.Any("err", err))
+			vm, err := m.startMachine(DefaultMachineTemplate, &pool.size, nil, nil)
+			if err == nil && m.standby.Load() {
+				// the node entered standby while the machine was starting
+				_ = m.StopMachine(vm.vmmID, false)
 				continue
 			}
-
-			err = m.setMetadata(vm)
 			if err != nil {
-				m.log.Warn("Failed to set metadata on VM for warming pool.", slog.Any("err", err))
+				m.log.Warn("Failed to start machine for warming pool.", slog.Any("err", err))
+				if m.config.NoSandbox {
+					time.Sleep(runloopTickInterval)
+				}
 				continue
 			}
 
 			go m.awaitHandshake(vm.vmmID)
 
-			m.allVMs[vm.vmmID] = vm
-			m.stopMutex[vm.vmmID] = &sync.Mutex{}
-			m.t.vmCounter.Add(m.ctx, 1)
+			m.log.Info("Adding new VM to warm pool", slog.Any("ip", vm.ip), slog.String("vmid", vm.vmmID), slog.String("size_class", pool.sizeClass))
+			pool.machines <- vm // If the pool is full, this line will block until a slot is available.
+		}
+	}
+}
+
+// Creates and starts a machine from the given machine template and registers it with the manager.
+// The machine is given the template's size unless another size is given, and the given IP address
+// and volume, if any
+func (m *MachineManager) startMachine(template string, size *machineSize, ip net.IP, volume *workloadVolume) (*runningFirecracker, error) {
+	if until := m.creationPausedUntil(); until != nil {
+		return nil, fmt.Errorf("machine creation is paused until %s", until.Format(time.RFC3339))
+	}
+
+	config, ok := m.templates[template]
+	if !ok {
+		return nil, fmt.Errorf("unknown machine template: %s", template)
+	}
+
+	if size != nil {
+		sized := *config
+		sized.MachineTemplate.VcpuCount = &size.vcpuCount
+		sized.MachineTemplate.MemSizeMib = &size.memSizeMib
+		config = &sized
+	}
+
+	var vm *runningFirecracker
+	var err error
+
+	if m.config.NoSandbox {
+		// metadata is handed to the agent process via its environment when spawned
+		vm, err = createAndStartProcess(context.TODO(), config, m.internalAuth, m.log)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var volumePath string
+		if volume != nil {
+			volumePath = volume.path
+		}
+
+		vm, err = createAndStartVM(context.TODO(), config, ip, volumePath, m.log)
+		if err != nil {
+			return nil, err
+		}
+		vm.volume = volume
+
+		err = m.setMetadata(vm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vm.template = template
+	m.trackMachine(vm)
+	m.recordMachineEvent(vm, controlapi.TimelineEventCreated, "")
+	m.t.vmCounter.Add(m.ctx, 1)
+
+	return vm, nil
+}
 
-			m.log.Info("Adding new VM to warm pool", slog.Any("ip", vm.ip), slog.String("vmid", vm.vmmID))
-			m.warmVMs <- vm // If the pool is full, this line will block until a slot is available.
+// Returns a ready machine of the given machine template (and size, if given) to deploy into.
+// Machines of the default template are taken from the warm pool; when a size is given, from the
+// pool of the smallest size class that fits it. Machines of other templates, and machines of sizes
+// for which no pool has a warm machine, are started on demand, as are machines given an IP address
+// or volume
+func (m *MachineManager) acquireMachine(template string, size *machineSize, ip net.IP, volume *workloadVolume) (*runningFirecracker, error) {
+	template = machineTemplateName(template)
+
+	// pooled machines already have an address, and drives can't be attached to running machines
+	if ip == nil && volume == nil && template == DefaultMachineTemplate && size != nil {
+		if vm := m.takeSizedMachine(*size); vm != nil {
+			return vm, nil
 		}
+	} else if ip == nil && volume == nil && template == DefaultMachineTemplate {
+		vm, ok, err := m.takeWarmMachine()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("machine manager is stopping")
+		}
+		if !m.handshakes.completed(vm.vmmID) {
+			_ = m.StopMachine(vm.vmmID, false)
+			return nil, fmt.Errorf("machine from pool did not initialize properly: %w", &MachineError{MachineId: vm.vmmID, Err: ErrHandshakeTimeout})
+		}
+		return vm, nil
+	}
+
+	vm, err := m.startMachine(template, size, ip, volume)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start machine from template %s: %s", template, err)
+	}
+
+	m.awaitHandshake(vm.vmmID)
+	if !m.handshakes.completed(vm.vmmID) {
+		_ = m.StopMachine(vm.vmmID, false)
+		return nil, fmt.Errorf("machine from template %s did not initialize properly: %w", template, &MachineError{MachineId: vm.vmmID, Err: ErrHandshakeTimeout})
 	}
+
+	return vm, nil
 }
 
 func (m *MachineManager) DeployWorkload(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	err := m.submitDeployment(vm, request)
+	if err != nil {
+		return err
+	}
+
+	// a standby replacement of a workload takes over its triggers once promoted
+	if !request.Standby {
+		err = m.subscribeTriggers(vm, request)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.workloadDeployed(vm)
+	if err != nil {
+		return err
+	}
+
+	// a standby replacement is recorded once it's promoted
+	if !request.Standby {
+		m.recordRecoverableWorkload(vm)
+	}
+
+	if request.SupportsCronTriggers() && !request.Standby {
+		err = m.scheduleCronTriggers(vm, request)
+		if err != nil {
+			m.log.Error("Failed to schedule cron triggers for deployed workload",
+				slog.String("vmid", vm.vmmID),
+				slog.Any("err", err),
+			)
+			_ = m.StopMachine(vm.vmmID, true)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subscribes to the trigger subjects of the function deployed to the given machine, stopping the
+// machine if any subscription fails
+func (m *MachineManager) subscribeTriggers(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	if request.SupportsTriggerSubjects() && request.IdleTimeout() > 0 {
+		err := m.subscribeIdleFunctionTriggers(vm, request)
+		if err != nil {
+			m.log.Error("Failed to create trigger subject subscriptions for deployed idle function",
+				slog.String("vmid", vm.vmmID),
+				slog.Any("err", err),
+			)
+			_ = m.StopMachine(vm.vmmID, true)
+			return err
+		}
+	} else if request.SupportsTriggerSubjects() && request.AtLeastOnceDelivery() {
+		err := m.subscribeAtLeastOnceTriggers(vm, request)
+		if err != nil {
+			m.log.Error("Failed to create at-least-once trigger subscriptions for deployed workload",
+				slog.String("vmid", vm.vmmID),
+				slog.Any("err", err),
+			)
+			_ = m.StopMachine(vm.vmmID, true)
+			return err
+		}
+	} else if request.SupportsTriggerSubjects() {
+		// the replacement of a canary update already has a limiter when it's promoted
+		if request.TriggerConcurrency != nil && vm.triggerLimiter == nil {
+			vm.triggerLimiter = m.newTriggerLimiter(vm.namespace, request)
+		}
+
+		// registered before the function is triggered, so its executions are all counted
+		if request.MicroService != nil {
+			err := m.registerMicroService(vm, request)
+			if err != nil {
+				m.log.Error("Failed to register deployed workload as micro service",
+					slog.String("vmid", vm.vmmID),
+					slog.Any("err", err),
+				)
+				_ = m.StopMachine(vm.vmmID, true)
+				return err
+			}
+		}
+
+		for _, tsub := range request.TriggerSubjects {
+			handler := nats.MsgHandler(m.generateTriggerHandler(vm, tsub, request))
+			if vm.triggerLimiter != nil {
+				handler = vm.triggerLimiter.wrap(handler)
+			}
+			handler = m.routeCanaryTriggers(vm, handler)
+
+			sub, err := m.subscribeTrigger(request, tsub, handler)
+			if err != nil {
+				m.log.Error("Failed to create trigger subject subscription for deployed workload",
+					slog.String("vmid", vm.vmmID),
+					slog.String("trigger_subject", tsub),
+					slog.String("workload_type", *request.WorkloadType),
+					slog.Any("err", err),
+				)
+				_ = m.StopMachine(vm.vmmID, true)
+				return err
+			}
+
+			m.log.Info("Created trigger subject subscription for deployed workload",
+				slog.String("vmid", vm.vmmID),
+				slog.String("trigger_subject", tsub),
+				slog.String("workload_type", *request.WorkloadType),
+			)
+
+			m.addMachineSubscription(vm.vmmID, sub)
+		}
+	}
+
+	return nil
+}
+
+// Submits the given deploy request to the agent running in the given (ready) machine
+func (m *MachineManager) submitDeployment(vm *runningFirecracker, request *agentapi.DeployRequest) error {
 	bytes, err := json.Marshal(request)
 	if err != nil {
 		return err
@@ -191,63 +602,177 @@ func (m *MachineManager) DeployWorkload(vm *runningFirecracker, request *agentap
 		slog.String("vmid", vm.vmmID),
 		slog.String("status", status.String()))
 
-	vm.deployRequest = request
 	vm.namespace = *request.Namespace
+	err = m.transitionMachine(vm, machineStateDeploying)
+	if err != nil {
+		// the machine was never ours to deploy into; leave it as we found it
+		vm.namespace = ""
+		return fmt.Errorf("failed to deploy workload: %s", err)
+	}
+
+	vm.deployRequest = request
 	vm.workloadStarted = time.Now().UTC()
+	m.publishWorkloadLifecycle(vm, controlapi.WorkloadStateDeploying, "")
 
-	subject := fmt.Sprintf("agentint.%s.deploy", vm.vmmID)
-	resp, err := m.ncInternal.Request(subject, bytes, 1*time.Second)
+	// the machine's egress is restricted before the workload is started, so it never runs without
+	if request.EgressPolicy != nil {
+		err = m.applyEgressPolicy(vm, request.EgressPolicy)
+		if err != nil {
+			m.abandonDeployment(vm, fmt.Sprintf("Failed to apply egress policy: %s", err))
+			return fmt.Errorf("failed to apply egress policy: %s", err)
+		}
+	}
+
+	// subscribed before the request is submitted, so that the outcome of a workload the agent is
+	// still initializing can't be missed
+	ready, err := m.ncInternal.SubscribeSync(fmt.Sprintf("agentint.%s.events.%s", vm.vmmID, agentapi.WorkloadReadyEventType))
+	if err != nil {
+		m.abandonDeployment(vm, fmt.Sprintf("Failed to subscribe to workload readiness: %s", err))
+		return fmt.Errorf("failed to subscribe to workload readiness: %s", err)
+	}
+	defer func() {
+		_ = ready.Unsubscribe()
+	}()
+
+	resp, err := m.requestDeployAck(vm, bytes, request.AckTimeout())
 	if err != nil {
-		if errors.Is(err, os.ErrDeadlineExceeded) {
-			return errors.New("timed out waiting for acknowledgement of workload deployment")
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			err = fmt.Errorf("timed out after %s waiting for acknowledgement of workload deployment", request.AckTimeout())
 		} else {
-			return fmt.Errorf("failed to submit request for workload deployment: %s", err)
+			err = fmt.Errorf("failed to submit request for workload deployment: %s", err)
 		}
+		m.abandonDeployment(vm, fmt.Sprintf("Deployment failed: %s", err))
+		return err
 	}
 
 	var deployResponse agentapi.DeployResponse
-	err = json.Unmarshal(resp.Data, &deployResponse)
+	err = schema.Unmarshal(resp.Data, &deployResponse)
 	if err != nil {
+		m.abandonDeployment(vm, fmt.Sprintf("Failed to deserialize deploy response: %s", err))
 		return err
 	}
 
-	if deployResponse.Accepted {
-		if request.SupportsTriggerSubjects() {
-			for _, tsub := range request.TriggerSubjects {
-				sub, err := m.nc.Subscribe(tsub, m.generateTriggerHandler(vm, tsub, request))
-				if err != nil {
-					m.log.Error("Failed to create trigger subject subscription for deployed workload",
-						slog.String("vmid", vm.vmmID),
-						slog.String("trigger_subject", tsub),
-						slog.String("workload_type", *request.WorkloadType),
-						slog.Any("err", err),
-					)
-					_ = m.StopMachine(vm.vmmID, true)
-					return err
-				}
+	if !deployResponse.Accepted {
+		m.abandonDeployment(vm, fmt.Sprintf("Workload rejected by agent: %s", *deployResponse.Message))
+		return fmt.Errorf("workload rejected by agent: %s", *deployResponse.Message)
+	}
 
-				m.log.Info("Created trigger subject subscription for deployed workload",
-					slog.String("vmid", vm.vmmID),
-					slog.String("trigger_subject", tsub),
-					slog.String("workload_type", *request.WorkloadType),
-				)
+	if deployResponse.Initializing {
+		err = m.awaitWorkloadReady(vm, ready, request.ReadyTimeout())
+		if err != nil {
+			return err
+		}
+	}
 
-				m.vmsubz[vm.vmmID] = append(m.vmsubz[vm.vmmID], sub)
-			}
+	// the agent only reports a workload with a readiness probe deployed once the probe has passed,
+	// so its triggers are never subscribed to before then
+	atomic.StoreUint32(&vm.ready, 1)
+	return nil
+}
+
+// Stops a machine whose deployment failed, so that it isn't left deploying forever, holding on to
+// its volume, address and the rest of its resources
+func (m *MachineManager) abandonDeployment(vm *runningFirecracker, reason string) {
+	m.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, reason)
+	err := m.StopMachine(vm.vmmID, false)
+	if err != nil {
+		m.log.Warn("Failed to stop machine after failed deployment", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+	}
+}
+
+// Submits the given deploy request to the agent in the given machine, retrying errors which may
+// clear up by themselves (e.g., the agent not having subscribed to its deploy subject yet) until
+// the agent acknowledges the request or the ack timeout passes
+func (m *MachineManager) requestDeployAck(vm *runningFirecracker, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	subject := fmt.Sprintf("agentint.%s.deploy", vm.vmmID)
+	deadline := time.Now().Add(timeout)
+	backoff := deployAckRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := m.ncInternal.Request(subject, data, time.Until(deadline))
+		if err == nil || !transientRequestError(err) || attempt == maxDeployAckAttempts {
+			return resp, err
 		}
-	} else {
-		_ = m.StopMachine(vm.vmmID, false)
-		return fmt.Errorf("workload rejected by agent: %s", *deployResponse.Message)
+
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return nil, err
+		}
+
+		m.log.Debug("Retrying workload deployment request",
+			slog.String("vmid", vm.vmmID),
+			slog.Int("attempt", attempt),
+			slog.Any("err", err),
+		)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// Waits for the agent in the given machine to report whether the workload it acknowledged as
+// initializing is ready, stopping the machine if it isn't (or doesn't say in time)
+func (m *MachineManager) awaitWorkloadReady(vm *runningFirecracker, ready *nats.Subscription, timeout time.Duration) error {
+	m.log.Info("Workload accepted by agent, waiting for it to initialize",
+		slog.String("vmid", vm.vmmID),
+		slog.Duration("ready_timeout", timeout),
+	)
+
+	msg, err := ready.NextMsg(timeout)
+	if err != nil {
+		m.abandonDeployment(vm, fmt.Sprintf("Workload did not initialize within %s", timeout))
+		return fmt.Errorf("timed out after %s waiting for workload to initialize", timeout)
+	}
+
+	var evt cloudevents.Event
+	err = json.Unmarshal(msg.Data, &evt)
+	if err != nil {
+		m.abandonDeployment(vm, fmt.Sprintf("Failed to deserialize workload readiness: %s", err))
+		return fmt.Errorf("failed to deserialize workload readiness: %s", err)
+	}
+
+	var readiness agentapi.WorkloadReadyEvent
+	err = evt.DataAs(&readiness)
+	if err != nil {
+		m.abandonDeployment(vm, fmt.Sprintf("Failed to read workload readiness: %s", err))
+		return fmt.Errorf("failed to read workload readiness: %s", err)
+	}
+
+	if !readiness.Ready {
+		m.abandonDeployment(vm, fmt.Sprintf("Workload failed to initialize: %s", readiness.Message))
+		return fmt.Errorf("workload failed to initialize: %s", readiness.Message)
+	}
+
+	return nil
+}
+
+// Whether a failed request to an agent may succeed if retried, e.g., because the agent had yet to
+// subscribe to the request's subject
+func transientRequestError(err error) bool {
+	return errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrDisconnected)
+}
+
+// Marks the workload submitted to the given machine as running and records it in telemetry
+func (m *MachineManager) workloadDeployed(vm *runningFirecracker) error {
+	err := m.transitionMachine(vm, machineStateRunning)
+	if err != nil {
+		// the machine was stopped while the workload was being deployed
+		return fmt.Errorf("failed to deploy workload: %s", err)
 	}
 
 	m.t.workloadCounter.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_type", *vm.deployRequest.WorkloadType)))
 	m.t.workloadCounter.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)), metric.WithAttributes(attribute.String("workload_type", *vm.deployRequest.WorkloadType)))
-	m.t.deployedByteCounter.Add(m.ctx, request.TotalBytes)
-	m.t.deployedByteCounter.Add(m.ctx, request.TotalBytes, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-	m.t.allocatedVCPUCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.VcpuCount)
-	m.t.allocatedVCPUCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.VcpuCount, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-	m.t.allocatedMemoryCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.MemSizeMib)
-	m.t.allocatedMemoryCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.MemSizeMib, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.recordUtilization(vm.namespace, func(usage *controlapi.NamespaceUtilization) {
+		usage.Deploys++
+	})
+
+	m.t.deployedByteCounter.Add(m.ctx, vm.deployRequest.TotalBytes)
+	m.t.deployedByteCounter.Add(m.ctx, vm.deployRequest.TotalBytes, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.allocatedVCPUCounter.Add(m.ctx, vm.vcpuCount)
+	m.t.allocatedVCPUCounter.Add(m.ctx, vm.vcpuCount, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.allocatedMemoryCounter.Add(m.ctx, vm.memSizeMib)
+	m.t.allocatedMemoryCounter.Add(m.ctx, vm.memSizeMib, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
 
 	return nil
 }
@@ -258,36 +783,151 @@ func (m *MachineManager) DeployWorkload(vm *runningFirecracker, request *agentap
 func (m *MachineManager) Stop() error {
 	if atomic.AddUint32(&m.closing, 1) == 1 {
 		m.log.Info("Virtual machine manager stopping")
-		close(m.warmVMs)
+		for _, pool := range m.pools {
+			close(pool.machines)
+		}
 
-		for vmID := range m.allVMs {
-			err := m.StopMachine(vmID, true)
-			if err != nil {
-				m.log.Warn("Failed to stop VM", slog.String("vmid", vmID), slog.String("error", err.Error()))
-			}
+		for _, fn := range m.idleFunctionsSnapshot() {
+			m.stopIdleFunction(fn)
+			m.stopped = append(m.stopped, controlapi.ShutdownWorkload{
+				MachineId:    fn.id,
+				Namespace:    fn.namespace,
+				WorkloadName: fn.request.DecodedClaims.Subject,
+			})
 		}
 
-		m.cleanSockets()
+		m.stopAllMachines()
+
+		if m.config.RunDirectoryCleanup != RunDirectoryCleanupNever {
+			m.cleanRunDirectory(false)
+		}
 	}
 
 	return nil
 }
 
+// Stops every machine as the manager stops, several at once, recording which were stopped and
+// which weren't. Machines still stopping when the shutdown timeout expires are recorded as having
+// failed to stop, and are left to finish (or not) on their own
+func (m *MachineManager) stopAllMachines() {
+	vms := m.runningMachines()
+	if len(vms) == 0 {
+		return
+	}
+
+	concurrency := m.config.shutdownConcurrency()
+	timeout := m.config.shutdownTimeout()
+	m.log.Info("Stopping machines",
+		slog.Int("machines", len(vms)),
+		slog.Int("concurrency", concurrency),
+		slog.Duration("timeout", timeout),
+	)
+
+	started := time.Now()
+	pending := make(chan *runningFirecracker, len(vms))
+	for _, vm := range vms {
+		pending <- vm
+	}
+	close(pending)
+
+	// buffered so that workers still stopping a machine after the timeout never block
+	results := make(chan controlapi.ShutdownWorkload, len(vms))
+	for i := 0; i < concurrency && i < len(vms); i++ {
+		go func() {
+			for vm := range pending {
+				results <- m.stopMachineForShutdown(vm)
+			}
+		}()
+	}
+
+	reported := make(map[string]struct{}, len(vms))
+	deadline := time.After(timeout)
+	for len(reported) < len(vms) {
+		select {
+		case stopped := <-results:
+			reported[stopped.MachineId] = struct{}{}
+			if stopped.Error != "" {
+				m.failedToStop = append(m.failedToStop, stopped)
+			} else {
+				m.stopped = append(m.stopped, stopped)
+			}
+		case <-deadline:
+			for _, vm := range vms {
+				if _, ok := reported[vm.vmmID]; ok {
+					continue
+				}
+				stopped := shutdownWorkload(vm)
+				stopped.Error = fmt.Sprintf("machine did not stop within the shutdown timeout of %s", timeout)
+				stopped.DurationMillis = time.Since(started).Milliseconds()
+				m.log.Warn("Machine did not stop within the shutdown timeout", slog.String("vmid", vm.vmmID))
+				m.failedToStop = append(m.failedToStop, stopped)
+			}
+			return
+		}
+	}
+
+	m.log.Info("Stopped machines",
+		slog.Int("stopped", len(m.stopped)),
+		slog.Int("failed_to_stop", len(m.failedToStop)),
+		slog.Duration("elapsed", time.Since(started)),
+	)
+}
+
+// Stops the given machine as the manager stops, returning the result for the shutdown report
+func (m *MachineManager) stopMachineForShutdown(vm *runningFirecracker) controlapi.ShutdownWorkload {
+	stopped := shutdownWorkload(vm)
+	started := time.Now()
+
+	m.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, "Node stopping")
+	err := m.StopMachine(vm.vmmID, true)
+	stopped.DurationMillis = time.Since(started).Milliseconds()
+	if err != nil {
+		m.log.Warn("Failed to stop VM", slog.String("vmid", vm.vmmID), slog.String("error", err.Error()))
+		stopped.Error = err.Error()
+	}
+
+	return stopped
+}
+
+func shutdownWorkload(vm *runningFirecracker) controlapi.ShutdownWorkload {
+	stopped := controlapi.ShutdownWorkload{MachineId: vm.vmmID}
+	if vm.deployRequest != nil {
+		stopped.Namespace = vm.namespace
+		stopped.WorkloadName = *vm.deployRequest.WorkloadName
+	}
+	return stopped
+}
+
 // Stops a single machine, optionally attempting to gracefully undeploy the running workload.
 // Will return an error if called with a non-existent workload/vm ID
 func (m *MachineManager) StopMachine(vmID string, undeploy bool) error {
-	vm, exists := m.allVMs[vmID]
-	if !exists {
-		return fmt.Errorf("failed to stop machine %s", vmID)
+	vm, exists := m.lookupMachine(vmID)
+	mutex := m.machineStopMutex(vmID)
+	if !exists || mutex == nil {
+		return &MachineError{MachineId: vmID, Err: ErrMachineNotFound}
 	}
 
-	mutex := m.stopMutex[vmID]
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	err := m.transitionMachine(vm, machineStateDraining)
+	if err != nil {
+		return fmt.Errorf("failed to stop machine: %s", err)
+	}
+
 	m.log.Debug("Attempting to stop virtual machine", slog.String("vmid", vmID), slog.Bool("undeploy", undeploy))
 
-	for _, sub := range m.vmsubz[vmID] {
+	if vm.function != nil {
+		m.detachIdleFunction(vm)
+	} else {
+		m.forgetRecoverableWorkload(vmID)
+	}
+
+	m.stopCronTriggers(vm)
+	m.updates.forget(vmID)
+	m.artifacts.cancel(vmID)
+
+	for _, sub := range m.takeMachineSubscriptions(vmID) {
 		err := sub.Drain()
 		if err != nil {
 			m.log.Warn(fmt.Sprintf("failed to drain subscription to subject %s associated with vm %s: %s", sub.Subject, vmID, err.Error()))
@@ -296,20 +936,48 @@ func (m *MachineManager) StopMachine(vmID string, undeploy bool) error {
 		m.log.Debug(fmt.Sprintf("drained subscription to subject %s associated with vm %s", sub.Subject, vmID))
 	}
 
+	if vm.triggerLimiter != nil {
+		vm.triggerLimiter.stop()
+	}
+
+	if vm.lastMemoryReport != nil && vm.lastMemoryReport.Triggers != nil {
+		m.recordTriggerExecutions(vm, vm.lastMemoryReport.Triggers, nil)
+	}
+
+	if m.hostServices != nil {
+		m.hostServices.removeSubscriptions(vmID)
+	}
+
 	if vm.deployRequest != nil && undeploy {
 		// we do a request here to allow graceful shutdown of the workload being undeployed
+		timeout := 500 * time.Millisecond // FIXME-- allow this timeout to be configurable... 500ms is likely not enough
+		if vm.deployRequest.PostStopHook != nil {
+			// give the agent a chance to run the workload's post-stop hook before the machine is torn down
+			timeout += vm.deployRequest.PostStopHook.Timeout()
+		}
+
 		subject := fmt.Sprintf("agentint.%s.undeploy", vm.vmmID)
-		_, err := m.ncInternal.Request(subject, []byte{}, 500*time.Millisecond) // FIXME-- allow this timeout to be configurable... 500ms is likely not enough
+		_, err := m.ncInternal.Request(subject, []byte{}, timeout)
 		if err != nil {
 			m.log.Warn("request to undeploy workload via internal NATS connection failed", slog.String("vmid", vm.vmmID), slog.String("error", err.Error()))
 			// return err
 		}
 	}
 
-	vm.shutdown()
-	delete(m.allVMs, vmID)
-	delete(m.stopMutex, vmID)
-	delete(m.vmsubz, vmID)
+	if vm.cgroup != "" {
+		m.accountMachineCPU(vm)
+	}
+	m.recordOrphans(vm.shutdown())
+	m.revokeWorkloadCredentials(vm)
+	m.internalAuth.revoke(vmID)
+	if vm.dnsName != "" {
+		m.serviceNetwork.deregister(vm.dnsName, vm.ip)
+	}
+	if vm.volume != nil {
+		m.volumes.release(vm.volume)
+	}
+	m.forgetMachine(vmID)
+	m.handshakes.forget(vmID)
 
 	_ = m.publishMachineStopped(vm)
 
@@ -321,23 +989,89 @@ func (m *MachineManager) StopMachine(vmID string, undeploy bool) error {
 	}
 
 	m.t.vmCounter.Add(m.ctx, -1)
-	m.t.allocatedVCPUCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.VcpuCount*-1)
-	m.t.allocatedVCPUCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.VcpuCount*-1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-	m.t.allocatedMemoryCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.MemSizeMib*-1)
-	m.t.allocatedMemoryCounter.Add(m.ctx, *vm.machine.Cfg.MachineCfg.MemSizeMib*-1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.allocatedVCPUCounter.Add(m.ctx, vm.vcpuCount*-1)
+	m.t.allocatedVCPUCounter.Add(m.ctx, vm.vcpuCount*-1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.allocatedMemoryCounter.Add(m.ctx, vm.memSizeMib*-1)
+	m.t.allocatedMemoryCounter.Add(m.ctx, vm.memSizeMib*-1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
 
 	return nil
 }
 
+// Stops the machine running a function workload which has repeatedly failed to execute and emits a
+// workload failed event. The workload is redeployed afterward if the node is configured to do so
+func (m *MachineManager) evictWorkload(vm *runningFirecracker, reason string) {
+	if !atomic.CompareAndSwapUint32(&vm.evicted, 0, 1) {
+		return
+	}
+
+	m.log.Warn("Evicting workload due to repeated trigger failures",
+		slog.String("vmid", vm.vmmID),
+		slog.String("workload", *vm.deployRequest.WorkloadName),
+		slog.String("reason", reason),
+	)
+
+	m.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, fmt.Sprintf("Evicted: %s", reason))
+	err := m.StopMachine(vm.vmmID, true)
+	if err != nil {
+		m.log.Warn("Failed to stop evicted workload", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+	}
+
+	m.t.functionEvictions.Add(m.ctx, 1)
+	m.t.functionEvictions.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.functionEvictions.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
+
+	err = m.publishWorkloadFailed(vm, reason)
+	if err != nil {
+		m.log.Warn("Failed to publish workload failed event", slog.Any("err", err))
+	}
+
+	if m.config.RestartEvictedWorkloads && !m.stopping() {
+		err = m.redeployWorkload(vm)
+		if err != nil {
+			m.log.Error("Failed to redeploy evicted workload", slog.Any("err", err))
+		}
+	}
+}
+
 // Looks up a virtual machine by workload/vm ID. Returns nil if machine doesn't exist
 func (m *MachineManager) LookupMachine(vmId string) *runningFirecracker {
-	vm, exists := m.allVMs[vmId]
+	vm, exists := m.lookupMachine(vmId)
 	if !exists {
 		return nil
 	}
 	return vm
 }
 
+// Looks up a virtual machine by workload/vm ID within the given namespace, failing with
+// ErrMachineNotFound or ErrNamespaceMismatch
+func (m *MachineManager) lookupNamespacedMachine(vmId string, namespace string) (*runningFirecracker, error) {
+	vm := m.LookupMachine(vmId)
+	if vm == nil {
+		return nil, &MachineError{MachineId: vmId, Err: ErrMachineNotFound}
+	}
+	if vm.namespace != namespace {
+		return nil, &MachineError{MachineId: vmId, Err: ErrNamespaceMismatch}
+	}
+	return vm, nil
+}
+
+// Returns the machines running deployed workloads in the given namespace whose labels
+// satisfy the provided predicate
+func (m *MachineManager) matchingMachines(namespace string, matches func(labels map[string]string) bool) []*runningFirecracker {
+	vms := make([]*runningFirecracker, 0)
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest == nil || vm.namespace != namespace {
+			continue
+		}
+
+		if matches(vm.deployRequest.Labels) {
+			vms = append(vms, vm)
+		}
+	}
+
+	return vms
+}
+
 func (m *MachineManager) awaitHandshake(vmid string) {
 	timeoutAt := time.Now().UTC().Add(m.handshakeTimeout)
 
@@ -345,14 +1079,14 @@ func (m *MachineManager) awaitHandshake(vmid string) {
 	for !handshakeOk && !m.stopping() {
 		if time.Now().UTC().After(timeoutAt) {
 			m.log.Error("Did not receive NATS handshake from agent within timeout.", slog.String("vmid", vmid))
-			if len(m.handshakes) == 0 {
+			if !m.handshakes.anyCompleted() {
 				m.log.Error("First handshake failed, shutting down to avoid inconsistent behavior")
-				m.cancel()
+				m.fail("first agent handshake failed")
 			}
 			return
 		}
 
-		_, handshakeOk = m.handshakes[vmid]
+		handshakeOk = m.handshakes.completed(vmid)
 		time.Sleep(time.Millisecond * agentapi.DefaultRunloopSleepTimeoutMillis)
 	}
 }
@@ -361,15 +1095,15 @@ func (m *MachineManager) awaitHandshake(vmid string) {
 // fire-and-forget publishes from inside the firecracker VM could potentially be lost
 func (m *MachineManager) handleHandshake(msg *nats.Msg) {
 	var req agentapi.HandshakeRequest
-	err := json.Unmarshal(msg.Data, &req)
+	err := schema.Unmarshal(msg.Data, &req)
 	if err != nil {
-		m.log.Error("Failed to handle agent handshake", slog.String("vmid", *req.MachineID), slog.String("message", *req.Message))
+		m.log.Error("Failed to handle agent handshake", slog.Any("err", err))
 		return
 	}
 
 	m.log.Info("Received agent handshake", slog.String("vmid", *req.MachineID), slog.String("message", *req.Message))
 
-	_, ok := m.allVMs[*req.MachineID]
+	vm, ok := m.lookupMachine(*req.MachineID)
 	if !ok {
 		m.log.Warn("Received agent handshake attempt from a VM we don't know about.")
 		return
@@ -383,151 +1117,232 @@ func (m *MachineManager) handleHandshake(msg *nats.Msg) {
 		return
 	}
 
-	now := time.Now().UTC()
-	m.handshakes[*req.MachineID] = now.Format(time.RFC3339)
-}
-
-func (m *MachineManager) resetCNI() error {
-	m.log.Info("Resetting network")
-
-	err := os.RemoveAll("/var/lib/cni")
-	if err != nil {
-		return err
-	}
-
-	err = os.Mkdir("/var/lib/cni", 0755)
-	if err != nil {
-		return err
+	received := time.Now().UTC()
+	m.recordMachineEvent(vm, controlapi.TimelineEventHandshake, *req.Message)
+	if !vm.boot.started.IsZero() && !m.handshakes.completed(vm.vmmID) {
+		m.t.agentHandshakeLatency.Record(m.ctx, millisBetween(vm.boot.started, received),
+			metric.WithAttributes(attribute.String("machine_template", machineTemplateName(vm.template))),
+		)
 	}
+	m.completeMachineBoot(vm, &req, received)
 
-	cmd := exec.Command("bash", "-c", "for name in $(ifconfig -a | sed 's/[ \t].*//;/^\\(lo\\|\\)$/d' | grep veth); do ip link delete $name; done")
-	err = cmd.Start()
-	if err != nil {
-		return err
-	}
-	err = cmd.Wait()
+	err = m.transitionMachine(vm, machineStateReady)
 	if err != nil {
-		return err
+		m.log.Warn("Received agent handshake from a machine which is no longer warming", slog.Any("err", err))
 	}
 
-	return nil
+	m.handshakes.record(vm.vmmID, received, func(vmID string) bool {
+		_, ok := m.lookupMachine(vmID)
+		return ok
+	})
 }
 
 // Remove firecracker VM sockets created by this pid
-func (m *MachineManager) cleanSockets() {
-	dir, err := os.ReadDir(os.TempDir())
+// Sweeps machine sockets, firecracker logs and root filesystems from the run directory. Only the
+// files belonging to this node process are removed, unless previous is true, in which case only
+// files left behind by previous node processes are removed
+func (m *MachineManager) cleanRunDirectory(previous bool) {
+	dir := m.config.runDirectory()
+
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		m.log.Error("Failed to read temp directory", slog.Any("err", err))
+		m.log.Error("Failed to read run directory", slog.String("dir", dir), slog.Any("err", err))
+		return
 	}
 
-	for _, d := range dir {
-		if strings.Contains(d.Name(), fmt.Sprintf(".firecracker.sock-%d-", os.Getpid())) {
-			os.Remove(path.Join([]string{"tmp", d.Name()}...))
+	ownPrefix := fmt.Sprintf(".firecracker.sock-%d-", os.Getpid())
+	for _, e := range entries {
+		var remove bool
+		switch {
+		case strings.HasPrefix(e.Name(), ".firecracker.sock-"):
+			remove = strings.HasPrefix(e.Name(), ownPrefix) != previous
+		case strings.HasPrefix(e.Name(), "rootfs-") && strings.HasSuffix(e.Name(), ".ext4"):
+			// rootfs copies don't identify their node process, so they're only swept up on start
+			remove = previous
+		}
+
+		if remove {
+			err = os.Remove(filepath.Join(dir, e.Name()))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				m.log.Warn("Failed to remove file from run directory", slog.String("file", e.Name()), slog.Any("err", err))
+			}
 		}
 	}
 }
 
+// Subscribes to the given trigger subject on the external NATS connection, joining the subject's
+// queue group (if any) so trigger messages are load-balanced across identical workloads
+func (m *MachineManager) subscribeTrigger(request *agentapi.DeployRequest, tsub string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	if queue := request.TriggerQueueGroup(tsub); queue != "" {
+		return m.nc.QueueSubscribe(tsub, queue, handler)
+	}
+	return m.nc.Subscribe(tsub, handler)
+}
+
 func (m *MachineManager) generateTriggerHandler(vm *runningFirecracker, tsub string, request *agentapi.DeployRequest) func(msg *nats.Msg) {
 	return func(msg *nats.Msg) {
+		m.handleTrigger(vm, tsub, request, msg)
+	}
+}
 
-		ctx, parentSpan := tracer.Start(
-			m.ctx,
-			"workload-trigger",
-			trace.WithNewRoot(),
-			trace.WithSpanKind(trace.SpanKindServer),
-			trace.WithAttributes(
-				attribute.String("name", *request.WorkloadName),
-				attribute.String("namespace", vm.namespace),
-				attribute.String("trigger-subject", msg.Subject),
-			))
-
-		defer parentSpan.End()
-
-		intmsg := nats.NewMsg(fmt.Sprintf("agentint.%s.trigger", vm.vmmID))
-		// TODO: inject tracer context into message header
-		intmsg.Data = msg.Data
-
-		intmsg.Header.Add(nexTriggerSubject, msg.Subject)
-
-		cctx, childSpan := tracer.Start(
-			ctx,
-			"internal request",
-			trace.WithSpanKind(trace.SpanKindClient),
+// Executes the deployed function with the given trigger message, responding to the message
+// with the function's result
+func (m *MachineManager) handleTrigger(vm *runningFirecracker, tsub string, request *agentapi.DeployRequest, msg *nats.Msg) {
+	started := time.Now()
+	resp, err := m.executeTrigger(vm, tsub, request, msg)
+	m.recordCanaryTrigger(vm, time.Since(started), err)
+	if vm.microService != nil {
+		vm.microService.record(tsub, time.Since(started), err)
+	}
+	if err != nil || resp == nil {
+		return
+	}
+
+	err = msg.Respond(resp.Data)
+	//_ = tracerProvider.ForceFlush(ctx)
+	if err != nil {
+		m.log.Error("Failed to respond to trigger subject subscription request for deployed workload",
+			slog.String("vmid", vm.vmmID),
+			slog.String("trigger_subject", tsub),
+			slog.String("workload_type", *request.WorkloadType),
+			slog.Any("err", err),
 		)
+	}
+}
 
-		otel.GetTextMapPropagator().Inject(cctx, propagation.HeaderCarrier(msg.Header))
+// Executes the deployed function with the given trigger message by way of the agent, recording the
+// outcome in telemetry and events. Returns the agent's response on success
+func (m *MachineManager) executeTrigger(vm *runningFirecracker, tsub string, request *agentapi.DeployRequest, msg *nats.Msg) (*nats.Msg, error) {
+	if vm.state() != machineStateRunning {
+		return nil, fmt.Errorf("machine %s is %s", vm.vmmID, vm.state())
+	}
 
-		// TODO: make the agent's exec handler extract and forward the otel context
-		// so it continues in the host services like kv, obj, msg, etc
-		resp, err := m.ncInternal.RequestMsg(intmsg, time.Millisecond*10000) // FIXME-- make timeout configurable
-		childSpan.End()
+	ctx, parentSpan := tracer.Start(
+		m.ctx,
+		"workload-trigger",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("name", *request.WorkloadName),
+			attribute.String("namespace", vm.namespace),
+			attribute.String("trigger-subject", msg.Subject),
+		))
+
+	defer parentSpan.End()
+
+	intmsg := nats.NewMsg(fmt.Sprintf("agentint.%s.trigger", vm.vmmID))
+	intmsg.Data = msg.Data
+
+	intmsg.Header.Add(nexTriggerSubject, msg.Subject)
+	if key := msg.Header.Get(nexIdempotencyKey); key != "" {
+		intmsg.Header.Add(nexIdempotencyKey, key)
+	}
 
-		//for reference - this is what agent exec would also do
-		//ctx = otel.GetTextMapPropagator().Extract(cctx, propagation.HeaderCarrier(msg.Header))
+	cctx, childSpan := tracer.Start(
+		ctx,
+		"internal request",
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
 
-		parentSpan.AddEvent("Completed internal request")
-		if err != nil {
-			parentSpan.SetStatus(codes.Error, "Internal trigger request failed")
-			parentSpan.RecordError(err)
-			m.log.Error("Failed to request agent execution via internal trigger subject",
-				slog.Any("err", err),
-				slog.String("trigger_subject", tsub),
-				slog.String("workload_type", *request.WorkloadType),
-				slog.String("vmid", vm.vmmID),
-			)
+	// the agent extracts the trace context and forwards it with any host services requests
+	// made by the function, which are traced as children of the internal request
+	otel.GetTextMapPropagator().Inject(cctx, propagation.HeaderCarrier(intmsg.Header))
+
+	resp, err := m.ncInternal.RequestMsg(intmsg, time.Millisecond*triggerTimeoutMillis) // FIXME-- make timeout configurable
+	childSpan.End()
+
+	parentSpan.AddEvent("Completed internal request")
+	if err != nil {
+		parentSpan.SetStatus(codes.Error, "Internal trigger request failed")
+		parentSpan.RecordError(err)
+		m.log.Error("Failed to request agent execution via internal trigger subject",
+			slog.Any("err", err),
+			slog.String("trigger_subject", tsub),
+			slog.String("workload_type", *request.WorkloadType),
+			slog.String("vmid", vm.vmmID),
+		)
+
+		m.t.functionFailedTriggers.Add(m.ctx, 1)
+		m.t.functionFailedTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+		m.t.functionFailedTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
+		m.t.exemplars.recordFailure(parentSpan.SpanContext(), *vm.deployRequest.WorkloadName)
+		m.recordUtilization(vm.namespace, func(usage *controlapi.NamespaceUtilization) {
+			usage.FailedTriggers++
+		})
+		_ = m.publishFunctionExecFailed(vm, *request.WorkloadName, tsub, err)
+
+		failures := atomic.AddUint32(&vm.consecutiveTriggerFailures, 1)
+		threshold := m.config.TriggerFailureThreshold
+		if threshold > 0 && failures >= uint32(threshold) {
+			// evict asynchronously, as stopping the machine drains this very subscription
+			go m.evictWorkload(vm, fmt.Sprintf("%d consecutive trigger executions failed; last error: %s", failures, err))
+		}
+
+		return nil, err
+	}
+
+	atomic.StoreUint32(&vm.consecutiveTriggerFailures, 0)
+	parentSpan.SetStatus(codes.Ok, "Trigger succeeded")
+	runtimeNs := resp.Header.Get(nexRuntimeNs)
+	m.log.Debug("Received response from execution via trigger subject",
+		slog.String("vmid", vm.vmmID),
+		slog.String("trigger_subject", tsub),
+		slog.String("workload_type", *request.WorkloadType),
+		slog.String("function_run_time_nanosec", runtimeNs),
+		slog.Int("payload_size", len(resp.Data)),
+	)
 
-			m.t.functionFailedTriggers.Add(m.ctx, 1)
-			m.t.functionFailedTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-			m.t.functionFailedTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
-			_ = m.publishFunctionExecFailed(vm, *request.WorkloadName, tsub, err)
-		} else if resp != nil {
-			parentSpan.SetStatus(codes.Ok, "Trigger succeeded")
-			runtimeNs := resp.Header.Get(nexRuntimeNs)
-			m.log.Debug("Received response from execution via trigger subject",
+	runTimeNs64, err := strconv.ParseInt(runtimeNs, 10, 64)
+	if err != nil {
+		m.log.Warn("failed to log function runtime", slog.Any("err", err))
+	}
+	_ = m.publishFunctionExecSucceeded(vm, tsub, runTimeNs64)
+	parentSpan.AddEvent("published success event")
+
+	m.t.functionTriggers.Add(m.ctx, 1)
+	m.t.functionTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.functionTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
+	m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64)
+	m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
+	m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
+	m.t.exemplars.recordRuntime(parentSpan.SpanContext(), *vm.deployRequest.WorkloadName, runTimeNs64)
+	m.recordUtilization(vm.namespace, func(usage *controlapi.NamespaceUtilization) {
+		usage.Triggers++
+		usage.FunctionRunTimeMillis += runTimeNs64 / int64(time.Millisecond)
+	})
+
+	if request.CompletionSubject != nil {
+		err = m.nc.Publish(*request.CompletionSubject, resp.Data)
+		if err != nil {
+			m.log.Warn("Failed to publish function result to completion subject",
 				slog.String("vmid", vm.vmmID),
-				slog.String("trigger_subject", tsub),
-				slog.String("workload_type", *request.WorkloadType),
-				slog.String("function_run_time_nanosec", runtimeNs),
-				slog.Int("payload_size", len(resp.Data)),
+				slog.String("completion_subject", *request.CompletionSubject),
+				slog.Any("err", err),
 			)
-
-			runTimeNs64, err := strconv.ParseInt(runtimeNs, 10, 64)
-			if err != nil {
-				m.log.Warn("failed to log function runtime", slog.Any("err", err))
-			}
-			_ = m.publishFunctionExecSucceeded(vm, tsub, runTimeNs64)
-			parentSpan.AddEvent("published success event")
-
-			m.t.functionTriggers.Add(m.ctx, 1)
-			m.t.functionTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-			m.t.functionTriggers.Add(m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
-			m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64)
-			m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64, metric.WithAttributes(attribute.String("namespace", vm.namespace)))
-			m.t.functionRunTimeNano.Add(m.ctx, runTimeNs64, metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName)))
-
-			err = msg.Respond(resp.Data)
-			//_ = tracerProvider.ForceFlush(ctx)
-			if err != nil {
-				parentSpan.SetStatus(codes.Error, "Failed to respond to trigger subject")
-				parentSpan.RecordError(err)
-				m.log.Error("Failed to respond to trigger subject subscription request for deployed workload",
-					slog.String("vmid", vm.vmmID),
-					slog.String("trigger_subject", tsub),
-					slog.String("workload_type", *request.WorkloadType),
-					slog.Any("err", err),
-				)
-			}
 		}
 	}
+
+	return resp, nil
 }
 
 func (m *MachineManager) setMetadata(vm *runningFirecracker) error {
-	return vm.setMetadata(&agentapi.MachineMetadata{
-		Message:      agentapi.StringOrNil("Host-supplied metadata"),
-		NodeNatsHost: vm.config.InternalNodeHost,
-		NodeNatsPort: vm.config.InternalNodePort,
-		VmID:         &vm.vmmID,
+	seed, err := m.internalAuth.issue(vm.vmmID)
+	if err != nil {
+		vm.vmmCancel()
+		return err
+	}
+
+	err = vm.setMetadata(&agentapi.MachineMetadata{
+		Message:          agentapi.StringOrNil("Host-supplied metadata"),
+		NodeNatsHost:     vm.config.InternalNodeHost,
+		NodeNatsPort:     vm.config.InternalNodePort,
+		NodeNatsNkeySeed: &seed,
+		VmID:             &vm.vmmID,
 	})
+	if err != nil {
+		m.internalAuth.revoke(vm.vmmID)
+	}
+	return err
 }
 
 func (m *MachineManager) stopping() bool {
@@ -41,15 +41,7 @@ func (m *MachineManager) publishFunctionExecSucceeded(vm *runningFirecracker, ts
 		return err
 	}
 
-	emitLog := emittedLog{
-		Text:      fmt.Sprintf("Function %s execution succeeded (%dns)", functionExecPassed.Name, functionExecPassed.Elapsed),
-		Level:     slog.LevelDebug,
-		MachineId: vm.vmmID,
-	}
-	logBytes, _ := json.Marshal(emitLog)
-
-	subject := fmt.Sprintf("%s.%s.%s.%s.%s", LogSubjectPrefix, vm.namespace, m.publicKey, *vm.deployRequest.WorkloadName, vm.vmmID)
-	err = m.nc.Publish(subject, logBytes)
+	err = m.publishMachineLog(vm, controlapi.LogSourceHost, slog.LevelDebug, fmt.Sprintf("Function %s execution succeeded (%dns)", functionExecPassed.Name, functionExecPassed.Elapsed))
 	if err != nil {
 		m.log.Error("Failed to publish function exec passed log", slog.Any("err", err))
 	}
@@ -84,17 +76,41 @@ func (m *MachineManager) publishFunctionExecFailed(vm *runningFirecracker, workl
 		return err
 	}
 
-	emitLog := emittedLog{
-		Text:      "Function execution failed",
-		Level:     slog.LevelError,
-		MachineId: vm.vmmID,
+	err = m.publishMachineLog(vm, controlapi.LogSourceHost, slog.LevelError, "Function execution failed")
+	if err != nil {
+		m.log.Error("Failed to publish function exec failed log", slog.Any("err", err))
+	}
+
+	return m.nc.Flush()
+}
+
+// publishWorkloadFailed writes a workload failed event for a workload that was evicted from the provided VM
+func (m *MachineManager) publishWorkloadFailed(vm *runningFirecracker, reason string) error {
+	m.publishWorkloadLifecycle(vm, controlapi.WorkloadStateFailed, reason)
+
+	workloadFailed := controlapi.WorkloadFailedEvent{
+		Name:    *vm.deployRequest.WorkloadName,
+		VmId:    vm.vmmID,
+		Reason:  reason,
+		Evicted: true,
+	}
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(agentapi.WorkloadFailedEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(workloadFailed)
+
+	err := PublishCloudEvent(m.nc, vm.namespace, cloudevent, m.log)
+	if err != nil {
+		return err
 	}
-	logBytes, _ := json.Marshal(emitLog)
 
-	subject := fmt.Sprintf("%s.%s.%s.%s.%s", LogSubjectPrefix, vm.namespace, m.publicKey, *vm.deployRequest.WorkloadName, vm.vmmID)
-	err = m.nc.Publish(subject, logBytes)
+	err = m.publishMachineLog(vm, controlapi.LogSourceHost, slog.LevelError, fmt.Sprintf("Workload evicted: %s", reason))
 	if err != nil {
-		m.log.Error("Failed to publish function exec failed log", slog.Any("err", err))
+		m.log.Error("Failed to publish workload failed log", slog.Any("err", err))
 	}
 
 	return m.nc.Flush()
@@ -106,6 +122,8 @@ func (m *MachineManager) publishMachineStopped(vm *runningFirecracker) error {
 		return errors.New("machine stopped event was not published")
 	}
 
+	m.publishWorkloadLifecycle(vm, controlapi.WorkloadStateStopped, "")
+
 	workloadName := strings.TrimSpace(vm.deployRequest.DecodedClaims.Subject)
 	if len(workloadName) > 0 {
 		workloadStopped := struct {
@@ -131,15 +149,7 @@ func (m *MachineManager) publishMachineStopped(vm *runningFirecracker) error {
 			return err
 		}
 
-		emitLog := emittedLog{
-			Text:      "Workload stopped",
-			Level:     slog.LevelDebug,
-			MachineId: vm.vmmID,
-		}
-		logBytes, _ := json.Marshal(emitLog)
-
-		subject := fmt.Sprintf("%s.%s.%s.%s.%s", LogSubjectPrefix, vm.namespace, m.publicKey, workloadName, vm.vmmID)
-		err = m.nc.Publish(subject, logBytes)
+		err = m.publishMachineLog(vm, controlapi.LogSourceHost, slog.LevelDebug, "Workload stopped")
 		if err != nil {
 			m.log.Error("Failed to publish machine stopped event", slog.Any("err", err))
 		}
@@ -156,7 +166,7 @@ func (m *MachineManager) handleAgentLog(msg *nats.Msg) {
 	tokens := strings.Split(msg.Subject, ".")
 	vmID := tokens[1]
 
-	vm, ok := m.allVMs[vmID]
+	vm, ok := m.lookupMachine(vmID)
 	if !ok {
 		m.log.Warn("Received a log message from an unknown VM.")
 		return
@@ -171,23 +181,18 @@ func (m *MachineManager) handleAgentLog(msg *nats.Msg) {
 
 	m.log.Debug("Received agent log", slog.String("vmid", vmID), slog.String("log", logentry.Text))
 
-	bytes, err := json.Marshal(&emittedLog{
-		Text:      logentry.Text,
-		Level:     slog.Level(logentry.Level),
-		MachineId: vmID,
-	})
-	if err != nil {
-		m.log.Error("Failed to marshal our own log entry", slog.Any("err", err))
+	source := controlapi.LogSourceWorkload
+	if logentry.Source == agentapi.AgentLogSource {
+		source = controlapi.LogSourceAgent
+	} else if vm.deployRequest != nil && logentry.Level > vm.deployRequest.LogLimits.MinLevel() {
+		// agents already drop such output, but older ones don't
 		return
 	}
 
-	var workload *string
-	if vm.deployRequest != nil {
-		workload = vm.deployRequest.WorkloadName
+	err = m.publishMachineLog(vm, source, logentry.Level.SlogLevel(), logentry.Text)
+	if err != nil {
+		m.log.Error("Failed to publish agent log", slog.String("vmid", vmID), slog.Any("err", err))
 	}
-
-	subject := logPublishSubject(vm.namespace, m.publicKey, vmID, workload)
-	_ = m.nc.Publish(subject, bytes)
 }
 
 // Called when the node server gets an event from the nex agent inside firecracker. The data here is already a fully formed
@@ -197,7 +202,7 @@ func (m *MachineManager) handleAgentEvent(msg *nats.Msg) {
 	tokens := strings.Split(msg.Subject, ".")
 	vmID := tokens[1]
 
-	vm, ok := m.allVMs[vmID]
+	vm, ok := m.lookupMachine(vmID)
 	if !ok {
 		m.log.Warn("Received an event from a VM we don't know about. Rejecting.")
 		return
@@ -212,18 +217,27 @@ func (m *MachineManager) handleAgentEvent(msg *nats.Msg) {
 
 	m.log.Info("Received agent event", slog.String("vmid", vmID), slog.String("type", evt.Type()))
 
-	err = PublishCloudEvent(m.nc, vm.namespace, evt, m.log)
+	// agents start before any workload is deployed to them, so their first events are the node's
+	namespace := vm.namespace
+	if namespace == "" {
+		namespace = controlapi.SystemNamespace
+	}
+
+	err = PublishCloudEvent(m.nc, namespace, evt, m.log)
 	if err != nil {
 		m.log.Error("Failed to publish cloudevent", slog.Any("err", err))
 		return
 	}
 
-	if evt.Type() == agentapi.WorkloadStoppedEventType {
-		_ = m.StopMachine(vmID, false)
+	if evt.Type() == agentapi.WorkloadOOMEventType {
+		m.recordWorkloadOOM(vm, evt)
+	}
 
+	if evt.Type() == agentapi.WorkloadStoppedEventType {
 		evtData, err := evt.DataBytes()
 		if err != nil {
 			m.log.Error("Failed to read cloudevent data", slog.Any("err", err))
+			_ = m.StopMachine(vmID, false)
 			return
 		}
 
@@ -231,9 +245,21 @@ func (m *MachineManager) handleAgentEvent(msg *nats.Msg) {
 		err = json.Unmarshal(evtData, &workloadStatus)
 		if err != nil {
 			m.log.Error("Failed to unmarshal workload status from cloudevent data", slog.Any("err", err))
+			_ = m.StopMachine(vmID, false)
 			return
 		}
 
+		if vm.state() == machineStateRunning {
+			// the workload exited on its own rather than being stopped by the node
+			reason := fmt.Sprintf("Workload exited with code %d: %s", workloadStatus.Code, workloadStatus.Message)
+			m.recordMachineEvent(vm, controlapi.TimelineEventStopRequested, reason)
+			if workloadStatus.Code != 0 {
+				m.publishWorkloadLifecycle(vm, controlapi.WorkloadStateFailed, reason)
+			}
+		}
+
+		_ = m.StopMachine(vmID, false)
+
 		if vm.isEssential() && workloadStatus.Code != 0 {
 			m.log.Debug("Essential workload stopped with non-zero exit code",
 				slog.String("vmid", vmID),
@@ -241,35 +267,7 @@ func (m *MachineManager) handleAgentEvent(msg *nats.Msg) {
 				slog.String("workload", *vm.deployRequest.WorkloadName),
 				slog.String("workload_type", *vm.deployRequest.WorkloadType))
 
-			if vm.deployRequest.RetryCount == nil {
-				retryCount := uint(0)
-				vm.deployRequest.RetryCount = &retryCount
-			}
-
-			*vm.deployRequest.RetryCount += 1
-
-			retriedAt := time.Now().UTC()
-			vm.deployRequest.RetriedAt = &retriedAt
-
-			req, _ := json.Marshal(&controlapi.DeployRequest{
-				Argv:            vm.deployRequest.Argv,
-				Description:     vm.deployRequest.Description,
-				WorkloadType:    vm.deployRequest.WorkloadType,
-				Location:        vm.deployRequest.Location,
-				WorkloadJwt:     vm.deployRequest.WorkloadJwt,
-				Environment:     vm.deployRequest.EncryptedEnvironment,
-				Essential:       vm.deployRequest.Essential,
-				RetriedAt:       vm.deployRequest.RetriedAt,
-				RetryCount:      vm.deployRequest.RetryCount,
-				SenderPublicKey: vm.deployRequest.SenderPublicKey,
-				TargetNode:      vm.deployRequest.TargetNode,
-				TriggerSubjects: vm.deployRequest.TriggerSubjects,
-				JsDomain:        vm.deployRequest.JsDomain,
-			})
-
-			nodeID, _ := m.kp.PublicKey()
-			subject := fmt.Sprintf("%s.DEPLOY.%s.%s", controlapi.APIPrefix, vm.namespace, nodeID)
-			_, err = m.nc.Request(subject, req, time.Millisecond*2500)
+			err = m.redeployWorkload(vm)
 			if err != nil {
 				m.log.Error("Failed to redeploy essential workload", slog.Any("err", err))
 			}
@@ -277,12 +275,107 @@ func (m *MachineManager) handleAgentEvent(msg *nats.Msg) {
 	}
 }
 
-func logPublishSubject(namespace string, node string, vm string, workload *string) string {
-	// $NEX.logs.{namespace}.{node}.{vm}[.{workload name}]
-	subject := fmt.Sprintf("%s.%s.%s.%s", LogSubjectPrefix, namespace, node, vm)
-	if workload != nil {
-		subject = fmt.Sprintf("%s.%s", subject, *workload)
+// Submits a deploy request to this node for the workload which was previously running in the
+// given (stopped) VM, incrementing its retry count
+func (m *MachineManager) redeployWorkload(vm *runningFirecracker) error {
+	if vm.deployRequest.RetryCount == nil {
+		retryCount := uint(0)
+		vm.deployRequest.RetryCount = &retryCount
+	}
+
+	*vm.deployRequest.RetryCount += 1
+
+	retriedAt := time.Now().UTC()
+	vm.deployRequest.RetriedAt = &retriedAt
+
+	if id, ok := vm.deployRequest.DecodedClaims.Data["token_id"].(string); ok {
+		m.deployTokens.allowRedeploy(id)
+	}
+
+	req, _ := json.Marshal(controlDeployRequest(vm.deployRequest))
+
+	nodeID, _ := m.kp.PublicKey()
+	subject := fmt.Sprintf("%s.DEPLOY.%s.%s", controlapi.APIPrefix, vm.namespace, nodeID)
+	_, err := m.nc.Request(subject, req, time.Millisecond*2500)
+	return err
+}
+
+// Converts the given agent deploy request back into the control API deploy request it was
+// created from, e.g., to redeploy the workload
+func controlDeployRequest(request *agentapi.DeployRequest) *controlapi.DeployRequest {
+	return &controlapi.DeployRequest{
+		Argv:               request.Argv,
+		Description:        request.Description,
+		WorkloadType:       request.WorkloadType,
+		Location:           request.Location,
+		WorkloadJwt:        request.WorkloadJwt,
+		IssuerChain:        request.IssuerChain,
+		Environment:        request.EncryptedEnvironment,
+		SealedEnvironment:  request.SealedEnvironment,
+		Secrets:            request.Secrets,
+		Essential:          request.Essential,
+		ExecPerTrigger:     request.ExecPerTrigger,
+		Stdin:              request.Stdin,
+		HealthCheck:        controlHealthCheck(request.HealthCheck),
+		ReadinessProbe:     controlHealthCheck(request.ReadinessProbe),
+		IdleTimeoutMillis:  request.IdleTimeoutMillis,
+		AckTimeoutMillis:   request.AckTimeoutMillis,
+		ReadyTimeoutMillis: request.ReadyTimeoutMillis,
+		CompletionSubject:  request.CompletionSubject,
+		CronTriggers:       controlCronTriggers(request.CronTriggers),
+		Labels:             request.Labels,
+		MachineTemplate:    request.MachineTemplate,
+		VcpuCount:          request.VcpuCount,
+		MemSizeMib:         request.MemSizeMib,
+		MemorySoftLimit:    controlMemorySoftLimit(request.MemorySoftLimit),
+		LogLimits:          controlLogLimits(request.LogLimits),
+		EgressPolicy:       controlEgressPolicy(request.EgressPolicy),
+		SandboxProfile:     request.SandboxProfile,
+		StableIP:           request.StableIP,
+		DNSName:            request.DNSName,
+		VolumeSizeMib:      request.VolumeSizeMib,
+		Credentials:        controlCredentialsRequest(request.Credentials),
+		Digest:             &request.Hash,
+		StreamArtifact:     request.StreamArtifact,
+		PostStopHook:       controlWorkloadHook(request.PostStopHook),
+		PreStartHook:       controlWorkloadHook(request.PreStartHook),
+		Signature:          controlArtifactSignature(request.Signature),
+		RetriedAt:          request.RetriedAt,
+		RetryCount:         request.RetryCount,
+		SenderPublicKey:    request.SenderPublicKey,
+		TargetNode:         request.TargetNode,
+		TriggerSubjects:    request.TriggerSubjects,
+		TriggerDelivery:    request.TriggerDelivery,
+		TriggerQueueGroups: request.TriggerQueueGroups,
+		TriggerConcurrency: controlTriggerConcurrency(request.TriggerConcurrency),
+		JsDomain:           request.JsDomain,
+		Webhook:            controlWebhookTrigger(request.Webhook),
+		MicroService:       controlMicroService(request.MicroService),
+	}
+}
+
+// Publishes a log record about the machine from the given source. Machines log in the system
+// namespace, as the nex-agent workload, until a workload is deployed to them, so that their
+// namespace's logs only ever carry its own workloads'
+func (m *MachineManager) publishMachineLog(vm *runningFirecracker, source string, level slog.Level, text string) error {
+	namespace, workload := controlapi.SystemNamespace, agentLogWorkload
+	if vm.deployRequest != nil && vm.deployRequest.WorkloadName != nil {
+		namespace, workload = vm.namespace, *vm.deployRequest.WorkloadName
+	}
+
+	now := time.Now().UTC()
+	raw, err := json.Marshal(&controlapi.RawLog{
+		Time:         &now,
+		Level:        level,
+		Source:       source,
+		Namespace:    namespace,
+		WorkloadName: workload,
+		MachineId:    vm.vmmID,
+		Text:         text,
+	})
+	if err != nil {
+		return err
 	}
 
-	return subject
+	return m.nc.Publish(controlapi.LogSubject(namespace, workload, m.publicKey, vm.vmmID), raw)
 }
@@ -0,0 +1,189 @@
+package nexnode
+
+import (
+	"fmt"
+	"sort"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// The number of vCPUs and MiB of memory of a machine
+type machineSize struct {
+	vcpuCount  int
+	memSizeMib int
+}
+
+func (s machineSize) fits(requested machineSize) bool {
+	return s.vcpuCount >= requested.vcpuCount && s.memSizeMib >= requested.memSizeMib
+}
+
+// A pool of warm machines of the default machine template, all of the same size. The pool of the
+// default size class is the machine manager's warm pool
+type machinePool struct {
+	sizeClass string
+	size      machineSize
+	capacity  int
+	machines  chan *runningFirecracker
+}
+
+// Creates the pools of warm machines: the pool of the default template's size, followed by a
+// pool for each of the node's size classes, ordered from smallest to largest
+func newMachinePools(config *NodeConfiguration) []*machinePool {
+	pools := []*machinePool{{
+		sizeClass: DefaultMachineTemplate,
+		size: machineSize{
+			vcpuCount:  *config.MachineTemplate.VcpuCount,
+			memSizeMib: *config.MachineTemplate.MemSizeMib,
+		},
+		capacity: config.MachinePoolSize,
+		machines: make(chan *runningFirecracker, config.MachinePoolSize),
+	}}
+
+	classes := make([]*machinePool, 0, len(config.MachineSizeClasses))
+	for name, class := range config.MachineSizeClasses {
+		classes = append(classes, &machinePool{
+			sizeClass: name,
+			size:      machineSize{vcpuCount: class.VcpuCount, memSizeMib: class.MemSizeMib},
+			capacity:  class.PoolSize,
+			machines:  make(chan *runningFirecracker, class.PoolSize),
+		})
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].size.memSizeMib != classes[j].size.memSizeMib {
+			return classes[i].size.memSizeMib < classes[j].size.memSizeMib
+		}
+		return classes[i].size.vcpuCount < classes[j].size.vcpuCount
+	})
+
+	return append(pools, classes...)
+}
+
+// Returns the first pool which holds fewer warm machines than its capacity, if any. Pools have no
+// deficit while the node is in standby or machine creation is paused
+func (m *MachineManager) poolWithDeficit() *machinePool {
+	if m.standby.Load() || m.creationPausedUntil() != nil {
+		return nil
+	}
+	for _, pool := range m.pools {
+		if len(pool.machines) < pool.capacity {
+			return pool
+		}
+	}
+	return nil
+}
+
+// Takes a warm machine from the pool of the smallest size class that fits the given size without
+// waiting, returning nil if every fitting pool is empty
+func (m *MachineManager) takeSizedMachine(size machineSize) *runningFirecracker {
+	candidates := make([]*machinePool, 0, len(m.pools))
+	for _, pool := range m.pools {
+		if pool.size.fits(size) {
+			candidates = append(candidates, pool)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].size.memSizeMib != candidates[j].size.memSizeMib {
+			return candidates[i].size.memSizeMib < candidates[j].size.memSizeMib
+		}
+		return candidates[i].size.vcpuCount < candidates[j].size.vcpuCount
+	})
+
+	for _, pool := range candidates {
+		for {
+			var vm *runningFirecracker
+			var ok bool
+			select {
+			case vm, ok = <-pool.machines:
+			default:
+			}
+			if vm == nil || !ok {
+				break
+			}
+
+			if m.handshakes.completed(vm.vmmID) {
+				return vm
+			}
+			_ = m.StopMachine(vm.vmmID, false)
+		}
+	}
+
+	return nil
+}
+
+// Returns the number of warm machines across all pools
+func (m *MachineManager) warmMachineCount() int {
+	count := 0
+	for _, pool := range m.pools {
+		count += len(pool.machines)
+	}
+	return count
+}
+
+// Returns the total capacity of all pools
+func (m *MachineManager) poolCapacity() int {
+	capacity := 0
+	for _, pool := range m.pools {
+		capacity += pool.capacity
+	}
+	return capacity
+}
+
+// Returns the size of machine the request asks for, filling in whichever of vCPUs and memory it
+// omits from the given machine template, or nil if it doesn't ask for a size
+func (m *MachineManager) requestedMachineSize(template string, vcpuCount *int, memSizeMib *int) *machineSize {
+	if vcpuCount == nil && memSizeMib == nil {
+		return nil
+	}
+
+	config, ok := m.templates[template]
+	if !ok {
+		config = m.templates[DefaultMachineTemplate]
+	}
+
+	size := machineSize{
+		vcpuCount:  *config.MachineTemplate.VcpuCount,
+		memSizeMib: *config.MachineTemplate.MemSizeMib,
+	}
+	if vcpuCount != nil {
+		size.vcpuCount = *vcpuCount
+	}
+	if memSizeMib != nil {
+		size.memSizeMib = *memSizeMib
+	}
+	return &size
+}
+
+// Returns the size of machine the given deploy request asks for, if any
+func (m *MachineManager) deployRequestMachineSize(request *agentapi.DeployRequest) *machineSize {
+	return m.requestedMachineSize(machineTemplateName(request.Template()), request.VcpuCount, request.MemSizeMib)
+}
+
+// Ensures the requested machine size is within the node's limits
+func (m *MachineManager) validateMachineSize(size *machineSize) error {
+	if size == nil {
+		return nil
+	}
+
+	limits := m.config.MachineSizeLimits
+	if limits == nil {
+		return fmt.Errorf("this node does not allow deploy requests to specify a machine size")
+	}
+
+	if size.vcpuCount < limits.MinVcpuCount || size.vcpuCount > limits.MaxVcpuCount {
+		return fmt.Errorf("vcpu count %d is outside of the node's limits (%d-%d)", size.vcpuCount, limits.MinVcpuCount, limits.MaxVcpuCount)
+	}
+	if size.memSizeMib < limits.MinMemSizeMib || size.memSizeMib > limits.MaxMemSizeMib {
+		return fmt.Errorf("memory size %d MiB is outside of the node's limits (%d-%d)", size.memSizeMib, limits.MinMemSizeMib, limits.MaxMemSizeMib)
+	}
+
+	return nil
+}
+
+// Returns the name of the given machine template, substituting the default template for an
+// empty name
+func machineTemplateName(template string) string {
+	if template == "" {
+		return DefaultMachineTemplate
+	}
+	return template
+}
@@ -0,0 +1,125 @@
+package nexnode
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// The machine manager's record of its machines (allVMs, stopMutex and vmsubz) and of its
+// functions which scale to zero (idleFunctions) is shared by the pool loop, NATS handlers,
+// trigger handlers and whichever goroutine stops a machine or function, so it's only accessed
+// through these methods, which hold vmsMutex. Callers never hold vmsMutex while doing
+// anything else; iterating callers work on a snapshot of the machines instead
+
+// Records a machine the manager has started
+func (m *MachineManager) trackMachine(vm *runningFirecracker) {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	m.allVMs[vm.vmmID] = vm
+	m.stopMutex[vm.vmmID] = &sync.Mutex{}
+}
+
+// Forgets a machine once it has stopped, along with any subscriptions still recorded for it
+func (m *MachineManager) forgetMachine(vmID string) {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	delete(m.allVMs, vmID)
+	delete(m.stopMutex, vmID)
+	delete(m.vmsubz, vmID)
+}
+
+// Returns the machine with the given ID, if the manager is running it
+func (m *MachineManager) lookupMachine(vmID string) (*runningFirecracker, bool) {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	vm, ok := m.allVMs[vmID]
+	return vm, ok
+}
+
+// Returns the mutex serializing attempts to stop the given machine, or nil if the manager isn't
+// running it
+func (m *MachineManager) machineStopMutex(vmID string) *sync.Mutex {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	return m.stopMutex[vmID]
+}
+
+// Returns a snapshot of the machines the manager is running, warm and deployed alike
+func (m *MachineManager) runningMachines() []*runningFirecracker {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	vms := make([]*runningFirecracker, 0, len(m.allVMs))
+	for _, vm := range m.allVMs {
+		vms = append(vms, vm)
+	}
+	return vms
+}
+
+func (m *MachineManager) runningMachineCount() int {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	return len(m.allVMs)
+}
+
+// Records a subscription made on behalf of the given machine, which is drained when it stops
+func (m *MachineManager) addMachineSubscription(vmID string, sub *nats.Subscription) {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	m.vmsubz[vmID] = append(m.vmsubz[vmID], sub)
+}
+
+// Removes and returns the subscriptions recorded for the given machine, for the caller to drain
+func (m *MachineManager) takeMachineSubscriptions(vmID string) []*nats.Subscription {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	subs := m.vmsubz[vmID]
+	delete(m.vmsubz, vmID)
+	return subs
+}
+
+// Records a function which scales to zero when idle
+func (m *MachineManager) trackIdleFunction(fn *idleFunction) {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	m.idleFunctions[fn.id] = fn
+}
+
+// Forgets a function once it has been stopped for good
+func (m *MachineManager) forgetIdleFunction(id string) {
+	m.vmsMutex.Lock()
+	defer m.vmsMutex.Unlock()
+
+	delete(m.idleFunctions, id)
+}
+
+// Returns the function deployed with the given ID, if any
+func (m *MachineManager) idleFunctionByID(id string) (*idleFunction, bool) {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	fn, ok := m.idleFunctions[id]
+	return fn, ok
+}
+
+// Returns a snapshot of the functions deployed to the node which scale to zero when idle,
+// whether or not they're currently running
+func (m *MachineManager) idleFunctionsSnapshot() []*idleFunction {
+	m.vmsMutex.RLock()
+	defer m.vmsMutex.RUnlock()
+
+	fns := make([]*idleFunction, 0, len(m.idleFunctions))
+	for _, fn := range m.idleFunctions {
+		fns = append(fns, fn)
+	}
+	return fns
+}
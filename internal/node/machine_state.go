@@ -0,0 +1,116 @@
+package nexnode
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// The lifecycle state of a machine. Machines start out warming in the pool, become ready once
+// the agent has completed its handshake, and are then deployed into exactly once. Once a machine
+// begins draining it never accepts another deployment or trigger
+type machineState uint32
+
+const (
+	machineStateWarming machineState = iota
+	machineStateReady
+	machineStateDeploying
+	machineStateRunning
+	machineStateDraining
+	machineStateStopped
+)
+
+// The states each state may legally transition to
+var machineStateTransitions = map[machineState][]machineState{
+	machineStateWarming:   {machineStateReady, machineStateDraining},
+	machineStateReady:     {machineStateDeploying, machineStateDraining},
+	machineStateDeploying: {machineStateRunning, machineStateDraining},
+	machineStateRunning:   {machineStateDraining},
+	machineStateDraining:  {machineStateStopped},
+}
+
+func (s machineState) String() string {
+	switch s {
+	case machineStateWarming:
+		return controlapi.MachineStateWarming
+	case machineStateReady:
+		return controlapi.MachineStateReady
+	case machineStateDeploying:
+		return controlapi.MachineStateDeploying
+	case machineStateRunning:
+		return controlapi.MachineStateRunning
+	case machineStateDraining:
+		return controlapi.MachineStateDraining
+	case machineStateStopped:
+		return controlapi.MachineStateStopped
+	default:
+		return "unknown"
+	}
+}
+
+func (vm *runningFirecracker) state() machineState {
+	return machineState(atomic.LoadUint32(&vm.machineState))
+}
+
+// Atomically moves the machine into the given state, returning the state it was in. Returns
+// an error, leaving the state unchanged, if the transition isn't allowed from the current state
+func (vm *runningFirecracker) transition(to machineState) (machineState, error) {
+	for {
+		from := vm.state()
+		if !slices.Contains(machineStateTransitions[from], to) {
+			return from, fmt.Errorf("machine %s cannot transition from %s to %s", vm.vmmID, from, to)
+		}
+
+		if atomic.CompareAndSwapUint32(&vm.machineState, uint32(from), uint32(to)) {
+			return from, nil
+		}
+	}
+}
+
+// Transitions the given machine to a new state, publishing a machine state changed event once the
+// machine belongs to a namespace (i.e., once a workload is being deployed into it)
+func (m *MachineManager) transitionMachine(vm *runningFirecracker, to machineState) error {
+	from, err := vm.transition(to)
+	if err != nil {
+		return err
+	}
+
+	m.recordTimelineEntry(vm, controlapi.TimelineEntry{
+		Event: controlapi.TimelineEventStateChanged,
+		State: to.String(),
+	})
+
+	m.log.Debug("Machine state changed",
+		slog.String("vmid", vm.vmmID),
+		slog.String("from", from.String()),
+		slog.String("to", to.String()),
+	)
+
+	if vm.namespace != "" {
+		cloudevent := cloudevents.NewEvent()
+		cloudevent.SetSource(m.publicKey)
+		cloudevent.SetID(uuid.NewString())
+		cloudevent.SetTime(time.Now().UTC())
+		cloudevent.SetType(controlapi.MachineStateChangedEventType)
+		cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+		_ = cloudevent.SetData(controlapi.MachineStateChangedEvent{
+			VmId: vm.vmmID,
+			From: from.String(),
+			To:   to.String(),
+		})
+
+		_ = PublishCloudEvent(m.nc, vm.namespace, cloudevent, m.log)
+	}
+
+	if state, ok := machineLifecycleStates[to]; ok {
+		m.publishWorkloadLifecycle(vm, state, "")
+	}
+
+	return nil
+}
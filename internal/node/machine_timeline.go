@@ -0,0 +1,72 @@
+package nexnode
+
+import (
+	"sync"
+	"time"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Number of stopped machines whose timelines are retained so operators can find out
+// why a machine went away after the fact
+const retainedTimelineCount = 100
+
+// The significant events in the life of a single machine, in the order they occurred
+type machineTimeline struct {
+	mutex     sync.Mutex
+	namespace string
+	entries   []controlapi.TimelineEntry
+}
+
+// Records an event in the timeline of the given machine
+func (m *MachineManager) recordMachineEvent(vm *runningFirecracker, event string, reason string) {
+	m.recordTimelineEntry(vm, controlapi.TimelineEntry{Event: event, Reason: reason})
+}
+
+func (m *MachineManager) recordTimelineEntry(vm *runningFirecracker, entry controlapi.TimelineEntry) {
+	entry.Timestamp = time.Now().UTC()
+
+	m.timelinesMutex.Lock()
+	defer m.timelinesMutex.Unlock()
+
+	timeline, ok := m.timelines[vm.vmmID]
+	if !ok {
+		timeline = &machineTimeline{}
+		m.timelines[vm.vmmID] = timeline
+	}
+
+	timeline.mutex.Lock()
+	if vm.namespace != "" {
+		timeline.namespace = vm.namespace
+	}
+	timeline.entries = append(timeline.entries, entry)
+	timeline.mutex.Unlock()
+
+	if entry.State == controlapi.MachineStateStopped {
+		m.stoppedTimelines = append(m.stoppedTimelines, vm.vmmID)
+		if len(m.stoppedTimelines) > retainedTimelineCount {
+			delete(m.timelines, m.stoppedTimelines[0])
+			m.stoppedTimelines = m.stoppedTimelines[1:]
+		}
+	}
+}
+
+// Returns the timeline of the machine with the given ID if it belongs to the given namespace
+func (m *MachineManager) machineTimeline(vmID string, namespace string) []controlapi.TimelineEntry {
+	m.timelinesMutex.Lock()
+	timeline, ok := m.timelines[vmID]
+	m.timelinesMutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	timeline.mutex.Lock()
+	defer timeline.mutex.Unlock()
+
+	if timeline.namespace != namespace {
+		return nil
+	}
+
+	return append([]controlapi.TimelineEntry{}, timeline.entries...)
+}
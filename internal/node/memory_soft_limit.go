@@ -0,0 +1,32 @@
+package nexnode
+
+import (
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+func agentMemorySoftLimit(limit *controlapi.MemorySoftLimit) *agentapi.MemorySoftLimit {
+	if limit == nil {
+		return nil
+	}
+
+	return &agentapi.MemorySoftLimit{
+		ThresholdPercent: limit.ThresholdPercent,
+		PressurePercent:  limit.PressurePercent,
+		Signal:           limit.Signal,
+		IntervalMillis:   limit.IntervalMillis,
+	}
+}
+
+func controlMemorySoftLimit(limit *agentapi.MemorySoftLimit) *controlapi.MemorySoftLimit {
+	if limit == nil {
+		return nil
+	}
+
+	return &controlapi.MemorySoftLimit{
+		ThresholdPercent: limit.ThresholdPercent,
+		PressurePercent:  limit.PressurePercent,
+		Signal:           limit.Signal,
+		IntervalMillis:   limit.IntervalMillis,
+	}
+}
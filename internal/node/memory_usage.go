@@ -0,0 +1,206 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	// Recommended memory sizes leave this much room above a workload's peak memory use
+	memoryRecommendationHeadroom = 1.25
+	// Workloads killed for running out of memory are recommended at least this much more memory
+	memoryRecommendationOOMGrowth = 1.5
+	// Recommended memory sizes are rounded up to a multiple of this many MiB
+	memoryRecommendationStepMib = 32
+	// Number of memory reports (a minute's worth) observed of a workload before it's recommended
+	// a different memory size
+	memoryRecommendationMinSamples = 6
+)
+
+type workloadMemoryKey struct {
+	namespace string
+	workload  string
+}
+
+// The memory use observed of a workload across every machine it has run in since the node started
+type workloadMemoryUsage struct {
+	memSizeMib int64
+	peakMib    int64
+	samples    int
+	oomKills   uint64
+	firstSeen  time.Time
+	lastSeen   time.Time
+}
+
+// Called when the node receives a report of the memory use (and, for functions, the trigger
+// executions) of a workload's machine from its agent via internal NATS. The report is cached on
+// the VM and folded into the workload's observed memory use
+func (m *MachineManager) handleAgentMemory(msg *nats.Msg) {
+	// agentint.{vmid}.memory
+	tokens := strings.Split(msg.Subject, ".")
+	vmID := tokens[1]
+
+	vm, ok := m.lookupMachine(vmID)
+	if !ok || vm.deployRequest == nil {
+		m.log.Warn("Received a memory report from an unknown VM.")
+		return
+	}
+
+	var report agentapi.MemoryReport
+	err := json.Unmarshal(msg.Data, &report)
+	if err != nil {
+		m.log.Error("Failed to unmarshal memory report from agent", slog.Any("err", err))
+		return
+	}
+
+	var previous *agentapi.TriggerExecutionReport
+	if vm.lastMemoryReport != nil {
+		previous = vm.lastMemoryReport.Triggers
+	}
+	m.recordTriggerExecutions(vm, previous, report.Triggers)
+
+	vm.lastMemoryReport = &report
+
+	m.updateWorkloadMemory(vm, func(usage *workloadMemoryUsage) {
+		usage.peakMib = max(usage.peakMib, report.PeakMib)
+		usage.samples++
+		usage.lastSeen = report.SampledAt
+	})
+}
+
+// Records the processes the kernel of the given VM killed for running out of memory, per the
+// given workload_oom event from its agent
+func (m *MachineManager) recordWorkloadOOM(vm *runningFirecracker, evt cloudevents.Event) {
+	var oom agentapi.WorkloadOOMEvent
+	err := evt.DataAs(&oom)
+	if err != nil {
+		m.log.Error("Failed to unmarshal workload oom event from agent", slog.Any("err", err))
+		return
+	}
+
+	m.updateWorkloadMemory(vm, func(usage *workloadMemoryUsage) {
+		if oom.OOMKills > vm.oomKills {
+			usage.oomKills += oom.OOMKills - vm.oomKills
+			vm.oomKills = oom.OOMKills
+		}
+		usage.lastSeen = time.Now().UTC()
+	})
+
+	reason := fmt.Sprintf("Out of memory with %d of %d MiB in use", oom.UsedMib, oom.TotalMib)
+	m.recordMachineEvent(vm, controlapi.TimelineEventOOMKilled, reason)
+}
+
+// Applies the given update to the observed memory use of the given VM's workload, which is
+// created if necessary, taking the memory size of the VM as that of the workload's machine
+func (m *MachineManager) updateWorkloadMemory(vm *runningFirecracker, update func(usage *workloadMemoryUsage)) {
+	key := workloadMemoryKey{namespace: vm.namespace, workload: *vm.deployRequest.WorkloadName}
+
+	m.workloadMemoryMutex.Lock()
+	defer m.workloadMemoryMutex.Unlock()
+
+	usage, ok := m.workloadMemory[key]
+	if !ok {
+		usage = &workloadMemoryUsage{firstSeen: time.Now().UTC()}
+		m.workloadMemory[key] = usage
+	}
+
+	usage.memSizeMib = vm.memSizeMib
+	update(usage)
+}
+
+// Returns the observed memory use of the namespace's workloads (or the named workload), along
+// with a recommended memory size for each, ordered by workload name
+func (m *MachineManager) workloadMemoryRecommendations(namespace string, workload string) *controlapi.MemoryResponse {
+	res := &controlapi.MemoryResponse{
+		NodeId:    m.publicKey,
+		Namespace: namespace,
+		Workloads: make([]controlapi.WorkloadMemory, 0),
+	}
+
+	m.workloadMemoryMutex.Lock()
+	defer m.workloadMemoryMutex.Unlock()
+
+	for key, usage := range m.workloadMemory {
+		if key.namespace != namespace || (workload != "" && key.workload != workload) {
+			continue
+		}
+
+		res.Workloads = append(res.Workloads, controlapi.WorkloadMemory{
+			WorkloadName:   key.workload,
+			MemSizeMib:     usage.memSizeMib,
+			PeakMib:        usage.peakMib,
+			Samples:        usage.samples,
+			OOMKills:       usage.oomKills,
+			FirstSeen:      usage.firstSeen,
+			LastSeen:       usage.lastSeen,
+			Recommendation: m.recommendMemory(usage),
+		})
+	}
+
+	sort.Slice(res.Workloads, func(i, j int) bool {
+		return res.Workloads[i].WorkloadName < res.Workloads[j].WorkloadName
+	})
+
+	return res
+}
+
+// Recommends a memory size for a workload based on its observed memory use: enough to leave
+// headroom above its peak use, more if it has run out of memory, and within the node's limits
+func (m *MachineManager) recommendMemory(usage *workloadMemoryUsage) controlapi.MemoryRecommendation {
+	current := usage.memSizeMib
+	target := roundUpMemory(float64(usage.peakMib) * memoryRecommendationHeadroom)
+	headroom := int((memoryRecommendationHeadroom - 1) * 100)
+
+	rec := controlapi.MemoryRecommendation{
+		Action:     controlapi.MemoryRecommendationKeep,
+		MemSizeMib: current,
+		Reason:     fmt.Sprintf("Peak use of %d MiB leaves at least %d%% headroom", usage.peakMib, headroom),
+	}
+
+	switch {
+	case usage.oomKills > 0:
+		rec.Action = controlapi.MemoryRecommendationIncrease
+		rec.MemSizeMib = max(target, roundUpMemory(float64(current)*memoryRecommendationOOMGrowth))
+		rec.Reason = fmt.Sprintf("Killed %d process(es) for running out of memory", usage.oomKills)
+	case usage.samples < memoryRecommendationMinSamples:
+		rec.Action = controlapi.MemoryRecommendationInsufficientData
+		rec.Reason = fmt.Sprintf("Only %d memory report(s) observed", usage.samples)
+	case target > current:
+		rec.Action = controlapi.MemoryRecommendationIncrease
+		rec.MemSizeMib = target
+		rec.Reason = fmt.Sprintf("Peak use of %d MiB leaves less than %d%% headroom", usage.peakMib, headroom)
+	case target <= current*3/4:
+		rec.Action = controlapi.MemoryRecommendationDecrease
+		rec.MemSizeMib = target
+		rec.Reason = fmt.Sprintf("Peak use of %d MiB leaves more headroom than needed", usage.peakMib)
+	}
+
+	if limits := m.config.MachineSizeLimits; limits != nil && rec.MemSizeMib != current {
+		if rec.MemSizeMib > int64(limits.MaxMemSizeMib) {
+			rec.MemSizeMib = int64(limits.MaxMemSizeMib)
+			rec.Reason += "; capped at the node's maximum"
+		}
+		if rec.MemSizeMib < int64(limits.MinMemSizeMib) {
+			rec.MemSizeMib = int64(limits.MinMemSizeMib)
+		}
+		if rec.MemSizeMib == current {
+			rec.Action = controlapi.MemoryRecommendationKeep
+		}
+	}
+
+	return rec
+}
+
+func roundUpMemory(mib float64) int64 {
+	return int64(math.Ceil(mib/memoryRecommendationStepMib)) * memoryRecommendationStepMib
+}
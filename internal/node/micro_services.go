@@ -0,0 +1,213 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const defaultMicroServiceVersion = "0.0.0"
+
+var (
+	// the names and versions accepted by the micro protocol
+	validMicroServiceName    = regexp.MustCompile(`^[A-Za-z0-9\-_]+$`)
+	validMicroServiceVersion = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+	invalidEndpointNameChars = regexp.MustCompile(`[^A-Za-z0-9\-_]`)
+)
+
+// A function registered as a NATS service (micro). The node answers the service's PING, INFO and
+// STATS requests on the function's behalf, with the function's trigger subjects as its endpoints
+// and their executions as its stats
+type microService struct {
+	identity    micro.ServiceIdentity
+	description string
+	started     time.Time
+
+	mutex     sync.Mutex
+	endpoints []*micro.EndpointStats
+}
+
+// Validates the registration of a function as a micro service, whose trigger subjects must be
+// delivered at most once by a function which doesn't scale to zero, so that it's always
+// subscribed to them
+func validateMicroService(registration *controlapi.MicroServiceRegistration, request *controlapi.DeployRequest) error {
+	if !agentapi.SupportsTriggers(*request.WorkloadType, request.ExecPerTrigger) || len(request.TriggerSubjects) == 0 {
+		return errors.New("only functions with trigger subjects may be registered as micro services")
+	}
+	if request.IdleTimeoutMillis != nil || (request.TriggerDelivery != nil && strings.EqualFold(*request.TriggerDelivery, agentapi.TriggerDeliveryAtLeastOnce)) {
+		return errors.New("only functions with at-most-once trigger subjects that don't scale to zero may be registered as micro services")
+	}
+	if registration.Name != nil && !validMicroServiceName.MatchString(*registration.Name) {
+		return fmt.Errorf("invalid service name: %s", *registration.Name)
+	}
+	if registration.Version != nil && !validMicroServiceVersion.MatchString(*registration.Version) {
+		return fmt.Errorf("service version is not a semantic version: %s", *registration.Version)
+	}
+	return nil
+}
+
+func agentMicroService(registration *controlapi.MicroServiceRegistration, workloadName string) *agentapi.MicroService {
+	if registration == nil {
+		return nil
+	}
+
+	service := &agentapi.MicroService{Name: workloadName, Version: defaultMicroServiceVersion}
+	if registration.Name != nil {
+		service.Name = *registration.Name
+	}
+	if registration.Version != nil {
+		service.Version = *registration.Version
+	}
+	return service
+}
+
+func controlMicroService(service *agentapi.MicroService) *controlapi.MicroServiceRegistration {
+	if service == nil {
+		return nil
+	}
+	return &controlapi.MicroServiceRegistration{
+		Name:    &service.Name,
+		Version: &service.Version,
+	}
+}
+
+// Registers the function deployed to the given machine as a micro service, subscribing to the
+// protocol's discovery subjects for every service, for services of its name and for its own
+// instance, whose ID is the machine's
+func (m *MachineManager) registerMicroService(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	svc := &microService{
+		identity: micro.ServiceIdentity{
+			Name:    request.MicroService.Name,
+			ID:      vm.vmmID,
+			Version: request.MicroService.Version,
+			Metadata: map[string]string{
+				"nex.node":      m.publicKey,
+				"nex.namespace": vm.namespace,
+				"nex.workload":  *request.WorkloadName,
+			},
+		},
+		started: time.Now().UTC(),
+	}
+	if request.Description != nil {
+		svc.description = *request.Description
+	}
+	for _, tsub := range request.TriggerSubjects {
+		queueGroup := request.TriggerQueueGroup(tsub)
+		svc.endpoints = append(svc.endpoints, &micro.EndpointStats{
+			Name:       invalidEndpointNameChars.ReplaceAllString(tsub, "_"),
+			Subject:    tsub,
+			QueueGroup: queueGroup,
+		})
+	}
+	vm.microService = svc
+
+	handlers := map[micro.Verb]func() interface{}{
+		micro.PingVerb:  svc.ping,
+		micro.InfoVerb:  svc.info,
+		micro.StatsVerb: svc.stats,
+	}
+	for verb, handler := range handlers {
+		respond := handler
+		for _, subject := range microControlSubjects(verb, svc.identity) {
+			sub, err := m.nc.Subscribe(subject, func(msg *nats.Msg) {
+				raw, _ := json.Marshal(respond())
+				_ = msg.Respond(raw)
+			})
+			if err != nil {
+				return err
+			}
+			m.addMachineSubscription(vm.vmmID, sub)
+		}
+	}
+
+	m.log.Info("Registered deployed workload as micro service",
+		slog.String("vmid", vm.vmmID),
+		slog.String("service", svc.identity.Name),
+		slog.String("version", svc.identity.Version),
+	)
+	return nil
+}
+
+func microControlSubjects(verb micro.Verb, identity micro.ServiceIdentity) []string {
+	subjects := make([]string, 0, 3)
+	for _, kind := range [][2]string{{"", ""}, {identity.Name, ""}, {identity.Name, identity.ID}} {
+		subject, _ := micro.ControlSubject(verb, kind[0], kind[1])
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// Records an execution of the function triggered on the given subject
+func (s *microService) record(tsub string, elapsed time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, endpoint := range s.endpoints {
+		if endpoint.Subject != tsub {
+			continue
+		}
+
+		endpoint.NumRequests++
+		endpoint.ProcessingTime += elapsed
+		endpoint.AverageProcessingTime = endpoint.ProcessingTime / time.Duration(endpoint.NumRequests)
+		if err != nil {
+			endpoint.NumErrors++
+			endpoint.LastError = err.Error()
+		}
+		return
+	}
+}
+
+func (s *microService) ping() interface{} {
+	return micro.Ping{
+		ServiceIdentity: s.identity,
+		Type:            micro.PingResponseType,
+	}
+}
+
+func (s *microService) info() interface{} {
+	endpoints := make([]micro.EndpointInfo, 0, len(s.endpoints))
+	for _, endpoint := range s.endpoints {
+		endpoints = append(endpoints, micro.EndpointInfo{
+			Name:       endpoint.Name,
+			Subject:    endpoint.Subject,
+			QueueGroup: endpoint.QueueGroup,
+		})
+	}
+
+	return micro.Info{
+		ServiceIdentity: s.identity,
+		Type:            micro.InfoResponseType,
+		Description:     s.description,
+		Endpoints:       endpoints,
+	}
+}
+
+func (s *microService) stats() interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	endpoints := make([]*micro.EndpointStats, 0, len(s.endpoints))
+	for _, endpoint := range s.endpoints {
+		stats := *endpoint
+		endpoints = append(endpoints, &stats)
+	}
+
+	return micro.Stats{
+		ServiceIdentity: s.identity,
+		Type:            micro.StatsResponseType,
+		Started:         s.started,
+		Endpoints:       endpoints,
+	}
+}
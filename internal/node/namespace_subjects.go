@@ -0,0 +1,161 @@
+package nexnode
+
+import (
+	"fmt"
+	"sort"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	hostservices "github.com/synadia-io/nex/internal/node/services/lib"
+)
+
+// Control API operations which are scoped to a namespace, i.e., $NEX.{op}.{namespace}.{node}
+var namespacedOperations = []struct {
+	op          string
+	description string
+}{
+	{op: "INFO", description: "Request node info"},
+	{op: "DEPLOY", description: "Deploy workloads"},
+	{op: "STOP", description: "Stop workloads"},
+	{op: "STOPALL", description: "Stop workloads matching a selector"},
+	{op: "TIMELINE", description: "Request machine timelines"},
+	{op: "DESCRIBE", description: "Describe workloads"},
+	{op: "SUBJECTS", description: "Request the namespace's subjects"},
+	{op: "MEMORY", description: "Request workload memory use and recommendations"},
+	{op: "RESERVE", description: "Reserve the node exclusively for the namespace"},
+}
+
+// Generates the subjects the node uses for the given namespace, including the trigger and host
+// services subjects of each workload currently deployed to it
+func (api *ApiListener) namespaceSubjects(namespace string) *controlapi.SubjectsResponse {
+	pub := controlapi.SubjectPermissionPublish
+	sub := controlapi.SubjectPermissionSubscribe
+
+	res := &controlapi.SubjectsResponse{
+		NodeId:    api.nodeId,
+		Namespace: namespace,
+		Control: []controlapi.SubjectGrant{
+			{Subject: controlapi.APIPrefix + ".PING", Permission: pub, Description: "Discover nodes"},
+			{Subject: fmt.Sprintf("%s.PING.%s", controlapi.APIPrefix, api.nodeId), Permission: pub, Description: "Ping the node"},
+			{Subject: controlapi.APIPrefix + ".PREFLIGHT", Permission: pub, Description: "Run the preflight checks of every node"},
+			{Subject: fmt.Sprintf("%s.PREFLIGHT.%s", controlapi.APIPrefix, api.nodeId), Permission: pub, Description: "Run the node's preflight checks"},
+		},
+		Events: []controlapi.SubjectGrant{
+			{Subject: fmt.Sprintf("%s.%s.>", EventSubjectPrefix, namespace), Permission: sub, Description: "Events of the namespace's workloads"},
+			{Subject: fmt.Sprintf("%s.%s.>", EventSubjectPrefix, controlapi.SystemNamespace), Permission: sub, Description: "Node events, e.g., node started and capacity"},
+		},
+		Logs: []controlapi.SubjectGrant{
+			{Subject: fmt.Sprintf("%s.%s.>", LogSubjectPrefix, namespace), Permission: sub, Description: "Logs of the namespace's workloads"},
+		},
+	}
+
+	for _, op := range namespacedOperations {
+		res.Control = append(res.Control, controlapi.SubjectGrant{
+			Subject:     fmt.Sprintf("%s.%s.%s.%s", controlapi.APIPrefix, op.op, namespace, api.nodeId),
+			Permission:  pub,
+			Description: op.description,
+		})
+	}
+	res.Control = append(res.Control, controlapi.SubjectGrant{
+		Subject:     "_INBOX.>",
+		Permission:  sub,
+		Description: "Receive replies to control requests",
+	})
+
+	if creds := api.config.WorkloadCredentials; creds != nil && creds.RevocationSubject != nil {
+		res.Events = append(res.Events, controlapi.SubjectGrant{
+			Subject:     *creds.RevocationSubject,
+			Permission:  sub,
+			Description: "Revocation notices for workload credentials minted by the node (all namespaces)",
+		})
+	}
+
+	for _, request := range api.namespaceWorkloads(namespace) {
+		workload := *request.WorkloadName
+
+		for _, tsub := range request.TriggerSubjects {
+			res.Triggers = append(res.Triggers, controlapi.SubjectGrant{
+				Subject:     tsub,
+				Permission:  pub,
+				Description: "Trigger the function",
+				Workload:    workload,
+			})
+		}
+		if request.CompletionSubject != nil {
+			res.Triggers = append(res.Triggers, controlapi.SubjectGrant{
+				Subject:     *request.CompletionSubject,
+				Permission:  sub,
+				Description: "Results of the function's trigger executions",
+				Workload:    workload,
+			})
+		}
+		if request.MicroService != nil {
+			res.Triggers = append(res.Triggers, controlapi.SubjectGrant{
+				Subject:     fmt.Sprintf("$SRV.*.%s", request.MicroService.Name),
+				Permission:  pub,
+				Description: "Ping, describe and get the stats of the function's micro service",
+				Workload:    workload,
+			})
+		}
+
+		if request.SupportsTriggerSubjects() {
+			bucket := hostservices.KeyValueBucketName(namespace, workload)
+			res.HostServices = append(res.HostServices,
+				controlapi.SubjectGrant{
+					Subject:     fmt.Sprintf("$KV.%s.>", bucket),
+					Permission:  pub,
+					Description: "Write to the function's key/value bucket",
+					Workload:    workload,
+				},
+				controlapi.SubjectGrant{
+					Subject:     fmt.Sprintf("$KV.%s.>", bucket),
+					Permission:  sub,
+					Description: "Watch the function's key/value bucket",
+					Workload:    workload,
+				},
+				controlapi.SubjectGrant{
+					Subject:     fmt.Sprintf("$JS.API.*.*.KV_%s", bucket),
+					Permission:  pub,
+					Description: "Manage the function's key/value bucket",
+					Workload:    workload,
+				},
+				controlapi.SubjectGrant{
+					Subject:     fmt.Sprintf("$JS.API.DIRECT.GET.KV_%s.>", bucket),
+					Permission:  pub,
+					Description: "Read from the function's key/value bucket",
+					Workload:    workload,
+				},
+			)
+		}
+	}
+
+	return res
+}
+
+// Returns the deploy requests of the workloads deployed to the namespace, including functions
+// which have scaled to zero, ordered by workload name
+func (api *ApiListener) namespaceWorkloads(namespace string) []*agentapi.DeployRequest {
+	requests := make(map[string]*agentapi.DeployRequest)
+	for _, vm := range api.mgr.runningMachines() {
+		if vm.namespace == namespace && vm.deployRequest != nil && vm.deployRequest.WorkloadName != nil {
+			requests[*vm.deployRequest.WorkloadName] = vm.deployRequest
+		}
+	}
+	for _, fn := range api.mgr.idleFunctionsSnapshot() {
+		if fn.namespace == namespace && fn.request.WorkloadName != nil {
+			requests[*fn.request.WorkloadName] = fn.request
+		}
+	}
+
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*agentapi.DeployRequest, 0, len(names))
+	for _, name := range names {
+		result = append(result, requests[name])
+	}
+	return result
+}
@@ -2,12 +2,14 @@ package nexnode
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -53,11 +55,15 @@ type Node struct {
 
 	nc *nats.Conn
 
-	natsint *server.Server
-	ncint   *nats.Conn
+	natsint     *server.Server
+	ncint       *nats.Conn
+	natsintAuth *internalAuth
 
 	startedAt time.Time
 	telemetry *Telemetry
+
+	// the updated binary to restart into once the node has shut down, if any
+	update *nodeUpdate
 }
 
 func NewNode(opts *models.Options, nodeOpts *models.NodeOptions, ctx context.Context, cancelF context.CancelFunc, log *slog.Logger) (*Node, error) {
@@ -118,26 +124,39 @@ func (n *Node) Start() {
 			// TODO: check NATS subscription statuses, machine manager, telemetry etc.
 		case sig := <-n.sigs:
 			n.log.Debug("received signal: %s", sig)
-			n.shutdown()
+			n.shutdown(fmt.Sprintf("received signal: %s", sig), false)
+		case update := <-n.api.updates:
+			n.update = update
+			n.shutdown("restarting into updated binary", false)
 		case <-n.ctx.Done():
-			n.shutdown()
+			if n.manager.failure != "" {
+				n.shutdown(n.manager.failure, true)
+			} else {
+				n.shutdown("node context canceled", false)
+			}
 		default:
 			time.Sleep(runloopSleepInterval)
 		}
 	}
 
+	if n.update != nil {
+		n.restartInto(n.update)
+	}
+
 	n.log.Info("exiting node")
 	n.cancelF()
 }
 
 func (n *Node) Stop() {
 	n.log.Debug("stopping node")
-	n.shutdown()
+	n.shutdown("node stopped", false)
 }
 
 func (n *Node) createPid() error {
-	if _, err := os.Stat(defaultPidFilepath); err == nil {
-		raw, err := os.ReadFile(defaultPidFilepath)
+	pidFilepath := n.pidFilepath()
+
+	if _, err := os.Stat(pidFilepath); err == nil {
+		raw, err := os.ReadFile(pidFilepath)
 		if err != nil {
 			return err
 		}
@@ -158,25 +177,41 @@ func (n *Node) createPid() error {
 		}
 	}
 
-	f, err := os.Create(defaultPidFilepath)
+	f, err := os.Create(pidFilepath)
 	if err != nil {
 		return err
 	}
 
 	_, err = f.Write([]byte(fmt.Sprintf("%d", os.Getpid())))
 	if err != nil {
-		_ = os.Remove(defaultPidFilepath)
+		_ = os.Remove(pidFilepath)
 		return err
 	}
 
-	n.log.Debug(fmt.Sprintf("Wrote pidfile to %s", defaultPidFilepath), slog.Int("pid", os.Getpid()))
+	n.log.Debug(fmt.Sprintf("Wrote pidfile to %s", pidFilepath), slog.Int("pid", os.Getpid()))
 	return nil
 }
 
+// Nodes running without a sandbox are typically unprivileged (and possibly not on Linux),
+// so their pidfile is written to the temp dir instead
+func (n *Node) pidFilepath() string {
+	if n.config != nil && n.config.NoSandbox {
+		return filepath.Join(os.TempDir(), "nex.pid")
+	}
+
+	return defaultPidFilepath
+}
+
 func (n *Node) generateKeypair() error {
 	var err error
 
-	n.keypair, err = nkeys.CreateServer()
+	if seed := os.Getenv(nodeSeedHandoffEnv); seed != "" {
+		// the node has been updated by exec; keep the identity it was handed
+		_ = os.Unsetenv(nodeSeedHandoffEnv)
+		n.keypair, err = nkeys.FromSeed([]byte(seed))
+	} else {
+		n.keypair, err = nkeys.CreateServer()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to generate node keypair: %s", err)
 	}
@@ -242,7 +277,7 @@ func (n *Node) init() error {
 		}
 
 		// init machine manager
-		n.manager, err = NewMachineManager(n.ctx, n.cancelF, n.keypair, n.publicKey, n.nc, n.ncint, n.config, n.log, n.telemetry)
+		n.manager, err = NewMachineManager(n.ctx, n.cancelF, n.keypair, n.publicKey, n.nc, n.ncint, n.natsintAuth, n.config, n.log, n.telemetry)
 		if err != nil {
 			n.log.Error("Failed to initialize machine manager", slog.Any("err", err))
 			err = fmt.Errorf("failed to initialize machine manager: %s", err)
@@ -267,12 +302,34 @@ func (n *Node) init() error {
 func (n *Node) initInternalNATS() error {
 	var err error
 
+	n.natsintAuth, err = newInternalAuth()
+	if err != nil {
+		return err
+	}
+
+	// the listener is bound to the address through which agents reach the node, rather than to
+	// every interface, and (with sandboxes) firewalled from everything but the CNI network
+	host := n.config.internalListenHost()
+	err = checkInternalListenHost(host)
+	if err != nil {
+		return err
+	}
+	if !n.config.NoSandbox {
+		err = ensureInternalListenAddress(host)
+		if err != nil {
+			return err
+		}
+	}
+
 	n.natsint, err = server.NewServer(&server.Options{
-		Host:      "0.0.0.0",
+		Host:      host,
 		Port:      -1,
 		JetStream: true,
 		NoLog:     true,
-		StoreDir:  path.Join(os.TempDir(), defaultNatsStoreDir),
+		StoreDir:  path.Join(n.config.runDirectory(), defaultNatsStoreDir),
+
+		CustomClientAuthentication: n.natsintAuth,
+		AlwaysEnableNonce:          true,
 	})
 	if err != nil {
 		return err
@@ -280,6 +337,12 @@ func (n *Node) initInternalNATS() error {
 
 	n.natsint.Start()
 
+	err = verifyInternalListener(n.natsint)
+	if err != nil {
+		n.natsint.Shutdown()
+		return err
+	}
+
 	clientUrl, err := url.Parse(n.natsint.ClientURL())
 	if err != nil {
 		return fmt.Errorf("failed to parse internal NATS client URL: %s", err)
@@ -291,7 +354,15 @@ func (n *Node) initInternalNATS() error {
 	}
 	n.config.InternalNodePort = &p
 
-	n.ncint, err = nats.Connect(n.natsint.ClientURL())
+	if !n.config.NoSandbox {
+		err = installInternalListenerFirewall(host, p, *n.config.CNI.NetworkName)
+		if err != nil {
+			n.natsint.Shutdown()
+			return fmt.Errorf("failed to firewall internal NATS listener: %s", err)
+		}
+	}
+
+	n.ncint, err = nats.Connect(n.natsint.ClientURL(), nats.Nkey(n.natsintAuth.nodePub, n.natsintAuth.nodeKey.Sign))
 	if err != nil {
 		return fmt.Errorf("failed to connect to internal nats: %s", err)
 	}
@@ -317,9 +388,19 @@ func (n *Node) loadNodeConfig() error {
 	if n.config == nil {
 		var err error
 
-		n.config, err = LoadNodeConfiguration(n.nodeOpts.ConfigFilepath)
-		if err != nil {
-			return err
+		if _, err = os.Stat(n.nodeOpts.ConfigFilepath); errors.Is(err, os.ErrNotExist) && n.nodeOpts.DevMode {
+			// dev mode doesn't require a node configuration file
+			config := DefaultNodeConfiguration()
+			n.config = &config
+		} else {
+			n.config, err = LoadNodeConfiguration(n.nodeOpts.ConfigFilepath)
+			if err != nil {
+				return err
+			}
+		}
+
+		if n.nodeOpts.DevMode {
+			n.config.NoSandbox = true
 		}
 
 		// HACK-- copying these here... everything should ultimately be configurable via node JSON config...
@@ -346,7 +427,7 @@ func (n *Node) publishNodeStarted() error {
 	_ = cloudevent.SetData(nodeStart)
 
 	n.log.Info("Publishing node started event")
-	return PublishCloudEvent(n.nc, "system", cloudevent, n.log)
+	return PublishCloudEvent(n.nc, controlapi.SystemNamespace, cloudevent, n.log)
 }
 
 func (n *Node) publishNodeStopped() error {
@@ -364,7 +445,7 @@ func (n *Node) publishNodeStopped() error {
 	_ = cloudevent.SetData(evt)
 
 	n.log.Info("Publishing node stopped event")
-	return PublishCloudEvent(n.nc, "system", cloudevent, n.log)
+	return PublishCloudEvent(n.nc, controlapi.SystemNamespace, cloudevent, n.log)
 }
 
 func (n *Node) validateConfig() error {
@@ -375,22 +456,30 @@ func (n *Node) validateConfig() error {
 		}
 	}
 
+	if n.config.NoSandbox {
+		n.log.Warn("Node is running without a sandbox; workloads will run as local processes on this host with no isolation")
+		return nil
+	}
+
 	return CheckPrerequisites(n.config, true)
 }
 
 func (n *Node) installSignalHandlers() {
 	n.log.Debug("installing signal handlers")
 	// both firecracker and the embedded NATS server register signal handlers... wipe those so ours are the ones being used
-	signal.Reset(syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	// (SIGUSR1 and SIGUSR2 aren't defined on all platforms, so reset everything)
+	signal.Reset()
 	n.sigs = make(chan os.Signal, 1)
 	signal.Notify(n.sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 }
 
-func (n *Node) shutdown() {
+// Stops the node's machines and connections, reporting the given reason for the shutdown
+func (n *Node) shutdown(reason string, fatal bool) {
 	if atomic.AddUint32(&n.closing, 1) == 1 {
-		n.log.Debug("shutting down")
+		n.log.Debug("shutting down", slog.String("reason", reason))
 		_ = n.manager.Stop()
 		_ = n.publishNodeStopped()
+		_ = n.publishShutdownReport(reason, fatal)
 
 		_ = n.ncint.Drain()
 		for !n.ncint.IsClosed() {
@@ -402,11 +491,18 @@ func (n *Node) shutdown() {
 			time.Sleep(time.Millisecond * 25)
 		}
 
+		if n.api != nil && n.api.audit != nil {
+			n.api.audit.close()
+		}
+
 		n.natsint.Shutdown()
 		n.natsint.WaitForShutdown()
+		if !n.config.NoSandbox {
+			removeInternalListenerFirewall(n.config.internalListenHost())
+		}
 		_ = n.telemetry.Shutdown()
 
-		_ = os.Remove(defaultPidFilepath)
+		_ = os.Remove(n.pidFilepath())
 		close(n.sigs)
 	}
 }
@@ -1,11 +1,18 @@
 package nexnode
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net"
 
+	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
 )
 
 // Use this proxy object with extreme care, as it exposes
@@ -48,6 +55,23 @@ func (n *NodeProxy) Telemetry() *Telemetry {
 	return n.n.telemetry
 }
 
+type ApiListenerProxy struct {
+	api *ApiListener
+}
+
+func NewApiListenerProxyWith(api *ApiListener) *ApiListenerProxy {
+	return &ApiListenerProxy{api: api}
+}
+
+// Joins the cluster named in the node's configuration without starting the rest of the control API
+func (a *ApiListenerProxy) JoinCluster() error {
+	return a.api.joinCluster()
+}
+
+func (a *ApiListenerProxy) NodeID() string {
+	return a.api.nodeId
+}
+
 type MachineManagerProxy struct {
 	m *MachineManager
 }
@@ -72,14 +96,138 @@ func (m *MachineManagerProxy) Telemetry() *Telemetry {
 	return m.m.t
 }
 
+// Returns a snapshot of the manager's machines, keyed by ID
 func (m *MachineManagerProxy) VMs() map[string]*runningFirecracker {
-	return m.m.allVMs
+	vms := make(map[string]*runningFirecracker)
+	for _, vm := range m.m.runningMachines() {
+		vms[vm.vmmID] = vm
+	}
+	return vms
+}
+
+// Records a machine which was never started, so specs can exercise the manager's bookkeeping
+// without firecracker
+func (m *MachineManagerProxy) TrackVM(vmID string) {
+	m.m.trackMachine(&runningFirecracker{
+		vmmID:  vmID,
+		config: m.m.config,
+		log:    m.m.log,
+	})
+}
+
+// Records a machine which was never started as running the given workload, so specs can
+// exercise the handling of its agent's requests without firecracker
+func (m *MachineManagerProxy) TrackDeployedVM(vmID string, namespace string, workload string) {
+	m.m.trackMachine(&runningFirecracker{
+		vmmID:     vmID,
+		config:    m.m.config,
+		log:       m.m.log,
+		namespace: namespace,
+		deployRequest: &agentapi.DeployRequest{
+			Namespace:    &namespace,
+			WorkloadName: &workload,
+		},
+	})
+}
+
+// A stand-in for the sandbox of a machine recorded by a spec, which has nothing to stop
+type specSandbox struct{}
+
+func (specSandbox) SetMetadata(ctx context.Context, metadata interface{}) error { return nil }
+func (specSandbox) StopVMM() error                                              { return nil }
+
+// Records a machine which was never started as ready to be deployed into, so specs can exercise
+// deployments to it without firecracker (or an agent)
+func (m *MachineManagerProxy) TrackReadyVM(vmID string) {
+	vm := &runningFirecracker{
+		vmmID:   vmID,
+		config:  m.m.config,
+		log:     m.m.log,
+		machine: specSandbox{},
+	}
+	_, _ = vm.transition(machineStateReady)
+	m.m.trackMachine(vm)
+}
+
+// Submits the given deploy request to a machine recorded with TrackReadyVM
+func (m *MachineManagerProxy) SubmitDeployment(vmID string, request *agentapi.DeployRequest) error {
+	vm, ok := m.m.lookupMachine(vmID)
+	if !ok {
+		return &MachineError{MachineId: vmID, Err: ErrMachineNotFound}
+	}
+	return m.m.submitDeployment(vm, request)
+}
+
+// Forgets a machine recorded with TrackVM
+func (m *MachineManagerProxy) ForgetVM(vmID string) {
+	m.m.forgetMachine(vmID)
+}
+
+// Records a function which scales to zero, currently scaled to zero, so specs can exercise the
+// manager's bookkeeping of idle functions without firecracker
+func (m *MachineManagerProxy) TrackIdleFunction(id string, namespace string, workload string, triggerSubjects []string) {
+	workloadType := agentapi.NexExecutionProviderV8
+	m.m.trackIdleFunction(&idleFunction{
+		id:        id,
+		namespace: namespace,
+		request: &agentapi.DeployRequest{
+			DecodedClaims:   jwt.GenericClaims{ClaimsData: jwt.ClaimsData{Subject: workload}},
+			Namespace:       &namespace,
+			TriggerSubjects: triggerSubjects,
+			WorkloadName:    &workload,
+			WorkloadType:    &workloadType,
+		},
+		done: make(chan struct{}),
+	})
+}
+
+// Stops a function recorded with TrackIdleFunction for good
+func (m *MachineManagerProxy) StopIdleFunction(id string) {
+	if fn, ok := m.m.idleFunctionByID(id); ok {
+		m.m.stopIdleFunction(fn)
+	}
+}
+
+// Returns whether any of the trigger subjects is claimed by a workload other than the named one
+func (m *MachineManagerProxy) TriggerSubjectsClaimed(namespace string, workload string, subjects []string) bool {
+	return m.m.checkTriggerSubjectClaims(namespace, workload, subjects) != nil
+}
+
+// Returns the IDs of the workloads deployed to the node, including functions scaled to zero
+func (m *MachineManagerProxy) DeployedWorkloadIDs() []string {
+	return m.m.deployedMachineIDs()
+}
+
+func (m *MachineManagerProxy) HandshakeCompleted(vmID string) bool {
+	return m.m.handshakes.completed(vmID)
 }
 
 func (m *MachineManagerProxy) PoolVMs() chan *runningFirecracker {
 	return m.m.warmVMs
 }
 
+// Handles a health check result from the given machine's agent as if it arrived over internal NATS
+func (m *MachineManagerProxy) ReportHealth(vmID string, result agentapi.HealthCheckResult) {
+	raw, _ := json.Marshal(&result)
+	m.m.handleAgentHealth(&nats.Msg{Subject: fmt.Sprintf("agentint.%s.health", vmID), Data: raw})
+}
+
+// Summarizes the machines running workloads in the given namespace as INFO does
+func (m *MachineManagerProxy) SummarizeMachines(namespace string) []controlapi.MachineSummary {
+	return summarizeMachines(m.m.runningMachines(), namespace, nil)
+}
+
+// Verifies the signature of an updated binary as the node does before installing it
+func VerifyUpdateSignature(config *SelfUpdate, signature *controlapi.ArtifactSignature, binary []byte) error {
+	digest := sha256.Sum256(binary)
+	return verifyUpdateSignature(config, signature, binary, digest[:])
+}
+
+// Returns the node environment an agent running as a local process inherits
+func AgentProcessEnv() []string {
+	return agentProcessEnv()
+}
+
 type VMProxy struct {
 	vm *runningFirecracker
 }
@@ -91,3 +239,15 @@ func NewVMProxyWith(vm *runningFirecracker) *VMProxy {
 func (v *VMProxy) IP() net.IP {
 	return v.vm.ip
 }
+
+func (m *MachineManagerProxy) MintWorkloadCredentials(request *controlapi.CredentialsRequest, workloadName string, namespace string) (*agentapi.Credentials, error) {
+	return m.m.mintWorkloadCredentials(request, workloadName, namespace)
+}
+
+func (m *MachineManagerProxy) RedeemDeployToken(request *controlapi.DeployRequest, namespace string) error {
+	token, err := m.m.checkDeployToken(request, namespace)
+	if err != nil {
+		return err
+	}
+	return m.m.redeemDeployToken(token)
+}
@@ -0,0 +1,159 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Longest the node may pause machine creation for, unless the node configures otherwise
+const defaultMaxPauseSeconds = 3600
+
+func (c *NodeConfiguration) maxPause() time.Duration {
+	if c.MaxPauseSeconds > 0 {
+		return time.Duration(c.MaxPauseSeconds) * time.Second
+	}
+	return defaultMaxPauseSeconds * time.Second
+}
+
+// Returns when the pause of machine creation expires, or nil if machine creation isn't paused
+func (m *MachineManager) creationPausedUntil() *time.Time {
+	until := m.pausedUntil.Load()
+	if until == 0 || time.Now().UnixNano() >= until {
+		return nil
+	}
+
+	expiresAt := time.Unix(0, until).UTC()
+	return &expiresAt
+}
+
+// Takes a machine from the warm pool, waiting for one unless machine creation is paused, in which
+// case the pool won't be refilled until the pause expires
+func (m *MachineManager) takeWarmMachine() (*runningFirecracker, bool, error) {
+	until := m.creationPausedUntil()
+	if until == nil {
+		vm, ok := <-m.warmVMs
+		return vm, ok, nil
+	}
+
+	select {
+	case vm, ok := <-m.warmVMs:
+		return vm, ok, nil
+	default:
+		return nil, false, fmt.Errorf("%w and machine creation is paused until %s", ErrPoolExhausted, until.Format(time.RFC3339))
+	}
+}
+
+// Pauses machine creation for the requested duration, replacing any current pause, or resumes it.
+// The pause is lifted, and announced, once it expires
+func (api *ApiListener) pause(request *controlapi.PauseRequest) (*controlapi.PauseResponse, error) {
+	api.pauseMutex.Lock()
+	defer api.pauseMutex.Unlock()
+
+	res := &controlapi.PauseResponse{
+		NodeId: api.nodeId,
+	}
+
+	if request.Resume {
+		wasPaused := api.mgr.creationPausedUntil() != nil
+		api.resumeLocked()
+		if wasPaused {
+			api.log.Info("Node resumed machine creation", slog.String("reason", "control request"))
+			api.publishPauseEvent(nil)
+		}
+		res.WarmMachines = api.mgr.warmMachineCount()
+		return res, nil
+	}
+
+	duration := time.Duration(request.DurationSeconds) * time.Second
+	if duration <= 0 {
+		return nil, errors.New("pause duration must be positive")
+	}
+	if duration > api.config.maxPause() {
+		return nil, fmt.Errorf("pause duration %s exceeds the node's maximum of %s", duration, api.config.maxPause())
+	}
+
+	expiresAt := time.Now().UTC().Add(duration)
+	api.resumeLocked()
+	api.mgr.pausedUntil.Store(expiresAt.UnixNano())
+	api.pauseTimer = time.AfterFunc(duration, func() {
+		api.pauseMutex.Lock()
+		defer api.pauseMutex.Unlock()
+
+		// a later request may have replaced or lifted this pause
+		if api.mgr.pausedUntil.Load() != expiresAt.UnixNano() {
+			return
+		}
+		api.resumeLocked()
+		api.log.Info("Node resumed machine creation", slog.String("reason", "pause expired"))
+		api.publishPauseEvent(nil)
+	})
+
+	api.log.Info("Node paused machine creation", slog.Time("expires_at", expiresAt))
+	api.publishPauseEvent(&expiresAt)
+
+	res.Paused = true
+	res.ExpiresAt = &expiresAt
+	res.WarmMachines = api.mgr.warmMachineCount()
+	return res, nil
+}
+
+// Lifts the current pause, if any. Must be called with the pause mutex held
+func (api *ApiListener) resumeLocked() {
+	if api.pauseTimer != nil {
+		api.pauseTimer.Stop()
+		api.pauseTimer = nil
+	}
+	api.mgr.pausedUntil.Store(0)
+}
+
+func (api *ApiListener) handlePause(m *nats.Msg) {
+	var request controlapi.PauseRequest
+	err := schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize pause request", slog.Any("err", err))
+		respondInvalid(controlapi.PauseResponseType, m, fmt.Sprintf("Unable to deserialize pause request: %s", err), err)
+		return
+	}
+
+	res, err := api.pause(&request)
+	if err != nil {
+		api.log.Error("Failed to pause machine creation", slog.Any("err", err))
+		respondFail(controlapi.PauseResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to pause machine creation: %s", err))
+		return
+	}
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.PauseResponseType, res, nil))
+	if err != nil {
+		api.log.Error("Failed to marshal pause response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func (api *ApiListener) publishPauseEvent(expiresAt *time.Time) {
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(api.nodeId)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.NodePauseEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(controlapi.NodePauseEvent{
+		Id:        api.nodeId,
+		Paused:    expiresAt != nil,
+		ExpiresAt: expiresAt,
+	})
+
+	err := PublishCloudEvent(api.mgr.nc, controlapi.SystemNamespace, cloudevent, api.log)
+	if err != nil {
+		api.log.Warn("Failed to publish node pause event", slog.Any("err", err))
+	}
+}
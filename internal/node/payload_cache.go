@@ -3,6 +3,8 @@ package nexnode
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
 	"strings"
 
@@ -25,7 +27,7 @@ func NewPayloadCache(nc *nats.Conn, log *slog.Logger, dir string) *payloadCache
 	}
 }
 
-func (m *MachineManager) CacheWorkload(request *controlapi.DeployRequest) (uint64, *string, error) {
+func (m *MachineManager) CacheWorkload(request *controlapi.DeployRequest) (uint64, *string, *agentapi.ArtifactProvenance, error) {
 	bucket := request.Location.Host
 	key := strings.Trim(request.Location.Path, "/")
 	m.log.Info("Attempting object store download", slog.String("bucket", bucket), slog.String("key", key), slog.String("url", m.nc.Opts.Url))
@@ -37,25 +39,49 @@ func (m *MachineManager) CacheWorkload(request *controlapi.DeployRequest) (uint6
 
 	js, err := m.nc.JetStream(opts...)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
 
 	store, err := js.ObjectStore(bucket)
 	if err != nil {
 		m.log.Error("Failed to bind to source object store", slog.Any("err", err), slog.String("bucket", bucket))
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
 
 	_, err = store.GetInfo(key)
 	if err != nil {
 		m.log.Error("Failed to locate workload binary in source object store", slog.Any("err", err), slog.String("key", key), slog.String("bucket", bucket))
-		return 0, nil, err
+		return 0, nil, nil, err
+	}
+
+	// signed artifacts are verified as a whole, so they're never streamed
+	if request.StreamArtifact && request.Signature == nil {
+		return m.streamWorkload(request, store, key)
 	}
 
 	workload, err := store.GetBytes(key)
 	if err != nil {
 		m.log.Error("Failed to download bytes from source object store", slog.Any("err", err), slog.String("key", key))
-		return 0, nil, err
+		return 0, nil, nil, err
+	}
+
+	workloadHash := sha256.New()
+	workloadHash.Write(workload)
+	workloadHashString := hex.EncodeToString(workloadHash.Sum(nil))
+
+	if request.Digest != nil && !strings.EqualFold(*request.Digest, workloadHashString) {
+		m.log.Error("Workload artifact digest mismatch",
+			slog.String("key", key),
+			slog.String("expected", *request.Digest),
+			slog.String("actual", workloadHashString),
+		)
+		return 0, nil, nil, fmt.Errorf("workload artifact digest mismatch; expected %s, got %s", *request.Digest, workloadHashString)
+	}
+
+	provenance, err := m.verifyArtifact(request, workload)
+	if err != nil {
+		m.log.Error("Failed to verify workload artifact", slog.Any("err", err), slog.String("key", key))
+		return 0, nil, nil, err
 	}
 
 	jsInternal, err := m.ncInternal.JetStream()
@@ -76,10 +102,57 @@ func (m *MachineManager) CacheWorkload(request *controlapi.DeployRequest) (uint6
 		panic(err)
 	}
 
+	m.log.Info("Successfully stored workload in internal object store", slog.String("name", request.DecodedClaims.Subject), slog.Int64("bytes", int64(obj.Size)))
+	return obj.Size, &workloadHashString, provenance, nil
+}
+
+// Relays the workload artifact from the source object store into the internal cache in chunks,
+// hashing it on the way, rather than holding all of it in memory. An artifact which doesn't
+// match the expected digest is removed from the cache again
+func (m *MachineManager) streamWorkload(request *controlapi.DeployRequest, store nats.ObjectStore, key string) (uint64, *string, *agentapi.ArtifactProvenance, error) {
+	// rejects unsigned artifacts if the node requires signatures
+	provenance, err := m.verifyArtifact(request, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	source, err := store.Get(key)
+	if err != nil {
+		m.log.Error("Failed to open workload in source object store", slog.Any("err", err), slog.String("key", key))
+		return 0, nil, nil, err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	jsInternal, err := m.ncInternal.JetStream()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	cache, err := jsInternal.ObjectStore(agentapi.WorkloadCacheBucket)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
 	workloadHash := sha256.New()
-	workloadHash.Write(workload)
+	obj, err := cache.Put(&nats.ObjectMeta{Name: request.DecodedClaims.Subject}, io.TeeReader(source, workloadHash))
+	if err != nil {
+		m.log.Error("Failed to stream workload to internal cache", slog.Any("err", err))
+		return 0, nil, nil, err
+	}
+
 	workloadHashString := hex.EncodeToString(workloadHash.Sum(nil))
+	if request.Digest != nil && !strings.EqualFold(*request.Digest, workloadHashString) {
+		_ = cache.Delete(request.DecodedClaims.Subject)
+		m.log.Error("Workload artifact digest mismatch",
+			slog.String("key", key),
+			slog.String("expected", *request.Digest),
+			slog.String("actual", workloadHashString),
+		)
+		return 0, nil, nil, fmt.Errorf("workload artifact digest mismatch; expected %s, got %s", *request.Digest, workloadHashString)
+	}
 
-	m.log.Info("Successfully stored workload in internal object store", slog.String("name", request.DecodedClaims.Subject), slog.Int64("bytes", int64(obj.Size)))
-	return obj.Size, &workloadHashString, nil
+	m.log.Info("Successfully streamed workload to internal object store", slog.String("name", request.DecodedClaims.Subject), slog.Int64("bytes", int64(obj.Size)))
+	return obj.Size, &workloadHashString, provenance, nil
 }
@@ -0,0 +1,37 @@
+package nexnode
+
+import (
+	"sort"
+)
+
+// Names of the workloads deployed to the node, including idle functions, keyed by namespace
+func (m *MachineManager) deployedWorkloadNames() map[string][]string {
+	seen := make(map[string]map[string]bool)
+	add := func(namespace string, name *string) {
+		if name == nil {
+			return
+		}
+		if seen[namespace] == nil {
+			seen[namespace] = make(map[string]bool)
+		}
+		seen[namespace][*name] = true
+	}
+
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest != nil {
+			add(vm.namespace, vm.deployRequest.WorkloadName)
+		}
+	}
+	for _, fn := range m.idleFunctionsSnapshot() {
+		add(fn.namespace, fn.request.WorkloadName)
+	}
+
+	workloads := make(map[string][]string, len(seen))
+	for namespace, names := range seen {
+		for name := range names {
+			workloads[namespace] = append(workloads[namespace], name)
+		}
+		sort.Strings(workloads[namespace])
+	}
+	return workloads
+}
@@ -0,0 +1,86 @@
+package nexnode
+
+import (
+	"os"
+	"path/filepath"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Devices the host must expose to run firecracker machines and communicate with their agents
+var preflightDevices = []struct {
+	path        string
+	requirement string
+	description string
+}{
+	{path: "/dev/kvm", requirement: "KVM", description: "KVM virtualization support"},
+	{path: "/dev/vhost-vsock", requirement: "Vsock", description: "vhost vsock support"},
+}
+
+// Runs the node's preflight checks without installing anything, returning a structured report
+// rather than printing the results
+func preflightReport(nodeId string, config *NodeConfiguration) *controlapi.PreflightResponse {
+	report := &controlapi.PreflightResponse{
+		NodeId:    nodeId,
+		Passed:    true,
+		NoSandbox: config.NoSandbox,
+		Checks:    make([]controlapi.PreflightCheck, 0),
+	}
+
+	for _, r := range *nodeRequirements(config) {
+		for _, f := range r.files {
+			check := controlapi.PreflightCheck{
+				Requirement: r.descriptor,
+				Description: f.description,
+			}
+
+			for _, dir := range r.directories {
+				path := f.name
+				if dir != "" {
+					path = filepath.Join(dir, f.name)
+				}
+
+				if _, err := os.Stat(path); err == nil {
+					check.Satisfied = true
+					check.Path = path
+					break
+				}
+				check.Searched = append(check.Searched, path)
+			}
+			if check.Satisfied {
+				check.Searched = nil
+			}
+
+			report.Passed = report.Passed && check.Satisfied
+			report.Checks = append(report.Checks, check)
+		}
+	}
+
+	for _, device := range preflightDevices {
+		check := controlapi.PreflightCheck{
+			Requirement: device.requirement,
+			Description: device.description,
+		}
+
+		if _, err := os.Stat(device.path); err == nil {
+			check.Satisfied = true
+			check.Path = device.path
+		} else {
+			check.Searched = []string{device.path}
+		}
+
+		report.Passed = report.Passed && check.Satisfied
+		report.Checks = append(report.Checks, check)
+	}
+
+	listener := controlapi.PreflightCheck{
+		Requirement: "Internal NATS listener",
+		Description: "internal NATS listener bound to the CNI gateway rather than every interface",
+		Path:        config.internalListenHost(),
+	}
+	listener.Satisfied = checkInternalListenHost(listener.Path) == nil
+	report.Passed = report.Passed && listener.Satisfied
+	report.Checks = append(report.Checks, listener)
+
+	return report
+}
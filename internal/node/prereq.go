@@ -125,10 +125,9 @@ type fileSpec struct {
 	satisfied   bool
 }
 
-func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
-	var sb strings.Builder
-
-	required := &requirements{
+// The requirements a node's host must satisfy to run firecracker machines
+func nodeRequirements(config *NodeConfiguration) *requirements {
+	required := requirements{
 		{
 			directories: config.CNI.BinPath,
 			files: []*fileSpec{
@@ -159,7 +158,11 @@ func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
 			satisfied:  false,
 			initFuncs:  []initFunc{writeCniConf},
 		},
-		{
+	}
+
+	// a kernel or rootfs declared as a machine template artifact is fetched by the node itself
+	if config.MachineTemplate.Kernel == nil {
+		required = append(required, &requirement{
 			directories: []string{""},
 			files: []*fileSpec{
 				{name: config.KernelFilepath, description: "VMLinux Kernel"},
@@ -167,8 +170,11 @@ func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
 			descriptor: "VMLinux Kernel",
 			satisfied:  false,
 			initFuncs:  []initFunc{downloadKernel},
-		},
-		{
+		})
+	}
+
+	if config.MachineTemplate.RootFs == nil {
+		required = append(required, &requirement{
 			directories: []string{""},
 			files: []*fileSpec{
 				{name: config.RootFsFilepath, description: "Root Filesystem Template"},
@@ -176,9 +182,17 @@ func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
 			descriptor: "Root Filesystem Template",
 			satisfied:  false,
 			initFuncs:  []initFunc{downloadRootFS},
-		},
+		})
 	}
 
+	return &required
+}
+
+func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
+	var sb strings.Builder
+
+	required := nodeRequirements(config)
+
 	// Verify all directories are present
 	for _, r := range *required {
 		sb.WriteString(fmt.Sprintf("Validating - %s\n", magenta(r.descriptor)))
@@ -221,10 +235,23 @@ func CheckPrerequisites(config *NodeConfiguration, readonly bool) error {
 		r.satisfied = depsFound == len(r.files)
 	}
 
+	host := config.internalListenHost()
+	sb.WriteString(fmt.Sprintf("Validating - %s\n", magenta("Internal NATS listener")))
+	listenerErr := checkInternalListenHost(host)
+	if listenerErr != nil {
+		sb.WriteString(fmt.Sprintf("\t⛔ Exposed Listener - %s\n\n", red(listenerErr.Error())))
+	} else {
+		sb.WriteString(fmt.Sprintf("\t  ✅ Listener Restricted - %s [%s]\n\n", green(host), cyan("CNI gateway")))
+	}
+
 	if !readonly {
 		fmt.Print(sb.String())
 	}
 
+	if listenerErr != nil {
+		return fmt.Errorf("internal NATS listener is exposed: %s", listenerErr)
+	}
+
 	for _, r := range *required {
 		if r.satisfied {
 			continue
@@ -0,0 +1,367 @@
+package nexnode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const dssePayloadTypeInToto = "application/vnd.in-toto+json"
+
+// Extensions in which Fulcio records the OIDC issuer of a certificate's identity: the original
+// holds the issuer's URL as is, and its replacement as a DER-encoded UTF8String
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// A DSSE envelope, as produced by `cosign attest-blob`
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// The subset of an in-toto statement needed to bind an attestation to an artifact
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+}
+
+// A public key trusted to sign workload artifacts, along with a description of its signer
+type artifactSigner struct {
+	name string
+	key  crypto.PublicKey
+}
+
+func (c *ArtifactVerification) validate() []error {
+	errs := make([]error, 0)
+
+	// any certificate chaining to a public Fulcio root would otherwise be trusted
+	if len(c.FulcioRoots) > 0 && (len(c.CertificateIdentities) == 0 || c.CertificateOIDCIssuer == "") {
+		errs = append(errs, errors.New("artifact verification with fulcio roots requires certificate identities and a certificate OIDC issuer"))
+	}
+
+	return errs
+}
+
+// Verifies the signature (and attestation, if any) of a workload artifact according to the node's
+// artifact verification configuration, returning the provenance to be recorded for the workload.
+// Signatures are those produced by `cosign sign-blob`, either with a key pair or keyless, in which
+// case the signing certificate must chain to one of the configured Fulcio roots
+func (m *MachineManager) verifyArtifact(request *controlapi.DeployRequest, artifact []byte) (*agentapi.ArtifactProvenance, error) {
+	config := m.config.ArtifactVerification
+	if config == nil {
+		return nil, nil
+	}
+
+	if request.Signature == nil {
+		if config.Required {
+			return nil, errors.New("workload artifact is not signed")
+		}
+		return nil, nil
+	}
+
+	digest := sha256.Sum256(artifact)
+
+	signers, err := config.signers(request.Signature, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(request.Signature.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode artifact signature: %s", err)
+	}
+
+	var signer *artifactSigner
+	for _, s := range signers {
+		if verifySignature(s.key, artifact, digest[:], sig) {
+			signer = &s
+			break
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("workload artifact signature could not be verified by any trusted key")
+	}
+
+	provenance := &agentapi.ArtifactProvenance{
+		Verified: true,
+		Signer:   signer.name,
+		Digest:   hex.EncodeToString(digest[:]),
+	}
+
+	if request.Signature.Attestation != nil {
+		predicateType, err := verifyAttestation(*request.Signature.Attestation, signer.key, digest[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify workload artifact attestation: %s", err)
+		}
+
+		provenance.Attested = true
+		provenance.PredicateType = predicateType
+	} else if config.RequireAttestation {
+		return nil, errors.New("workload artifact has no attestation")
+	}
+
+	return provenance, nil
+}
+
+// Returns the keys which may have signed the artifact with the given digest: the certificate's key
+// for keyless signatures, or the configured trusted keys otherwise
+func (c *ArtifactVerification) signers(signature *controlapi.ArtifactSignature, digest []byte) ([]artifactSigner, error) {
+	if signature.Certificate != nil {
+		cert, err := c.verifyCertificate(signature, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		return []artifactSigner{{name: certificateIdentity(cert), key: cert.PublicKey}}, nil
+	}
+
+	signers := make([]artifactSigner, 0, len(c.TrustedKeys))
+	for _, path := range c.TrustedKeys {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted key: %s", err)
+		}
+
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("trusted key %s is not PEM-encoded", path)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted key %s: %s", path, err)
+		}
+
+		signers = append(signers, artifactSigner{name: filepath.Base(path), key: key})
+	}
+
+	return signers, nil
+}
+
+// Verifies that the (Fulcio-issued) certificate of a keyless signature chains to a configured root
+// and was issued to one of the configured identities through the configured OIDC issuer. Fulcio
+// certificates are only valid for a few minutes, so the chain is verified as of the time a trusted
+// transparency log recorded the signature if it carries a log entry, and as of now otherwise
+func (c *ArtifactVerification) verifyCertificate(signature *controlapi.ArtifactSignature, digest []byte) (*x509.Certificate, error) {
+	if len(c.FulcioRoots) == 0 {
+		return nil, errors.New("keyless signatures are not trusted by this node")
+	}
+	if len(c.CertificateIdentities) == 0 || c.CertificateOIDCIssuer == "" {
+		return nil, errors.New("keyless signatures require trusted certificate identities and a certificate OIDC issuer")
+	}
+
+	certPEM := *signature.Certificate
+
+	raw := []byte(certPEM)
+	if !strings.HasPrefix(strings.TrimSpace(certPEM), "-----BEGIN") {
+		// cosign emits the certificate as base64-encoded PEM
+		decoded, err := base64.StdEncoding.DecodeString(certPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signing certificate: %s", err)
+		}
+		raw = decoded
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("signing certificate is not PEM-encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %s", err)
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, path := range c.FulcioRoots {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fulcio root: %s", err)
+		}
+
+		for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+			ca, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse fulcio root %s: %s", path, err)
+			}
+
+			if ca.Subject.String() == ca.Issuer.String() {
+				roots.AddCert(ca)
+			} else {
+				intermediates.AddCert(ca)
+			}
+		}
+	}
+
+	verifiedAt := time.Now()
+	if signature.Bundle != nil {
+		verifiedAt, err = c.verifyTransparencyLogEntry(*signature.Bundle, signature.Signature, cert, digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifiedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing certificate is not trusted: %s", err)
+	}
+
+	if !slices.Contains(c.CertificateIdentities, certificateIdentity(cert)) {
+		return nil, fmt.Errorf("signing certificate identity %s is not trusted", certificateIdentity(cert))
+	}
+	if certificateOIDCIssuer(cert) != c.CertificateOIDCIssuer {
+		return nil, fmt.Errorf("signing certificate OIDC issuer %s is not trusted", certificateOIDCIssuer(cert))
+	}
+
+	return cert, nil
+}
+
+func certificateIdentity(cert *x509.Certificate) string {
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.String()
+}
+
+// Returns the OIDC issuer Fulcio recorded in the certificate, if any
+func certificateOIDCIssuer(cert *x509.Certificate) string {
+	var issuer string
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidFulcioIssuerV2) {
+			var value string
+			_, err := asn1.Unmarshal(ext.Value, &value)
+			if err == nil {
+				return value
+			}
+		}
+		if ext.Id.Equal(oidFulcioIssuer) {
+			issuer = string(ext.Value)
+		}
+	}
+	return issuer
+}
+
+func verifySignature(key crypto.PublicKey, message []byte, digest []byte, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	default:
+		return false
+	}
+}
+
+// Verifies a DSSE-enveloped in-toto attestation was signed by the given key and that its subject
+// is the artifact with the given digest, returning the attestation's predicate type
+func verifyAttestation(attestation string, key crypto.PublicKey, digest []byte) (string, error) {
+	var envelope dsseEnvelope
+	err := json.Unmarshal([]byte(attestation), &envelope)
+	if err != nil {
+		return "", err
+	}
+
+	if envelope.PayloadType != dssePayloadTypeInToto {
+		return "", fmt.Errorf("unsupported attestation payload type: %s", envelope.PayloadType)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return "", err
+	}
+
+	// DSSE pre-authentication encoding
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(envelope.PayloadType), envelope.PayloadType, len(payload), payload))
+	paeDigest := sha256.Sum256(pae)
+
+	verified := false
+	for _, s := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err == nil && verifySignature(key, pae, paeDigest[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", errors.New("attestation signature could not be verified")
+	}
+
+	var statement inTotoStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return "", err
+	}
+
+	expected := hex.EncodeToString(digest)
+	for _, subject := range statement.Subject {
+		if strings.EqualFold(subject.Digest["sha256"], expected) {
+			return statement.PredicateType, nil
+		}
+	}
+
+	return "", errors.New("attestation subject does not match workload artifact")
+}
+
+func agentArtifactSignature(signature *controlapi.ArtifactSignature) *agentapi.ArtifactSignature {
+	if signature == nil {
+		return nil
+	}
+
+	return &agentapi.ArtifactSignature{
+		Signature:   signature.Signature,
+		Certificate: signature.Certificate,
+		Attestation: signature.Attestation,
+		Bundle:      signature.Bundle,
+	}
+}
+
+func controlArtifactSignature(signature *agentapi.ArtifactSignature) *controlapi.ArtifactSignature {
+	if signature == nil {
+		return nil
+	}
+
+	return &controlapi.ArtifactSignature{
+		Signature:   signature.Signature,
+		Certificate: signature.Certificate,
+		Attestation: signature.Attestation,
+		Bundle:      signature.Bundle,
+	}
+}
@@ -0,0 +1,139 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Returns the resource quota in effect for the given namespace, or nil if the namespace is
+// unconstrained. Quotas stored in the configured quota bucket (keyed by namespace) take
+// precedence over those in the node configuration
+func (m *MachineManager) namespaceQuota(namespace string) *controlapi.NamespaceQuota {
+	if m.config.QuotaBucket != nil {
+		quota, err := m.lookupNamespaceQuota(*m.config.QuotaBucket, namespace)
+		if err != nil {
+			m.log.Warn("Failed to look up namespace quota; falling back to node configuration",
+				slog.String("namespace", namespace),
+				slog.Any("err", err),
+			)
+		} else if quota != nil {
+			return quota
+		}
+	}
+
+	if quota, ok := m.config.NamespaceQuotas[namespace]; ok {
+		return &quota
+	}
+
+	return nil
+}
+
+func (m *MachineManager) lookupNamespaceQuota(bucket, namespace string) (*controlapi.NamespaceQuota, error) {
+	js, err := m.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := kv.Get(namespace)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var quota controlapi.NamespaceQuota
+	err = json.Unmarshal(entry.Value(), &quota)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quota, nil
+}
+
+// Returns the resources currently consumed by the workloads deployed in the given namespace
+func (m *MachineManager) namespaceUsage(namespace string) controlapi.QuotaUsage {
+	var usage controlapi.QuotaUsage
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest == nil || vm.namespace != namespace {
+			continue
+		}
+
+		usage.Workloads++
+		usage.VCPU += vm.vcpuCount
+		usage.MemoryMib += vm.memSizeMib
+		usage.DeployedBytes += vm.deployRequest.TotalBytes
+	}
+
+	return usage
+}
+
+// Determines whether deploying a workload of the given size into the given namespace would exceed
+// the namespace's quota, returning a description of the exceeded limit if so
+func (m *MachineManager) checkNamespaceQuota(namespace string, template string, size *machineSize, totalBytes int64) *controlapi.QuotaExceededResponse {
+	quota := m.namespaceQuota(namespace)
+	if quota == nil {
+		return nil
+	}
+
+	machine := m.templates[template].MachineTemplate
+	if size == nil {
+		size = &machineSize{vcpuCount: *machine.VcpuCount, memSizeMib: *machine.MemSizeMib}
+	}
+
+	usage := m.namespaceUsage(namespace)
+	requested := controlapi.QuotaUsage{
+		Workloads:     usage.Workloads + 1,
+		VCPU:          usage.VCPU + int64(size.vcpuCount),
+		MemoryMib:     usage.MemoryMib + int64(size.memSizeMib),
+		DeployedBytes: usage.DeployedBytes + totalBytes,
+	}
+
+	exceeded := func(resource string, limit, requested int64) *controlapi.QuotaExceededResponse {
+		if limit <= 0 || requested <= limit {
+			return nil
+		}
+
+		return &controlapi.QuotaExceededResponse{
+			Namespace: namespace,
+			Resource:  resource,
+			Limit:     limit,
+			Requested: requested,
+		}
+	}
+
+	if resp := exceeded(controlapi.QuotaResourceWorkloads, int64(quota.MaxWorkloads), int64(requested.Workloads)); resp != nil {
+		return resp
+	}
+	if resp := exceeded(controlapi.QuotaResourceVCPU, quota.MaxVCPU, requested.VCPU); resp != nil {
+		return resp
+	}
+	if resp := exceeded(controlapi.QuotaResourceMemory, quota.MaxMemoryMib, requested.MemoryMib); resp != nil {
+		return resp
+	}
+
+	return exceeded(controlapi.QuotaResourceDeployedBytes, quota.MaxDeployedBytes, requested.DeployedBytes)
+}
+
+// Summarizes the quota and current usage of the given namespace for INFO, or returns nil
+// if the namespace is unconstrained
+func (m *MachineManager) namespaceQuotaStatus(namespace string) *controlapi.QuotaStatus {
+	quota := m.namespaceQuota(namespace)
+	if quota == nil {
+		return nil
+	}
+
+	return &controlapi.QuotaStatus{
+		Limits: *quota,
+		Usage:  m.namespaceUsage(namespace),
+	}
+}
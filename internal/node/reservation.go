@@ -0,0 +1,169 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Longest a namespace may reserve the node for, unless the node configures otherwise
+const defaultMaxReservationSeconds = 3600
+
+func (c *NodeConfiguration) maxReservation() time.Duration {
+	if c.MaxReservationSeconds > 0 {
+		return time.Duration(c.MaxReservationSeconds) * time.Second
+	}
+	return defaultMaxReservationSeconds * time.Second
+}
+
+// Returns the node's reservation if one is in effect, discarding it once it has expired
+func (api *ApiListener) activeReservation() *controlapi.NodeReservation {
+	api.reservationMutex.Lock()
+	defer api.reservationMutex.Unlock()
+
+	return api.currentReservation()
+}
+
+// Must be called with the reservation mutex held
+func (api *ApiListener) currentReservation() *controlapi.NodeReservation {
+	if api.reservation != nil && !time.Now().UTC().Before(api.reservation.ExpiresAt) {
+		api.log.Info("Node reservation expired", slog.String("namespace", api.reservation.Namespace))
+		api.reservation = nil
+	}
+	if api.reservation == nil {
+		return nil
+	}
+
+	reservation := *api.reservation
+	return &reservation
+}
+
+// Returns the reason for rejecting a deploy request from the given namespace if the node is
+// reserved for another namespace, or nil if it isn't
+func (api *ApiListener) reservedForOther(namespace string) *controlapi.NodeReservedResponse {
+	reservation := api.activeReservation()
+	if reservation == nil || reservation.Namespace == namespace {
+		return nil
+	}
+
+	return &controlapi.NodeReservedResponse{
+		NodeId:          api.nodeId,
+		NodeReservation: *reservation,
+	}
+}
+
+// Reserves the node for the given namespace, extends the namespace's reservation or releases it.
+// A reservation held by another namespace is returned as an error
+func (api *ApiListener) reserve(namespace string, request *controlapi.ReserveRequest) (*controlapi.ReserveResponse, error) {
+	api.reservationMutex.Lock()
+	defer api.reservationMutex.Unlock()
+
+	current := api.currentReservation()
+	if current != nil && current.Namespace != namespace {
+		return nil, &controlapi.NodeReservedResponse{
+			NodeId:          api.nodeId,
+			NodeReservation: *current,
+		}
+	}
+
+	res := &controlapi.ReserveResponse{
+		NodeId:    api.nodeId,
+		Namespace: namespace,
+	}
+
+	if request.Release {
+		api.reservation = nil
+		return res, nil
+	}
+
+	duration := time.Duration(request.DurationSeconds) * time.Second
+	if duration <= 0 {
+		return nil, fmt.Errorf("reservation duration must be positive")
+	}
+	if duration > api.config.maxReservation() {
+		return nil, fmt.Errorf("reservation duration %s exceeds the node's maximum of %s", duration, api.config.maxReservation())
+	}
+
+	expiresAt := time.Now().UTC().Add(duration)
+	api.reservation = &controlapi.NodeReservation{
+		Namespace: namespace,
+		ExpiresAt: expiresAt,
+	}
+
+	res.Reserved = true
+	res.ExpiresAt = &expiresAt
+	return res, nil
+}
+
+func (api *ApiListener) handleReserve(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Failed to extract namespace for reserve request", slog.Any("err", err))
+		respondFail(controlapi.ReserveResponseType, m, controlapi.ErrorCodeInvalidRequest, "Failed to extract namespace for reserve request")
+		return
+	}
+
+	var request controlapi.ReserveRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize reserve request", slog.Any("err", err))
+		respondInvalid(controlapi.ReserveResponseType, m, fmt.Sprintf("Unable to deserialize reserve request: %s", err), err)
+		return
+	}
+
+	res, err := api.reserve(namespace, &request)
+	if reserved, ok := err.(*controlapi.NodeReservedResponse); ok {
+		reason := reserved.Error()
+		env := controlapi.NewEnvelope(controlapi.NodeReservedResponseType, reserved, &reason)
+		env.Code = controlapi.ErrorCodeNodeReserved
+		raw, _ := json.Marshal(env)
+		_ = m.Respond(raw)
+		return
+	}
+	if err != nil {
+		api.log.Error("Failed to reserve node", slog.String("namespace", namespace), slog.Any("err", err))
+		respondFail(controlapi.ReserveResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to reserve node: %s", err))
+		return
+	}
+
+	api.log.Info("Node reservation changed",
+		slog.String("namespace", namespace),
+		slog.Bool("reserved", res.Reserved),
+		slog.Any("expires_at", res.ExpiresAt),
+	)
+	api.publishReservationEvent(res)
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.ReserveResponseType, res, nil))
+	if err != nil {
+		api.log.Error("Failed to marshal reserve response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func (api *ApiListener) publishReservationEvent(res *controlapi.ReserveResponse) {
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(api.nodeId)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.NodeReservationEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(controlapi.NodeReservationEvent{
+		Id:        api.nodeId,
+		Namespace: res.Namespace,
+		Reserved:  res.Reserved,
+		ExpiresAt: res.ExpiresAt,
+	})
+
+	err := PublishCloudEvent(api.mgr.nc, controlapi.SystemNamespace, cloudevent, api.log)
+	if err != nil {
+		api.log.Warn("Failed to publish node reservation event", slog.Any("err", err))
+	}
+}
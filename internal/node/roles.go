@@ -0,0 +1,275 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+var (
+	roleRanks = map[string]int{
+		controlapi.RoleViewer:   1,
+		controlapi.RoleDeployer: 2,
+		controlapi.RoleOperator: 3,
+	}
+
+	// The role required by each control operation, the second token of its subject. Operations
+	// not listed here require the operator role
+	operationRoles = map[string]string{
+		"INFO":           controlapi.RoleViewer,
+		"TIMELINE":       controlapi.RoleViewer,
+		"DESCRIBE":       controlapi.RoleViewer,
+		"SUBJECTS":       controlapi.RoleViewer,
+		"MEMORY":         controlapi.RoleViewer,
+		"DEPLOY":         controlapi.RoleDeployer,
+		"STOP":           controlapi.RoleDeployer,
+		"STOPALL":        controlapi.RoleDeployer,
+		"UPDATEWORKLOAD": controlapi.RoleDeployer,
+		"PROMOTE":        controlapi.RoleDeployer,
+		"CLUSTERDEPLOY":  controlapi.RoleDeployer,
+		"DEPLOYSET":      controlapi.RoleDeployer,
+	}
+
+	// Identities are part of role bucket keys
+	validRoleIdentity = regexp.MustCompile(`^[-_=a-zA-Z0-9]+$`)
+)
+
+func validateRoles(roles map[string]map[string]string) []error {
+	errs := make([]error, 0)
+	for namespace, assignments := range roles {
+		if namespace == "" || strings.ContainsAny(namespace, ".> ") {
+			errs = append(errs, fmt.Errorf("roles are assigned in an invalid namespace: %q", namespace))
+		}
+		for identity, role := range assignments {
+			if _, ok := roleRanks[role]; !ok {
+				errs = append(errs, fmt.Errorf("%s is assigned an unknown role in namespace %s: %q", identity, namespace, role))
+			}
+		}
+	}
+	return errs
+}
+
+func (c *ControlAuth) rolesEnabled() bool {
+	return len(c.Roles) > 0 || c.RoleBucket != ""
+}
+
+// Returns the role required to perform the control operation requested on the given subject
+func requiredRole(subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 2 {
+		return controlapi.RoleOperator
+	}
+
+	// $NEX.DEPLOYSET.{namespace}.{cluster}.{op}
+	if tokens[1] == "DEPLOYSET" && len(tokens) > 4 && tokens[4] == controlapi.DeploySetOpStatus {
+		return controlapi.RoleViewer
+	}
+	if role, ok := operationRoles[tokens[1]]; ok {
+		return role
+	}
+	return controlapi.RoleOperator
+}
+
+// The identities by which a requester may be assigned roles
+func roleIdentities(info *server.ClientInfo) []string {
+	identities := make([]string, 0, 3)
+	for _, identity := range []string{info.User, info.Account, info.NameTag} {
+		if identity != "" {
+			identities = append(identities, identity)
+		}
+	}
+	return identities
+}
+
+// Returns the highest role of the requester in the given namespace, assigned either in the node
+// configuration or in the role bucket, or an empty string if it has none
+func (api *ApiListener) requesterRole(info *server.ClientInfo, namespace string) string {
+	auth := api.config.ControlAuth
+
+	var role string
+	grant := func(candidate string) {
+		if roleRanks[candidate] > roleRanks[role] {
+			role = candidate
+		}
+	}
+
+	for _, identity := range roleIdentities(info) {
+		grant(auth.Roles[namespace][identity])
+		grant(auth.Roles[anyNamespace][identity])
+
+		if auth.RoleBucket == "" || namespace == anyNamespace {
+			continue
+		}
+		assigned, err := api.lookupRole(namespace, identity)
+		if err != nil {
+			api.log.Warn("Failed to look up assigned role",
+				slog.String("namespace", namespace),
+				slog.String("identity", identity),
+				slog.Any("err", err),
+			)
+			continue
+		}
+		grant(assigned)
+	}
+
+	return role
+}
+
+func (api *ApiListener) roleBucket() (nats.KeyValue, error) {
+	js, err := api.mgr.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	return js.KeyValue(api.config.ControlAuth.RoleBucket)
+}
+
+func (api *ApiListener) lookupRole(namespace string, identity string) (string, error) {
+	if !validRoleIdentity.MatchString(identity) {
+		return "", nil
+	}
+
+	kv, err := api.roleBucket()
+	if err != nil {
+		if errors.Is(err, nats.ErrBucketNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	entry, err := kv.Get(roleKey(namespace, identity))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(entry.Value()), nil
+}
+
+func roleKey(namespace string, identity string) string {
+	return fmt.Sprintf("%s.%s", namespace, identity)
+}
+
+// Lists, assigns or revokes the roles of requesters in a namespace. Roles are assigned in the
+// role bucket, which every node using it shares
+func (api *ApiListener) handleRoles(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Invalid subject for role request", slog.Any("err", err))
+		respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for role request")
+		return
+	}
+
+	var request controlapi.RoleRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize role request", slog.Any("err", err))
+		respondInvalid(controlapi.RoleResponseType, m, fmt.Sprintf("Unable to deserialize role request: %s", err), err)
+		return
+	}
+
+	auth := api.config.ControlAuth
+	if auth == nil || auth.RoleBucket == "" {
+		if request.Operation != controlapi.RoleOpList {
+			respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Node has no role bucket in which to assign roles")
+			return
+		}
+	}
+
+	var kv nats.KeyValue
+	if auth != nil && auth.RoleBucket != "" {
+		kv, err = api.roleBucket()
+		if errors.Is(err, nats.ErrBucketNotFound) && request.Operation == controlapi.RoleOpAssign {
+			var js nats.JetStreamContext
+			js, err = api.mgr.nc.JetStream()
+			if err == nil {
+				kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: auth.RoleBucket})
+			}
+		}
+		if err != nil && !errors.Is(err, nats.ErrBucketNotFound) {
+			respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to open role bucket: %s", err))
+			return
+		}
+	}
+
+	switch request.Operation {
+	case controlapi.RoleOpList:
+	case controlapi.RoleOpAssign, controlapi.RoleOpRevoke:
+		if !validRoleIdentity.MatchString(request.Identity) {
+			respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid identity: %q", request.Identity))
+			return
+		}
+
+		if request.Operation == controlapi.RoleOpAssign {
+			if _, ok := roleRanks[request.Role]; !ok {
+				respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Unknown role: %q", request.Role))
+				return
+			}
+			_, err = kv.Put(roleKey(namespace, request.Identity), []byte(request.Role))
+		} else if kv != nil {
+			err = kv.Delete(roleKey(namespace, request.Identity))
+		}
+		if err != nil {
+			respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to %s role: %s", request.Operation, err))
+			return
+		}
+
+		api.log.Info("Changed role assignment",
+			slog.String("namespace", namespace),
+			slog.String("operation", request.Operation),
+			slog.String("identity", request.Identity),
+			slog.String("role", request.Role),
+		)
+	default:
+		respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Unknown role operation: %s", request.Operation))
+		return
+	}
+
+	res := controlapi.RoleResponse{
+		Namespace:   namespace,
+		Assignments: make(map[string]string),
+	}
+	if auth != nil {
+		for _, ns := range []string{anyNamespace, namespace} {
+			for identity, role := range auth.Roles[ns] {
+				if res.Configured == nil {
+					res.Configured = make(map[string]string)
+				}
+				res.Configured[identity] = role
+			}
+		}
+	}
+	if kv != nil {
+		keys, err := kv.Keys()
+		if err != nil && !errors.Is(err, nats.ErrNoKeysFound) {
+			respondFail(controlapi.RoleResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to list roles: %s", err))
+			return
+		}
+		for _, key := range keys {
+			identity, ok := strings.CutPrefix(key, namespace+".")
+			if !ok {
+				continue
+			}
+			entry, err := kv.Get(key)
+			if err != nil {
+				continue
+			}
+			res.Assignments[identity] = string(entry.Value())
+		}
+	}
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.RoleResponseType, res, nil))
+	if err != nil {
+		api.log.Error("Failed to marshal role response", slog.Any("err", err))
+		return
+	}
+	_ = m.Respond(raw)
+}
@@ -0,0 +1,142 @@
+package nexnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/rs/xid"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const nexAgentBinary = "nex-agent"
+
+// The internal NATS host as seen by an agent running as a local process
+const noSandboxInternalNodeHost = "127.0.0.1"
+
+// Variables of the node's environment passed on to agents running as local processes; the rest,
+// which may hold the node's own secrets, are withheld
+var agentProcessEnvAllowlist = []string{"PATH", "HOME"}
+
+// A nex agent running as a local OS process; this is used in place of a
+// firecracker VM when the node is configured without a sandbox (dev mode)
+type agentProcess struct {
+	cmd *exec.Cmd
+}
+
+// Agents running as local processes receive their metadata via environment
+// variables when they are spawned, so there is nothing to set after the fact
+func (p *agentProcess) SetMetadata(ctx context.Context, metadata interface{}) error {
+	return errors.New("metadata cannot be set on a running agent process")
+}
+
+func (p *agentProcess) StopVMM() error {
+	err := p.cmd.Process.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	_ = p.cmd.Wait()
+	return nil
+}
+
+// Spawn a nex agent as a local process with no firecracker VM and no CNI. This is intended
+// for development and testing of v8 and wasm function workloads only; there is no isolation
+// between the agent, its workload and the host!
+func createAndStartProcess(ctx context.Context, config *NodeConfiguration, auth *internalAuth, log *slog.Logger) (*runningFirecracker, error) {
+	vmmID := xid.New().String()
+
+	agentBinary, err := findAgentBinary(config.BinPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := auth.issue(vmmID)
+	if err != nil {
+		return nil, err
+	}
+
+	vmmCtx, vmmCancel := context.WithCancel(ctx)
+
+	cmd := exec.CommandContext(vmmCtx, agentBinary)
+	cmd.Env = append(agentProcessEnv(),
+		fmt.Sprintf("%s=%s", agentapi.NexAgentEnvNodeNatsHost, noSandboxInternalNodeHost),
+		fmt.Sprintf("%s=%d", agentapi.NexAgentEnvNodeNatsPort, *config.InternalNodePort),
+		fmt.Sprintf("%s=%s", agentapi.NexAgentEnvVmID, vmmID),
+		fmt.Sprintf("%s=%s", agentapi.NexAgentEnvNodeNatsSeed, seed),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	boot := machineBoot{started: time.Now().UTC()}
+	err = cmd.Start()
+	boot.instanceStarted = time.Now().UTC()
+	if err != nil {
+		vmmCancel()
+		auth.revoke(vmmID)
+		return nil, fmt.Errorf("failed to start agent process: %s", err)
+	}
+
+	log.Info("Agent process started",
+		slog.String("vmid", vmmID),
+		slog.Int("pid", cmd.Process.Pid),
+		slog.String("agent_binary", agentBinary),
+		slog.String("nats_host", noSandboxInternalNodeHost),
+		slog.Int("nats_port", *config.InternalNodePort),
+	)
+
+	return &runningFirecracker{
+		boot:           boot,
+		config:         config,
+		ip:             net.ParseIP(noSandboxInternalNodeHost),
+		log:            log,
+		machine:        &agentProcess{cmd: cmd},
+		machineStarted: time.Now().UTC(),
+		memSizeMib:     int64(*config.MachineTemplate.MemSizeMib),
+		vcpuCount:      int64(*config.MachineTemplate.VcpuCount),
+		vmmCancel:      vmmCancel,
+		vmmCtx:         vmmCtx,
+		vmmID:          vmmID,
+	}, nil
+}
+
+// Locate the nex agent binary, checking the configured bin path first and then the rest of the PATH
+// Returns the allowed variables of the node's environment, to which an agent process's own are added
+func agentProcessEnv() []string {
+	env := make([]string, 0, len(agentProcessEnvAllowlist))
+	for _, name := range agentProcessEnvAllowlist {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return env
+}
+
+func findAgentBinary(binPath []string) (string, error) {
+	name := nexAgentBinary
+	if runtime.GOOS == "windows" {
+		name = name + ".exe"
+	}
+
+	for _, dir := range binPath {
+		candidate := filepath.Join(dir, name)
+		if finfo, err := os.Stat(candidate); err == nil && !finfo.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	agentBinary, err := exec.LookPath(nexAgentBinary)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate %s binary: %s", nexAgentBinary, err)
+	}
+
+	return agentBinary, nil
+}
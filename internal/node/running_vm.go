@@ -8,37 +8,79 @@ import (
 	"log/slog"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/firecracker-microvm/firecracker-go-sdk"
-	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
-	"github.com/rs/xid"
-
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 )
 
+// The sandbox in which a nex agent is running. This is a firecracker VM unless the
+// node is running in (unsandboxed) development mode, in which case it's a local process
+type sandbox interface {
+	SetMetadata(ctx context.Context, metadata interface{}) error
+	StopVMM() error
+}
+
 // Represents an instance of a single firecracker VM containing the nex agent.
 type runningFirecracker struct {
 	vmmCtx    context.Context
 	vmmCancel context.CancelFunc
 	vmmID     string
 
-	closing         uint32
-	config          *NodeConfiguration
-	deployRequest   *agentapi.DeployRequest
-	ip              net.IP
-	log             *slog.Logger
-	machine         *firecracker.Machine
-	machineStarted  time.Time
-	namespace       string
+	// how long each phase of starting the machine took
+	boot machineBoot
+
+	// the machine's lifecycle state; see machineState
+	machineState uint32
+	evicted      uint32
+	// set once the workload is deployed and, if it declared a readiness probe, that probe passed
+	ready uint32
+	// number of trigger executions that have failed in a row; reset on success
+	consecutiveTriggerFailures uint32
+
+	// path of the machine's cgroup, when the node places firecracker processes in cgroups
+	cgroup string
+	// the cgroup's CPU use as of when it was last attributed to the workload
+	cpuAccounted  cgroupUsage
+	config        *NodeConfiguration
+	cronStop      chan struct{}
+	cronTriggers  []*cronTrigger
+	deployRequest *agentapi.DeployRequest
+	// the fully qualified DNS name registered for the workload, if it has one
+	dnsName string
+	// the iptables chain enforcing the workload's egress policy, if it has one
+	egressChain string
+	function    *idleFunction
+	ip          net.IP
+	// the most recent result of the workload's health check, stored as results arrive from the agent
+	// while INFO, describe and update requests read it
+	lastHealthCheck atomic.Pointer[agentapi.HealthCheckResult]
+	// the most recent report of the machine's memory use, and the processes its kernel has
+	// killed for running out of memory as of the agent's last workload_oom event
+	lastMemoryReport *agentapi.MemoryReport
+	oomKills         uint64
+	triggerLimiter   *triggerLimiter
+	log              *slog.Logger
+	machine          sandbox
+	machineStarted   time.Time
+	memSizeMib       int64
+	// the micro service the workload is registered as, if it is
+	microService *microService
+	namespace    string
+	template     string
+	vcpuCount    int64
+	// the workload's persistent volume, attached as the machine's second drive, if it has one
+	volume          *workloadVolume
 	workloadStarted time.Time
 }
 
+func (vm *runningFirecracker) isReady() bool {
+	return atomic.LoadUint32(&vm.ready) == 1
+}
+
 func (vm *runningFirecracker) isEssential() bool {
 	return vm.deployRequest != nil && vm.deployRequest.Essential != nil && *vm.deployRequest.Essential
 }
@@ -53,8 +95,12 @@ func (vm *runningFirecracker) setMetadata(metadata *agentapi.MachineMetadata) er
 	return nil
 }
 
-func (vm *runningFirecracker) shutdown() {
-	if atomic.AddUint32(&vm.closing, 1) == 1 {
+// Stops the machine and cleans up after it, returning descriptions of the resources it failed
+// to clean up
+func (vm *runningFirecracker) shutdown() []string {
+	orphaned := make([]string, 0)
+
+	if _, err := vm.transition(machineStateStopped); err == nil {
 		vm.log.Info("Machine stopping",
 			slog.String("vmid", vm.vmmID),
 			slog.String("ip", vm.ip.String()),
@@ -63,180 +109,51 @@ func (vm *runningFirecracker) shutdown() {
 		err := vm.machine.StopVMM()
 		if err != nil {
 			vm.log.Error("Failed to stop firecracker VM", slog.Any("err", err))
+			orphaned = append(orphaned, fmt.Sprintf("firecracker process of machine %s", vm.vmmID))
 		}
 
-		err = os.Remove(getSocketPath(vm.vmmID))
+		err = os.Remove(getSocketPath(vm.config.runDirectory(), vm.vmmID))
 		if err != nil {
 			if !errors.Is(err, fs.ErrNotExist) {
 				vm.log.Error("Failed to remove VM socket", slog.Any("err", err))
+				orphaned = append(orphaned, getSocketPath(vm.config.runDirectory(), vm.vmmID))
 			}
 		}
 
-		err = os.Remove(getLogPath(vm.vmmID))
+		err = os.Remove(getLogPath(vm.config.runDirectory(), vm.vmmID))
 		if err != nil {
 			if !errors.Is(err, fs.ErrNotExist) {
 				vm.log.Error("Failed to remove VM log", slog.Any("err", err))
+				orphaned = append(orphaned, getLogPath(vm.config.runDirectory(), vm.vmmID))
 			}
 		}
 
-		rootFsPath := getRootFsPath(vm.vmmID)
+		rootFsPath := getRootFsPath(vm.config.runDirectory(), vm.vmmID)
 		err = os.Remove(rootFsPath)
 		if err != nil {
 			if !errors.Is(err, fs.ErrNotExist) {
 				vm.log.Warn("Failed to delete VM rootfs", slog.Any("err", err))
+				orphaned = append(orphaned, rootFsPath)
 			}
 		}
-	}
-}
-
-// Create a VMM with a given set of options and start the VM
-func createAndStartVM(ctx context.Context, config *NodeConfiguration, log *slog.Logger) (*runningFirecracker, error) {
-	vmmID := xid.New().String()
-
-	fcCfg, err := generateFirecrackerConfig(vmmID, config)
-	if err != nil {
-		log.Error("Failed to generate firecracker configuration", slog.Any("config", config))
-		return nil, err
-	}
-
-	err = copy(config.RootFsFilepath, *fcCfg.Drives[0].PathOnHost)
-
-	if err != nil {
-		log.Error("Failed to copy rootfs to temp location", slog.Any("err", err))
-		return nil, err
-	}
-
-	// TODO: can we please not use logrus here amazon?
-	machineOpts := []firecracker.Opt{
-		firecracker.WithLogger(log.With(slog.Bool("firecracker", true), slog.String("vmmid", vmmID))),
-	}
-
-	firecrackerBinary, err := exec.LookPath("firecracker")
-	if err != nil {
-		return nil, err
-	}
-
-	finfo, err := os.Stat(firecrackerBinary)
-	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("binary %q does not exist: %v", firecrackerBinary, err)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat binary, %q: %v", firecrackerBinary, err)
-	}
 
-	if finfo.IsDir() {
-		return nil, fmt.Errorf("binary, %q, is a directory", firecrackerBinary)
-	} else if finfo.Mode()&0111 == 0 {
-		return nil, fmt.Errorf("binary, %q, is not executable. Check permissions of binary", firecrackerBinary)
-	}
-
-	if fcCfg.JailerCfg == nil {
-		cmd := firecracker.VMCommandBuilder{}.
-			WithBin(firecrackerBinary).
-			WithSocketPath(fcCfg.SocketPath).
-			WithStderr(os.Stderr).
-			Build(ctx)
-
-		machineOpts = append(machineOpts, firecracker.WithProcessRunner(cmd))
-	}
-
-	vmmCtx, vmmCancel := context.WithCancel(ctx)
-
-	m, err := firecracker.NewMachine(vmmCtx, fcCfg, machineOpts...)
-	if err != nil {
-		vmmCancel()
-		return nil, fmt.Errorf("failed creating machine: %s", err)
-	}
-
-	if err := m.Start(vmmCtx); err != nil {
-		vmmCancel()
-		return nil, fmt.Errorf("failed to start machine: %v", err)
-	}
-
-	gw := m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.Gateway
-	ip := m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IPAddr.IP
-	hosttap := m.Cfg.NetworkInterfaces[0].StaticConfiguration.HostDevName
-	mask := m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IPAddr.Mask
-
-	log.Info("Machine started",
-		slog.String("vmid", vmmID),
-		slog.Any("ip", ip),
-		slog.Any("gateway", gw),
-		slog.String("netmask", mask.String()),
-		slog.String("hosttap", hosttap),
-		slog.String("nats_host", *config.InternalNodeHost),
-		slog.Int("nats_port", *config.InternalNodePort),
-	)
-
-	return &runningFirecracker{
-		config:         config,
-		ip:             ip,
-		log:            log,
-		machine:        m,
-		machineStarted: time.Now().UTC(),
-		vmmCancel:      vmmCancel,
-		vmmCtx:         vmmCtx,
-		vmmID:          vmmID,
-	}, nil
-}
+		if vm.egressChain != "" {
+			removeEgressChain(vm.egressChain, vm.ip.String())
+		}
 
-func copy(src string, dst string) error {
-	data, err := os.ReadFile(src)
-	if err != nil {
-		return err
+		if vm.cgroup != "" {
+			err = removeMachineCgroup(vm.cgroup)
+			if err != nil {
+				vm.log.Warn("Failed to remove VM cgroup", slog.String("cgroup", vm.cgroup), slog.Any("err", err))
+				orphaned = append(orphaned, vm.cgroup)
+			}
+		}
 	}
-	err = os.WriteFile(dst, data, 0644)
-	return err
-}
-
-func generateFirecrackerConfig(id string, config *NodeConfiguration) (firecracker.Config, error) {
-	socket := getSocketPath(id)
-	rootPath := getRootFsPath(id)
 
-	return firecracker.Config{
-		Drives: []models.Drive{{
-			DriveID:      firecracker.String("1"),
-			PathOnHost:   &rootPath,
-			IsRootDevice: firecracker.Bool(true),
-			IsReadOnly:   firecracker.Bool(false),
-			// RateLimiter: firecracker.NewRateLimiter(
-			// 	// bytes/s
-			// 	models.TokenBucket{
-			// 		OneTimeBurst: firecracker.Int64(1024 * 1024), // 1 MiB/s
-			// 		RefillTime:   firecracker.Int64(500),         // 0.5s
-			// 		Size:         firecracker.Int64(1024 * 1024),
-			// 	},
-			// 	// ops/s
-			// 	models.TokenBucket{
-			// 		OneTimeBurst: firecracker.Int64(100),  // 100 iops
-			// 		RefillTime:   firecracker.Int64(1000), // 1s
-			// 		Size:         firecracker.Int64(100),
-			// 	}),
-		}},
-		ForwardSignals:  make([]os.Signal, 0),
-		KernelImagePath: config.KernelFilepath,
-		LogPath:         fmt.Sprintf("%s.log", socket),
-		NetworkInterfaces: []firecracker.NetworkInterface{{
-			AllowMMDS: true,
-			// Use CNI to get dynamic IP
-			CNIConfiguration: &firecracker.CNIConfiguration{
-				BinPath:     config.CNI.BinPath,
-				IfName:      *config.CNI.InterfaceName,
-				NetworkName: *config.CNI.NetworkName,
-			},
-			//OutRateLimiter: firecracker.NewRateLimiter(..., ...),
-			//InRateLimiter: firecracker.NewRateLimiter(..., ...),
-		}},
-		MachineCfg: models.MachineConfiguration{
-			VcpuCount:  firecracker.Int64(int64(*config.MachineTemplate.VcpuCount)),
-			MemSizeMib: firecracker.Int64(int64(*config.MachineTemplate.MemSizeMib)),
-		},
-		MmdsVersion: firecracker.MMDSv2,
-		SocketPath:  socket,
-	}, nil
+	return orphaned
 }
 
-func getLogPath(vmmID string) string {
+func getLogPath(dir string, vmmID string) string {
 	filename := strings.Join([]string{
 		".firecracker.sock",
 		strconv.Itoa(os.Getpid()),
@@ -244,19 +161,26 @@ func getLogPath(vmmID string) string {
 	},
 		"-",
 	)
-	dir := os.TempDir()
 
 	return filepath.Join(dir, filename)
 }
 
-func getRootFsPath(vmmID string) string {
+const defaultRootFsOverlaySizeMib = 64
+
+func (c *RootFsOverlay) sizeMib() int {
+	if c.SizeMib > 0 {
+		return c.SizeMib
+	}
+	return defaultRootFsOverlaySizeMib
+}
+
+func getRootFsPath(dir string, vmmID string) string {
 	filename := fmt.Sprintf("rootfs-%s.ext4", vmmID)
-	dir := os.TempDir()
 
 	return filepath.Join(dir, filename)
 }
 
-func getSocketPath(vmmID string) string {
+func getSocketPath(dir string, vmmID string) string {
 	filename := strings.Join([]string{
 		".firecracker.sock",
 		strconv.Itoa(os.Getpid()),
@@ -264,7 +188,6 @@ func getSocketPath(vmmID string) string {
 	},
 		"-",
 	)
-	dir := os.TempDir()
 
 	return filepath.Join(dir, filename)
 }
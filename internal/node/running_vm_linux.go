@@ -0,0 +1,262 @@
+package nexnode
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/rs/xid"
+)
+
+// Firecracker's default kernel command line, with the rootfs' overlay init run in place of its init.
+// The init is given the size of the overlay, in MiB, in its environment
+const overlayKernelArgs = "reboot=k panic=1 pci=off nomodules 8250.nr_uarts=0 i8042.noaux i8042.nomux i8042.dumbkbd swiotlb=noforce init=/sbin/overlay-init nex_overlay_size_mib=%d"
+
+// Create a VMM with a given set of options and start the VM. The VM is given the requested IP
+// address, if any, rather than one allocated by the CNI network, and the volume at the given
+// path, if any, as its second drive
+func createAndStartVM(ctx context.Context, config *NodeConfiguration, ip net.IP, volumePath string, log *slog.Logger) (*runningFirecracker, error) {
+	vmmID := xid.New().String()
+
+	fcCfg, err := generateFirecrackerConfig(vmmID, config, ip, volumePath)
+	if err != nil {
+		log.Error("Failed to generate firecracker configuration", slog.Any("config", config))
+		return nil, err
+	}
+
+	boot := machineBoot{started: time.Now().UTC()}
+	if config.RootFsOverlay == nil {
+		err = copy(config.RootFsFilepath, *fcCfg.Drives[0].PathOnHost)
+		boot.timings.RootfsCopyMillis = millisBetween(boot.started, time.Now().UTC())
+
+		if err != nil {
+			log.Error("Failed to copy rootfs to temp location", slog.Any("err", err))
+			return nil, err
+		}
+	}
+
+	// TODO: can we please not use logrus here amazon?
+	machineOpts := []firecracker.Opt{
+		firecracker.WithLogger(log.With(slog.Bool("firecracker", true), slog.String("vmmid", vmmID))),
+	}
+
+	firecrackerBinary, err := exec.LookPath("firecracker")
+	if err != nil {
+		return nil, err
+	}
+
+	finfo, err := os.Stat(firecrackerBinary)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("binary %q does not exist: %v", firecrackerBinary, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat binary, %q: %v", firecrackerBinary, err)
+	}
+
+	if finfo.IsDir() {
+		return nil, fmt.Errorf("binary, %q, is a directory", firecrackerBinary)
+	} else if finfo.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("binary, %q, is not executable. Check permissions of binary", firecrackerBinary)
+	}
+
+	var cgroup string
+	if fcCfg.JailerCfg == nil {
+		cmd := firecracker.VMCommandBuilder{}.
+			WithBin(firecrackerBinary).
+			WithSocketPath(fcCfg.SocketPath).
+			WithStderr(os.Stderr).
+			Build(ctx)
+
+		// the firecracker process is started directly in the machine's cgroup, so that it's
+		// limited from the outset
+		if config.Cgroups != nil {
+			cgroup, err = createMachineCgroup(config.Cgroups, vmmID, *config.MachineTemplate.VcpuCount, *config.MachineTemplate.MemSizeMib)
+			if err != nil {
+				return nil, err
+			}
+
+			cgroupDir, err := os.Open(cgroup)
+			if err != nil {
+				_ = removeMachineCgroup(cgroup)
+				return nil, fmt.Errorf("failed to open machine cgroup: %s", err)
+			}
+			defer cgroupDir.Close()
+
+			cmd.SysProcAttr = &syscall.SysProcAttr{
+				UseCgroupFD: true,
+				CgroupFD:    int(cgroupDir.Fd()),
+			}
+		}
+
+		machineOpts = append(machineOpts, firecracker.WithProcessRunner(cmd))
+	}
+
+	vmmCtx, vmmCancel := context.WithCancel(ctx)
+
+	m, err := firecracker.NewMachine(vmmCtx, fcCfg, machineOpts...)
+	if err != nil {
+		vmmCancel()
+		removeFailedMachineCgroup(cgroup, log)
+		return nil, fmt.Errorf("failed creating machine: %s", err)
+	}
+
+	// the CNI network is set up by the first of the handlers run as the machine starts, after
+	// which the firecracker process is started and the machine configured
+	var networkStarted, networkReady time.Time
+	m.Handlers.FcInit = m.Handlers.FcInit.
+		Prepend(firecracker.Handler{
+			Name: "nex.BootTimingNetworkStarted",
+			Fn: func(context.Context, *firecracker.Machine) error {
+				networkStarted = time.Now().UTC()
+				return nil
+			},
+		}).
+		AppendAfter(firecracker.SetupNetworkHandlerName, firecracker.Handler{
+			Name: "nex.BootTimingNetworkReady",
+			Fn: func(context.Context, *firecracker.Machine) error {
+				networkReady = time.Now().UTC()
+				return nil
+			},
+		})
+
+	if err := m.Start(vmmCtx); err != nil {
+		vmmCancel()
+		removeFailedMachineCgroup(cgroup, log)
+		return nil, fmt.Errorf("failed to start machine: %v", err)
+	}
+
+	boot.instanceStarted = time.Now().UTC()
+	boot.timings.CNISetupMillis = millisBetween(networkStarted, networkReady)
+	boot.timings.FirecrackerStartMillis = millisBetween(networkReady, boot.instanceStarted)
+
+	gw := m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.Gateway
+	ip = m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IPAddr.IP
+	hosttap := m.Cfg.NetworkInterfaces[0].StaticConfiguration.HostDevName
+	mask := m.Cfg.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IPAddr.Mask
+
+	log.Info("Machine started",
+		slog.String("vmid", vmmID),
+		slog.Any("ip", ip),
+		slog.Any("gateway", gw),
+		slog.String("netmask", mask.String()),
+		slog.String("hosttap", hosttap),
+		slog.String("nats_host", *config.InternalNodeHost),
+		slog.Int("nats_port", *config.InternalNodePort),
+	)
+
+	return &runningFirecracker{
+		boot:           boot,
+		cgroup:         cgroup,
+		config:         config,
+		ip:             ip,
+		log:            log,
+		machine:        m,
+		machineStarted: time.Now().UTC(),
+		memSizeMib:     *m.Cfg.MachineCfg.MemSizeMib,
+		vcpuCount:      *m.Cfg.MachineCfg.VcpuCount,
+		vmmCancel:      vmmCancel,
+		vmmCtx:         vmmCtx,
+		vmmID:          vmmID,
+	}, nil
+}
+
+func removeFailedMachineCgroup(cgroup string, log *slog.Logger) {
+	if cgroup == "" {
+		return
+	}
+
+	err := removeMachineCgroup(cgroup)
+	if err != nil {
+		log.Warn("Failed to remove machine cgroup", slog.String("cgroup", cgroup), slog.Any("err", err))
+	}
+}
+
+func copy(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(dst, data, 0644)
+	return err
+}
+
+func generateFirecrackerConfig(id string, config *NodeConfiguration, ip net.IP, volumePath string) (firecracker.Config, error) {
+	socket := getSocketPath(config.runDirectory(), id)
+	rootPath := getRootFsPath(config.runDirectory(), id)
+
+	// firecracker's default kernel command line is used unless the rootfs is shared read-only
+	var kernelArgs string
+	if config.RootFsOverlay != nil {
+		rootPath = config.RootFsFilepath
+		kernelArgs = fmt.Sprintf(overlayKernelArgs, config.RootFsOverlay.sizeMib())
+	}
+
+	var cniArgs [][2]string
+	if ip != nil {
+		// requests the address of the host-local IPAM plugin; other plugins ignore it
+		cniArgs = [][2]string{{"IgnoreUnknown", "1"}, {"IP", ip.String()}}
+	}
+
+	drives := []models.Drive{{
+		DriveID:      firecracker.String("1"),
+		PathOnHost:   &rootPath,
+		IsRootDevice: firecracker.Bool(true),
+		IsReadOnly:   firecracker.Bool(config.RootFsOverlay != nil),
+		// RateLimiter: firecracker.NewRateLimiter(
+		// 	// bytes/s
+		// 	models.TokenBucket{
+		// 		OneTimeBurst: firecracker.Int64(1024 * 1024), // 1 MiB/s
+		// 		RefillTime:   firecracker.Int64(500),         // 0.5s
+		// 		Size:         firecracker.Int64(1024 * 1024),
+		// 	},
+		// 	// ops/s
+		// 	models.TokenBucket{
+		// 		OneTimeBurst: firecracker.Int64(100),  // 100 iops
+		// 		RefillTime:   firecracker.Int64(1000), // 1s
+		// 		Size:         firecracker.Int64(100),
+		// 	}),
+	}}
+
+	if volumePath != "" {
+		// appears in the guest as /dev/vdb, which the rootfs mounts at the workload volume path
+		drives = append(drives, models.Drive{
+			DriveID:      firecracker.String("2"),
+			PathOnHost:   &volumePath,
+			IsRootDevice: firecracker.Bool(false),
+			IsReadOnly:   firecracker.Bool(false),
+		})
+	}
+
+	return firecracker.Config{
+		Drives:          drives,
+		ForwardSignals:  make([]os.Signal, 0),
+		KernelArgs:      kernelArgs,
+		KernelImagePath: config.KernelFilepath,
+		LogPath:         fmt.Sprintf("%s.log", socket),
+		NetworkInterfaces: []firecracker.NetworkInterface{{
+			AllowMMDS: true,
+			// Use CNI to get dynamic IP
+			CNIConfiguration: &firecracker.CNIConfiguration{
+				BinPath:     config.CNI.BinPath,
+				IfName:      *config.CNI.InterfaceName,
+				NetworkName: *config.CNI.NetworkName,
+				Args:        cniArgs,
+			},
+			//OutRateLimiter: firecracker.NewRateLimiter(..., ...),
+			//InRateLimiter: firecracker.NewRateLimiter(..., ...),
+		}},
+		MachineCfg: models.MachineConfiguration{
+			VcpuCount:  firecracker.Int64(int64(*config.MachineTemplate.VcpuCount)),
+			MemSizeMib: firecracker.Int64(int64(*config.MachineTemplate.MemSizeMib)),
+		},
+		MmdsVersion: firecracker.MMDSv2,
+		SocketPath:  socket,
+	}, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package nexnode
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+)
+
+// Firecracker is only available on Linux; on all other platforms the node must
+// be started in development mode (no_sandbox) so agents run as local processes
+func createAndStartVM(ctx context.Context, config *NodeConfiguration, ip net.IP, volumePath string, log *slog.Logger) (*runningFirecracker, error) {
+	return nil, errors.New("firecracker is not supported on this platform; enable no_sandbox (or start the node with --dev) to run agents as local processes")
+}
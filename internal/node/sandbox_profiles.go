@@ -0,0 +1,114 @@
+package nexnode
+
+import (
+	"fmt"
+	"slices"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+// Built-in sandbox profiles
+const (
+	SandboxProfilePureCompute  = "pure-compute"
+	SandboxProfileKeyValueOnly = "kv-only"
+	SandboxProfileFull         = "full"
+)
+
+var (
+	allHostServices = []string{hostServiceHTTP, hostServiceKeyValue, hostServiceMessaging, hostServiceObjectStore, hostServiceSecrets}
+
+	builtinSandboxProfiles = map[string][]string{
+		SandboxProfilePureCompute:  {},
+		SandboxProfileKeyValueOnly: {hostServiceKeyValue},
+		SandboxProfileFull:         allHostServices,
+	}
+)
+
+func (p *SandboxProfiles) validate() []error {
+	errs := make([]error, 0)
+
+	for name, services := range p.Profiles {
+		if _, ok := builtinSandboxProfiles[name]; ok {
+			errs = append(errs, fmt.Errorf("sandbox profile %s is built in and can't be redefined", name))
+		}
+		for _, service := range services {
+			if !slices.Contains(allHostServices, service) {
+				errs = append(errs, fmt.Errorf("sandbox profile %s exposes unknown host service: %s", name, service))
+			}
+		}
+	}
+
+	if p.Default != "" && !p.defines(p.Default) {
+		errs = append(errs, fmt.Errorf("default sandbox profile %s is not defined", p.Default))
+	}
+
+	for namespace, profiles := range p.Namespaces {
+		if len(profiles) == 0 {
+			errs = append(errs, fmt.Errorf("namespace %s must be permitted at least one sandbox profile", namespace))
+		}
+		for _, profile := range profiles {
+			if !p.defines(profile) {
+				errs = append(errs, fmt.Errorf("sandbox profile %s permitted for namespace %s is not defined", profile, namespace))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (p *SandboxProfiles) defines(name string) bool {
+	_, ok := p.services(name)
+	return ok
+}
+
+// Returns the host services exposed by the named profile
+func (p *SandboxProfiles) services(name string) ([]string, bool) {
+	if services, ok := builtinSandboxProfiles[name]; ok {
+		return services, true
+	}
+	if p == nil {
+		return nil, false
+	}
+	services, ok := p.Profiles[name]
+	return services, ok
+}
+
+// Returns the profile of workloads in the given namespace which don't request one: the first of
+// the namespace's permitted profiles, if it is limited to some, or the node's default profile
+func (p *SandboxProfiles) defaultProfile(namespace string) string {
+	if p == nil {
+		return SandboxProfileFull
+	}
+	if profiles := p.Namespaces[namespace]; len(profiles) > 0 {
+		return profiles[0]
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return SandboxProfileFull
+}
+
+// Resolves the sandbox profile requested by (or defaulted for) a workload in the given namespace
+// into the host services it exposes
+func (p *SandboxProfiles) resolve(requested *string, namespace string) (*agentapi.HostServicesPolicy, error) {
+	name := p.defaultProfile(namespace)
+	if requested != nil {
+		name = *requested
+	}
+
+	services, ok := p.services(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox profile: %s", name)
+	}
+
+	if p != nil {
+		if profiles, ok := p.Namespaces[namespace]; ok && !slices.Contains(profiles, name) {
+			return nil, fmt.Errorf("sandbox profile %s is not permitted for namespace %s", name, namespace)
+		}
+	}
+
+	return &agentapi.HostServicesPolicy{
+		Profile:  name,
+		Services: slices.Clone(services),
+	}, nil
+}
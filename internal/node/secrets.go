@@ -0,0 +1,149 @@
+package nexnode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSecretProviderTimeoutSeconds = 10
+
+	// Environment variables through which a secret provider is told which workload a reference
+	// is being resolved for
+	secretProviderNamespaceEnv = "NEX_SECRET_NAMESPACE"
+	secretProviderWorkloadEnv  = "NEX_SECRET_WORKLOAD"
+
+	// Longest provider error output included in a resolution error
+	maxSecretProviderMessageLength = 256
+)
+
+var validSecretScheme = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+func (c *Secrets) validate() []error {
+	errs := make([]error, 0)
+
+	for scheme, provider := range c.Providers {
+		if !validSecretScheme.MatchString(scheme) {
+			errs = append(errs, fmt.Errorf("invalid secret provider scheme: %s", scheme))
+		}
+		if len(provider.Command) == 0 {
+			errs = append(errs, fmt.Errorf("secret provider %s requires a command", scheme))
+		}
+		if provider.TimeoutSeconds < 0 {
+			errs = append(errs, fmt.Errorf("secret provider %s timeout must be >= 0", scheme))
+		}
+	}
+
+	return errs
+}
+
+func (p *SecretProvider) timeout() time.Duration {
+	if p.TimeoutSeconds > 0 {
+		return time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	return defaultSecretProviderTimeoutSeconds * time.Second
+}
+
+func (p *SecretProvider) permits(namespace string) bool {
+	return len(p.Namespaces) == 0 || slices.Contains(p.Namespaces, namespace)
+}
+
+// Resolves each of a workload's secret references, keyed by the name of the environment
+// variable the secret is given to the workload as, into the secret's value
+func (m *MachineManager) resolveSecrets(namespace string, workload string, references map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(references))
+	for name, reference := range references {
+		if name == "" {
+			return nil, errors.New("secret name is required")
+		}
+
+		value, err := m.resolveSecret(namespace, workload, reference)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %s", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Resolves a secret reference, e.g. vault://secret/db, by running the provider configured for
+// its scheme
+func (m *MachineManager) resolveSecret(namespace string, workload string, reference string) (string, error) {
+	ref, err := url.Parse(reference)
+	if err != nil || ref.Scheme == "" {
+		return "", fmt.Errorf("invalid secret reference: %s", reference)
+	}
+
+	var provider SecretProvider
+	ok := false
+	if m.config.Secrets != nil {
+		provider, ok = m.config.Secrets.Providers[ref.Scheme]
+	}
+	if !ok {
+		return "", fmt.Errorf("no secret provider is configured for %s references", ref.Scheme)
+	}
+	if !provider.permits(namespace) {
+		return "", fmt.Errorf("secret provider %s is not permitted for namespace %s", ref.Scheme, namespace)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, provider.timeout())
+	defer cancel()
+
+	args := append(slices.Clone(provider.Command[1:]), reference)
+	cmd := exec.CommandContext(ctx, provider.Command[0], args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", secretProviderNamespaceEnv, namespace),
+		fmt.Sprintf("%s=%s", secretProviderWorkloadEnv, workload),
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", provider.timeout())
+		} else if message := strings.TrimSpace(stderr.String()); message != "" {
+			if len(message) > maxSecretProviderMessageLength {
+				message = message[:maxSecretProviderMessageLength] + "..."
+			}
+			err = errors.New(message)
+		}
+
+		m.log.Warn("Secret provider failed to resolve reference",
+			slog.String("scheme", ref.Scheme),
+			slog.String("namespace", namespace),
+			slog.String("workload", workload),
+			slog.Any("err", err),
+		)
+		return "", fmt.Errorf("secret provider %s failed: %s", ref.Scheme, err)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// Resolves, for the secrets host service, the secret a workload declared under the given name
+func (m *MachineManager) resolveWorkloadSecret(vmID string, name string) (string, error) {
+	vm, ok := m.lookupMachine(vmID)
+	if !ok || vm.deployRequest == nil {
+		return "", errors.New("unknown workload")
+	}
+
+	reference, ok := vm.deployRequest.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s was not declared by the workload", name)
+	}
+
+	return m.resolveSecret(vm.namespace, *vm.deployRequest.WorkloadName, reference)
+}
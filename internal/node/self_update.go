@@ -0,0 +1,260 @@
+package nexnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Ways in which the node restarts into an updated binary
+const (
+	SelfUpdateRestartExec       = "exec"
+	SelfUpdateRestartSupervisor = "supervisor"
+)
+
+const (
+	// Environment variable through which the node hands its seed to the binary it execs into, so
+	// that the node keeps its identity across the update
+	nodeSeedHandoffEnv = "NEX_NODE_SEED_HANDOFF"
+
+	selfUpdateVersionTimeout = 10 * time.Second
+)
+
+func (c *SelfUpdate) validate() []error {
+	errs := make([]error, 0)
+
+	if c.Restart != "" && c.Restart != SelfUpdateRestartExec && c.Restart != SelfUpdateRestartSupervisor {
+		errs = append(errs, fmt.Errorf("invalid self update restart: %s", c.Restart))
+	}
+
+	if len(c.Verification.TrustedKeys) == 0 && len(c.Verification.FulcioRoots) == 0 {
+		errs = append(errs, errors.New("self update requires at least one trusted key or fulcio root to verify binaries"))
+	}
+
+	// any certificate chaining to a public Fulcio root would otherwise be trusted to replace the
+	// node's binary, which runs as root
+	if len(c.Verification.FulcioRoots) > 0 && (len(c.Verification.CertificateIdentities) == 0 || c.Verification.CertificateOIDCIssuer == "") {
+		errs = append(errs, errors.New("self update with fulcio roots requires certificate identities and a certificate OIDC issuer"))
+	}
+
+	return errs
+}
+
+func (c *SelfUpdate) restart() string {
+	if c.Restart != "" {
+		return c.Restart
+	}
+	return SelfUpdateRestartExec
+}
+
+// A binary which has been installed in place of the node's own, which the node restarts into
+// once it has shut down
+type nodeUpdate struct {
+	path    string
+	restart string
+}
+
+// Updates the node to the binary in the request: it's retrieved from the object store, verified
+// against the request's digest and signature, checked to run, and installed in place of the
+// running binary, which is kept alongside it as {binary}.previous. The node then shuts down
+// and restarts into it
+func (api *ApiListener) handleUpdate(m *nats.Msg) {
+	config := api.config.SelfUpdate
+	if config == nil {
+		respondFail(controlapi.NodeUpdateResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Self update is not enabled on this node")
+		return
+	}
+
+	var request controlapi.NodeUpdateRequest
+	err := schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize node update request", slog.Any("err", err))
+		respondInvalid(controlapi.NodeUpdateResponseType, m, fmt.Sprintf("Unable to deserialize node update request: %s", err), err)
+		return
+	}
+
+	if !api.updating.CompareAndSwap(false, true) {
+		respondFail(controlapi.NodeUpdateResponseType, m, controlapi.ErrorCodeConflict, "Node is already being updated")
+		return
+	}
+
+	update, version, err := api.installUpdate(config, &request)
+	if err != nil {
+		api.updating.Store(false)
+		api.log.Error("Failed to update node", slog.String("name", request.Name), slog.Any("err", err))
+		respondFail(controlapi.NodeUpdateResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to update node: %s", err))
+		return
+	}
+
+	api.log.Info("Installed updated node binary, restarting",
+		slog.String("path", update.path),
+		slog.String("version", version),
+		slog.String("restart", update.restart),
+	)
+
+	res := controlapi.NewEnvelope(controlapi.NodeUpdateResponseType, controlapi.NodeUpdateResponse{
+		NodeId:  api.nodeId,
+		Version: version,
+		Digest:  strings.ToLower(request.Digest),
+		Restart: update.restart,
+	}, nil)
+	raw, err := json.Marshal(res)
+	if err != nil {
+		api.log.Error("Failed to marshal node update response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+
+	api.updates <- update
+}
+
+func (api *ApiListener) installUpdate(config *SelfUpdate, request *controlapi.NodeUpdateRequest) (*nodeUpdate, string, error) {
+	if request.Bucket == "" || request.Name == "" || request.Digest == "" {
+		return nil, "", errors.New("bucket, name and digest are required")
+	}
+
+	binary, err := api.retrieveUpdate(request)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to retrieve binary: %s", err)
+	}
+
+	digest := sha256.Sum256(binary)
+	if !strings.EqualFold(hex.EncodeToString(digest[:]), request.Digest) {
+		return nil, "", errors.New("binary does not match the requested digest")
+	}
+
+	err = verifyUpdateSignature(config, &request.Signature, binary, digest[:])
+	if err != nil {
+		return nil, "", err
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to locate running binary: %s", err)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to locate running binary: %s", err)
+	}
+
+	staged := path + ".update"
+	err = os.WriteFile(staged, binary, 0755)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stage binary: %s", err)
+	}
+
+	version, err := binaryVersion(staged)
+	if err == nil && request.Version != "" && !strings.Contains(version, request.Version) {
+		err = fmt.Errorf("binary reports version %s rather than %s", version, request.Version)
+	}
+	if err != nil {
+		_ = os.Remove(staged)
+		return nil, "", err
+	}
+
+	err = os.Rename(path, path+".previous")
+	if err == nil {
+		err = os.Rename(staged, path)
+		if err != nil {
+			_ = os.Rename(path+".previous", path)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(staged)
+		return nil, "", fmt.Errorf("failed to install binary: %s", err)
+	}
+
+	return &nodeUpdate{path: path, restart: config.restart()}, version, nil
+}
+
+func (api *ApiListener) retrieveUpdate(request *controlapi.NodeUpdateRequest) ([]byte, error) {
+	var opts []nats.JSOpt
+	if request.JsDomain != nil {
+		opts = append(opts, nats.Domain(*request.JsDomain))
+	}
+
+	js, err := api.mgr.nc.JetStream(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := js.ObjectStore(request.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetBytes(request.Name)
+}
+
+// Verifies the signature of an updated binary, produced by `cosign sign-blob`, against the keys
+// (or Fulcio roots) trusted for self updates. Unlike workload artifacts, binaries must be signed
+func verifyUpdateSignature(config *SelfUpdate, signature *controlapi.ArtifactSignature, binary []byte, digest []byte) error {
+	if signature.Signature == "" {
+		return errors.New("binary is not signed")
+	}
+
+	signers, err := config.Verification.signers(signature, digest)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode binary signature: %s", err)
+	}
+
+	for _, s := range signers {
+		if verifySignature(s.key, binary, digest, sig) {
+			return nil
+		}
+	}
+	return errors.New("binary signature could not be verified by any trusted key")
+}
+
+// Runs the binary with --version, ensuring it runs on this host, and returns the version it reports
+func binaryVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), selfUpdateVersionTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("binary failed to run: %s", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Restarts into an updated binary once the node has shut down. When restarting by exec, the node's
+// seed is handed to the new process, which keeps the node's identity; when a supervisor restarts
+// the node, the process just exits
+func (n *Node) restartInto(update *nodeUpdate) {
+	if update.restart == SelfUpdateRestartSupervisor {
+		n.log.Info("Exiting to be restarted into updated binary by supervisor", slog.String("path", update.path))
+		return
+	}
+
+	seed, err := n.keypair.Seed()
+	if err != nil {
+		n.log.Error("Failed to hand off node seed to updated binary", slog.Any("err", err))
+		return
+	}
+
+	n.log.Info("Executing updated binary", slog.String("path", update.path))
+	env := append(os.Environ(), fmt.Sprintf("%s=%s", nodeSeedHandoffEnv, seed))
+	err = syscall.Exec(update.path, os.Args, env)
+	n.log.Error("Failed to execute updated binary", slog.String("path", update.path), slog.Any("err", err))
+}
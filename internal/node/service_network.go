@@ -0,0 +1,349 @@
+package nexnode
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultServiceDomain = "nex.internal"
+
+	// Name of the file in the run directory in which stable IP leases are persisted
+	ipLeasesFilename = "ip_leases.json"
+
+	serviceDNSHookTimeout = 10 * time.Second
+	serviceDNSTTLSeconds  = 5
+
+	serviceDNSActionAdd    = "add"
+	serviceDNSActionRemove = "remove"
+)
+
+var validDNSLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func (c *ServiceNetworking) domain() string {
+	if c.Domain != "" {
+		return strings.TrimSuffix(strings.ToLower(c.Domain), ".")
+	}
+	return defaultServiceDomain
+}
+
+func (c *ServiceNetworking) validate() []error {
+	errs := make([]error, 0)
+
+	if c.LeaseCIDR != "" {
+		ip, _, err := net.ParseCIDR(c.LeaseCIDR)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid stable ip lease cidr: %s", err))
+		} else if ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("stable ip lease cidr must be an IPv4 network: %s", c.LeaseCIDR))
+		}
+	}
+
+	for _, label := range strings.Split(c.domain(), ".") {
+		if !validDNSLabel.MatchString(label) {
+			errs = append(errs, fmt.Errorf("invalid service domain: %s", c.Domain))
+			break
+		}
+	}
+
+	if c.DNSListen != "" {
+		if _, _, err := net.SplitHostPort(c.DNSListen); err != nil {
+			errs = append(errs, fmt.Errorf("invalid dns listen address: %s", err))
+		}
+	}
+
+	return errs
+}
+
+// Stable IP leases of service workloads, keyed by {namespace}/{workload}, and the DNS names
+// registered for running service workloads, keyed by fully qualified name. Leases are persisted
+// to the run directory so that they survive node restarts; names are not
+type serviceNetwork struct {
+	config *ServiceNetworking
+	log    *slog.Logger
+	path   string
+
+	mutex  sync.Mutex
+	leases map[string]string
+	names  map[string]net.IP
+}
+
+func newServiceNetwork(config *ServiceNetworking, runDirectory string, log *slog.Logger) *serviceNetwork {
+	n := &serviceNetwork{
+		config: config,
+		log:    log,
+		path:   filepath.Join(runDirectory, ipLeasesFilename),
+		leases: make(map[string]string),
+		names:  make(map[string]net.IP),
+	}
+
+	raw, err := os.ReadFile(n.path)
+	if err == nil {
+		err = json.Unmarshal(raw, &n.leases)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn("Failed to load stable ip leases", slog.String("path", n.path), slog.Any("err", err))
+	}
+
+	return n
+}
+
+// Returns the fully qualified DNS name of a service workload: {name}.{namespace}.{domain}
+func (n *serviceNetwork) fqdn(name string, namespace string) string {
+	return fmt.Sprintf("%s.%s.%s", name, strings.ToLower(namespace), n.config.domain())
+}
+
+// Returns the address leased to the given workload, leasing it the lowest free address of the
+// lease network if it has none
+func (n *serviceNetwork) lease(namespace string, workload string) (net.IP, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	key := fmt.Sprintf("%s/%s", namespace, workload)
+	if ip, ok := n.leases[key]; ok {
+		return net.ParseIP(ip), nil
+	}
+
+	_, network, err := net.ParseCIDR(n.config.LeaseCIDR)
+	if err != nil {
+		return nil, errors.New("node has no network to lease stable ips from")
+	}
+
+	leased := make(map[string]struct{}, len(n.leases))
+	for _, ip := range n.leases {
+		leased[ip] = struct{}{}
+	}
+
+	// the network and broadcast addresses are skipped
+	first := binary.BigEndian.Uint32(network.IP.To4())
+	ones, bits := network.Mask.Size()
+	last := first + uint32(1)<<(bits-ones) - 1
+	for addr := first + 1; addr < last; addr++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, addr)
+		if _, ok := leased[ip.String()]; ok {
+			continue
+		}
+
+		n.leases[key] = ip.String()
+		err = n.saveLeases()
+		if err != nil {
+			delete(n.leases, key)
+			return nil, err
+		}
+
+		n.log.Info("Leased stable ip", slog.String("workload", key), slog.String("ip", ip.String()))
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("no stable ips left to lease in %s", n.config.LeaseCIDR)
+}
+
+// Releases the address leased to the given workload, if any
+func (n *serviceNetwork) release(namespace string, workload string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	key := fmt.Sprintf("%s/%s", namespace, workload)
+	if _, ok := n.leases[key]; !ok {
+		return
+	}
+
+	delete(n.leases, key)
+	err := n.saveLeases()
+	if err != nil {
+		n.log.Warn("Failed to persist stable ip leases", slog.Any("err", err))
+	}
+	n.log.Info("Released stable ip", slog.String("workload", key))
+}
+
+// Releases the address leased to a workload whose deployment failed, unless another of the
+// workload's machines is still using it
+func (m *MachineManager) releaseFailedStableIP(namespace string, workload string, ip net.IP) {
+	for _, vm := range m.runningMachines() {
+		if vm.ip.Equal(ip) {
+			return
+		}
+	}
+	m.serviceNetwork.release(namespace, workload)
+}
+
+// Must be called with the mutex held
+func (n *serviceNetwork) saveLeases() error {
+	raw, err := json.Marshal(n.leases)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(n.path, raw, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to persist stable ip leases: %s", err)
+	}
+	return nil
+}
+
+// Registers a DNS name for a service workload with the built-in responder and the external
+// provider hook, if configured
+func (n *serviceNetwork) register(fqdn string, ip net.IP) {
+	n.mutex.Lock()
+	n.names[fqdn] = ip
+	n.mutex.Unlock()
+
+	n.log.Info("Registered service dns name", slog.String("name", fqdn), slog.String("ip", ip.String()))
+	n.runHook(serviceDNSActionAdd, fqdn, ip)
+}
+
+func (n *serviceNetwork) deregister(fqdn string, ip net.IP) {
+	n.mutex.Lock()
+	if registered, ok := n.names[fqdn]; ok && registered.Equal(ip) {
+		delete(n.names, fqdn)
+	}
+	n.mutex.Unlock()
+
+	n.log.Info("Deregistered service dns name", slog.String("name", fqdn))
+	n.runHook(serviceDNSActionRemove, fqdn, ip)
+}
+
+func (n *serviceNetwork) resolve(fqdn string) (net.IP, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	ip, ok := n.names[strings.TrimSuffix(strings.ToLower(fqdn), ".")]
+	return ip, ok
+}
+
+// Runs the external dns provider hook with the action, name and address as its arguments
+func (n *serviceNetwork) runHook(action string, fqdn string, ip net.IP) {
+	if len(n.config.DNSHook) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serviceDNSHookTimeout)
+	defer cancel()
+
+	args := append(append([]string{}, n.config.DNSHook[1:]...), action, fqdn, ip.String())
+	output, err := exec.CommandContext(ctx, n.config.DNSHook[0], args...).CombinedOutput()
+	if err != nil {
+		n.log.Warn("Service dns hook failed",
+			slog.String("action", action),
+			slog.String("name", fqdn),
+			slog.String("output", strings.TrimSpace(string(output))),
+			slog.Any("err", err),
+		)
+	}
+}
+
+// Answers A queries for the names of service workloads over UDP until the context is done.
+// Queries for other names within the service domain are answered with NXDOMAIN, and queries
+// for names outside of it are refused
+func (n *serviceNetwork) serveDNS(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", n.config.DNSListen)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	n.log.Info("Service dns responder listening", slog.String("addr", conn.LocalAddr().String()), slog.String("domain", n.config.domain()))
+
+	buf := make([]byte, 512)
+	for {
+		size, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		res, err := n.answer(buf[:size])
+		if err != nil {
+			n.log.Debug("Failed to answer dns query", slog.Any("err", err))
+			continue
+		}
+
+		_, _ = conn.WriteTo(res, addr)
+	}
+}
+
+func (n *serviceNetwork) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(question.Name.String()), ".")
+	domain := n.config.domain()
+
+	res := dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		OpCode:             header.OpCode,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RCode:              dnsmessage.RCodeSuccess,
+		RecursionAvailable: false,
+	}
+
+	ip, found := n.resolve(name)
+	switch {
+	case name != domain && !strings.HasSuffix(name, "."+domain):
+		res.Authoritative = false
+		res.RCode = dnsmessage.RCodeRefused
+	case !found:
+		res.RCode = dnsmessage.RCodeNameError
+	}
+
+	builder := dnsmessage.NewBuilder(nil, res)
+	builder.EnableCompression()
+
+	err = builder.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+	err = builder.Question(question)
+	if err != nil {
+		return nil, err
+	}
+
+	if found && (question.Type == dnsmessage.TypeA || question.Type == dnsmessage.TypeALL) {
+		err = builder.StartAnswers()
+		if err != nil {
+			return nil, err
+		}
+
+		err = builder.AResource(dnsmessage.ResourceHeader{
+			Name:  question.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   serviceDNSTTLSeconds,
+		}, dnsmessage.AResource{A: [4]byte(ip.To4())})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return builder.Finish()
+}
@@ -1,7 +1,9 @@
 package nexnode
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -9,12 +11,19 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/synadia-io/nex/internal/node/services"
 	hostservices "github.com/synadia-io/nex/internal/node/services/lib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const hostServiceHTTP = "http"
 const hostServiceKeyValue = "kv"
 const hostServiceMessaging = "messaging"
 const hostServiceObjectStore = "objectstore"
+const hostServiceSecrets = "secrets"
+
+const hostServiceMessagingSubscribe = "subscribe"
 
 // Host services server implements select functionality which is
 // exposed to workloads by way of the agent which makes RPC calls
@@ -27,8 +36,9 @@ type HostServices struct {
 
 	http      services.HostService
 	kv        services.HostService
-	messaging services.HostService
+	messaging *hostservices.MessagingService
 	object    services.HostService
+	secrets   services.HostService
 }
 
 func NewHostServices(mgr *MachineManager, nc, ncint *nats.Conn, log *slog.Logger) *HostServices {
@@ -51,7 +61,7 @@ func (h *HostServices) init() error {
 		h.log.Debug("initialized http host service")
 	}
 
-	h.kv, err = hostservices.NewKeyValueService(h.nc, h.log)
+	h.kv, err = hostservices.NewKeyValueService(h.nc, h.mgr.resolveDeployedWorkload, h.log)
 	if err != nil {
 		h.log.Error(fmt.Sprintf("failed to initialize key/value host service: %s", err.Error()))
 		return err
@@ -59,7 +69,7 @@ func (h *HostServices) init() error {
 		h.log.Debug("initialized key/value host service")
 	}
 
-	h.messaging, err = hostservices.NewMessagingService(h.nc, h.log)
+	h.messaging, err = hostservices.NewMessagingService(h.nc, h.ncint, h.log)
 	if err != nil {
 		h.log.Error(fmt.Sprintf("failed to initialize messaging host service: %s", err.Error()))
 		return err
@@ -75,6 +85,14 @@ func (h *HostServices) init() error {
 		h.log.Debug("initialized object store host service")
 	}
 
+	h.secrets, err = hostservices.NewSecretsService(h.mgr.resolveWorkloadSecret, h.log)
+	if err != nil {
+		h.log.Error(fmt.Sprintf("failed to initialize secrets host service: %s", err.Error()))
+		return err
+	} else {
+		h.log.Debug("initialized secrets host service")
+	}
+
 	// agentint.{vmID}.rpc.{namespace}.{workload}.{service}.{method}
 	_, err = h.ncint.Subscribe("agentint.*.rpc.*.*.*.*", h.handleRPC)
 	if err != nil {
@@ -93,7 +111,7 @@ func (h *HostServices) handleRPC(msg *nats.Msg) {
 	service := tokens[5]
 	method := tokens[6]
 
-	_, ok := h.mgr.allVMs[vmID]
+	vm, ok := h.mgr.lookupMachine(vmID)
 	if !ok {
 		h.log.Warn("Received a host services RPC request from an unknown VM.")
 		resp, _ := json.Marshal(map[string]interface{}{
@@ -107,6 +125,76 @@ func (h *HostServices) handleRPC(msg *nats.Msg) {
 		return
 	}
 
+	// only the vm ID is vouched for, by the agent's permissions on the internal NATS server; the
+	// namespace and workload are the agent's say-so and must be those deployed to its vm
+	deployedNamespace, deployedWorkload, err := h.mgr.resolveDeployedWorkload(vmID)
+	if err != nil || namespace != deployedNamespace || workload != deployedWorkload {
+		h.log.Warn("Rejected host services RPC request for a workload not deployed to the requesting VM",
+			slog.String("vmid", vmID),
+			slog.String("namespace", namespace),
+			slog.String("workload", workload),
+		)
+		resp, _ := json.Marshal(map[string]interface{}{
+			"error": "namespace and workload do not match those deployed to the vm",
+		})
+
+		err := msg.Respond(resp)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+		}
+		return
+	}
+
+	if !vm.deployRequest.HostServices.Exposes(service) {
+		h.log.Warn("Rejected host services RPC request not permitted by the workload's sandbox profile",
+			slog.String("vmid", vmID),
+			slog.String("service", service),
+		)
+		resp, _ := json.Marshal(map[string]interface{}{
+			"error": "host service not permitted by sandbox profile",
+		})
+
+		err := msg.Respond(resp)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+		}
+		return
+	}
+
+	if service == hostServiceMessaging && method == hostServiceMessagingSubscribe &&
+		vm.deployRequest.SupportsTriggerSubjects() {
+		h.log.Warn("Rejected messaging subscription requested by a function workload",
+			slog.String("vmid", vmID),
+		)
+		resp, _ := json.Marshal(map[string]interface{}{
+			"error": "subscriptions are only available to service workloads",
+		})
+
+		err := msg.Respond(resp)
+		if err != nil {
+			h.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+		}
+		return
+	}
+
+	ctx := context.Background()
+	if msg.Header != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(msg.Header))
+	}
+
+	_, span := tracer.Start(
+		ctx,
+		"host-service-rpc",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("vmid", vmID),
+			attribute.String("namespace", namespace),
+			attribute.String("workload", workload),
+			attribute.String("service", service),
+			attribute.String("method", method),
+		))
+	defer span.End()
+
 	h.log.Debug("Received host services RPC request",
 		slog.String("vmid", vmID),
 		slog.String("namespace", namespace),
@@ -125,6 +213,8 @@ func (h *HostServices) handleRPC(msg *nats.Msg) {
 		h.messaging.HandleRPC(msg)
 	case hostServiceObjectStore:
 		h.object.HandleRPC(msg)
+	case hostServiceSecrets:
+		h.secrets.HandleRPC(msg)
 	default:
 		h.log.Warn("Received invalid host services RPC request",
 			slog.String("service", service),
@@ -141,3 +231,19 @@ func (h *HostServices) handleRPC(msg *nats.Msg) {
 		}
 	}
 }
+
+// Returns the namespace and name of the workload deployed to the given vm
+func (m *MachineManager) resolveDeployedWorkload(vmID string) (string, string, error) {
+	vm, ok := m.lookupMachine(vmID)
+	if !ok || vm.deployRequest == nil || vm.deployRequest.WorkloadName == nil {
+		return "", "", errors.New("unknown workload")
+	}
+	return vm.namespace, *vm.deployRequest.WorkloadName, nil
+}
+
+// Removes the messaging subscriptions held on behalf of the given vm
+func (h *HostServices) removeSubscriptions(vmID string) {
+	if h.messaging != nil {
+		h.messaging.RemoveSubscriptions(vmID)
+	}
+}
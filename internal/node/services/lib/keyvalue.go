@@ -16,15 +16,22 @@ const kvServiceMethodSet = "set"
 const kvServiceMethodDelete = "delete"
 const kvServiceMethodKeys = "keys"
 
+// Resolves the namespace and name of the workload deployed to the given VM
+type WorkloadResolver func(vmID string) (string, string, error)
+
+// Gives each workload a key/value bucket of its own, chosen by the workload deployed to the
+// requesting VM rather than by the namespace and workload in the request's subject
 type KeyValueService struct {
-	log *slog.Logger
-	nc  *nats.Conn
+	log     *slog.Logger
+	nc      *nats.Conn
+	resolve WorkloadResolver
 }
 
-func NewKeyValueService(nc *nats.Conn, log *slog.Logger) (*KeyValueService, error) {
+func NewKeyValueService(nc *nats.Conn, resolve WorkloadResolver, log *slog.Logger) (*KeyValueService, error) {
 	kv := &KeyValueService{
-		log: log,
-		nc:  nc,
+		log:     log,
+		nc:      nc,
+		resolve: resolve,
 	}
 
 	err := kv.init()
@@ -65,13 +72,11 @@ func (k *KeyValueService) HandleRPC(msg *nats.Msg) {
 }
 
 func (k *KeyValueService) handleGet(msg *nats.Msg) {
-	tokens := strings.Split(msg.Subject, ".")
-	namespace := tokens[3]
-	workload := tokens[4]
-
-	kvStore, err := k.resolveKeyValueStore(namespace, workload)
+	kvStore, err := k.resolveKeyValueStore(msg)
 	if err != nil {
 		k.log.Warn(fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		k.respondError(msg, fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		return
 	}
 
 	var req *agentapi.HostServicesKeyValueRequest
@@ -129,13 +134,11 @@ func (k *KeyValueService) handleGet(msg *nats.Msg) {
 }
 
 func (k *KeyValueService) handleSet(msg *nats.Msg) {
-	tokens := strings.Split(msg.Subject, ".")
-	namespace := tokens[3]
-	workload := tokens[4]
-
-	kvStore, err := k.resolveKeyValueStore(namespace, workload)
+	kvStore, err := k.resolveKeyValueStore(msg)
 	if err != nil {
 		k.log.Warn(fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		k.respondError(msg, fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		return
 	}
 
 	var req *agentapi.HostServicesKeyValueRequest
@@ -206,13 +209,11 @@ func (k *KeyValueService) handleSet(msg *nats.Msg) {
 }
 
 func (k *KeyValueService) handleDelete(msg *nats.Msg) {
-	tokens := strings.Split(msg.Subject, ".")
-	namespace := tokens[3]
-	workload := tokens[4]
-
-	kvStore, err := k.resolveKeyValueStore(namespace, workload)
+	kvStore, err := k.resolveKeyValueStore(msg)
 	if err != nil {
 		k.log.Warn(fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		k.respondError(msg, fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		return
 	}
 
 	var req *agentapi.HostServicesKeyValueRequest
@@ -268,13 +269,11 @@ func (k *KeyValueService) handleDelete(msg *nats.Msg) {
 }
 
 func (k *KeyValueService) handleKeys(msg *nats.Msg) {
-	tokens := strings.Split(msg.Subject, ".")
-	namespace := tokens[3]
-	workload := tokens[4]
-
-	kvStore, err := k.resolveKeyValueStore(namespace, workload)
+	kvStore, err := k.resolveKeyValueStore(msg)
 	if err != nil {
 		k.log.Warn(fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		k.respondError(msg, fmt.Sprintf("failed to resolve key/value store: %s", err.Error()))
+		return
 	}
 
 	keys, err := kvStore.Keys() // TODO-- paginate...
@@ -300,14 +299,26 @@ func (k *KeyValueService) handleKeys(msg *nats.Msg) {
 	}
 }
 
-// resolve the key value store for this workload; initialize it if necessary
-func (k *KeyValueService) resolveKeyValueStore(namespace, workload string) (nats.KeyValue, error) {
+// The name of the bucket backing the key/value host service of the given workload
+func KeyValueBucketName(namespace, workload string) string {
+	return fmt.Sprintf("hs_%s_%s_kv", namespace, workload)
+}
+
+// resolve the key value store of the workload deployed to the requesting vm; initialize it if necessary
+func (k *KeyValueService) resolveKeyValueStore(msg *nats.Msg) (nats.KeyValue, error) {
+	// agentint.{vmID}.rpc.{namespace}.{workload}.{service}.{method}
+	vmID := strings.Split(msg.Subject, ".")[1]
+	namespace, workload, err := k.resolve(vmID)
+	if err != nil {
+		return nil, err
+	}
+
 	js, err := k.nc.JetStream()
 	if err != nil {
 		return nil, err
 	}
 
-	kvStoreName := fmt.Sprintf("hs_%s_%s_kv", namespace, workload)
+	kvStoreName := KeyValueBucketName(namespace, workload)
 	kvStore, err := js.KeyValue(kvStoreName)
 	if err != nil {
 		if errors.Is(err, nats.ErrBucketNotFound) {
@@ -323,3 +334,14 @@ func (k *KeyValueService) resolveKeyValueStore(namespace, workload string) (nats
 
 	return kvStore, nil
 }
+
+func (k *KeyValueService) respondError(msg *nats.Msg, reason string) {
+	resp, _ := json.Marshal(map[string]interface{}{
+		"error": reason,
+	})
+
+	err := msg.Respond(resp)
+	if err != nil {
+		k.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+	}
+}
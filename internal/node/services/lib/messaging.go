@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -15,21 +16,36 @@ import (
 const messagingServiceMethodPublish = "publish"
 const messagingServiceMethodRequest = "request"
 const messagingServiceMethodRequestMany = "requestMany"
+const messagingServiceMethodSubscribe = "subscribe"
+const messagingServiceMethodUnsubscribe = "unsubscribe"
 
 const messagingRequestTimeout = time.Millisecond * 500 // FIXME-- make timeout configurable per request?
 const messagingRequestManyTimeout = time.Millisecond * 3000
 
+// Most subscriptions a single workload may hold at once
+const maxSubscriptionsPerWorkload = 32
+
 const messageSubject = "x-subject"
+const messageQueue = "x-queue"
+const messageReply = "x-reply"
+const messageSubscriptionID = "x-subscription-id"
 
 type MessagingService struct {
-	log *slog.Logger
-	nc  *nats.Conn
+	log   *slog.Logger
+	nc    *nats.Conn
+	ncint *nats.Conn
+
+	// subscriptions made on behalf of workloads, keyed by vm id and subscription id
+	subsMutex     sync.Mutex
+	subscriptions map[string]map[string]*nats.Subscription
 }
 
-func NewMessagingService(nc *nats.Conn, log *slog.Logger) (*MessagingService, error) {
+func NewMessagingService(nc, ncint *nats.Conn, log *slog.Logger) (*MessagingService, error) {
 	messaging := &MessagingService{
-		log: log,
-		nc:  nc,
+		log:           log,
+		nc:            nc,
+		ncint:         ncint,
+		subscriptions: make(map[string]map[string]*nats.Subscription),
 	}
 
 	err := messaging.init()
@@ -47,6 +63,7 @@ func (m *MessagingService) init() error {
 func (m *MessagingService) HandleRPC(msg *nats.Msg) {
 	// agentint.{vmID}.rpc.{namespace}.{workload}.{service}.{method}
 	tokens := strings.Split(msg.Subject, ".")
+	vmID := tokens[1]
 	service := tokens[5]
 	method := tokens[6]
 
@@ -57,6 +74,10 @@ func (m *MessagingService) HandleRPC(msg *nats.Msg) {
 		m.handleRequest(msg)
 	case messagingServiceMethodRequestMany:
 		m.handleRequestMany(msg)
+	case messagingServiceMethodSubscribe:
+		m.handleSubscribe(vmID, msg)
+	case messagingServiceMethodUnsubscribe:
+		m.handleUnsubscribe(vmID, msg)
 	default:
 		m.log.Warn("Received invalid host services RPC request",
 			slog.String("service", service),
@@ -208,3 +229,120 @@ func (m *MessagingService) handleRequestMany(msg *nats.Msg) {
 		}
 	}
 }
+
+// Subscribes to the subject given by the workload, optionally as a member of a queue group, on
+// the node's connection. Messages are forwarded to the workload's agent on the internal
+// connection at agentint.{vmID}.subscriptions.{subscriptionID}, with their original subject and
+// reply subject in the x-subject and x-reply headers. The subscription ID is chosen by the agent
+func (m *MessagingService) handleSubscribe(vmID string, msg *nats.Msg) {
+	subject := msg.Header.Get(messageSubject)
+	id := msg.Header.Get(messageSubscriptionID)
+	if subject == "" || id == "" || strings.ContainsAny(id, ".*> ") {
+		m.respond(msg, errors.New("subject and a valid subscription id are required"))
+		return
+	}
+
+	m.subsMutex.Lock()
+	defer m.subsMutex.Unlock()
+
+	subs, ok := m.subscriptions[vmID]
+	if !ok {
+		subs = make(map[string]*nats.Subscription)
+		m.subscriptions[vmID] = subs
+	}
+
+	if _, ok := subs[id]; ok {
+		m.respond(msg, fmt.Errorf("subscription %s already exists", id))
+		return
+	}
+	if len(subs) >= maxSubscriptionsPerWorkload {
+		m.respond(msg, fmt.Errorf("workloads may hold at most %d subscriptions", maxSubscriptionsPerWorkload))
+		return
+	}
+
+	forward := fmt.Sprintf("agentint.%s.subscriptions.%s", vmID, id)
+	handler := func(in *nats.Msg) {
+		out := nats.NewMsg(forward)
+		out.Data = in.Data
+		for k, v := range in.Header {
+			out.Header[k] = v
+		}
+		out.Header.Set(messageSubject, in.Subject)
+		if in.Reply != "" {
+			out.Header.Set(messageReply, in.Reply)
+		}
+
+		err := m.ncint.PublishMsg(out)
+		if err != nil {
+			m.log.Warn(fmt.Sprintf("failed to forward %d-byte message on subject %s to subscription %s: %s", len(in.Data), in.Subject, id, err.Error()))
+		}
+	}
+
+	var sub *nats.Subscription
+	var err error
+	if queue := msg.Header.Get(messageQueue); queue != "" {
+		sub, err = m.nc.QueueSubscribe(subject, queue, handler)
+	} else {
+		sub, err = m.nc.Subscribe(subject, handler)
+	}
+	if err != nil {
+		m.respond(msg, fmt.Errorf("failed to subscribe to subject %s: %s", subject, err.Error()))
+		return
+	}
+
+	subs[id] = sub
+	m.log.Debug(fmt.Sprintf("subscribed to subject %s on behalf of vm %s", subject, vmID), slog.String("subscription_id", id))
+
+	m.respond(msg, nil)
+}
+
+func (m *MessagingService) handleUnsubscribe(vmID string, msg *nats.Msg) {
+	id := msg.Header.Get(messageSubscriptionID)
+
+	m.subsMutex.Lock()
+	sub, ok := m.subscriptions[vmID][id]
+	if ok {
+		delete(m.subscriptions[vmID], id)
+	}
+	m.subsMutex.Unlock()
+
+	if !ok {
+		m.respond(msg, fmt.Errorf("unknown subscription %s", id))
+		return
+	}
+
+	err := sub.Unsubscribe()
+	if err != nil {
+		m.log.Warn(fmt.Sprintf("failed to unsubscribe from subject %s: %s", sub.Subject, err.Error()))
+	}
+
+	m.respond(msg, nil)
+}
+
+// Removes the subscriptions held on behalf of the given vm, e.g., when it's stopped
+func (m *MessagingService) RemoveSubscriptions(vmID string) {
+	m.subsMutex.Lock()
+	subs := m.subscriptions[vmID]
+	delete(m.subscriptions, vmID)
+	m.subsMutex.Unlock()
+
+	for _, sub := range subs {
+		err := sub.Unsubscribe()
+		if err != nil {
+			m.log.Warn(fmt.Sprintf("failed to unsubscribe from subject %s: %s", sub.Subject, err.Error()))
+		}
+	}
+}
+
+func (m *MessagingService) respond(msg *nats.Msg, err error) {
+	res := &agentapi.HostServicesMessagingResponse{Success: err == nil}
+	if err != nil {
+		res.Errors = []string{err.Error()}
+	}
+
+	resp, _ := json.Marshal(res)
+	err = msg.Respond(resp)
+	if err != nil {
+		m.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+	}
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const secretsServiceMethodGet = "get"
+
+// Resolves the secret declared by the workload running in the given VM under the given name
+type SecretResolver func(vmID string, name string) (string, error)
+
+// Gives workloads the values of the secrets declared in their deploy requests, resolved by the
+// node's secret providers each time they're requested
+type SecretsService struct {
+	log     *slog.Logger
+	resolve SecretResolver
+}
+
+func NewSecretsService(resolve SecretResolver, log *slog.Logger) (*SecretsService, error) {
+	secrets := &SecretsService{
+		log:     log,
+		resolve: resolve,
+	}
+
+	return secrets, nil
+}
+
+func (s *SecretsService) HandleRPC(msg *nats.Msg) {
+	// agentint.{vmID}.rpc.{namespace}.{workload}.{service}.{method}
+	tokens := strings.Split(msg.Subject, ".")
+	service := tokens[5]
+	method := tokens[6]
+
+	switch method {
+	case secretsServiceMethodGet:
+		s.handleGet(msg)
+	default:
+		s.log.Warn("Received invalid host services RPC request",
+			slog.String("service", service),
+			slog.String("method", method),
+		)
+
+		s.respondError(msg, "invalid rpc request")
+	}
+}
+
+func (s *SecretsService) handleGet(msg *nats.Msg) {
+	tokens := strings.Split(msg.Subject, ".")
+	vmID := tokens[1]
+
+	var req agentapi.HostServicesSecretRequest
+	if len(msg.Data) > 0 {
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			s.log.Warn(fmt.Sprintf("failed to unmarshal secrets RPC request: %s", err.Error()))
+			s.respondError(msg, fmt.Sprintf("failed to unmarshal secrets RPC request: %s", err.Error()))
+			return
+		}
+	}
+
+	// service workloads reach host services through the agent's HTTP proxy, which passes the
+	// name as a header
+	if req.Name == nil && msg.Header.Get("x-secret-name") != "" {
+		name := msg.Header.Get("x-secret-name")
+		req.Name = &name
+	}
+
+	if req.Name == nil || *req.Name == "" {
+		s.respondError(msg, "name is required")
+		return
+	}
+
+	value, err := s.resolve(vmID, *req.Name)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to resolve secret %s: %s", *req.Name, err.Error()))
+		s.respondError(msg, fmt.Sprintf("failed to resolve secret %s: %s", *req.Name, err.Error()))
+		return
+	}
+
+	resp, _ := json.Marshal(&agentapi.HostServicesSecretRequest{
+		Name:  req.Name,
+		Value: &value,
+	})
+
+	err = msg.Respond(resp)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+	}
+}
+
+func (s *SecretsService) respondError(msg *nats.Msg, reason string) {
+	resp, _ := json.Marshal(map[string]interface{}{
+		"error": reason,
+	})
+
+	err := msg.Respond(resp)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("failed to respond to host services RPC request: %s", err.Error()))
+	}
+}
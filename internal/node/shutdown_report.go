@@ -0,0 +1,79 @@
+package nexnode
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Records resources a machine failed to clean up as it stopped, to be reported when the node
+// shuts down
+func (m *MachineManager) recordOrphans(resources []string) {
+	if len(resources) == 0 {
+		return
+	}
+
+	m.orphanedMutex.Lock()
+	defer m.orphanedMutex.Unlock()
+
+	m.orphaned = append(m.orphaned, resources...)
+}
+
+// Cancels the node due to a fatal error, which is given as the reason for its shutdown
+func (m *MachineManager) fail(reason string) {
+	m.failure = reason
+	m.cancel()
+}
+
+// Publishes the node's shutdown report once its machines have been stopped
+func (n *Node) publishShutdownReport(reason string, fatal bool) error {
+	report := controlapi.NodeShutdownReport{
+		Id:           n.publicKey,
+		Reason:       reason,
+		Fatal:        fatal,
+		Uptime:       myUptime(time.Since(n.startedAt)),
+		Stopped:      make([]controlapi.ShutdownWorkload, 0),
+		FailedToStop: make([]controlapi.ShutdownWorkload, 0),
+		Orphaned:     make([]string, 0),
+	}
+
+	if n.manager != nil {
+		report.Stopped = append(report.Stopped, n.manager.stopped...)
+		report.FailedToStop = append(report.FailedToStop, n.manager.failedToStop...)
+
+		n.manager.orphanedMutex.Lock()
+		report.Orphaned = append(report.Orphaned, n.manager.orphaned...)
+		n.manager.orphanedMutex.Unlock()
+
+		// machines which failed to stop are left running
+		for _, vm := range report.FailedToStop {
+			report.Orphaned = append(report.Orphaned, fmt.Sprintf("machine %s", vm.MachineId))
+		}
+		slices.Sort(report.Orphaned)
+		report.Orphaned = slices.Compact(report.Orphaned)
+	}
+
+	report.Clean = !fatal && len(report.FailedToStop) == 0 && len(report.Orphaned) == 0
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(n.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.NodeShutdownReportEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(report)
+
+	n.log.Info("Publishing node shutdown report",
+		slog.String("reason", reason),
+		slog.Bool("clean", report.Clean),
+		slog.Int("stopped", len(report.Stopped)),
+		slog.Int("failed_to_stop", len(report.FailedToStop)),
+		slog.Int("orphaned", len(report.Orphaned)),
+	)
+	return PublishCloudEvent(n.nc, controlapi.SystemNamespace, cloudevent, n.log)
+}
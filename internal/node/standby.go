@@ -0,0 +1,114 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+func (c *NodeConfiguration) activatesOnDeploy() bool {
+	return c.Standby != nil && c.Standby.ActivateOnDeploy
+}
+
+// Enters or leaves standby, returning whether the machine manager was in the other state and the
+// number of warm machines stopped. While in standby the machine manager keeps no warm machines;
+// once it leaves standby, its run loop warms the pools back to their configured size
+func (m *MachineManager) setStandby(standby bool) (bool, int) {
+	if m.standby.Swap(standby) == standby {
+		return false, 0
+	}
+	if !standby {
+		return true, 0
+	}
+	return true, m.drainPools()
+}
+
+// Stops the warm machines in every pool, returning the number stopped
+func (m *MachineManager) drainPools() int {
+	stopped := 0
+	for _, pool := range m.pools {
+		for drained := false; !drained; {
+			select {
+			case vm, ok := <-pool.machines:
+				if !ok {
+					drained = true
+					break
+				}
+				err := m.StopMachine(vm.vmmID, false)
+				if err != nil {
+					m.log.Warn("Failed to stop warm machine entering standby", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+				}
+				stopped++
+			default:
+				drained = true
+			}
+		}
+	}
+	return stopped
+}
+
+// Puts the node in standby or activates it, for the given reason, announcing the change
+func (api *ApiListener) setStandby(standby bool, reason string) *controlapi.StandbyResponse {
+	changed, stopped := api.mgr.setStandby(standby)
+	if changed {
+		api.log.Info("Node standby changed",
+			slog.Bool("standby", standby),
+			slog.String("reason", reason),
+			slog.Int("stopped_machines", stopped),
+		)
+		api.refreshCapacity()
+		api.publishStandbyEvent(standby)
+	}
+
+	return &controlapi.StandbyResponse{
+		NodeId:          api.nodeId,
+		Standby:         standby,
+		WarmMachines:    api.mgr.warmMachineCount(),
+		MachinePoolSize: api.mgr.poolCapacity(),
+		StoppedMachines: stopped,
+	}
+}
+
+func (api *ApiListener) handleStandby(m *nats.Msg) {
+	var request controlapi.StandbyRequest
+	err := schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize standby request", slog.Any("err", err))
+		respondInvalid(controlapi.StandbyResponseType, m, fmt.Sprintf("Unable to deserialize standby request: %s", err), err)
+		return
+	}
+
+	res := api.setStandby(request.Standby, "control request")
+
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.StandbyResponseType, res, nil))
+	if err != nil {
+		api.log.Error("Failed to marshal standby response", slog.Any("err", err))
+	} else {
+		_ = m.Respond(raw)
+	}
+}
+
+func (api *ApiListener) publishStandbyEvent(standby bool) {
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(api.nodeId)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.NodeStandbyEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(controlapi.NodeStandbyEvent{
+		Id:      api.nodeId,
+		Standby: standby,
+	})
+
+	err := PublishCloudEvent(api.mgr.nc, controlapi.SystemNamespace, cloudevent, api.log)
+	if err != nil {
+		api.log.Warn("Failed to publish node standby event", slog.Any("err", err))
+	}
+}
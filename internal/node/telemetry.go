@@ -18,6 +18,7 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 
+	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -46,6 +47,29 @@ type Telemetry struct {
 	functionTriggers       metric.Int64Counter
 	functionFailedTriggers metric.Int64Counter
 	functionRunTimeNano    metric.Int64Counter
+	functionEvictions      metric.Int64Counter
+
+	functionColdStartLatency metric.Int64Histogram
+	machineBootLatency       metric.Int64Histogram
+	agentHandshakeLatency    metric.Int64Histogram
+
+	functionTriggerQueueDepth metric.Int64UpDownCounter
+	functionRejectedTriggers  metric.Int64Counter
+
+	functionAgentQueueDepth       metric.Int64UpDownCounter
+	functionActiveExecutions      metric.Int64UpDownCounter
+	functionSaturatedMachineCount metric.Int64UpDownCounter
+
+	functionCanaryTriggers metric.Int64Counter
+	functionCanaryLatency  metric.Int64Histogram
+
+	workloadCPUUsec          metric.Int64Counter
+	workloadCPUThrottledUsec metric.Int64Counter
+	workloadCPUThrottles     metric.Int64Counter
+
+	// traces of function invocations attached as exemplars to the function runtime and failure
+	// metrics exported to prometheus
+	exemplars *functionExemplars
 }
 
 func NewTelemetry(ctx context.Context, log *slog.Logger, config *NodeConfiguration, nodePubKey string) (*Telemetry, error) {
@@ -59,6 +83,7 @@ func NewTelemetry(ctx context.Context, log *slog.Logger, config *NodeConfigurati
 		serviceName:     defaultServiceName,
 		nodePubKey:      nodePubKey,
 		meterProvider:   noop.NewMeterProvider(),
+		exemplars:       newFunctionExemplars(),
 	}
 
 	err := t.init()
@@ -133,6 +158,110 @@ func (t *Telemetry) init() error {
 	if e != nil {
 		err = errors.Join(err, e)
 	}
+	t.functionEvictions, e = t.meter.
+		Int64Counter("nex-function-evictions",
+			metric.WithDescription("Total number of functions evicted due to repeated trigger failures"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.workloadCPUUsec, e = t.meter.
+		Int64Counter("nex-workload-cpu-usec",
+			metric.WithDescription("Host CPU time in microseconds used by the machines of workloads, as accounted by their cgroups"),
+			metric.WithUnit("us"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.workloadCPUThrottledUsec, e = t.meter.
+		Int64Counter("nex-workload-cpu-throttled-usec",
+			metric.WithDescription("Time in microseconds the machines of workloads were throttled for exhausting their cgroup CPU quota"),
+			metric.WithUnit("us"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.workloadCPUThrottles, e = t.meter.
+		Int64Counter("nex-workload-cpu-throttled-periods",
+			metric.WithDescription("Number of CPU bandwidth periods in which the machines of workloads were throttled"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionTriggerQueueDepth, e = t.meter.
+		Int64UpDownCounter("nex-function-trigger-queue-depth",
+			metric.WithDescription("Number of trigger messages waiting for execution by functions with concurrency limits"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionAgentQueueDepth, e = t.meter.
+		Int64UpDownCounter("nex-function-agent-trigger-queue-depth",
+			metric.WithDescription("Number of trigger messages received by function agents but not yet executed"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionActiveExecutions, e = t.meter.
+		Int64UpDownCounter("nex-function-active-executions",
+			metric.WithDescription("Number of trigger messages being executed by function agents"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionSaturatedMachineCount, e = t.meter.
+		Int64UpDownCounter("nex-function-saturated-machine-count",
+			metric.WithDescription("Number of function machines with trigger messages waiting in their agent"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionRejectedTriggers, e = t.meter.
+		Int64Counter("nex-function-rejected-trigger",
+			metric.WithDescription("Total number of trigger messages rejected or dropped because a function's trigger queue was full"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionCanaryTriggers, e = t.meter.
+		Int64Counter("nex-function-canary-trigger",
+			metric.WithDescription("Total number of trigger executions of the versions of functions being canaried, by version and outcome"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionCanaryLatency, e = t.meter.
+		Int64Histogram("nex-function-canary-latency-ms",
+			metric.WithDescription("Time in milliseconds taken by trigger executions of the versions of functions being canaried"),
+			metric.WithUnit("ms"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.functionColdStartLatency, e = t.meter.
+		Int64Histogram("nex-function-cold-start-latency-ms",
+			metric.WithDescription("Time in milliseconds taken to cold-start idle functions scaled to zero"),
+			metric.WithUnit("ms"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.machineBootLatency, e = t.meter.
+		Int64Histogram("nex-machine-boot-phase-ms",
+			metric.WithDescription("Time in milliseconds taken by each phase of starting a machine"),
+			metric.WithUnit("ms"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
+	t.agentHandshakeLatency, e = t.meter.
+		Int64Histogram("nex-agent-handshake-latency-ms",
+			metric.WithDescription("Time in milliseconds from starting a machine to receiving its agent's handshake"),
+			metric.WithUnit("ms"),
+		)
+	if e != nil {
+		err = errors.Join(err, e)
+	}
 
 	return err
 }
@@ -191,14 +320,19 @@ func (t *Telemetry) serveMetrics() (metricsdk.Reader, error) {
 		t.log.Debug("Starting prometheus exporter")
 		go func() {
 			t.log.Info(fmt.Sprintf("serving metrics at localhost:%d/metrics", t.metricsPort))
-			http.Handle("/metrics", promhttp.Handler())
+			// exemplars are only exposed in the OpenMetrics format
+			handler := promhttp.HandlerFor(prom.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+			http.Handle("/metrics", promhttp.InstrumentMetricHandler(prom.DefaultRegisterer, handler))
 			err := http.ListenAndServe(fmt.Sprintf(":%d", t.metricsPort), nil)
 			if err != nil {
 				t.log.Warn("failed to start prometheus web server", slog.Any("err", err))
 			}
 		}()
 
-		return prometheus.New()
+		return prometheus.New(prometheus.WithRegisterer(&exemplarRegisterer{
+			Registerer: prom.DefaultRegisterer,
+			exemplars:  t.exemplars,
+		}))
 	default:
 		t.log.Debug("Starting standard out exporter")
 		reader, err := stdoutmetric.New()
@@ -13,12 +13,14 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
-	tracer trace.Tracer
+	// a no-op until the node initializes tracing
+	tracer trace.Tracer = noop.NewTracerProvider().Tracer("nex")
 )
 
 const (
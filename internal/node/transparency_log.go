@@ -0,0 +1,141 @@
+package nexnode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A bundle as produced by `cosign sign-blob --bundle`, of which only the transparency log
+// entry is used
+type cosignBundle struct {
+	RekorBundle *rekorBundle `json:"rekorBundle"`
+}
+
+// A Rekor log entry along with the log's signed promise to include it (its SET)
+type rekorBundle struct {
+	SignedEntryTimestamp string       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// The fields of a Rekor entry covered by its SET. They're declared in lexical order, so that
+// encoding them yields the canonical JSON the log signed
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// The body of a hashedrekord entry, recording the digest of the signed blob, the signature and the
+// signing certificate
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// Verifies that the bundle's transparency log entry was signed by one of the configured log keys
+// and records this signature, made by the given certificate over the artifact with the given digest,
+// returning the time at which the log integrated the entry
+func (c *ArtifactVerification) verifyTransparencyLogEntry(bundle string, signature string, cert *x509.Certificate, digest []byte) (time.Time, error) {
+	if len(c.TransparencyLogKeys) == 0 {
+		return time.Time{}, errors.New("transparency log entries are not trusted by this node")
+	}
+
+	var b cosignBundle
+	err := json.Unmarshal([]byte(bundle), &b)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse signature bundle: %s", err)
+	}
+	if b.RekorBundle == nil {
+		return time.Time{}, errors.New("signature bundle has no transparency log entry")
+	}
+
+	set, err := base64.StdEncoding.DecodeString(b.RekorBundle.SignedEntryTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode signed entry timestamp: %s", err)
+	}
+
+	payload, _ := json.Marshal(b.RekorBundle.Payload)
+	payloadDigest := sha256.Sum256(payload)
+
+	verified := false
+	for _, path := range c.TransparencyLogKeys {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read transparency log key: %s", err)
+		}
+
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("transparency log key %s is not PEM-encoded", path)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse transparency log key %s: %s", path, err)
+		}
+
+		if verifySignature(key, payload, payloadDigest[:], set) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return time.Time{}, errors.New("transparency log entry was not signed by any trusted log")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(b.RekorBundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode transparency log entry: %s", err)
+	}
+
+	var entry hashedRekord
+	err = json.Unmarshal(body, &entry)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse transparency log entry: %s", err)
+	}
+	if entry.Kind != "hashedrekord" {
+		return time.Time{}, fmt.Errorf("unsupported transparency log entry kind: %s", entry.Kind)
+	}
+
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || !strings.EqualFold(entry.Spec.Data.Hash.Value, hex.EncodeToString(digest)) {
+		return time.Time{}, errors.New("transparency log entry does not match the signed artifact")
+	}
+	if entry.Spec.Signature.Content != signature {
+		return time.Time{}, errors.New("transparency log entry does not match the artifact signature")
+	}
+
+	certPEM, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode transparency log entry certificate: %s", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || !bytes.Equal(block.Bytes, cert.Raw) {
+		return time.Time{}, errors.New("transparency log entry does not match the signing certificate")
+	}
+
+	return time.Unix(b.RekorBundle.Payload.IntegratedTime, 0), nil
+}
@@ -0,0 +1,154 @@
+package nexnode
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	// Work queue stream on the internal NATS server holding in-flight trigger messages
+	// for workloads with at-least-once trigger delivery
+	triggerStreamName    = "NEXTRIGGERS"
+	triggerStreamSubject = "nextrigger"
+
+	triggerMaxDeliver   = 5
+	triggerRedeliverMin = 500 * time.Millisecond
+
+	nexTriggerReply = "x-nex-trigger-reply"
+)
+
+// Subscribes to the workload's trigger subjects such that each trigger message is first persisted
+// to the internal trigger stream, and then delivered to the function from that stream until it
+// executes successfully. In-flight messages survive the failure of the agent, and are redelivered
+// to the workload when it's redeployed
+func (m *MachineManager) subscribeAtLeastOnceTriggers(vm *runningFirecracker, request *agentapi.DeployRequest) error {
+	js, err := m.ncInternal.JetStream()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.StreamInfo(triggerStreamName)
+	if err != nil {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        triggerStreamName,
+			Description: "In-flight trigger messages for workloads with at-least-once delivery",
+			Subjects:    []string{triggerStreamSubject + ".>"},
+			Retention:   nats.WorkQueuePolicy,
+			Storage:     nats.FileStorage,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create trigger stream: %s", err)
+		}
+	}
+
+	// keyed by workload (not vm) so a redeployed workload picks up where its predecessor left off
+	subject := fmt.Sprintf("%s.%s.%s", triggerStreamSubject, vm.namespace, *request.WorkloadName)
+	durable := fmt.Sprintf("%s_%s", vm.namespace, *request.WorkloadName)
+
+	sub, err := js.Subscribe(subject, m.generateDurableTriggerHandler(vm, request),
+		nats.Durable(durable),
+		nats.ManualAck(),
+		nats.AckWait(time.Millisecond*triggerTimeoutMillis+5*time.Second),
+		nats.MaxDeliver(triggerMaxDeliver),
+		nats.DeliverAll(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create durable trigger consumer: %s", err)
+	}
+	m.addMachineSubscription(vm.vmmID, sub)
+
+	for _, tsub := range request.TriggerSubjects {
+		sub, err := m.subscribeTrigger(request, tsub, m.generatePersistingTriggerHandler(js, subject))
+		if err != nil {
+			return err
+		}
+
+		m.log.Info("Created at-least-once trigger subject subscription for deployed workload",
+			slog.String("vmid", vm.vmmID),
+			slog.String("trigger_subject", tsub),
+			slog.String("workload_type", *request.WorkloadType),
+		)
+
+		m.addMachineSubscription(vm.vmmID, sub)
+	}
+
+	return nil
+}
+
+// Persists trigger messages to the internal trigger stream. Each message is assigned an idempotency
+// key (the publisher's Nats-Msg-Id, when present) which is passed along to the workload
+func (m *MachineManager) generatePersistingTriggerHandler(js nats.JetStreamContext, streamSubject string) func(msg *nats.Msg) {
+	return func(msg *nats.Msg) {
+		key := msg.Header.Get(nats.MsgIdHdr)
+		if key == "" {
+			key = nuid.Next()
+		}
+
+		persisted := nats.NewMsg(streamSubject)
+		persisted.Data = msg.Data
+		persisted.Header.Set(nats.MsgIdHdr, key)
+		persisted.Header.Set(nexIdempotencyKey, key)
+		persisted.Header.Set(nexTriggerSubject, msg.Subject)
+		if msg.Reply != "" {
+			persisted.Header.Set(nexTriggerReply, msg.Reply)
+		}
+
+		_, err := js.PublishMsg(persisted)
+		if err != nil {
+			m.log.Error("Failed to persist trigger message",
+				slog.String("trigger_subject", msg.Subject),
+				slog.Any("err", err),
+			)
+		}
+	}
+}
+
+// Delivers persisted trigger messages to the function, acknowledging (and thereby removing) them
+// once the function has executed successfully and negatively acknowledging them otherwise
+func (m *MachineManager) generateDurableTriggerHandler(vm *runningFirecracker, request *agentapi.DeployRequest) func(msg *nats.Msg) {
+	return func(msg *nats.Msg) {
+		tsub := msg.Header.Get(nexTriggerSubject)
+
+		trigger := nats.NewMsg(tsub)
+		trigger.Data = msg.Data
+		trigger.Header.Set(nexIdempotencyKey, msg.Header.Get(nexIdempotencyKey))
+
+		resp, err := m.executeTrigger(vm, tsub, request, trigger)
+		if err != nil {
+			meta, _ := msg.Metadata()
+			if meta != nil && meta.NumDelivered >= triggerMaxDeliver {
+				m.log.Error("Giving up on trigger message after maximum deliveries",
+					slog.String("vmid", vm.vmmID),
+					slog.String("trigger_subject", tsub),
+					slog.String("idempotency_key", msg.Header.Get(nexIdempotencyKey)),
+				)
+				_ = msg.Term()
+				return
+			}
+
+			var delivered uint64 = 1
+			if meta != nil {
+				delivered = meta.NumDelivered
+			}
+			_ = msg.NakWithDelay(triggerRedeliverMin * time.Duration(delivered))
+			return
+		}
+
+		if reply := msg.Header.Get(nexTriggerReply); reply != "" && resp != nil {
+			err = m.nc.Publish(reply, resp.Data)
+			if err != nil {
+				m.log.Warn("Failed to reply to trigger message", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+			}
+		}
+
+		err = msg.Ack()
+		if err != nil {
+			m.log.Warn("Failed to acknowledge trigger message", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+		}
+	}
+}
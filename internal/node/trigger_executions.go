@@ -0,0 +1,68 @@
+package nexnode
+
+import (
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Records the change in a function machine's trigger executions from its agent's previous report
+// to the given one as metrics. A nil report removes the machine's executions, e.g. once it stops
+func (m *MachineManager) recordTriggerExecutions(vm *runningFirecracker, previous *agentapi.TriggerExecutionReport, current *agentapi.TriggerExecutionReport) {
+	var before, after agentapi.TriggerExecutionReport
+	if previous != nil {
+		before = *previous
+	}
+	if current != nil {
+		after = *current
+	}
+
+	var saturated int64
+	switch {
+	case before.Queued == 0 && after.Queued > 0:
+		saturated = 1
+	case before.Queued > 0 && after.Queued == 0:
+		saturated = -1
+	}
+
+	// recorded in total, by namespace and by workload
+	options := [][]metric.AddOption{
+		nil,
+		{metric.WithAttributes(attribute.String("namespace", vm.namespace))},
+		{metric.WithAttributes(attribute.String("workload_name", *vm.deployRequest.WorkloadName))},
+	}
+	for _, opts := range options {
+		if delta := int64(after.Queued - before.Queued); delta != 0 {
+			m.t.functionAgentQueueDepth.Add(m.ctx, delta, opts...)
+		}
+		if delta := int64(after.Active - before.Active); delta != 0 {
+			m.t.functionActiveExecutions.Add(m.ctx, delta, opts...)
+		}
+		if saturated != 0 {
+			m.t.functionSaturatedMachineCount.Add(m.ctx, saturated, opts...)
+		}
+	}
+}
+
+// Returns the trigger executions of a function's machine as of its agent's last report, along
+// with the trigger messages waiting in the node's queue
+func (vm *runningFirecracker) triggerExecutionStatus() *controlapi.TriggerExecutionStatus {
+	if vm.lastMemoryReport == nil || vm.lastMemoryReport.Triggers == nil {
+		return nil
+	}
+
+	report := vm.lastMemoryReport.Triggers
+	status := &controlapi.TriggerExecutionStatus{
+		AgentQueued: report.Queued,
+		Active:      report.Active,
+		Executed:    report.Executed,
+		Saturated:   report.Queued > 0,
+		ReportedAt:  vm.lastMemoryReport.SampledAt,
+	}
+	if vm.triggerLimiter != nil {
+		status.NodeQueued = vm.triggerLimiter.queued()
+	}
+
+	return status
+}
@@ -0,0 +1,197 @@
+package nexnode
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Bounds the number of trigger messages a function executes concurrently. Trigger subscription
+// handlers enqueue messages rather than executing them, and a fixed number of workers execute
+// them in the order in which they arrived
+type triggerLimiter struct {
+	m         *MachineManager
+	limits    *agentapi.TriggerConcurrency
+	namespace string
+	workload  string
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queue   []queuedTrigger
+	stopped bool
+}
+
+type queuedTrigger struct {
+	msg     *nats.Msg
+	handler nats.MsgHandler
+}
+
+// Creates a limiter for the given function and starts its workers, which run until the
+// limiter is stopped
+func (m *MachineManager) newTriggerLimiter(namespace string, request *agentapi.DeployRequest) *triggerLimiter {
+	l := &triggerLimiter{
+		m:         m,
+		limits:    request.TriggerConcurrency,
+		namespace: namespace,
+		workload:  *request.WorkloadName,
+	}
+	l.cond = sync.NewCond(&l.mutex)
+
+	for i := 0; i < l.limits.MaxInFlight; i++ {
+		go l.work()
+	}
+
+	return l
+}
+
+// Wraps the given trigger handler such that it's executed by one of the limiter's workers
+func (l *triggerLimiter) wrap(handler nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		l.enqueue(queuedTrigger{msg: msg, handler: handler})
+	}
+}
+
+func (l *triggerLimiter) enqueue(trigger queuedTrigger) {
+	l.mutex.Lock()
+
+	var rejected *nats.Msg
+	if len(l.queue) >= l.limits.QueueCapacity() {
+		switch l.limits.OverflowPolicy() {
+		case agentapi.TriggerOverflowBlock:
+			for len(l.queue) >= l.limits.QueueCapacity() && !l.stopped {
+				l.cond.Wait()
+			}
+		case agentapi.TriggerOverflowDropOldest:
+			rejected = l.queue[0].msg
+			l.queue = l.queue[1:]
+			l.recordDepth(-1)
+		default:
+			l.mutex.Unlock()
+			l.reject(trigger.msg)
+			return
+		}
+	}
+
+	if l.stopped {
+		l.mutex.Unlock()
+		l.reject(trigger.msg)
+		return
+	}
+
+	l.queue = append(l.queue, trigger)
+	l.recordDepth(1)
+	l.cond.Broadcast()
+	l.mutex.Unlock()
+
+	if rejected != nil {
+		l.reject(rejected)
+	}
+}
+
+func (l *triggerLimiter) work() {
+	for {
+		l.mutex.Lock()
+		for len(l.queue) == 0 && !l.stopped {
+			l.cond.Wait()
+		}
+		if l.stopped {
+			l.mutex.Unlock()
+			return
+		}
+
+		trigger := l.queue[0]
+		l.queue = l.queue[1:]
+		l.recordDepth(-1)
+		// wakes handlers blocked on a full queue
+		l.cond.Broadcast()
+		l.mutex.Unlock()
+
+		trigger.handler(trigger.msg)
+	}
+}
+
+// Stops the limiter's workers, rejecting any trigger messages still waiting in its queue
+func (l *triggerLimiter) stop() {
+	l.mutex.Lock()
+	l.stopped = true
+	queued := l.queue
+	l.queue = nil
+	l.recordDepth(-int64(len(queued)))
+	l.cond.Broadcast()
+	l.mutex.Unlock()
+
+	for _, trigger := range queued {
+		l.reject(trigger.msg)
+	}
+}
+
+// Returns the number of trigger messages waiting for one of the limiter's workers
+func (l *triggerLimiter) queued() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.queue)
+}
+
+// Responds to a trigger message which won't be executed with an error, so requesters fail fast
+// rather than waiting for their request to time out
+func (l *triggerLimiter) reject(msg *nats.Msg) {
+	l.m.log.Warn("Rejected trigger message for function with a full trigger queue",
+		slog.String("workload_name", l.workload),
+		slog.String("trigger_subject", msg.Subject),
+		slog.String("overflow", l.limits.OverflowPolicy()),
+	)
+
+	l.m.t.functionRejectedTriggers.Add(l.m.ctx, 1)
+	l.m.t.functionRejectedTriggers.Add(l.m.ctx, 1, metric.WithAttributes(attribute.String("namespace", l.namespace)))
+	l.m.t.functionRejectedTriggers.Add(l.m.ctx, 1, metric.WithAttributes(attribute.String("workload_name", l.workload)))
+
+	if msg.Reply == "" {
+		return
+	}
+
+	resp := nats.NewMsg(msg.Reply)
+	resp.Header.Set(natsServiceError, "trigger queue is full")
+	resp.Header.Set(natsServiceErrorCode, "429")
+	_ = msg.RespondMsg(resp)
+}
+
+// Must be called with the limiter's mutex held
+func (l *triggerLimiter) recordDepth(delta int64) {
+	if delta == 0 {
+		return
+	}
+
+	l.m.t.functionTriggerQueueDepth.Add(l.m.ctx, delta)
+	l.m.t.functionTriggerQueueDepth.Add(l.m.ctx, delta, metric.WithAttributes(attribute.String("namespace", l.namespace)))
+	l.m.t.functionTriggerQueueDepth.Add(l.m.ctx, delta, metric.WithAttributes(attribute.String("workload_name", l.workload)))
+}
+
+func agentTriggerConcurrency(c *controlapi.TriggerConcurrency) *agentapi.TriggerConcurrency {
+	if c == nil {
+		return nil
+	}
+
+	return &agentapi.TriggerConcurrency{
+		MaxInFlight: c.MaxInFlight,
+		QueueSize:   c.QueueSize,
+		Overflow:    c.Overflow,
+	}
+}
+
+func controlTriggerConcurrency(c *agentapi.TriggerConcurrency) *controlapi.TriggerConcurrency {
+	if c == nil {
+		return nil
+	}
+
+	return &controlapi.TriggerConcurrency{
+		MaxInFlight: c.MaxInFlight,
+		QueueSize:   c.QueueSize,
+		Overflow:    c.Overflow,
+	}
+}
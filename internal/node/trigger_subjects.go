@@ -0,0 +1,194 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Subject prefixes of the nex control API, JetStream and the system account, which no
+// workload may subscribe to
+var reservedTriggerSubjectPrefixes = []string{controlapi.APIPrefix, "$JS", "$SYS"}
+
+const defaultMinLiteralTriggerTokens = 1
+
+func (p *TriggerSubjectPolicy) validate() []error {
+	errs := make([]error, 0)
+
+	for _, prefix := range p.ReservedPrefixes {
+		tokens, err := subjectTokens(prefix)
+		if err != nil || wildcardCount(tokens) > 0 {
+			errs = append(errs, fmt.Errorf("invalid reserved trigger subject prefix: %q", prefix))
+		}
+	}
+	if p.MinLiteralTokens < 0 {
+		errs = append(errs, errors.New("trigger subject policy minimum literal tokens must be >= 0"))
+	}
+	if p.MaxWildcards != nil && *p.MaxWildcards < 0 {
+		errs = append(errs, errors.New("trigger subject policy maximum wildcards must be >= 0"))
+	}
+
+	return errs
+}
+
+func (p *TriggerSubjectPolicy) minLiteralTokens() int {
+	if p == nil || p.MinLiteralTokens == 0 {
+		return defaultMinLiteralTriggerTokens
+	}
+	return p.MinLiteralTokens
+}
+
+// Splits a subject into its tokens, failing if it isn't a valid subscription subject
+func subjectTokens(subject string) ([]string, error) {
+	if subject == "" {
+		return nil, errors.New("subject is empty")
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, errors.New("subject contains whitespace")
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		if token == "" {
+			return nil, errors.New("subject contains an empty token")
+		}
+		if token == ">" && i != len(tokens)-1 {
+			return nil, errors.New("the > wildcard may only be the last token")
+		}
+	}
+	return tokens, nil
+}
+
+func wildcardCount(tokens []string) int {
+	count := 0
+	for _, token := range tokens {
+		if token == "*" || token == ">" {
+			count++
+		}
+	}
+	return count
+}
+
+// Whether any subject matching the given subscription tokens is the prefix or lies beneath it
+func overlapsPrefix(tokens []string, prefix []string) bool {
+	for i, p := range prefix {
+		if i == len(tokens) {
+			return false
+		}
+		if tokens[i] == ">" {
+			return true
+		}
+		if tokens[i] != "*" && tokens[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that the trigger subjects of a deploy request are valid, don't overlap any reserved
+// prefix and are no broader than the node's trigger subject policy permits
+func (api *ApiListener) checkTriggerSubjects(subjects []string) *controlapi.TriggerSubjectRejectedResponse {
+	policy := api.config.TriggerSubjectPolicy
+
+	reserved := reservedTriggerSubjectPrefixes
+	if policy != nil {
+		reserved = append(append([]string{}, reserved...), policy.ReservedPrefixes...)
+	}
+
+	seen := make(map[string]bool)
+	for _, subject := range subjects {
+		rejected := func(reason string, detail string) *controlapi.TriggerSubjectRejectedResponse {
+			return &controlapi.TriggerSubjectRejectedResponse{Subject: subject, Reason: reason, Detail: detail}
+		}
+
+		tokens, err := subjectTokens(subject)
+		if err != nil {
+			return rejected(controlapi.TriggerSubjectInvalid, err.Error())
+		}
+		if seen[subject] {
+			return rejected(controlapi.TriggerSubjectInvalid, "subject is given more than once")
+		}
+		seen[subject] = true
+
+		for _, prefix := range reserved {
+			if overlapsPrefix(tokens, strings.Split(prefix, ".")) {
+				return rejected(controlapi.TriggerSubjectReserved, fmt.Sprintf("subject overlaps the reserved prefix %s", prefix))
+			}
+		}
+
+		literals := 0
+		for literals < len(tokens) && tokens[literals] != "*" && tokens[literals] != ">" {
+			literals++
+		}
+		if wildcardCount(tokens) > 0 && literals < policy.minLiteralTokens() {
+			return rejected(controlapi.TriggerSubjectTooBroad, fmt.Sprintf("subject must begin with at least %d literal tokens", policy.minLiteralTokens()))
+		}
+		if policy == nil {
+			continue
+		}
+		if policy.MaxWildcards != nil && wildcardCount(tokens) > *policy.MaxWildcards {
+			return rejected(controlapi.TriggerSubjectTooBroad, fmt.Sprintf("subject may contain at most %d wildcards", *policy.MaxWildcards))
+		}
+		if policy.DenyFullWildcard && tokens[len(tokens)-1] == ">" {
+			return rejected(controlapi.TriggerSubjectTooBroad, "subject may not end with the > wildcard")
+		}
+	}
+
+	return nil
+}
+
+// Finds the first of the trigger subjects already claimed by a workload on the node other than
+// the named one, whose redeployments and replicas may share its subjects
+func (m *MachineManager) checkTriggerSubjectClaims(namespace string, workloadName string, subjects []string) *controlapi.TriggerSubjectRejectedResponse {
+	if len(subjects) == 0 || (m.config.TriggerSubjectPolicy != nil && m.config.TriggerSubjectPolicy.AllowShared) {
+		return nil
+	}
+
+	claimed := make(map[string]bool)
+	claim := func(ns string, name *string, tsubs []string) {
+		if ns == namespace && name != nil && *name == workloadName {
+			return
+		}
+		for _, tsub := range tsubs {
+			claimed[tsub] = true
+		}
+	}
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest != nil {
+			claim(vm.namespace, vm.deployRequest.WorkloadName, vm.deployRequest.TriggerSubjects)
+		}
+	}
+	for _, fn := range m.idleFunctionsSnapshot() {
+		claim(fn.namespace, fn.request.WorkloadName, fn.request.TriggerSubjects)
+	}
+
+	for _, subject := range subjects {
+		if claimed[subject] {
+			return &controlapi.TriggerSubjectRejectedResponse{
+				Subject: subject,
+				Reason:  controlapi.TriggerSubjectClaimed,
+				Detail:  "subject is already claimed by another workload on this node",
+			}
+		}
+	}
+
+	return nil
+}
+
+func (api *ApiListener) respondTriggerSubjectRejected(m *nats.Msg, rejected *controlapi.TriggerSubjectRejectedResponse) {
+	api.log.Warn("Trigger subject rejected",
+		slog.String("trigger_subject", rejected.Subject),
+		slog.String("reason", rejected.Reason),
+	)
+
+	reason := rejected.Error()
+	env := controlapi.NewEnvelope(controlapi.TriggerSubjectRejectedResponseType, rejected, &reason)
+	env.Code = controlapi.ErrorCodeTriggerSubjectRejected
+	raw, _ := json.Marshal(env)
+	_ = m.Respond(raw)
+}
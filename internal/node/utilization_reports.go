@@ -0,0 +1,302 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	defaultUtilizationReportIntervalSeconds = 24 * 3600
+
+	// Running machines are sampled this often (or once per report, if reports are more frequent)
+	utilizationSampleInterval = time.Minute
+)
+
+func (r *UtilizationReports) interval() time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return defaultUtilizationReportIntervalSeconds * time.Second
+}
+
+// Utilization accumulated since the start of the current reporting period. Allocated resources
+// are integrated by sampling the node's machines; deploys and triggers are counted as they happen
+type utilizationUsage struct {
+	mutex sync.Mutex
+
+	periodStart time.Time
+	lastSample  time.Time
+
+	vcpuSeconds      float64
+	memoryMibSeconds float64
+	workloadSeconds  float64
+	peakWorkloads    int
+	// host CPU time used by the machines of workloads, when the node places machines in cgroups
+	cpuSeconds float64
+
+	namespaces map[string]*controlapi.NamespaceUtilization
+	// keyed by namespace and then workload name; only kept when the node places machines in cgroups
+	workloads map[string]map[string]*controlapi.WorkloadUtilization
+}
+
+func newUtilizationUsage() *utilizationUsage {
+	now := time.Now().UTC()
+	return &utilizationUsage{
+		periodStart: now,
+		lastSample:  now,
+		namespaces:  make(map[string]*controlapi.NamespaceUtilization),
+		workloads:   make(map[string]map[string]*controlapi.WorkloadUtilization),
+	}
+}
+
+// Must be called with the mutex held
+func (u *utilizationUsage) namespace(namespace string) *controlapi.NamespaceUtilization {
+	usage, ok := u.namespaces[namespace]
+	if !ok {
+		usage = &controlapi.NamespaceUtilization{Namespace: namespace}
+		u.namespaces[namespace] = usage
+	}
+	return usage
+}
+
+// Must be called with the mutex held
+func (u *utilizationUsage) workload(namespace string, name string) *controlapi.WorkloadUtilization {
+	u.namespace(namespace)
+
+	workloads, ok := u.workloads[namespace]
+	if !ok {
+		workloads = make(map[string]*controlapi.WorkloadUtilization)
+		u.workloads[namespace] = workloads
+	}
+	usage, ok := workloads[name]
+	if !ok {
+		usage = &controlapi.WorkloadUtilization{Name: name}
+		workloads[name] = usage
+	}
+	return usage
+}
+
+// Applies the given update to the utilization of the given namespace in the current period, if
+// the node reports utilization
+func (m *MachineManager) recordUtilization(namespace string, update func(usage *controlapi.NamespaceUtilization)) {
+	if m.utilization == nil {
+		return
+	}
+
+	m.utilization.mutex.Lock()
+	defer m.utilization.mutex.Unlock()
+
+	update(m.utilization.namespace(namespace))
+}
+
+// Periodically samples the node's machines and publishes a utilization report at the end of each
+// reporting period until the machine manager is stopped
+func (m *MachineManager) reportUtilization() {
+	interval := m.config.UtilizationReports.interval()
+
+	sampler := time.NewTicker(min(utilizationSampleInterval, interval))
+	defer sampler.Stop()
+
+	reporter := time.NewTicker(interval)
+	defer reporter.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-sampler.C:
+			m.sampleUtilization()
+		case <-reporter.C:
+			if m.config.Cgroups != nil {
+				m.sampleCPUUsage()
+			}
+			m.sampleUtilization()
+			m.publishUtilizationReport(m.takeUtilizationReport())
+		}
+	}
+}
+
+// Integrates the resources allocated to the node's machines since the last sample
+func (m *MachineManager) sampleUtilization() {
+	now := time.Now().UTC()
+
+	m.utilization.mutex.Lock()
+	defer m.utilization.mutex.Unlock()
+
+	elapsed := now.Sub(m.utilization.lastSample).Seconds()
+	m.utilization.lastSample = now
+
+	workloads := 0
+	perNamespace := make(map[string]int)
+	for _, vm := range m.runningMachines() {
+		vcpuSeconds := float64(vm.vcpuCount) * elapsed
+		memoryMibSeconds := float64(vm.memSizeMib) * elapsed
+
+		m.utilization.vcpuSeconds += vcpuSeconds
+		m.utilization.memoryMibSeconds += memoryMibSeconds
+
+		if vm.deployRequest == nil {
+			// warm machines count towards the node's utilization only
+			continue
+		}
+
+		workloads++
+		perNamespace[vm.namespace]++
+
+		usage := m.utilization.namespace(vm.namespace)
+		usage.VCPUSeconds += vcpuSeconds
+		usage.MemoryMibSeconds += memoryMibSeconds
+
+		if vm.cgroup != "" && vm.deployRequest.WorkloadName != nil {
+			m.utilization.workload(vm.namespace, *vm.deployRequest.WorkloadName).VCPUSeconds += vcpuSeconds
+		}
+	}
+
+	m.utilization.workloadSeconds += float64(workloads) * elapsed
+	m.utilization.peakWorkloads = max(m.utilization.peakWorkloads, workloads)
+	for namespace, count := range perNamespace {
+		usage := m.utilization.namespace(namespace)
+		usage.PeakWorkloads = max(usage.PeakWorkloads, count)
+	}
+}
+
+// Summarizes the utilization accumulated over the current period and starts a new one
+func (m *MachineManager) takeUtilizationReport() *controlapi.UtilizationReport {
+	m.utilization.mutex.Lock()
+	defer m.utilization.mutex.Unlock()
+
+	usage := m.utilization
+	report := &controlapi.UtilizationReport{
+		NodeId:                    m.publicKey,
+		PeriodStart:               usage.periodStart,
+		PeriodEnd:                 usage.lastSample,
+		VCPUs:                     runtime.NumCPU(),
+		AllocatedVCPUSeconds:      usage.vcpuSeconds,
+		AllocatedMemoryMibSeconds: usage.memoryMibSeconds,
+		PeakWorkloads:             usage.peakWorkloads,
+		Namespaces:                make([]controlapi.NamespaceUtilization, 0, len(usage.namespaces)),
+	}
+
+	stats, err := ReadMemoryStats()
+	if err == nil {
+		// meminfo reports kB
+		report.MemoryMib = int64(stats.MemTotal / 1024)
+	}
+
+	period := report.PeriodEnd.Sub(report.PeriodStart).Seconds()
+	if period > 0 {
+		report.AverageWorkloads = usage.workloadSeconds / period
+		report.VCPUUtilization = utilizationPercent(usage.vcpuSeconds, float64(report.VCPUs)*period)
+		report.MemoryUtilization = utilizationPercent(usage.memoryMibSeconds, float64(report.MemoryMib)*period)
+		if usage.cpuSeconds > 0 {
+			report.CPUSeconds = usage.cpuSeconds
+			report.CPUUtilization = utilizationPercent(usage.cpuSeconds, float64(report.VCPUs)*period)
+		}
+	}
+
+	for _, ns := range usage.namespaces {
+		for _, workload := range usage.workloads[ns.Namespace] {
+			ns.Workloads = append(ns.Workloads, *workload)
+		}
+		sort.Slice(ns.Workloads, func(i, j int) bool {
+			return ns.Workloads[i].Name < ns.Workloads[j].Name
+		})
+		report.Namespaces = append(report.Namespaces, *ns)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		return report.Namespaces[i].Namespace < report.Namespaces[j].Namespace
+	})
+
+	m.utilization.periodStart = usage.lastSample
+	m.utilization.vcpuSeconds = 0
+	m.utilization.memoryMibSeconds = 0
+	m.utilization.workloadSeconds = 0
+	m.utilization.peakWorkloads = 0
+	m.utilization.cpuSeconds = 0
+	m.utilization.namespaces = make(map[string]*controlapi.NamespaceUtilization)
+	m.utilization.workloads = make(map[string]map[string]*controlapi.WorkloadUtilization)
+
+	return report
+}
+
+func utilizationPercent(used float64, available float64) float64 {
+	if available <= 0 {
+		return 0
+	}
+	return math.Round(used/available*10000) / 100
+}
+
+func (m *MachineManager) publishUtilizationReport(report *controlapi.UtilizationReport) {
+	m.log.Info("Utilization report",
+		slog.Time("period_start", report.PeriodStart),
+		slog.Time("period_end", report.PeriodEnd),
+		slog.Float64("vcpu_utilization", report.VCPUUtilization),
+		slog.Float64("memory_utilization", report.MemoryUtilization),
+		slog.Int("peak_workloads", report.PeakWorkloads),
+		slog.Int("namespaces", len(report.Namespaces)),
+	)
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(report.PeriodEnd)
+	cloudevent.SetType(controlapi.UtilizationReportEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(report)
+
+	err := PublishCloudEvent(m.nc, controlapi.SystemNamespace, cloudevent, m.log)
+	if err != nil {
+		m.log.Warn("Failed to publish utilization report event", slog.Any("err", err))
+	}
+
+	if bucket := m.config.UtilizationReports.Bucket; bucket != "" {
+		err = m.storeUtilizationReport(bucket, report)
+		if err != nil {
+			m.log.Warn("Failed to store utilization report", slog.String("bucket", bucket), slog.Any("err", err))
+		}
+	}
+}
+
+// Stores the report in the given object store bucket, which is created if it doesn't exist
+func (m *MachineManager) storeUtilizationReport(bucket string, report *controlapi.UtilizationReport) error {
+	js, err := m.nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	store, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrBucketNotFound) {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      bucket,
+			Description: "Utilization reports of nex nodes",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.PutBytes(utilizationReportName(report), raw)
+	return err
+}
+
+// Returns the name under which a utilization report is stored: {node id}/{period end}.json
+func utilizationReportName(report *controlapi.UtilizationReport) string {
+	return fmt.Sprintf("%s/%s.json", report.NodeId, report.PeriodEnd.UTC().Format("20060102T150405Z"))
+}
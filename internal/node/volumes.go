@@ -0,0 +1,214 @@
+package nexnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VolumeTypeFile = "file"
+	VolumeTypeLVM  = "lvm"
+
+	defaultMaxVolumeSizeMib = 1024
+	volumeCommandTimeout    = 30 * time.Second
+
+	// The nex user, which the agent runs workloads as, owns the root directory of each volume
+	volumeRootOwner = "1000:1000"
+)
+
+// Namespaces and workload names are used as file and logical volume names
+var validVolumeName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (c *Volumes) volumeType() string {
+	if c.Type != "" {
+		return strings.ToLower(c.Type)
+	}
+	return VolumeTypeFile
+}
+
+func (c *Volumes) maxSizeMib() int {
+	if c.MaxSizeMib > 0 {
+		return c.MaxSizeMib
+	}
+	return defaultMaxVolumeSizeMib
+}
+
+func (c *Volumes) validate() []error {
+	errs := make([]error, 0)
+
+	switch c.volumeType() {
+	case VolumeTypeFile:
+		if !filepath.IsAbs(c.Directory) {
+			errs = append(errs, fmt.Errorf("volume directory must be an absolute path: %s", c.Directory))
+		}
+	case VolumeTypeLVM:
+		if c.VolumeGroup == "" {
+			errs = append(errs, errors.New("lvm volumes require a volume group"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported volume type: %s", c.Type))
+	}
+
+	if c.MaxSizeMib < 0 {
+		errs = append(errs, errors.New("max volume size must be >= 0"))
+	}
+
+	return errs
+}
+
+// A workload's persistent volume, attached to the machine the workload is deployed to
+type workloadVolume struct {
+	key  string
+	path string
+}
+
+// Persistent volumes of service workloads, keyed by {namespace}/{workload}. Volumes are provisioned
+// with an ext4 filesystem when first requested and are never deleted by the node. A volume is
+// attached to at most one machine at a time
+type volumeStore struct {
+	config *Volumes
+	log    *slog.Logger
+
+	mutex    sync.Mutex
+	attached map[string]*workloadVolume
+}
+
+func newVolumeStore(config *Volumes, log *slog.Logger) *volumeStore {
+	return &volumeStore{
+		config:   config,
+		log:      log,
+		attached: make(map[string]*workloadVolume),
+	}
+}
+
+// Returns the given workload's volume, provisioning it with the given size if it doesn't exist
+// yet. Existing volumes keep the size they were provisioned with. The volume is reserved for the
+// caller's machine until it's released
+func (s *volumeStore) acquire(namespace string, workload string, sizeMib int) (*workloadVolume, error) {
+	if !validVolumeName.MatchString(namespace) || !validVolumeName.MatchString(workload) {
+		return nil, fmt.Errorf("invalid volume name: %s/%s", namespace, workload)
+	}
+	if sizeMib < 1 || sizeMib > s.config.maxSizeMib() {
+		return nil, fmt.Errorf("volume size must be between 1 and %d MiB", s.config.maxSizeMib())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := fmt.Sprintf("%s/%s", namespace, workload)
+	if _, ok := s.attached[key]; ok {
+		return nil, fmt.Errorf("volume %s is attached to another machine", key)
+	}
+
+	var path string
+	var err error
+	switch s.config.volumeType() {
+	case VolumeTypeLVM:
+		path, err = s.provisionLogicalVolume(namespace, workload, sizeMib)
+	default:
+		path, err = s.provisionFile(namespace, workload, sizeMib)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	volume := &workloadVolume{key: key, path: path}
+	s.attached[key] = volume
+	return volume, nil
+}
+
+// Releases the volume once the machine it was attached to has stopped
+func (s *volumeStore) release(volume *workloadVolume) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.attached[volume.key] == volume {
+		delete(s.attached, volume.key)
+	}
+}
+
+// Provisions the volume as a sparse file, {directory}/{namespace}/{workload}.ext4
+func (s *volumeStore) provisionFile(namespace string, workload string, sizeMib int) (string, error) {
+	path := filepath.Join(s.config.Directory, namespace, fmt.Sprintf("%s.ext4", workload))
+
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.Size() != int64(sizeMib)*1024*1024 {
+			s.log.Warn("Existing volume differs in size from request", slog.String("path", path), slog.Int64("size_bytes", info.Size()))
+		}
+		return path, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume directory: %s", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume: %s", err)
+	}
+	err = f.Truncate(int64(sizeMib) * 1024 * 1024)
+	_ = f.Close()
+	if err == nil {
+		err = makeVolumeFilesystem(path)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	s.log.Info("Provisioned volume", slog.String("path", path), slog.Int("size_mib", sizeMib))
+	return path, nil
+}
+
+// Provisions the volume as a logical volume, nex.{namespace}.{workload}, in the volume group
+func (s *volumeStore) provisionLogicalVolume(namespace string, workload string, sizeMib int) (string, error) {
+	name := fmt.Sprintf("nex.%s.%s", namespace, workload)
+	path := filepath.Join("/dev", s.config.VolumeGroup, name)
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	err := runVolumeCommand("lvcreate", "-y", "-n", name, "-L", fmt.Sprintf("%dm", sizeMib), s.config.VolumeGroup)
+	if err != nil {
+		return "", err
+	}
+
+	err = makeVolumeFilesystem(path)
+	if err != nil {
+		_ = runVolumeCommand("lvremove", "-y", fmt.Sprintf("%s/%s", s.config.VolumeGroup, name))
+		return "", err
+	}
+
+	s.log.Info("Provisioned volume", slog.String("path", path), slog.Int("size_mib", sizeMib))
+	return path, nil
+}
+
+func makeVolumeFilesystem(path string) error {
+	return runVolumeCommand("mkfs.ext4", "-q", "-F", "-E", fmt.Sprintf("root_owner=%s", volumeRootOwner), path)
+}
+
+func runVolumeCommand(name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), volumeCommandTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %s: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package nexnode
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	webhookPathPrefix          = "/webhooks/"
+	defaultWebhookMaxBodyBytes = 1 << 20
+
+	// Trigger executions time out after triggerTimeoutMillis, without a response to the requester
+	webhookTriggerTimeout = time.Millisecond*triggerTimeoutMillis + 2*time.Second
+)
+
+func (c *WebhookReceiver) validate() []error {
+	errs := make([]error, 0)
+	if c.Listen == "" {
+		errs = append(errs, errors.New("webhook receiver listen address is required"))
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid webhook receiver public url: %s", c.PublicURL))
+		}
+	}
+	if c.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("webhook receiver max body bytes must be >= 0"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("webhook receiver requires both a tls certificate and key, or neither"))
+	}
+	return errs
+}
+
+func (c *WebhookReceiver) maxBodyBytes() int64 {
+	if c.MaxBodyBytes == 0 {
+		return defaultWebhookMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// The URL at which the given workload's webhook is reached, if the receiver's public URL is known
+func (c *WebhookReceiver) webhookURL(namespace string, workload string) string {
+	if c == nil || c.PublicURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%s%s/%s", strings.TrimSuffix(c.PublicURL, "/"), webhookPathPrefix, url.PathEscape(namespace), url.PathEscape(workload))
+}
+
+// Validates the webhook of a deploy request, returning the subject its requests are delivered on
+func validateWebhookTrigger(webhook *controlapi.WebhookTrigger, triggerSubjects []string) (string, error) {
+	digest, err := hex.DecodeString(webhook.TokenSha256)
+	if err != nil || len(digest) != sha256.Size {
+		return "", errors.New("webhook token digest must be a hex-encoded SHA-256 digest")
+	}
+	if len(triggerSubjects) == 0 {
+		return "", errors.New("webhooks require trigger subjects")
+	}
+
+	subject := triggerSubjects[0]
+	if webhook.Subject != nil {
+		subject = *webhook.Subject
+	}
+
+	tokens, err := subjectTokens(subject)
+	if err != nil || wildcardCount(tokens) > 0 {
+		return "", fmt.Errorf("webhook subject must be a literal subject: %s", subject)
+	}
+	for _, tsub := range triggerSubjects {
+		if server.SubjectsCollide(subject, tsub) {
+			return subject, nil
+		}
+	}
+	return "", fmt.Errorf("webhook subject %s matches none of the trigger subjects", subject)
+}
+
+func agentWebhookTrigger(webhook *controlapi.WebhookTrigger, subject string) *agentapi.WebhookTrigger {
+	if webhook == nil {
+		return nil
+	}
+	return &agentapi.WebhookTrigger{
+		TokenSha256: strings.ToLower(webhook.TokenSha256),
+		Subject:     subject,
+	}
+}
+
+func controlWebhookTrigger(webhook *agentapi.WebhookTrigger) *controlapi.WebhookTrigger {
+	if webhook == nil {
+		return nil
+	}
+	return &controlapi.WebhookTrigger{
+		TokenSha256: webhook.TokenSha256,
+		Subject:     &webhook.Subject,
+	}
+}
+
+// Returns the webhook of the named function deployed to the node, including functions scaled to
+// zero, if it has one
+func (m *MachineManager) workloadWebhook(namespace string, workload string) *agentapi.WebhookTrigger {
+	requests := make([]*agentapi.DeployRequest, 0)
+	for _, fn := range m.idleFunctionsSnapshot() {
+		requests = append(requests, fn.request)
+	}
+	for _, vm := range m.runningMachines() {
+		if vm.deployRequest != nil {
+			requests = append(requests, vm.deployRequest)
+		}
+	}
+
+	for _, request := range requests {
+		if request.Webhook != nil && request.Namespace != nil && *request.Namespace == namespace &&
+			request.WorkloadName != nil && *request.WorkloadName == workload {
+			return request.Webhook
+		}
+	}
+	return nil
+}
+
+// Serves the node's webhook receiver until the context is done. Requests are delivered to the
+// function as requests on its webhook subject, such that they take the same path as any other
+// trigger message: trigger concurrency limits, queue groups and cold starts of idle functions
+// all apply
+func (m *MachineManager) serveWebhooks(ctx context.Context) error {
+	config := m.config.Webhooks
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookPathPrefix, m.handleWebhook)
+	srv := &http.Server{
+		Addr:              config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	m.log.Info("Webhook receiver listening", slog.String("addr", config.Listen), slog.Bool("tls", config.TLSCertFile != ""))
+
+	var err error
+	if config.TLSCertFile != "" {
+		err = srv.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (m *MachineManager) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, webhookPathPrefix), "/")
+	if len(path) != 2 || path[0] == "" || path[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, workload := path[0], path[1]
+
+	webhook := m.workloadWebhook(namespace, workload)
+	if webhook == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	digest := sha256.Sum256([]byte(token))
+	if token == "" || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(webhook.TokenSha256)) != 1 {
+		m.log.Warn("Rejected webhook request with an invalid token",
+			slog.String("namespace", namespace),
+			slog.String("workload_name", workload),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, m.config.Webhooks.maxBodyBytes()))
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	msg := nats.NewMsg(webhook.Subject)
+	msg.Data = body
+	// lets functions recognize a delivery the sender has retried
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		msg.Header.Set(nexIdempotencyKey, key)
+	}
+
+	resp, err := m.nc.RequestMsg(msg, webhookTriggerTimeout)
+	if err != nil {
+		m.log.Warn("Webhook trigger request failed",
+			slog.String("namespace", namespace),
+			slog.String("workload_name", workload),
+			slog.String("trigger_subject", webhook.Subject),
+			slog.Any("err", err),
+		)
+
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, nats.ErrNoResponders):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, nats.ErrTimeout):
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, "function could not be triggered", status)
+		return
+	}
+
+	if description := resp.Header.Get(natsServiceError); description != "" {
+		status, _ := strconv.Atoi(resp.Header.Get(natsServiceErrorCode))
+		if status < 400 || status > 599 {
+			status = http.StatusBadGateway
+		}
+		http.Error(w, description, status)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.Data)
+}
@@ -0,0 +1,256 @@
+package nexnode
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Number of the most recent machine timeline entries included in a workload description
+const describedEventCount = 50
+
+// Describes the workload with the given ID in the given namespace, returning nil if there's no
+// such workload. Functions which have scaled to zero are known by the ID of the machine they were
+// originally deployed to, and are described as they were last deployed
+func (m *MachineManager) describeWorkload(id string, namespace string) *controlapi.DescribeResponse {
+	var vm *runningFirecracker
+	var request *agentapi.DeployRequest
+	state := controlapi.MachineStateScaledToZero
+
+	if fn, ok := m.idleFunctionByID(id); ok && fn.namespace == namespace {
+		fn.mutex.Lock()
+		vm = fn.vm
+		request = fn.request
+		fn.mutex.Unlock()
+	} else if vm = m.LookupMachine(id); vm == nil || vm.namespace != namespace || vm.deployRequest == nil {
+		return nil
+	}
+
+	if vm != nil && vm.deployRequest != nil {
+		request = vm.deployRequest
+		state = vm.state().String()
+	}
+
+	template := machineTemplateName(request.Template())
+
+	res := &controlapi.DescribeResponse{
+		NodeId:          m.publicKey,
+		NodeVersion:     VERSION,
+		Namespace:       namespace,
+		MachineId:       id,
+		MachineTemplate: template,
+		State:           state,
+		Healthy:         true,
+		Workload: controlapi.WorkloadSummary{
+			Name:         request.DecodedClaims.Subject,
+			WorkloadType: *request.WorkloadType,
+			Hash:         request.Hash,
+		},
+		Labels:          request.Labels,
+		TriggerSubjects: request.TriggerSubjects,
+	}
+	if request.Description != nil {
+		res.Workload.Description = *request.Description
+	}
+	if request.RetryCount != nil {
+		res.RetryCount = *request.RetryCount
+	}
+	for _, result := range request.ScanResults {
+		res.ScanResults = append(res.ScanResults, controlapi.ArtifactScanResult{
+			Scanner: result.Scanner,
+			Passed:  result.Passed,
+			Message: result.Message,
+		})
+	}
+
+	if vm != nil {
+		now := time.Now().UTC()
+		machineStarted := vm.machineStarted
+		workloadStarted := vm.workloadStarted
+
+		res.MachineId = vm.vmmID
+		res.IP = vm.ip.String()
+		res.DNSName = vm.dnsName
+		res.Healthy = vm.healthy()
+		res.Ready = vm.isReady()
+		res.MachineStarted = &machineStarted
+		res.WorkloadStarted = &workloadStarted
+		res.Workload.Runtime = myUptime(now.Sub(vm.workloadStarted))
+		if vm.boot.complete {
+			boot := vm.boot.timings
+			res.Boot = &boot
+		}
+		res.Resources = controlapi.WorkloadResources{
+			VCPU:          vm.vcpuCount,
+			MemoryMib:     vm.memSizeMib,
+			DeployedBytes: request.TotalBytes,
+		}
+		if vm.lastMemoryReport != nil {
+			res.Resources.UsedMemoryMib = vm.lastMemoryReport.UsedMib
+			res.Resources.PeakMemoryMib = vm.lastMemoryReport.PeakMib
+		}
+		if vm.cgroup != "" {
+			if usage, err := readCgroupUsage(vm.cgroup); err == nil {
+				res.Resources.HostMemoryMib = usage.memoryBytes / (1024 * 1024)
+				res.Resources.HostCPUSeconds = usage.cpuUsec / 1000000
+				res.Resources.HostCPUThrottledSeconds = usage.cpuThrottledUsec / 1000000
+				if usage.cpuPeriods > 0 {
+					res.Resources.HostCPUThrottledPercent = math.Round(float64(usage.cpuThrottledPeriods)/float64(usage.cpuPeriods)*10000) / 100
+				}
+			}
+		}
+		res.CronTriggers = vm.cronTriggerStatus()
+
+		if result := vm.lastHealthCheck.Load(); result != nil {
+			checkedAt := result.CheckedAt
+			res.LastHealthCheck = &checkedAt
+			if result.Message != nil {
+				res.HealthMessage = *result.Message
+			}
+		}
+	}
+
+	for key := range request.Environment {
+		res.EnvironmentKeys = append(res.EnvironmentKeys, key)
+	}
+	sort.Strings(res.EnvironmentKeys)
+
+	redacted := controlDeployRequest(request)
+	redacted.Environment = nil
+	redacted.SealedEnvironment = nil
+	redacted.WorkloadJwt = nil
+	res.Request = *redacted
+
+	effective := controlDeployRequest(request)
+	effective.Environment = nil
+	effective.SealedEnvironment = nil
+	effective.WorkloadJwt = nil
+	res.Defaulted = m.applyEffectiveDefaults(effective, namespace)
+	res.Effective = *effective
+
+	events := m.machineTimeline(res.MachineId, namespace)
+	if len(events) > describedEventCount {
+		events = events[len(events)-describedEventCount:]
+	}
+	res.Events = events
+
+	if split := m.updates.splitOf(id); split != nil {
+		res.Canary = split.status()
+	}
+
+	return res
+}
+
+// Fills in the defaults the node applies to options left unset in the given deploy request (and
+// the limits it applies to credential lifetimes), returning the names of the affected options
+func (m *MachineManager) applyEffectiveDefaults(request *controlapi.DeployRequest, namespace string) []string {
+	defaulted := make([]string, 0)
+
+	if request.MachineTemplate == nil || *request.MachineTemplate == "" {
+		template := DefaultMachineTemplate
+		request.MachineTemplate = &template
+		defaulted = append(defaulted, "machine_template")
+	}
+
+	if config, ok := m.templates[*request.MachineTemplate]; ok {
+		if request.VcpuCount == nil {
+			request.VcpuCount = config.MachineTemplate.VcpuCount
+			defaulted = append(defaulted, "vcpu_count")
+		}
+		if request.MemSizeMib == nil {
+			request.MemSizeMib = config.MachineTemplate.MemSizeMib
+			defaulted = append(defaulted, "memsize_mib")
+		}
+	}
+
+	if len(request.TriggerSubjects) > 0 && request.TriggerDelivery == nil {
+		delivery := agentapi.TriggerDeliveryAtMostOnce
+		request.TriggerDelivery = &delivery
+		defaulted = append(defaulted, "trigger_delivery")
+	}
+
+	if c := request.TriggerConcurrency; c != nil {
+		if c.QueueSize == 0 {
+			c.QueueSize = agentapi.DefaultTriggerQueueSize
+			defaulted = append(defaulted, "trigger_concurrency.queue_size")
+		}
+		if c.Overflow == "" {
+			c.Overflow = agentapi.TriggerOverflowReject
+			defaulted = append(defaulted, "trigger_concurrency.overflow")
+		}
+	}
+
+	if hc := request.HealthCheck; hc != nil {
+		if hc.IntervalMillis == 0 {
+			hc.IntervalMillis = agentapi.DefaultHealthCheckIntervalMillis
+			defaulted = append(defaulted, "health_check.interval_ms")
+		}
+		if hc.TimeoutMillis == 0 {
+			hc.TimeoutMillis = agentapi.DefaultHealthCheckTimeoutMillis
+			defaulted = append(defaulted, "health_check.timeout_ms")
+		}
+	}
+
+	if probe := request.ReadinessProbe; probe != nil {
+		if probe.IntervalMillis == 0 {
+			probe.IntervalMillis = agentapi.DefaultReadinessProbeIntervalMillis
+			defaulted = append(defaulted, "readiness_probe.interval_ms")
+		}
+		if probe.TimeoutMillis == 0 {
+			probe.TimeoutMillis = agentapi.DefaultHealthCheckTimeoutMillis
+			defaulted = append(defaulted, "readiness_probe.timeout_ms")
+		}
+	}
+
+	if limit := request.MemorySoftLimit; limit != nil && limit.IntervalMillis == 0 {
+		limit.IntervalMillis = agentapi.DefaultMemorySoftLimitIntervalMillis
+		defaulted = append(defaulted, "memory_soft_limit.interval_ms")
+	}
+
+	if policy := request.EgressPolicy; policy != nil {
+		for i := range policy.Allow {
+			if policy.Allow[i].Protocol == "" {
+				policy.Allow[i].Protocol = agentapi.EgressProtocolTCP
+				defaulted = append(defaulted, fmt.Sprintf("egress_policy.allow[%d].protocol", i))
+			}
+		}
+	}
+
+	if request.SandboxProfile == nil && agentapi.IsFunctionWorkloadType(*request.WorkloadType) {
+		profile := m.config.SandboxProfiles.defaultProfile(namespace)
+		request.SandboxProfile = &profile
+		defaulted = append(defaulted, "sandbox_profile")
+	}
+
+	if hook := request.PreStartHook; hook != nil && hook.TimeoutMillis == 0 {
+		hook.TimeoutMillis = agentapi.DefaultWorkloadHookTimeoutMillis
+		defaulted = append(defaulted, "pre_start_hook.timeout_ms")
+	}
+
+	if hook := request.PostStopHook; hook != nil && hook.TimeoutMillis == 0 {
+		hook.TimeoutMillis = agentapi.DefaultWorkloadHookTimeoutMillis
+		defaulted = append(defaulted, "post_stop_hook.timeout_ms")
+	}
+
+	for i := range request.CronTriggers {
+		if request.CronTriggers[i].Timezone == nil {
+			utc := time.UTC.String()
+			request.CronTriggers[i].Timezone = &utc
+			defaulted = append(defaulted, fmt.Sprintf("cron_triggers[%d].timezone", i))
+		}
+	}
+
+	if creds := request.Credentials; creds != nil && m.config.WorkloadCredentials != nil {
+		maxTTL := m.config.WorkloadCredentials.maxTTLSeconds()
+		if creds.TTLSeconds <= 0 || creds.TTLSeconds > maxTTL {
+			creds.TTLSeconds = maxTTL
+			defaulted = append(defaulted, "credentials.ttl_seconds")
+		}
+	}
+
+	return defaulted
+}
@@ -0,0 +1,287 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	recoverableWorkloadsDir = "workloads"
+	recoveryXKeyFile        = "xkey.seed"
+)
+
+func (c *WorkloadRecovery) validate() []error {
+	errs := make([]error, 0)
+
+	if !filepath.IsAbs(c.Directory) {
+		errs = append(errs, fmt.Errorf("workload recovery directory must be an absolute path: %s", c.Directory))
+	}
+
+	return errs
+}
+
+// A workload the node was running, as recorded to be redeployed once the node restarts
+type recoverableWorkload struct {
+	// the ID of the machine the workload was deployed to, or, for functions which scale to zero
+	// when idle, the ID by which the function is known
+	MachineId    string                    `json:"machine_id"`
+	Namespace    string                    `json:"namespace"`
+	WorkloadName string                    `json:"workload_name"`
+	DeployedAt   time.Time                 `json:"deployed_at"`
+	Request      *controlapi.DeployRequest `json:"request"`
+}
+
+// Records the workloads the node is running, one file per workload, {directory}/workloads/{id}.json
+type workloadStateStore struct {
+	dir string
+	log *slog.Logger
+
+	mutex sync.Mutex
+}
+
+func newWorkloadStateStore(config *WorkloadRecovery, log *slog.Logger) (*workloadStateStore, error) {
+	err := os.MkdirAll(filepath.Join(config.Directory, recoverableWorkloadsDir), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workload recovery directory: %s", err)
+	}
+
+	return &workloadStateStore{
+		dir: config.Directory,
+		log: log,
+	}, nil
+}
+
+// Returns the node's xkey, generating it the first time the node runs with this directory
+func (s *workloadStateStore) xkey() (nkeys.KeyPair, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := filepath.Join(s.dir, recoveryXKeyFile)
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		return nkeys.FromSeed([]byte(strings.TrimSpace(string(seed))))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	kp, err := nkeys.CreateCurveKeys()
+	if err != nil {
+		return nil, err
+	}
+	seed, err = kp.Seed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(path, seed, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write xkey: %s", err)
+	}
+	return kp, nil
+}
+
+// Records the workload deployed with the given ID. The file is replaced atomically, so that a
+// crash never leaves a partially written record behind
+func (s *workloadStateStore) save(id string, namespace string, request *agentapi.DeployRequest) error {
+	workload := recoverableWorkload{
+		MachineId:  id,
+		Namespace:  namespace,
+		DeployedAt: time.Now().UTC(),
+		Request:    controlDeployRequest(request),
+	}
+	if request.WorkloadName != nil {
+		workload.WorkloadName = *request.WorkloadName
+	}
+
+	raw, err := json.Marshal(workload)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := s.workloadPath(id)
+	err = os.WriteFile(path+".tmp", raw, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *workloadStateStore) remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := os.Remove(s.workloadPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warn("Failed to remove recoverable workload", slog.String("id", id), slog.Any("err", err))
+	}
+}
+
+// Returns the recorded workloads, oldest deploy first. Unreadable records are skipped
+func (s *workloadStateStore) load() ([]*recoverableWorkload, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, recoverableWorkloadsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]*recoverableWorkload, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(s.dir, recoverableWorkloadsDir, entry.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			s.log.Warn("Failed to read recoverable workload", slog.String("path", path), slog.Any("err", err))
+			continue
+		}
+
+		var workload recoverableWorkload
+		err = json.Unmarshal(raw, &workload)
+		if err != nil || workload.Request == nil {
+			s.log.Warn("Skipping malformed recoverable workload", slog.String("path", path))
+			continue
+		}
+		workloads = append(workloads, &workload)
+	}
+
+	slices.SortFunc(workloads, func(a, b *recoverableWorkload) int {
+		return a.DeployedAt.Compare(b.DeployedAt)
+	})
+	return workloads, nil
+}
+
+func (s *workloadStateStore) workloadPath(id string) string {
+	return filepath.Join(s.dir, recoverableWorkloadsDir, fmt.Sprintf("%s.json", id))
+}
+
+// Records the workload deployed into the given machine so it's redeployed should the node restart
+func (m *MachineManager) recordRecoverableWorkload(vm *runningFirecracker) {
+	if m.workloadState == nil {
+		return
+	}
+
+	err := m.workloadState.save(vm.vmmID, vm.namespace, vm.deployRequest)
+	if err != nil {
+		m.log.Warn("Failed to record recoverable workload", slog.String("vmid", vm.vmmID), slog.Any("err", err))
+	}
+}
+
+// Forgets the workload with the given ID once it has been stopped for good. Workloads stopped
+// because the node is stopping are kept, to be recovered once it restarts
+func (m *MachineManager) forgetRecoverableWorkload(id string) {
+	if m.workloadState == nil || m.stopping() {
+		return
+	}
+
+	m.workloadState.remove(id)
+}
+
+// Redeploys the workloads the node was running when it last stopped, one at a time, to new
+// machines. Each workload's record is replaced by that of its new machine, or dropped if it
+// couldn't be redeployed
+func (api *ApiListener) recoverWorkloads() {
+	workloads, err := api.mgr.workloadState.load()
+	if err != nil {
+		api.log.Error("Failed to load recoverable workloads", slog.Any("err", err))
+		return
+	}
+	if len(workloads) == 0 {
+		return
+	}
+
+	api.log.Info("Recovering workloads", slog.Int("workloads", len(workloads)))
+	for _, workload := range workloads {
+		if api.mgr.stopping() {
+			return
+		}
+		api.recoverWorkload(workload)
+	}
+}
+
+func (api *ApiListener) recoverWorkload(workload *recoverableWorkload) {
+	evt := controlapi.WorkloadRecoveryEvent{
+		NodeId:            api.nodeId,
+		Namespace:         workload.Namespace,
+		PreviousMachineId: workload.MachineId,
+		WorkloadName:      workload.WorkloadName,
+		DeployedAt:        workload.DeployedAt,
+	}
+
+	// the node may have a new identity since the workload was deployed
+	request := *workload.Request
+	request.TargetNode = nil
+
+	deployed, failed, err := api.deployReplacement(workload.Namespace, &request)
+	switch {
+	case err != nil:
+		evt.Error = err.Error()
+	case deployed == nil:
+		evt.Error = deployFailureReason(failed)
+	default:
+		evt.Recovered = true
+		evt.MachineId = deployed.MachineId
+	}
+
+	api.mgr.workloadState.remove(workload.MachineId)
+
+	if evt.Recovered {
+		api.log.Info("Recovered workload",
+			slog.String("namespace", evt.Namespace),
+			slog.String("workload", evt.WorkloadName),
+			slog.String("previous_vmid", evt.PreviousMachineId),
+			slog.String("vmid", evt.MachineId),
+		)
+	} else {
+		api.log.Warn("Failed to recover workload",
+			slog.String("namespace", evt.Namespace),
+			slog.String("workload", evt.WorkloadName),
+			slog.String("previous_vmid", evt.PreviousMachineId),
+			slog.String("error", evt.Error),
+		)
+	}
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(api.nodeId)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.WorkloadRecoveryEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(evt)
+
+	err = PublishCloudEvent(api.mgr.nc, evt.Namespace, cloudevent, api.log)
+	if err != nil {
+		api.log.Warn("Failed to publish workload recovery event", slog.Any("err", err))
+	}
+}
+
+// Returns the error given in the envelope of a failed deploy response
+func deployFailureReason(raw []byte) string {
+	var envelope controlapi.Envelope
+	err := json.Unmarshal(raw, &envelope)
+	if err != nil || envelope.Error == nil {
+		return "deploy request failed"
+	}
+	return fmt.Sprint(envelope.Error)
+}
@@ -0,0 +1,516 @@
+package nexnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+const (
+	// Replacements are deployed through the node's own deploy subject, which may need to fetch
+	// the replacement's artifact
+	workloadUpdateDeployTimeout = 30 * time.Second
+
+	workloadUpdateHealthPollInterval = 250 * time.Millisecond
+)
+
+// Tracks the replacements of workloads deployed by updates. Replacements are deployed on standby,
+// without their trigger subscriptions, until they're promoted
+type workloadUpdates struct {
+	mutex sync.Mutex
+	// workload JWTs of the replacements being deployed
+	standby map[string]struct{}
+	// IDs of the machines replaced by blue-green and canary replacements awaiting promotion, keyed
+	// by the IDs of the replacements' machines
+	pending map[string]string
+	// splits of the trigger messages of workloads being canaried, keyed by the IDs of the
+	// machines of the stable versions
+	canaries map[string]*canarySplit
+}
+
+func newWorkloadUpdates() *workloadUpdates {
+	return &workloadUpdates{
+		standby:  make(map[string]struct{}),
+		pending:  make(map[string]string),
+		canaries: make(map[string]*canarySplit),
+	}
+}
+
+// Whether the deploy request with the given workload JWT deploys the replacement of a workload
+func (u *workloadUpdates) isStandby(workloadJwt *string) bool {
+	if workloadJwt == nil {
+		return false
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	_, ok := u.standby[*workloadJwt]
+	return ok
+}
+
+func (u *workloadUpdates) setStandby(workloadJwt string, standby bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if standby {
+		u.standby[workloadJwt] = struct{}{}
+	} else {
+		delete(u.standby, workloadJwt)
+	}
+}
+
+// Returns the replacement awaiting promotion of the given machine, or the machine awaiting
+// promotion as the replacement of the given machine, if there is one
+func (u *workloadUpdates) counterpart(vmID string) (string, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if previous, ok := u.pending[vmID]; ok {
+		return previous, true
+	}
+	for replacement, previous := range u.pending {
+		if previous == vmID {
+			return replacement, true
+		}
+	}
+	return "", false
+}
+
+// Forgets any pending update involving the given machine, which is being stopped or promoted
+func (u *workloadUpdates) forget(vmID string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	for replacement, previous := range u.pending {
+		if replacement == vmID || previous == vmID {
+			delete(u.pending, replacement)
+		}
+	}
+	for stable, split := range u.canaries {
+		if stable == vmID || split.canary.vmmID == vmID {
+			delete(u.canaries, stable)
+		}
+	}
+}
+
+func (u *workloadUpdates) setCanary(split *canarySplit) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.pending[split.canary.vmmID] = split.stable.vmmID
+	u.canaries[split.stable.vmmID] = split
+}
+
+// Returns the canary split of the workload in the given machine, if it's the stable version of
+// a canary update
+func (u *workloadUpdates) canaryOf(vmID string) *canarySplit {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.canaries[vmID]
+}
+
+// Returns the canary split the workload in the given machine is either version of, if any
+func (u *workloadUpdates) splitOf(vmID string) *canarySplit {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if split, ok := u.canaries[vmID]; ok {
+		return split
+	}
+	for _, split := range u.canaries {
+		if split.canary.vmmID == vmID {
+			return split
+		}
+	}
+	return nil
+}
+
+// Whether the given workload can be replaced by rolling, blue-green and canary updates, which
+// move its trigger subscriptions to its replacement
+func supportsTriggerHandover(request *agentapi.DeployRequest) bool {
+	return request.SupportsTriggerSubjects() && request.IdleTimeout() == 0 && !request.AtLeastOnceDelivery()
+}
+
+func (api *ApiListener) handleWorkloadUpdate(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Invalid subject for workload update", slog.Any("err", err))
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for workload update")
+		return
+	}
+
+	var request controlapi.WorkloadUpdateRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize workload update request", slog.Any("err", err))
+		respondInvalid(controlapi.WorkloadUpdateResponseType, m, fmt.Sprintf("Unable to deserialize workload update request: %s", err), err)
+		return
+	}
+
+	previous, err := api.mgr.lookupNamespacedMachine(request.WorkloadId, namespace)
+	if err == nil && previous.deployRequest == nil {
+		err = &MachineError{MachineId: request.WorkloadId, Err: ErrMachineNotFound}
+	}
+	if err != nil {
+		respondError(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeNotFound, "No such workload", err)
+		return
+	}
+
+	strategy := strings.ToLower(request.Strategy)
+	if strategy == "" {
+		strategy = controlapi.UpdateStrategyRecreate
+	}
+	switch strategy {
+	case controlapi.UpdateStrategyRecreate:
+	case controlapi.UpdateStrategyRolling, controlapi.UpdateStrategyBlueGreen, controlapi.UpdateStrategyCanary:
+		if !supportsTriggerHandover(previous.deployRequest) {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeUnsupportedFeature, "Rolling, blue-green and canary updates are only supported for functions with at-most-once trigger subjects that don't scale to zero")
+			return
+		}
+		if _, ok := api.mgr.updates.counterpart(previous.vmmID); ok {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeConflict, "Workload already has a replacement awaiting promotion")
+			return
+		}
+	default:
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Unknown update strategy: %s", request.Strategy))
+		return
+	}
+
+	weight := request.CanaryWeight
+	if weight == 0 {
+		weight = controlapi.DefaultCanaryWeight
+	}
+	if strategy == controlapi.UpdateStrategyCanary && (weight < 1 || weight > 99) {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Canary weight must be between 1 and 99 percent")
+		return
+	}
+
+	if request.Request == nil || request.Request.WorkloadJwt == nil {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Workload update requires a deploy request")
+		return
+	}
+
+	// the replacement's JWT authorizes the update, so it must be the same workload's by the same issuer
+	claims, err := controlapi.VerifyClaims(*request.Request.WorkloadJwt, newClaimsVerifiers(api.config.ClaimsVerifiers)...)
+	if err != nil {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid workload update request: could not decode workload JWT: %s", err))
+		return
+	}
+	original := previous.deployRequest.DecodedClaims
+	if claims.Subject != original.Subject || claims.Issuer != original.Issuer {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeUnauthorized, "Invalid workload update request: the replacement must be the same workload, signed by the issuer that started it")
+		return
+	}
+
+	api.log.Info("Updating workload",
+		slog.String("vmid", previous.vmmID),
+		slog.String("namespace", namespace),
+		slog.String("workload", original.Subject),
+		slog.String("strategy", strategy),
+	)
+
+	res := controlapi.WorkloadUpdateResponse{
+		Strategy:          strategy,
+		Name:              original.Subject,
+		PreviousMachineId: previous.vmmID,
+	}
+
+	if strategy == controlapi.UpdateStrategyRecreate {
+		api.mgr.recordMachineEvent(previous, controlapi.TimelineEventStopRequested, "Replaced by workload update")
+		err = api.mgr.StopMachine(previous.vmmID, true)
+		if err != nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to stop workload: %s", err))
+			return
+		}
+	} else {
+		api.mgr.updates.setStandby(*request.Request.WorkloadJwt, true)
+		defer api.mgr.updates.setStandby(*request.Request.WorkloadJwt, false)
+	}
+
+	deployed, failure, err := api.deployReplacement(namespace, request.Request)
+	if err != nil {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeDeployFailed, fmt.Sprintf("Failed to deploy replacement: %s", err))
+		return
+	}
+	if failure != nil {
+		_ = m.Respond(failure)
+		return
+	}
+	res.MachineId = deployed.MachineId
+
+	switch strategy {
+	case controlapi.UpdateStrategyRolling:
+		replacement := api.mgr.LookupMachine(deployed.MachineId)
+		if replacement == nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeDeployFailed, "Replacement stopped before it could be promoted")
+			return
+		}
+
+		err = api.mgr.awaitHealthy(replacement, request.HealthTimeout())
+		if err != nil {
+			api.mgr.recordMachineEvent(replacement, controlapi.TimelineEventStopRequested, fmt.Sprintf("Rolling update abandoned: %s", err))
+			_ = api.mgr.StopMachine(replacement.vmmID, true)
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeDeployFailed, fmt.Sprintf("Replacement did not become healthy: %s", err))
+			return
+		}
+
+		err = api.mgr.promoteReplacement(previous, replacement)
+		if err != nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to promote replacement: %s", err))
+			return
+		}
+	case controlapi.UpdateStrategyBlueGreen:
+		api.mgr.updates.mutex.Lock()
+		api.mgr.updates.pending[deployed.MachineId] = previous.vmmID
+		api.mgr.updates.mutex.Unlock()
+		res.Pending = true
+	case controlapi.UpdateStrategyCanary:
+		replacement := api.mgr.LookupMachine(deployed.MachineId)
+		if replacement == nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeDeployFailed, "Replacement stopped before it could be canaried")
+			return
+		}
+
+		split := api.mgr.newCanarySplit(previous, replacement, weight)
+		api.mgr.updates.setCanary(split)
+		api.mgr.recordMachineEvent(replacement, controlapi.TimelineEventStateChanged, fmt.Sprintf("Receiving %d%% of the trigger messages of machine %s", weight, previous.vmmID))
+		res.Pending = true
+		res.Canary = split.status()
+	}
+
+	api.respondWorkloadUpdate(m, res)
+}
+
+// Promotes or aborts the replacement of a blue-green or canary update, or changes the share of
+// trigger messages routed to the replacement of a canary update
+func (api *ApiListener) handlePromote(m *nats.Msg) {
+	namespace, err := extractNamespace(m.Subject)
+	if err != nil {
+		api.log.Error("Invalid subject for workload promotion", slog.Any("err", err))
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Invalid subject for workload promotion")
+		return
+	}
+
+	var request controlapi.WorkloadPromoteRequest
+	err = schema.Unmarshal(m.Data, &request)
+	if err != nil {
+		api.log.Error("Failed to deserialize workload promote request", slog.Any("err", err))
+		respondInvalid(controlapi.WorkloadUpdateResponseType, m, fmt.Sprintf("Unable to deserialize workload promote request: %s", err), err)
+		return
+	}
+
+	replacement, err := api.mgr.lookupNamespacedMachine(request.WorkloadId, namespace)
+	if err == nil && (replacement.deployRequest == nil || !replacement.deployRequest.Standby) {
+		err = &MachineError{MachineId: request.WorkloadId, Err: ErrMachineNotFound}
+	}
+	if err != nil {
+		respondError(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeNotFound, "No such workload awaiting promotion", err)
+		return
+	}
+
+	stop := controlapi.StopRequest{WorkloadJwt: request.WorkloadJwt}
+	err = stop.Validate(&replacement.deployRequest.DecodedClaims, newClaimsVerifiers(api.config.ClaimsVerifiers)...)
+	if err != nil {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, fmt.Sprintf("Invalid workload promote request: %s", err))
+		return
+	}
+
+	res := controlapi.WorkloadUpdateResponse{
+		Strategy:  controlapi.UpdateStrategyBlueGreen,
+		Name:      replacement.deployRequest.DecodedClaims.Subject,
+		MachineId: replacement.vmmID,
+	}
+	previousID, _ := api.mgr.updates.counterpart(replacement.vmmID)
+	res.PreviousMachineId = previousID
+
+	split := api.mgr.updates.splitOf(replacement.vmmID)
+	if split != nil {
+		res.Strategy = controlapi.UpdateStrategyCanary
+	}
+
+	if request.CanaryWeight != nil {
+		if split == nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Workload is not the replacement of a canary update")
+			return
+		}
+		if *request.CanaryWeight < 1 || *request.CanaryWeight > 99 {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInvalidRequest, "Canary weight must be between 1 and 99 percent")
+			return
+		}
+
+		split.setWeight(*request.CanaryWeight)
+		api.mgr.recordMachineEvent(replacement, controlapi.TimelineEventStateChanged, fmt.Sprintf("Receiving %d%% of the trigger messages of machine %s", *request.CanaryWeight, previousID))
+		res.Pending = true
+		res.Canary = split.status()
+		api.respondWorkloadUpdate(m, res)
+		return
+	}
+
+	if request.Abort {
+		api.mgr.recordMachineEvent(replacement, controlapi.TimelineEventStopRequested, fmt.Sprintf("%s update aborted", res.Strategy))
+		err = api.mgr.StopMachine(replacement.vmmID, true)
+		if err != nil {
+			respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to stop replacement: %s", err))
+			return
+		}
+		res.Aborted = true
+		api.respondWorkloadUpdate(m, res)
+		return
+	}
+
+	// the replaced workload may have been stopped since, leaving its triggers to the replacement
+	previous := api.mgr.LookupMachine(previousID)
+	if split != nil {
+		res.Canary = split.status()
+	}
+	err = api.mgr.promoteReplacement(previous, replacement)
+	if err != nil {
+		respondFail(controlapi.WorkloadUpdateResponseType, m, controlapi.ErrorCodeInternal, fmt.Sprintf("Failed to promote replacement: %s", err))
+		return
+	}
+
+	api.respondWorkloadUpdate(m, res)
+}
+
+func (api *ApiListener) respondWorkloadUpdate(m *nats.Msg, res controlapi.WorkloadUpdateResponse) {
+	raw, err := json.Marshal(controlapi.NewEnvelope(controlapi.WorkloadUpdateResponseType, res, nil))
+	if err != nil {
+		api.log.Error("Failed to marshal workload update response", slog.Any("err", err))
+		return
+	}
+	_ = m.Respond(raw)
+}
+
+// Deploys the replacement of a workload through the node's own deploy subject, so that it's
+// validated like any other deploy request. Should the deploy fail, the node's response is
+// returned as it gave it instead
+func (api *ApiListener) deployReplacement(namespace string, request *controlapi.DeployRequest) (*controlapi.RunResponse, []byte, error) {
+	// the replacement must be deployed, even if its request reuses the original's idempotency key
+	replacement := *request
+	replacement.IdempotencyKey = nil
+
+	raw, err := json.Marshal(replacement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subject := fmt.Sprintf("%s.DEPLOY.%s.%s", controlapi.APIPrefix, namespace, api.nodeId)
+	res, err := api.mgr.nc.Request(subject, raw, workloadUpdateDeployTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var envelope controlapi.Envelope
+	err = json.Unmarshal(res.Data, &envelope)
+	if err != nil || envelope.Error != nil || envelope.PayloadType != controlapi.RunResponseType {
+		return nil, res.Data, nil
+	}
+
+	var runResponse controlapi.RunResponse
+	data, _ := json.Marshal(envelope.Data)
+	err = json.Unmarshal(data, &runResponse)
+	if err != nil {
+		return nil, res.Data, nil
+	}
+	return &runResponse, nil, nil
+}
+
+// Waits for the workload in the given machine to pass its health check, if it has one. Without
+// one, the agent having accepted the workload suffices
+func (m *MachineManager) awaitHealthy(vm *runningFirecracker, timeout time.Duration) error {
+	if vm.deployRequest.HealthCheck == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if vm.state() != machineStateRunning {
+			return fmt.Errorf("machine is %s", vm.state())
+		}
+		if result := vm.lastHealthCheck.Load(); result != nil && result.Healthy {
+			return nil
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return errors.New("node is stopping")
+		case <-time.After(workloadUpdateHealthPollInterval):
+		}
+	}
+
+	if result := vm.lastHealthCheck.Load(); result != nil && result.Message != nil {
+		return fmt.Errorf("timed out waiting for a healthy check; last check: %s", *result.Message)
+	}
+	return errors.New("timed out waiting for a healthy check")
+}
+
+// Moves the trigger subscriptions of the replaced workload, if it's still running, to its standby
+// replacement and stops the replaced workload. Its subscriptions are drained before the
+// replacement subscribes, so that no trigger message is executed by both
+func (m *MachineManager) promoteReplacement(previous *runningFirecracker, replacement *runningFirecracker) error {
+	if previous != nil {
+		m.stopCronTriggers(previous)
+
+		subs := m.takeMachineSubscriptions(previous.vmmID)
+		for _, sub := range subs {
+			_ = sub.Drain()
+		}
+
+		deadline := time.Now().Add(time.Millisecond * triggerTimeoutMillis)
+		for _, sub := range subs {
+			for sub.IsValid() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		for previous.triggerLimiter != nil && previous.triggerLimiter.queued() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	replacement.deployRequest.Standby = false
+	err := m.subscribeTriggers(replacement, replacement.deployRequest)
+	if err != nil {
+		// the replacement has been stopped, so the replaced workload takes its triggers back
+		if previous != nil {
+			if previous.triggerLimiter != nil {
+				previous.triggerLimiter.stop()
+				previous.triggerLimiter = nil
+			}
+			_ = m.subscribeTriggers(previous, previous.deployRequest)
+			if previous.deployRequest.SupportsCronTriggers() {
+				_ = m.scheduleCronTriggers(previous, previous.deployRequest)
+			}
+		}
+		return err
+	}
+	if replacement.deployRequest.SupportsCronTriggers() {
+		err = m.scheduleCronTriggers(replacement, replacement.deployRequest)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.updates.forget(replacement.vmmID)
+	m.recordRecoverableWorkload(replacement)
+	m.log.Info("Promoted workload replacement", slog.String("vmid", replacement.vmmID))
+	m.recordMachineEvent(replacement, controlapi.TimelineEventStateChanged, "Promoted to take over the workload's triggers")
+
+	if previous != nil {
+		m.recordMachineEvent(previous, controlapi.TimelineEventStopRequested, fmt.Sprintf("Replaced by machine %s", replacement.vmmID))
+		err = m.StopMachine(previous.vmmID, true)
+		if err != nil {
+			m.log.Warn("Failed to stop replaced workload", slog.String("vmid", previous.vmmID), slog.Any("err", err))
+		}
+	}
+
+	return nil
+}
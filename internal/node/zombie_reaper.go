@@ -0,0 +1,114 @@
+package nexnode
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/google/uuid"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	defaultZombieReaperIntervalSeconds = 300
+
+	// Resources of machines younger than this are never reaped, as they may belong to a machine
+	// which is still starting, and so isn't tracked yet
+	zombieGracePeriod = 5 * time.Minute
+)
+
+func (r *ZombieReaper) interval() time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return defaultZombieReaperIntervalSeconds * time.Second
+}
+
+// Periodically finds (and, unless in dry run mode, reaps) resources left behind by machines the
+// node isn't tracking until the machine manager is stopped
+func (m *MachineManager) reapZombies() {
+	ticker := time.NewTicker(m.config.ZombieReaper.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			zombies := m.findZombies(!m.config.ZombieReaper.DryRun)
+			if len(zombies) == 0 {
+				continue
+			}
+
+			m.publishZombieResources(zombies)
+		}
+	}
+}
+
+// Whether a resource of the given machine, created by the node process with the given PID (or 0
+// if unknown) and last changed at the given time, has been left behind. Resources of other node
+// processes are left behind once that process has exited; those of this one (or of an unknown
+// one) once they're older than the grace period and the machine isn't tracked
+func (m *MachineManager) isZombie(pid int, vmID string, changed time.Time) bool {
+	if pid != 0 && pid != os.Getpid() {
+		return !processAlive(pid)
+	}
+
+	if time.Since(changed) < zombieGracePeriod {
+		return false
+	}
+	_, tracked := m.lookupMachine(vmID)
+	return !tracked
+}
+
+// Parses the name of a machine's socket or firecracker log in the run directory,
+// .firecracker.sock-{pid}-{vmid}[.log], returning the PID of the node process which created it
+// and the machine's ID
+func parseMachineSocketName(name string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(name, ".firecracker.sock-")
+	if !ok {
+		return 0, "", false
+	}
+
+	pid, vmID, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, "", false
+	}
+
+	p, err := strconv.Atoi(pid)
+	if err != nil || vmID == "" {
+		return 0, "", false
+	}
+	return p, strings.TrimSuffix(vmID, ".log"), true
+}
+
+func (m *MachineManager) publishZombieResources(zombies []controlapi.ZombieResource) {
+	for _, zombie := range zombies {
+		m.log.Warn("Found zombie resource",
+			slog.String("kind", zombie.Kind),
+			slog.String("name", zombie.Name),
+			slog.String("vmid", zombie.MachineId),
+			slog.Bool("reaped", zombie.Reaped),
+		)
+	}
+
+	cloudevent := cloudevents.NewEvent()
+	cloudevent.SetSource(m.publicKey)
+	cloudevent.SetID(uuid.NewString())
+	cloudevent.SetTime(time.Now().UTC())
+	cloudevent.SetType(controlapi.ZombieResourcesEventType)
+	cloudevent.SetDataContentType(cloudevents.ApplicationJSON)
+	_ = cloudevent.SetData(controlapi.ZombieResourcesEvent{
+		Id:        m.publicKey,
+		DryRun:    m.config.ZombieReaper.DryRun,
+		Resources: zombies,
+	})
+
+	err := PublishCloudEvent(m.nc, controlapi.SystemNamespace, cloudevent, m.log)
+	if err != nil {
+		m.log.Warn("Failed to publish zombie resources event", slog.Any("err", err))
+	}
+}
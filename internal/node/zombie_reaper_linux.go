@@ -0,0 +1,205 @@
+package nexnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containernetworking/cni/libcni"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Finds the firecracker processes, CNI attachments and run directory files left behind by machines
+// the node isn't tracking, reaping them if asked to. Processes are reaped first, so that nothing
+// is still using the attachments and files reaped after them. Attachments are left alone when the
+// node is configured to preserve its network
+func (m *MachineManager) findZombies(reap bool) []controlapi.ZombieResource {
+	zombies := m.zombieProcesses(reap)
+	if !m.config.PreserveNetwork {
+		zombies = append(zombies, m.zombieNetworks(reap)...)
+	}
+	return append(zombies, m.zombieFiles(reap)...)
+}
+
+// Firecracker processes whose API socket is in the node's run directory
+func (m *MachineManager) zombieProcesses(reap bool) []controlapi.ZombieResource {
+	zombies := make([]controlapi.ZombieResource, 0)
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		m.log.Warn("Failed to list processes", slog.Any("err", err))
+		return zombies
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		socket, ok := firecrackerSocket(pid)
+		if !ok || filepath.Dir(socket) != filepath.Clean(m.config.runDirectory()) {
+			continue
+		}
+		nodePid, vmID, ok := parseMachineSocketName(filepath.Base(socket))
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join("/proc", entry.Name()))
+		if err != nil || !m.isZombie(nodePid, vmID, info.ModTime()) {
+			continue
+		}
+
+		zombie := controlapi.ZombieResource{
+			Kind:      controlapi.ZombieResourceKindProcess,
+			Name:      entry.Name(),
+			MachineId: vmID,
+		}
+		if reap {
+			err = syscall.Kill(pid, syscall.SIGKILL)
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				zombie.Error = err.Error()
+			} else {
+				zombie.Reaped = true
+			}
+		}
+		zombies = append(zombies, zombie)
+	}
+
+	return zombies
+}
+
+// Returns the API socket of the process with the given PID, if it's a firecracker process
+func firecrackerSocket(pid int) (string, bool) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return "", false
+	}
+
+	args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	if filepath.Base(args[0]) != "firecracker" {
+		return "", false
+	}
+
+	for i, arg := range args {
+		if arg == "--api-sock" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Cached attachments to the node's CNI network, torn down along with their network namespace and
+// the tap device within it
+func (m *MachineManager) zombieNetworks(reap bool) []controlapi.ZombieResource {
+	zombies := make([]controlapi.ZombieResource, 0)
+
+	network := *m.config.CNI.NetworkName
+	cached, err := filepath.Glob(filepath.Join(cniStateDir, "*", "results", network+"-*"))
+	if err != nil || len(cached) == 0 {
+		return zombies
+	}
+
+	var list *libcni.NetworkConfigList
+	for _, path := range cached {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var attachment cniCachedAttachment
+		if json.Unmarshal(raw, &attachment) != nil || attachment.ContainerID == "" {
+			continue
+		}
+
+		if !m.isZombie(0, attachment.ContainerID, info.ModTime()) {
+			continue
+		}
+
+		zombie := controlapi.ZombieResource{
+			Kind:      controlapi.ZombieResourceKindNetwork,
+			Name:      path,
+			MachineId: attachment.ContainerID,
+		}
+		if reap {
+			if list == nil {
+				list, err = libcni.LoadConfList(cniConfDir, network)
+			}
+			if err == nil {
+				err = m.resetCNIAttachment(list, path)
+			}
+			if err != nil {
+				zombie.Error = err.Error()
+			} else {
+				zombie.Reaped = true
+			}
+		}
+		zombies = append(zombies, zombie)
+	}
+
+	return zombies
+}
+
+// Machine sockets, firecracker logs and root filesystem copies in the run directory
+func (m *MachineManager) zombieFiles(reap bool) []controlapi.ZombieResource {
+	zombies := make([]controlapi.ZombieResource, 0)
+
+	dir := m.config.runDirectory()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		m.log.Warn("Failed to read run directory", slog.String("dir", dir), slog.Any("err", err))
+		return zombies
+	}
+
+	for _, entry := range entries {
+		var pid int
+		var vmID string
+		if p, id, ok := parseMachineSocketName(entry.Name()); ok {
+			pid, vmID = p, id
+		} else if id, ok := strings.CutPrefix(entry.Name(), "rootfs-"); ok && strings.HasSuffix(id, ".ext4") {
+			vmID = strings.TrimSuffix(id, ".ext4")
+		} else {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !m.isZombie(pid, vmID, info.ModTime()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		zombie := controlapi.ZombieResource{
+			Kind:      controlapi.ZombieResourceKindFile,
+			Name:      path,
+			MachineId: vmID,
+		}
+		if reap {
+			err = os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				zombie.Error = err.Error()
+			} else {
+				zombie.Reaped = true
+			}
+		}
+		zombies = append(zombies, zombie)
+	}
+
+	return zombies
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
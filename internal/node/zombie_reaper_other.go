@@ -0,0 +1,14 @@
+//go:build !linux
+
+package nexnode
+
+import controlapi "github.com/synadia-io/nex/internal/control-api"
+
+// Firecracker machines are only available on Linux, so nothing is ever left behind elsewhere
+func (m *MachineManager) findZombies(reap bool) []controlapi.ZombieResource {
+	return nil
+}
+
+func processAlive(pid int) bool {
+	return true
+}
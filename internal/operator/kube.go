@@ -0,0 +1,257 @@
+package nexoperator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	contentTypeJSON       = "application/json"
+	contentTypeMergePatch = "application/merge-patch+json"
+)
+
+// A client of the few Kubernetes API endpoints the operator needs: listing, watching and patching
+// NexWorkloads, and recording events about them
+type KubeClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// A failed request to the Kubernetes API, with the message of the Status it was answered with
+type KubeError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *KubeError) Error() string {
+	return fmt.Sprintf("kubernetes API request failed (%d): %s", e.StatusCode, e.Message)
+}
+
+// Creates a client of the Kubernetes API at the given URL, authenticating with the given bearer
+// token (if any) and trusting the given CA certificate (if any) in addition to the system's
+func NewKubeClient(apiURL string, token string, caFile string) (*KubeClient, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid kubernetes API url: %s", apiURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &KubeClient{
+		baseURL: strings.TrimSuffix(u.String(), "/"),
+		token:   token,
+		http:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// Creates a client of the Kubernetes API of the cluster the operator is running in, with the
+// credentials of its pod's service account
+func InClusterKubeClient() (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster; give the kubernetes API url instead")
+	}
+
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	return NewKubeClient("https://"+net.JoinHostPort(host, port), strings.TrimSpace(string(token)), serviceAccountDir+"/ca.crt")
+}
+
+func workloadsPath(namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("/apis/%s/%s/%s", Group, Version, Resource)
+	}
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", Group, Version, url.PathEscape(namespace), Resource)
+}
+
+func workloadPath(w *NexWorkload) string {
+	return workloadsPath(w.Metadata.Namespace) + "/" + url.PathEscape(w.Metadata.Name)
+}
+
+// Lists the NexWorkloads of the given namespace, or of every namespace if it's empty
+func (k *KubeClient) ListWorkloads(ctx context.Context, namespace string) (*NexWorkloadList, error) {
+	var list NexWorkloadList
+	err := k.do(ctx, http.MethodGet, workloadsPath(namespace), "", nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// A change to a NexWorkload, as delivered by a watch. Type is ADDED, MODIFIED, DELETED, BOOKMARK
+// or ERROR
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Watches the NexWorkloads of the given namespace (or every namespace) from the given resource
+// version, passing each change to the handler, until the watch times out after the given
+// duration, fails or the context is done
+func (k *KubeClient) WatchWorkloads(ctx context.Context, namespace string, resourceVersion string, timeout time.Duration, handler func(WatchEvent)) error {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("timeoutSeconds", fmt.Sprintf("%d", int(timeout.Seconds())))
+
+	resp, err := k.request(ctx, http.MethodGet, workloadsPath(namespace)+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event WatchEvent
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			var status kubeStatus
+			_ = json.Unmarshal(event.Object, &status)
+			return &KubeError{StatusCode: status.Code, Message: status.Message}
+		}
+		handler(event)
+	}
+}
+
+// Merge patches the NexWorkload's finalizers
+func (k *KubeClient) PatchFinalizers(ctx context.Context, w *NexWorkload, finalizers []string) error {
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"finalizers": finalizers},
+	}
+	return k.do(ctx, http.MethodPatch, workloadPath(w), contentTypeMergePatch, patch, nil)
+}
+
+// Merge patches the NexWorkload's status subresource with the given fields of its status
+func (k *KubeClient) PatchStatus(ctx context.Context, w *NexWorkload, status map[string]interface{}) error {
+	patch := map[string]interface{}{"status": status}
+	return k.do(ctx, http.MethodPatch, workloadPath(w)+"/status", contentTypeMergePatch, patch, nil)
+}
+
+// Records a Kubernetes event about the NexWorkload, as shown by kubectl describe. The event type
+// is Normal or Warning
+func (k *KubeClient) RecordEvent(ctx context.Context, w *NexWorkload, eventType string, reason string, message string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	event := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"generateName": w.Metadata.Name + ".",
+			"namespace":    w.Metadata.Namespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"apiVersion":      APIVersion,
+			"kind":            Kind,
+			"name":            w.Metadata.Name,
+			"namespace":       w.Metadata.Namespace,
+			"uid":             w.Metadata.UID,
+			"resourceVersion": w.Metadata.ResourceVersion,
+		},
+		"type":           eventType,
+		"reason":         reason,
+		"message":        message,
+		"source":         map[string]interface{}{"component": "nex-operator"},
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/events", url.PathEscape(w.Metadata.Namespace))
+	return k.do(ctx, http.MethodPost, path, contentTypeJSON, event, nil)
+}
+
+type kubeStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (k *KubeClient) do(ctx context.Context, method string, path string, contentType string, body interface{}, out interface{}) error {
+	resp, err := k.request(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Sends a request to the Kubernetes API, returning a KubeError for responses other than 2xx
+func (k *KubeClient) request(ctx context.Context, method string, path string, contentType string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, k.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", contentTypeJSON)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		status := kubeStatus{Code: resp.StatusCode, Message: resp.Status}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = json.Unmarshal(raw, &status)
+		return nil, &KubeError{StatusCode: resp.StatusCode, Message: status.Message}
+	}
+	return resp, nil
+}
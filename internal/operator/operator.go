@@ -0,0 +1,544 @@
+// Package nexoperator runs nex workloads on behalf of Kubernetes. Users declare NexWorkload
+// custom resources, which the operator runs on nex nodes, stopping them again when the resources
+// are deleted. The state of each workload and its most recent logs are reported back in the
+// resource's status, and its lifecycle as Kubernetes events.
+package nexoperator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	nexclient "github.com/synadia-io/nex/client"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+const (
+	defaultResyncInterval = time.Minute
+
+	// How many of a workload's most recent log lines are kept in its status, and how often
+	// they're written
+	statusLogLines     = 20
+	statusLogsInterval = 5 * time.Second
+
+	watchRetryDelay = 5 * time.Second
+)
+
+type OperatorOptions struct {
+	// Namespace whose NexWorkloads are run, or every namespace if empty
+	WatchNamespace string
+	// How often every NexWorkload is reconciled, e.g. to run those whose workloads have stopped
+	ResyncInterval time.Duration
+	// Options of the clients of each nex namespace, e.g. the issuer signing the workloads
+	ClientOptions []nexclient.Option
+}
+
+// Reconciles NexWorkloads with the workloads running on nex nodes
+type Operator struct {
+	kube *KubeClient
+	nc   *nats.Conn
+	opts OperatorOptions
+	log  *slog.Logger
+
+	// serializes reconciliation, which is triggered both by Kubernetes and by nex events
+	reconcileMutex sync.Mutex
+
+	mutex     sync.Mutex
+	clients   map[string]*nexclient.Client
+	workloads map[string]*NexWorkload
+	// keys of the NexWorkloads running on each machine
+	machines  map[string]string
+	logs      map[string][]string
+	dirtyLogs map[string]bool
+}
+
+func NewOperator(kube *KubeClient, nc *nats.Conn, opts OperatorOptions, log *slog.Logger) *Operator {
+	if opts.ResyncInterval <= 0 {
+		opts.ResyncInterval = defaultResyncInterval
+	}
+	return &Operator{
+		kube:      kube,
+		nc:        nc,
+		opts:      opts,
+		log:       log,
+		clients:   make(map[string]*nexclient.Client),
+		workloads: make(map[string]*NexWorkload),
+		machines:  make(map[string]string),
+		logs:      make(map[string][]string),
+		dirtyLogs: make(map[string]bool),
+	}
+}
+
+// Runs the operator until the context is done. Every NexWorkload is reconciled whenever it
+// changes and once per resync interval, and whenever its workload's lifecycle changes
+func (o *Operator) Run(ctx context.Context) error {
+	streams, err := nexclient.New(o.nc, o.opts.ClientOptions...)
+	if err != nil {
+		return err
+	}
+	lifecycle, err := streams.StreamEvents(ctx, nexclient.EventFilter{Namespace: "*", EventType: controlapi.WorkloadLifecycleEventType})
+	if err != nil {
+		return err
+	}
+	logs, err := streams.StreamLogs(ctx, nexclient.LogFilter{Namespace: "*"})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(statusLogsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-lifecycle:
+				if !ok {
+					return
+				}
+				o.handleLifecycleEvent(ctx, event)
+			case entry, ok := <-logs:
+				if !ok {
+					return
+				}
+				o.recordLog(entry)
+			case <-ticker.C:
+				o.flushLogs(ctx)
+			}
+		}
+	}()
+
+	o.log.Info("Operator started", slog.String("namespace", o.opts.WatchNamespace))
+
+	for ctx.Err() == nil {
+		err := o.resync(ctx)
+		if err != nil && ctx.Err() == nil {
+			o.log.Warn("Failed to watch NexWorkloads", slog.Any("err", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(watchRetryDelay):
+			}
+		}
+	}
+	return nil
+}
+
+// Reconciles every NexWorkload, then those which change until the watch times out after the
+// resync interval
+func (o *Operator) resync(ctx context.Context) error {
+	list, err := o.kube.ListWorkloads(ctx, o.opts.WatchNamespace)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool)
+	for i := range list.Items {
+		w := &list.Items[i]
+		keys[w.key()] = true
+		o.reconcileLogged(ctx, w)
+	}
+
+	// forget the workloads deleted while the operator wasn't watching
+	o.mutex.Lock()
+	for key := range o.workloads {
+		if !keys[key] {
+			o.forget(key)
+		}
+	}
+	o.mutex.Unlock()
+
+	return o.kube.WatchWorkloads(ctx, o.opts.WatchNamespace, list.Metadata.ResourceVersion, o.opts.ResyncInterval, func(event WatchEvent) {
+		if event.Type == "BOOKMARK" {
+			return
+		}
+
+		var w NexWorkload
+		err := json.Unmarshal(event.Object, &w)
+		if err != nil {
+			o.log.Warn("Failed to decode NexWorkload", slog.Any("err", err))
+			return
+		}
+
+		if event.Type == "DELETED" {
+			o.mutex.Lock()
+			o.forget(w.key())
+			o.mutex.Unlock()
+			return
+		}
+
+		// changes other than to the spec, such as the operator's own to the status, don't need
+		// reconciling. Workloads which failed to run are retried when next resynced
+		o.mutex.Lock()
+		reconciled, ok := o.workloads[w.key()]
+		upToDate := ok && reconciled.Status.ObservedGeneration == w.Metadata.Generation
+		o.mutex.Unlock()
+		if w.Metadata.DeletionTimestamp == nil && upToDate {
+			return
+		}
+		o.reconcileLogged(ctx, &w)
+	})
+}
+
+func (o *Operator) reconcileLogged(ctx context.Context, w *NexWorkload) {
+	err := o.Reconcile(ctx, w)
+	if err != nil {
+		o.log.Warn("Failed to reconcile NexWorkload",
+			slog.String("namespace", w.Metadata.Namespace),
+			slog.String("name", w.Metadata.Name),
+			slog.Any("err", err),
+		)
+	}
+}
+
+// Reconciles a NexWorkload: runs its workload if it isn't running (or its spec has changed since
+// it was run), and stops it once the resource is being deleted
+func (o *Operator) Reconcile(ctx context.Context, w *NexWorkload) error {
+	o.reconcileMutex.Lock()
+	defer o.reconcileMutex.Unlock()
+
+	client, err := o.client(w.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if w.Metadata.DeletionTimestamp != nil {
+		return o.finalize(ctx, client, w)
+	}
+
+	if !w.hasFinalizer() {
+		w.Metadata.Finalizers = append(w.Metadata.Finalizers, Finalizer)
+		err := o.kube.PatchFinalizers(ctx, w, w.Metadata.Finalizers)
+		if err != nil {
+			return err
+		}
+	}
+	o.remember(w)
+
+	if w.Status.MachineId != "" {
+		if w.Status.ObservedGeneration == w.Metadata.Generation && o.running(client, w) {
+			return nil
+		}
+		// replaced by a workload of the new spec, or no longer running
+		o.stop(ctx, client, w)
+	}
+
+	return o.run(ctx, client, w)
+}
+
+func (o *Operator) finalize(ctx context.Context, client *nexclient.Client, w *NexWorkload) error {
+	if !w.hasFinalizer() {
+		return nil
+	}
+
+	o.stop(ctx, client, w)
+
+	finalizers := make([]string, 0, len(w.Metadata.Finalizers))
+	for _, f := range w.Metadata.Finalizers {
+		if f != Finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	err := o.kube.PatchFinalizers(ctx, w, finalizers)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	o.forget(w.key())
+	o.mutex.Unlock()
+	return nil
+}
+
+// Returns true if the NexWorkload's machine is still running its workload
+func (o *Operator) running(client *nexclient.Client, w *NexWorkload) bool {
+	_, err := client.DescribeWorkload(w.Status.NodeId, w.Status.MachineId)
+	if err == nil {
+		return true
+	}
+
+	var requestErr *nexclient.RequestError
+	if errors.As(err, &requestErr) && requestErr.Code == nexclient.ErrorCodeNotFound {
+		return false
+	}
+	// the node may be briefly unreachable; the workload is checked again when next reconciled
+	o.log.Debug("Failed to describe workload", slog.String("machine_id", w.Status.MachineId), slog.Any("err", err))
+	return true
+}
+
+// Stops the NexWorkload's workload, if it's running. Failures are logged rather than returned, as
+// the workload may well have stopped already
+func (o *Operator) stop(ctx context.Context, client *nexclient.Client, w *NexWorkload) {
+	if w.Status.MachineId == "" {
+		return
+	}
+
+	o.mutex.Lock()
+	delete(o.machines, w.Status.MachineId)
+	o.mutex.Unlock()
+
+	_, err := client.StopWorkload(w.Status.NodeId, w.Status.MachineId, w.WorkloadName())
+	if err != nil {
+		o.log.Debug("Failed to stop workload", slog.String("machine_id", w.Status.MachineId), slog.Any("err", err))
+		return
+	}
+	o.event(ctx, w, "Normal", "Stopped", fmt.Sprintf("Stopped workload %s on node %s", w.Status.MachineId, w.Status.NodeId))
+}
+
+// Runs the NexWorkload's workload, reporting the node and machine it runs on, or why it couldn't
+// be run, in its status
+func (o *Operator) run(ctx context.Context, client *nexclient.Client, w *NexWorkload) error {
+	nodeId, machineId, err := o.deploy(client, w)
+	if err != nil {
+		o.setRun(w, "", "")
+		o.event(ctx, w, "Warning", "DeployFailed", err.Error())
+		_ = o.updateStatus(ctx, w, map[string]interface{}{
+			"state":              StateFailed,
+			"nodeId":             nil,
+			"machineId":          nil,
+			"message":            err.Error(),
+			"observedGeneration": w.Metadata.Generation,
+		})
+		return err
+	}
+
+	o.setRun(w, nodeId, machineId)
+	o.event(ctx, w, "Normal", "Deployed", fmt.Sprintf("Ran workload %s on node %s", machineId, nodeId))
+	return o.updateStatus(ctx, w, map[string]interface{}{
+		"state":              controlapi.WorkloadStateDeploying,
+		"nodeId":             nodeId,
+		"machineId":          machineId,
+		"message":            "",
+		"observedGeneration": w.Metadata.Generation,
+	})
+}
+
+func (o *Operator) deploy(client *nexclient.Client, w *NexWorkload) (string, string, error) {
+	nodeId, err := o.selectNode(client, w)
+	if err != nil {
+		return "", "", err
+	}
+
+	run, err := client.RunWorkload(nodeId, o.requestOptions(w)...)
+	if err != nil {
+		return "", "", err
+	}
+	if !run.Started {
+		return "", "", errors.New("node did not start the workload")
+	}
+	return nodeId, run.MachineId, nil
+}
+
+// Returns the NexWorkload's target node, or the node with the most allocatable memory whose tags
+// include its node tags and which runs its type of workload
+func (o *Operator) selectNode(client *nexclient.Client, w *NexWorkload) (string, error) {
+	if w.Spec.TargetNode != "" {
+		return w.Spec.TargetNode, nil
+	}
+
+	nodes, err := client.ListNodes()
+	if err != nil {
+		return "", err
+	}
+
+	var selected *nexclient.PingResponse
+	var selectedMemory int64 = -1
+	for i := range nodes {
+		node := &nodes[i]
+		if !tagsMatch(node.Tags, w.Spec.NodeTags) {
+			continue
+		}
+		if node.Capabilities != nil && !node.Capabilities.SupportsWorkloadType(w.Spec.Type, controlapi.WorkloadTypeVersion) {
+			continue
+		}
+
+		var memory int64
+		if node.Capacity != nil {
+			memory = node.Capacity.AllocatableMemoryMib
+		}
+		if memory > selectedMemory || (memory == selectedMemory && node.RunningMachines < selected.RunningMachines) {
+			selected, selectedMemory = node, memory
+		}
+	}
+	if selected == nil {
+		return "", errors.New("no node matches the workload's node tags and type")
+	}
+	return selected.NodeId, nil
+}
+
+func tagsMatch(tags map[string]string, required map[string]string) bool {
+	for k, v := range required {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Operator) requestOptions(w *NexWorkload) []nexclient.RequestOption {
+	labels := map[string]string{
+		"nex.k8s.namespace": w.Metadata.Namespace,
+		"nex.k8s.name":      w.Metadata.Name,
+	}
+	maps.Copy(labels, w.Spec.Labels)
+
+	return []nexclient.RequestOption{
+		nexclient.WorkloadName(w.WorkloadName()),
+		nexclient.WorkloadType(w.Spec.Type),
+		nexclient.WorkloadDescription(w.Spec.Description),
+		nexclient.Location(w.Spec.Location),
+		nexclient.Checksum(w.Spec.Digest),
+		nexclient.WorkloadDigest(w.Spec.Digest),
+		nexclient.Argv(w.Spec.Argv),
+		nexclient.Environment(w.Spec.Env),
+		nexclient.Essential(w.Spec.Essential),
+		nexclient.TriggerSubjects(w.Spec.TriggerSubjects),
+		nexclient.WorkloadLabels(labels),
+		nexclient.MachineTemplate(w.Spec.Template),
+		nexclient.MachineSize(w.Spec.Vcpus, w.Spec.MemoryMb),
+	}
+}
+
+// Reports the lifecycle state of a NexWorkload's workload in its status, and reconciles it once
+// its workload has stopped so that it's run again
+func (o *Operator) handleLifecycleEvent(ctx context.Context, event nexclient.EmittedEvent) {
+	var lifecycle controlapi.WorkloadLifecycleEvent
+	if event.DataAs(&lifecycle) != nil || lifecycle.MachineId == "" {
+		return
+	}
+
+	o.mutex.Lock()
+	w, ok := o.workloads[o.machines[lifecycle.MachineId]]
+	current := ok && w.Status.MachineId == lifecycle.MachineId
+	o.mutex.Unlock()
+	if !current {
+		return
+	}
+
+	status := map[string]interface{}{
+		"state":   lifecycle.State,
+		"message": lifecycle.Reason,
+	}
+	err := o.updateStatus(ctx, w, status)
+	if err != nil {
+		o.log.Warn("Failed to update NexWorkload status", slog.String("name", w.Metadata.Name), slog.Any("err", err))
+	}
+
+	switch lifecycle.State {
+	case controlapi.WorkloadStateRunning:
+		o.event(ctx, w, "Normal", "Running", fmt.Sprintf("Workload %s is running", lifecycle.MachineId))
+	case controlapi.WorkloadStateUnhealthy:
+		o.event(ctx, w, "Warning", "Unhealthy", fmt.Sprintf("Workload %s is unhealthy: %s", lifecycle.MachineId, lifecycle.Reason))
+	case controlapi.WorkloadStateFailed:
+		o.event(ctx, w, "Warning", "Failed", fmt.Sprintf("Workload %s failed: %s", lifecycle.MachineId, lifecycle.Reason))
+	case controlapi.WorkloadStateStopped:
+		o.reconcileLogged(ctx, w)
+	}
+}
+
+func (o *Operator) recordLog(entry nexclient.EmittedLog) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	key, ok := o.machines[entry.MachineId]
+	if !ok {
+		return
+	}
+
+	lines := append(o.logs[key], entry.Text)
+	if len(lines) > statusLogLines {
+		lines = lines[len(lines)-statusLogLines:]
+	}
+	o.logs[key] = lines
+	o.dirtyLogs[key] = true
+}
+
+// Writes the most recent log lines of the NexWorkloads which have logged since the last flush
+func (o *Operator) flushLogs(ctx context.Context) {
+	o.mutex.Lock()
+	type pending struct {
+		w     *NexWorkload
+		lines []string
+	}
+	flush := make([]pending, 0, len(o.dirtyLogs))
+	for key := range o.dirtyLogs {
+		if w, ok := o.workloads[key]; ok {
+			flush = append(flush, pending{w: w, lines: append([]string{}, o.logs[key]...)})
+		}
+	}
+	o.dirtyLogs = make(map[string]bool)
+	o.mutex.Unlock()
+
+	for _, p := range flush {
+		err := o.kube.PatchStatus(ctx, p.w, map[string]interface{}{"logs": p.lines})
+		if err != nil {
+			o.log.Debug("Failed to update NexWorkload logs", slog.String("name", p.w.Metadata.Name), slog.Any("err", err))
+		}
+	}
+}
+
+func (o *Operator) updateStatus(ctx context.Context, w *NexWorkload, status map[string]interface{}) error {
+	status["updatedAt"] = time.Now().UTC().Format(time.RFC3339)
+	return o.kube.PatchStatus(ctx, w, status)
+}
+
+func (o *Operator) event(ctx context.Context, w *NexWorkload, eventType string, reason string, message string) {
+	err := o.kube.RecordEvent(ctx, w, eventType, reason, message)
+	if err != nil {
+		o.log.Debug("Failed to record event", slog.String("name", w.Metadata.Name), slog.String("reason", reason), slog.Any("err", err))
+	}
+}
+
+// Returns the client of the given nex namespace, which is that of the NexWorkload
+func (o *Operator) client(namespace string) (*nexclient.Client, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if client, ok := o.clients[namespace]; ok {
+		return client, nil
+	}
+	client, err := nexclient.New(o.nc, append(o.opts.ClientOptions, nexclient.WithNamespace(namespace))...)
+	if err != nil {
+		return nil, err
+	}
+	o.clients[namespace] = client
+	return client, nil
+}
+
+// Records the machine the NexWorkload's workload was run on, if it was, as of its current spec
+func (o *Operator) setRun(w *NexWorkload, nodeId string, machineId string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	w.Status.NodeId = nodeId
+	w.Status.MachineId = machineId
+	w.Status.ObservedGeneration = w.Metadata.Generation
+	if machineId != "" {
+		o.machines[machineId] = w.key()
+	}
+}
+
+func (o *Operator) remember(w *NexWorkload) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.workloads[w.key()] = w
+	if w.Status.MachineId != "" {
+		o.machines[w.Status.MachineId] = w.key()
+	}
+}
+
+// Forgets a NexWorkload, with the operator's mutex held
+func (o *Operator) forget(key string) {
+	if w, ok := o.workloads[key]; ok {
+		delete(o.machines, w.Status.MachineId)
+	}
+	delete(o.workloads, key)
+	delete(o.logs, key)
+	delete(o.dirtyLogs, key)
+}
@@ -0,0 +1,110 @@
+package nexoperator
+
+import (
+	"time"
+)
+
+// The API group and version of the NexWorkload custom resource, as defined by
+// examples/kubernetes/nexworkload-crd.yaml
+const (
+	Group      = "nex.synadia.io"
+	Version    = "v1alpha1"
+	Kind       = "NexWorkload"
+	Resource   = "nexworkloads"
+	APIVersion = Group + "/" + Version
+
+	// Keeps a NexWorkload from being deleted until its workload has been stopped
+	Finalizer = "nex.synadia.io/workload"
+)
+
+// States of NexWorkloads, in addition to the workload lifecycle states reported by nodes (e.g.
+// running or stopped). A workload is pending until it has been run on a node, and failed if no
+// node would run it
+const (
+	StatePending = "pending"
+	StateFailed  = "failed"
+)
+
+// A workload run on a nex node on behalf of Kubernetes. Its namespace is also its nex namespace
+type NexWorkload struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Spec       NexWorkloadSpec   `json:"spec"`
+	Status     NexWorkloadStatus `json:"status,omitempty"`
+}
+
+// The subset of Kubernetes object metadata the operator uses
+type ObjectMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Finalizers        []string          `json:"finalizers,omitempty"`
+	DeletionTimestamp *time.Time        `json:"deletionTimestamp,omitempty"`
+}
+
+type NexWorkloadSpec struct {
+	// Name of the nex workload, all lowercase letters. Defaults to the resource's name
+	WorkloadName    string            `json:"workloadName,omitempty"`
+	Type            string            `json:"type"`
+	Location        string            `json:"location"`
+	Digest          string            `json:"digest,omitempty"`
+	Description     string            `json:"description,omitempty"`
+	Argv            []string          `json:"argv,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Essential       bool              `json:"essential,omitempty"`
+	TriggerSubjects []string          `json:"triggerSubjects,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Template        string            `json:"template,omitempty"`
+	Vcpus           int               `json:"vcpus,omitempty"`
+	MemoryMb        int               `json:"memoryMb,omitempty"`
+
+	// Public key of the node to run the workload on. When empty, the operator picks the node
+	// with the most allocatable memory among those whose tags include the node tags
+	TargetNode string            `json:"targetNode,omitempty"`
+	NodeTags   map[string]string `json:"nodeTags,omitempty"`
+}
+
+type NexWorkloadStatus struct {
+	State              string     `json:"state,omitempty"`
+	NodeId             string     `json:"nodeId,omitempty"`
+	MachineId          string     `json:"machineId,omitempty"`
+	Message            string     `json:"message,omitempty"`
+	ObservedGeneration int64      `json:"observedGeneration,omitempty"`
+	UpdatedAt          *time.Time `json:"updatedAt,omitempty"`
+	// The most recent lines logged by the workload
+	Logs []string `json:"logs,omitempty"`
+}
+
+type NexWorkloadList struct {
+	Metadata ListMeta      `json:"metadata"`
+	Items    []NexWorkload `json:"items"`
+}
+
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// The name of the nex workload the resource runs
+func (w *NexWorkload) WorkloadName() string {
+	if w.Spec.WorkloadName != "" {
+		return w.Spec.WorkloadName
+	}
+	return w.Metadata.Name
+}
+
+func (w *NexWorkload) key() string {
+	return w.Metadata.Namespace + "/" + w.Metadata.Name
+}
+
+func (w *NexWorkload) hasFinalizer() bool {
+	for _, f := range w.Metadata.Finalizers {
+		if f == Finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package scaffold
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Describes how to deploy the workload of a project. Paths are relative to the manifest
+type Manifest struct {
+	Name            string            `json:"name"`
+	WorkloadType    string            `json:"type"`
+	Description     string            `json:"description,omitempty"`
+	Artifact        string            `json:"artifact"`
+	Issuer          string            `json:"issuer,omitempty"`
+	Argv            []string          `json:"argv,omitempty"`
+	Environment     map[string]string `json:"environment,omitempty"`
+	TriggerSubjects []string          `json:"trigger_subjects,omitempty"`
+	// Shell commands, run in the manifest's directory, which build the artifact
+	Build []string `json:"build,omitempty"`
+}
+
+// Reads the manifest at the given path, resolving the paths within it against its directory
+func LoadManifest(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	manifest.Artifact = resolvePath(dir, manifest.Artifact)
+	manifest.Issuer = resolvePath(dir, manifest.Issuer)
+
+	return &manifest, nil
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, filepath.FromSlash(path))
+}
@@ -0,0 +1,230 @@
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	ManifestFilename = "nex.json"
+	IssuerFilename   = "issuer.nk"
+
+	// Version of the NATS client the generated Go service depends on
+	natsGoVersion = "v1.31.0"
+
+	templateSuffix = ".tmpl"
+)
+
+var validProjectName = regexp.MustCompile(`^[a-z]+$`)
+
+//go:embed templates
+var templates embed.FS
+
+// Describes the project to generate. The name is also the name of the workload, and so must
+// be all lowercase letters
+type Options struct {
+	Name         string
+	WorkloadType string
+	Dir          string
+}
+
+// A generated project: the files written, relative to its directory, and the public key of the
+// issuer generated to sign its workload JWTs, which nodes must trust to run it
+type Project struct {
+	Dir       string
+	Files     []string
+	IssuerKey string
+}
+
+// Per-workload type details of a generated project
+type projectKind struct {
+	artifact      string
+	subject       string
+	buildCommands []string
+}
+
+func kindOf(name string, workloadType string) (*projectKind, error) {
+	switch workloadType {
+	case agentapi.NexExecutionProviderELF:
+		return &projectKind{
+			artifact: path.Join("dist", name),
+			subject:  fmt.Sprintf("svc.%s", name),
+			buildCommands: []string{
+				"go mod tidy",
+				fmt.Sprintf(`CGO_ENABLED=0 go build -tags netgo -ldflags '-extldflags "-static"' -o dist/%s .`, name),
+			},
+		}, nil
+	case agentapi.NexExecutionProviderV8:
+		return &projectKind{
+			artifact:      path.Join("dist", name+".js"),
+			subject:       fmt.Sprintf("%s.trigger", name),
+			buildCommands: []string{"npm install", "npm run build"},
+		}, nil
+	case agentapi.NexExecutionProviderWasm:
+		return &projectKind{
+			artifact:      path.Join("target", "wasm32-wasi", "release", name+".wasm"),
+			subject:       fmt.Sprintf("%s.trigger", name),
+			buildCommands: []string{"cargo build --target wasm32-wasi --release"},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported workload type: %s", workloadType)
+	}
+}
+
+// Generates a starter project for a workload of the given type: its source, a build and test
+// harness (Taskfile.yml), a manifest from which `nex devrun` deploys it, and an issuer key with
+// which its workload JWTs are signed. The project's directory must not exist or be empty
+func Generate(opts Options) (*Project, error) {
+	if !validProjectName.MatchString(opts.Name) {
+		return nil, fmt.Errorf("project name ('%s') must be all lowercase letters", opts.Name)
+	}
+
+	kind, err := kindOf(opts.Name, opts.WorkloadType)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = opts.Name
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("project directory %s is not empty", dir)
+	}
+
+	issuer, err := nkeys.CreateAccount()
+	if err != nil {
+		return nil, err
+	}
+	issuerKey, err := issuer.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	seed, err := issuer.Seed()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"Name":          opts.Name,
+		"WorkloadType":  opts.WorkloadType,
+		"Artifact":      kind.artifact,
+		"Subject":       kind.subject,
+		"BuildCommands": kind.buildCommands,
+		"IssuerKey":     issuerKey,
+		"NatsGoVersion": natsGoVersion,
+	}
+
+	project := &Project{Dir: dir, IssuerKey: issuerKey}
+	for _, root := range []string{"templates/common", "templates/" + opts.WorkloadType} {
+		err = fs.WalkDir(templates, root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			rendered, err := render(name, data)
+			if err != nil {
+				return err
+			}
+
+			target := strings.TrimSuffix(strings.TrimPrefix(name, root+"/"), templateSuffix)
+			return project.write(target, rendered, 0644)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(&Manifest{
+		Name:            opts.Name,
+		WorkloadType:    opts.WorkloadType,
+		Description:     fmt.Sprintf("Generated %s workload", opts.WorkloadType),
+		Artifact:        kind.artifact,
+		Issuer:          IssuerFilename,
+		TriggerSubjects: kind.triggerSubjects(opts.WorkloadType),
+		Build:           kind.buildCommands,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = project.write(ManifestFilename, append(manifest, '\n'), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = project.write(IssuerFilename, seed, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// Function workloads are triggered on the project's subject, whereas services subscribe to it
+func (k *projectKind) triggerSubjects(workloadType string) []string {
+	if workloadType == agentapi.NexExecutionProviderELF {
+		return nil
+	}
+	return []string{k.subject}
+}
+
+func render(name string, data map[string]interface{}) ([]byte, error) {
+	raw, err := templates.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the generated Taskfile is itself a go template, so these templates use other delimiters
+	tmpl, err := template.New(name).Delims("[[", "]]").Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *Project) write(name string, contents []byte, perm os.FileMode) error {
+	// embedded files can't be dotfiles, so they're stored without the dot
+	if name == "gitignore" {
+		name = ".gitignore"
+	}
+
+	target := filepath.Join(p.Dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(target, contents, perm)
+	if err != nil {
+		return err
+	}
+
+	p.Files = append(p.Files, name)
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package schema generates JSON Schemas for the control and agent API messages from their Go
+// types, and validates incoming payloads against them.
+//
+// A payload that doesn't match its message's schema is rejected with a ValidationError listing
+// every offending field, rather than with the first error encoding/json happens upon. Schemas
+// only constrain the types of fields (and the formats of timestamps and base64 encoded bytes);
+// no field is required and unknown fields are permitted, so that messages of older and newer
+// versions of nex remain valid. What a message must contain is left to its Validate method.
+package schema
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The JSON Schema dialect of generated schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSON types
+const (
+	TypeArray   = "array"
+	TypeBoolean = "boolean"
+	TypeInteger = "integer"
+	TypeNull    = "null"
+	TypeNumber  = "number"
+	TypeObject  = "object"
+	TypeString  = "string"
+)
+
+// A JSON Schema, limited to the keywords needed to describe the API's messages. A schema
+// without types accepts any value
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 []string           `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// A field of a payload not matching its schema. The field is given as a path of JSON names
+// separated by dots, with array indices in brackets, and is empty for the payload itself
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// The fields of a payload not matching its schema
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		if f.Field == "" {
+			problems = append(problems, f.Message)
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: %s", f.Field, f.Message))
+		}
+	}
+	return fmt.Sprintf("invalid fields: %s", strings.Join(problems, "; "))
+}
+
+const modulePath = "github.com/synadia-io/nex/"
+
+var (
+	cache sync.Map
+
+	timeType        = reflect.TypeOf(time.Time{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textType        = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Returns the schema of the message type of v, which may be a pointer to the message
+func For(v interface{}) *Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if s, ok := cache.Load(t); ok {
+		return s.(*Schema)
+	}
+
+	s := *generate(t, map[reflect.Type]bool{})
+	s.Schema = Draft
+	s.Title = t.Name()
+	cached, _ := cache.LoadOrStore(t, &s)
+	return cached.(*Schema)
+}
+
+// Validates the JSON against the schema of v's type before unmarshaling it into v, which must be
+// a pointer. If the JSON doesn't match the schema, a ValidationError is returned
+func Unmarshal(data []byte, v interface{}) error {
+	err := For(v).Validate(data)
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return err
+	}
+	// anything else, e.g. malformed JSON, is left for encoding/json to report
+	return json.Unmarshal(data, v)
+}
+
+// Validates the JSON against the schema, returning a ValidationError listing the offending
+// fields if it doesn't match
+func (s *Schema) Validate(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	err := decoder.Decode(&v)
+	if err != nil {
+		return err
+	}
+
+	var fields []FieldError
+	s.validate("", v, &fields)
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, v interface{}, fields *[]FieldError) {
+	if len(s.Type) == 0 {
+		return
+	}
+
+	actual := jsonType(v)
+	if !s.allows(actual) {
+		expected := make([]string, 0, len(s.Type))
+		for _, t := range s.Type {
+			if t != TypeNull {
+				expected = append(expected, t)
+			}
+		}
+		*fields = append(*fields, FieldError{Field: path, Message: fmt.Sprintf("expected %s, got %s", strings.Join(expected, " or "), actual)})
+		return
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.validateObject(path, v, fields)
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, fields)
+			}
+		}
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				*fields = append(*fields, FieldError{Field: path, Message: "expected an RFC 3339 timestamp"})
+			}
+		}
+		if s.ContentEncoding == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+				*fields = append(*fields, FieldError{Field: path, Message: "expected base64 encoded bytes"})
+			}
+		}
+	case json.Number:
+		if !s.allowsType(TypeInteger) || s.allowsType(TypeNumber) {
+			return
+		}
+		if s.Minimum != nil {
+			if _, err := strconv.ParseUint(v.String(), 10, 64); err != nil {
+				*fields = append(*fields, FieldError{Field: path, Message: fmt.Sprintf("expected an integer of at least %d, got %s", *s.Minimum, v)})
+			}
+		} else if _, err := strconv.ParseInt(v.String(), 10, 64); err != nil {
+			*fields = append(*fields, FieldError{Field: path, Message: fmt.Sprintf("expected an integer, got %s", v)})
+		}
+	}
+}
+
+func (s *Schema) validateObject(path string, v map[string]interface{}, fields *[]FieldError) {
+	// encoding/json matches the names of fields case-insensitively
+	properties := make(map[string]*Schema, len(s.Properties))
+	for name, p := range s.Properties {
+		properties[strings.ToLower(name)] = p
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, ok := properties[strings.ToLower(name)]
+		if !ok {
+			p = s.AdditionalProperties
+		}
+		if p == nil {
+			continue
+		}
+
+		field := name
+		if path != "" {
+			field = path + "." + name
+		}
+		p.validate(field, v[name], fields)
+	}
+}
+
+func (s *Schema) allows(actual string) bool {
+	if actual == TypeInteger {
+		return s.allowsType(TypeInteger) || s.allowsType(TypeNumber)
+	}
+	if actual == TypeNumber && s.allowsType(TypeInteger) {
+		// reported as a non-integer
+		return true
+	}
+	return s.allowsType(actual)
+}
+
+func (s *Schema) allowsType(t string) bool {
+	for _, allowed := range s.Type {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case string:
+		return TypeString
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return TypeNumber
+		}
+		return TypeInteger
+	case []interface{}:
+		return TypeArray
+	default:
+		return TypeObject
+	}
+}
+
+// Generates the schema of the given type, as encoding/json (un)marshals it. Types already being
+// generated, i.e., recursive ones, accept any value
+func generate(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: []string{TypeString}, Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Kind() != reflect.Pointer && !strings.HasPrefix(t.PkgPath(), modulePath) && (t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType)):
+		// the JSON of another package's type with its own unmarshaling is unknown. The API's
+		// own messages only unmarshal themselves to preserve unknown fields
+		return &Schema{}
+	case t.Kind() != reflect.Pointer && (t.Implements(textType) || reflect.PointerTo(t).Implements(textType)):
+		return &Schema{Type: []string{TypeString}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(generate(t.Elem(), visiting))
+	case reflect.Bool:
+		return &Schema{Type: []string{TypeBoolean}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: []string{TypeInteger}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := int64(0)
+		return &Schema{Type: []string{TypeInteger}, Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: []string{TypeNumber}}
+	case reflect.String:
+		return &Schema{Type: []string{TypeString}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: []string{TypeString, TypeNull}, ContentEncoding: "base64"}
+		}
+		return nullable(&Schema{Type: []string{TypeArray}, Items: generate(t.Elem(), visiting)})
+	case reflect.Array:
+		return &Schema{Type: []string{TypeArray}, Items: generate(t.Elem(), visiting)}
+	case reflect.Map:
+		return nullable(&Schema{Type: []string{TypeObject}, AdditionalProperties: generate(t.Elem(), visiting)})
+	case reflect.Struct:
+		if visiting[t] {
+			return &Schema{}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &Schema{Type: []string{TypeObject}, Properties: map[string]*Schema{}}
+		addProperties(s, t, visiting)
+		return s
+	default:
+		// interfaces hold anything
+		return &Schema{}
+	}
+}
+
+// Adds the properties of the fields of the given struct, including those promoted from embedded
+// structs, to the schema. As with encoding/json, the fields of an outer struct shadow those of
+// the structs it embeds
+func addProperties(s *Schema, t reflect.Type, visiting map[reflect.Type]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			e := f.Type
+			if e.Kind() == reflect.Pointer {
+				e = e.Elem()
+			}
+			if e.Kind() == reflect.Struct {
+				embedded = append(embedded, e)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := s.Properties[name]; ok {
+			continue
+		}
+
+		property := generate(f.Type, visiting)
+		if strings.Contains(","+opts+",", ",string,") {
+			property = &Schema{Type: []string{TypeString}}
+		}
+		s.Properties[name] = property
+	}
+
+	for _, e := range embedded {
+		addProperties(s, e, visiting)
+	}
+}
+
+func nullable(s *Schema) *Schema {
+	if len(s.Type) == 0 {
+		return s
+	}
+	for _, t := range s.Type {
+		if t == TypeNull {
+			return s
+		}
+	}
+
+	n := *s
+	n.Type = append(append([]string{}, s.Type...), TypeNull)
+	return &n
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/models"
+)
+
+// What applying a manifest does to one of its workloads
+const (
+	planCreate    = "create"
+	planUpdate    = "update"
+	planScale     = "scale"
+	planDelete    = "delete"
+	planUnchanged = "unchanged"
+)
+
+type planEntry struct {
+	Action string
+	Name   string
+	// The manifest's declaration of the workload, absent for deletions
+	Workload *manifestWorkload
+	// The machines running the workload on the target node, each keyed by its ID, or the
+	// replicas of the workload's deploy set on the target cluster
+	Machines map[string]string
+	Replicas int
+	Changes  []string
+}
+
+// The changes needed to bring a node or cluster in line with a manifest
+type plan struct {
+	Manifest *manifest
+	Target   string
+	Cluster  bool
+	Entries  []planEntry
+}
+
+func (p *plan) changed() bool {
+	for _, entry := range p.Entries {
+		if entry.Action != planUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Shows the changes applying the manifest would make, without making them
+func DiffManifest(ctx context.Context) error {
+	m, err := loadManifest(ApplyOpts.ManifestFile)
+	if err != nil {
+		return err
+	}
+
+	nodeClient, err := applyClient()
+	if err != nil {
+		return err
+	}
+
+	p, err := planManifest(nodeClient, m)
+	if err != nil {
+		return err
+	}
+
+	renderPlan(p)
+	return nil
+}
+
+// Creates, updates, scales and deletes workloads on the target node or cluster until it runs
+// exactly the workloads the manifest declares
+func ApplyManifest(ctx context.Context, logger *slog.Logger) error {
+	m, err := loadManifest(ApplyOpts.ManifestFile)
+	if err != nil {
+		return err
+	}
+
+	nodeClient, err := applyClient()
+	if err != nil {
+		return err
+	}
+
+	p, err := planManifest(nodeClient, m)
+	if err != nil {
+		return err
+	}
+
+	renderPlan(p)
+	if !p.changed() {
+		return nil
+	}
+	fmt.Println()
+
+	signer, err := claimsSignerFromOpts(ApplyOpts.ClaimsIssuerFile, ApplyOpts.VaultTransitKey)
+	if err != nil {
+		return err
+	}
+
+	xkeyRaw, err := os.ReadFile(ApplyOpts.PublisherXkeyFile)
+	if err != nil {
+		return err
+	}
+	xkey, err := nkeys.FromCurveSeed(xkeyRaw)
+	if err != nil {
+		return err
+	}
+
+	if p.Cluster {
+		return applyToCluster(nodeClient, p, signer, xkey)
+	}
+	return applyToNode(nodeClient, p, signer, xkey)
+}
+
+func applyClient() (*controlapi.Client, error) {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return nil, err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	// nodes deploy a replacement, and may wait for it to become healthy, before responding to
+	// an update request
+	return controlapi.NewApiClientWithNamespace(nc, Opts.Timeout+ApplyOpts.UpdateHealthTimeout+time.Minute, Opts.Namespace, log), nil
+}
+
+// Compares the manifest with the workloads running on the target node, or the deploy sets of
+// the target cluster. Only workloads labeled as applied from the manifest are ever deleted
+func planManifest(nodeClient *controlapi.Client, m *manifest) (*plan, error) {
+	p := &plan{Manifest: m, Target: ApplyOpts.TargetNode, Cluster: ApplyOpts.Cluster}
+
+	var err error
+	if p.Cluster {
+		err = p.planCluster(nodeClient)
+	} else {
+		err = p.planNode(nodeClient)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(p.Entries, func(i, j int) bool {
+		return p.Entries[i].Name < p.Entries[j].Name
+	})
+	return p, nil
+}
+
+func (p *plan) planNode(nodeClient *controlapi.Client) error {
+	for _, w := range p.Manifest.Workloads {
+		if w.Replicas > 1 {
+			return fmt.Errorf("workload %s has %d replicas, which may only be applied to a cluster", w.Name, w.Replicas)
+		}
+	}
+
+	info, err := nodeClient.NodeInfo(p.Target)
+	if err != nil {
+		return err
+	}
+
+	// machines applied from the manifest, by workload name
+	running := make(map[string][]controlapi.MachineSummary)
+	for _, machine := range info.Machines {
+		if machine.Labels[manifestLabel] == p.Manifest.Name {
+			running[machine.Workload.Name] = append(running[machine.Workload.Name], machine)
+		}
+	}
+
+	for i := range p.Manifest.Workloads {
+		w := &p.Manifest.Workloads[i]
+		entry := planEntry{Name: w.Name, Workload: w, Machines: make(map[string]string), Replicas: 1}
+
+		machines := running[w.Name]
+		delete(running, w.Name)
+		if len(machines) == 0 {
+			entry.Action = planCreate
+			p.Entries = append(p.Entries, entry)
+			continue
+		}
+
+		entry.Action = planUnchanged
+		for _, machine := range machines {
+			entry.Machines[machine.Id] = p.Target
+			if machine.Labels[manifestHashLabel] == w.hash() || entry.Changes != nil {
+				continue
+			}
+
+			desc, err := nodeClient.DescribeWorkload(p.Target, machine.Id)
+			if err != nil {
+				return err
+			}
+			entry.Action = planUpdate
+			entry.Changes = w.changesFrom(desc)
+		}
+		p.Entries = append(p.Entries, entry)
+	}
+
+	for name, machines := range running {
+		entry := planEntry{Action: planDelete, Name: name, Machines: make(map[string]string)}
+		for _, machine := range machines {
+			entry.Machines[machine.Id] = p.Target
+		}
+		p.Entries = append(p.Entries, entry)
+	}
+
+	return nil
+}
+
+func (p *plan) planCluster(nodeClient *controlapi.Client) error {
+	res, err := nodeClient.DeploySetStatus(p.Target, "")
+	if err != nil {
+		return err
+	}
+
+	sets := make(map[string]controlapi.DeploySetStatus)
+	for _, set := range res.Sets {
+		sets[set.Name] = set
+	}
+
+	// describes a replica of the set, whose labels record the manifest it was applied from
+	describe := func(set controlapi.DeploySetStatus) (*controlapi.DescribeResponse, error) {
+		if len(set.Replicas) == 0 {
+			return nil, nil
+		}
+		return nodeClient.DescribeWorkload(set.Replicas[0].NodeId, set.Replicas[0].MachineId)
+	}
+	replicas := func(set controlapi.DeploySetStatus) map[string]string {
+		machines := make(map[string]string, len(set.Replicas))
+		for _, replica := range set.Replicas {
+			machines[replica.MachineId] = replica.NodeId
+		}
+		return machines
+	}
+
+	for i := range p.Manifest.Workloads {
+		w := &p.Manifest.Workloads[i]
+		entry := planEntry{Name: w.Name, Workload: w, Replicas: w.replicas()}
+
+		set, ok := sets[w.Name]
+		delete(sets, w.Name)
+		if !ok {
+			entry.Action = planCreate
+			p.Entries = append(p.Entries, entry)
+			continue
+		}
+		entry.Machines = replicas(set)
+
+		desc, err := describe(set)
+		if err != nil {
+			return err
+		}
+		if desc != nil && desc.Request.Labels[manifestLabel] != p.Manifest.Name {
+			return fmt.Errorf("deploy set %s in cluster %s was not applied from manifest %s", set.Name, p.Target, p.Manifest.Name)
+		}
+
+		switch {
+		case desc != nil && desc.Request.Labels[manifestHashLabel] != w.hash():
+			entry.Action = planUpdate
+			entry.Changes = w.changesFrom(desc)
+			if set.Desired != entry.Replicas {
+				entry.Changes = append(entry.Changes, fmt.Sprintf("replicas: %d → %d", set.Desired, entry.Replicas))
+			}
+		case set.Desired != entry.Replicas:
+			entry.Action = planScale
+			entry.Changes = []string{fmt.Sprintf("replicas: %d → %d", set.Desired, entry.Replicas)}
+		default:
+			entry.Action = planUnchanged
+		}
+		p.Entries = append(p.Entries, entry)
+	}
+
+	for _, set := range sets {
+		desc, err := describe(set)
+		if err != nil {
+			return err
+		}
+		if desc == nil || desc.Request.Labels[manifestLabel] != p.Manifest.Name {
+			continue
+		}
+		p.Entries = append(p.Entries, planEntry{Action: planDelete, Name: set.Name, Machines: replicas(set), Replicas: set.Desired})
+	}
+
+	return nil
+}
+
+func applyToNode(nodeClient *controlapi.Client, p *plan, signer controlapi.ClaimsSigner, xkey nkeys.KeyPair) error {
+	info, err := nodeClient.NodeInfo(p.Target)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, entry := range p.Entries {
+		var err error
+		switch entry.Action {
+		case planCreate:
+			var request *controlapi.DeployRequest
+			request, err = entry.Workload.deployRequest(p.Manifest.Name, signer, xkey, p.Target, info.PublicXKey)
+			if err != nil {
+				break
+			}
+			err = nodeClient.ReplicateArtifact(request, info.Tags[controlapi.TagJsDomain])
+			if err != nil {
+				break
+			}
+
+			var resp *controlapi.RunResponse
+			resp, err = nodeClient.StartWorkload(request)
+			if err == nil {
+				renderRunResponse(p.Target, resp)
+				fmt.Println()
+			}
+		case planUpdate:
+			for machineId := range entry.Machines {
+				var request *controlapi.DeployRequest
+				request, err = entry.Workload.deployRequest(p.Manifest.Name, signer, xkey, p.Target, info.PublicXKey)
+				if err != nil {
+					break
+				}
+				err = nodeClient.ReplicateArtifact(request, info.Tags[controlapi.TagJsDomain])
+				if err != nil {
+					break
+				}
+
+				var resp *controlapi.WorkloadUpdateResponse
+				resp, err = nodeClient.UpdateWorkload(&controlapi.WorkloadUpdateRequest{
+					WorkloadId:          machineId,
+					TargetNode:          p.Target,
+					Strategy:            entry.Workload.UpdateStrategy,
+					HealthTimeoutMillis: int(ApplyOpts.UpdateHealthTimeout.Milliseconds()),
+					Request:             request,
+				})
+				if err != nil {
+					break
+				}
+				renderWorkloadUpdateResponse(p.Target, resp)
+			}
+		case planDelete:
+			for machineId := range entry.Machines {
+				var request *controlapi.StopRequest
+				request, err = controlapi.NewSignedStopRequest(machineId, entry.Name, p.Target, signer)
+				if err != nil {
+					break
+				}
+
+				var resp *controlapi.StopResponse
+				resp, err = nodeClient.StopWorkload(request)
+				if err != nil {
+					break
+				}
+				renderStopResponse(resp)
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("⛔ Failed to %s workload '%s': %s\n", entry.Action, entry.Name, err)
+			errs = append(errs, fmt.Errorf("%s %s: %w", entry.Action, entry.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func applyToCluster(nodeClient *controlapi.Client, p *plan, signer controlapi.ClaimsSigner, xkey nkeys.KeyPair) error {
+	// workloads deployed to a cluster are encrypted for its leader
+	cluster, err := nodeClient.ClusterInfo(p.Target)
+	if err != nil {
+		return err
+	}
+
+	create := func(w *manifestWorkload) error {
+		request, err := w.deployRequest(p.Manifest.Name, signer, xkey, cluster.Leader, cluster.LeaderPublicXKey)
+		if err != nil {
+			return err
+		}
+
+		res, err := nodeClient.CreateDeploySet(p.Target, &controlapi.DeploySetRequest{
+			Name:     w.Name,
+			Replicas: w.replicas(),
+			NodeTags: w.NodeTags,
+			Request:  request,
+		})
+		if err != nil {
+			return err
+		}
+		renderDeploySets(res)
+		return nil
+	}
+
+	errs := make([]error, 0)
+	for _, entry := range p.Entries {
+		if entry.Action == planUnchanged {
+			continue
+		}
+
+		// deploy sets are named for their workload
+		issuerJwt, err := controlapi.DeploySetIssuerJwt(entry.Name, signer)
+		if err == nil {
+			switch entry.Action {
+			case planCreate:
+				err = create(entry.Workload)
+			case planUpdate:
+				// a deploy set's workload can't be changed, so the set is replaced
+				_, err = nodeClient.DeleteDeploySet(p.Target, entry.Name, issuerJwt)
+				if err == nil {
+					err = create(entry.Workload)
+				}
+			case planScale:
+				var res *controlapi.DeploySetResponse
+				res, err = nodeClient.ScaleDeploySet(p.Target, entry.Name, entry.Replicas, issuerJwt)
+				if err == nil {
+					renderDeploySets(res)
+				}
+			case planDelete:
+				_, err = nodeClient.DeleteDeploySet(p.Target, entry.Name, issuerJwt)
+				if err == nil {
+					fmt.Printf("✅ Deploy set '%s' deleted.\n", entry.Name)
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("⛔ Failed to %s deploy set '%s': %s\n", entry.Action, entry.Name, err)
+			errs = append(errs, fmt.Errorf("%s %s: %w", entry.Action, entry.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func renderPlan(p *plan) {
+	target := fmt.Sprintf("node %s", p.Target)
+	if p.Cluster {
+		target = fmt.Sprintf("cluster %s", p.Target)
+	}
+
+	if len(p.Entries) == 0 {
+		fmt.Printf("Manifest %s declares no workloads, and none applied from it run on %s\n", p.Manifest.Name, target)
+		return
+	}
+
+	table := newTableWriter(fmt.Sprintf("Changes applying manifest %s to %s", p.Manifest.Name, target))
+	if p.Cluster {
+		table.AddHeaders("Action", "Workload", "Replicas", "Changes")
+	} else {
+		table.AddHeaders("Action", "Workload", "Machines", "Changes")
+	}
+
+	for _, entry := range p.Entries {
+		var placement string
+		if p.Cluster {
+			placement = fmt.Sprintf("%d / %d", len(entry.Machines), entry.Replicas)
+		} else {
+			machines := make([]string, 0, len(entry.Machines))
+			for id := range entry.Machines {
+				machines = append(machines, id)
+			}
+			sort.Strings(machines)
+			placement = strings.Join(machines, "\n")
+		}
+		table.AddRow(planSymbol(entry.Action)+" "+entry.Action, entry.Name, placement, strings.Join(entry.Changes, "\n"))
+	}
+
+	fmt.Println(table.Render())
+}
+
+func planSymbol(action string) string {
+	switch action {
+	case planCreate:
+		return "+"
+	case planDelete:
+		return "-"
+	case planUpdate, planScale:
+		return "~"
+	default:
+		return " "
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+)
+
+const (
+	// Object store to which `nex run` uploads local and OCI artifacts for nodes to fetch
+	artifactStoreName = "NEXCACHE"
+	// Size of the chunks in which artifacts are uploaded
+	artifactChunkSize = 128 * 1024
+)
+
+// Resolves the workload URL given to `nex run` to the location nodes fetch the artifact from.
+// Local files (a path, or a file:// URL) and OCI artifacts (oci://{registry}/{repository}:{tag}
+// or @{digest}) are uploaded to the artifact object store first, defaulting the workload's name,
+// type and expected digest from the artifact where they aren't given. Other URLs are returned as
+// they are
+func stageWorkloadArtifact(nc *nats.Conn) (string, error) {
+	location := RunOpts.WorkloadUrl
+	var filename, path string
+
+	switch location.Scheme {
+	case "", "file":
+		path = location.Path
+		filename = filepath.Base(path)
+	case "oci":
+		ref, err := parseOCIReference(location)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("📥 Pulling %s\n", ref)
+		path, filename, err = pullOCIArtifact(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to pull %s: %s", ref, err)
+		}
+		defer func() {
+			_ = os.Remove(path)
+		}()
+	default:
+		return location.String(), nil
+	}
+
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	if RunOpts.Digest != "" && !strings.EqualFold(RunOpts.Digest, digest) {
+		return "", fmt.Errorf("artifact digest mismatch; expected %s, got %s", RunOpts.Digest, digest)
+	}
+	RunOpts.Digest = digest
+
+	if RunOpts.Name == "" && RunOpts.DeployTokenFile == "" {
+		RunOpts.Name = workloadNameFromFile(filename)
+		if RunOpts.Name == "" {
+			return "", fmt.Errorf("unable to derive a workload name from %s; give one with --name", filename)
+		}
+	}
+	if RunOpts.WorkloadType == "" {
+		RunOpts.WorkloadType = workloadTypeFromFile(filename)
+	}
+
+	return uploadArtifact(nc, path, digest)
+}
+
+// Uploads the file, in chunks, to the artifact object store under a key derived from its digest,
+// returning its location. Artifacts already in the store aren't uploaded again
+func uploadArtifact(nc *nats.Conn, path string, digest string) (string, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return "", err
+	}
+
+	bucket, err := js.ObjectStore(artifactStoreName)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		bucket, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      artifactStoreName,
+			Description: "Workload artifacts uploaded by the NEX CLI",
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("sha256-%s", digest)
+	location := fmt.Sprintf("nats://%s/%s", artifactStoreName, key)
+
+	if info, err := bucket.GetInfo(key); err == nil && !info.Deleted {
+		fmt.Printf("📦 Artifact %s already uploaded to %s\n", shortDigest(digest), artifactStoreName)
+		return location, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := bucket.Put(&nats.ObjectMeta{
+		Name:        key,
+		Description: filepath.Base(path),
+		Opts:        &nats.ObjectMetaOptions{ChunkSize: artifactChunkSize},
+	}, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload artifact: %s", err)
+	}
+
+	fmt.Printf("📦 Uploaded artifact %s to %s (%d bytes in %d chunks)\n", shortDigest(digest), artifactStoreName, info.Size, info.Chunks)
+	return location, nil
+}
+
+// Returns the hex-encoded SHA-256 digest of the file's contents
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Derives a workload name, which must be alphabetic (lowercase), from the artifact's file name
+func workloadNameFromFile(filename string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return -1
+	}, base)
+}
+
+func workloadTypeFromFile(filename string) string {
+	switch strings.TrimPrefix(filepath.Ext(filename), ".") {
+	case fileExtensionJS, fileExtensionTgz:
+		return agentapi.NexExecutionProviderV8
+	case fileExtensionWasm:
+		return agentapi.NexExecutionProviderWasm
+	case fileExtensionPyz, fileExtensionPex:
+		return agentapi.NexExecutionProviderPython
+	default:
+		return defaultWorkloadType
+	}
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
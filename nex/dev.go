@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/models"
+	"github.com/synadia-io/nex/internal/scaffold"
+)
+
+// Directories whose changes never trigger a rebuild: version control, dependencies and the
+// usual build outputs
+var devIgnoredDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"dist":         true,
+	"target":       true,
+}
+
+// How long each deployment may take to become ready
+const devReadyTimeout = 30 * time.Second
+
+// The workload deployed by the latest iteration of the development loop
+type devDeployment struct {
+	mutex     sync.Mutex
+	name      string
+	nodeId    string
+	machineId string
+}
+
+func (d *devDeployment) set(name string, nodeId string, machineId string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.name, d.nodeId, d.machineId = name, nodeId, machineId
+}
+
+func (d *devDeployment) get() (string, string, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.name, d.nodeId, d.machineId
+}
+
+// Watches a project's source directory and, whenever a file changes, rebuilds its artifact,
+// redeploys it to a nearby node and, optionally, sends it a request. The workload's logs and
+// trigger executions are shown as they happen. The deployed workload is stopped on exit
+func RunDevLoop(ctx context.Context, logger *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manifestFile := filepath.Join(DevOpts.Dir, scaffold.ManifestFilename)
+	manifest, err := scaffold.LoadManifest(manifestFile)
+	if err != nil {
+		return fmt.Errorf("failed to load manifest: %s", err)
+	}
+
+	buildCommands := DevOpts.BuildCommands
+	if len(buildCommands) == 0 {
+		buildCommands = manifest.Build
+	}
+
+	// redeploys reuse devrun, which stops the previous deployment of the workload
+	DevRunOpts.ManifestFile = manifestFile
+	DevRunOpts.AutoStop = true
+
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	quiet := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, quiet)
+
+	deployment := &devDeployment{}
+	err = tailDevWorkload(ctx, nodeClient, deployment)
+	if err != nil {
+		return err
+	}
+
+	iterate := func() {
+		if len(buildCommands) > 0 {
+			fmt.Printf("🔨 Building %s\n", manifest.Name)
+			err := buildDevWorkload(ctx, DevOpts.Dir, buildCommands)
+			if err != nil {
+				fmt.Printf("⛔ Build failed: %s\n", err)
+				return
+			}
+		}
+
+		nodeId, resp, err := deployDevWorkload(nc, nodeClient, DevOpts.TargetNode)
+		if err != nil {
+			fmt.Printf("⛔ Deploy failed: %s\n", err)
+			return
+		}
+		renderRunResponse(nodeId, resp)
+		fmt.Println()
+		if !resp.Started {
+			return
+		}
+		deployment.set(resp.Name, nodeId, resp.MachineId)
+
+		readiness, err := nodeClient.AwaitWorkload(nodeId, resp, devReadyTimeout)
+		if err != nil {
+			fmt.Printf("⛔ Workload did not become ready: %s\n", err)
+			return
+		}
+		renderWorkloadReadiness(readiness)
+
+		if DevOpts.Payload != "" {
+			sendDevPayload(nc, manifest)
+		}
+	}
+
+	// with no build, the artifact itself is watched
+	watched := map[string]bool{}
+	if len(buildCommands) == 0 {
+		watched[manifest.Artifact] = true
+	}
+
+	snapshot := devSnapshot(DevOpts.Dir, manifest.Artifact, watched)
+	iterate()
+	fmt.Printf("👀 Watching %s for changes. Press ctrl-c to stop\n", DevOpts.Dir)
+
+	ticker := time.NewTicker(DevOpts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopDevWorkload(nodeClient, deployment)
+			return nil
+		case <-ticker.C:
+			current := devSnapshot(DevOpts.Dir, manifest.Artifact, watched)
+			if maps.Equal(current, snapshot) {
+				continue
+			}
+
+			// wait for a burst of changes, e.g. an editor saving several files, to settle
+			for {
+				time.Sleep(DevOpts.PollInterval)
+				settled := devSnapshot(DevOpts.Dir, manifest.Artifact, watched)
+				if maps.Equal(settled, current) {
+					break
+				}
+				current = settled
+			}
+			snapshot = current
+
+			fmt.Println()
+			fmt.Println("🔁 Change detected")
+			iterate()
+		}
+	}
+}
+
+// Returns the modification time and size of each file beneath the directory, skipping ignored
+// directories and the artifact, along with the given watched files
+func devSnapshot(dir string, artifact string, watched map[string]bool) map[string]string {
+	snapshot := make(map[string]string)
+	record := func(path string, info fs.FileInfo) {
+		snapshot[path] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+	}
+
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && devIgnoredDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == artifact {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			record(path, info)
+		}
+		return nil
+	})
+
+	for path := range watched {
+		if info, err := os.Stat(path); err == nil {
+			record(path, info)
+		}
+	}
+
+	return snapshot
+}
+
+// Runs each of the build commands in turn in the project directory, stopping at the first
+// which fails
+func buildDevWorkload(ctx context.Context, dir string, commands []string) error {
+	for _, command := range commands {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("%s: %s", command, err)
+		}
+	}
+	return nil
+}
+
+// Sends the --payload to the workload's first trigger subject and shows the reply
+func sendDevPayload(nc *nats.Conn, manifest *scaffold.Manifest) {
+	if len(manifest.TriggerSubjects) == 0 {
+		fmt.Println("⛔ The workload has no trigger subject to send the payload to")
+		return
+	}
+
+	subject := manifest.TriggerSubjects[0]
+	start := time.Now()
+	resp, err := nc.Request(subject, []byte(DevOpts.Payload), Opts.Timeout)
+	if err != nil {
+		fmt.Printf("⛔ Request on %s failed: %s\n", subject, err)
+		return
+	}
+	fmt.Printf("📨 Reply on %s after %s: %s\n", subject, time.Since(start).Round(time.Millisecond), string(resp.Data))
+}
+
+// Shows the logs and trigger executions of the deployed workload until the context is done
+func tailDevWorkload(ctx context.Context, nodeClient *controlapi.Client, deployment *devDeployment) error {
+	logs, err := nodeClient.MonitorLogs(Opts.Namespace, "*", "*", "*", 256)
+	if err != nil {
+		return err
+	}
+	events, err := nodeClient.MonitorEvents(Opts.Namespace, "*", 256)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry := <-logs:
+				_, _, machineId := deployment.get()
+				if machineId == "" || entry.MachineId != machineId {
+					continue
+				}
+				fmt.Printf("%s %-8s %-5s %s\n", entry.LoggedAt(time.Now()).Local().Format(time.TimeOnly), entry.Source, entry.Level, entry.Text)
+			case event := <-events:
+				renderDevEvent(deployment, event)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func renderDevEvent(deployment *devDeployment, event controlapi.EmittedEvent) {
+	name, _, _ := deployment.get()
+
+	switch event.EventType {
+	case agentapi.FunctionExecutionSucceededType:
+		evt := struct {
+			Name    string `json:"workload_name"`
+			Subject string `json:"trigger_subject"`
+			Elapsed int64  `json:"elapsed_nanos"`
+		}{}
+		if event.DataAs(&evt) != nil || evt.Name != name {
+			return
+		}
+		fmt.Printf("%s ⚡ %s executed in %s\n", time.Now().Format(time.TimeOnly), evt.Subject, time.Duration(evt.Elapsed))
+	case agentapi.FunctionExecutionFailedType:
+		evt := struct {
+			Name    string `json:"workload_name"`
+			Subject string `json:"trigger_subject"`
+			Error   string `json:"error"`
+		}{}
+		if event.DataAs(&evt) != nil || evt.Name != name {
+			return
+		}
+		fmt.Printf("%s ⛔ %s failed: %s\n", time.Now().Format(time.TimeOnly), evt.Subject, evt.Error)
+	case controlapi.WorkloadFailedEventType:
+		evt := &controlapi.WorkloadFailedEvent{}
+		if event.DataAs(evt) != nil || evt.Name != name {
+			return
+		}
+		fmt.Printf("%s ⛔ Workload failed: %s\n", time.Now().Format(time.TimeOnly), evt.Reason)
+	}
+}
+
+// Stops the workload deployed by the development loop, unless it's to be kept
+func stopDevWorkload(nodeClient *controlapi.Client, deployment *devDeployment) {
+	name, nodeId, machineId := deployment.get()
+	if DevOpts.Keep || machineId == "" {
+		return
+	}
+
+	err := func() error {
+		var issuerKp nkeys.KeyPair
+		var err error
+		if RunOpts.ClaimsIssuerFile != "" {
+			issuerKp, err = readIssuer(RunOpts.ClaimsIssuerFile)
+		} else {
+			issuerKp, err = readOrGenerateIssuer()
+		}
+		if err != nil {
+			return err
+		}
+
+		request, err := controlapi.NewStopRequest(machineId, name, nodeId, issuerKp)
+		if err != nil {
+			return err
+		}
+		resp, err := nodeClient.StopWorkload(request)
+		if err != nil {
+			return err
+		}
+		if !resp.Stopped {
+			return errors.New("node failed to stop the workload")
+		}
+		return nil
+	}()
+	if err != nil {
+		fmt.Printf("\n⛔ Failed to stop workload '%s' (%s): %s\n", name, machineId, err)
+		return
+	}
+	fmt.Printf("\n✅ Workload '%s' stopped.\n", name)
+}
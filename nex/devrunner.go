@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -17,6 +19,7 @@ import (
 	agentapi "github.com/synadia-io/nex/internal/agent-api"
 	controlapi "github.com/synadia-io/nex/internal/control-api"
 	"github.com/synadia-io/nex/internal/models"
+	"github.com/synadia-io/nex/internal/scaffold"
 )
 
 var (
@@ -27,7 +30,10 @@ const (
 	defaultFileMode     = os.FileMode(int(0770)) // owner and group r/w/x
 	defaultWorkloadType = agentapi.NexExecutionProviderELF
 	fileExtensionJS     = "js"
+	fileExtensionTgz    = "tgz" // a tarball of ES modules, for v8
 	fileExtensionWasm   = "wasm"
+	fileExtensionPyz    = "pyz"
+	fileExtensionPex    = "pex"
 
 	objectStoreName     = "NEXCLIFILES"
 	objectStoreMaxBytes = 100 * 1024 * 1024 // 100 MB
@@ -56,83 +62,163 @@ func RunDevWorkload(ctx context.Context, logger *slog.Logger) error {
 	// node "nearby"
 	nodeClient := controlapi.NewApiClientWithNamespace(nc, 750*time.Millisecond, Opts.Namespace, logger)
 
-	candidates, err := nodeClient.ListNodes()
+	nodeId, runResponse, err := deployDevWorkload(nc, nodeClient, "")
 	if err != nil {
 		return err
 	}
-	if len(candidates) == 0 {
-		return errors.New("unable to locate candidate node - no nodes discovered")
+	renderRunResponse(nodeId, runResponse)
+
+	return nil
+}
+
+// Uploads the workload file and deploys it to the given node, or the first node discovered if
+// none is given, returning the ID of the node it was deployed to
+func deployDevWorkload(nc *nats.Conn, nodeClient *controlapi.Client, nodeId string) (string, *controlapi.RunResponse, error) {
+	if nodeId == "" {
+		candidates, err := nodeClient.ListNodes()
+		if err != nil {
+			return "", nil, err
+		}
+		if len(candidates) == 0 {
+			return "", nil, errors.New("unable to locate candidate node - no nodes discovered")
+		}
+		nodeId = candidates[0].NodeId
 	}
-	target := candidates[0]
-	info, err := nodeClient.NodeInfo(target.NodeId)
+	info, err := nodeClient.NodeInfo(nodeId)
 	if err != nil {
-		return fmt.Errorf("failed to get node info for potential execution target: %s", err)
+		return "", nil, fmt.Errorf("failed to get node info for potential execution target: %s", err)
 	}
 
-	issuerKp, err := readOrGenerateIssuer()
+	manifest, err := applyManifest()
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+
+	var issuerKp nkeys.KeyPair
+	if RunOpts.ClaimsIssuerFile != "" {
+		issuerKp, err = readIssuer(RunOpts.ClaimsIssuerFile)
+	} else {
+		issuerKp, err = readOrGenerateIssuer()
+	}
+	if err != nil {
+		return "", nil, err
 	}
 	publisherXKey, err := readOrGeneratePublisher()
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	targetPublicXkey := info.PublicXKey
-	workloadUrl, workloadName, workloadType, err := uploadWorkload(nc, DevRunOpts.Filename)
+	workloadUrl, workloadName, workloadType, workloadDigest, err := uploadWorkload(nc, DevRunOpts.Filename)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	if manifest != nil {
+		workloadName = manifest.Name
+		workloadType = manifest.WorkloadType
 	}
 
 	if DevRunOpts.AutoStop {
 		for _, machine := range info.Machines {
 			if machine.Workload.Name == workloadName {
 				fmt.Printf("Workload %s (%s) already exists on the target. Attempting to stop it\n", workloadName, machine.Id)
-				stopRequest, err := controlapi.NewStopRequest(machine.Id, workloadName, target.NodeId, issuerKp)
+				stopRequest, err := controlapi.NewStopRequest(machine.Id, workloadName, nodeId, issuerKp)
 				if err != nil {
-					return err
+					return "", nil, err
 				}
 				stopResp, err := nodeClient.StopWorkload(stopRequest)
 				if err != nil {
-					return err
+					return "", nil, err
 				}
 				if !stopResp.Stopped {
-					return errors.New("target node failed to stop the existing workload. This may result in inconsistency or unexpected scale-out")
+					return "", nil, errors.New("target node failed to stop the existing workload. This may result in inconsistency or unexpected scale-out")
 				}
 				time.Sleep(500 * time.Millisecond)
 			}
 		}
 	}
 
+	secrets, err := nodeClient.SealEnvironment(nodeId, publisherXKey, RunOpts.Secrets)
+	if err != nil {
+		return "", nil, err
+	}
+
+	issuerChain, err := issuerChainFromOpts()
+	if err != nil {
+		return "", nil, err
+	}
+
+	egressPolicy, err := egressPolicyFromOpts()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stdin, err := stdinFromOpts()
+	if err != nil {
+		return "", nil, err
+	}
+
 	request, err := controlapi.NewDeployRequest(
-		controlapi.Argv(strings.Split(RunOpts.Argv, " ")),
+		controlapi.Argv(strings.Fields(RunOpts.Argv)),
+		controlapi.Stdin(stdin),
+		controlapi.ExecPerTrigger(RunOpts.ExecPerTrigger),
 		controlapi.Location(workloadUrl),
 		controlapi.Environment(RunOpts.Env),
+		secrets,
 		controlapi.Essential(RunOpts.Essential),
+		controlapi.MachineTemplate(RunOpts.MachineTemplate),
+		controlapi.MachineSize(RunOpts.VcpuCount, RunOpts.MemSizeMib),
 		controlapi.Issuer(issuerKp),
+		controlapi.IssuerChain(issuerChain...),
 		controlapi.SenderXKey(publisherXKey),
-		controlapi.TargetNode(target.NodeId),
+		controlapi.TargetNode(nodeId),
 		controlapi.TargetPublicXKey(targetPublicXkey),
 		controlapi.TriggerSubjects(RunOpts.TriggerSubjects),
+		controlapi.TriggerDelivery(RunOpts.TriggerDelivery),
+		controlapi.TriggerQueueGroups(RunOpts.TriggerQueueGroups),
+		controlapi.IdleTimeout(RunOpts.IdleTimeout),
+		controlapi.DeployTimeouts(RunOpts.AckTimeout, RunOpts.ReadyTimeout),
+		controlapi.TriggerConcurrencyLimit(RunOpts.MaxInFlight, RunOpts.TriggerQueueSize, RunOpts.TriggerOverflow),
+		controlapi.CronTriggers(cronTriggersFromOpts()),
 		controlapi.WorkloadName(workloadName),
 		controlapi.WorkloadType(workloadType),
 		controlapi.Checksum("abc12345TODOmakethisreal"),
-		controlapi.WorkloadDescription("Workload published in devmode"),
+		controlapi.WorkloadDescription(devRunDescription(manifest)),
+		controlapi.WorkloadDigest(workloadDigest),
+		controlapi.StreamArtifact(RunOpts.StreamArtifact),
+		controlapi.WorkloadLabels(RunOpts.Labels),
+		controlapi.WorkloadHealthCheck(healthCheckFromOpts()),
+		controlapi.WorkloadReadinessProbe(readinessProbeFromOpts()),
+		controlapi.WorkloadCredentials(credentialsFromOpts()),
+		controlapi.WorkloadPreStartHook(workloadHookFromOpts(RunOpts.PreStartHook)),
+		controlapi.WorkloadPostStopHook(workloadHookFromOpts(RunOpts.PostStopHook)),
+		controlapi.WorkloadMemorySoftLimit(memorySoftLimitFromOpts()),
+		controlapi.WorkloadLogLimits(logLimitsFromOpts()),
+		controlapi.WorkloadEgressPolicy(egressPolicy),
+		controlapi.WorkloadSandboxProfile(RunOpts.SandboxProfile),
+		controlapi.SecretReferences(RunOpts.SecretRefs),
+		controlapi.WorkloadStableIP(RunOpts.StableIP),
+		controlapi.WorkloadDNSName(RunOpts.DNSName),
+		controlapi.WorkloadVolume(RunOpts.VolumeSizeMib),
 	)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+
+	err = nodeClient.ReplicateArtifact(request, info.Tags[controlapi.TagJsDomain])
+	if err != nil {
+		return "", nil, err
 	}
 
 	runResponse, err := nodeClient.StartWorkload(request)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	renderRunResponse(target.NodeId, runResponse)
 
-	return nil
+	return nodeId, runResponse, nil
 }
 
-func uploadWorkload(nc *nats.Conn, filename string) (string, string, string, error) {
+func uploadWorkload(nc *nats.Conn, filename string) (string, string, string, string, error) {
 	js, err := nc.JetStream()
 	if err != nil {
 		panic(err)
@@ -146,32 +232,35 @@ func uploadWorkload(nc *nats.Conn, filename string) (string, string, string, err
 			MaxBytes:    objectStoreMaxBytes,
 		})
 		if err != nil {
-			return "", "", "", err
+			return "", "", "", "", err
 		}
 	}
 	bytes, err := os.ReadFile(filename)
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 	key := filepath.Base(filename)
 	key = strings.ReplaceAll(key, ".", "")
 
 	_, err = bucket.PutBytes(key, bytes)
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", "", err
 	}
 
 	var workloadType string
 	switch strings.Replace(filepath.Ext(DevRunOpts.Filename), ".", "", 1) {
-	case fileExtensionJS:
+	case fileExtensionJS, fileExtensionTgz:
 		workloadType = agentapi.NexExecutionProviderV8
 	case fileExtensionWasm:
 		workloadType = agentapi.NexExecutionProviderWasm
+	case fileExtensionPyz, fileExtensionPex:
+		workloadType = agentapi.NexExecutionProviderPython
 	default:
 		workloadType = defaultWorkloadType
 	}
 
-	return fmt.Sprintf("nats://%s/%s", objectStoreName, key), key, workloadType, nil
+	digest := sha256.Sum256(bytes)
+	return fmt.Sprintf("nats://%s/%s", objectStoreName, key), key, workloadType, hex.EncodeToString(digest[:]), nil
 }
 
 func readOrGenerateIssuer() (nkeys.KeyPair, error) {
@@ -189,6 +278,57 @@ func readOrGenerateIssuer() (nkeys.KeyPair, error) {
 	}
 }
 
+func readIssuer(filename string) (nkeys.KeyPair, error) {
+	seed, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return nkeys.FromSeed(seed)
+}
+
+// Fills in the options of a devrun from the given manifest, if any. Options given on the command
+// line take precedence over those in the manifest
+func applyManifest() (*scaffold.Manifest, error) {
+	if DevRunOpts.ManifestFile == "" {
+		if DevRunOpts.Filename == "" {
+			return nil, errors.New("a file to run or a manifest is required")
+		}
+		return nil, nil
+	}
+
+	manifest, err := scaffold.LoadManifest(DevRunOpts.ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %s", err)
+	}
+
+	if DevRunOpts.Filename == "" {
+		DevRunOpts.Filename = manifest.Artifact
+	}
+	if RunOpts.ClaimsIssuerFile == "" {
+		RunOpts.ClaimsIssuerFile = manifest.Issuer
+	}
+	if RunOpts.Argv == "" {
+		RunOpts.Argv = strings.Join(manifest.Argv, " ")
+	}
+	if len(RunOpts.TriggerSubjects) == 0 {
+		RunOpts.TriggerSubjects = manifest.TriggerSubjects
+	}
+	for k, v := range manifest.Environment {
+		if _, ok := RunOpts.Env[k]; !ok {
+			RunOpts.Env[k] = v
+		}
+	}
+
+	return manifest, nil
+}
+
+func devRunDescription(manifest *scaffold.Manifest) string {
+	if manifest != nil && manifest.Description != "" {
+		return manifest.Description
+	}
+	return "Workload published in devmode"
+}
+
 func readOrGeneratePublisher() (nkeys.KeyPair, error) {
 	filename := path.Join(nexDir, "publisher.xk")
 	bytes, err := os.ReadFile(filename)
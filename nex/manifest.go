@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"gopkg.in/yaml.v3"
+)
+
+// Labels given to every workload applied from a manifest: the name of the manifest, by which the
+// workloads it no longer declares are found and stopped, and the hash of the workload's spec, by
+// which changed workloads are found
+const (
+	manifestLabel     = "nex.manifest"
+	manifestHashLabel = "nex.manifest.hash"
+)
+
+var validManifestWorkloadName = regexp.MustCompile(`^[a-z]+$`)
+
+// A declarative description of the workloads which should run on a node or cluster
+type manifest struct {
+	Name      string             `yaml:"name" json:"name"`
+	Workloads []manifestWorkload `yaml:"workloads" json:"workloads"`
+}
+
+type manifestWorkload struct {
+	Name            string            `yaml:"name" json:"name"`
+	Url             string            `yaml:"url" json:"url"`
+	Type            string            `yaml:"type" json:"type"`
+	Description     string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Argv            []string          `yaml:"argv,omitempty" json:"argv,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Essential       bool              `yaml:"essential,omitempty" json:"essential,omitempty"`
+	TriggerSubjects []string          `yaml:"trigger_subjects,omitempty" json:"trigger_subjects,omitempty"`
+	TriggerDelivery string            `yaml:"trigger_delivery,omitempty" json:"trigger_delivery,omitempty"`
+	Cron            []string          `yaml:"cron,omitempty" json:"cron,omitempty"`
+	CronTimezone    string            `yaml:"cron_timezone,omitempty" json:"cron_timezone,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Digest          string            `yaml:"digest,omitempty" json:"digest,omitempty"`
+	Health          *manifestHealth   `yaml:"health,omitempty" json:"health,omitempty"`
+
+	// Resources
+	Template string `yaml:"template,omitempty" json:"template,omitempty"`
+	Vcpus    int    `yaml:"vcpus,omitempty" json:"vcpus,omitempty"`
+	MemoryMb int    `yaml:"memory_mb,omitempty" json:"memory_mb,omitempty"`
+
+	// Placement, which only applies to workloads applied to a cluster
+	Replicas      int               `yaml:"replicas,omitempty" json:"-"`
+	NodeTags      map[string]string `yaml:"node_tags,omitempty" json:"node_tags,omitempty"`
+	NodeSelectors []string          `yaml:"node_selectors,omitempty" json:"node_selectors,omitempty"`
+	AntiAffinity  []string          `yaml:"anti_affinity,omitempty" json:"anti_affinity,omitempty"`
+
+	// How a changed workload applied to a node is replaced, one of recreate (the default) or rolling
+	UpdateStrategy string `yaml:"update_strategy,omitempty" json:"-"`
+}
+
+type manifestHealth struct {
+	Exec     string        `yaml:"exec,omitempty" json:"exec,omitempty"`
+	HTTP     string        `yaml:"http,omitempty" json:"http,omitempty"`
+	NATS     string        `yaml:"nats,omitempty" json:"nats,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+}
+
+func loadManifest(path string) (*manifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	err = m.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *manifest) validate() error {
+	errs := make([]error, 0)
+	if m.Name == "" || strings.ContainsAny(m.Name, ".*> ") {
+		errs = append(errs, fmt.Errorf("manifest name must be given, without dots, wildcards or spaces: %q", m.Name))
+	}
+
+	names := make(map[string]bool)
+	for _, w := range m.Workloads {
+		if !validManifestWorkloadName.MatchString(w.Name) {
+			errs = append(errs, fmt.Errorf("workload name must be all lowercase letters: %q", w.Name))
+		}
+		if names[w.Name] {
+			errs = append(errs, fmt.Errorf("workload %s is declared more than once", w.Name))
+		}
+		names[w.Name] = true
+
+		if _, err := url.Parse(w.Url); err != nil || w.Url == "" {
+			errs = append(errs, fmt.Errorf("workload %s requires a valid url: %q", w.Name, w.Url))
+		}
+		if w.Type == "" {
+			errs = append(errs, fmt.Errorf("workload %s requires a type", w.Name))
+		}
+		if w.Replicas < 0 {
+			errs = append(errs, fmt.Errorf("workload %s has negative replicas", w.Name))
+		}
+		for _, expr := range w.NodeSelectors {
+			if _, err := controlapi.ParseNodeSelector(expr); err != nil {
+				errs = append(errs, fmt.Errorf("workload %s has an invalid node selector: %w", w.Name, err))
+			}
+		}
+		switch w.UpdateStrategy {
+		case "", controlapi.UpdateStrategyRecreate, controlapi.UpdateStrategyRolling:
+		default:
+			errs = append(errs, fmt.Errorf("workload %s has an unsupported update strategy: %q", w.Name, w.UpdateStrategy))
+		}
+		for key := range w.Labels {
+			if key == manifestLabel || key == manifestHashLabel {
+				errs = append(errs, fmt.Errorf("workload %s may not set the reserved label %s", w.Name, key))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The number of replicas of the workload to run on a cluster
+func (w *manifestWorkload) replicas() int {
+	if w.Replicas == 0 {
+		return 1
+	}
+	return w.Replicas
+}
+
+// Hash of the workload's spec, excluding its replicas and update strategy, which change how the
+// workload is applied rather than the workload itself
+func (w *manifestWorkload) hash() string {
+	raw, _ := json.Marshal(w)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// The workload's labels, including those recording the manifest it was applied from
+func (w *manifestWorkload) labels(manifestName string) map[string]string {
+	labels := make(map[string]string, len(w.Labels)+2)
+	for k, v := range w.Labels {
+		labels[k] = v
+	}
+	labels[manifestLabel] = manifestName
+	labels[manifestHashLabel] = w.hash()
+	return labels
+}
+
+func (w *manifestWorkload) healthCheck() *controlapi.HealthCheck {
+	if w.Health == nil {
+		return nil
+	}
+
+	hc := &controlapi.HealthCheck{
+		IntervalMillis: int(w.Health.Interval.Milliseconds()),
+	}
+	switch {
+	case w.Health.Exec != "":
+		hc.Type = "exec"
+		hc.Command = strings.Fields(w.Health.Exec)
+	case w.Health.HTTP != "":
+		hc.Type = "http"
+		hc.URL = &w.Health.HTTP
+	case w.Health.NATS != "":
+		hc.Type = "nats"
+		hc.Subject = &w.Health.NATS
+	default:
+		return nil
+	}
+	return hc
+}
+
+func (w *manifestWorkload) cronTriggers() []controlapi.CronTrigger {
+	if len(w.Cron) == 0 {
+		return nil
+	}
+
+	triggers := make([]controlapi.CronTrigger, 0, len(w.Cron))
+	for _, schedule := range w.Cron {
+		trigger := controlapi.CronTrigger{Schedule: schedule}
+		if w.CronTimezone != "" {
+			trigger.Timezone = &w.CronTimezone
+		}
+		triggers = append(triggers, trigger)
+	}
+	return triggers
+}
+
+func (w *manifestWorkload) placement() *controlapi.PlacementConstraints {
+	if len(w.NodeSelectors) == 0 && len(w.AntiAffinity) == 0 {
+		return nil
+	}
+
+	placement := &controlapi.PlacementConstraints{AntiAffinity: w.AntiAffinity}
+	for _, expr := range w.NodeSelectors {
+		// selectors were parsed when the manifest was validated
+		requirement, _ := controlapi.ParseNodeSelector(expr)
+		placement.NodeSelector = append(placement.NodeSelector, requirement)
+	}
+	return placement
+}
+
+// Builds the deploy request of the workload, signed by the given signer, with its environment
+// encrypted for the given target node
+func (w *manifestWorkload) deployRequest(manifestName string, signer controlapi.ClaimsSigner, xkey nkeys.KeyPair, targetNode string, targetPublicXkey string) (*controlapi.DeployRequest, error) {
+	location, err := url.Parse(w.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	return controlapi.NewDeployRequest(
+		controlapi.Location(location.String()),
+		controlapi.Argv(w.Argv),
+		controlapi.Environment(w.Env),
+		controlapi.Essential(w.Essential),
+		controlapi.MachineTemplate(w.Template),
+		controlapi.MachineSize(w.Vcpus, w.MemoryMb),
+		controlapi.IssuerSigner(signer),
+		controlapi.SenderXKey(xkey),
+		controlapi.TargetNode(targetNode),
+		controlapi.TargetPublicXKey(targetPublicXkey),
+		controlapi.WorkloadName(w.Name),
+		controlapi.WorkloadType(w.Type),
+		controlapi.TriggerSubjects(w.TriggerSubjects),
+		controlapi.TriggerDelivery(w.TriggerDelivery),
+		controlapi.CronTriggers(w.cronTriggers()),
+		controlapi.Checksum(w.Digest),
+		controlapi.WorkloadDescription(w.Description),
+		controlapi.WorkloadDigest(w.Digest),
+		controlapi.WorkloadLabels(w.labels(manifestName)),
+		controlapi.WorkloadHealthCheck(w.healthCheck()),
+		controlapi.WorkloadPlacement(w.placement()),
+	)
+}
+
+// Lists the fields of the workload which differ from the deploy request of a running workload,
+// as described by its node. Environment values are encrypted in the request, so only the names
+// of environment variables are compared
+func (w *manifestWorkload) changesFrom(desc *controlapi.DescribeResponse) []string {
+	req := desc.Request
+	changes := make([]string, 0)
+	compare := func(field string, current, desired interface{}) {
+		c, d := displayJSON(current), displayJSON(desired)
+		if c != d {
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", field, c, d))
+		}
+	}
+
+	var location string
+	if req.Location != nil {
+		location = req.Location.String()
+	}
+	compare("url", location, w.Url)
+	compare("type", deref(req.WorkloadType), w.Type)
+	compare("description", deref(req.Description), w.Description)
+	compare("argv", emptyIfNil(req.Argv), emptyIfNil(w.Argv))
+	compare("essential", req.Essential != nil && *req.Essential, w.Essential)
+	compare("template", deref(req.MachineTemplate), w.Template)
+	compare("vcpus", derefInt(req.VcpuCount), w.Vcpus)
+	compare("memory_mb", derefInt(req.MemSizeMib), w.MemoryMb)
+	compare("trigger_subjects", emptyIfNil(req.TriggerSubjects), emptyIfNil(w.TriggerSubjects))
+	compare("trigger_delivery", deref(req.TriggerDelivery), w.TriggerDelivery)
+	compare("digest", deref(req.Digest), w.Digest)
+
+	schedules := make([]string, 0, len(req.CronTriggers))
+	for _, trigger := range req.CronTriggers {
+		schedules = append(schedules, trigger.Schedule)
+	}
+	compare("cron", schedules, emptyIfNil(w.Cron))
+
+	labels := make(map[string]string)
+	for k, v := range req.Labels {
+		if k != manifestLabel && k != manifestHashLabel {
+			labels[k] = v
+		}
+	}
+	desiredLabels := w.Labels
+	if desiredLabels == nil {
+		desiredLabels = make(map[string]string)
+	}
+	compare("labels", labels, desiredLabels)
+
+	envKeys := make([]string, 0, len(w.Env))
+	for k := range w.Env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	currentEnvKeys := slices.Clone(desc.EnvironmentKeys)
+	sort.Strings(currentEnvKeys)
+	compare("env", emptyIfNil(currentEnvKeys), envKeys)
+
+	if len(changes) == 0 && req.Labels[manifestHashLabel] != w.hash() {
+		changes = append(changes, "environment values or other settings")
+	}
+	return changes
+}
+
+// Renders a value as JSON without escaping the characters of subject wildcards
+func displayJSON(v interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(v)
+	return strings.TrimSpace(buf.String())
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefInt(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func emptyIfNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -30,28 +30,121 @@ var (
 	_    = ncli.HelpFlag.Short('h')
 	_    = ncli.WithCheats().CheatCommand.Hidden()
 
-	nodes = ncli.Command("node", "Interact with execution engine nodes")
-	run   = ncli.Command("run", "Run a workload on a target node")
-	yeet  = ncli.Command("devrun", "Run a workload locating reasonable defaults (developer mode)").Alias("yeet")
-	stop  = ncli.Command("stop", "Stop a running workload")
-	logs  = ncli.Command("logs", "Live monitor workload log emissions")
-	evts  = ncli.Command("events", "Live monitor events from nex nodes")
+	nodes     = ncli.Command("node", "Interact with execution engine nodes")
+	run       = ncli.Command("run", "Run a workload on a target node")
+	yeet      = ncli.Command("devrun", "Run a workload locating reasonable defaults (developer mode)").Alias("yeet")
+	dev       = ncli.Command("dev", "Rebuild and redeploy a project's workload whenever its source changes, showing its logs and trigger executions")
+	stop      = ncli.Command("stop", "Stop a running workload")
+	stopAll   = ncli.Command("stopall", "Stop all running workloads in a namespace, optionally matching a label selector")
+	logs      = ncli.Command("logs", "Live monitor workload log emissions")
+	evts      = ncli.Command("events", "Live monitor events from nex nodes")
+	newProj   = ncli.Command("new", "Generate a starter project for a workload, ready to build, sign and run")
+	mintToken = ncli.Command("token", "Mint a single-use deploy token authorizing a run of a specific workload artifact")
+	deploySet = ncli.Command("deployset", "Manage the sets of workload replicas maintained across clusters by their leaders")
+	top       = ncli.Command("top", "Live view of the namespace's nodes, warm pools, workloads, trigger rates and recent errors").Alias("ui")
+	apply     = ncli.Command("apply", "Create, update, scale and delete workloads on a node or cluster to match a declarative manifest")
+	diff      = ncli.Command("diff", "Show the changes applying a manifest to a node or cluster would make")
+	promote   = ncli.Command("promote", "Promote the replacement awaiting promotion from a blue-green or canary workload update, or abort the update")
+	schemas   = ncli.Command("schemas", "List, show or write out the JSON Schemas of the control and agent API messages")
+	operator  = ncli.Command("operator", "Run the Kubernetes operator, running the cluster's NexWorkload resources on nex nodes")
 
-	nodesLs   = nodes.Command("ls", "List nodes")
-	nodesInfo = nodes.Command("info", "Get information for an engine node")
+	deploySetStatus = deploySet.Command("status", "Show the replicas of one or all of the namespace's deploy sets in a cluster")
+	deploySetScale  = deploySet.Command("scale", "Change the number of replicas of a deploy set")
+	deploySetDelete = deploySet.Command("delete", "Stop every replica of a deploy set and delete it")
 
-	// These two commands are GOOS/GOARCH dependent
+	nodesLs       = nodes.Command("ls", "List nodes")
+	nodesInfo     = nodes.Command("info", "Get information for an engine node")
+	nodesTimeline = nodes.Command("timeline", "Show the lifecycle events of a workload's machine, including why it stopped")
+	nodesDescribe = nodes.Command("describe", "Show everything a node knows about a workload, including its effective spec and recent events")
+	nodesSubjects = nodes.Command("subjects", "List the subjects a node uses for the namespace, for constructing tenant permissions")
+	nodesMemory   = nodes.Command("memory", "Show the observed memory use of the namespace's workloads on a node, and recommended memory sizes")
+	nodesReserve  = nodes.Command("reserve", "Reserve a node exclusively for the namespace for a limited time, e.g. for benchmarking")
+	nodesActivate = nodes.Command("activate", "Activate a node in standby, warming its machine pools, or return it to standby")
+	nodesPause    = nodes.Command("pause", "Pause the creation of machines on a node for a limited time, e.g. during a host backup")
+	nodesPrecheck = nodes.Command("precheck", "Run the preflight checks of one or all nodes remotely, without installing anything")
+	nodesReport   = nodes.Command("report", "Summarize the utilization of the fleet from the reports nodes store in an object store bucket")
+	nodesAudit    = nodes.Command("audit", "Show the namespace's recent control requests recorded in a node's audit log")
+	nodesRoles    = nodes.Command("roles", "List, assign or revoke the roles (viewer, deployer or operator) of requesters in the namespace")
+	nodesUpdate   = nodes.Command("update", "Update nodes, one at a time, to a signed nex binary stored in an object store bucket")
+	nodesCluster  = nodes.Command("cluster", "List the members of a cluster of nodes and its leader")
+
+	// These two commands are GOOS dependent
 	nodeUp        *fisk.CmdClause
 	nodePreflight *fisk.CmdClause
 
-	node_info_id_arg = nodesInfo.Arg("id", "Public key of the node you're interested in").Required().String()
+	node_info_id_arg       = nodesInfo.Arg("id", "Public key of the node you're interested in").Required().String()
+	node_info_selector_arg = nodesInfo.Flag("selector", "Only show workloads with the given label (key=value); may be repeated").StringMap()
+
+	node_timeline_id_arg       = nodesTimeline.Arg("id", "Public key of the node running (or that ran) the workload").Required().String()
+	node_timeline_workload_arg = nodesTimeline.Arg("workload_id", "ID of the workload's machine").Required().String()
+
+	node_describe_id_arg       = nodesDescribe.Arg("id", "Public key of the node running the workload").Required().String()
+	node_describe_workload_arg = nodesDescribe.Arg("workload_id", "ID of the workload's machine").Required().String()
+
+	node_subjects_id_arg = nodesSubjects.Arg("id", "Public key of the node you're interested in").Required().String()
+	node_precheck_id_arg = nodesPrecheck.Arg("id", "Public key of the node to check. Checks all nodes when omitted").String()
+
+	node_memory_id_arg       = nodesMemory.Arg("id", "Public key of the node you're interested in").Required().String()
+	node_memory_workload_arg = nodesMemory.Flag("workload", "Only show the workload with the given name").String()
+
+	node_reserve_id_arg       = nodesReserve.Arg("id", "Public key of the node to reserve").Required().String()
+	node_reserve_duration_arg = nodesReserve.Flag("duration", "How long to reserve the node for").Default("30m").Duration()
+	node_reserve_release_arg  = nodesReserve.Flag("release", "Release the namespace's reservation of the node").Bool()
+
+	node_activate_id_arg      = nodesActivate.Arg("id", "Public key of the node to activate").Required().String()
+	node_activate_standby_arg = nodesActivate.Flag("standby", "Return the node to standby instead, stopping its warm machines").Bool()
+
+	node_pause_id_arg       = nodesPause.Arg("id", "Public key of the node to pause").Required().String()
+	node_pause_duration_arg = nodesPause.Flag("duration", "How long to pause machine creation for").Default("15m").Duration()
+	node_pause_resume_arg   = nodesPause.Flag("resume", "Resume machine creation before the pause expires").Bool()
+
+	node_report_bucket_arg = nodesReport.Flag("bucket", "Object store bucket the nodes store their utilization reports in").Default("NEXREPORTS").String()
+	node_report_since_arg  = nodesReport.Flag("since", "Summarize the reports of periods ending within this long").Default("24h").Duration()
+
+	node_audit_id_arg    = nodesAudit.Arg("id", "Public key of the node you're interested in").Required().String()
+	node_audit_since_arg = nodesAudit.Flag("since", "Only show requests made within this long").Duration()
+	node_audit_op_arg    = nodesAudit.Flag("operation", "Only show requests of the given operation, e.g. DEPLOY").String()
+	node_audit_limit_arg = nodesAudit.Flag("limit", "Show at most this many of the most recent requests").Default("100").Int()
+
+	node_roles_id_arg     = nodesRoles.Arg("id", "Public key of the node to send the request to; nodes sharing a role bucket share assignments").Required().String()
+	node_roles_assign_arg = nodesRoles.Flag("assign", "Assign a role (identity=role) to a user or account, by public key or name; may be repeated").StringMap()
+	node_roles_revoke_arg = nodesRoles.Flag("revoke", "Revoke the role of a user or account, by public key or name; may be repeated").Strings()
+
+	node_cluster_name_arg = nodesCluster.Arg("name", "Name of the cluster").Required().String()
+
+	deployset_status_cluster_arg = deploySetStatus.Arg("cluster", "Name of the cluster").Required().String()
+	deployset_status_name_arg    = deploySetStatus.Arg("name", "Name of the deploy set. Shows all of the namespace's deploy sets when omitted").String()
+
+	deployset_scale_cluster_arg  = deploySetScale.Arg("cluster", "Name of the cluster").Required().String()
+	deployset_scale_name_arg     = deploySetScale.Arg("name", "Name of the deploy set").Required().String()
+	deployset_scale_replicas_arg = deploySetScale.Arg("replicas", "Number of replicas to maintain").Required().Int()
+
+	deployset_delete_cluster_arg = deploySetDelete.Arg("cluster", "Name of the cluster").Required().String()
+	deployset_delete_name_arg    = deploySetDelete.Arg("name", "Name of the deploy set").Required().String()
+
+	node_update_ids_arg         = nodesUpdate.Arg("id", "Public keys of the nodes to update, in order").Required().Strings()
+	node_update_bucket_arg      = nodesUpdate.Flag("bucket", "Object store bucket holding the nex binary").Required().String()
+	node_update_name_arg        = nodesUpdate.Flag("name", "Name of the nex binary in the bucket").Required().String()
+	node_update_signature_arg   = nodesUpdate.Flag("signature", "Path to a cosign signature (as produced by sign-blob) of the binary").Required().ExistingFile()
+	node_update_certificate_arg = nodesUpdate.Flag("certificate", "Path to the signing certificate of a keyless cosign signature").ExistingFile()
+	node_update_bundle_arg      = nodesUpdate.Flag("bundle", "Path to the cosign bundle (sign-blob --bundle) recording a keyless signature in the transparency log").ExistingFile()
+	node_update_digest_arg      = nodesUpdate.Flag("digest", "Hex-encoded SHA-256 digest of the binary; defaults to the digest recorded by the object store").String()
+	node_update_version_arg     = nodesUpdate.Flag("binary_version", "Version the binary must report").String()
+	node_update_jsdomain_arg    = nodesUpdate.Flag("jsdomain", "JetStream domain of the bucket").String()
 
 	Opts       = &models.Options{}
 	GuiOpts    = &models.UiOptions{}
-	RunOpts    = &models.RunOptions{Env: make(map[string]string)}
+	RunOpts    = &models.RunOptions{Env: make(map[string]string), Secrets: make(map[string]string), Labels: make(map[string]string), TriggerQueueGroups: make(map[string]string), NodeTags: make(map[string]string)}
 	DevRunOpts = &models.DevRunOptions{}
-	StopOpts   = &models.StopOptions{}
+	DevOpts    = &models.DevOptions{}
+	StopOpts   = &models.StopOptions{Selector: make(map[string]string)}
 	WatchOpts  = &models.WatchOptions{}
+	TopOpts    = &models.TopOptions{}
+	ApplyOpts  = &models.ApplyOptions{}
+	TokenOpts  = &models.DeployTokenOptions{}
+	SchemaOpts = &models.SchemaOptions{}
+	OperOpts   = &models.OperatorOptions{}
+	NewOpts    = &models.NewProjectOptions{}
 	NodeOpts   = &models.NodeOptions{}
 )
 
@@ -72,34 +165,224 @@ func init() {
 	ncli.Flag("context", "Configuration context").Envar("NATS_CONTEXT").PlaceHolder("NAME").StringVar(&Opts.ConfigurationContext)
 	ncli.Flag("no-context", "Disable NATS context discovery").UnNegatableBoolVar(&Opts.SkipContexts)
 
-	run.Arg("url", "URL pointing to the file to run").Required().URLVar(&RunOpts.WorkloadUrl)
-	run.Arg("id", "Public key of the target node to run the workload").Required().StringVar(&RunOpts.TargetNode)
+	run.Arg("url", "URL pointing to the file to run: a nats:// object, a local file or an oci:// artifact, which are uploaded first").Required().URLVar(&RunOpts.WorkloadUrl)
+	run.Arg("id", "Public key of the target node to run the workload, or the name of the cluster with --cluster").Required().StringVar(&RunOpts.TargetNode)
+	run.Flag("cluster", "Run the workload on the node of the named cluster chosen by its leader").UnNegatableBoolVar(&RunOpts.Cluster)
+	run.Flag("replicas", "Number of replicas of the workload the cluster's leader maintains across its nodes, as a deploy set named after the workload").IntVar(&RunOpts.Replicas)
+	run.Flag("node_tag", "Tag (key=value) the node chosen by the cluster's leader must have; may be repeated").StringMapVar(&RunOpts.NodeTags)
 	run.Flag("xkey", "Path to publisher's Xkey required to encrypt environment").Required().ExistingFileVar(&RunOpts.PublisherXkeyFile)
-	run.Flag("issuer", "Path to a seed key to sign the workload JWT as the issuer").Required().ExistingFileVar(&RunOpts.ClaimsIssuerFile)
+	run.Flag("issuer", "Path to a seed key to sign the workload JWT as the issuer. Required unless a deploy token is given").ExistingFileVar(&RunOpts.ClaimsIssuerFile)
+	run.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) to sign the workload JWT with instead of an issuer seed key, using VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE").StringVar(&RunOpts.VaultTransitKey)
+	run.Flag("token", "Path to a pre-authorized deploy token to run the workload with instead of an issuer").ExistingFileVar(&RunOpts.DeployTokenFile)
+	run.Flag("delegation", "Path to a delegation JWT chaining the issuer to a trusted root issuer; may be repeated, starting from the root's delegation").ExistingFilesVar(&RunOpts.DelegationFiles)
 	run.Arg("env", "Environment variables to pass to workload").StringMapVar(&RunOpts.Env)
-	run.Flag("name", "Name of the workload. Must be alphabetic (lowercase)").Required().StringVar(&RunOpts.Name)
-	run.Flag("type", "Type of workload").EnumVar(&RunOpts.WorkloadType, "elf", "v8", "wasm")
+	run.Flag("name", "Name of the workload. Must be alphabetic (lowercase). Required unless a deploy token is given, or derived from a local or OCI artifact").StringVar(&RunOpts.Name)
+	run.Flag("type", "Type of workload").EnumVar(&RunOpts.WorkloadType, "elf", "v8", "wasm", "python")
 	run.Flag("description", "Description of the workload").StringVar(&RunOpts.Description)
 	run.Flag("argv", "Arguments to pass to the workload, if applicable").StringVar(&RunOpts.Argv)
+	run.Flag("stdin", "Path to a file whose contents are written to a native workload's stdin when it starts").ExistingFileVar(&RunOpts.StdinFile)
+	run.Flag("exec_per_trigger", "Execute a native workload once per trigger, with the trigger payload on its stdin and its stdout as the reply, rather than as a service").BoolVar(&RunOpts.ExecPerTrigger)
 	run.Flag("essential", "When true, workload is redeployed if it exits with a non-zero status").BoolVar(&RunOpts.Essential)
+	run.Flag("template", "Name of the node machine template to run the workload in").StringVar(&RunOpts.MachineTemplate)
+	run.Flag("vcpus", "Number of vCPUs of the workload's machine, within the node's limits").IntVar(&RunOpts.VcpuCount)
+	run.Flag("memory", "Memory (MiB) of the workload's machine, within the node's limits").IntVar(&RunOpts.MemSizeMib)
 	run.Flag("trigger_subject", "Trigger subjects to register for subsequent workload execution, if supported by the workload type").StringsVar(&RunOpts.TriggerSubjects)
+	run.Flag("trigger_delivery", "Delivery semantics for trigger messages").EnumVar(&RunOpts.TriggerDelivery, "at_most_once", "at_least_once")
+	run.Flag("secret", "Environment variable (key=value) whose value is individually sealed to the target node's xkey; may be repeated").StringMapVar(&RunOpts.Secrets)
+	run.Flag("secret_ref", "Environment variable (key=reference) whose value the node resolves from a secret provider, e.g. DB_PASSWORD=vault://secret/db; may be repeated").StringMapVar(&RunOpts.SecretRefs)
+	run.Flag("trigger_queue", "Queue group (subject=queue) used to load-balance a trigger subject across nodes; may be repeated").StringMapVar(&RunOpts.TriggerQueueGroups)
+	run.Flag("cron", "Cron expression on which to trigger the function, e.g. '*/5 * * * *' or @hourly; may be repeated").StringsVar(&RunOpts.CronSchedules)
+	run.Flag("cron_timezone", "IANA time zone in which cron expressions are evaluated").Default("UTC").StringVar(&RunOpts.CronTimezone)
+	run.Flag("max_in_flight", "Maximum number of trigger messages the function executes concurrently (unlimited by default)").IntVar(&RunOpts.MaxInFlight)
+	run.Flag("trigger_queue_size", "Number of trigger messages which may wait for execution when max_in_flight is reached").Default("100").IntVar(&RunOpts.TriggerQueueSize)
+	run.Flag("trigger_overflow", "What to do with trigger messages arriving while the trigger queue is full").Default("reject").EnumVar(&RunOpts.TriggerOverflow, "reject", "drop_oldest", "block")
+	run.Flag("idle_timeout", "Undeploy the function after it receives no triggers for this long; its next trigger starts it again").DurationVar(&RunOpts.IdleTimeout)
+	run.Flag("ack_timeout", "How long the node waits for the agent to acknowledge the workload (1s by default)").DurationVar(&RunOpts.AckTimeout)
+	run.Flag("ready_timeout", "How long the node waits for a workload which is slow to initialize to be ready (5m by default)").DurationVar(&RunOpts.ReadyTimeout)
+	run.Flag("digest", "Expected SHA-256 digest (hex) of the workload artifact; the workload is rejected on mismatch").StringVar(&RunOpts.Digest)
+	run.Flag("stream_artifact", "Stream the workload artifact into its machine in chunks, publishing progress events, rather than transferring it whole").BoolVar(&RunOpts.StreamArtifact)
+	run.Flag("idempotency_key", "Key identifying the deploy request, so that nodes answer retries of it with the original response rather than deploying again").StringVar(&RunOpts.IdempotencyKey)
+	run.Flag("signature", "Path to a cosign signature (as produced by sign-blob) of the workload artifact").ExistingFileVar(&RunOpts.SignatureFile)
+	run.Flag("certificate", "Path to the signing certificate of a keyless cosign signature").ExistingFileVar(&RunOpts.CertificateFile)
+	run.Flag("attestation", "Path to a cosign attestation (DSSE envelope) of the workload artifact").ExistingFileVar(&RunOpts.AttestationFile)
+	run.Flag("bundle", "Path to the cosign bundle (sign-blob --bundle) recording a keyless signature in the transparency log").ExistingFileVar(&RunOpts.BundleFile)
+	run.Flag("label", "Label (key=value) used to group and select the workload; may be repeated").StringMapVar(&RunOpts.Labels)
+	run.Flag("health_exec", "Command the agent runs to check workload health; exit code 0 is healthy").StringVar(&RunOpts.HealthCheckExec)
+	run.Flag("health_http", "URL the agent requests to check workload health; any 2xx status is healthy").StringVar(&RunOpts.HealthCheckHTTP)
+	run.Flag("health_nats", "Subject the agent requests to check workload health; any reply is healthy").StringVar(&RunOpts.HealthCheckNATS)
+	run.Flag("health_interval", "Interval between workload health checks").Default("10s").DurationVar(&RunOpts.HealthCheckInterval)
+	run.Flag("ready_exec", "Command the agent runs until the workload is ready to receive triggers; exit code 0 is ready").StringVar(&RunOpts.ReadinessExec)
+	run.Flag("ready_http", "URL the agent requests until the workload is ready to receive triggers; any 2xx status is ready").StringVar(&RunOpts.ReadinessHTTP)
+	run.Flag("ready_nats", "Subject the agent requests until the workload is ready to receive triggers; any reply is ready").StringVar(&RunOpts.ReadinessNATS)
+	run.Flag("ready_interval", "Interval between attempts of the workload's readiness probe").Default("1s").DurationVar(&RunOpts.ReadinessInterval)
+	run.Flag("pre_start", "Command the agent runs before starting the workload; the workload is not started if it fails").StringVar(&RunOpts.PreStartHook)
+	run.Flag("post_stop", "Command the agent runs after the workload is stopped").StringVar(&RunOpts.PostStopHook)
+	run.Flag("hook_timeout", "Maximum time allowed for the pre-start and post-stop commands").Default("30s").DurationVar(&RunOpts.HookTimeout)
+	run.Flag("memory_soft_limit", "Percentage of the machine's memory in use at which the workload is notified to shed memory").IntVar(&RunOpts.MemorySoftLimit)
+	run.Flag("memory_signal", "Signal sent to an elf workload crossing its soft memory limit").EnumVar(&RunOpts.MemorySoftLimitSignal, "SIGUSR1", "SIGUSR2", "SIGHUP")
+	run.Flag("output_level", "Least severe level of the workload's output forwarded as logs; stdout is info and stderr error").EnumVar(&RunOpts.OutputLevel, "debug", "info", "warn", "error")
+	run.Flag("output_rate", "Lines of the workload's output forwarded as logs a second, on average; lines beyond the rate are dropped").IntVar(&RunOpts.OutputRate)
+	run.Flag("output_burst", "Lines of the workload's output forwarded as logs in a burst beyond the average rate").IntVar(&RunOpts.OutputBurst)
+	run.Flag("egress", "Destination ([tcp:|udp:]cidr|host[:ports]) the workload may connect to; all other egress is dropped. May be repeated").StringsVar(&RunOpts.Egress)
+	run.Flag("egress_dns", "Allow the workload to make DNS queries when its egress is restricted").BoolVar(&RunOpts.EgressDNS)
+	run.Flag("stable_ip", "Give a service workload's machine a stable IP address, kept when the workload is redeployed").BoolVar(&RunOpts.StableIP)
+	run.Flag("dns_name", "Register a DNS name ({name}.{namespace}.{domain}) resolving to a service workload's machine").StringVar(&RunOpts.DNSName)
+	run.Flag("volume", "Size (MiB) of a persistent volume attached to a service workload's machine, kept when the workload is redeployed").IntVar(&RunOpts.VolumeSizeMib)
+	run.Flag("webhook", "Give the function a webhook through which HTTP POSTs to the node trigger it, generating its token unless one is given").BoolVar(&RunOpts.Webhook)
+	run.Flag("webhook_token", "Token callers of the function's webhook must present; implies --webhook").StringVar(&RunOpts.WebhookToken)
+	run.Flag("webhook_subject", "Trigger subject webhook requests are delivered on; defaults to the first trigger subject").StringVar(&RunOpts.WebhookSubject)
+	run.Flag("micro", "Register the function as a NATS service (micro), with its trigger subjects as endpoints, so it's listed by 'nats micro ls'").BoolVar(&RunOpts.MicroService)
+	run.Flag("micro_name", "Name of the function's micro service; defaults to the workload's name. Implies --micro").StringVar(&RunOpts.MicroServiceName)
+	run.Flag("micro_version", "Semantic version of the function's micro service (0.0.0 by default). Implies --micro").StringVar(&RunOpts.MicroServiceVersion)
+	run.Flag("node_selector", "Requirement (key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key) on the tags of the node the workload is placed on; may be repeated").StringsVar(&RunOpts.NodeSelectors)
+	run.Flag("anti_affinity", "Name of a workload in the namespace this workload may not share a node with; may be repeated").StringsVar(&RunOpts.AntiAffinity)
+	run.Flag("sandbox_profile", "Sandbox profile determining which host services are exposed to a function workload, e.g. pure-compute, kv-only or full").StringVar(&RunOpts.SandboxProfile)
+	run.Flag("creds_pub", "Subject the workload's minted NATS credentials may publish to; may be repeated").StringsVar(&RunOpts.CredentialsPublish)
+	run.Flag("creds_sub", "Subject the workload's minted NATS credentials may subscribe to; may be repeated").StringsVar(&RunOpts.CredentialsSubscribe)
+	run.Flag("creds_ttl", "Lifetime of the workload's minted NATS credentials").DurationVar(&RunOpts.CredentialsTTL)
+	run.Flag("update", "ID of a running workload on the target node to replace with this one, instead of running it alongside").StringVar(&RunOpts.UpdateWorkloadId)
+	run.Flag("strategy", "Strategy with which --update replaces the workload").Default("recreate").EnumVar(&RunOpts.UpdateStrategy, "recreate", "rolling", "blue_green", "canary")
+	run.Flag("canary_weight", "Percentage of the workload's trigger messages a canary update routes to the replacement").Default("5").IntVar(&RunOpts.CanaryWeight)
+	run.Flag("health_timeout", "How long a rolling update waits for the replacement to pass its health check").Default("60s").DurationVar(&RunOpts.UpdateHealthTimeout)
+	run.Flag("wait", "Wait up to this long for the workload to become ready (running and, if it has a health check, healthy) before returning").DurationVar(&RunOpts.AwaitReady)
 
-	yeet.Arg("file", "File to run").Required().ExistingFileVar(&DevRunOpts.Filename)
+	yeet.Arg("file", "File to run. Required unless a manifest is given").ExistingFileVar(&DevRunOpts.Filename)
+	yeet.Flag("manifest", "Path to the manifest (nex.json) of a generated project from which to run the workload").ExistingFileVar(&DevRunOpts.ManifestFile)
+	yeet.Flag("issuer", "Path to a seed key to sign the workload JWT as the issuer, instead of the default developer issuer").ExistingFileVar(&RunOpts.ClaimsIssuerFile)
 	yeet.Arg("env", "Environment variables to pass to workload").StringMapVar(&RunOpts.Env)
 	yeet.Flag("argv", "Arguments to pass to the workload, if applicable").StringVar(&RunOpts.Argv)
+	yeet.Flag("stdin", "Path to a file whose contents are written to a native workload's stdin when it starts").ExistingFileVar(&RunOpts.StdinFile)
+	yeet.Flag("exec_per_trigger", "Execute a native workload once per trigger, with the trigger payload on its stdin and its stdout as the reply, rather than as a service").BoolVar(&RunOpts.ExecPerTrigger)
+	yeet.Flag("delegation", "Path to a delegation JWT chaining the issuer to a trusted root issuer; may be repeated, starting from the root's delegation").ExistingFilesVar(&RunOpts.DelegationFiles)
 	yeet.Flag("essential", "When true, workload is redeployed if it exits with a non-zero status").BoolVar(&RunOpts.Essential)
+	yeet.Flag("template", "Name of the node machine template to run the workload in").StringVar(&RunOpts.MachineTemplate)
+	yeet.Flag("vcpus", "Number of vCPUs of the workload's machine, within the node's limits").IntVar(&RunOpts.VcpuCount)
+	yeet.Flag("memory", "Memory (MiB) of the workload's machine, within the node's limits").IntVar(&RunOpts.MemSizeMib)
 	yeet.Flag("trigger_subject", "Trigger subjects to register for subsequent workload execution, if supported by the workload type").StringsVar(&RunOpts.TriggerSubjects)
+	yeet.Flag("trigger_delivery", "Delivery semantics for trigger messages").EnumVar(&RunOpts.TriggerDelivery, "at_most_once", "at_least_once")
+	yeet.Flag("secret", "Environment variable (key=value) whose value is individually sealed to the target node's xkey; may be repeated").StringMapVar(&RunOpts.Secrets)
+	yeet.Flag("secret_ref", "Environment variable (key=reference) whose value the node resolves from a secret provider, e.g. DB_PASSWORD=vault://secret/db; may be repeated").StringMapVar(&RunOpts.SecretRefs)
+	yeet.Flag("trigger_queue", "Queue group (subject=queue) used to load-balance a trigger subject across nodes; may be repeated").StringMapVar(&RunOpts.TriggerQueueGroups)
+	yeet.Flag("cron", "Cron expression on which to trigger the function, e.g. '*/5 * * * *' or @hourly; may be repeated").StringsVar(&RunOpts.CronSchedules)
+	yeet.Flag("cron_timezone", "IANA time zone in which cron expressions are evaluated").Default("UTC").StringVar(&RunOpts.CronTimezone)
+	yeet.Flag("max_in_flight", "Maximum number of trigger messages the function executes concurrently (unlimited by default)").IntVar(&RunOpts.MaxInFlight)
+	yeet.Flag("trigger_queue_size", "Number of trigger messages which may wait for execution when max_in_flight is reached").Default("100").IntVar(&RunOpts.TriggerQueueSize)
+	yeet.Flag("trigger_overflow", "What to do with trigger messages arriving while the trigger queue is full").Default("reject").EnumVar(&RunOpts.TriggerOverflow, "reject", "drop_oldest", "block")
+	yeet.Flag("stream_artifact", "Stream the workload artifact into its machine in chunks, publishing progress events, rather than transferring it whole").BoolVar(&RunOpts.StreamArtifact)
+	yeet.Flag("idle_timeout", "Undeploy the function after it receives no triggers for this long; its next trigger starts it again").DurationVar(&RunOpts.IdleTimeout)
+	yeet.Flag("ack_timeout", "How long the node waits for the agent to acknowledge the workload (1s by default)").DurationVar(&RunOpts.AckTimeout)
+	yeet.Flag("ready_timeout", "How long the node waits for a workload which is slow to initialize to be ready (5m by default)").DurationVar(&RunOpts.ReadyTimeout)
+	yeet.Flag("label", "Label (key=value) used to group and select the workload; may be repeated").StringMapVar(&RunOpts.Labels)
+	yeet.Flag("health_exec", "Command the agent runs to check workload health; exit code 0 is healthy").StringVar(&RunOpts.HealthCheckExec)
+	yeet.Flag("health_http", "URL the agent requests to check workload health; any 2xx status is healthy").StringVar(&RunOpts.HealthCheckHTTP)
+	yeet.Flag("health_nats", "Subject the agent requests to check workload health; any reply is healthy").StringVar(&RunOpts.HealthCheckNATS)
+	yeet.Flag("health_interval", "Interval between workload health checks").Default("10s").DurationVar(&RunOpts.HealthCheckInterval)
+	yeet.Flag("ready_exec", "Command the agent runs until the workload is ready to receive triggers; exit code 0 is ready").StringVar(&RunOpts.ReadinessExec)
+	yeet.Flag("ready_http", "URL the agent requests until the workload is ready to receive triggers; any 2xx status is ready").StringVar(&RunOpts.ReadinessHTTP)
+	yeet.Flag("ready_nats", "Subject the agent requests until the workload is ready to receive triggers; any reply is ready").StringVar(&RunOpts.ReadinessNATS)
+	yeet.Flag("ready_interval", "Interval between attempts of the workload's readiness probe").Default("1s").DurationVar(&RunOpts.ReadinessInterval)
+	yeet.Flag("pre_start", "Command the agent runs before starting the workload; the workload is not started if it fails").StringVar(&RunOpts.PreStartHook)
+	yeet.Flag("post_stop", "Command the agent runs after the workload is stopped").StringVar(&RunOpts.PostStopHook)
+	yeet.Flag("hook_timeout", "Maximum time allowed for the pre-start and post-stop commands").Default("30s").DurationVar(&RunOpts.HookTimeout)
+	yeet.Flag("memory_soft_limit", "Percentage of the machine's memory in use at which the workload is notified to shed memory").IntVar(&RunOpts.MemorySoftLimit)
+	yeet.Flag("memory_signal", "Signal sent to an elf workload crossing its soft memory limit").EnumVar(&RunOpts.MemorySoftLimitSignal, "SIGUSR1", "SIGUSR2", "SIGHUP")
+	yeet.Flag("output_level", "Least severe level of the workload's output forwarded as logs; stdout is info and stderr error").EnumVar(&RunOpts.OutputLevel, "debug", "info", "warn", "error")
+	yeet.Flag("output_rate", "Lines of the workload's output forwarded as logs a second, on average; lines beyond the rate are dropped").IntVar(&RunOpts.OutputRate)
+	yeet.Flag("output_burst", "Lines of the workload's output forwarded as logs in a burst beyond the average rate").IntVar(&RunOpts.OutputBurst)
+	yeet.Flag("egress", "Destination ([tcp:|udp:]cidr|host[:ports]) the workload may connect to; all other egress is dropped. May be repeated").StringsVar(&RunOpts.Egress)
+	yeet.Flag("egress_dns", "Allow the workload to make DNS queries when its egress is restricted").BoolVar(&RunOpts.EgressDNS)
+	yeet.Flag("stable_ip", "Give a service workload's machine a stable IP address, kept when the workload is redeployed").BoolVar(&RunOpts.StableIP)
+	yeet.Flag("dns_name", "Register a DNS name ({name}.{namespace}.{domain}) resolving to a service workload's machine").StringVar(&RunOpts.DNSName)
+	yeet.Flag("volume", "Size (MiB) of a persistent volume attached to a service workload's machine, kept when the workload is redeployed").IntVar(&RunOpts.VolumeSizeMib)
+	yeet.Flag("sandbox_profile", "Sandbox profile determining which host services are exposed to a function workload, e.g. pure-compute, kv-only or full").StringVar(&RunOpts.SandboxProfile)
+	yeet.Flag("creds_pub", "Subject the workload's minted NATS credentials may publish to; may be repeated").StringsVar(&RunOpts.CredentialsPublish)
+	yeet.Flag("creds_sub", "Subject the workload's minted NATS credentials may subscribe to; may be repeated").StringsVar(&RunOpts.CredentialsSubscribe)
+	yeet.Flag("creds_ttl", "Lifetime of the workload's minted NATS credentials").DurationVar(&RunOpts.CredentialsTTL)
 	yeet.Flag("stop", "Indicates whether to stop pre-existing workloads during launch. Disable with caution").Default("true").BoolVar(&DevRunOpts.AutoStop)
 
+	dev.Arg("dir", "Directory of the project, holding its manifest (nex.json)").Default(".").ExistingDirVar(&DevOpts.Dir)
+	dev.Flag("build", "Command building the workload artifact, instead of the manifest's build commands; may be repeated").StringsVar(&DevOpts.BuildCommands)
+	dev.Flag("node", "Public key of the node to deploy to. Defaults to the first node discovered").StringVar(&DevOpts.TargetNode)
+	dev.Flag("interval", "How often to check the project's files for changes").Default("500ms").DurationVar(&DevOpts.PollInterval)
+	dev.Flag("payload", "Payload sent to the workload's first trigger subject after each deploy, showing the reply").StringVar(&DevOpts.Payload)
+	dev.Flag("keep", "Leave the last deployed workload running on exit").UnNegatableBoolVar(&DevOpts.Keep)
+	dev.Flag("issuer", "Path to a seed key to sign the workload JWT as the issuer, instead of the manifest's issuer").ExistingFileVar(&RunOpts.ClaimsIssuerFile)
+	dev.Arg("env", "Environment variables to pass to workload").StringMapVar(&RunOpts.Env)
+
 	stop.Arg("id", "Public key of the target node on which to stop the workload").Required().StringVar(&StopOpts.TargetNode)
 	stop.Arg("workload_id", "Unique ID of the workload to be stopped").Required().StringVar(&StopOpts.WorkloadId)
 	stop.Flag("name", "Name of the workload to stop").Required().StringVar(&StopOpts.WorkloadName)
-	stop.Flag("issuer", "Path to the issuer seed key originally used to start the workload").Required().ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	stop.Flag("issuer", "Path to the issuer seed key originally used to start the workload").ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	stop.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) originally used to start the workload, instead of an issuer seed key").StringVar(&StopOpts.VaultTransitKey)
+
+	stopAll.Arg("id", "Public key of the target node on which to stop workloads. Stops workloads on all nodes when omitted").StringVar(&StopOpts.TargetNode)
+	stopAll.Flag("selector", "Label (key=value) workloads must have to be stopped; may be repeated").StringMapVar(&StopOpts.Selector)
+	stopAll.Flag("issuer", "Path to the issuer seed key originally used to start the workloads").ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	stopAll.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) originally used to start the workloads, instead of an issuer seed key").StringVar(&StopOpts.VaultTransitKey)
+
+	promote.Arg("id", "Public key of the node running the replacement").Required().StringVar(&StopOpts.TargetNode)
+	promote.Arg("workload_id", "Unique ID of the replacement awaiting promotion").Required().StringVar(&StopOpts.WorkloadId)
+	promote.Flag("name", "Name of the workload").Required().StringVar(&StopOpts.WorkloadName)
+	promote.Flag("abort", "Stop the replacement instead, keeping the workload it would have replaced").UnNegatableBoolVar(&StopOpts.Abort)
+	promote.Flag("canary_weight", "Route this percentage of trigger messages to the replacement of a canary update instead of promoting it").IntVar(&StopOpts.CanaryWeight)
+	promote.Flag("issuer", "Path to the issuer seed key used to start the replacement").ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	promote.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) used to start the replacement, instead of an issuer seed key").StringVar(&StopOpts.VaultTransitKey)
+
+	deploySetScale.Flag("issuer", "Path to the issuer seed key originally used to start the workload").ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	deploySetScale.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) originally used to start the workload, instead of an issuer seed key").StringVar(&StopOpts.VaultTransitKey)
+	deploySetDelete.Flag("issuer", "Path to the issuer seed key originally used to start the workload").ExistingFileVar(&StopOpts.ClaimsIssuerFile)
+	deploySetDelete.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) originally used to start the workload, instead of an issuer seed key").StringVar(&StopOpts.VaultTransitKey)
+
+	newProj.Arg("type", "Type of workload").Required().EnumVar(&NewOpts.WorkloadType, "elf", "v8", "wasm")
+	newProj.Arg("name", "Name of the workload. Must be alphabetic (lowercase)").Required().StringVar(&NewOpts.Name)
+	newProj.Flag("dir", "Directory to generate the project in. Defaults to the workload's name").StringVar(&NewOpts.Dir)
 
+	mintToken.Flag("issuer", "Path to the seed key of a trusted issuer with which to sign the token").Required().ExistingFileVar(&TokenOpts.ClaimsIssuerFile)
+	mintToken.Flag("name", "Name of the workload the token authorizes").Required().StringVar(&TokenOpts.Name)
+	mintToken.Flag("digest", "SHA-256 digest (hex) of the workload artifact the token authorizes").Required().StringVar(&TokenOpts.Digest)
+	mintToken.Flag("ttl", "Time until the token expires").Default("15m").DurationVar(&TokenOpts.TTL)
+
+	schemas.Arg("name", "Name of the message whose schema to show, e.g. control.deploy_request").StringVar(&SchemaOpts.Name)
+	schemas.Flag("output", "Directory to write every message's schema to, as {name}.json").StringVar(&SchemaOpts.Output)
+
+	operator.Flag("kube_api", "URL of the Kubernetes API. Defaults to that of the cluster the operator runs in, with its service account").StringVar(&OperOpts.KubeAPIURL)
+	operator.Flag("kube_token", "Bearer token with which to authenticate to the Kubernetes API").Envar("KUBE_TOKEN").StringVar(&OperOpts.KubeToken)
+	operator.Flag("kube_ca", "Path to the CA certificate of the Kubernetes API").ExistingFileVar(&OperOpts.KubeCAFile)
+	operator.Flag("watch_namespace", "Kubernetes namespace whose NexWorkloads are run. Watches every namespace when omitted").StringVar(&OperOpts.WatchNamespace)
+	operator.Flag("resync", "How often every NexWorkload is reconciled with the workloads running on nodes").Default("1m").DurationVar(&OperOpts.ResyncInterval)
+	operator.Flag("issuer", "Path to the issuer seed key with which to sign workloads").ExistingFileVar(&OperOpts.ClaimsIssuerFile)
+	operator.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) with which to sign workloads, instead of an issuer seed key").StringVar(&OperOpts.VaultTransitKey)
+	operator.Flag("xkey", "Path to publisher's Xkey with which to encrypt environments. Generated when omitted").ExistingFileVar(&OperOpts.PublisherXkeyFile)
+
+	logs.Arg("workload", "Name of the workload to filter on").StringVar(&WatchOpts.WorkloadName)
 	logs.Flag("node", "Public key of the nex node to filter on").Default("*").StringVar(&WatchOpts.NodeId)
 	logs.Flag("workload_name", "Name of the workload to filter on").Default("*").StringVar(&WatchOpts.WorkloadName)
 	logs.Flag("workload_id", "ID of the workload machine to filter on").Default("*").StringVar(&WatchOpts.WorkloadId)
-	logs.Flag("level", "Log level filter").Default("debug").StringVar(&WatchOpts.LogLevel)
+	logs.Flag("level", "Minimum level of the log entries shown").Default("debug").StringVar(&WatchOpts.LogLevel)
+	logs.Flag("source", "Source of the log entries shown: the workload's output, its agent or the node (host) running it").Default("*").EnumVar(&WatchOpts.Source, "*", "workload", "agent", "host")
+	logs.Flag("output", "Format in which log entries are shown").Default("pretty").EnumVar(&WatchOpts.Output, "pretty", "json")
+	logs.Flag("stream", "Query the logs nodes persisted to this stream for the named workload, instead of watching").StringVar(&WatchOpts.LogStream)
+	logs.Flag("follow", "Keep showing the log entries persisted to the --stream after those queried").Short('f').UnNegatableBoolVar(&WatchOpts.Follow)
+	logs.Flag("domain", "JetStream domain in which to query the log stream. Repeatable, merging the logs of every domain").StringsVar(&WatchOpts.LogDomains)
+	logs.Flag("since", "How long ago the queried logs begin").Default("1h").DurationVar(&WatchOpts.Since)
+	logs.Flag("until", "How long ago the queried logs end").Default("0s").DurationVar(&WatchOpts.Until)
+	logs.Flag("limit", "Maximum number of queried log entries, the earliest in the range (0 for no limit)").Default("0").IntVar(&WatchOpts.Limit)
+
+	top.Flag("interval", "How often to refresh node and workload information").Default("2s").DurationVar(&TopOpts.Interval)
+	top.Flag("issuer", "Path to the issuer seed key used to start workloads, to stop them from the view").ExistingFileVar(&TopOpts.ClaimsIssuerFile)
+	top.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) used to start workloads, instead of an issuer seed key").StringVar(&TopOpts.VaultTransitKey)
+
+	apply.Arg("id", "Public key of the target node, or the name of the cluster with --cluster").Required().StringVar(&ApplyOpts.TargetNode)
+	apply.Flag("file", "Path to the manifest").Short('f').Required().ExistingFileVar(&ApplyOpts.ManifestFile)
+	apply.Flag("cluster", "Apply the manifest's workloads to the named cluster as deploy sets").UnNegatableBoolVar(&ApplyOpts.Cluster)
+	apply.Flag("xkey", "Path to publisher's Xkey required to encrypt environment").Required().ExistingFileVar(&ApplyOpts.PublisherXkeyFile)
+	apply.Flag("issuer", "Path to the issuer seed key with which to sign workloads").ExistingFileVar(&ApplyOpts.ClaimsIssuerFile)
+	apply.Flag("vault_transit_key", "Vault transit key ({mount}/{key}) with which to sign workloads, instead of an issuer seed key").StringVar(&ApplyOpts.VaultTransitKey)
+	apply.Flag("update_health_timeout", "How long a rolling update waits for the replacement to pass its health check").Default("60s").DurationVar(&ApplyOpts.UpdateHealthTimeout)
+
+	diff.Arg("id", "Public key of the target node, or the name of the cluster with --cluster").Required().StringVar(&ApplyOpts.TargetNode)
+	diff.Flag("file", "Path to the manifest").Short('f').Required().ExistingFileVar(&ApplyOpts.ManifestFile)
+	diff.Flag("cluster", "Compare the manifest with the deploy sets of the named cluster").UnNegatableBoolVar(&ApplyOpts.Cluster)
 
 }
 
@@ -149,10 +432,99 @@ func main() {
 			fmt.Printf("Failed to list nodes: %s\n", err)
 		}
 	case nodesInfo.FullCommand():
-		err := NodeInfo(ctx, *node_info_id_arg)
+		err := NodeInfo(ctx, *node_info_id_arg, *node_info_selector_arg)
 		if err != nil {
 			fmt.Printf("Failed to get node info: %s\n", err)
 		}
+	case nodesTimeline.FullCommand():
+		err := MachineTimeline(ctx, *node_timeline_id_arg, *node_timeline_workload_arg)
+		if err != nil {
+			fmt.Printf("Failed to get machine timeline: %s\n", err)
+		}
+	case nodesDescribe.FullCommand():
+		err := DescribeWorkload(ctx, *node_describe_id_arg, *node_describe_workload_arg)
+		if err != nil {
+			fmt.Printf("Failed to describe workload: %s\n", err)
+		}
+	case nodesSubjects.FullCommand():
+		err := NamespaceSubjects(ctx, *node_subjects_id_arg)
+		if err != nil {
+			fmt.Printf("Failed to get namespace subjects: %s\n", err)
+		}
+	case nodesMemory.FullCommand():
+		err := WorkloadMemory(ctx, *node_memory_id_arg, *node_memory_workload_arg)
+		if err != nil {
+			fmt.Printf("Failed to get workload memory use: %s\n", err)
+		}
+	case nodesReserve.FullCommand():
+		err := ReserveNode(ctx, *node_reserve_id_arg, *node_reserve_duration_arg, *node_reserve_release_arg)
+		if err != nil {
+			fmt.Printf("Failed to reserve node: %s\n", err)
+		}
+	case nodesActivate.FullCommand():
+		err := ActivateNode(ctx, *node_activate_id_arg, *node_activate_standby_arg)
+		if err != nil {
+			fmt.Printf("Failed to activate node: %s\n", err)
+		}
+	case nodesPause.FullCommand():
+		err := PauseNode(ctx, *node_pause_id_arg, *node_pause_duration_arg, *node_pause_resume_arg)
+		if err != nil {
+			fmt.Printf("Failed to pause node: %s\n", err)
+		}
+	case nodesPrecheck.FullCommand():
+		err := NodePrecheck(ctx, *node_precheck_id_arg)
+		if err != nil {
+			fmt.Printf("Failed to run node preflight checks: %s\n", err)
+		}
+	case nodesReport.FullCommand():
+		err := FleetUtilization(ctx, *node_report_bucket_arg, *node_report_since_arg)
+		if err != nil {
+			fmt.Printf("Failed to summarize fleet utilization: %s\n", err)
+		}
+	case nodesAudit.FullCommand():
+		err := NodeAuditLog(ctx, *node_audit_id_arg, *node_audit_since_arg, *node_audit_op_arg, *node_audit_limit_arg)
+		if err != nil {
+			fmt.Printf("Failed to get audit log: %s\n", err)
+		}
+	case nodesRoles.FullCommand():
+		err := NodeRoles(ctx, *node_roles_id_arg, *node_roles_assign_arg, *node_roles_revoke_arg)
+		if err != nil {
+			fmt.Printf("Failed to manage roles: %s\n", err)
+		}
+	case nodesCluster.FullCommand():
+		err := ClusterInfo(ctx, *node_cluster_name_arg)
+		if err != nil {
+			fmt.Printf("Failed to get cluster info: %s\n", err)
+		}
+	case nodesUpdate.FullCommand():
+		err := UpdateNodes(ctx, *node_update_ids_arg, &nodeUpdateOptions{
+			bucket:          *node_update_bucket_arg,
+			name:            *node_update_name_arg,
+			signatureFile:   *node_update_signature_arg,
+			certificateFile: *node_update_certificate_arg,
+			bundleFile:      *node_update_bundle_arg,
+			digest:          *node_update_digest_arg,
+			version:         *node_update_version_arg,
+			jsDomain:        *node_update_jsdomain_arg,
+		})
+		if err != nil {
+			fmt.Printf("Failed to update nodes: %s\n", err)
+		}
+	case deploySetStatus.FullCommand():
+		err := DeploySetStatus(ctx, *deployset_status_cluster_arg, *deployset_status_name_arg)
+		if err != nil {
+			fmt.Printf("Failed to get deploy set status: %s\n", err)
+		}
+	case deploySetScale.FullCommand():
+		err := ScaleDeploySet(ctx, *deployset_scale_cluster_arg, *deployset_scale_name_arg, *deployset_scale_replicas_arg)
+		if err != nil {
+			fmt.Printf("Failed to scale deploy set: %s\n", err)
+		}
+	case deploySetDelete.FullCommand():
+		err := DeleteDeploySet(ctx, *deployset_delete_cluster_arg, *deployset_delete_name_arg)
+		if err != nil {
+			fmt.Printf("Failed to delete deploy set: %s\n", err)
+		}
 	case run.FullCommand():
 		err := RunWorkload(ctx, logger)
 		if err != nil {
@@ -163,21 +535,83 @@ func main() {
 		if err != nil {
 			logger.Error("failed to devrun workload", slog.Any("err", err))
 		}
+	case dev.FullCommand():
+		err := RunDevLoop(ctx, logger)
+		if err != nil {
+			fmt.Printf("Failed to run development loop: %s\n", err)
+		}
 	case stop.FullCommand():
 		err := StopWorkload(ctx, logger)
 		if err != nil {
 			logger.Error("failed to stop workload", slog.Any("err", err))
 		}
-	case logs.FullCommand():
-		err := WatchLogs(ctx, logger)
+	case stopAll.FullCommand():
+		err := StopWorkloads(ctx, logger)
 		if err != nil {
-			logger.Error("failed to start log watcher", slog.Any("err", err))
+			logger.Error("failed to stop workloads", slog.Any("err", err))
+		}
+	case apply.FullCommand():
+		err := ApplyManifest(ctx, logger)
+		if err != nil {
+			fmt.Printf("Failed to apply manifest: %s\n", err)
+		}
+	case diff.FullCommand():
+		err := DiffManifest(ctx)
+		if err != nil {
+			fmt.Printf("Failed to diff manifest: %s\n", err)
+		}
+	case promote.FullCommand():
+		err := PromoteWorkload(ctx, logger)
+		if err != nil {
+			logger.Error("failed to promote workload", slog.Any("err", err))
+		}
+	case newProj.FullCommand():
+		err := NewProject(ctx)
+		if err != nil {
+			logger.Error("failed to generate project", slog.Any("err", err))
+		}
+	case mintToken.FullCommand():
+		err := MintDeployToken(ctx)
+		if err != nil {
+			logger.Error("failed to mint deploy token", slog.Any("err", err))
+		}
+	case schemas.FullCommand():
+		err := ShowSchemas(ctx)
+		if err != nil {
+			logger.Error("failed to show schemas", slog.Any("err", err))
+		}
+	case operator.FullCommand():
+		err := RunOperator(ctx, logger)
+		if err != nil {
+			logger.Error("failed to run operator", slog.Any("err", err))
+		}
+	case logs.FullCommand():
+		if WatchOpts.LogStream != "" && WatchOpts.Follow {
+			err := FollowLogs(ctx, logger)
+			if err != nil {
+				fmt.Printf("Failed to follow logs: %s\n", err)
+			}
+		} else if WatchOpts.LogStream != "" {
+			err := QueryLogs(ctx, logger)
+			if err != nil {
+				fmt.Printf("Failed to query logs: %s\n", err)
+			}
+		} else {
+			err := WatchLogs(ctx, logger)
+			if err != nil {
+				logger.Error("failed to start log watcher", slog.Any("err", err))
+			}
 		}
 	case evts.FullCommand():
 		err := WatchEvents(ctx, logger)
 		if err != nil {
 			logger.Error("failed to start event watcher", slog.Any("err", err))
 		}
+	case top.FullCommand():
+		err := Top(ctx, logger)
+		if err != nil {
+			fmt.Printf("Failed to run live view: %s\n", err)
+		}
 	case nodeUp.FullCommand():
 		err := RunNodeUp(ctx, logger)
 		if err != nil {
@@ -1,10 +1,9 @@
-//go:build (linux && amd64) || (linux && arm64)
-
 package main
 
 import (
 	"context"
 	"log/slog"
+	"runtime"
 
 	nexnode "github.com/synadia-io/nex/internal/node"
 )
@@ -12,6 +11,7 @@ import (
 func setConditionalCommands() {
 	nodeUp = nodes.Command("up", "Starts a Nex node")
 	nodeUp.Flag("config", "configuration file for the node").Default("./config.json").StringVar(&NodeOpts.ConfigFilepath)
+	nodeUp.Flag("dev", "run agents as local processes without firecracker or CNI (development only; no isolation)").Default("false").UnNegatableBoolVar(&NodeOpts.DevMode)
 	nodeUp.Flag("metrics", "enable open telemetry metrics endpoint").Default("false").UnNegatableBoolVar(&NodeOpts.OtelMetrics)
 	nodeUp.Flag("metrics_port", "enable open telemetry metrics endpoint").Default("8085").IntVar(&NodeOpts.OtelMetricsPort)
 	nodeUp.Flag("otel_metrics_exporter", "OTel exporter for metrics").Default("stdout").EnumVar(&NodeOpts.OtelMetricsExporter, "stdout", "prometheus")
@@ -19,6 +19,11 @@ func setConditionalCommands() {
 	nodePreflight = nodes.Command("preflight", "Checks system for node requirements and installs missing")
 	nodePreflight.Flag("force", "installs missing dependencies without prompt").Default("false").BoolVar(&NodeOpts.ForceDepInstall)
 	nodePreflight.Flag("config", "configuration file for the node").Default("./config.json").StringVar(&NodeOpts.ConfigFilepath)
+
+	// firecracker and CNI prerequisites only apply to linux; elsewhere nodes can only run in dev mode
+	if runtime.GOOS != "linux" {
+		nodePreflight.Hidden()
+	}
 }
 
 func RunNodeUp(ctx context.Context, logger *slog.Logger) error {
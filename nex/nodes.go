@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/natscli/columns"
 	controlapi "github.com/synadia-io/nex/internal/control-api"
 	"github.com/synadia-io/nex/internal/models"
@@ -33,14 +41,14 @@ func ListNodes(ctx context.Context) error {
 }
 
 // Uses a control API client to retrieve info on a single node
-func NodeInfo(ctx context.Context, nodeid string) error {
+func NodeInfo(ctx context.Context, nodeid string, selector map[string]string) error {
 	nc, err := models.GenerateConnectionFromOpts(Opts)
 	if err != nil {
 		return err
 	}
 	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
 	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
-	nodeInfo, err := nodeClient.NodeInfo(nodeid)
+	nodeInfo, err := nodeClient.NodeInfoMatching(nodeid, selector)
 	if err != nil {
 		return err
 	}
@@ -49,6 +57,494 @@ func NodeInfo(ctx context.Context, nodeid string) error {
 	return nil
 }
 
+// Uses a control API client to retrieve the timeline of a single workload's machine
+func MachineTimeline(ctx context.Context, nodeid string, workloadid string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+	timeline, err := nodeClient.MachineTimeline(nodeid, workloadid)
+	if err != nil {
+		return err
+	}
+	renderMachineTimeline(timeline)
+
+	return nil
+}
+
+// Uses a control API client to describe a single workload
+func DescribeWorkload(ctx context.Context, nodeid string, workloadid string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+	description, err := nodeClient.DescribeWorkload(nodeid, workloadid)
+	if err != nil {
+		return err
+	}
+
+	return renderWorkloadDescription(description)
+}
+
+// Uses a control API client to retrieve the subjects a node uses for the namespace
+func NamespaceSubjects(ctx context.Context, nodeid string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+	subjects, err := nodeClient.NamespaceSubjects(nodeid)
+	if err != nil {
+		return err
+	}
+	renderNamespaceSubjects(subjects)
+
+	return nil
+}
+
+// Uses a control API client to retrieve the observed memory use of the namespace's workloads
+func WorkloadMemory(ctx context.Context, nodeid string, workload string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+	memory, err := nodeClient.WorkloadMemory(nodeid, workload)
+	if err != nil {
+		return err
+	}
+	renderWorkloadMemory(memory)
+
+	return nil
+}
+
+// Uses a control API client to retrieve the namespace's recent entries in a node's audit log
+func NodeAuditLog(ctx context.Context, nodeid string, since time.Duration, operation string, limit int) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	request := &controlapi.AuditRequest{
+		Operation: strings.ToUpper(operation),
+		Limit:     limit,
+	}
+	if since > 0 {
+		start := time.Now().UTC().Add(-since)
+		request.Since = &start
+	}
+
+	res, err := nodeClient.AuditLog(nodeid, request)
+	if err != nil {
+		return err
+	}
+	renderAuditLog(res)
+
+	return nil
+}
+
+// Uses a control API client to assign and revoke the given roles in the namespace, then lists the
+// namespace's roles
+func NodeRoles(ctx context.Context, nodeid string, assign map[string]string, revoke []string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	for identity, role := range assign {
+		_, err = nodeClient.AssignRole(nodeid, identity, role)
+		if err != nil {
+			return err
+		}
+	}
+	for _, identity := range revoke {
+		_, err = nodeClient.RevokeRole(nodeid, identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := nodeClient.ListRoles(nodeid)
+	if err != nil {
+		return err
+	}
+	renderRoles(res)
+
+	return nil
+}
+
+// Uses a control API client to list the members of a cluster, as seen by its leader
+func ClusterInfo(ctx context.Context, cluster string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	res, err := nodeClient.ClusterInfo(cluster)
+	if err != nil {
+		return err
+	}
+	renderClusterInfo(res)
+
+	return nil
+}
+
+// Uses a control API client to describe one or all of the namespace's deploy sets in a cluster
+func DeploySetStatus(ctx context.Context, cluster string, name string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	res, err := nodeClient.DeploySetStatus(cluster, name)
+	if err != nil {
+		return err
+	}
+	renderDeploySets(res)
+
+	return nil
+}
+
+// Asks the leader of a cluster to change the number of replicas of a deploy set, authorized by
+// the issuer of its workload
+func ScaleDeploySet(ctx context.Context, cluster string, name string, replicas int) error {
+	return updateDeploySet(cluster, name, func(nodeClient *controlapi.Client, issuerJwt string) (*controlapi.DeploySetResponse, error) {
+		return nodeClient.ScaleDeploySet(cluster, name, replicas, issuerJwt)
+	})
+}
+
+// Asks the leader of a cluster to stop every replica of a deploy set and delete it, authorized
+// by the issuer of its workload
+func DeleteDeploySet(ctx context.Context, cluster string, name string) error {
+	return updateDeploySet(cluster, name, func(nodeClient *controlapi.Client, issuerJwt string) (*controlapi.DeploySetResponse, error) {
+		return nodeClient.DeleteDeploySet(cluster, name, issuerJwt)
+	})
+}
+
+func updateDeploySet(cluster string, name string, update func(*controlapi.Client, string) (*controlapi.DeploySetResponse, error)) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	// the issuer JWT must name the set's workload, which needn't be the set's name
+	res, err := nodeClient.DeploySetStatus(cluster, name)
+	if err != nil {
+		return err
+	}
+	if len(res.Sets) == 0 {
+		return fmt.Errorf("no such deploy set: %s", name)
+	}
+
+	signer, err := claimsSignerFromOpts(StopOpts.ClaimsIssuerFile, StopOpts.VaultTransitKey)
+	if err != nil {
+		return err
+	}
+	issuerJwt, err := controlapi.DeploySetIssuerJwt(res.Sets[0].Workload, signer)
+	if err != nil {
+		return err
+	}
+
+	res, err = update(nodeClient, issuerJwt)
+	if err != nil {
+		return err
+	}
+	renderDeploySets(res)
+
+	return nil
+}
+
+// Nodes fetch, verify and install a binary before responding to an update request
+const nodeUpdateTimeout = 2 * time.Minute
+
+type nodeUpdateOptions struct {
+	bucket          string
+	name            string
+	signatureFile   string
+	certificateFile string
+	bundleFile      string
+	digest          string
+	version         string
+	jsDomain        string
+}
+
+// Uses a control API client to update each of the given nodes in turn, stopping at the first
+// which fails to update
+func UpdateNodes(ctx context.Context, nodeids []string, opts *nodeUpdateOptions) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClient(nc, max(Opts.Timeout, nodeUpdateTimeout), log)
+
+	request := &controlapi.NodeUpdateRequest{
+		Bucket:  opts.bucket,
+		Name:    opts.name,
+		Digest:  opts.digest,
+		Version: opts.version,
+	}
+	if opts.jsDomain != "" {
+		request.JsDomain = &opts.jsDomain
+	}
+
+	sig, err := os.ReadFile(opts.signatureFile)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %s", err)
+	}
+	request.Signature.Signature = strings.TrimSpace(string(sig))
+
+	if opts.certificateFile != "" {
+		cert, err := os.ReadFile(opts.certificateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read certificate: %s", err)
+		}
+		certificate := strings.TrimSpace(string(cert))
+		request.Signature.Certificate = &certificate
+	}
+
+	if opts.bundleFile != "" {
+		raw, err := os.ReadFile(opts.bundleFile)
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %s", err)
+		}
+		bundle := string(raw)
+		request.Signature.Bundle = &bundle
+	}
+
+	if request.Digest == "" {
+		request.Digest, err = objectDigest(nc, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, nodeid := range nodeids {
+		res, err := nodeClient.UpdateNode(nodeid, request)
+		if err != nil {
+			return fmt.Errorf("node %s: %s", nodeid, err)
+		}
+		fmt.Printf("Node %s installed %s and is restarting (%s)\n", res.NodeId, res.Version, res.Restart)
+	}
+
+	return nil
+}
+
+// Returns the hex-encoded SHA-256 digest the object store recorded for the binary
+func objectDigest(nc *nats.Conn, opts *nodeUpdateOptions) (string, error) {
+	var jsOpts []nats.JSOpt
+	if opts.jsDomain != "" {
+		jsOpts = append(jsOpts, nats.Domain(opts.jsDomain))
+	}
+
+	js, err := nc.JetStream(jsOpts...)
+	if err != nil {
+		return "", err
+	}
+
+	store, err := js.ObjectStore(opts.bucket)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := store.GetInfo(opts.name)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(info.Digest, "SHA-256="))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode object digest: %s", err)
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// Uses a control API client to reserve a node exclusively for the namespace, or release it
+func ReserveNode(ctx context.Context, nodeid string, duration time.Duration, release bool) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	var res *controlapi.ReserveResponse
+	if release {
+		res, err = nodeClient.ReleaseNode(nodeid)
+	} else {
+		res, err = nodeClient.ReserveNode(nodeid, duration)
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.Reserved {
+		fmt.Printf("Node %s is reserved for namespace %s until %s\n", res.NodeId, res.Namespace, res.ExpiresAt.Local().Format(time.RFC1123))
+	} else {
+		fmt.Printf("Released the reservation of node %s by namespace %s\n", res.NodeId, res.Namespace)
+	}
+
+	return nil
+}
+
+// Uses a control API client to activate a node in standby, or return it to standby
+func ActivateNode(ctx context.Context, nodeid string, standby bool) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	var res *controlapi.StandbyResponse
+	if standby {
+		res, err = nodeClient.StandbyNode(nodeid)
+	} else {
+		res, err = nodeClient.ActivateNode(nodeid)
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.Standby {
+		fmt.Printf("Node %s is in standby; stopped %d warm machines\n", res.NodeId, res.StoppedMachines)
+	} else {
+		fmt.Printf("Node %s is active, warming its pools (%d of %d machines warm)\n", res.NodeId, res.WarmMachines, res.MachinePoolSize)
+	}
+
+	return nil
+}
+
+// Uses a control API client to pause the creation of machines on a node, or resume it
+func PauseNode(ctx context.Context, nodeid string, duration time.Duration, resume bool) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, log)
+
+	var res *controlapi.PauseResponse
+	if resume {
+		res, err = nodeClient.ResumeNode(nodeid)
+	} else {
+		res, err = nodeClient.PauseNode(nodeid, duration)
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.Paused {
+		fmt.Printf("Node %s paused machine creation until %s (%d warm machines)\n", res.NodeId, res.ExpiresAt.Local().Format(time.RFC1123), res.WarmMachines)
+	} else {
+		fmt.Printf("Node %s resumed machine creation\n", res.NodeId)
+	}
+
+	return nil
+}
+
+// Uses a control API client to run the preflight checks of one node, or every node
+func NodePrecheck(ctx context.Context, nodeid string) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	nodeClient := controlapi.NewApiClient(nc, Opts.Timeout, log)
+
+	var reports []controlapi.PreflightResponse
+	if nodeid != "" {
+		report, err := nodeClient.NodePreflight(nodeid)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, *report)
+	} else {
+		reports, err = nodeClient.FleetPreflight()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(reports) == 0 {
+		fmt.Println("No nodes discovered")
+		return nil
+	}
+
+	for _, report := range reports {
+		renderPreflightReport(report)
+	}
+	return nil
+}
+
+// Reads the utilization reports nodes stored in the given bucket for periods ending within the
+// given duration, and summarizes the utilization of the fleet and of each namespace across them
+func FleetUtilization(ctx context.Context, bucket string, since time.Duration) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		return fmt.Errorf("failed to open report bucket %s: %s", bucket, err)
+	}
+
+	objects, err := store.List()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoObjectsFound) {
+			fmt.Println("No utilization reports found")
+			return nil
+		}
+		return err
+	}
+
+	cutoff := time.Now().Add(-since)
+	reports := make([]controlapi.UtilizationReport, 0)
+	for _, obj := range objects {
+		raw, err := store.GetBytes(obj.Name)
+		if err != nil {
+			return fmt.Errorf("failed to read report %s: %s", obj.Name, err)
+		}
+
+		var report controlapi.UtilizationReport
+		err = json.Unmarshal(raw, &report)
+		if err != nil || report.PeriodEnd.Before(cutoff) {
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	if len(reports) == 0 {
+		fmt.Printf("No utilization reports for periods ending in the last %s\n", since)
+		return nil
+	}
+
+	renderFleetUtilization(reports, since)
+	return nil
+}
+
 func render(cols *columns.Writer) {
 	_ = cols.Frender(os.Stdout)
 }
@@ -60,6 +556,13 @@ func renderNodeInfo(info *controlapi.InfoResponse, id string) {
 	cols.AddRowf("Xkey", info.PublicXKey)
 	cols.AddRow("Version", info.Version)
 	cols.AddRow("Uptime", info.Uptime)
+	if info.Capabilities != nil {
+		versions := make([]string, 0, len(info.Capabilities.APIVersions))
+		for _, v := range info.Capabilities.APIVersions {
+			versions = append(versions, strconv.Itoa(v))
+		}
+		cols.AddRow("API Versions", strings.Join(versions, ", "))
+	}
 
 	taglist := make([]string, 0)
 	for k, v := range info.Tags {
@@ -79,21 +582,105 @@ func renderNodeInfo(info *controlapi.InfoResponse, id string) {
 		cols.Indent(0)
 	}
 
+	if info.Quota != nil {
+		cols.AddSectionTitle("Namespace Quota")
+		cols.Indent(2)
+
+		cols.Println()
+		cols.AddRow("Workloads", quotaUsage(int64(info.Quota.Usage.Workloads), int64(info.Quota.Limits.MaxWorkloads)))
+		cols.AddRow("vCPU", quotaUsage(info.Quota.Usage.VCPU, info.Quota.Limits.MaxVCPU))
+		cols.AddRow("Memory (MiB)", quotaUsage(info.Quota.Usage.MemoryMib, info.Quota.Limits.MaxMemoryMib))
+		cols.AddRow("Deployed Bytes", quotaUsage(info.Quota.Usage.DeployedBytes, info.Quota.Limits.MaxDeployedBytes))
+
+		cols.Indent(0)
+	}
+
+	if info.Standby {
+		cols.AddRow("Standby", true)
+	}
+
+	if info.PausedUntil != nil {
+		cols.AddRow("Machine Creation Paused Until", info.PausedUntil.Local().Format(time.RFC1123))
+	}
+
+	if info.Reservation != nil {
+		cols.AddSectionTitle("Reservation")
+		cols.Indent(2)
+
+		cols.Println()
+		cols.AddRow("Namespace", info.Reservation.Namespace)
+		cols.AddRow("Expires", info.Reservation.ExpiresAt.Local().Format(time.RFC1123))
+
+		cols.Indent(0)
+	}
+
+	if info.MachineBoot != nil {
+		cols.AddSectionTitle(fmt.Sprintf("Machine Boot (last %d machines)", info.MachineBoot.Machines))
+		cols.Indent(2)
+
+		cols.Println()
+		cols.AddRow("Average", bootTimings(&info.MachineBoot.Average))
+		cols.AddRow("Slowest", bootTimings(&info.MachineBoot.Slowest))
+
+		cols.Indent(0)
+	}
+
 	if len(info.Machines) > 0 {
 		cols.AddSectionTitle("Workloads")
 		cols.Indent(2)
 		for _, m := range info.Machines {
 			cols.Println()
 			cols.AddRow("Id", m.Id)
+			if m.State != "" {
+				cols.AddRow("State", m.State)
+			}
 			cols.AddRow("Healthy", m.Healthy)
+			cols.AddRow("Ready", m.Ready)
+			if m.LastHealthCheck != nil {
+				cols.AddRow("Last Health Check", m.LastHealthCheck.Format(time.RFC3339))
+			}
+			if m.HealthMessage != "" {
+				cols.AddRow("Health", m.HealthMessage)
+			}
 			cols.AddRow("Runtime", m.Uptime)
 			cols.AddRow("Name", m.Workload.Name)
 			cols.AddRow("Description", m.Workload.Description)
+			if len(m.Labels) > 0 {
+				labels := make([]string, 0, len(m.Labels))
+				for k, v := range m.Labels {
+					labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+				}
+				sort.Strings(labels)
+				cols.AddRow("Labels", strings.Join(labels, ", "))
+			}
+			for _, ct := range m.CronTriggers {
+				next := "never"
+				if ct.NextRun != nil {
+					next = ct.NextRun.Format(time.RFC3339)
+				}
+				cols.AddRow("Cron", fmt.Sprintf("%s (%s), next run %s", ct.Schedule, ct.Timezone, next))
+				if ct.LastError != "" {
+					cols.AddRow("Last Cron Error", ct.LastError)
+				}
+			}
+			if t := m.Triggers; t != nil {
+				triggers := fmt.Sprintf("%d active, %d queued in agent, %d queued in node, %d executed", t.Active, t.AgentQueued, t.NodeQueued, t.Executed)
+				if t.Saturated {
+					triggers += " (saturated)"
+				}
+				cols.AddRow("Triggers", triggers)
+			}
 		}
 		cols.Indent(0)
 	}
 }
 
+// Describes a machine's boot timings as its total time followed by the time of each phase
+func bootTimings(t *controlapi.MachineBootTimings) string {
+	return fmt.Sprintf("%d ms (rootfs %d, cni %d, firecracker %d, kernel %d, agent %d, handshake %d)",
+		t.TotalMillis, t.RootfsCopyMillis, t.CNISetupMillis, t.FirecrackerStartMillis, t.KernelBootMillis, t.AgentStartMillis, t.HandshakeMillis)
+}
+
 func renderNodeList(nodes []controlapi.PingResponse) {
 	if len(nodes) == 0 {
 		fmt.Println("No nodes discovered")
@@ -101,10 +688,401 @@ func renderNodeList(nodes []controlapi.PingResponse) {
 	}
 
 	table := newTableWriter("NATS Execution Nodes")
-	table.AddHeaders("ID", "Version", "Uptime", "Workloads")
+	table.AddHeaders("ID", "Version", "Uptime", "Workloads", "Warm", "Free vCPU", "Free Memory", "Pending")
 
 	for _, node := range nodes {
-		table.AddRow(node.NodeId, node.Version, node.Uptime, node.RunningMachines)
+		if node.Capacity == nil {
+			table.AddRow(node.NodeId, node.Version, node.Uptime, node.RunningMachines, "-", "-", "-", "-")
+			continue
+		}
+		warm := fmt.Sprint(node.Capacity.WarmMachines)
+		if node.Capacity.Standby {
+			warm = "standby"
+		}
+		table.AddRow(node.NodeId, node.Version, node.Uptime, node.RunningMachines,
+			warm,
+			node.Capacity.AllocatableVCPU,
+			fmt.Sprintf("%d MiB", node.Capacity.AllocatableMemoryMib),
+			node.Capacity.PendingDeploys,
+		)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderClusterInfo(cluster *controlapi.ClusterResponse) {
+	table := newTableWriter(fmt.Sprintf("Cluster %s", cluster.Cluster))
+	table.AddHeaders("ID", "Leader", "Workloads", "Warm", "Free vCPU", "Free Memory", "Pending", "Last Seen")
+
+	for _, member := range cluster.Members {
+		leader := ""
+		if member.NodeId == cluster.Leader {
+			leader = "*"
+		}
+		table.AddRow(member.NodeId, leader,
+			member.Capacity.RunningWorkloads,
+			member.Capacity.WarmMachines,
+			member.Capacity.AllocatableVCPU,
+			fmt.Sprintf("%d MiB", member.Capacity.AllocatableMemoryMib),
+			member.Capacity.PendingDeploys,
+			member.LastSeen.Format(time.RFC3339),
+		)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderDeploySets(res *controlapi.DeploySetResponse) {
+	table := newTableWriter(fmt.Sprintf("Deploy sets in cluster %s", res.Cluster))
+	table.AddHeaders("Name", "Workload", "Replicas", "Nodes", "Last Error")
+
+	for _, set := range res.Sets {
+		nodes := make([]string, 0, len(set.Replicas))
+		for _, replica := range set.Replicas {
+			nodes = append(nodes, replica.NodeId)
+		}
+		table.AddRow(set.Name, set.Workload,
+			fmt.Sprintf("%d / %d", len(set.Replicas), set.Desired),
+			strings.Join(nodes, "\n"),
+			set.LastError,
+		)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func quotaUsage(used, limit int64) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%d (unlimited)", used)
+	}
+	return fmt.Sprintf("%d / %d", used, limit)
+}
+
+func renderNamespaceSubjects(subjects *controlapi.SubjectsResponse) {
+	table := newTableWriter(fmt.Sprintf("Subjects of namespace %s on node %s", subjects.Namespace, subjects.NodeId))
+	table.AddHeaders("Category", "Subject", "Permission", "Workload", "Description")
+
+	categories := []struct {
+		name   string
+		grants []controlapi.SubjectGrant
+	}{
+		{"Control", subjects.Control},
+		{"Events", subjects.Events},
+		{"Logs", subjects.Logs},
+		{"Triggers", subjects.Triggers},
+		{"Host services", subjects.HostServices},
+	}
+	for _, category := range categories {
+		for _, grant := range category.grants {
+			table.AddRow(category.name, grant.Subject, grant.Permission, grant.Workload, grant.Description)
+		}
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderWorkloadMemory(memory *controlapi.MemoryResponse) {
+	if len(memory.Workloads) == 0 {
+		fmt.Println("No memory use observed")
+		return
+	}
+
+	table := newTableWriter(fmt.Sprintf("Memory use of namespace %s on node %s", memory.Namespace, memory.NodeId))
+	table.AddHeaders("Workload", "Memory", "Peak", "OOM Kills", "Samples", "Recommendation", "Reason")
+
+	for _, w := range memory.Workloads {
+		recommendation := w.Recommendation.Action
+		if w.Recommendation.MemSizeMib != w.MemSizeMib {
+			recommendation = fmt.Sprintf("%s to %d MiB", w.Recommendation.Action, w.Recommendation.MemSizeMib)
+		}
+		table.AddRow(w.WorkloadName,
+			fmt.Sprintf("%d MiB", w.MemSizeMib),
+			fmt.Sprintf("%d MiB", w.PeakMib),
+			w.OOMKills,
+			w.Samples,
+			recommendation,
+			w.Recommendation.Reason,
+		)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderAuditLog(res *controlapi.AuditResponse) {
+	if len(res.Entries) == 0 {
+		fmt.Println("No audit log entries")
+		return
+	}
+
+	table := newTableWriter(fmt.Sprintf("Audit log of namespace %s on node %s", res.Namespace, res.NodeId))
+	table.AddHeaders("Time", "Operation", "Requester", "Issuer", "Workload", "Result")
+
+	for _, e := range res.Entries {
+		workload := e.WorkloadName
+		if e.WorkloadId != "" {
+			workload = strings.TrimSpace(fmt.Sprintf("%s %s", e.WorkloadName, e.WorkloadId))
+		}
+		result := "ok"
+		if !e.Success {
+			result = e.Error
+		}
+		table.AddRow(e.Timestamp.Local().Format(time.DateTime), e.Operation, e.Requester, e.Issuer, workload, result)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderFleetUtilization(reports []controlapi.UtilizationReport, since time.Duration) {
+	nodes := make(map[string]struct{})
+	var vcpuSeconds, vcpuCapacity, memoryMibSeconds, memoryCapacity, workloadSeconds, periodSeconds, cpuSeconds float64
+	peakWorkloads := 0
+
+	namespaces := make(map[string]*controlapi.NamespaceUtilization)
+	// keyed by namespace and then workload name
+	workloads := make(map[string]map[string]*controlapi.WorkloadUtilization)
+	for _, report := range reports {
+		nodes[report.NodeId] = struct{}{}
+
+		period := report.PeriodEnd.Sub(report.PeriodStart).Seconds()
+		vcpuSeconds += report.AllocatedVCPUSeconds
+		vcpuCapacity += float64(report.VCPUs) * period
+		memoryMibSeconds += report.AllocatedMemoryMibSeconds
+		memoryCapacity += float64(report.MemoryMib) * period
+		workloadSeconds += report.AverageWorkloads * period
+		periodSeconds += period
+		peakWorkloads = max(peakWorkloads, report.PeakWorkloads)
+		cpuSeconds += report.CPUSeconds
+
+		for _, ns := range report.Namespaces {
+			total, ok := namespaces[ns.Namespace]
+			if !ok {
+				total = &controlapi.NamespaceUtilization{Namespace: ns.Namespace}
+				namespaces[ns.Namespace] = total
+			}
+			total.Deploys += ns.Deploys
+			total.PeakWorkloads = max(total.PeakWorkloads, ns.PeakWorkloads)
+			total.VCPUSeconds += ns.VCPUSeconds
+			total.MemoryMibSeconds += ns.MemoryMibSeconds
+			total.Triggers += ns.Triggers
+			total.FailedTriggers += ns.FailedTriggers
+			total.FunctionRunTimeMillis += ns.FunctionRunTimeMillis
+			total.CPUSeconds += ns.CPUSeconds
+			total.ThrottledCPUSeconds += ns.ThrottledCPUSeconds
+
+			for _, w := range ns.Workloads {
+				if workloads[ns.Namespace] == nil {
+					workloads[ns.Namespace] = make(map[string]*controlapi.WorkloadUtilization)
+				}
+				wTotal, ok := workloads[ns.Namespace][w.Name]
+				if !ok {
+					wTotal = &controlapi.WorkloadUtilization{Name: w.Name}
+					workloads[ns.Namespace][w.Name] = wTotal
+				}
+				wTotal.VCPUSeconds += w.VCPUSeconds
+				wTotal.CPUSeconds += w.CPUSeconds
+				wTotal.ThrottledCPUSeconds += w.ThrottledCPUSeconds
+				wTotal.CPUPeriods += w.CPUPeriods
+				wTotal.ThrottledCPUPeriods += w.ThrottledCPUPeriods
+			}
+		}
+	}
+
+	percent := func(used, available float64) string {
+		if available <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", used/available*100)
+	}
+
+	cols := newColumns("Fleet utilization over the last %s", since)
+	cols.AddRow("Nodes", len(nodes))
+	cols.AddRow("Reports", len(reports))
+	cols.AddRow("vCPU Utilization", percent(vcpuSeconds, vcpuCapacity))
+	cols.AddRow("Memory Utilization", percent(memoryMibSeconds, memoryCapacity))
+	cols.AddRow("vCPU Hours", fmt.Sprintf("%.1f", vcpuSeconds/3600))
+	if cpuSeconds > 0 {
+		cols.AddRow("CPU Utilization", percent(cpuSeconds, vcpuCapacity))
+		cols.AddRow("CPU Hours Used", fmt.Sprintf("%.1f", cpuSeconds/3600))
+	}
+	cols.AddRow("Memory GiB Hours", fmt.Sprintf("%.1f", memoryMibSeconds/1024/3600))
+	if periodSeconds > 0 {
+		cols.AddRow("Average Workloads per Node", fmt.Sprintf("%.1f", workloadSeconds/periodSeconds))
+	}
+	cols.AddRow("Peak Workloads on a Node", peakWorkloads)
+	render(cols)
+
+	if len(namespaces) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := newTableWriter("Namespace utilization")
+	table.AddHeaders("Namespace", "Deploys", "Peak Workloads", "vCPU Hours", "CPU Hours Used", "Memory GiB Hours", "Triggers", "Failed", "Function Run Time")
+	for _, name := range names {
+		ns := namespaces[name]
+		table.AddRow(ns.Namespace,
+			ns.Deploys,
+			ns.PeakWorkloads,
+			fmt.Sprintf("%.1f", ns.VCPUSeconds/3600),
+			fmt.Sprintf("%.1f", ns.CPUSeconds/3600),
+			fmt.Sprintf("%.1f", ns.MemoryMibSeconds/1024/3600),
+			ns.Triggers,
+			ns.FailedTriggers,
+			time.Duration(ns.FunctionRunTimeMillis)*time.Millisecond,
+		)
+	}
+	fmt.Println(table.Render())
+
+	if len(workloads) == 0 {
+		return
+	}
+
+	table = newTableWriter("Workload CPU use")
+	table.AddHeaders("Namespace", "Workload", "vCPU Hours", "CPU Hours Used", "CPU Use", "Throttled Time", "Throttled Periods")
+	for _, namespace := range names {
+		workloadNames := make([]string, 0, len(workloads[namespace]))
+		for name := range workloads[namespace] {
+			workloadNames = append(workloadNames, name)
+		}
+		sort.Strings(workloadNames)
+
+		for _, name := range workloadNames {
+			w := workloads[namespace][name]
+			table.AddRow(namespace,
+				w.Name,
+				fmt.Sprintf("%.1f", w.VCPUSeconds/3600),
+				fmt.Sprintf("%.1f", w.CPUSeconds/3600),
+				percent(w.CPUSeconds, w.VCPUSeconds),
+				time.Duration(w.ThrottledCPUSeconds*float64(time.Second)).Round(time.Second),
+				percent(float64(w.ThrottledCPUPeriods), float64(w.CPUPeriods)),
+			)
+		}
+	}
+	fmt.Println(table.Render())
+}
+
+func renderPreflightReport(report controlapi.PreflightResponse) {
+	result := "✅ passed"
+	if !report.Passed {
+		result = "⛔ failed"
+	}
+	if report.NoSandbox {
+		result += " (running without a sandbox)"
+	}
+
+	table := newTableWriter(fmt.Sprintf("Preflight checks of node %s: %s", report.NodeId, result))
+	table.AddHeaders("Requirement", "Description", "Satisfied", "Location")
+
+	for _, check := range report.Checks {
+		location := check.Path
+		if !check.Satisfied {
+			location = fmt.Sprintf("not found in %s", strings.Join(check.Searched, ", "))
+		}
+		table.AddRow(check.Requirement, check.Description, check.Satisfied, location)
+	}
+
+	fmt.Println(table.Render())
+}
+
+func renderWorkloadDescription(desc *controlapi.DescribeResponse) error {
+	table := newTableWriter(fmt.Sprintf("Workload %s", desc.Workload.Name))
+	table.AddRow("Machine", desc.MachineId)
+	table.AddRow("Machine Template", desc.MachineTemplate)
+	table.AddRow("Node", fmt.Sprintf("%s (%s)", desc.NodeId, desc.NodeVersion))
+	table.AddRow("Namespace", desc.Namespace)
+	table.AddRow("State", desc.State)
+	table.AddRow("Healthy", desc.Healthy)
+	table.AddRow("Ready", desc.Ready)
+	if desc.HealthMessage != "" {
+		table.AddRow("Health", desc.HealthMessage)
+	}
+	table.AddRow("IP", desc.IP)
+	if desc.DNSName != "" {
+		table.AddRow("DNS Name", desc.DNSName)
+	}
+	table.AddRow("Type", desc.Workload.WorkloadType)
+	table.AddRow("Hash", desc.Workload.Hash)
+	table.AddRow("Runtime", desc.Workload.Runtime)
+	table.AddRow("Retries", desc.RetryCount)
+	table.AddRow("Resources", fmt.Sprintf("%d vCPU, %d MiB, %d bytes deployed", desc.Resources.VCPU, desc.Resources.MemoryMib, desc.Resources.DeployedBytes))
+	if desc.Resources.HostMemoryMib > 0 {
+		table.AddRow("Host Usage", fmt.Sprintf("%d MiB, %d s CPU", desc.Resources.HostMemoryMib, desc.Resources.HostCPUSeconds))
+		if desc.Resources.HostCPUThrottledPercent > 0 {
+			table.AddRow("CPU Throttling", fmt.Sprintf("%d s, %.1f%% of periods", desc.Resources.HostCPUThrottledSeconds, desc.Resources.HostCPUThrottledPercent))
+		}
+	}
+	if desc.Boot != nil {
+		table.AddRow("Boot", bootTimings(desc.Boot))
+	}
+	if len(desc.TriggerSubjects) > 0 {
+		table.AddRow("Trigger Subjects", strings.Join(desc.TriggerSubjects, ", "))
+	}
+	if len(desc.ScanResults) > 0 {
+		scans := make([]string, 0, len(desc.ScanResults))
+		for _, result := range desc.ScanResults {
+			scans = append(scans, result.Scanner)
+		}
+		table.AddRow("Scanned By", strings.Join(scans, ", "))
+	}
+	labels := make([]string, 0, len(desc.Labels))
+	for k, v := range desc.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(labels)
+	if len(labels) > 0 {
+		table.AddRow("Labels", strings.Join(labels, ", "))
+	}
+	if len(desc.EnvironmentKeys) > 0 {
+		table.AddRow("Environment", strings.Join(desc.EnvironmentKeys, ", "))
+	}
+	if len(desc.Defaulted) > 0 {
+		table.AddRow("Defaulted", strings.Join(desc.Defaulted, ", "))
+	}
+	fmt.Println(table.Render())
+
+	spec, err := json.MarshalIndent(desc.Effective, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Effective spec:\n%s\n\n", spec)
+
+	if desc.Canary != nil {
+		renderCanarySplit(desc.Canary)
+	}
+	renderMachineTimeline(&controlapi.TimelineResponse{MachineId: desc.MachineId, Entries: desc.Events})
+	return nil
+}
+
+func renderRoles(res *controlapi.RoleResponse) {
+	table := newTableWriter(fmt.Sprintf("Roles in namespace %s", res.Namespace))
+	table.AddHeaders("Identity", "Role", "Source")
+
+	rows := make([][]string, 0, len(res.Assignments)+len(res.Configured))
+	for identity, role := range res.Configured {
+		rows = append(rows, []string{identity, role, "node configuration"})
+	}
+	for identity, role := range res.Assignments {
+		rows = append(rows, []string{identity, role, "role bucket"})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	for _, row := range rows {
+		table.AddRow(row[0], row[1], row[2])
+	}
+	fmt.Println(table.Render())
+}
+
+func renderMachineTimeline(timeline *controlapi.TimelineResponse) {
+	table := newTableWriter(fmt.Sprintf("Timeline of machine %s", timeline.MachineId))
+	table.AddHeaders("Time", "Event", "State", "Reason")
+
+	for _, entry := range timeline.Entries {
+		table.AddRow(entry.Timestamp.Format(time.RFC3339), entry.Event, entry.State, entry.Reason)
 	}
 
 	fmt.Println(table.Render())
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	// Annotation in which `oras push` records the file name of each layer
+	ociTitleAnnotation = "org.opencontainers.image.title"
+
+	ociPullTimeout = 5 * time.Minute
+)
+
+// An artifact in an OCI registry, referenced as oci://{registry}/{repository}:{tag} or
+// oci://{registry}/{repository}@{digest}. The tag defaults to latest
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+func (r *ociReference) String() string {
+	if strings.HasPrefix(r.reference, "sha256:") {
+		return fmt.Sprintf("oci://%s/%s@%s", r.registry, r.repository, r.reference)
+	}
+	return fmt.Sprintf("oci://%s/%s:%s", r.registry, r.repository, r.reference)
+}
+
+func parseOCIReference(u *url.URL) (*ociReference, error) {
+	repository := strings.Trim(u.Path, "/")
+	if u.Host == "" || repository == "" {
+		return nil, fmt.Errorf("invalid OCI reference %s; expected oci://{registry}/{repository}[:{tag}]", u)
+	}
+
+	ref := &ociReference{registry: u.Host, reference: "latest"}
+	if repo, digest, ok := strings.Cut(repository, "@"); ok {
+		repository, ref.reference = repo, digest
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, ref.reference = repository[:i], repository[i+1:]
+	}
+	ref.repository = repository
+
+	return ref, nil
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+	// Only present in image indexes, which reference a manifest per platform
+	Manifests []ociDescriptor `json:"manifests,omitempty"`
+}
+
+// Pulls an artifact with a single layer, as pushed by e.g. `oras push`, from an OCI registry,
+// authenticating anonymously if the registry requires a token. The layer is written to a temporary
+// file, whose path is returned along with the layer's file name
+func pullOCIArtifact(ref *ociReference) (string, string, error) {
+	client := &ociClient{
+		http: &http.Client{Timeout: ociPullTimeout},
+		ref:  ref,
+	}
+
+	resp, err := client.get(fmt.Sprintf("manifests/%s", ref.reference), ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return "", "", err
+	}
+	var manifest ociManifest
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode manifest: %s", err)
+	}
+
+	if len(manifest.Manifests) > 0 {
+		return "", "", errors.New("reference is an image index; reference an artifact with a single layer instead")
+	}
+	if len(manifest.Layers) != 1 {
+		return "", "", fmt.Errorf("expected an artifact with a single layer, found %d layers", len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+
+	filename := layer.Annotations[ociTitleAnnotation]
+	if filename == "" {
+		filename = path.Base(ref.repository)
+	}
+
+	algorithm, expected, ok := strings.Cut(layer.Digest, ":")
+	if !ok || algorithm != "sha256" {
+		return "", "", fmt.Errorf("unsupported layer digest %s", layer.Digest)
+	}
+
+	resp, err = client.get(fmt.Sprintf("blobs/%s", layer.Digest), "")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	f, err := os.CreateTemp("", "nex-oci-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != expected {
+		err = fmt.Errorf("layer digest mismatch; expected %s", layer.Digest)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", "", err
+	}
+
+	return f.Name(), filename, nil
+}
+
+// Requests resources of a repository from its registry's distribution API
+type ociClient struct {
+	http  *http.Client
+	ref   *ociReference
+	token string
+}
+
+func (c *ociClient) get(resource string, accept string) (*http.Response, error) {
+	resp, err := c.do(resource, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		_ = resp.Body.Close()
+
+		c.token, err = c.anonymousToken(challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.do(resource, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("registry responded to %s request with %s", resource, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *ociClient) do(resource string, accept string) (*http.Response, error) {
+	// local registries are rarely served over TLS
+	scheme := "https"
+	if host := strings.Split(c.ref.registry, ":")[0]; host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/v2/%s/%s", scheme, c.ref.registry, c.ref.repository, resource), nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// Requests an anonymous pull token from the realm given by the registry's bearer challenge
+func (c *ociClient) anonymousToken(challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry requires unsupported %s authentication", scheme)
+	}
+
+	attrs := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok {
+			attrs[key] = strings.Trim(value, `"`)
+		}
+	}
+	if attrs["realm"] == "" {
+		return "", errors.New("registry's authentication challenge has no realm")
+	}
+
+	query := url.Values{}
+	if attrs["service"] != "" {
+		query.Set("service", attrs["service"])
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", c.ref.repository))
+
+	resp, err := c.http.Get(attrs["realm"] + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request failed with %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
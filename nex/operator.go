@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nkeys"
+	nexclient "github.com/synadia-io/nex/client"
+	"github.com/synadia-io/nex/internal/models"
+	nexoperator "github.com/synadia-io/nex/internal/operator"
+)
+
+// Runs the Kubernetes operator until interrupted, running the cluster's NexWorkloads on the nodes
+// of their namespaces
+func RunOperator(ctx context.Context, logger *slog.Logger) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	var kube *nexoperator.KubeClient
+	if OperOpts.KubeAPIURL != "" {
+		kube, err = nexoperator.NewKubeClient(OperOpts.KubeAPIURL, OperOpts.KubeToken, OperOpts.KubeCAFile)
+	} else {
+		kube, err = nexoperator.InClusterKubeClient()
+	}
+	if err != nil {
+		return err
+	}
+
+	signer, err := claimsSignerFromOpts(OperOpts.ClaimsIssuerFile, OperOpts.VaultTransitKey)
+	if err != nil {
+		return err
+	}
+	clientOpts := []nexclient.Option{
+		nexclient.WithTimeout(Opts.Timeout),
+		nexclient.WithLogger(logger),
+		nexclient.WithIssuerSigner(signer),
+	}
+
+	if OperOpts.PublisherXkeyFile != "" {
+		xkeyRaw, err := os.ReadFile(OperOpts.PublisherXkeyFile)
+		if err != nil {
+			return err
+		}
+		xkey, err := nkeys.FromCurveSeed(xkeyRaw)
+		if err != nil {
+			return err
+		}
+		clientOpts = append(clientOpts, nexclient.WithXKey(xkey))
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	op := nexoperator.NewOperator(kube, nc, nexoperator.OperatorOptions{
+		WatchNamespace: OperOpts.WatchNamespace,
+		ResyncInterval: OperOpts.ResyncInterval,
+		ClientOptions:  clientOpts,
+	}, logger)
+	return op.Run(ctx)
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nats-io/nkeys"
 	controlapi "github.com/synadia-io/nex/internal/control-api"
@@ -20,16 +27,11 @@ func StopWorkload(ctx context.Context, logger *slog.Logger) error {
 
 	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
 
-	issuerSeed, err := os.ReadFile(StopOpts.ClaimsIssuerFile)
+	signer, err := claimsSignerFromOpts(StopOpts.ClaimsIssuerFile, StopOpts.VaultTransitKey)
 	if err != nil {
 		return err
 	}
-
-	issuerKp, err := nkeys.FromSeed(issuerSeed)
-	if err != nil {
-		return err
-	}
-	stopRequest, err := controlapi.NewStopRequest(StopOpts.WorkloadId, StopOpts.WorkloadName, StopOpts.TargetNode, issuerKp)
+	stopRequest, err := controlapi.NewSignedStopRequest(StopOpts.WorkloadId, StopOpts.WorkloadName, StopOpts.TargetNode, signer)
 	if err != nil {
 		fmt.Printf("⛔ Failed to create workload request: %s\n", err)
 		return err
@@ -44,6 +46,111 @@ func StopWorkload(ctx context.Context, logger *slog.Logger) error {
 	return nil
 }
 
+// Promotes the replacement awaiting promotion from a blue-green or canary workload update, aborts
+// the update, or changes the share of trigger messages routed to the replacement of a canary update
+func PromoteWorkload(ctx context.Context, logger *slog.Logger) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
+
+	signer, err := claimsSignerFromOpts(StopOpts.ClaimsIssuerFile, StopOpts.VaultTransitKey)
+	if err != nil {
+		return err
+	}
+	request, err := controlapi.NewSignedPromoteRequest(StopOpts.WorkloadId, StopOpts.WorkloadName, StopOpts.TargetNode, StopOpts.Abort, signer)
+	if err != nil {
+		fmt.Printf("⛔ Failed to create promote request: %s\n", err)
+		return err
+	}
+	if StopOpts.CanaryWeight > 0 {
+		request.CanaryWeight = &StopOpts.CanaryWeight
+	}
+	resp, err := nodeClient.PromoteWorkload(request)
+	if err != nil {
+		fmt.Printf("⛔ Workload promote request failed: %s\n", err)
+		return err
+	}
+
+	renderWorkloadUpdateResponse(StopOpts.TargetNode, resp)
+	return nil
+}
+
+// Stops all of the issuer's workloads in the namespace matching the label selector, either on
+// the specified node or on every discovered node
+func StopWorkloads(ctx context.Context, logger *slog.Logger) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+
+	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
+
+	signer, err := claimsSignerFromOpts(StopOpts.ClaimsIssuerFile, StopOpts.VaultTransitKey)
+	if err != nil {
+		return err
+	}
+
+	targetNodes := []string{StopOpts.TargetNode}
+	if StopOpts.TargetNode == "" {
+		nodes, err := nodeClient.ListNodes()
+		if err != nil {
+			return err
+		}
+
+		targetNodes = make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			targetNodes = append(targetNodes, node.NodeId)
+		}
+	}
+
+	for _, nodeId := range targetNodes {
+		request, err := controlapi.NewSignedBulkStopRequest(StopOpts.Selector, nodeId, signer)
+		if err != nil {
+			fmt.Printf("⛔ Failed to create bulk stop request: %s\n", err)
+			return err
+		}
+
+		resp, err := nodeClient.StopWorkloads(request)
+		if err != nil {
+			fmt.Printf("⛔ Bulk stop request to node %s failed: %s\n", nodeId, err)
+			continue
+		}
+
+		renderBulkStopResponse(nodeId, resp)
+	}
+
+	return nil
+}
+
+// Signs request JWTs with the given Vault transit key, if any, or else with the issuer seed key
+// in the given file
+func claimsSignerFromOpts(issuerFile string, vaultTransitKey string) (controlapi.ClaimsSigner, error) {
+	if vaultTransitKey != "" {
+		transit := controlapi.NewVaultTransit(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), vaultTransitKey)
+		transit.Namespace = os.Getenv("VAULT_NAMESPACE")
+		return transit, nil
+	}
+
+	if issuerFile == "" {
+		return nil, errors.New("an issuer seed key or vault transit key is required")
+	}
+
+	issuerSeed, err := os.ReadFile(issuerFile)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerKp, err := nkeys.FromSeed(issuerSeed)
+	if err != nil {
+		return nil, err
+	}
+
+	return controlapi.NkeyClaimsSigner{KeyPair: issuerKp}, nil
+}
+
 // Submits a run request for the given workload to the specified node
 func RunWorkload(ctx context.Context, logger *slog.Logger) error {
 	nc, err := models.GenerateConnectionFromOpts(Opts)
@@ -52,23 +159,55 @@ func RunWorkload(ctx context.Context, logger *slog.Logger) error {
 	}
 	nodeClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
 
+	// workloads run on a cluster are deployed by its leader, for which the env is encrypted
+	targetNode := RunOpts.TargetNode
+	if RunOpts.Replicas > 0 && (!RunOpts.Cluster || RunOpts.DeployTokenFile != "") {
+		return errors.New("replicas may only be run on a cluster, with an issuer rather than a single-use deploy token")
+	}
+	if RunOpts.UpdateWorkloadId != "" && RunOpts.Cluster {
+		return errors.New("workloads are updated on the node running them, rather than on a cluster")
+	}
+	if RunOpts.Cluster {
+		cluster, err := nodeClient.ClusterInfo(RunOpts.TargetNode)
+		if err != nil {
+			return err
+		}
+		targetNode = cluster.Leader
+	}
+
 	// Get node info so we can get public xkey from the target for env encryption
-	nodeInfo, err := nodeClient.NodeInfo(RunOpts.TargetNode)
+	nodeInfo, err := nodeClient.NodeInfo(targetNode)
 	if err != nil {
 		return err
 	}
 
 	targetPublicXkey := nodeInfo.PublicXKey
 
-	issuerSeed, err := os.ReadFile(RunOpts.ClaimsIssuerFile)
+	// local files and OCI artifacts are uploaded first, which may name the workload
+	location, err := stageWorkloadArtifact(nc)
 	if err != nil {
 		return err
 	}
 
-	issuerKp, err := nkeys.FromSeed(issuerSeed)
-	if err != nil {
-		return err
+	var signer controlapi.ClaimsSigner
+	var deployToken string
+	if RunOpts.DeployTokenFile != "" {
+		token, err := os.ReadFile(RunOpts.DeployTokenFile)
+		if err != nil {
+			return err
+		}
+		deployToken = strings.TrimSpace(string(token))
+	} else {
+		if RunOpts.Name == "" {
+			return errors.New("either a deploy token, or an issuer and workload name, are required")
+		}
+
+		signer, err = claimsSignerFromOpts(RunOpts.ClaimsIssuerFile, RunOpts.VaultTransitKey)
+		if err != nil {
+			return err
+		}
 	}
+
 	xkeyRaw, err := os.ReadFile(RunOpts.PublisherXkeyFile)
 	if err != nil {
 		return nil
@@ -78,24 +217,150 @@ func RunWorkload(ctx context.Context, logger *slog.Logger) error {
 		return err
 	}
 
+	signature, err := signatureFromOpts()
+	if err != nil {
+		return err
+	}
+
+	secrets, err := nodeClient.SealEnvironment(targetNode, xkey, RunOpts.Secrets)
+	if err != nil {
+		return err
+	}
+
+	issuerChain, err := issuerChainFromOpts()
+	if err != nil {
+		return err
+	}
+
+	egressPolicy, err := egressPolicyFromOpts()
+	if err != nil {
+		return err
+	}
+
+	placement, err := placementFromOpts()
+	if err != nil {
+		return err
+	}
+
+	webhookToken, err := webhookTokenFromOpts()
+	if err != nil {
+		return err
+	}
+
+	stdin, err := stdinFromOpts()
+	if err != nil {
+		return err
+	}
+
 	request, err := controlapi.NewDeployRequest(
-		controlapi.Location(RunOpts.WorkloadUrl.String()),
+		controlapi.Argv(strings.Fields(RunOpts.Argv)),
+		controlapi.Stdin(stdin),
+		controlapi.ExecPerTrigger(RunOpts.ExecPerTrigger),
+		controlapi.Location(location),
 		controlapi.Environment(RunOpts.Env),
+		secrets,
 		controlapi.Essential(RunOpts.Essential),
-		controlapi.Issuer(issuerKp),
+		controlapi.MachineTemplate(RunOpts.MachineTemplate),
+		controlapi.MachineSize(RunOpts.VcpuCount, RunOpts.MemSizeMib),
+		controlapi.IssuerSigner(signer),
+		controlapi.IssuerChain(issuerChain...),
+		controlapi.DeployToken(deployToken),
 		controlapi.SenderXKey(xkey),
-		controlapi.TargetNode(RunOpts.TargetNode),
+		controlapi.TargetNode(targetNode),
 		controlapi.TargetPublicXKey(targetPublicXkey),
 		controlapi.WorkloadName(RunOpts.Name),
 		controlapi.WorkloadType(RunOpts.WorkloadType),
 		controlapi.TriggerSubjects(RunOpts.TriggerSubjects),
+		controlapi.TriggerDelivery(RunOpts.TriggerDelivery),
+		controlapi.TriggerQueueGroups(RunOpts.TriggerQueueGroups),
+		controlapi.IdleTimeout(RunOpts.IdleTimeout),
+		controlapi.DeployTimeouts(RunOpts.AckTimeout, RunOpts.ReadyTimeout),
+		controlapi.TriggerConcurrencyLimit(RunOpts.MaxInFlight, RunOpts.TriggerQueueSize, RunOpts.TriggerOverflow),
+		controlapi.CronTriggers(cronTriggersFromOpts()),
 		controlapi.Checksum("abc12345TODOmakethisreal"),
 		controlapi.WorkloadDescription(RunOpts.Description),
+		controlapi.WorkloadDigest(RunOpts.Digest),
+		controlapi.StreamArtifact(RunOpts.StreamArtifact),
+		controlapi.IdempotencyKey(RunOpts.IdempotencyKey),
+		controlapi.WorkloadSignature(signature),
+		controlapi.WorkloadLabels(RunOpts.Labels),
+		controlapi.WorkloadHealthCheck(healthCheckFromOpts()),
+		controlapi.WorkloadReadinessProbe(readinessProbeFromOpts()),
+		controlapi.WorkloadCredentials(credentialsFromOpts()),
+		controlapi.WorkloadPreStartHook(workloadHookFromOpts(RunOpts.PreStartHook)),
+		controlapi.WorkloadPostStopHook(workloadHookFromOpts(RunOpts.PostStopHook)),
+		controlapi.WorkloadMemorySoftLimit(memorySoftLimitFromOpts()),
+		controlapi.WorkloadLogLimits(logLimitsFromOpts()),
+		controlapi.WorkloadEgressPolicy(egressPolicy),
+		controlapi.WorkloadSandboxProfile(RunOpts.SandboxProfile),
+		controlapi.SecretReferences(RunOpts.SecretRefs),
+		controlapi.WorkloadStableIP(RunOpts.StableIP),
+		controlapi.WorkloadDNSName(RunOpts.DNSName),
+		controlapi.WorkloadVolume(RunOpts.VolumeSizeMib),
+		controlapi.WorkloadPlacement(placement),
+		controlapi.WorkloadWebhook(webhookToken, RunOpts.WebhookSubject),
+		controlapi.WorkloadMicroService(microServiceFromOpts()),
 	)
 	if err != nil {
 		return nil
 	}
 
+	if RunOpts.Cluster && RunOpts.Replicas > 0 {
+		resp, err := nodeClient.CreateDeploySet(RunOpts.TargetNode, &controlapi.DeploySetRequest{
+			Name:     RunOpts.Name,
+			Replicas: RunOpts.Replicas,
+			NodeTags: RunOpts.NodeTags,
+			Request:  request,
+		})
+		if err != nil {
+			fmt.Printf("⛔ Deploy set request failed to submit to cluster %s: %s\n", RunOpts.TargetNode, err)
+			return err
+		}
+
+		renderDeploySets(resp)
+		return nil
+	}
+
+	if RunOpts.Cluster {
+		resp, err := nodeClient.StartClusterWorkload(RunOpts.TargetNode, &controlapi.ClusterDeployRequest{
+			NodeTags: RunOpts.NodeTags,
+			Request:  request,
+		})
+		if err != nil {
+			fmt.Printf("⛔ Workload run request failed to submit to cluster %s: %s\n", RunOpts.TargetNode, err)
+			return err
+		}
+
+		renderRunResponse(resp.NodeId, resp)
+		renderWebhook(resp, webhookToken)
+		return awaitWorkloadFromOpts(nodeClient, resp.NodeId, resp)
+	}
+
+	err = nodeClient.ReplicateArtifact(request, nodeInfo.Tags[controlapi.TagJsDomain])
+	if err != nil {
+		return err
+	}
+
+	if RunOpts.UpdateWorkloadId != "" {
+		// the node deploys the replacement, and may wait for it to become healthy, before responding
+		updateClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout+RunOpts.UpdateHealthTimeout+time.Minute, Opts.Namespace, logger)
+		resp, err := updateClient.UpdateWorkload(&controlapi.WorkloadUpdateRequest{
+			WorkloadId:          RunOpts.UpdateWorkloadId,
+			TargetNode:          targetNode,
+			Strategy:            RunOpts.UpdateStrategy,
+			HealthTimeoutMillis: int(RunOpts.UpdateHealthTimeout.Milliseconds()),
+			CanaryWeight:        RunOpts.CanaryWeight,
+			Request:             request,
+		})
+		if err != nil {
+			fmt.Printf("⛔ Workload update request failed: %s\n", err)
+			return err
+		}
+
+		renderWorkloadUpdateResponse(targetNode, resp)
+		return nil
+	}
+
 	resp, err := nodeClient.StartWorkload(request)
 	if err != nil {
 		fmt.Printf("⛔ Workload run request failed to submit: %s\n", err)
@@ -103,9 +368,309 @@ func RunWorkload(ctx context.Context, logger *slog.Logger) error {
 	}
 
 	renderRunResponse(RunOpts.TargetNode, resp)
+	renderWebhook(resp, webhookToken)
+	return awaitWorkloadFromOpts(nodeClient, RunOpts.TargetNode, resp)
+}
+
+// Waits for the started workload to become ready, when requested with --wait
+func awaitWorkloadFromOpts(nodeClient *controlapi.Client, nodeId string, resp *controlapi.RunResponse) error {
+	if RunOpts.AwaitReady <= 0 || !resp.Started {
+		return nil
+	}
+
+	fmt.Println()
+	readiness, err := nodeClient.AwaitWorkload(nodeId, resp, RunOpts.AwaitReady)
+	if err != nil {
+		fmt.Printf("⛔ Workload did not become ready: %s\n", err)
+		return err
+	}
+
+	renderWorkloadReadiness(readiness)
+	return nil
+}
+
+// Returns the token callers of the workload's webhook must present, generating one when a
+// webhook is requested without a token
+func webhookTokenFromOpts() (string, error) {
+	if RunOpts.WebhookToken != "" || !RunOpts.Webhook {
+		return RunOpts.WebhookToken, nil
+	}
+
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// Mints a single-use deploy token for the given workload and artifact digest, printing it to stdout
+func MintDeployToken(ctx context.Context) error {
+	issuerSeed, err := os.ReadFile(TokenOpts.ClaimsIssuerFile)
+	if err != nil {
+		return err
+	}
+
+	issuerKp, err := nkeys.FromSeed(issuerSeed)
+	if err != nil {
+		return err
+	}
+
+	token, err := controlapi.CreateDeployToken(TokenOpts.Name, TokenOpts.Digest, Opts.Namespace, TokenOpts.TTL, issuerKp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
 	return nil
 }
 
+// Builds the workload health check from the --health_* flags, if any were given
+func healthCheckFromOpts() *controlapi.HealthCheck {
+	return probeFromOpts(RunOpts.HealthCheckExec, RunOpts.HealthCheckHTTP, RunOpts.HealthCheckNATS, RunOpts.HealthCheckInterval)
+}
+
+// Builds the workload readiness probe from the --ready_* flags, if any were given
+func readinessProbeFromOpts() *controlapi.HealthCheck {
+	return probeFromOpts(RunOpts.ReadinessExec, RunOpts.ReadinessHTTP, RunOpts.ReadinessNATS, RunOpts.ReadinessInterval)
+}
+
+func probeFromOpts(command string, url string, subject string, interval time.Duration) *controlapi.HealthCheck {
+	hc := &controlapi.HealthCheck{
+		IntervalMillis: int(interval.Milliseconds()),
+	}
+
+	switch {
+	case command != "":
+		hc.Type = "exec"
+		hc.Command = strings.Fields(command)
+	case url != "":
+		hc.Type = "http"
+		hc.URL = &url
+	case subject != "":
+		hc.Type = "nats"
+		hc.Subject = &subject
+	default:
+		return nil
+	}
+
+	return hc
+}
+
+// Builds the workload's cron triggers from the --cron and --cron_timezone flags
+func cronTriggersFromOpts() []controlapi.CronTrigger {
+	triggers := make([]controlapi.CronTrigger, 0, len(RunOpts.CronSchedules))
+	for _, schedule := range RunOpts.CronSchedules {
+		triggers = append(triggers, controlapi.CronTrigger{
+			Schedule: schedule,
+			Timezone: &RunOpts.CronTimezone,
+		})
+	}
+
+	if len(triggers) == 0 {
+		return nil
+	}
+	return triggers
+}
+
+// Builds a workload hook from the given --pre_start or --post_stop flag, if it was given
+func workloadHookFromOpts(command string) *controlapi.WorkloadHook {
+	if command == "" {
+		return nil
+	}
+
+	return &controlapi.WorkloadHook{
+		Command:       strings.Fields(command),
+		TimeoutMillis: int(RunOpts.HookTimeout.Milliseconds()),
+	}
+}
+
+// Builds the workload's soft memory limit from the --memory_soft_limit and --memory_signal
+// flags, if a limit was given
+func memorySoftLimitFromOpts() *controlapi.MemorySoftLimit {
+	if RunOpts.MemorySoftLimit == 0 {
+		return nil
+	}
+
+	limit := &controlapi.MemorySoftLimit{ThresholdPercent: RunOpts.MemorySoftLimit}
+	if RunOpts.MemorySoftLimitSignal != "" {
+		limit.Signal = &RunOpts.MemorySoftLimitSignal
+	}
+	return limit
+}
+
+// Builds the limits on the workload's output forwarded as logs from the --output_level,
+// --output_rate and --output_burst flags, if any were given
+func logLimitsFromOpts() *controlapi.LogLimits {
+	if RunOpts.OutputLevel == "" && RunOpts.OutputRate == 0 && RunOpts.OutputBurst == 0 {
+		return nil
+	}
+
+	limits := &controlapi.LogLimits{LinesPerSecond: RunOpts.OutputRate, Burst: RunOpts.OutputBurst}
+	if RunOpts.OutputLevel != "" {
+		limits.Level = &RunOpts.OutputLevel
+	}
+	return limits
+}
+
+// Builds the workload's egress policy from the --egress and --egress_dns flags, if any
+// destinations were given. Each destination is [tcp:|udp:]{cidr|host}[:port[,port...]]
+func placementFromOpts() (*controlapi.PlacementConstraints, error) {
+	placement := &controlapi.PlacementConstraints{AntiAffinity: RunOpts.AntiAffinity}
+	for _, expr := range RunOpts.NodeSelectors {
+		requirement, err := controlapi.ParseNodeSelector(expr)
+		if err != nil {
+			return nil, err
+		}
+		placement.NodeSelector = append(placement.NodeSelector, requirement)
+	}
+
+	return placement, nil
+}
+
+// Reads the data written to the workload's stdin from the --stdin file, if given
+func stdinFromOpts() ([]byte, error) {
+	if RunOpts.StdinFile == "" {
+		return nil, nil
+	}
+
+	stdin, err := os.ReadFile(RunOpts.StdinFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin file: %s", err)
+	}
+	return stdin, nil
+}
+
+func egressPolicyFromOpts() (*controlapi.EgressPolicy, error) {
+	if len(RunOpts.Egress) == 0 {
+		if RunOpts.EgressDNS {
+			return nil, errors.New("--egress_dns requires at least one --egress destination")
+		}
+		return nil, nil
+	}
+
+	policy := &controlapi.EgressPolicy{AllowDNS: RunOpts.EgressDNS}
+	for _, destination := range RunOpts.Egress {
+		parts := strings.Split(destination, ":")
+
+		rule := controlapi.EgressRule{}
+		if len(parts) > 1 && (parts[0] == "tcp" || parts[0] == "udp") {
+			rule.Protocol = parts[0]
+			parts = parts[1:]
+		}
+		if len(parts) > 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid egress destination: %s", destination)
+		}
+
+		if strings.Contains(parts[0], "/") || net.ParseIP(parts[0]) != nil {
+			rule.CIDR = &parts[0]
+		} else {
+			rule.Host = &parts[0]
+		}
+
+		if len(parts) == 2 {
+			for _, raw := range strings.Split(parts[1], ",") {
+				port, err := strconv.Atoi(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid egress port in %s: %s", destination, raw)
+				}
+				rule.Ports = append(rule.Ports, port)
+			}
+		}
+
+		policy.Allow = append(policy.Allow, rule)
+	}
+
+	return policy, nil
+}
+
+// Builds the request for minted workload NATS credentials from the --creds_* flags, if any were given
+// Builds the function's micro service registration from the --micro flags, if any were given
+func microServiceFromOpts() *controlapi.MicroServiceRegistration {
+	if !RunOpts.MicroService && RunOpts.MicroServiceName == "" && RunOpts.MicroServiceVersion == "" {
+		return nil
+	}
+
+	registration := &controlapi.MicroServiceRegistration{}
+	if RunOpts.MicroServiceName != "" {
+		registration.Name = &RunOpts.MicroServiceName
+	}
+	if RunOpts.MicroServiceVersion != "" {
+		registration.Version = &RunOpts.MicroServiceVersion
+	}
+	return registration
+}
+
+func credentialsFromOpts() *controlapi.CredentialsRequest {
+	if len(RunOpts.CredentialsPublish) == 0 && len(RunOpts.CredentialsSubscribe) == 0 {
+		return nil
+	}
+
+	return &controlapi.CredentialsRequest{
+		PublishAllow:   RunOpts.CredentialsPublish,
+		SubscribeAllow: RunOpts.CredentialsSubscribe,
+		TTLSeconds:     int(RunOpts.CredentialsTTL.Seconds()),
+	}
+}
+
+// Reads the delegation JWTs given by the --delegation flags, in order
+func issuerChainFromOpts() ([]string, error) {
+	chain := make([]string, 0, len(RunOpts.DelegationFiles))
+	for _, file := range RunOpts.DelegationFiles {
+		token, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, strings.TrimSpace(string(token)))
+	}
+	return chain, nil
+}
+
+// Reads the workload artifact signature from the --signature, --certificate, --attestation and --bundle flags, if given
+func signatureFromOpts() (*controlapi.ArtifactSignature, error) {
+	if RunOpts.SignatureFile == "" {
+		return nil, nil
+	}
+
+	sig, err := os.ReadFile(RunOpts.SignatureFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := &controlapi.ArtifactSignature{
+		Signature: strings.TrimSpace(string(sig)),
+	}
+
+	if RunOpts.CertificateFile != "" {
+		cert, err := os.ReadFile(RunOpts.CertificateFile)
+		if err != nil {
+			return nil, err
+		}
+		certificate := strings.TrimSpace(string(cert))
+		signature.Certificate = &certificate
+	}
+
+	if RunOpts.AttestationFile != "" {
+		attestation, err := os.ReadFile(RunOpts.AttestationFile)
+		if err != nil {
+			return nil, err
+		}
+		envelope := string(attestation)
+		signature.Attestation = &envelope
+	}
+
+	if RunOpts.BundleFile != "" {
+		raw, err := os.ReadFile(RunOpts.BundleFile)
+		if err != nil {
+			return nil, err
+		}
+		bundle := string(raw)
+		signature.Bundle = &bundle
+	}
+
+	return signature, nil
+}
+
 func renderRunResponse(targetNode string, resp *controlapi.RunResponse) {
 	if resp.Started {
 		fmt.Printf("🚀 Workload '%s' accepted. You can now refer to this workload with ID: %s on node %s", resp.Name, resp.MachineId, targetNode)
@@ -114,6 +679,70 @@ func renderRunResponse(targetNode string, resp *controlapi.RunResponse) {
 	}
 }
 
+func renderWorkloadReadiness(readiness *controlapi.WorkloadReadiness) {
+	fmt.Printf("✅ Workload '%s' ready after %s", readiness.Name, readiness.Elapsed.Round(time.Millisecond))
+	if readiness.IP != "" {
+		fmt.Printf(" at %s", readiness.IP)
+	}
+	if readiness.Boot != nil {
+		fmt.Printf(" (machine booted in %dms)", readiness.Boot.TotalMillis)
+	}
+	fmt.Println()
+}
+
+func renderWebhook(resp *controlapi.RunResponse, token string) {
+	if !resp.Started || token == "" {
+		return
+	}
+
+	fmt.Println()
+	if resp.WebhookURL != "" {
+		fmt.Printf("🪝 Webhook: %s\n", resp.WebhookURL)
+	}
+	if RunOpts.WebhookToken == "" {
+		fmt.Printf("🔑 Webhook token (shown only once): %s\n", token)
+	}
+}
+
+func renderWorkloadUpdateResponse(targetNode string, resp *controlapi.WorkloadUpdateResponse) {
+	switch {
+	case resp.Aborted:
+		fmt.Printf("↩️  Update of workload '%s' aborted; replacement %s stopped and %s kept on node %s\n", resp.Name, resp.MachineId, resp.PreviousMachineId, targetNode)
+	case resp.Pending:
+		fmt.Printf("🟦 Replacement of workload '%s' started with ID: %s on node %s, alongside %s. Promote it with `nex promote %s %s --name %s`\n", resp.Name, resp.MachineId, targetNode, resp.PreviousMachineId, targetNode, resp.MachineId, resp.Name)
+	default:
+		fmt.Printf("🚀 Workload '%s' replaced (%s). You can now refer to this workload with ID: %s on node %s\n", resp.Name, resp.Strategy, resp.MachineId, targetNode)
+	}
+
+	if resp.Canary != nil {
+		renderCanarySplit(resp.Canary)
+	}
+}
+
+func renderCanarySplit(split *controlapi.CanarySplit) {
+	table := newTableWriter(fmt.Sprintf("Canary split (%d%% to canary)", split.Weight))
+	table.AddHeaders("Version", "Machine", "Hash", "Triggers", "Failed", "Avg Latency (ms)")
+	table.AddRow("stable", split.Stable.MachineId, split.Stable.Hash, split.Stable.Triggers, split.Stable.FailedTriggers, fmt.Sprintf("%.1f", split.Stable.AverageLatencyMillis))
+	table.AddRow("canary", split.Canary.MachineId, split.Canary.Hash, split.Canary.Triggers, split.Canary.FailedTriggers, fmt.Sprintf("%.1f", split.Canary.AverageLatencyMillis))
+	fmt.Println(table.Render())
+}
+
+func renderBulkStopResponse(nodeId string, resp *controlapi.BulkStopResponse) {
+	if len(resp.Results) == 0 {
+		fmt.Printf("No matching workloads on node %s\n", nodeId)
+		return
+	}
+
+	table := newTableWriter(fmt.Sprintf("Workloads stopped on %s", nodeId))
+	table.AddHeaders("ID", "Name", "Stopped", "Error")
+
+	for _, result := range resp.Results {
+		table.AddRow(result.MachineId, result.Name, result.Stopped, result.Error)
+	}
+
+	fmt.Println(table.Render())
+}
+
 func renderStopResponse(resp *controlapi.StopResponse) {
 	if resp.Stopped {
 		fmt.Printf("✅ Workload '%s' stopped.\n", resp.Name)
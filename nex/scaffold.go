@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/synadia-io/nex/internal/scaffold"
+)
+
+// Generates a starter project for a workload of the given type
+func NewProject(ctx context.Context) error {
+	project, err := scaffold.Generate(scaffold.Options{
+		Name:         NewOpts.Name,
+		WorkloadType: NewOpts.WorkloadType,
+		Dir:          NewOpts.Dir,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated %s workload project in %s\n", NewOpts.WorkloadType, project.Dir)
+	for _, f := range project.Files {
+		fmt.Printf("  %s\n", f)
+	}
+	fmt.Printf("\nWorkload JWTs are signed by issuer %s\n", project.IssuerKey)
+	fmt.Println("Add it to the valid_issuers of any node that restricts issuers, then run `task run` in the project directory")
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Lists the names of the control and agent API messages, shows the schema of the named one, or
+// writes every schema to the output directory
+func ShowSchemas(ctx context.Context) error {
+	all := make(map[string]*schema.Schema)
+	for name, s := range controlapi.Schemas() {
+		all["control."+name] = s
+	}
+	for name, s := range agentapi.Schemas() {
+		all["agent."+name] = s
+	}
+
+	if SchemaOpts.Name != "" {
+		s, ok := all[SchemaOpts.Name]
+		if !ok {
+			return fmt.Errorf("no such message: %s", SchemaOpts.Name)
+		}
+		raw, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(raw))
+		return nil
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if SchemaOpts.Output == "" {
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(SchemaOpts.Output, 0755)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		raw, err := json.MarshalIndent(all[name], "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(SchemaOpts.Output, name+".json"), append(raw, '\n'), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Wrote %d schemas to %s\n", len(names), SchemaOpts.Output)
+	return nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/models"
+	"golang.org/x/term"
+)
+
+const (
+	// Number of the most recent errors shown
+	topRecentErrors = 8
+	// Number of the most recent timeline entries shown for the inspected workload
+	topInspectEvents = 5
+)
+
+// The live view of the namespace's nodes and workloads. Node capacity events and workload
+// failures are received as they're published, and error logs as they're emitted; node and
+// workload information is refreshed at the given interval
+type topView struct {
+	client   *controlapi.Client
+	signer   controlapi.ClaimsSigner
+	interval time.Duration
+
+	mutex    sync.Mutex
+	nodes    map[string]*topNode
+	errors   []topError
+	selected int
+	inspect  *controlapi.DescribeResponse
+	status   string
+}
+
+type topNode struct {
+	id       string
+	version  string
+	uptime   string
+	capacity *controlapi.NodeCapacity
+	lastSeen time.Time
+	machines []controlapi.MachineSummary
+
+	// Trigger executions of each function machine as of the last refresh, from which the rate
+	// of executions since the refresh before it is derived
+	executed   map[string]uint64
+	executedAt time.Time
+	rates      map[string]float64
+}
+
+type topError struct {
+	at     time.Time
+	source string
+	text   string
+}
+
+type topWorkload struct {
+	nodeId  string
+	machine controlapi.MachineSummary
+	rate    float64
+}
+
+// Runs the live view until the user quits. Workloads are selected with the arrow keys (or j and
+// k), inspected with enter (or i) and stopped with s; q quits
+func Top(ctx context.Context, logger *slog.Logger) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("the live view requires a terminal")
+	}
+
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	// Anything logged would garble the view
+	quiet := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	view := &topView{
+		client:   controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, quiet),
+		interval: TopOpts.Interval,
+		nodes:    make(map[string]*topNode),
+		errors:   make([]topError, 0),
+	}
+	if TopOpts.ClaimsIssuerFile != "" || TopOpts.VaultTransitKey != "" {
+		view.signer, err = claimsSignerFromOpts(TopOpts.ClaimsIssuerFile, TopOpts.VaultTransitKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	events, err := view.client.MonitorEvents(Opts.Namespace, "*", 64)
+	if err != nil {
+		return err
+	}
+	logs, err := view.client.MonitorLogs(Opts.Namespace, "*", "*", "*", 64)
+	if err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = term.Restore(fd, state)
+		fmt.Print("\033[H\033[2J")
+	}()
+
+	keys := make(chan string)
+	go readTopKeys(keys)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Refreshing waits on every node, so it mustn't hold up key presses
+	refreshed := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(view.interval)
+		defer ticker.Stop()
+		for {
+			view.refresh()
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	view.render()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-refreshed:
+		case event := <-events:
+			view.handleEvent(event)
+		case entry := <-logs:
+			if entry.Level < slog.LevelError {
+				continue
+			}
+			view.recordError(fmt.Sprintf("%s/%s", entry.Workload, short(entry.MachineId)), entry.Text)
+		case key := <-keys:
+			if !view.handleKey(key) {
+				return nil
+			}
+		}
+		view.render()
+	}
+}
+
+// Reads key presses from the terminal, sending "up" and "down" for the arrow keys
+func readTopKeys(keys chan<- string) {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		switch input := string(buf[:n]); input {
+		case "\033[A":
+			keys <- "up"
+		case "\033[B":
+			keys <- "down"
+		default:
+			keys <- input
+		}
+	}
+}
+
+// Returns false if the key quits the view
+func (v *topView) handleKey(key string) bool {
+	switch key {
+	case "", "q", "\003":
+		return false
+	case "up", "k":
+		v.mutex.Lock()
+		if v.selected > 0 {
+			v.selected--
+		}
+		v.mutex.Unlock()
+	case "down", "j":
+		v.mutex.Lock()
+		v.selected++
+		v.mutex.Unlock()
+	case "\r", "i":
+		v.inspectSelected()
+	case "s":
+		v.stopSelected()
+	case "\033":
+		v.mutex.Lock()
+		v.inspect = nil
+		v.mutex.Unlock()
+	}
+	return true
+}
+
+func (v *topView) refresh() {
+	pongs, err := v.client.ListNodes()
+	if err != nil {
+		v.recordError("nex", fmt.Sprintf("Failed to list nodes: %s", err))
+		return
+	}
+
+	now := time.Now()
+	for _, pong := range pongs {
+		info, err := v.client.NodeInfo(pong.NodeId)
+
+		v.mutex.Lock()
+		node := v.node(pong.NodeId)
+		node.version = pong.Version
+		node.uptime = pong.Uptime
+		node.lastSeen = now
+		if pong.Capacity != nil {
+			node.capacity = pong.Capacity
+		}
+		if err == nil {
+			node.machines = info.Machines
+			node.updateRates(now)
+		}
+		v.mutex.Unlock()
+
+		if err != nil {
+			v.recordError(short(pong.NodeId), fmt.Sprintf("Failed to get node info: %s", err))
+		}
+	}
+
+	// Nodes which no longer respond are dropped once they've missed a few refreshes
+	v.mutex.Lock()
+	for id, node := range v.nodes {
+		if now.Sub(node.lastSeen) > 3*v.interval {
+			delete(v.nodes, id)
+		}
+	}
+	v.mutex.Unlock()
+}
+
+// Must be called with the mutex held
+func (v *topView) node(id string) *topNode {
+	node, ok := v.nodes[id]
+	if !ok {
+		node = &topNode{
+			id:       id,
+			executed: make(map[string]uint64),
+			rates:    make(map[string]float64),
+		}
+		v.nodes[id] = node
+	}
+	return node
+}
+
+func (n *topNode) updateRates(now time.Time) {
+	elapsed := now.Sub(n.executedAt).Seconds()
+	executed := make(map[string]uint64)
+	rates := make(map[string]float64)
+
+	for _, machine := range n.machines {
+		if machine.Triggers == nil {
+			continue
+		}
+		executed[machine.Id] = machine.Triggers.Executed
+		if previous, ok := n.executed[machine.Id]; ok && elapsed > 0 && machine.Triggers.Executed >= previous {
+			rates[machine.Id] = float64(machine.Triggers.Executed-previous) / elapsed
+		}
+	}
+
+	n.executed = executed
+	n.executedAt = now
+	n.rates = rates
+}
+
+func (v *topView) handleEvent(event controlapi.EmittedEvent) {
+	switch event.EventType {
+	case controlapi.NodeCapacityEventType:
+		evt := &controlapi.NodeCapacityEvent{}
+		if event.DataAs(evt) != nil {
+			return
+		}
+		v.mutex.Lock()
+		node := v.node(evt.Id)
+		node.capacity = &evt.Capacity
+		node.lastSeen = time.Now()
+		v.mutex.Unlock()
+	case controlapi.NodeStoppedEventType:
+		evt := &controlapi.NodeStoppedEvent{}
+		if event.DataAs(evt) != nil {
+			return
+		}
+		v.mutex.Lock()
+		delete(v.nodes, evt.Id)
+		v.mutex.Unlock()
+		if !evt.Graceful {
+			v.recordError(short(evt.Id), "Node stopped ungracefully")
+		}
+	case controlapi.WorkloadFailedEventType:
+		evt := &controlapi.WorkloadFailedEvent{}
+		if event.DataAs(evt) != nil {
+			return
+		}
+		v.recordError(fmt.Sprintf("%s/%s", evt.Name, short(evt.VmId)), fmt.Sprintf("Workload failed: %s", evt.Reason))
+	}
+}
+
+func (v *topView) recordError(source string, text string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.errors = append([]topError{{at: time.Now(), source: source, text: text}}, v.errors...)
+	if len(v.errors) > topRecentErrors {
+		v.errors = v.errors[:topRecentErrors]
+	}
+}
+
+// Must be called with the mutex held
+func (v *topView) workloads() []topWorkload {
+	workloads := make([]topWorkload, 0)
+	for _, node := range v.nodes {
+		for _, machine := range node.machines {
+			workloads = append(workloads, topWorkload{
+				nodeId:  node.id,
+				machine: machine,
+				rate:    node.rates[machine.Id],
+			})
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].machine.Workload.Name != workloads[j].machine.Workload.Name {
+			return workloads[i].machine.Workload.Name < workloads[j].machine.Workload.Name
+		}
+		return workloads[i].machine.Id < workloads[j].machine.Id
+	})
+
+	if v.selected >= len(workloads) {
+		v.selected = len(workloads) - 1
+	}
+	if v.selected < 0 {
+		v.selected = 0
+	}
+	return workloads
+}
+
+func (v *topView) selectedWorkload() (*topWorkload, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	workloads := v.workloads()
+	if len(workloads) == 0 {
+		return nil, false
+	}
+	return &workloads[v.selected], true
+}
+
+func (v *topView) inspectSelected() {
+	workload, ok := v.selectedWorkload()
+	if !ok {
+		return
+	}
+
+	resp, err := v.client.DescribeWorkload(workload.nodeId, workload.machine.Id)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if err != nil {
+		v.status = fmt.Sprintf("Failed to describe workload %s: %s", workload.machine.Id, err)
+		return
+	}
+	v.inspect = resp
+	v.status = ""
+}
+
+func (v *topView) stopSelected() {
+	workload, ok := v.selectedWorkload()
+	if !ok {
+		return
+	}
+
+	status := func() string {
+		if v.signer == nil {
+			return "Stopping workloads requires --issuer or --vault_transit_key"
+		}
+		request, err := controlapi.NewSignedStopRequest(workload.machine.Id, workload.machine.Workload.Name, workload.nodeId, v.signer)
+		if err != nil {
+			return fmt.Sprintf("Failed to create stop request: %s", err)
+		}
+		resp, err := v.client.StopWorkload(request)
+		if err != nil {
+			return fmt.Sprintf("Failed to stop workload %s: %s", workload.machine.Id, err)
+		}
+		if !resp.Stopped {
+			return fmt.Sprintf("Workload %s was not stopped", workload.machine.Id)
+		}
+		return fmt.Sprintf("Stopped workload %s (%s)", workload.machine.Workload.Name, workload.machine.Id)
+	}()
+
+	v.mutex.Lock()
+	v.status = status
+	v.inspect = nil
+	v.mutex.Unlock()
+}
+
+func (v *topView) render() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var out strings.Builder
+	out.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&out, "nex top — namespace %s — %s\n\n", Opts.Namespace, time.Now().Format(time.TimeOnly))
+
+	nodes := make([]*topNode, 0, len(v.nodes))
+	for _, node := range v.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+
+	nodeTable := newTableWriter("Nodes")
+	nodeTable.AddHeaders("ID", "Version", "Uptime", "Warm Pool", "Workloads", "Pending", "Memory (MiB)", "vCPU", "Last Seen")
+	for _, node := range nodes {
+		warm, running, pending, memory, vcpu := "-", "-", "-", "-", "-"
+		if c := node.capacity; c != nil {
+			warm = fmt.Sprintf("%d/%d", c.WarmMachines, c.MachinePoolSize)
+			if c.Standby {
+				warm = "standby"
+			}
+			running = fmt.Sprint(c.RunningWorkloads)
+			pending = fmt.Sprint(c.PendingDeploys)
+			memory = fmt.Sprint(c.AllocatableMemoryMib)
+			vcpu = fmt.Sprint(c.AllocatableVCPU)
+		}
+		nodeTable.AddRow(short(node.id), node.version, node.uptime, warm, running, pending, memory, vcpu,
+			fmt.Sprintf("%s ago", time.Since(node.lastSeen).Truncate(time.Second)))
+	}
+	out.WriteString(nodeTable.Render())
+	out.WriteString("\n")
+
+	workloadTable := newTableWriter("Workloads")
+	workloadTable.AddHeaders("", "Name", "Type", "Machine", "Node", "State", "Healthy", "Uptime", "Triggers/s", "Queued")
+	for i, workload := range v.workloads() {
+		marker := " "
+		if i == v.selected {
+			marker = ">"
+		}
+		rate, queued := "-", "-"
+		if triggers := workload.machine.Triggers; triggers != nil {
+			rate = fmt.Sprintf("%.1f", workload.rate)
+			queued = fmt.Sprint(triggers.NodeQueued + triggers.AgentQueued)
+			if triggers.Saturated {
+				queued += " (saturated)"
+			}
+		}
+		workloadTable.AddRow(marker, workload.machine.Workload.Name, workload.machine.Workload.WorkloadType,
+			short(workload.machine.Id), short(workload.nodeId), workload.machine.State,
+			workload.machine.Healthy, workload.machine.Uptime, rate, queued)
+	}
+	out.WriteString(workloadTable.Render())
+	out.WriteString("\n")
+
+	if v.inspect != nil {
+		out.WriteString(renderTopInspect(v.inspect))
+		out.WriteString("\n")
+	}
+
+	errorTable := newTableWriter("Recent Errors")
+	errorTable.AddHeaders("Time", "Source", "Error")
+	for _, e := range v.errors {
+		errorTable.AddRow(e.at.Format(time.TimeOnly), e.source, e.text)
+	}
+	out.WriteString(errorTable.Render())
+	out.WriteString("\n")
+
+	if v.status != "" {
+		fmt.Fprintf(&out, "%s\n", v.status)
+	}
+	out.WriteString("↑/↓ select · enter inspect · esc close · s stop · q quit\n")
+
+	// The terminal is in raw mode, so lines must return the cursor themselves
+	fmt.Print(strings.ReplaceAll(out.String(), "\n", "\r\n"))
+}
+
+func renderTopInspect(resp *controlapi.DescribeResponse) string {
+	table := newTableWriter(fmt.Sprintf("Workload %s (%s)", resp.Workload.Name, resp.MachineId))
+	table.AddRow("Node", resp.NodeId)
+	table.AddRow("Machine Template", resp.MachineTemplate)
+	table.AddRow("State", resp.State)
+	table.AddRow("Healthy", resp.Healthy)
+	if resp.HealthMessage != "" {
+		table.AddRow("Health Message", resp.HealthMessage)
+	}
+	if resp.IP != "" {
+		table.AddRow("IP", resp.IP)
+	}
+	if len(resp.TriggerSubjects) > 0 {
+		table.AddRow("Trigger Subjects", strings.Join(resp.TriggerSubjects, ", "))
+	}
+	table.AddRow("Retries", resp.RetryCount)
+
+	events := resp.Events
+	if len(events) > topInspectEvents {
+		events = events[len(events)-topInspectEvents:]
+	}
+	for _, event := range events {
+		entry := event.Event
+		if event.Reason != "" {
+			entry = fmt.Sprintf("%s: %s", entry, event.Reason)
+		}
+		table.AddRow(event.Timestamp.Format(time.TimeOnly), entry)
+	}
+
+	return table.Render()
+}
+
+// Shortens a node or machine ID for display
+func short(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
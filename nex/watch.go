@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -23,7 +24,7 @@ func WatchEvents(ctx context.Context, logger *slog.Logger) error {
 		namespaceFilter = Opts.Namespace
 	}
 
-	if strings.Contains(namespaceFilter, "system") {
+	if strings.Contains(namespaceFilter, controlapi.SystemNamespace) {
 		return errors.New("namespace filter cannot contain the token 'system', as that is automatically subscribed")
 	}
 
@@ -48,6 +49,10 @@ func WatchLogs(ctx context.Context, logger *slog.Logger) error {
 	if err != nil {
 		return err
 	}
+	printer, err := newLogPrinter()
+	if err != nil {
+		return err
+	}
 
 	nodeFilter := "*"
 	if len(strings.TrimSpace(WatchOpts.NodeId)) != 0 {
@@ -55,34 +60,42 @@ func WatchLogs(ctx context.Context, logger *slog.Logger) error {
 	}
 	namespaceFilter := "*"
 	if len(strings.TrimSpace(Opts.Namespace)) != 0 {
-		namespaceFilter = "default"
+		namespaceFilter = Opts.Namespace
 	}
-	workloadNameFilter := "*"
+
+	if WatchOpts.Output == "pretty" {
+		fmt.Print("\033[H\033[2J")
+
+		logger.Info("Starting log watcher",
+			slog.String("machine_filter", WatchOpts.WorkloadId),
+			slog.String("namespace_filter", namespaceFilter),
+			slog.String("node_filter", nodeFilter),
+			slog.String("source_filter", WatchOpts.Source),
+			slog.String("workload_filter", WatchOpts.WorkloadName),
+		)
+	}
+
+	workloadFilter := "*"
 	if len(strings.TrimSpace(WatchOpts.WorkloadName)) != 0 {
-		workloadNameFilter = WatchOpts.WorkloadName
+		workloadFilter = WatchOpts.WorkloadName
 	}
-	vmFilter := "*"
+	machineFilter := "*"
 	if len(strings.TrimSpace(WatchOpts.WorkloadId)) != 0 {
-		vmFilter = WatchOpts.WorkloadId
+		machineFilter = WatchOpts.WorkloadId
 	}
 
-	fmt.Print("\033[H\033[2J")
-
-	logger.Info("Starting log watcher",
-		slog.String("machine_filter", vmFilter),
-		slog.String("namespace_filter", namespaceFilter),
-		slog.String("node_filter", nodeFilter),
-		slog.String("workload_filter", workloadNameFilter),
-	)
-
 	apiClient := controlapi.NewApiClient(nc, 1*time.Second, logger)
-	ch, err := apiClient.MonitorLogs(namespaceFilter, nodeFilter, workloadNameFilter, vmFilter, 0)
+	ch, err := apiClient.MonitorLogs(namespaceFilter, nodeFilter, workloadFilter, machineFilter, 0)
 	if err != nil {
 		return err
 	}
 	for {
-		logEntry := <-ch
-		handleLogEntry(logger, logEntry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-ch:
+			printer.print(entry)
+		}
 	}
 }
 
@@ -147,14 +160,121 @@ func handleEventEntry(log *slog.Logger, emittedEvent controlapi.EmittedEvent) {
 	log.LogAttrs(context.Background(), slog.LevelInfo, "Received", attrs...)
 }
 
-func handleLogEntry(log *slog.Logger, entry controlapi.EmittedLog) {
-	log.Log(
-		context.Background(),
-		slog.LevelDebug,
-		entry.Text,
-		slog.String("namespace", entry.Namespace),
-		slog.String("node", entry.NodeId),
-		slog.String("workload", entry.Workload),
-		slog.String("vmid", entry.Workload),
-	)
+func QueryLogs(ctx context.Context, logger *slog.Logger) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	if WatchOpts.WorkloadName == "" || WatchOpts.WorkloadName == "*" {
+		return errors.New("a workload name is required to query logs")
+	}
+
+	now := time.Now()
+	query := &controlapi.LogQuery{
+		Stream:       WatchOpts.LogStream,
+		Domains:      WatchOpts.LogDomains,
+		WorkloadName: WatchOpts.WorkloadName,
+		Since:        now.Add(-WatchOpts.Since),
+		Until:        now.Add(-WatchOpts.Until),
+		Limit:        WatchOpts.Limit,
+	}
+
+	printer, err := newLogPrinter()
+	if err != nil {
+		return err
+	}
+
+	apiClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
+	entries, err := apiClient.QueryLogs(query)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		printer.print(entry)
+	}
+	return nil
+}
+
+// Shows the logs nodes persisted to the log stream since --since, and keeps showing entries as
+// they're persisted until interrupted
+func FollowLogs(ctx context.Context, logger *slog.Logger) error {
+	nc, err := models.GenerateConnectionFromOpts(Opts)
+	if err != nil {
+		return err
+	}
+	printer, err := newLogPrinter()
+	if err != nil {
+		return err
+	}
+
+	query := &controlapi.LogQuery{
+		Stream:  WatchOpts.LogStream,
+		Domains: WatchOpts.LogDomains,
+		Since:   time.Now().Add(-WatchOpts.Since),
+	}
+	if WatchOpts.WorkloadName != "*" {
+		query.WorkloadName = WatchOpts.WorkloadName
+	}
+
+	apiClient := controlapi.NewApiClientWithNamespace(nc, Opts.Timeout, Opts.Namespace, logger)
+	ch, err := apiClient.FollowLogs(query, 256)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-ch:
+			printer.print(entry)
+		}
+	}
+}
+
+// Shows the log entries matching the node, workload, machine, source and level filters in the
+// format chosen by --output
+type logPrinter struct {
+	level slog.Level
+}
+
+func newLogPrinter() (*logPrinter, error) {
+	p := &logPrinter{}
+	err := p.level.UnmarshalText([]byte(WatchOpts.LogLevel))
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *logPrinter) print(entry controlapi.EmittedLog) {
+	if entry.Level < p.level {
+		return
+	}
+	if WatchOpts.NodeId != "*" && entry.NodeId != WatchOpts.NodeId {
+		return
+	}
+	if WatchOpts.WorkloadName != "*" && entry.Workload != WatchOpts.WorkloadName {
+		return
+	}
+	if WatchOpts.WorkloadId != "*" && entry.MachineId != WatchOpts.WorkloadId {
+		return
+	}
+	// entries published by older nodes don't carry their source, so never match this filter
+	if WatchOpts.Source != "*" && entry.Source != WatchOpts.Source {
+		return
+	}
+
+	if WatchOpts.Output == "json" {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		fmt.Println(string(raw))
+		return
+	}
+	source := entry.Source
+	if source == "" {
+		source = "-"
+	}
+	fmt.Printf("%s %s %s %s %-8s %-5s %s\n", entry.Timestamp, entry.NodeId, entry.MachineId, entry.Workload, source, entry.Level, entry.Text)
 }
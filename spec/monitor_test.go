@@ -103,7 +103,7 @@ var _ = Describe("log monitor", func() {
 		raw = RawLog{Text: "hey from test", Level: slog.LevelDebug, MachineId: "vm1234"}
 		bytes, _ := json.Marshal(raw)
 
-		_ = nc.Publish("$NEX.logs.default.Nxxxx.echoservice.vm1234", bytes)
+		_ = nc.Publish("$NEX.logs.default.echoservice.Nxxxx.vm1234", bytes)
 		subject = <-ch
 	})
 
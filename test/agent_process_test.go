@@ -0,0 +1,26 @@
+package test
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+func TestAgentProcessEnvironmentWithholdsNodeSecrets(t *testing.T) {
+	t.Setenv("NEX_NODE_SEED_HANDOFF", "SNAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	env := nexnode.AgentProcessEnv()
+	for _, v := range env {
+		name, _, _ := strings.Cut(v, "=")
+		if name != "PATH" && name != "HOME" {
+			t.Fatalf("Expected agent processes not to inherit %s", name)
+		}
+	}
+	if !slices.Contains(env, "PATH="+os.Getenv("PATH")) {
+		t.Fatal("Expected agent processes to inherit the node's PATH")
+	}
+}
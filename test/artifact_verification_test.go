@@ -0,0 +1,138 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+// A stand-in for Rekor, which records keyless signatures and signs a promise (SET) to include them
+type testRekor struct {
+	key     *ecdsa.PrivateKey
+	keyPath string
+}
+
+func newTestRekor(t *testing.T) *testRekor {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	path := filepath.Join(t.TempDir(), "rekor.pub")
+	_ = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: raw}), 0600)
+
+	return &testRekor{key: key, keyPath: path}
+}
+
+// Records the keyless signature of the blob as integrated at the given time, attaching the bundle
+// `cosign sign-blob --bundle` would have written
+func (r *testRekor) record(t *testing.T, signature *controlapi.ArtifactSignature, blob []byte, integratedAt time.Time) {
+	cert, _ := base64.StdEncoding.DecodeString(*signature.Certificate)
+	digest := sha256.Sum256(blob)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{
+				"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(digest[:])},
+			},
+			"signature": map[string]interface{}{
+				"content":   signature.Signature,
+				"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString(cert)},
+			},
+		},
+	})
+
+	encodedBody := base64.StdEncoding.EncodeToString(body)
+	payload := fmt.Sprintf(`{"body":%q,"integratedTime":%d,"logID":"c0d23d6ad406973f","logIndex":42}`, encodedBody, integratedAt.Unix())
+	payloadDigest := sha256.Sum256([]byte(payload))
+	set, err := ecdsa.SignASN1(rand.Reader, r.key, payloadDigest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign entry timestamp: %s", err)
+	}
+
+	bundle := fmt.Sprintf(`{"base64Signature":%q,"cert":%q,"rekorBundle":{"SignedEntryTimestamp":%q,"Payload":%s}}`,
+		signature.Signature, *signature.Certificate, base64.StdEncoding.EncodeToString(set), payload)
+	signature.Bundle = &bundle
+}
+
+func TestArtifactVerificationRequiresKeylessIdentities(t *testing.T) {
+	fulcio := newTestFulcio(t)
+
+	config := nexnode.DefaultNodeConfiguration()
+	config.ArtifactVerification = &nexnode.ArtifactVerification{FulcioRoots: []string{fulcio.rootPath}}
+	if config.Validate() {
+		t.Fatal("Expected artifact verification trusting fulcio roots without identities and an issuer to be refused")
+	}
+
+	// even if the configuration were not validated, keyless signatures aren't trusted without them
+	binary := []byte("#!/bin/sh\necho nex\n")
+	update := &nexnode.SelfUpdate{Verification: *config.ArtifactVerification}
+	err := nexnode.VerifyUpdateSignature(update, fulcio.sign(t, "anyone@example.com", testOIDCIssuer, binary), binary)
+	if err == nil || !strings.Contains(err.Error(), "require trusted certificate identities") {
+		t.Fatalf("Expected a keyless signature to be rejected without trusted identities, got %v", err)
+	}
+}
+
+func TestKeylessCertificatesVerifiedAsOfTransparencyLogEntry(t *testing.T) {
+	fulcio := newTestFulcio(t)
+	rekor := newTestRekor(t)
+	binary := []byte("#!/bin/sh\necho nex\n")
+
+	config := &nexnode.SelfUpdate{Verification: nexnode.ArtifactVerification{
+		FulcioRoots:           []string{fulcio.rootPath},
+		CertificateIdentities: []string{"release@example.com"},
+		CertificateOIDCIssuer: testOIDCIssuer,
+		TransparencyLogKeys:   []string{rekor.keyPath},
+	}}
+
+	issuedAt := time.Now().Add(-2 * time.Hour)
+
+	expired := fulcio.signAt(t, "release@example.com", testOIDCIssuer, binary, issuedAt)
+	err := nexnode.VerifyUpdateSignature(config, expired, binary)
+	if err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Fatalf("Expected an expired certificate without a log entry to be rejected, got %v", err)
+	}
+
+	logged := fulcio.signAt(t, "release@example.com", testOIDCIssuer, binary, issuedAt)
+	rekor.record(t, logged, binary, issuedAt.Add(time.Minute))
+	err = nexnode.VerifyUpdateSignature(config, logged, binary)
+	if err != nil {
+		t.Fatalf("Expected a certificate valid when its signature was logged to be accepted: %s", err)
+	}
+
+	late := fulcio.signAt(t, "release@example.com", testOIDCIssuer, binary, issuedAt)
+	rekor.record(t, late, binary, issuedAt.Add(time.Hour))
+	err = nexnode.VerifyUpdateSignature(config, late, binary)
+	if err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Fatalf("Expected a signature logged after its certificate expired to be rejected, got %v", err)
+	}
+
+	forged := fulcio.signAt(t, "release@example.com", testOIDCIssuer, binary, issuedAt)
+	newTestRekor(t).record(t, forged, binary, issuedAt.Add(time.Minute))
+	err = nexnode.VerifyUpdateSignature(config, forged, binary)
+	if err == nil || !strings.Contains(err.Error(), "not signed by any trusted log") {
+		t.Fatalf("Expected a log entry signed by an untrusted log to be rejected, got %v", err)
+	}
+
+	other := fulcio.signAt(t, "release@example.com", testOIDCIssuer, binary, issuedAt)
+	rekor.record(t, other, []byte("another binary"), issuedAt.Add(time.Minute))
+	err = nexnode.VerifyUpdateSignature(config, other, binary)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Expected a log entry for another artifact to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	nexclient "github.com/synadia-io/nex/client"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+func startNatsServer(t *testing.T) *nats.Conn {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %s", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not become ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS server: %s", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func respondEnvelope(t *testing.T, m *nats.Msg, responseType string, data interface{}) {
+	raw, err := json.Marshal(controlapi.NewEnvelope(responseType, data, nil))
+	if err != nil {
+		t.Errorf("Failed to marshal response: %s", err)
+		return
+	}
+	_ = m.Respond(raw)
+}
+
+func TestClientRunsWorkloadsWithEncryptedEnvironment(t *testing.T) {
+	nc := startNatsServer(t)
+
+	nodeXKey, _ := nkeys.CreateCurveKeys()
+	nodePublicXKey, _ := nodeXKey.PublicKey()
+
+	_, _ = nc.Subscribe("$NEX.INFO.prod.NODE1", func(m *nats.Msg) {
+		respondEnvelope(t, m, controlapi.InfoResponseType, controlapi.InfoResponse{PublicXKey: nodePublicXKey})
+	})
+
+	deployed := make(chan *controlapi.DeployRequest, 1)
+	_, _ = nc.Subscribe("$NEX.DEPLOY.prod.NODE1", func(m *nats.Msg) {
+		var request controlapi.DeployRequest
+		err := json.Unmarshal(m.Data, &request)
+		if err != nil {
+			t.Errorf("Failed to unmarshal deploy request: %s", err)
+			return
+		}
+		deployed <- &request
+		respondEnvelope(t, m, controlapi.RunResponseType, controlapi.RunResponse{Started: true, Name: "echo", MachineId: "vm1"})
+	})
+
+	issuer, _ := nkeys.CreateAccount()
+	client, err := nexclient.New(nc, nexclient.WithNamespace("prod"), nexclient.WithIssuer(issuer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	run, err := client.RunWorkload("NODE1",
+		nexclient.WorkloadName("echo"),
+		nexclient.WorkloadType("native"),
+		nexclient.Location("nats://BUCKET/echo"),
+		nexclient.Checksum("hash"),
+		nexclient.EnvironmentValue("SECRET", "hunter2"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to run workload: %s", err)
+	}
+	if !run.Started || run.MachineId != "vm1" {
+		t.Fatalf("Unexpected run response: %+v", run)
+	}
+
+	request := <-deployed
+	if request.TargetNode == nil || *request.TargetNode != "NODE1" {
+		t.Fatalf("Expected the request to target NODE1, got %v", request.TargetNode)
+	}
+	_, err = request.Validate()
+	if err != nil {
+		t.Fatalf("Expected the request to be signed by the client's issuer: %s", err)
+	}
+	err = request.DecryptRequestEnvironment(nodeXKey)
+	if err != nil {
+		t.Fatalf("Expected the environment to be encrypted for the node: %s", err)
+	}
+	if request.WorkloadEnvironment["SECRET"] != "hunter2" {
+		t.Fatalf("Unexpected environment: %v", request.WorkloadEnvironment)
+	}
+}
+
+func TestClientStopWorkloadRequiresIssuer(t *testing.T) {
+	nc := startNatsServer(t)
+
+	client, err := nexclient.New(nc)
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	_, err = client.StopWorkload("NODE1", "vm1", "echo")
+	if err == nil {
+		t.Fatal("Expected stopping a workload without an issuer to fail")
+	}
+}
+
+func TestClientStreamsFilteredEvents(t *testing.T) {
+	nc := startNatsServer(t)
+
+	client, err := nexclient.New(nc, nexclient.WithNamespace("prod"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.StreamEvents(ctx, nexclient.EventFilter{Workload: "echo"})
+	if err != nil {
+		t.Fatalf("Failed to stream events: %s", err)
+	}
+
+	publish := func(namespace string, workload string) {
+		event := cloudevents.NewEvent()
+		event.SetType(controlapi.WorkloadLifecycleEventType)
+		event.SetSource("test")
+		event.SetID(workload)
+		_ = event.SetData(map[string]string{"workload_name": workload})
+		raw, _ := json.Marshal(event)
+		_ = nc.Publish("$NEX.events."+namespace+"."+controlapi.WorkloadLifecycleEventType, raw)
+	}
+	publish("prod", "other")
+	publish("dev", "echo")
+	publish("prod", "echo")
+	_ = nc.Flush()
+
+	select {
+	case event := <-events:
+		if event.Namespace != "prod" || event.EventType != controlapi.WorkloadLifecycleEventType || event.ID() != "echo" {
+			t.Fatalf("Unexpected event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+
+	select {
+	case event := <-events:
+		t.Fatalf("Expected the other events to be filtered out, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("Expected the stream to be closed once the context is done")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the stream to close")
+	}
+}
+
+func TestClientStreamsWorkloadLogs(t *testing.T) {
+	nc := startNatsServer(t)
+
+	client, err := nexclient.New(nc, nexclient.WithNamespace("prod"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logs, err := client.StreamLogs(ctx, nexclient.LogFilter{Workload: "echo"})
+	if err != nil {
+		t.Fatalf("Failed to stream logs: %s", err)
+	}
+
+	node, _ := nkeys.CreateServer()
+	nodeId, _ := node.PublicKey()
+	publish := func(namespace string, workload string) {
+		raw, _ := json.Marshal(controlapi.RawLog{Text: workload, MachineId: "vm1"})
+		_ = nc.Publish(controlapi.LogSubject(namespace, workload, nodeId, "vm1"), raw)
+	}
+	publish("prod", "other")
+	publish("dev", "echo")
+	publish("prod", "echo")
+	_ = nc.Flush()
+
+	select {
+	case entry := <-logs:
+		if entry.Namespace != "prod" || entry.Workload != "echo" || entry.NodeId != nodeId || entry.MachineId != "vm1" {
+			t.Fatalf("Unexpected log entry: %+v", entry)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a log entry")
+	}
+
+	select {
+	case entry := <-logs:
+		t.Fatalf("Expected the other log entries to be filtered out, got %+v", entry)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestParseLegacyLogSubjects(t *testing.T) {
+	node, _ := nkeys.CreateServer()
+	nodeId, _ := node.PublicKey()
+
+	for _, subject := range []string{
+		controlapi.LogSubject("prod", "echo", nodeId, "vm1"),
+		"$NEX.logs.prod." + nodeId + ".echo.vm1",
+		"$NEX.logs.prod." + nodeId + ".vm1.echo",
+	} {
+		tokens, ok := controlapi.ParseLogSubject(subject, "vm1")
+		if !ok {
+			t.Fatalf("Failed to parse %s", subject)
+		}
+		expected := controlapi.LogSubjectTokens{Namespace: "prod", Workload: "echo", NodeId: nodeId, MachineId: "vm1"}
+		if *tokens != expected {
+			t.Fatalf("Unexpected tokens of %s: %+v", subject, tokens)
+		}
+	}
+
+	if _, ok := controlapi.ParseLogSubject("$NEX.events.prod.workload_started", "vm1"); ok {
+		t.Fatal("Expected an event subject not to parse as a log subject")
+	}
+}
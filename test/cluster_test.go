@@ -0,0 +1,184 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+// Returns a node key whose public key sorts before (or after) the given node ID, so that it
+// would (or wouldn't) be elected leader alongside it
+func nodeKeyOrdered(t *testing.T, nodeID string, before bool) (nkeys.KeyPair, string) {
+	for {
+		kp, err := nkeys.CreateServer()
+		if err != nil {
+			t.Fatalf("Failed to create node key: %s", err)
+		}
+		pk, _ := kp.PublicKey()
+		if (pk < nodeID) == before {
+			return kp, pk
+		}
+	}
+}
+
+// Writes a cluster signing key, returning its path
+func writeClusterKey(t *testing.T) (nkeys.KeyPair, string) {
+	kp, _ := nkeys.CreateAccount()
+	seed, _ := kp.Seed()
+	path := filepath.Join(t.TempDir(), "cluster.nk")
+	if err := os.WriteFile(path, seed, 0600); err != nil {
+		t.Fatalf("Failed to write cluster signing key: %s", err)
+	}
+	return kp, path
+}
+
+func gossipMsg(cluster string, member controlapi.ClusterMember, clusterKey nkeys.KeyPair, nodeKey nkeys.KeyPair) *nats.Msg {
+	member.AdvertisedAt = time.Now().UTC()
+	raw, _ := json.Marshal(member)
+	clusterSig, _ := clusterKey.Sign(raw)
+	nodeSig, _ := nodeKey.Sign(raw)
+
+	msg := nats.NewMsg("$NEX.cluster." + cluster + ".gossip")
+	msg.Data = raw
+	msg.Header.Set("Nex-Cluster-Signature", base64.StdEncoding.EncodeToString(clusterSig))
+	msg.Header.Set("Nex-Node-Signature", base64.StdEncoding.EncodeToString(nodeSig))
+	return msg
+}
+
+// Joins the given manager to the cluster, returning its node ID
+func joinTestCluster(t *testing.T, manager *nexnode.MachineManager, keyFile string) string {
+	config := nexnode.NewMachineManagerProxyWith(manager).NodeConfiguration()
+	config.Cluster = &nexnode.Cluster{Name: "east", SigningKeyFile: keyFile, HeartbeatIntervalMillis: 50, MemberTimeoutMillis: 300}
+	api := nexnode.NewApiListenerProxyWith(nexnode.NewApiListener(slog.New(slog.NewTextHandler(io.Discard, nil)), manager, config))
+	if err := api.JoinCluster(); err != nil {
+		t.Fatalf("Failed to join cluster: %s", err)
+	}
+	return api.NodeID()
+}
+
+func clusterMemberIDs(t *testing.T, nc *nats.Conn) (string, []string) {
+	client := controlapi.NewApiClient(nc, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	info, err := client.ClusterInfo("east")
+	if err != nil {
+		t.Fatalf("Failed to get cluster info: %s", err)
+	}
+
+	members := make([]string, 0, len(info.Members))
+	for _, member := range info.Members {
+		members = append(members, member.NodeId)
+	}
+	return info.Leader, members
+}
+
+func TestClusterRequiresSigningKey(t *testing.T) {
+	config := nexnode.DefaultNodeConfiguration()
+	config.Cluster = &nexnode.Cluster{Name: "east"}
+	if config.Validate() {
+		t.Fatal("Expected a cluster without a signing key to be refused")
+	}
+
+	config = nexnode.DefaultNodeConfiguration()
+	config.Cluster = &nexnode.Cluster{Name: "east", SigningKeyFile: filepath.Join(t.TempDir(), "missing.nk")}
+	if config.Validate() {
+		t.Fatal("Expected a cluster with a missing signing key file to be refused")
+	}
+}
+
+func TestClusterIgnoresUnauthenticatedGossip(t *testing.T) {
+	nc := startJetStreamServer(t)
+	clusterKey, keyFile := writeClusterKey(t)
+	otherKey, _ := writeClusterKey(t)
+	nodeID := joinTestCluster(t, newMachineManager(t, nc), keyFile)
+
+	peer, peerID := nodeKeyOrdered(t, nodeID, false)
+	intruder, intruderID := nodeKeyOrdered(t, nodeID, true)
+
+	var replayed *nats.Msg
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		// a node without the cluster's signing key and with a lower ID, which would otherwise take
+		// over as leader
+		_ = nc.PublishMsg(gossipMsg("east", controlapi.ClusterMember{NodeId: intruderID}, otherKey, intruder))
+		// a member's ID, signed by the cluster's key but not by the member
+		_ = nc.PublishMsg(gossipMsg("east", controlapi.ClusterMember{NodeId: peerID}, clusterKey, intruder))
+		// unsigned
+		_ = nc.Publish("$NEX.cluster.east.gossip", []byte(`{"node_id":"`+intruderID+`"}`))
+
+		replayed = gossipMsg("east", controlapi.ClusterMember{NodeId: peerID}, clusterKey, peer)
+		_ = nc.PublishMsg(replayed)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	leader, members := clusterMemberIDs(t, nc)
+	if leader != nodeID {
+		t.Fatalf("Expected the node to lead the cluster, got %s", leader)
+	}
+	if slices.Contains(members, intruderID) {
+		t.Fatalf("Expected a node without the cluster's signing key not to be admitted: %v", members)
+	}
+	if !slices.Contains(members, peerID) || !slices.Contains(members, nodeID) {
+		t.Fatalf("Expected both members to be live: %v", members)
+	}
+
+	// the peer's last advertisement is ignored when replayed once the peer has gone
+	time.Sleep(400 * time.Millisecond)
+	_ = nc.PublishMsg(replayed)
+	time.Sleep(50 * time.Millisecond)
+
+	_, members = clusterMemberIDs(t, nc)
+	if slices.Contains(members, peerID) {
+		t.Fatal("Expected replayed gossip of a member which has left not to readmit it")
+	}
+}
+
+func TestClusterReadmitsRestartedNode(t *testing.T) {
+	nc := startJetStreamServer(t)
+	_, keyFile := writeClusterKey(t)
+	nodeID := joinTestCluster(t, newMachineManager(t, nc), keyFile)
+
+	connect := func() *nats.Conn {
+		conn, err := nats.Connect(nc.ConnectedUrl())
+		if err != nil {
+			t.Fatalf("Failed to connect to NATS: %s", err)
+		}
+		return conn
+	}
+
+	before := connect()
+	beforeID := joinTestCluster(t, newMachineManager(t, before), keyFile)
+	time.Sleep(400 * time.Millisecond)
+
+	_, members := clusterMemberIDs(t, nc)
+	if !slices.Contains(members, nodeID) || !slices.Contains(members, beforeID) {
+		t.Fatalf("Expected both nodes to be members: %v", members)
+	}
+
+	// the node goes away, and comes back with a new node key
+	before.Close()
+	after := connect()
+	defer after.Close()
+	afterID := joinTestCluster(t, newMachineManager(t, after), keyFile)
+	if afterID == beforeID {
+		t.Fatal("Expected the restarted node to have a new node ID")
+	}
+	time.Sleep(400 * time.Millisecond)
+
+	_, members = clusterMemberIDs(t, nc)
+	if !slices.Contains(members, afterID) {
+		t.Fatalf("Expected the restarted node to rejoin the cluster: %v", members)
+	}
+	if slices.Contains(members, beforeID) {
+		t.Fatalf("Expected the node's old ID to have timed out: %v", members)
+	}
+}
@@ -0,0 +1,311 @@
+package test
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+)
+
+// Run `go test ./test -run Golden -update` to regenerate the golden files after an
+// intentional (and backwards-compatible!) change to one of the message formats
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+const goldenDir = "testdata/golden"
+
+type goldenMessage struct {
+	name string
+	msg  interface{}
+	new  func() interface{}
+}
+
+func goldenMessages() []goldenMessage {
+	location, _ := url.Parse("nats://MUHBUCKET/muhfile")
+	timestamp := time.Date(2024, time.February, 1, 12, 30, 0, 0, time.UTC)
+
+	essential := false
+	retryCount := uint(1)
+
+	return []goldenMessage{
+		{
+			name: "controlapi_deploy_request",
+			msg: &controlapi.DeployRequest{
+				Argv:            []string{"--verbose"},
+				Description:     agentapi.StringOrNil("testy mctesto"),
+				WorkloadType:    agentapi.StringOrNil("elf"),
+				Location:        location,
+				Essential:       &essential,
+				WorkloadJwt:     agentapi.StringOrNil("eyJ0eXAiOiJKV1QiLCJhbGciOiJlZDI1NTE5LW5rZXkifQ"),
+				Environment:     agentapi.StringOrNil("ZW5jcnlwdGVk"),
+				JsDomain:        agentapi.StringOrNil("hub"),
+				SenderPublicKey: agentapi.StringOrNil("XAL54S5FE6SRPONXRNVE4ZDAOHOT44GFIY2ZW33DHLR2U3H2HJSXXRKY"),
+				TargetNode:      agentapi.StringOrNil("NCOBPU3MCEA7LF6XKTRMTRVRHZXN7B4LLQSSJ4FX3KZMBFNSCFKTZ2JE"),
+				TriggerSubjects: []string{"hello.world"},
+				RetryCount:      &retryCount,
+				RetriedAt:       &timestamp,
+			},
+			new: func() interface{} { return &controlapi.DeployRequest{} },
+		},
+		{
+			name: "controlapi_stop_request",
+			msg: &controlapi.StopRequest{
+				WorkloadId:  "cmv1bjg6f3bk0h7jfpv0",
+				WorkloadJwt: "eyJ0eXAiOiJKV1QiLCJhbGciOiJlZDI1NTE5LW5rZXkifQ",
+				TargetNode:  "NCOBPU3MCEA7LF6XKTRMTRVRHZXN7B4LLQSSJ4FX3KZMBFNSCFKTZ2JE",
+			},
+			new: func() interface{} { return &controlapi.StopRequest{} },
+		},
+		{
+			name: "controlapi_run_response",
+			msg: &controlapi.RunResponse{
+				Started:   true,
+				MachineId: "cmv1bjg6f3bk0h7jfpv0",
+				Issuer:    "ADWUK3WGQ7VLXNPYIJCB2XWM7KGH6QDIQR6G2D2ZI5ZKP4EAEZK2RH6N",
+				Name:      "echoservice",
+			},
+			new: func() interface{} { return &controlapi.RunResponse{} },
+		},
+		{
+			name: "controlapi_stop_response",
+			msg: &controlapi.StopResponse{
+				Stopped:   true,
+				MachineId: "cmv1bjg6f3bk0h7jfpv0",
+				Issuer:    "ADWUK3WGQ7VLXNPYIJCB2XWM7KGH6QDIQR6G2D2ZI5ZKP4EAEZK2RH6N",
+				Name:      "echoservice",
+			},
+			new: func() interface{} { return &controlapi.StopResponse{} },
+		},
+		{
+			name: "controlapi_ping_response",
+			msg: &controlapi.PingResponse{
+				NodeId:          "NCOBPU3MCEA7LF6XKTRMTRVRHZXN7B4LLQSSJ4FX3KZMBFNSCFKTZ2JE",
+				Version:         "0.1.0",
+				Uptime:          "1h2m3s",
+				Tags:            map[string]string{"nex.arch": "amd64", "nex.os": "linux"},
+				RunningMachines: 2,
+			},
+			new: func() interface{} { return &controlapi.PingResponse{} },
+		},
+		{
+			name: "controlapi_info_response",
+			msg: &controlapi.InfoResponse{
+				Version:    "0.1.0",
+				Uptime:     "1h2m3s",
+				PublicXKey: "XAL54S5FE6SRPONXRNVE4ZDAOHOT44GFIY2ZW33DHLR2U3H2HJSXXRKY",
+				Tags:       map[string]string{"nex.arch": "amd64"},
+				Memory:     &controlapi.MemoryStat{MemTotal: 1024, MemFree: 512, MemAvailable: 768},
+				Machines: []controlapi.MachineSummary{{
+					Id:      "cmv1bjg6f3bk0h7jfpv0",
+					Healthy: true,
+					Uptime:  "1h2m3s",
+					Workload: controlapi.WorkloadSummary{
+						Name:         "echoservice",
+						Description:  "testy mctesto",
+						Runtime:      "1h2m",
+						WorkloadType: "elf",
+						Hash:         "hashbrowns",
+					},
+				}},
+				SupportedWorkloadTypes: []string{"elf", "v8", "wasm"},
+			},
+			new: func() interface{} { return &controlapi.InfoResponse{} },
+		},
+		{
+			name: "controlapi_emitted_log",
+			msg: &controlapi.EmittedLog{
+				Namespace: "default",
+				NodeId:    "NCOBPU3MCEA7LF6XKTRMTRVRHZXN7B4LLQSSJ4FX3KZMBFNSCFKTZ2JE",
+				Workload:  "echoservice",
+				Timestamp: timestamp.Format(time.RFC3339),
+				RawLog: controlapi.RawLog{
+					Text:      "hello",
+					Level:     0,
+					MachineId: "cmv1bjg6f3bk0h7jfpv0",
+				},
+			},
+			new: func() interface{} { return &controlapi.EmittedLog{} },
+		},
+		{
+			name: "controlapi_raw_log",
+			msg: &controlapi.RawLog{
+				Time:         &timestamp,
+				Level:        slog.LevelWarn,
+				Source:       controlapi.LogSourceWorkload,
+				Namespace:    "default",
+				WorkloadName: "echoservice",
+				MachineId:    "cmv1bjg6f3bk0h7jfpv0",
+				Text:         "hello",
+			},
+			new: func() interface{} { return &controlapi.RawLog{} },
+		},
+		{
+			name: "agentapi_deploy_request",
+			msg: &agentapi.DeployRequest{
+				Argv:            []string{"--verbose"},
+				Description:     agentapi.StringOrNil("testy mctesto"),
+				Environment:     map[string]string{"NATS_URL": "nats://127.0.0.1:4222"},
+				Essential:       &essential,
+				Hash:            "hashbrowns",
+				Namespace:       agentapi.StringOrNil("default"),
+				RetriedAt:       &timestamp,
+				RetryCount:      &retryCount,
+				TotalBytes:      1024,
+				TriggerSubjects: []string{"hello.world"},
+				WorkloadName:    agentapi.StringOrNil("echoservice"),
+				WorkloadType:    agentapi.StringOrNil("elf"),
+			},
+			new: func() interface{} { return &agentapi.DeployRequest{} },
+		},
+		{
+			name: "agentapi_deploy_response",
+			msg: &agentapi.DeployResponse{
+				Accepted: true,
+				Message:  agentapi.StringOrNil("Workload deployed"),
+			},
+			new: func() interface{} { return &agentapi.DeployResponse{} },
+		},
+		{
+			name: "agentapi_handshake_request",
+			msg: &agentapi.HandshakeRequest{
+				MachineID: agentapi.StringOrNil("cmv1bjg6f3bk0h7jfpv0"),
+				StartTime: timestamp,
+				Message:   agentapi.StringOrNil("Host-supplied metadata"),
+			},
+			new: func() interface{} { return &agentapi.HandshakeRequest{} },
+		},
+		{
+			name: "agentapi_machine_metadata",
+			msg: &agentapi.MachineMetadata{
+				VmID:         agentapi.StringOrNil("cmv1bjg6f3bk0h7jfpv0"),
+				NodeNatsHost: agentapi.StringOrNil("192.168.127.1"),
+				NodeNatsPort: &[]int{9222}[0],
+				Message:      agentapi.StringOrNil("Host-supplied metadata"),
+			},
+			new: func() interface{} { return &agentapi.MachineMetadata{} },
+		},
+		{
+			name: "agentapi_log_entry",
+			msg: &agentapi.LogEntry{
+				Source: "nex-agent",
+				Level:  agentapi.LogLevelInfo,
+				Text:   "Agent is up",
+			},
+			new: func() interface{} { return &agentapi.LogEntry{} },
+		},
+	}
+}
+
+// Ensures the serialized form of each message matches its golden file
+func TestGoldenFilesSerialization(t *testing.T) {
+	for _, gm := range goldenMessages() {
+		path := filepath.Join(goldenDir, gm.name+".json")
+
+		actual, err := json.MarshalIndent(gm.msg, "", "  ")
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %s", gm.name, err)
+		}
+
+		if *updateGolden {
+			err = os.WriteFile(path, append(actual, '\n'), 0644)
+			if err != nil {
+				t.Fatalf("%s: failed to update golden file: %s", gm.name, err)
+			}
+			continue
+		}
+
+		expected, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: failed to read golden file: %s", gm.name, err)
+		}
+
+		if !jsonEqual(t, expected, actual) {
+			t.Fatalf("%s: serialized message no longer matches golden file; if this change is intentional "+
+				"and backwards-compatible, regenerate with -update\nexpected: %s\nactual: %s", gm.name, expected, actual)
+		}
+	}
+}
+
+// Ensures messages serialized by older versions (i.e., the golden files) can still be
+// deserialized, and that nothing is lost when they're serialized again
+func TestGoldenFilesDeserialization(t *testing.T) {
+	for _, gm := range goldenMessages() {
+		expected, err := os.ReadFile(filepath.Join(goldenDir, gm.name+".json"))
+		if err != nil {
+			t.Fatalf("%s: failed to read golden file: %s", gm.name, err)
+		}
+
+		msg := gm.new()
+		err = json.Unmarshal(expected, msg)
+		if err != nil {
+			t.Fatalf("%s: failed to deserialize golden file: %s", gm.name, err)
+		}
+
+		actual, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %s", gm.name, err)
+		}
+
+		if !jsonEqual(t, expected, actual) {
+			t.Fatalf("%s: round trip of golden file is lossy\nexpected: %s\nactual: %s", gm.name, expected, actual)
+		}
+	}
+}
+
+func TestUnknownFieldPreservation(t *testing.T) {
+	for _, name := range []string{"controlapi_deploy_request", "controlapi_stop_request", "agentapi_deploy_request"} {
+		raw, err := os.ReadFile(filepath.Join(goldenDir, name+".json"))
+		if err != nil {
+			t.Fatalf("%s: failed to read golden file: %s", name, err)
+		}
+
+		var fields map[string]interface{}
+		_ = json.Unmarshal(raw, &fields)
+		fields["x_from_the_future"] = map[string]interface{}{"answer": float64(42)}
+		future, _ := json.Marshal(fields)
+
+		var msg interface{}
+		switch name {
+		case "controlapi_deploy_request":
+			msg = &controlapi.DeployRequest{}
+		case "controlapi_stop_request":
+			msg = &controlapi.StopRequest{}
+		case "agentapi_deploy_request":
+			msg = &agentapi.DeployRequest{}
+		}
+
+		err = json.Unmarshal(future, msg)
+		if err != nil {
+			t.Fatalf("%s: failed to deserialize message with unknown field: %s", name, err)
+		}
+
+		actual, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %s", name, err)
+		}
+
+		if !jsonEqual(t, future, actual) {
+			t.Fatalf("%s: unknown field was not preserved\nexpected: %s\nactual: %s", name, future, actual)
+		}
+	}
+}
+
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	var av, bv interface{}
+
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatalf("invalid json: %s", err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatalf("invalid json: %s", err)
+	}
+
+	return reflect.DeepEqual(av, bv)
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	. "github.com/synadia-io/nex/internal/control-api"
+)
+
+func TestIssuerChainValidation(t *testing.T) {
+	myKey, _ := nkeys.CreateCurveKeys()
+	recipientKey, _ := nkeys.CreateCurveKeys()
+	recipientPk, _ := recipientKey.PublicKey()
+
+	rootAccount, _ := nkeys.CreateAccount()
+	rootPk, _ := rootAccount.PublicKey()
+	teamAccount, _ := nkeys.CreateAccount()
+	teamPk, _ := teamAccount.PublicKey()
+	ciAccount, _ := nkeys.CreateAccount()
+	ciPk, _ := ciAccount.PublicKey()
+
+	teamDelegation, _ := CreateDelegationJwt(teamPk, []string{"team"}, time.Hour, rootAccount)
+	ciDelegation, _ := CreateDelegationJwt(ciPk, nil, 0, teamAccount)
+
+	newRequest := func(issuer nkeys.KeyPair, chain ...string) *DeployRequest {
+		request, _ := NewDeployRequest(
+			WorkloadName("testworkload"),
+			WorkloadType("v8"),
+			Checksum("hashbrowns"),
+			SenderXKey(myKey),
+			Issuer(issuer),
+			IssuerChain(chain...),
+			Location("nats://MUHBUCKET/muhfile"),
+			TargetPublicXKey(recipientPk),
+		)
+		if _, err := request.Validate(); err != nil {
+			t.Fatalf("Failed to validate request that should've passed: %s", err)
+		}
+		return request
+	}
+
+	root, err := newRequest(ciAccount, teamDelegation, ciDelegation).ValidateIssuerChain("team")
+	if err != nil {
+		t.Fatalf("Expected issuer chain to validate, got %s", err)
+	}
+	if root != rootPk {
+		t.Fatalf("Expected root issuer %s, got %s", rootPk, root)
+	}
+
+	root, err = newRequest(rootAccount).ValidateIssuerChain("team")
+	if err != nil || root != rootPk {
+		t.Fatalf("Expected request without issuer chain to resolve to its own issuer, got %s (%v)", root, err)
+	}
+
+	if _, err := newRequest(ciAccount, teamDelegation, ciDelegation).ValidateIssuerChain("other"); err == nil {
+		t.Fatal("Expected namespace restriction of delegation to be enforced")
+	}
+
+	if _, err := newRequest(ciAccount, ciDelegation, teamDelegation).ValidateIssuerChain("team"); err == nil {
+		t.Fatal("Expected out-of-order issuer chain to fail validation")
+	}
+
+	// a valid chain that stops short of the root resolves to the intermediate key
+	root, err = newRequest(ciAccount, ciDelegation).ValidateIssuerChain("team")
+	if err != nil || root != teamPk {
+		t.Fatalf("Expected partial chain to resolve to %s, got %s (%v)", teamPk, root, err)
+	}
+
+	expiredClaims := jwt.NewGenericClaims(teamPk)
+	expiredClaims.Data["type"] = DelegationClaimType
+	expiredClaims.Expires = time.Now().Add(-time.Hour).Unix()
+	expired, _ := expiredClaims.Encode(rootAccount)
+	if _, err := newRequest(teamAccount, expired).ValidateIssuerChain("team"); err == nil {
+		t.Fatal("Expected expired delegation to fail validation")
+	}
+}
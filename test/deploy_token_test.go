@@ -0,0 +1,120 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	. "github.com/synadia-io/nex/internal/control-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+func TestDeployTokenClaims(t *testing.T) {
+	myKey, _ := nkeys.CreateCurveKeys()
+	recipientKey, _ := nkeys.CreateCurveKeys()
+	recipientPk, _ := recipientKey.PublicKey()
+
+	operator, _ := nkeys.CreateAccount()
+	operatorPk, _ := operator.PublicKey()
+
+	if _, err := CreateDeployToken("testworkload", "ABCDEF", "default", 0, operator); err == nil {
+		t.Fatal("Expected deploy token without an expiry to be rejected")
+	}
+
+	token, err := CreateDeployToken("testworkload", "ABCDEF", "default", time.Minute, operator)
+	if err != nil {
+		t.Fatalf("Failed to create deploy token: %s", err)
+	}
+
+	request, _ := NewDeployRequest(
+		DeployToken(token),
+		WorkloadType("v8"),
+		SenderXKey(myKey),
+		Location("nats://MUHBUCKET/muhfile"),
+		TargetPublicXKey(recipientPk),
+	)
+
+	if _, err := request.Validate(); err != nil {
+		t.Fatalf("Failed to validate request that should've passed: %s", err)
+	}
+
+	claims, err := request.DeployTokenClaims()
+	if err != nil || claims == nil {
+		t.Fatalf("Expected deploy token claims, got %v (%v)", claims, err)
+	}
+	if claims.WorkloadName != "testworkload" || claims.Digest != "abcdef" || claims.Namespace != "default" || claims.Issuer != operatorPk {
+		t.Fatalf("Unexpected deploy token claims: %+v", claims)
+	}
+
+	other, _ := CreateDeployToken("testworkload", "ABCDEF", "default", time.Minute, operator)
+	request.WorkloadJwt = &other
+	_, _ = request.Validate()
+	otherClaims, _ := request.DeployTokenClaims()
+	if otherClaims.ID == claims.ID {
+		t.Fatal("Expected identical deploy tokens to have distinct IDs")
+	}
+
+	plain, _ := NewDeployRequest(
+		WorkloadName("testworkload"),
+		WorkloadType("v8"),
+		Checksum("hashbrowns"),
+		SenderXKey(myKey),
+		Issuer(operator),
+		Location("nats://MUHBUCKET/muhfile"),
+		TargetPublicXKey(recipientPk),
+	)
+	_, _ = plain.Validate()
+	if claims, err := plain.DeployTokenClaims(); claims != nil || err != nil {
+		t.Fatal("Expected workload JWT not to be treated as a deploy token")
+	}
+}
+
+func TestDeployTokensRedeemedOnceAcrossNodes(t *testing.T) {
+	nc := startJetStreamServer(t)
+	nodeA := nexnode.NewMachineManagerProxyWith(newMachineManager(t, nc))
+	nodeB := nexnode.NewMachineManagerProxyWith(newMachineManager(t, nc))
+
+	senderKey, _ := nkeys.CreateCurveKeys()
+	recipientKey, _ := nkeys.CreateCurveKeys()
+	recipientPk, _ := recipientKey.PublicKey()
+	operator, _ := nkeys.CreateAccount()
+
+	deployRequest := func(token string) *DeployRequest {
+		request, _ := NewDeployRequest(
+			DeployToken(token),
+			WorkloadType("v8"),
+			SenderXKey(senderKey),
+			Location("nats://MUHBUCKET/muhfile"),
+			TargetPublicXKey(recipientPk),
+		)
+		if _, err := request.Validate(); err != nil {
+			t.Fatalf("Failed to validate deploy request: %s", err)
+		}
+		return request
+	}
+
+	token, _ := CreateDeployToken("testworkload", "ABCDEF", "default", time.Minute, operator)
+
+	if err := nodeA.RedeemDeployToken(deployRequest(token), "other"); err == nil {
+		t.Fatal("Expected a deploy token to be rejected outside its namespace")
+	}
+	if err := nodeA.RedeemDeployToken(deployRequest(token), "default"); err != nil {
+		t.Fatalf("Expected an unused deploy token to be redeemed: %s", err)
+	}
+	if err := nodeA.RedeemDeployToken(deployRequest(token), "default"); err == nil {
+		t.Fatal("Expected a deploy token to be redeemed only once by the same node")
+	}
+	if err := nodeB.RedeemDeployToken(deployRequest(token), "default"); err == nil {
+		t.Fatal("Expected a deploy token redeemed by one node to be rejected by another")
+	}
+
+	restarted := nexnode.NewMachineManagerProxyWith(newMachineManager(t, nc))
+	if err := restarted.RedeemDeployToken(deployRequest(token), "default"); err == nil {
+		t.Fatal("Expected a deploy token to remain redeemed after a node restarts")
+	}
+
+	other, _ := CreateDeployToken("testworkload", "ABCDEF", "default", time.Minute, operator)
+	if err := nodeB.RedeemDeployToken(deployRequest(other), "default"); err != nil {
+		t.Fatalf("Expected another deploy token to be redeemed: %s", err)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+// Starts a machine manager connected to a JetStream-enabled NATS server, which serves as both
+// its control and internal connection
+func startMachineManager(t *testing.T, configure ...func(*nexnode.NodeConfiguration)) (*nexnode.MachineManager, *nats.Conn) {
+	nc := startJetStreamServer(t)
+	return newMachineManager(t, nc, configure...), nc
+}
+
+// Starts a NATS server with JetStream enabled, returning a connection to it
+func startJetStreamServer(t *testing.T) *nats.Conn {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %s", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not become ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS server: %s", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// Creates a machine manager for a node of its own, connected to NATS with the given connection
+func newMachineManager(t *testing.T, nc *nats.Conn, configure ...func(*nexnode.NodeConfiguration)) *nexnode.MachineManager {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := nexnode.DefaultNodeConfiguration()
+	config.NoSandbox = true
+	for _, c := range configure {
+		c(&config)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	telemetry, err := nexnode.NewTelemetry(ctx, log, &config, "NODE")
+	if err != nil {
+		t.Fatalf("Failed to initialize telemetry: %s", err)
+	}
+
+	kp, _ := nkeys.CreateServer()
+	pk, _ := kp.PublicKey()
+	manager, err := nexnode.NewMachineManager(ctx, cancel, kp, pk, nc, nc, nil, &config, log, telemetry)
+	if err != nil {
+		t.Fatalf("Failed to create machine manager: %s", err)
+	}
+	return manager
+}
+
+func TestHostServicesRejectOtherWorkloads(t *testing.T) {
+	manager, nc := startMachineManager(t)
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+	proxy.TrackDeployedVM("vma", "acme", "billing")
+	proxy.TrackDeployedVM("vmb", "globex", "payroll")
+
+	rpc := func(subject string, key string, value string) map[string]interface{} {
+		req := &agentapi.HostServicesKeyValueRequest{Key: &key}
+		if value != "" {
+			raw := json.RawMessage(value)
+			req.Value = &raw
+		}
+		data, _ := json.Marshal(req)
+
+		resp, err := nc.Request(subject, data, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Failed to request %s: %s", subject, err)
+		}
+		var result map[string]interface{}
+		_ = json.Unmarshal(resp.Data, &result)
+		return result
+	}
+
+	// agentint.{vmID}.rpc.{namespace}.{workload}.{service}.{method}
+	result := rpc("agentint.vmb.rpc.globex.payroll.kv.set", "salary", `"secret"`)
+	if result["success"] != true {
+		t.Fatalf("Expected VM B to write its own bucket, got %v", result)
+	}
+
+	for _, subject := range []string{
+		"agentint.vma.rpc.globex.payroll.kv.get",
+		"agentint.vma.rpc.globex.billing.kv.get",
+		"agentint.vma.rpc.acme.payroll.kv.get",
+	} {
+		result = rpc(subject, "salary", "")
+		reason, _ := result["error"].(string)
+		if !strings.Contains(reason, "do not match") {
+			t.Fatalf("Expected VM A's request on %s to be refused, got %v", subject, result)
+		}
+	}
+
+	result = rpc("agentint.vma.rpc.acme.billing.kv.get", "salary", "")
+	if _, ok := result["value"]; ok {
+		t.Fatalf("Expected VM A's own bucket not to hold VM B's key, got %v", result)
+	}
+}
+
+func TestAgentsReadOnlyTheirOwnArtifact(t *testing.T) {
+	manager, nc := startMachineManager(t)
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+	proxy.TrackDeployedVM("vma", "acme", "billing")
+	proxy.TrackDeployedVM("vmb", "globex", "payroll")
+	proxy.TrackVM("vmc")
+
+	js, _ := nc.JetStream()
+	cache, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: agentapi.WorkloadCacheBucket})
+	if err != nil {
+		t.Fatalf("Failed to create workload cache: %s", err)
+	}
+	billing := bytes.Repeat([]byte("billing!"), 40*1024)
+	_, _ = cache.PutBytes("billing", billing)
+	_, _ = cache.PutBytes("payroll", []byte("payroll"))
+
+	read := func(vmID string, offset int64) *nats.Msg {
+		req, _ := json.Marshal(&agentapi.ArtifactChunkRequest{Offset: offset})
+		resp, err := nc.Request(agentapi.ArtifactSubject(vmID), req, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Failed to request artifact: %s", err)
+		}
+		return resp
+	}
+
+	var received []byte
+	for {
+		resp := read("vma", int64(len(received)))
+		if reason := resp.Header.Get(agentapi.ArtifactErrorHeader); reason != "" {
+			t.Fatalf("Expected VM A to read its artifact, got %s", reason)
+		}
+		if resp.Header.Get(agentapi.ArtifactSizeHeader) != fmt.Sprintf("%d", len(billing)) {
+			t.Fatalf("Expected the artifact's size, got %s", resp.Header.Get(agentapi.ArtifactSizeHeader))
+		}
+		received = append(received, resp.Data...)
+		if len(resp.Data) < agentapi.ArtifactChunkSize {
+			break
+		}
+	}
+	if !bytes.Equal(received, billing) {
+		t.Fatalf("Expected VM A to read the billing artifact, got %d bytes", len(received))
+	}
+
+	// only the offset the transfer has reached may be read next
+	_ = read("vma", 0)
+	if read("vma", 42).Header.Get(agentapi.ArtifactErrorHeader) == "" {
+		t.Fatal("Expected an unexpected offset to be refused")
+	}
+
+	if resp := read("vmc", 0); resp.Header.Get(agentapi.ArtifactErrorHeader) == "" || len(resp.Data) > 0 {
+		t.Fatal("Expected a machine without a workload to be refused")
+	}
+	if resp := read("vmb", 0); string(resp.Data) != "payroll" {
+		t.Fatalf("Expected VM B to read only its own artifact, got %q", resp.Data)
+	}
+}
@@ -0,0 +1,209 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+// Run with `go test -race ./test -run MachineManager` to have the race detector check the
+// machine manager's bookkeeping as machines come and go while handshakes arrive
+func TestMachineManagerConcurrentBookkeeping(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %s", err)
+	}
+	ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not become ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS server: %s", err)
+	}
+	defer nc.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := nexnode.DefaultNodeConfiguration()
+	config.NoSandbox = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	telemetry, err := nexnode.NewTelemetry(ctx, log, &config, "NODE")
+	if err != nil {
+		t.Fatalf("Failed to initialize telemetry: %s", err)
+	}
+
+	kp, _ := nkeys.CreateServer()
+	pk, _ := kp.PublicKey()
+	manager, err := nexnode.NewMachineManager(ctx, cancel, kp, pk, nc, nc, nil, &config, log, telemetry)
+	if err != nil {
+		t.Fatalf("Failed to create machine manager: %s", err)
+	}
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+
+	const machines = 50
+	var wg sync.WaitGroup
+
+	for i := 0; i < machines; i++ {
+		vmID := fmt.Sprintf("vm%d", i)
+
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			proxy.TrackVM(vmID)
+
+			message := "Host-supplied metadata"
+			handshake, _ := json.Marshal(&agentapi.HandshakeRequest{MachineID: &vmID, Message: &message})
+			_, _ = nc.Request("agentint.handshake", handshake, time.Second)
+
+			_ = manager.LookupMachine(vmID)
+			proxy.ForgetVM(vmID)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = proxy.VMs()
+			_ = proxy.HandshakeCompleted(vmID)
+		}()
+		go func() {
+			defer wg.Done()
+			// a machine which was never started can't be stopped
+			_ = manager.StopMachine(fmt.Sprintf("unknown-%s", vmID), false)
+		}()
+	}
+	wg.Wait()
+
+	if len(proxy.VMs()) != 0 {
+		t.Fatalf("Expected every tracked machine to have been forgotten, %d remain", len(proxy.VMs()))
+	}
+}
+
+// Run with `go test -race ./test -run MachineManager` to have the race detector check the
+// bookkeeping of functions scaled to zero as they're claimed, deployed and stopped alongside
+// machines
+func TestMachineManagerIdleFunctionBookkeeping(t *testing.T) {
+	manager, _ := startMachineManager(t)
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+
+	const functions = 50
+	var wg sync.WaitGroup
+
+	for i := 0; i < functions; i++ {
+		id := fmt.Sprintf("fn%d", i)
+		vmID := fmt.Sprintf("vm%d", i)
+		subject := fmt.Sprintf("orders.%d", i)
+
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			proxy.TrackIdleFunction(id, "default", id, []string{subject})
+			_ = proxy.TriggerSubjectsClaimed("default", "other", []string{subject})
+			proxy.StopIdleFunction(id)
+		}()
+		go func() {
+			defer wg.Done()
+			proxy.TrackDeployedVM(vmID, "default", vmID)
+			_ = proxy.DeployedWorkloadIDs()
+			proxy.ForgetVM(vmID)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = proxy.TriggerSubjectsClaimed("default", "other", []string{subject})
+			_ = proxy.DeployedWorkloadIDs()
+			_ = proxy.VMs()
+		}()
+		go func() {
+			defer wg.Done()
+			proxy.StopIdleFunction(id)
+			_ = manager.StopMachine(vmID, false)
+		}()
+	}
+	wg.Wait()
+
+	if ids := proxy.DeployedWorkloadIDs(); len(ids) != 0 {
+		t.Fatalf("Expected every function and machine to have been stopped, %v remain", ids)
+	}
+	if proxy.TriggerSubjectsClaimed("default", "other", []string{"orders.0"}) {
+		t.Fatal("Expected the trigger subjects of stopped functions to be released")
+	}
+}
+
+func TestMachineManagerStopsMachineWhenDeployAckTimesOut(t *testing.T) {
+	manager, _ := startMachineManager(t)
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+	proxy.TrackReadyVM("vma")
+
+	namespace := "acme"
+	workload := "billing"
+	workloadType := agentapi.NexExecutionProviderV8
+	ackTimeout := 200
+	err := proxy.SubmitDeployment("vma", &agentapi.DeployRequest{
+		AckTimeoutMillis: &ackTimeout,
+		Namespace:        &namespace,
+		WorkloadName:     &workload,
+		WorkloadType:     &workloadType,
+	})
+	if err == nil {
+		t.Fatal("Expected a deployment no agent acknowledges to fail")
+	}
+
+	if _, ok := proxy.VMs()["vma"]; ok {
+		t.Fatal("Expected the machine of a deployment which was never acknowledged to be stopped")
+	}
+	for _, id := range proxy.DeployedWorkloadIDs() {
+		if id == "vma" {
+			t.Fatal("Expected the machine of a failed deployment not to be left deploying")
+		}
+	}
+}
+
+// Run with `go test -race ./test -run MachineManager` to have the race detector check that health
+// check results arriving from agents are recorded safely while INFO summarizes the machines
+func TestMachineManagerHealthCheckBookkeeping(t *testing.T) {
+	manager, _ := startMachineManager(t)
+	proxy := nexnode.NewMachineManagerProxyWith(manager)
+
+	const machines = 20
+	var wg sync.WaitGroup
+
+	for i := 0; i < machines; i++ {
+		vmID := fmt.Sprintf("vm%d", i)
+		proxy.TrackDeployedVM(vmID, "default", vmID)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				message := fmt.Sprintf("check %d", j)
+				proxy.ReportHealth(vmID, agentapi.HealthCheckResult{Healthy: j%2 == 0, Message: &message, CheckedAt: time.Now().UTC()})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_ = proxy.SummarizeMachines("default")
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, machine := range proxy.SummarizeMachines("default") {
+		if machine.LastHealthCheck == nil || machine.HealthMessage != "check 19" || machine.Healthy {
+			t.Fatalf("Expected machine %s to report its last health check, got %+v", machine.Id, machine)
+		}
+	}
+}
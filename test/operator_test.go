@@ -0,0 +1,172 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	nexclient "github.com/synadia-io/nex/client"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	nexoperator "github.com/synadia-io/nex/internal/operator"
+)
+
+type kubeRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// Records the requests made of a fake Kubernetes API, answering each with an empty object
+func startFakeKube(t *testing.T) (*nexoperator.KubeClient, func() []kubeRequest) {
+	var mutex sync.Mutex
+	var requests []kubeRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		_ = json.Unmarshal(raw, &body)
+
+		mutex.Lock()
+		requests = append(requests, kubeRequest{method: r.Method, path: r.URL.Path, body: body})
+		mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+
+	kube, err := nexoperator.NewKubeClient(server.URL, "token", "")
+	if err != nil {
+		t.Fatalf("Failed to create kubernetes client: %s", err)
+	}
+	return kube, func() []kubeRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]kubeRequest{}, requests...)
+	}
+}
+
+func findKubeRequest(requests []kubeRequest, method string, path string) *kubeRequest {
+	for i := len(requests) - 1; i >= 0; i-- {
+		if requests[i].method == method && requests[i].path == path {
+			return &requests[i]
+		}
+	}
+	return nil
+}
+
+func TestOperatorRunsAndStopsNexWorkloads(t *testing.T) {
+	nc := startNatsServer(t)
+	kube, kubeRequests := startFakeKube(t)
+
+	nodeXKey, _ := nkeys.CreateCurveKeys()
+	nodePublicXKey, _ := nodeXKey.PublicKey()
+
+	_, _ = nc.Subscribe("$NEX.INFO.prod.NODE1", func(m *nats.Msg) {
+		respondEnvelope(t, m, controlapi.InfoResponseType, controlapi.InfoResponse{PublicXKey: nodePublicXKey})
+	})
+
+	deployed := make(chan *controlapi.DeployRequest, 1)
+	_, _ = nc.Subscribe("$NEX.DEPLOY.prod.NODE1", func(m *nats.Msg) {
+		var request controlapi.DeployRequest
+		_ = json.Unmarshal(m.Data, &request)
+		deployed <- &request
+		respondEnvelope(t, m, controlapi.RunResponseType, controlapi.RunResponse{Started: true, Name: "echo", MachineId: "vm1"})
+	})
+
+	stopped := make(chan *controlapi.StopRequest, 1)
+	_, _ = nc.Subscribe("$NEX.STOP.prod.NODE1", func(m *nats.Msg) {
+		var request controlapi.StopRequest
+		_ = json.Unmarshal(m.Data, &request)
+		stopped <- &request
+		respondEnvelope(t, m, controlapi.StopResponseType, controlapi.StopResponse{Stopped: true, MachineId: "vm1", Name: "echo"})
+	})
+
+	issuer, _ := nkeys.CreateAccount()
+	op := nexoperator.NewOperator(kube, nc, nexoperator.OperatorOptions{
+		ClientOptions: []nexclient.Option{nexclient.WithIssuer(issuer)},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	w := &nexoperator.NexWorkload{
+		APIVersion: nexoperator.APIVersion,
+		Kind:       nexoperator.Kind,
+		Metadata:   nexoperator.ObjectMeta{Name: "echo", Namespace: "prod", Generation: 1},
+		Spec: nexoperator.NexWorkloadSpec{
+			Type:       "native",
+			Location:   "nats://BUCKET/echo",
+			Digest:     "hash",
+			TargetNode: "NODE1",
+		},
+	}
+
+	err := op.Reconcile(context.Background(), w)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %s", err)
+	}
+
+	select {
+	case request := <-deployed:
+		claims, err := request.Validate()
+		if err != nil || claims.Subject != "echo" {
+			t.Fatalf("Expected a workload named echo signed by the issuer: %v", err)
+		}
+		if request.Labels["nex.k8s.name"] != "echo" || request.Labels["nex.k8s.namespace"] != "prod" {
+			t.Fatalf("Expected the workload to be labeled with its resource, got %v", request.Labels)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the workload to be deployed")
+	}
+
+	path := "/apis/nex.synadia.io/v1alpha1/namespaces/prod/nexworkloads/echo"
+	finalizers := findKubeRequest(kubeRequests(), http.MethodPatch, path)
+	if finalizers == nil {
+		t.Fatal("Expected the finalizer to be added")
+	}
+	status := findKubeRequest(kubeRequests(), http.MethodPatch, path+"/status")
+	if status == nil {
+		t.Fatal("Expected the status to be updated")
+	}
+	fields, _ := status.body["status"].(map[string]interface{})
+	if fields["machineId"] != "vm1" || fields["nodeId"] != "NODE1" || fields["state"] != controlapi.WorkloadStateDeploying {
+		t.Fatalf("Unexpected status: %v", fields)
+	}
+	if findKubeRequest(kubeRequests(), http.MethodPost, "/api/v1/namespaces/prod/events") == nil {
+		t.Fatal("Expected an event to be recorded")
+	}
+
+	// deleting the resource stops its workload and removes the finalizer
+	now := time.Now()
+	w.Metadata.DeletionTimestamp = &now
+	w.Metadata.Finalizers = []string{nexoperator.Finalizer}
+	w.Status.NodeId = "NODE1"
+	w.Status.MachineId = "vm1"
+
+	err = op.Reconcile(context.Background(), w)
+	if err != nil {
+		t.Fatalf("Failed to reconcile deletion: %s", err)
+	}
+
+	select {
+	case request := <-stopped:
+		if request.WorkloadId != "vm1" {
+			t.Fatalf("Unexpected stop request: %+v", request)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the workload to be stopped")
+	}
+
+	finalizers = findKubeRequest(kubeRequests(), http.MethodPatch, path)
+	metadata, _ := finalizers.body["metadata"].(map[string]interface{})
+	remaining, _ := metadata["finalizers"].([]interface{})
+	if len(remaining) != 0 {
+		t.Fatalf("Expected the finalizer to be removed, got %v", remaining)
+	}
+}
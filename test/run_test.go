@@ -1,9 +1,13 @@
 package test
 
 import (
+	"encoding/json"
+	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
 	. "github.com/synadia-io/nex/internal/control-api"
 )
 
@@ -42,3 +46,113 @@ func TestEncryption(t *testing.T) {
 	}
 
 }
+
+func TestSealedEnvironment(t *testing.T) {
+	myKey, _ := nkeys.CreateCurveKeys()
+
+	recipientKey, _ := nkeys.CreateCurveKeys()
+	recipientPk, _ := recipientKey.PublicKey()
+
+	issuerAccount, _ := nkeys.CreateAccount()
+
+	sealed, err := SealEnvironmentValue(myKey, recipientPk, "hunter2")
+	if err != nil {
+		t.Fatalf("Failed to seal environment value: %s", err)
+	}
+
+	request, _ := NewDeployRequest(
+		WorkloadName("testworkload"),
+		Checksum("hashbrowns"),
+		EnvironmentValue("NATS_URL", "nats://127.0.0.1:4222"),
+		SealedEnvironment(map[string]string{"DB_PASSWORD": sealed}),
+		SenderXKey(myKey),
+		Issuer(issuerAccount),
+		Location("nats://MUHBUCKET/muhfile"),
+		TargetPublicXKey(recipientPk),
+	)
+
+	if request.SealedEnvironment["DB_PASSWORD"] == "hunter2" {
+		t.Fatalf("Expected sealed environment value not to be sent in plaintext")
+	}
+
+	err = request.DecryptRequestEnvironment(recipientKey)
+	if err != nil {
+		t.Fatalf("Did not decrypt request environment: %s", err)
+	}
+	if request.WorkloadEnvironment["DB_PASSWORD"] != "hunter2" {
+		t.Fatalf("Expected sealed value to be opened, found %s", request.WorkloadEnvironment["DB_PASSWORD"])
+	}
+	if request.WorkloadEnvironment["NATS_URL"] != "nats://127.0.0.1:4222" {
+		t.Fatalf("Expected plain environment value to be preserved, found %s", request.WorkloadEnvironment["NATS_URL"])
+	}
+
+	otherKey, _ := nkeys.CreateCurveKeys()
+	err = request.DecryptRequestEnvironment(otherKey)
+	if err == nil {
+		t.Fatalf("Expected decryption with the wrong key to fail")
+	}
+}
+
+func TestLogLimits(t *testing.T) {
+	var unlimited *agentapi.LogLimits
+	if unlimited.MinLevel() != agentapi.LogLevelInfo {
+		t.Fatalf("Expected info to be the default level, got %d", unlimited.MinLevel())
+	}
+	if rate, burst := unlimited.Rate(); rate != agentapi.DefaultLogLinesPerSecond || burst != agentapi.DefaultLogBurst {
+		t.Fatalf("Expected the default rate, got %d (burst %d)", rate, burst)
+	}
+
+	level := "WARN"
+	limits := &agentapi.LogLimits{Level: &level, LinesPerSecond: 2000}
+	if err := limits.Validate(); err != nil {
+		t.Fatalf("Expected limits to be valid: %s", err)
+	}
+	if limits.MinLevel() != agentapi.LogLevelWarn {
+		t.Fatalf("Expected warn level, got %d", limits.MinLevel())
+	}
+	if rate, burst := limits.Rate(); rate != 2000 || burst != 2000 {
+		t.Fatalf("Expected the burst to default to the rate, got %d (burst %d)", rate, burst)
+	}
+
+	level = "verbose"
+	if err := limits.Validate(); err == nil {
+		t.Fatal("Expected an unsupported level to be rejected")
+	}
+	if err := (&agentapi.LogLimits{LinesPerSecond: -1}).Validate(); err == nil {
+		t.Fatal("Expected a negative rate to be rejected")
+	}
+}
+
+func TestLogRecords(t *testing.T) {
+	levels := map[agentapi.LogLevel]slog.Level{
+		agentapi.LogLevelFatal: slog.LevelError,
+		agentapi.LogLevelError: slog.LevelError,
+		agentapi.LogLevelWarn:  slog.LevelWarn,
+		agentapi.LogLevelInfo:  slog.LevelInfo,
+		agentapi.LogLevelDebug: slog.LevelDebug,
+	}
+	for level, expected := range levels {
+		if level.SlogLevel() != expected {
+			t.Fatalf("Expected agent level %d to be published as %s, got %s", level, expected, level.SlogLevel())
+		}
+	}
+	if trace := agentapi.LogLevel(agentapi.LogLevelTrace).SlogLevel(); trace >= slog.LevelDebug {
+		t.Fatalf("Expected trace to be less severe than debug, got %s", trace)
+	}
+
+	// records published by older nodes carry no time, so are timed by their receipt
+	var legacy RawLog
+	if err := json.Unmarshal([]byte(`{"text":"hello","level":"INFO","machine_id":"cmv1bjg6f3bk0h7jfpv0"}`), &legacy); err != nil {
+		t.Fatalf("Expected the record to be decoded: %s", err)
+	}
+	received := time.Now()
+	if !legacy.LoggedAt(received).Equal(received) || legacy.Source != "" {
+		t.Fatalf("Expected a record without a time or source, got %+v", legacy)
+	}
+
+	logged := received.Add(-time.Minute)
+	record := RawLog{Time: &logged, Level: slog.LevelInfo, Source: LogSourceHost}
+	if !record.LoggedAt(received).Equal(logged) {
+		t.Fatalf("Expected the record's own time, got %s", record.LoggedAt(received))
+	}
+}
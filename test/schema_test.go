@@ -0,0 +1,78 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	"github.com/synadia-io/nex/internal/schema"
+)
+
+// Ensures messages serialized by older versions (i.e., the golden files) match their schemas
+func TestGoldenFilesMatchSchemas(t *testing.T) {
+	for _, gm := range goldenMessages() {
+		raw, err := os.ReadFile(filepath.Join(goldenDir, gm.name+".json"))
+		if err != nil {
+			t.Fatalf("%s: failed to read golden file: %s", gm.name, err)
+		}
+
+		err = schema.For(gm.new()).Validate(raw)
+		if err != nil {
+			t.Fatalf("%s: golden file doesn't match its schema: %s", gm.name, err)
+		}
+	}
+}
+
+func TestSchemaValidationListsOffendingFields(t *testing.T) {
+	raw := []byte(`{
+		"type": 42,
+		"vcpu_count": "two",
+		"memsize_mib": 1.5,
+		"retry_count": -1,
+		"retried_at": "yesterday",
+		"stdin": "not base64!",
+		"trigger_subjects": ["hello.world", 7],
+		"health_check": {"interval_ms": "1s"},
+		"labels": {"tier": true},
+		"some_future_field": {"anything": "goes"}
+	}`)
+
+	var request controlapi.DeployRequest
+	err := schema.Unmarshal(raw, &request)
+
+	var invalid *schema.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	expected := []schema.FieldError{
+		{Field: "health_check.interval_ms", Message: "expected integer, got string"},
+		{Field: "labels.tier", Message: "expected string, got boolean"},
+		{Field: "memsize_mib", Message: "expected an integer, got 1.5"},
+		{Field: "retried_at", Message: "expected an RFC 3339 timestamp"},
+		{Field: "retry_count", Message: "expected an integer of at least 0, got -1"},
+		{Field: "stdin", Message: "expected base64 encoded bytes"},
+		{Field: "trigger_subjects[1]", Message: "expected string, got integer"},
+		{Field: "type", Message: "expected string, got integer"},
+		{Field: "vcpu_count", Message: "expected integer, got string"},
+	}
+	if !reflect.DeepEqual(invalid.Fields, expected) {
+		t.Fatalf("unexpected validation errors\nexpected: %v\nactual: %v", expected, invalid.Fields)
+	}
+}
+
+func TestSchemaValidationAcceptsNullOptionalFields(t *testing.T) {
+	raw := []byte(`{"type": "elf", "description": null, "argv": null, "labels": null}`)
+
+	var request controlapi.DeployRequest
+	err := schema.Unmarshal(raw, &request)
+	if err != nil {
+		t.Fatalf("expected optional fields to accept null, got %s", err)
+	}
+	if request.WorkloadType == nil || *request.WorkloadType != "elf" {
+		t.Fatalf("expected the request to be unmarshaled, got %v", request.WorkloadType)
+	}
+}
@@ -0,0 +1,146 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+const testOIDCIssuer = "https://token.actions.githubusercontent.com"
+
+// A stand-in for Fulcio: a root which issues short-lived code signing certificates to identities,
+// recording the OIDC issuer of each as Fulcio does
+type testFulcio struct {
+	root     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	rootPath string
+}
+
+func newTestFulcio(t *testing.T) *testFulcio {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sigstore"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create root: %s", err)
+	}
+	root, _ := x509.ParseCertificate(raw)
+
+	path := filepath.Join(t.TempDir(), "fulcio.crt.pem")
+	_ = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0600)
+
+	return &testFulcio{root: root, key: key, rootPath: path}
+}
+
+// Signs the blob keyless as the given identity, returning its signature
+func (f *testFulcio) sign(t *testing.T, identity string, issuer string, blob []byte) *controlapi.ArtifactSignature {
+	return f.signAt(t, identity, issuer, blob, time.Now().Add(-time.Minute))
+}
+
+// Signs the blob keyless as the given identity with a certificate issued at the given time, which
+// is valid for ten minutes
+func (f *testFulcio) signAt(t *testing.T, identity string, issuer string, blob []byte, issuedAt time.Time) *controlapi.ArtifactSignature {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuerExt, _ := asn1.Marshal(issuer)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		NotBefore:      issuedAt,
+		NotAfter:       issuedAt.Add(10 * time.Minute),
+		EmailAddresses: []string{identity},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}, Value: issuerExt},
+		},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, f.root, &key.PublicKey, f.key)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %s", err)
+	}
+
+	digest := sha256.Sum256(blob)
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	cert := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}))
+
+	return &controlapi.ArtifactSignature{
+		Signature:   base64.StdEncoding.EncodeToString(sig),
+		Certificate: &cert,
+	}
+}
+
+func TestSelfUpdateRequiresKeylessIdentities(t *testing.T) {
+	fulcio := newTestFulcio(t)
+
+	for _, verification := range []nexnode.ArtifactVerification{
+		{FulcioRoots: []string{fulcio.rootPath}},
+		{FulcioRoots: []string{fulcio.rootPath}, CertificateIdentities: []string{"release@example.com"}},
+		{FulcioRoots: []string{fulcio.rootPath}, CertificateOIDCIssuer: testOIDCIssuer},
+	} {
+		config := nexnode.DefaultNodeConfiguration()
+		config.SelfUpdate = &nexnode.SelfUpdate{Verification: verification}
+		if config.Validate() {
+			t.Fatalf("Expected self update trusting fulcio roots without identities and an issuer to be refused: %+v", verification)
+		}
+	}
+
+	config := nexnode.DefaultNodeConfiguration()
+	config.SelfUpdate = &nexnode.SelfUpdate{Verification: nexnode.ArtifactVerification{
+		FulcioRoots:           []string{fulcio.rootPath},
+		CertificateIdentities: []string{"release@example.com"},
+		CertificateOIDCIssuer: testOIDCIssuer,
+	}}
+	config.Validate()
+	for _, err := range config.Errors {
+		if strings.Contains(err.Error(), "self update") {
+			t.Fatalf("Expected self update with identities and an issuer to be accepted: %s", err)
+		}
+	}
+}
+
+func TestSelfUpdateRejectsUntrustedKeylessSigners(t *testing.T) {
+	fulcio := newTestFulcio(t)
+	binary := []byte("#!/bin/sh\necho nex\n")
+
+	config := &nexnode.SelfUpdate{Verification: nexnode.ArtifactVerification{
+		FulcioRoots:           []string{fulcio.rootPath},
+		CertificateIdentities: []string{"release@example.com"},
+		CertificateOIDCIssuer: testOIDCIssuer,
+	}}
+
+	err := nexnode.VerifyUpdateSignature(config, fulcio.sign(t, "mallory@example.com", testOIDCIssuer, binary), binary)
+	if err == nil || !strings.Contains(err.Error(), "identity") {
+		t.Fatalf("Expected a binary signed by an identity not on the list to be rejected, got %v", err)
+	}
+
+	err = nexnode.VerifyUpdateSignature(config, fulcio.sign(t, "release@example.com", "https://accounts.example.com", binary), binary)
+	if err == nil || !strings.Contains(err.Error(), "OIDC issuer") {
+		t.Fatalf("Expected a binary signed through another OIDC issuer to be rejected, got %v", err)
+	}
+
+	err = nexnode.VerifyUpdateSignature(config, fulcio.sign(t, "release@example.com", testOIDCIssuer, binary), binary)
+	if err != nil {
+		t.Fatalf("Expected a binary signed by a trusted identity to be accepted: %s", err)
+	}
+}
@@ -59,3 +59,30 @@ func TestStopValidation(t *testing.T) {
 		t.Fatalf("Expected to get an error validating bad subject, but got none")
 	}
 }
+
+func TestBulkStopSelector(t *testing.T) {
+	issuerAccount, _ := nkeys.CreateAccount()
+	issuerPk, _ := issuerAccount.PublicKey()
+
+	request, _ := NewBulkStopRequest(map[string]string{"app": "echo"}, "Nx", issuerAccount)
+
+	claims, err := request.Validate()
+	if err != nil {
+		t.Fatalf("Expected no errors during positive path validation, got %s", err)
+	}
+	if claims.Issuer != issuerPk {
+		t.Fatalf("Expected bulk stop claims to be issued by %s, got %s", issuerPk, claims.Issuer)
+	}
+
+	if !request.Matches(map[string]string{"app": "echo", "version": "2"}) {
+		t.Fatalf("Expected selector to match labels containing it")
+	}
+	if request.Matches(map[string]string{"app": "other"}) || request.Matches(nil) {
+		t.Fatalf("Expected selector not to match labels without it")
+	}
+
+	everything, _ := NewBulkStopRequest(nil, "Nx", issuerAccount)
+	if !everything.Matches(nil) {
+		t.Fatalf("Expected an empty selector to match all workloads")
+	}
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	agentapi "github.com/synadia-io/nex/internal/agent-api"
+	controlapi "github.com/synadia-io/nex/internal/control-api"
+	nexnode "github.com/synadia-io/nex/internal/node"
+)
+
+// Returns a machine manager which mints workload credentials signed by the returned account key
+func startCredentialsMachineManager(t *testing.T, maxTTLSeconds int) (*nexnode.MachineManagerProxy, nkeys.KeyPair) {
+	account, _ := nkeys.CreateAccount()
+	seed, _ := account.Seed()
+	keyFile := filepath.Join(t.TempDir(), "workloads.sk.nk")
+	if err := os.WriteFile(keyFile, seed, 0600); err != nil {
+		t.Fatalf("Failed to write signing key: %s", err)
+	}
+
+	manager, _ := startMachineManager(t, func(config *nexnode.NodeConfiguration) {
+		config.WorkloadCredentials = &nexnode.WorkloadCredentials{
+			SigningKeyFile: keyFile,
+			MaxTTLSeconds:  maxTTLSeconds,
+			PublishAllow:   []string{"orders.>"},
+			SubscribeAllow: []string{"orders.*.created"},
+		}
+	})
+	return nexnode.NewMachineManagerProxyWith(manager), account
+}
+
+// Starts a NATS server in operator mode, trusting the given account, and returns its URL
+func startOperatorServer(t *testing.T, account nkeys.KeyPair) string {
+	operator, _ := nkeys.CreateOperator()
+	operatorPub, _ := operator.PublicKey()
+	operatorJwt, err := jwt.NewOperatorClaims(operatorPub).Encode(operator)
+	if err != nil {
+		t.Fatalf("Failed to sign operator JWT: %s", err)
+	}
+	operatorClaims, _ := jwt.DecodeOperatorClaims(operatorJwt)
+
+	accountPub, _ := account.PublicKey()
+	accountJwt, err := jwt.NewAccountClaims(accountPub).Encode(operator)
+	if err != nil {
+		t.Fatalf("Failed to sign account JWT: %s", err)
+	}
+
+	resolver := &server.MemAccResolver{}
+	_ = resolver.Store(accountPub, accountJwt)
+
+	ns, err := server.NewServer(&server.Options{
+		Host:             "127.0.0.1",
+		Port:             -1,
+		TrustedOperators: []*jwt.OperatorClaims{operatorClaims},
+		AccountResolver:  resolver,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %s", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not become ready")
+	}
+	return ns.ClientURL()
+}
+
+func decodeWorkloadUser(t *testing.T, creds string) *jwt.UserClaims {
+	token, err := jwt.ParseDecoratedJWT([]byte(creds))
+	if err != nil {
+		t.Fatalf("Failed to parse minted creds: %s", err)
+	}
+	claims, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		t.Fatalf("Failed to decode minted user JWT: %s", err)
+	}
+	return claims
+}
+
+func TestWorkloadCredentialsDefaultMaxTTL(t *testing.T) {
+	proxy, _ := startCredentialsMachineManager(t, 0)
+
+	for _, requested := range []int{0, 86400} {
+		creds, err := proxy.MintWorkloadCredentials(&controlapi.CredentialsRequest{TTLSeconds: requested}, "billing", "acme")
+		if err != nil {
+			t.Fatalf("Failed to mint credentials: %s", err)
+		}
+		if creds.TTLSeconds != 3600 {
+			t.Fatalf("Expected a requested TTL of %d to be capped at an hour, got %d", requested, creds.TTLSeconds)
+		}
+
+		claims := decodeWorkloadUser(t, creds.Creds)
+		expires := time.Unix(claims.Expires, 0)
+		if claims.Expires == 0 || time.Until(expires) > time.Hour {
+			t.Fatalf("Expected minted credentials to expire within an hour, got %s", expires)
+		}
+	}
+}
+
+func TestWorkloadCredentialsIntersectNodePolicy(t *testing.T) {
+	proxy, _ := startCredentialsMachineManager(t, 60)
+
+	creds, err := proxy.MintWorkloadCredentials(&controlapi.CredentialsRequest{
+		PublishAllow:   []string{"orders.eu.created", "orders.>", "$JS.API.>", ">"},
+		SubscribeAllow: []string{"orders.eu.created", "orders.*.created", "orders.>", "$SYS.>"},
+		TTLSeconds:     600,
+	}, "billing", "acme")
+	if err != nil {
+		t.Fatalf("Failed to mint credentials: %s", err)
+	}
+
+	if !reflect.DeepEqual(creds.PublishAllow, []string{"orders.eu.created", "orders.>"}) {
+		t.Fatalf("Unexpected publish permissions granted: %v", creds.PublishAllow)
+	}
+	if !reflect.DeepEqual(creds.SubscribeAllow, []string{"orders.eu.created", "orders.*.created"}) {
+		t.Fatalf("Unexpected subscribe permissions granted: %v", creds.SubscribeAllow)
+	}
+	if creds.TTLSeconds != 60 {
+		t.Fatalf("Expected the TTL to be capped at the configured maximum, got %d", creds.TTLSeconds)
+	}
+
+	claims := decodeWorkloadUser(t, creds.Creds)
+	for _, subject := range []string{"$JS.API.>", ">"} {
+		if claims.Pub.Allow.Contains(subject) {
+			t.Fatalf("Expected %q not to be publishable", subject)
+		}
+	}
+	for _, subject := range []string{"orders.>", "$SYS.>"} {
+		if claims.Sub.Allow.Contains(subject) {
+			t.Fatalf("Expected %q not to be subscribable", subject)
+		}
+	}
+	if !claims.Sub.Allow.Contains(creds.InboxPrefix + ".>") {
+		t.Fatal("Expected the workload's own inboxes to remain subscribable")
+	}
+	if claims.Sub.Allow.Contains("_INBOX.>") {
+		t.Fatal("Expected every inbox not to be subscribable")
+	}
+}
+
+func TestWorkloadCredentialsConfineInboxes(t *testing.T) {
+	proxy, account := startCredentialsMachineManager(t, 60)
+	url := startOperatorServer(t, account)
+
+	mint := func(workload string) *agentapi.Credentials {
+		creds, err := proxy.MintWorkloadCredentials(&controlapi.CredentialsRequest{}, workload, "acme")
+		if err != nil {
+			t.Fatalf("Failed to mint credentials: %s", err)
+		}
+		if creds.InboxPrefix != agentapi.WorkloadInboxPrefix(creds.User) {
+			t.Fatalf("Expected the inbox prefix to be the user's own, got %s", creds.InboxPrefix)
+		}
+		return creds
+	}
+	billing := mint("billing")
+	payroll := mint("payroll")
+
+	connect := func(creds *agentapi.Credentials) (*nats.Conn, chan error) {
+		errs := make(chan error, 8)
+		path := filepath.Join(t.TempDir(), "workload.creds")
+		_ = os.WriteFile(path, []byte(creds.Creds), 0600)
+
+		nc, err := nats.Connect(url,
+			nats.UserCredentials(path),
+			nats.CustomInboxPrefix(creds.InboxPrefix),
+			nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { errs <- err }),
+		)
+		if err != nil {
+			t.Fatalf("Failed to connect with minted credentials: %s", err)
+		}
+		t.Cleanup(nc.Close)
+		return nc, errs
+	}
+	nc, errs := connect(billing)
+
+	// the workload's own inboxes work
+	inbox := nc.NewRespInbox()
+	sub, _ := nc.SubscribeSync(inbox)
+	_ = nc.Flush()
+	select {
+	case err := <-errs:
+		t.Fatalf("Expected the workload to subscribe to its own inbox: %s", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	_ = sub.Unsubscribe()
+
+	for _, subject := range []string{payroll.InboxPrefix + ".>", "_INBOX.>"} {
+		_, _ = nc.SubscribeSync(subject)
+		_ = nc.Flush()
+		select {
+		case err := <-errs:
+			if !strings.Contains(strings.ToLower(err.Error()), "permissions violation") {
+				t.Fatalf("Expected a permissions violation subscribing to %s, got %s", subject, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected subscribing to %s to be refused", subject)
+		}
+	}
+}